@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// Function to check that a request carries the admin API key
+func (cfg *apiConfig) authorizeAdmin(r *http.Request) error {
+
+	// Admin endpoints are disabled entirely unless a key is configured
+	if cfg.adminAPIKey == "" {
+		return errors.New("admin API is disabled")
+	}
+
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) != 1 {
+		return errors.New("invalid admin API key")
+	}
+	return nil
+}
@@ -0,0 +1,798 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Request and response bodies of handlers that declare their own types,
+// mirrored here so they can be documented
+type (
+	credentialsDoc struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	loginResponseDoc struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	tokenResponseDoc struct {
+		Token string `json:"token"`
+	}
+	exportParamsDoc struct {
+		IncludeMediaURLs bool `json:"include_media_urls"`
+	}
+	exportResponseDoc struct {
+		database.Export
+		URL string `json:"url,omitempty"`
+	}
+	usageResponseDoc struct {
+		TotalBytes int64          `json:"total_bytes"`
+		Videos     []videoUsage   `json:"videos"`
+		Months     []monthlyUsage `json:"months"`
+	}
+	notificationTestResponseDoc struct {
+		Deliveries []notify.Delivery `json:"deliveries"`
+	}
+	chaptersParamsDoc struct {
+		Chapters []struct {
+			StartSeconds float64 `json:"start_seconds"`
+			Title        string  `json:"title"`
+		} `json:"chapters"`
+		Embed bool `json:"embed"`
+	}
+	commentParamsDoc struct {
+		Body     string        `json:"body"`
+		ParentID uuid.NullUUID `json:"parent_id"`
+	}
+	videoUpdateParamsDoc struct {
+		Title       string              `json:"title"`
+		Description string              `json:"description"`
+		ExternalID  string              `json:"external_id"`
+		Visibility  database.Visibility `json:"visibility"`
+		Tags        []string            `json:"tags"`
+	}
+	takeoutImportResponseDoc struct {
+		Created  int            `json:"created"`
+		Existing int            `json:"existing"`
+		Tickets  []uploadTicket `json:"tickets"`
+	}
+	bulkOperationParamsDoc struct {
+		Operation  database.BulkOperationKind `json:"operation"`
+		VideoIDs   []uuid.UUID                `json:"video_ids"`
+		Visibility database.Visibility        `json:"visibility,omitempty"`
+		Tag        string                     `json:"tag,omitempty"`
+	}
+	videoCreateParamsDoc struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		ExternalID  string `json:"external_id"`
+	}
+	commentUpdateParamsDoc struct {
+		Body string `json:"body"`
+	}
+	embedParamsDoc struct {
+		AllowedOrigins []string `json:"allowed_origins"`
+	}
+	apiKeyParamsDoc struct {
+		Name string `json:"name"`
+	}
+	shareParamsDoc struct {
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+		MaxViews         *int   `json:"max_views"`
+		Password         string `json:"password"`
+	}
+	shareResolveDoc struct {
+		Title        string     `json:"title"`
+		Description  string     `json:"description"`
+		ThumbnailURL *string    `json:"thumbnail_url"`
+		Duration     *float64   `json:"duration"`
+		URL          string     `json:"url"`
+		URLExpiresAt time.Time  `json:"url_expires_at"`
+		ExpiresAt    time.Time  `json:"expires_at"`
+		Views        int        `json:"views"`
+		MaxViews     *int       `json:"max_views"`
+		LastViewedAt *time.Time `json:"last_viewed_at"`
+	}
+	frameParamsDoc struct {
+		Key string `json:"key"`
+	}
+	clipParamsDoc struct {
+		StartSeconds     float64 `json:"start_seconds"`
+		EndSeconds       float64 `json:"end_seconds"`
+		Materialize      bool    `json:"materialize"`
+		ExpiresInSeconds int     `json:"expires_in_seconds"`
+	}
+	uploadSessionParamsDoc struct {
+		Size       *int64 `json:"size"`
+		MediaType  string `json:"media_type"`
+		Profile    string `json:"profile"`
+		Notify     string `json:"notify"`
+		Priority   string `json:"priority"`
+		Filename   string `json:"filename"`
+		ExternalID string `json:"external_id"`
+	}
+	transcoderCallbackDoc struct {
+		JobID      string `json:"job_id"`
+		ExternalID string `json:"external_id"`
+		Status     string `json:"status"`
+		Error      string `json:"error"`
+		Outputs    []struct {
+			Key string `json:"key"`
+		} `json:"outputs"`
+	}
+	oEmbedDoc struct {
+		Version      string `json:"version"`
+		Type         string `json:"type"`
+		ProviderName string `json:"provider_name"`
+		ProviderURL  string `json:"provider_url"`
+		Title        string `json:"title"`
+		ThumbnailURL string `json:"thumbnail_url,omitempty"`
+		HTML         string `json:"html"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+	}
+	fixityResponseDoc struct {
+		Checker  *processing.FixityStats `json:"checker"`
+		ByStatus map[string]int          `json:"by_status"`
+		Checks   []database.FixityCheck  `json:"checks"`
+	}
+	fixityRunResponseDoc struct {
+		Checked  int                    `json:"checked"`
+		Problems int                    `json:"problems"`
+		Checks   []database.FixityCheck `json:"checks"`
+	}
+	videoComplianceDoc struct {
+		VideoID   uuid.UUID     `json:"video_id"`
+		Encrypted bool          `json:"encrypted"`
+		Compliant bool          `json:"compliant"`
+		Objects   []objectAudit `json:"objects"`
+	}
+	modeParamsDoc struct {
+		Mode   string `json:"mode"`
+		Reason string `json:"reason"`
+	}
+	videoArchiveParamsDoc struct {
+		StorageClass string `json:"storage_class"`
+	}
+	videoRestoreParamsDoc struct {
+		Tier string `json:"tier"`
+		Days int    `json:"days"`
+	}
+	userTenantParamsDoc struct {
+		TenantID uuid.NullUUID `json:"tenant_id"`
+	}
+	userPlanParamsDoc struct {
+		Plan string `json:"plan"`
+	}
+	metadataValueParamsDoc struct {
+		Value string `json:"value"`
+	}
+)
+
+// Query parameters of the usage reports, which default to the last twelve months
+var usageRangeParams = []apiParam{
+	{Name: "from", Description: "First month to report, as YYYY-MM"},
+	{Name: "to", Description: "Last month to report, as YYYY-MM"},
+}
+
+// Filters of lists of the user's videos, parsed by parseVideoFilter
+var videoFilterParams = []apiParam{{
+	Name:        "metadata",
+	Description: "Only videos whose metadata has this key, or with key:value this value. Repeat it to match several.",
+}, {
+	Name:        "tag",
+	Description: "Only videos with this tag. Repeat it for videos with all of several.",
+}, {
+	Name:        "visibility",
+	Description: "Only videos with this visibility: public, unlisted or private",
+}, {
+	Name:        "state",
+	Description: "Only videos in this state: draft, uploading, processing, ready, failed, archived or quarantined",
+}}
+
+// Query parameter of destructive operations, which return 200 with a
+// removalPlan instead of their usual response on a dry run
+var dryRunParam = apiParam{
+	Name:        "dryRun",
+	Description: "Only report what would be removed, defaulting to DRY_RUN_DEFAULT",
+	Type:        "boolean",
+}
+
+// videoIfMatchHeader makes a change to a video conditional on its ETag
+var videoIfMatchHeader = apiParam{
+	Name:        "If-Match",
+	Description: "ETag from reading the video. The update fails with 412 if the video has changed since.",
+	Optional:    true,
+}
+
+// fieldsParam lets video endpoints send only some of each video's fields
+var fieldsParam = apiParam{
+	Name:        "fields",
+	Description: "Comma-separated JSON fields to send, like id,title,thumbnail_url, every field when left out",
+}
+
+// Fields of the upload forms
+var (
+	profileField = apiUploadField{
+		Name:        "profile",
+		Description: "Processing profile from GET /api/v2/profiles, your default when left out. It must come before the video.",
+	}
+	notifyField = apiUploadField{
+		Name:        "notify",
+		Description: "Notification channel for the result: all, email, slack, discord or none, your default when left out. It must come before the video.",
+	}
+	priorityField = apiUploadField{
+		Name:        "priority",
+		Description: "Processing priority: interactive, normal or bulk, interactive when left out. Send bulk for backfills so they don't hold up other uploads. It must come before the video.",
+	}
+	externalIDField = apiUploadField{
+		Name:        "external_id",
+		Description: "ID the video has in another system, for GET /api/v2/videos/by-external-id. It must be unique among your videos.",
+	}
+	thumbnailField = apiUploadField{Name: "thumbnail", Description: "JPEG or PNG image", File: true}
+	videoField     = apiUploadField{Name: "video", Description: "MP4 video", File: true, Required: true}
+)
+
+// apiOperations documents every route the apiRouter registers, keyed by the
+// pattern it's registered with
+var apiOperations = map[string]apiOperation{
+	"GET /api/openapi.json": {Summary: "This OpenAPI document", Tag: "meta"},
+
+	"POST /api/login": {
+		Summary: "Log in, getting an access token and a refresh token", Tag: "auth",
+		Request: credentialsDoc{}, Response: loginResponseDoc{},
+	},
+	"POST /api/refresh": {
+		Summary: "Get a new access token", Tag: "auth", Auth: authRefresh,
+		Response: tokenResponseDoc{},
+	},
+	"POST /api/revoke": {
+		Summary: "Revoke a refresh token", Tag: "auth", Auth: authRefresh,
+		Status: 204,
+	},
+
+	"POST /api/users": {
+		Summary: "Sign up", Tag: "users",
+		Request: credentialsDoc{}, Status: 201, Response: database.User{},
+	},
+	"DELETE /api/users/me": {
+		Summary: "Delete your account and everything stored for it", Tag: "users", Auth: authBearer,
+		Query:  []apiParam{dryRunParam},
+		Status: 202,
+	},
+	"PUT /api/users/me/avatar": {
+		Summary: "Upload your avatar", Tag: "users", Auth: authBearer,
+		Upload: &apiUpload{Limit: thumbnailUploadLimit, Fields: []apiUploadField{
+			{Name: "avatar", Description: "JPEG or PNG image", File: true, Required: true},
+		}},
+		Response: database.User{},
+	},
+	"DELETE /api/users/me/avatar": {Summary: "Remove your avatar", Tag: "users", Auth: authBearer, Status: 204},
+	"POST /api/users/me/export": {
+		Summary: "Start an export of your data", Tag: "users", Auth: authBearer,
+		Request: exportParamsDoc{}, Status: 202, Response: database.Export{},
+	},
+	"GET /api/exports/{exportID}": {
+		Summary: "Check on an export, with its download URL once it's ready", Tag: "users", Auth: authBearer,
+		Response: exportResponseDoc{},
+	},
+	"POST /api/imports/youtube-takeout": {
+		Summary: "Create a video for each one in a YouTube Takeout metadata archive, with a ticket saying where to upload each file. Videos imported before aren't created again, and the response is 200 when every video was.", Tag: "videos", Auth: authBearer,
+		Upload: &apiUpload{Limit: maxTakeoutArchiveSize, Fields: []apiUploadField{
+			{Name: "archive", Description: "Zip archive from Takeout with the channel's video metadata CSVs", File: true, Required: true},
+		}},
+		Status: 201, Response: takeoutImportResponseDoc{},
+	},
+	"GET /api/users/me/usage": {
+		Summary: "Storage you're using, by video and by month", Tag: "users", Auth: authBearer,
+		Query: usageRangeParams, Response: usageResponseDoc{},
+	},
+	"GET /api/users/me/plan": {
+		Summary: "What your plan entitles you to, and how much storage you're using", Tag: "users", Auth: authBearer,
+		Response: planResponse{},
+	},
+	"POST /api/billing/stripe": {
+		Summary: "Receive a Stripe event, signed with STRIPE_WEBHOOK_SECRET. Subscription events move the customer's user to the plan their prices name.", Tag: "users",
+		Response: stripeWebhookResponse{},
+	},
+	"GET /api/users/me/bucket": {
+		Summary: "Your own bucket, if you registered one", Tag: "users", Auth: authBearer,
+		Response: userBucketResponse{},
+	},
+	"PUT /api/users/me/bucket": {
+		Summary: "Store your videos in your own bucket", Tag: "users", Auth: authBearer,
+		Request: database.SetUserBucketParams{}, Response: userBucketResponse{},
+	},
+	"DELETE /api/users/me/bucket": {
+		Summary: "Stop storing your videos in your own bucket", Tag: "users", Auth: authBearer,
+		Status: 204,
+	},
+	"GET /api/users/me/notifications": {
+		Summary: "Your notification settings", Tag: "users", Auth: authBearer,
+		Response: notificationSettingsResponse{},
+	},
+	"PUT /api/users/me/notifications": {
+		Summary: "Choose how you hear about processed videos", Tag: "users", Auth: authBearer,
+		Request: database.SetNotificationSettingsParams{}, Response: notificationSettingsResponse{},
+	},
+	"GET /api/users/me/defaults": {
+		Summary: "Your upload defaults", Tag: "users", Auth: authBearer,
+		Response: database.UploadDefaults{},
+	},
+	"PUT /api/users/me/defaults": {
+		Summary: "Choose the profile and notification channel uploads get when they don't ask for one",
+		Tag:     "users", Auth: authBearer,
+		Request: database.SetUploadDefaultsParams{}, Response: database.UploadDefaults{},
+	},
+	"POST /api/users/me/notifications/test": {
+		Summary: "Send a test notification", Tag: "users", Auth: authBearer,
+		Response: notificationTestResponseDoc{},
+	},
+	"GET /api/users/me/api-keys": {
+		Summary: "Your API keys, without the keys themselves", Tag: "users", Auth: authBearer,
+		Response: []database.APIKey{},
+	},
+	"POST /api/users/me/api-keys": {
+		Summary: "Create an API key, for logging in to the SFTP gateway. The key is only shown in this response.", Tag: "users", Auth: authBearer,
+		Request: apiKeyParamsDoc{}, Status: 201, Response: apiKeyCreatedResponse{},
+	},
+	"DELETE /api/users/me/api-keys/{keyID}": {
+		Summary: "Revoke an API key", Tag: "users", Auth: authBearer, Status: 204,
+	},
+	"GET /api/users/me/likes": {
+		Summary: "Videos you liked", Tag: "lists", Auth: authBearer,
+		Query: []apiParam{fieldsParam}, Response: []database.Video{},
+	},
+	"GET /api/users/me/watch-later": {
+		Summary: "Your watch later list", Tag: "lists", Auth: authBearer,
+		Query: []apiParam{fieldsParam}, Response: []database.Video{},
+	},
+	"PUT /api/users/me/watch-later/{videoID}": {Summary: "Add a video to watch later", Tag: "lists", Auth: authBearer, Status: 204},
+	"DELETE /api/users/me/watch-later/{videoID}": {
+		Summary: "Remove a video from watch later", Tag: "lists", Auth: authBearer, Status: 204,
+	},
+	"PUT /api/videos/{videoID}/like":    {Summary: "Like a video", Tag: "lists", Auth: authBearer, Status: 204},
+	"DELETE /api/videos/{videoID}/like": {Summary: "Unlike a video", Tag: "lists", Auth: authBearer, Status: 204},
+	"GET /api/users/{userID}/feed.rss": {
+		Summary: "RSS feed of a user's videos", Tag: "feeds", ContentType: "application/rss+xml",
+	},
+	"GET /api/users/{userID}/feed.atom": {
+		Summary: "Atom feed of a user's videos", Tag: "feeds", ContentType: "application/atom+xml",
+	},
+	"GET /api/users/{userID}/podcast.rss": {
+		Summary: "Podcast feed of a user's audio renditions", Tag: "feeds", ContentType: "application/rss+xml",
+	},
+
+	"GET /api/profiles": {
+		Summary: "Processing profiles uploads can pick", Tag: "videos",
+		Response: []profileResponse{},
+	},
+	"POST /api/videos": {
+		Summary: "Create a video, before uploading its file", Tag: "videos", Auth: authBearer,
+		Request: videoCreateParamsDoc{}, Status: 201, Response: ownedVideo{},
+	},
+	"GET /api/videos/by-external-id": {
+		Summary: "Your video with an external ID", Tag: "videos", Auth: authBearer,
+		Query:    []apiParam{{Name: "external_id", Description: "ID the video was given in another system"}},
+		Response: ownedVideo{},
+	},
+	"GET /api/videos": {
+		Summary: "Your videos", Tag: "videos", Auth: authBearer,
+		Query:    append([]apiParam{fieldsParam}, videoFilterParams...),
+		Response: []ownedVideo{},
+	},
+	"GET /api/videos/export": {
+		Summary: "Stream your catalog as CSV or JSON Lines, filtered like the list of your videos", Tag: "videos", Auth: authBearer,
+		Query: append([]apiParam{{
+			Name:        "format",
+			Description: "csv, the default, or jsonl",
+		}, {
+			Name:        "include_urls",
+			Description: "Add each video's watch page, file and thumbnail URLs",
+			Type:        "boolean",
+		}}, videoFilterParams...),
+		ContentType: "text/csv",
+	},
+	"POST /api/videos/bulk": {
+		Summary: "Delete, change the visibility of or tag many of your videos in the background, with the report at the Location. Dry-run deletes respond with what they'd remove.", Tag: "videos", Auth: authBearer,
+		Query:   []apiParam{dryRunParam},
+		Request: bulkOperationParamsDoc{}, Status: 202, Response: database.BulkOperation{},
+	},
+	"GET /api/bulk/{operationID}": {
+		Summary: "Check on a bulk operation, with what happened to each video so far", Tag: "videos", Auth: authBearer,
+		Response: database.BulkOperation{},
+	},
+	"GET /api/videos/duplicates": {
+		Summary: "Pairs of your videos that look alike", Tag: "videos", Auth: authBearer,
+		Query:    []apiParam{{Name: "threshold", Description: "Largest perceptual hash distance counted as alike", Type: "integer"}},
+		Response: []duplicatePair{},
+	},
+	"GET /api/videos/{videoID}": {
+		Summary: "A video", Tag: "videos", Auth: authBearer,
+		Query: []apiParam{fieldsParam}, Response: database.Video{},
+	},
+	"PATCH /api/videos/{videoID}": {
+		Summary: "Change your video's title, description, visibility or tags", Tag: "videos", Auth: authBearer,
+		Headers: []apiParam{videoIfMatchHeader},
+		Request: videoUpdateParamsDoc{}, Response: ownedVideo{},
+	},
+	"GET /api/videos/{videoID}/metadata": {
+		Summary: "Your video's metadata", Tag: "videos", Auth: authBearer,
+		Response: map[string]string{},
+	},
+	"PUT /api/videos/{videoID}/metadata": {
+		Summary: "Replace all of your video's metadata", Tag: "videos", Auth: authBearer,
+		Headers: []apiParam{videoIfMatchHeader},
+		Request: map[string]string{}, Response: map[string]string{},
+	},
+	"PUT /api/videos/{videoID}/metadata/{key}": {
+		Summary: "Set one key of your video's metadata", Tag: "videos", Auth: authBearer,
+		Headers: []apiParam{videoIfMatchHeader},
+		Request: metadataValueParamsDoc{}, Response: map[string]string{},
+	},
+	"DELETE /api/videos/{videoID}/metadata/{key}": {
+		Summary: "Remove one key from your video's metadata, answering with what's left", Tag: "videos", Auth: authBearer,
+		Headers:  []apiParam{videoIfMatchHeader},
+		Response: map[string]string{},
+	},
+	"DELETE /api/videos/{videoID}": {
+		Summary: "Delete a video and its files", Tag: "videos", Auth: authBearer,
+		Query:  []apiParam{dryRunParam},
+		Status: 204,
+	},
+	"POST /api/thumbnail_upload/{videoID}": {
+		Summary: "Upload a video's thumbnail", Tag: "uploads", Auth: authBearer,
+		Upload: &apiUpload{Limit: thumbnailUploadLimit, Fields: []apiUploadField{
+			{Name: "thumbnail", Description: "JPEG or PNG image", File: true, Required: true},
+		}},
+		Response: database.Video{},
+	},
+	"POST /api/video_upload/{videoID}": {
+		Summary: "Upload a video's file. In worker mode it's queued, answering 202 with the job.", Tag: "uploads", Auth: authBearer,
+		Upload:   &apiUpload{Limit: videoUploadLimit, PerPlan: true, Fields: []apiUploadField{profileField, notifyField, priorityField, externalIDField, videoField}},
+		Response: database.Video{},
+	},
+	"POST /api/videos/{videoID}/media": {
+		Summary: "Upload a video's file and thumbnail together. In worker mode it's queued, answering 202 with the job.",
+		Tag:     "uploads", Auth: authBearer,
+		Upload:   &apiUpload{Limit: mediaUploadLimit, PerPlan: true, Fields: []apiUploadField{profileField, notifyField, priorityField, externalIDField, thumbnailField, videoField}},
+		Response: database.Video{},
+	},
+	"POST /api/videos/{videoID}/uploads": {
+		Summary: "Start a resumable upload of a video's file. Leave size out for a stream of unknown length.",
+		Tag:     "uploads", Auth: authBearer,
+		Request: uploadSessionParamsDoc{}, Status: 201, Response: database.UploadSession{},
+	},
+	"GET /api/uploads/{uploadID}": {
+		Summary: "Check how much of a resumable upload has arrived", Tag: "uploads", Auth: authBearer,
+		Response: database.UploadSession{},
+	},
+	"PATCH /api/uploads/{uploadID}": {
+		Summary: "Append a chunk to a resumable upload. A chunk that doesn't start at the upload's offset " +
+			"is refused with 409; the Upload-Offset response header says where to carry on from.",
+		Tag: "uploads", Auth: authBearer,
+		Headers:     []apiParam{{Name: "Upload-Offset", Description: "Offset the chunk starts at", Type: "integer"}},
+		RequestType: "application/octet-stream",
+		Response:    database.UploadSession{},
+	},
+	"POST /api/uploads/{uploadID}/complete": {
+		Summary: "Process a resumable upload once all of it has arrived. In worker mode it's queued, answering 202 with the job.",
+		Tag:     "uploads", Auth: authBearer,
+		Response: database.Video{},
+	},
+	"DELETE /api/uploads/{uploadID}": {
+		Summary: "Abandon a resumable upload", Tag: "uploads", Auth: authBearer, Status: 204,
+	},
+	"GET /api/jobs/{jobID}": {
+		Summary: "Check on a processing job", Tag: "uploads", Auth: authBearer,
+		Response: database.Job{},
+	},
+	"DELETE /api/jobs/{jobID}": {
+		Summary: "Cancel a processing job that hasn't finished, killing its ffmpeg and removing its partial output", Tag: "uploads", Auth: authBearer,
+		Response: database.Job{},
+	},
+	"POST /api/transcoder/callback": {
+		Summary: "Report a transcode's outcome, signed with TRANSCODER_WEBHOOK_SECRET", Tag: "uploads",
+		Request: transcoderCallbackDoc{}, Response: database.Job{},
+	},
+	"POST /api/inbound-email/mailgun": {
+		Summary: "Receive an email from a Mailgun route, signed with INBOUND_EMAIL_MAILGUN_SIGNING_KEY. Its video attachments are uploaded for the sender.", Tag: "uploads",
+		Upload: &apiUpload{Limit: maxInboundEmailSize, Fields: []apiUploadField{
+			{Name: "from", Description: "The From header", Required: true},
+			{Name: "subject", Description: "The Subject header"},
+			{Name: "message-headers", Description: "JSON list of the message's headers, where Mailgun's DKIM result and signing domain are read from", Required: true},
+			{Name: "timestamp", Required: true},
+			{Name: "token", Required: true},
+			{Name: "signature", Description: "HMAC-SHA256 of timestamp and token", Required: true},
+			{Name: "attachment-1", Description: "Attachments, numbered from 1", File: true},
+		}},
+		Response: inboundEmailResponse{},
+	},
+	"POST /api/inbound-email/ses": {
+		Summary: "Receive an SNS message about an email SES received, from INBOUND_EMAIL_SES_TOPIC_ARN. Its video attachments are uploaded for the sender.", Tag: "uploads",
+		Response: inboundEmailResponse{},
+	},
+
+	"POST /api/videos/{videoID}/chapters": {
+		Summary: "Replace a video's chapters", Tag: "videos", Auth: authBearer,
+		Request: chaptersParamsDoc{}, Status: 201, Response: []database.Chapter{},
+	},
+	"GET /api/videos/{videoID}/chapters": {Summary: "A video's chapters", Tag: "videos", Response: []database.Chapter{}},
+	"DELETE /api/videos/{videoID}/chapters/{chapterID}": {
+		Summary: "Delete a chapter", Tag: "videos", Auth: authBearer, Status: 204,
+	},
+	"POST /api/videos/{videoID}/comments": {
+		Summary: "Comment on a video, or reply to a comment", Tag: "comments", Auth: authBearer,
+		Request: commentParamsDoc{}, Status: 201, Response: database.Comment{},
+	},
+	"GET /api/videos/{videoID}/comments": {
+		Summary: "A video's comments, a page at a time", Tag: "comments",
+		Query: []apiParam{
+			{Name: "parent_id", Description: "List replies to this comment"},
+			{Name: "cursor", Description: "next_cursor from the previous page"},
+			{Name: "limit", Description: "Most comments to return", Type: "integer"},
+		},
+		Response: []database.Comment{},
+	},
+	"PATCH /api/comments/{commentID}": {
+		Summary: "Edit your comment", Tag: "comments", Auth: authBearer,
+		Request: commentUpdateParamsDoc{}, Response: database.Comment{},
+	},
+	"DELETE /api/comments/{commentID}": {Summary: "Delete a comment", Tag: "comments", Auth: authBearer, Status: 204},
+
+	"GET /api/embed/{videoID}/playback": {
+		Summary: "Playback URL for an embedded player, with its embed token", Tag: "embeds",
+		Response: embedPlaybackResponse{},
+	},
+	"POST /api/embed/{videoID}/token": {
+		Summary: "Swap an embedded player's embed token for a new one before it expires", Tag: "embeds",
+		Response: embedTokenResponse{},
+	},
+	"GET /api/videos/{videoID}/embed": {
+		Summary: "Where a video can be embedded, and the code to do it", Tag: "embeds", Auth: authBearer,
+		Response: embedResponse{},
+	},
+	"PUT /api/videos/{videoID}/embed": {
+		Summary: "Choose the sites a video can be embedded on", Tag: "embeds", Auth: authBearer,
+		Request: embedParamsDoc{}, Response: embedResponse{},
+	},
+	"GET /api/videos/{videoID}/access": {
+		Summary: "A video's country and network restrictions", Tag: "videos", Auth: authBearer,
+		Response: database.AccessRules{},
+	},
+	"PUT /api/videos/{videoID}/access": {
+		Summary: "Restrict a video to some countries or networks", Tag: "videos", Auth: authBearer,
+		Request: database.AccessRules{}, Response: database.AccessRules{},
+	},
+	"POST /api/videos/{videoID}/archive": {
+		Summary: "Move a video's file to cold storage. It can't be played until it's restored.", Tag: "videos", Auth: authBearer,
+		Request: videoArchiveParamsDoc{}, Response: database.VideoArchive{},
+	},
+	"GET /api/videos/{videoID}/archive": {
+		Summary: "Where a video is in the archive, checking on a running restore", Tag: "videos", Auth: authBearer,
+		Response: database.VideoArchive{},
+	},
+	"POST /api/videos/{videoID}/restore": {
+		Summary: "Start restoring an archived video, for a number of days", Tag: "videos", Auth: authBearer,
+		Request: videoRestoreParamsDoc{}, Status: 202, Response: database.VideoArchive{},
+	},
+	"POST /api/videos/{videoID}/share": {
+		Summary: "Create a share link", Tag: "sharing", Auth: authBearer,
+		Request: shareParamsDoc{}, Status: 201, Response: shareLinkResponse{},
+	},
+	"GET /api/videos/{videoID}/share": {
+		Summary: "A video's share links", Tag: "sharing", Auth: authBearer,
+		Response: []shareLinkResponse{},
+	},
+	"DELETE /api/videos/{videoID}/share/{shareID}": {
+		Summary: "Revoke a share link", Tag: "sharing", Auth: authBearer, Status: 204,
+	},
+	"GET /api/share/{token}": {
+		Summary: "Open a share link. Password-protected links take the password in X-Share-Password.", Tag: "sharing",
+		Response: shareResolveDoc{},
+	},
+	"POST /api/videos/{videoID}/clips": {
+		Summary: "Create a clip of part of a video", Tag: "sharing", Auth: authBearer,
+		Request: clipParamsDoc{}, Status: 201, Response: clipResponse{},
+	},
+	"GET /api/videos/{videoID}/clips": {
+		Summary: "A video's clips", Tag: "sharing", Auth: authBearer,
+		Response: []clipResponse{},
+	},
+	"GET /api/clips/{token}": {Summary: "Open a clip", Tag: "sharing", Response: clipResponse{}},
+	"DELETE /api/clips/{clipID}": {
+		Summary: "Delete a clip", Tag: "sharing", Auth: authBearer,
+		Query:  []apiParam{dryRunParam},
+		Status: 204,
+	},
+	"GET /api/play/{nonce}": {
+		Summary: "Follow a single-use playback link, redirecting to the video", Tag: "sharing",
+		Status: 302,
+	},
+	"GET /api/hls/{token}/master.m3u8": {
+		Summary: "Master playlist of a private HLS video, for the video a stream token was issued for", Tag: "sharing",
+		ContentType: "application/vnd.apple.mpegurl",
+	},
+	"GET /api/hls/{token}/key": {
+		Summary: "AES-128 key of an encrypted HLS video, for the video a stream token was issued for", Tag: "sharing",
+		ContentType: "application/octet-stream",
+	},
+	"GET /api/hls/{token}/{rendition}/{file}": {
+		Summary: "Rendition playlist with presigned segments, or a segment honouring Range, of a private HLS video", Tag: "sharing",
+		ContentType: "application/vnd.apple.mpegurl",
+	},
+
+	"GET /api/videos/{videoID}/download": {
+		Summary: "Redirect to a temporary link that downloads your video's file under the name it was uploaded with", Tag: "videos",
+		Auth: authBearer, Status: 302,
+	},
+	"GET /api/videos/{videoID}/probe": {
+		Summary: "ffprobe's report on a video's stored file", Tag: "videos", Auth: authBearer,
+		Response: processing.Probe{},
+	},
+	"GET /api/videos/{videoID}/frames": {
+		Summary: "Frames to pick a thumbnail from", Tag: "thumbnails", Auth: authBearer,
+		Query:    []apiParam{{Name: "count", Description: "How many frames to extract", Type: "integer"}},
+		Response: []videoFrame{},
+	},
+	"POST /api/videos/{videoID}/thumbnail/from-frame": {
+		Summary: "Use an extracted frame as the thumbnail", Tag: "thumbnails", Auth: authBearer,
+		Request: frameParamsDoc{}, Response: database.Video{},
+	},
+	"GET /api/videos/{videoID}/thumbnails": {
+		Summary: "A video's current and earlier thumbnails", Tag: "thumbnails", Auth: authBearer,
+		Response: []thumbnailVersion{},
+	},
+	"POST /api/videos/{videoID}/thumbnails/{thumbnailID}/revert": {
+		Summary: "Go back to an earlier thumbnail", Tag: "thumbnails", Auth: authBearer,
+		Response: database.Video{},
+	},
+
+	"GET /oembed": {
+		Summary: "oEmbed description of a watch page", Tag: "embeds",
+		Query: []apiParam{
+			{Name: "url", Description: "Watch page URL"},
+			{Name: "format", Description: "Only json is supported"},
+			{Name: "maxwidth", Description: "Widest the player may be", Type: "integer"},
+			{Name: "maxheight", Description: "Tallest the player may be", Type: "integer"},
+		},
+		Response: oEmbedDoc{}, Raw: true,
+	},
+
+	"POST /admin/reset": {
+		Summary: "Delete all data, on the dev platform only", Tag: "admin",
+		Query: []apiParam{dryRunParam},
+	},
+	"GET /admin/disk": {
+		Summary: "Disk usage of assets and scratch space", Tag: "admin", Auth: authAdmin,
+		Response: diskReport{},
+	},
+	"GET /admin/compliance": {
+		Summary: "Check stored objects' encryption and storage class", Tag: "admin", Auth: authAdmin,
+		Query:    []apiParam{{Name: "sse", Description: "Encryption every object should have"}},
+		Response: complianceReport{},
+	},
+	"GET /admin/videos/{videoID}/compliance": {
+		Summary: "Check one video's objects", Tag: "admin", Auth: authAdmin,
+		Response: videoComplianceDoc{},
+	},
+	"GET /admin/fixity": {
+		Summary: "Results of checksum verification", Tag: "admin", Auth: authAdmin,
+		Query: []apiParam{
+			{Name: "status", Description: "Only checks with this status"},
+			{Name: "limit", Description: "Most checks to return", Type: "integer"},
+		},
+		Response: fixityResponseDoc{},
+	},
+	"POST /admin/fixity/run": {
+		Summary: "Verify a sample of stored objects now", Tag: "admin", Auth: authAdmin,
+		Response: fixityRunResponseDoc{},
+	},
+	"GET /admin/reconcile": {
+		Summary: "Latest reconciliation of the buckets against the database", Tag: "admin", Auth: authAdmin,
+		Response: processing.ReconcileReport{},
+	},
+	"POST /admin/reconcile/run": {
+		Summary: "Reconcile the buckets against the database now", Tag: "admin", Auth: authAdmin,
+		Query: []apiParam{
+			{Name: "source", Description: "list, or inventory to read the S3 Inventory report"},
+			{Name: "repair", Description: "Comma-separated repairs: delete_orphaned, forget_missing, record_unrecorded"},
+			{Name: "dryRun", Description: "Check what the repairs would do without making them", Type: "boolean"},
+		},
+		Response: processing.ReconcileReport{},
+	},
+	"GET /admin/mode": {
+		Summary: "The server's current mode", Tag: "admin", Auth: authAdmin,
+		Response: serviceModeStatus{},
+	},
+	"PUT /admin/mode": {
+		Summary: "Switch to normal, read-only or maintenance mode", Tag: "admin", Auth: authAdmin,
+		Request: modeParamsDoc{}, Response: serviceModeStatus{},
+	},
+	"GET /admin/pipeline": {
+		Summary: "What each ingest stage has done since startup", Tag: "admin", Auth: authAdmin,
+		Response: []ingest.StageMetrics{},
+	},
+	"GET /admin/queue": {
+		Summary: "Processing jobs queued, running and claimed at each priority", Tag: "admin", Auth: authAdmin,
+		Query:    []apiParam{{Name: "window", Description: "How far back claims, waits and outcomes are counted, like 15m, 1h when left out"}},
+		Response: []database.JobQueueStats{},
+	},
+	"GET /admin/dead-letters": {
+		Summary: "Processing jobs that failed for good, newest first, a page at a time", Tag: "admin", Auth: authAdmin,
+		Query: []apiParam{
+			{Name: "user_id", Description: "Only this user's dead letters"},
+			{Name: "retried", Description: "Only dead letters that have, or haven't, been retried", Type: "boolean"},
+			{Name: "cursor", Description: "next_cursor from the previous page"},
+			{Name: "limit", Description: "Most dead letters to return, 50 when left out", Type: "integer"},
+		},
+		Response: []database.DeadLetter{},
+	},
+	"GET /admin/dead-letters/{deadLetterID}": {
+		Summary: "A failed job's error, ffmpeg output, source probe and stage trace", Tag: "admin", Auth: authAdmin,
+		Response: database.DeadLetter{},
+	},
+	"POST /admin/dead-letters/{deadLetterID}/retry": {
+		Summary: "Process a failed job again from the start, from its kept upload", Tag: "admin", Auth: authAdmin,
+		Status: 202, Response: database.Job{},
+	},
+	"DELETE /admin/dead-letters/{deadLetterID}": {
+		Summary: "Delete a dead letter and the upload kept for retrying it", Tag: "admin", Auth: authAdmin,
+		Status: 204,
+	},
+	"GET /admin/probes/{sha256}": {
+		Summary: "Cached ffprobe report for a file by its SHA-256", Tag: "admin", Auth: authAdmin,
+		Response: processing.Probe{},
+	},
+	"GET /admin/videos/{videoID}/probe": {
+		Summary: "Cached ffprobe report for a video's source file", Tag: "admin", Auth: authAdmin,
+		Response: processing.Probe{},
+	},
+	"POST /admin/tenants": {
+		Summary: "Create a tenant", Tag: "admin", Auth: authAdmin,
+		Request: database.CreateTenantParams{}, Status: 201, Response: database.Tenant{},
+	},
+	"GET /admin/tenants": {
+		Summary: "All tenants", Tag: "admin", Auth: authAdmin,
+		Response: []database.Tenant{},
+	},
+	"GET /admin/plans": {
+		Summary: "All plans", Tag: "admin", Auth: authAdmin,
+		Response: []database.Plan{},
+	},
+	"PUT /admin/plans/{plan}": {
+		Summary: "Create a plan, or replace its limits", Tag: "admin", Auth: authAdmin,
+		Request: database.PlanLimits{}, Response: database.Plan{},
+	},
+	"DELETE /admin/plans/{plan}": {
+		Summary: "Delete a plan nobody is on", Tag: "admin", Auth: authAdmin,
+		Status: 204,
+	},
+	"GET /admin/defaults": {
+		Summary: "Upload defaults for users who haven't set their own", Tag: "admin", Auth: authAdmin,
+		Response: database.UploadDefaults{},
+	},
+	"PUT /admin/defaults": {
+		Summary: "Set the upload defaults for users who haven't set their own", Tag: "admin", Auth: authAdmin,
+		Request: database.SetUploadDefaultsParams{}, Response: database.UploadDefaults{},
+	},
+	"GET /admin/usage.csv": {
+		Summary: "Monthly storage usage of every user", Tag: "admin", Auth: authAdmin,
+		Query: usageRangeParams, ContentType: "text/csv",
+	},
+	"PUT /admin/users/{userID}/tenant": {
+		Summary: "Move a user to a tenant, or out of one", Tag: "admin", Auth: authAdmin,
+		Request: userTenantParamsDoc{}, Status: 204,
+	},
+	"PUT /admin/users/{userID}/plan": {
+		Summary: "Move a user to a plan, or back to the default", Tag: "admin", Auth: authAdmin,
+		Request: userPlanParamsDoc{}, Status: 204,
+	},
+}
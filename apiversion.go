@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Current version of the API, served under /api/v2. /api/v1 serves the same
+// routes with the bodies they had before v2 wrapped them in envelopes, and
+// the unversioned /api paths are kept as deprecated aliases of it.
+const (
+	apiPrefix          = "/api/"
+	apiVersionedPrefix = "/api/v2/"
+	apiV1Prefix        = "/api/v1/"
+)
+
+// When the unversioned /api paths were deprecated in favour of /api/v1
+var unversionedAPIDeprecatedAt = time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+
+// apiRouter registers API routes under /api/v2 and /api/v1, and under the old
+// unversioned /api paths with headers telling clients to move. It's also
+// the registry the OpenAPI document is built from, so every route it
+// registers must be documented in apiOperations.
+type apiRouter struct {
+	mux *http.ServeMux
+	// sunset is when the unversioned paths stop working, zero if it's not decided
+	sunset time.Time
+	routes []apiRoute
+}
+
+// apiRoute is a registered route as it's documented
+type apiRoute struct {
+	pattern string
+	doc     apiOperation
+}
+
+// Function to register a handler for a pattern such as "GET /api/videos/{videoID}",
+// written without the version
+func (a *apiRouter) HandleFunc(pattern string, handler http.HandlerFunc) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || !strings.HasPrefix(path, apiPrefix) {
+		panic(fmt.Sprintf("API route %q must be a method and a path under %s", pattern, apiPrefix))
+	}
+	versioned := method + " " + apiVersionedPrefix + strings.TrimPrefix(path, apiPrefix)
+	v1 := method + " " + apiV1Prefix + strings.TrimPrefix(path, apiPrefix)
+
+	a.document(pattern, versioned)
+	a.mux.HandleFunc(versioned, handler)
+	a.mux.HandleFunc(v1, v1Responses(handler))
+	a.mux.HandleFunc(pattern, a.deprecated(v1Responses(handler)))
+}
+
+// Function to register a documented handler that isn't versioned, such as
+// the admin API
+func (a *apiRouter) HandleUnversionedFunc(pattern string, handler http.HandlerFunc) {
+	a.document(pattern, pattern)
+	a.mux.HandleFunc(pattern, handler)
+}
+
+// Function to record a route for the OpenAPI document under the pattern it's
+// served at, refusing routes nobody documented
+func (a *apiRouter) document(pattern, servedAt string) {
+	doc, ok := apiOperations[pattern]
+	if !ok {
+		panic(fmt.Sprintf("API route %q isn't documented in apiOperations", pattern))
+	}
+	a.routes = append(a.routes, apiRoute{pattern: servedAt, doc: doc})
+}
+
+// Function to serve an unversioned path, pointing clients at its /api/v1
+// successor, which answers with the same bodies, or answering 410 Gone once
+// the sunset has passed
+func (a *apiRouter) deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := apiV1Prefix + strings.TrimPrefix(r.URL.Path, apiPrefix)
+		if r.URL.RawQuery != "" {
+			successor += "?" + r.URL.RawQuery
+		}
+
+		// Deprecation takes a structured date (RFC 9745), Sunset an HTTP date (RFC 8594)
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", unversionedAPIDeprecatedAt.Unix()))
+		if !a.sunset.IsZero() {
+			w.Header().Set("Sunset", a.sunset.Format(http.TimeFormat))
+		}
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+
+		if !a.sunset.IsZero() && !time.Now().Before(a.sunset) {
+			respondWithError(w, http.StatusGone, "This path has been retired, use "+successor, nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Function to get the unversioned form of an API path, e.g. /api/videos for
+// /api/v2/videos, so every version can be matched the same way
+func unversionedAPIPath(path string) string {
+	for _, prefix := range []string{apiVersionedPrefix, apiV1Prefix} {
+		if strings.HasPrefix(path, prefix) {
+			return apiPrefix + strings.TrimPrefix(path, prefix)
+		}
+	}
+	return path
+}
+
+// Function to get the path of an API route in the version a request was made
+// to, e.g. for a Location header, so clients stay on the version they chose
+func apiPathFor(r *http.Request, path string) string {
+	rest := strings.TrimPrefix(path, apiPrefix)
+	if strings.HasPrefix(r.URL.Path, apiVersionedPrefix) {
+		return apiVersionedPrefix + rest
+	}
+	return apiV1Prefix + rest
+}
+
+// v1ResponseWriter marks responses to /api/v1 and the unversioned paths, which
+// respondWithJSON and respondWithList send without an envelope
+type v1ResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Function to let http.ResponseController reach the wrapped writer
+func (w v1ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Function to serve a handler with the response bodies of /api/v1
+func v1Responses(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(v1ResponseWriter{w}, r)
+	}
+}
@@ -1,5 +1,6 @@
 document.addEventListener('DOMContentLoaded', async () => {
   const token = localStorage.getItem('token');
+  loadProfiles();
 
   if (token) {
     document.getElementById('auth-section').style.display = 'none';
@@ -26,7 +27,7 @@ async function createVideoDraft() {
   const description = document.getElementById('video-description').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await fetch('/api/v2/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -39,7 +40,7 @@ async function createVideoDraft() {
       throw new Error(`Failed to create video draft: ${data.error}`);
     }
 
-    const videoID = data.id;
+    const videoID = data.data.id;
     if (videoID) {
       await getVideos();
       await videoStateHandler(videoID);
@@ -54,7 +55,7 @@ async function login() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/login', {
+    const res = await fetch('/api/v2/login', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -66,8 +67,8 @@ async function login() {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
-    if (data.token) {
-      localStorage.setItem('token', data.token);
+    if (data.data.token) {
+      localStorage.setItem('token', data.data.token);
       document.getElementById('auth-section').style.display = 'none';
       document.getElementById('video-section').style.display = 'block';
       await getVideos();
@@ -84,7 +85,7 @@ async function signup() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/users', {
+    const res = await fetch('/api/v2/users', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -130,7 +131,7 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await fetch(`/api/v2/thumbnail_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -152,18 +153,97 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+async function loadFrames(videoID) {
+  if (!videoID) return;
+
+  const frameBtn = document.getElementById('choose-frame-btn');
+  const frameList = document.getElementById('frame-list');
+  frameBtn.disabled = true;
+  frameList.innerHTML = '';
+
+  try {
+    const res = await fetch(`/api/v2/videos/${videoID}/frames?count=5`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to load frames. Error: ${data.error}`);
+    }
+
+    const { data: frames } = await res.json();
+    for (const frame of frames) {
+      const img = document.createElement('img');
+      img.src = frame.url;
+      img.title = `${frame.offset_seconds.toFixed(1)}s`;
+      img.onclick = () => selectFrame(videoID, frame.key);
+      frameList.appendChild(img);
+    }
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+
+  frameBtn.disabled = false;
+}
+
+async function selectFrame(videoID, key) {
+  try {
+    const res = await fetch(`/api/v2/videos/${videoID}/thumbnail/from-frame`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+      body: JSON.stringify({ key }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to set thumbnail. Error: ${data.error}`);
+    }
+
+    document.getElementById('frame-list').innerHTML = '';
+    await getVideo(videoID);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function loadProfiles() {
+  const res = await fetch('/api/v2/profiles');
+  if (!res.ok) return;
+  const { data: profiles } = await res.json();
+  if (profiles.length === 0) return;
+
+  const select = document.getElementById('video-profile');
+  select.innerHTML = '';
+  for (const profile of profiles) {
+    const option = document.createElement('option');
+    option.value = profile.name;
+    option.textContent = profile.name;
+    option.selected = profile.default;
+    select.appendChild(option);
+  }
+  select.hidden = false;
+}
+
 async function uploadVideoFile(videoID) {
   const videoFile = document.getElementById('video-file').files[0];
   if (!videoFile) return;
 
   const formData = new FormData();
+  const profile = document.getElementById('video-profile').value;
+  if (profile) {
+    formData.append('profile', profile);
+  }
   formData.append('video', videoFile);
 
   uploadBtnSelector = 'upload-video-btn';
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await fetch(`/api/v2/video_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -188,7 +268,7 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
+    const res = await fetch('/api/v2/videos', {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -199,7 +279,7 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const { data: videos } = await res.json();
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
@@ -223,6 +303,7 @@ function createVideoStateHandler() {
       // Reset file input values
       document.getElementById('thumbnail').value = '';
       document.getElementById('video-file').value = '';
+      document.getElementById('frame-list').innerHTML = '';
 
       await getVideo(videoID);
     }
@@ -231,7 +312,7 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
+    const res = await fetch(`/api/v2/videos/${videoID}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -241,7 +322,7 @@ async function getVideo(videoID) {
       throw new Error('Failed to get video.');
     }
 
-    const video = await res.json();
+    const { data: video } = await res.json();
     viewVideo(video);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -261,6 +342,7 @@ function viewVideo(video) {
     thumbnailImg.style.display = 'none';
   } else {
     thumbnailImg.style.display = 'block';
+    thumbnailImg.style.backgroundColor = video.thumbnail_dominant_color || '';
     thumbnailImg.src = video.thumbnail_url;
   }
 
@@ -283,7 +365,7 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await fetch(`/api/v2/videos/${currentVideo.id}`, {
       method: 'DELETE',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
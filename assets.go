@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -16,21 +29,67 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	return nil
 }
 
-
 // Function to get the asset file path
-func getAssetPath(mediaType string) string {
+func getAssetPath(mediaType string) (string, error) {
+	id, err := getAssetID()
+	if err != nil {
+		return "", err
+	}
+
+	// Get the extension of mediaType
+	ext := mediaTypeToExt(mediaType)
+	return fmt.Sprintf("%s%s", id, ext), nil
+}
+
+// Function to generate a random name for a stored asset
+func getAssetID() (string, error) {
 
 	// Create 32-byte slice with random bytes to convert to a random base64 string
 	base := make([]byte, 32)
 	_, err := rand.Read(base)
 	if err != nil {
-		panic("failed to generate random bytes")
+		return "", fmt.Errorf("couldn't generate a random name: %w", err)
 	}
-	id := base64.RawURLEncoding.EncodeToString(base)
+	return base64.RawURLEncoding.EncodeToString(base), nil
+}
 
-	// Get the extension of mediaType
-	ext := mediaTypeToExt(mediaType)
-	return fmt.Sprintf("%s%s", id, ext)
+// Function to create a new file in the assets directory under a random name,
+// trying another name should one already be taken
+func (cfg apiConfig) createAsset(mediaType string) (*os.File, string, error) {
+	for attempt := 0; attempt < processing.MaxNameAttempts; attempt++ {
+		assetPath, err := getAssetPath(mediaType)
+		if err != nil {
+			return nil, "", err
+		}
+		file, err := os.OpenFile(cfg.getAssetDiskPath(assetPath), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return file, assetPath, nil
+	}
+	return nil, "", processing.ErrNameTaken
+}
+
+// Function to pick a key for a new object under prefix in a bucket that no object has yet
+func (cfg apiConfig) newObjectKey(ctx context.Context, bucket, prefix, mediaType string) (string, error) {
+	return processing.UnusedName(func() (string, error) {
+		name, err := getAssetPath(mediaType)
+		return path.Join(prefix, name), err
+	}, func(key string) (bool, error) {
+		return cfg.objectExists(ctx, bucket, key)
+	})
+}
+
+// Function to report whether a bucket already has an object under a key
+func (cfg apiConfig) objectExists(ctx context.Context, bucket, key string) (bool, error) {
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		return false, err
+	}
+	return processing.ObjectExists(ctx, client, bucket, key)
 }
 
 // Function to get object URL
@@ -38,18 +97,151 @@ func (cfg apiConfig) getObjectURL(key string) string {
 	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, key)
 }
 
+// Function to generate a temporary URL for reading an object from a bucket
+func (cfg apiConfig) generatePresignedURL(ctx context.Context, bucket, key string, expireTime time.Duration) (string, error) {
+	return cfg.generateDownloadURL(ctx, bucket, key, "", expireTime)
+}
+
+// Function to generate a temporary URL that saves an object from a bucket as
+// a file named filename, or that reads it like any other when filename is ""
+func (cfg apiConfig) generateDownloadURL(ctx context.Context, bucket, key, filename string, expireTime time.Duration) (string, error) {
+	if err := cfg.checkObjectPlayable(bucket, key); err != nil {
+		return "", err
+	}
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		return "", err
+	}
+	presignClient := s3.NewPresignClient(client)
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if filename != "" {
+		if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); disposition != "" {
+			input.ResponseContentDisposition = aws.String(disposition)
+		}
+	}
+	request, err := presignClient.PresignGetObject(ctx, input, s3.WithPresignExpires(expireTime))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
+
+// Function to get a URL that plays a video for up to expiry, a single-use playback link
+// when hot-link protection asks for one and a presigned URL otherwise
+func (cfg *apiConfig) signVideoURL(ctx context.Context, video database.Video, expiry time.Duration) (string, time.Time, error) {
+	// HLS playlists point at their segments by relative path, which a presigned URL can't cover,
+	// so they go through the proxy that presigns each segment, if it's enabled
+	if processing.FormatForKey(*video.VideoURL) == processing.FormatHLS {
+		if !cfg.hls.Enabled {
+			return *video.VideoURL, time.Time{}, nil
+		}
+		url, err := cfg.getHLSProxyURL(video.ID, expiry)
+		return url, time.Now().UTC().Add(expiry), err
+	}
+
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if cfg.hotlink.SingleUseURLs {
+		url, err := cfg.createPlaybackLink(bucket, key, "")
+		return url, time.Now().UTC().Add(cfg.hotlink.URLExpiry), err
+	}
+	url, err := cfg.generatePresignedURL(ctx, bucket, key, expiry)
+	return url, time.Now().UTC().Add(expiry), err
+}
+
+// Function to get the storage a tenant's videos live in, the deployment's own when tenantID is null
+func (cfg apiConfig) getTenantStorage(tenantID uuid.NullUUID) (processing.Storage, error) {
+	return processing.TenantStorage(cfg.db, tenantID, processing.Storage{
+		Bucket:  cfg.s3Bucket,
+		BaseURL: cfg.s3CfDistribution,
+	})
+}
+
+// Function to get the storage a video is stored in, its owner's own bucket if they registered one
+func (cfg apiConfig) getVideoStorage(video database.Video) (processing.Storage, error) {
+	return processing.VideoStorage(cfg.db, video, processing.Storage{
+		Bucket:  cfg.s3Bucket,
+		BaseURL: cfg.s3CfDistribution,
+	})
+}
+
+// Function to get every storage a video's clips and frames may be in, the one new ones go
+// in first. Those made before the owner registered a bucket or joined a tenant stay where they were.
+func (cfg apiConfig) getVideoStorages(video database.Video) ([]processing.Storage, error) {
+	owner, err := cfg.getVideoStorage(video)
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := cfg.getTenantStorage(video.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	storages := []processing.Storage{owner}
+	for _, storage := range []processing.Storage{tenant, {Bucket: cfg.s3Bucket, BaseURL: cfg.s3CfDistribution}} {
+		if !slices.Contains(storages, storage) {
+			storages = append(storages, storage)
+		}
+	}
+	return storages, nil
+}
+
+// Function to get the S3 client for a bucket, one acting as its owner's role for a user's own bucket
+func (cfg apiConfig) getS3Client(bucket string) (*s3.Client, error) {
+	if cfg.buckets == nil {
+		return cfg.s3Client, nil
+	}
+	return cfg.buckets.Client(bucket)
+}
+
+// Function to get the bucket and key of a video's stored file from its URL
+func (cfg apiConfig) getVideoLocation(video database.Video) (string, string, error) {
+	if video.VideoURL == nil {
+		return "", "", errors.New("video has not been uploaded")
+	}
+	storage, err := cfg.getVideoStorage(video)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := storage.ObjectKey(*video.VideoURL)
+	if err == nil {
+		return storage.Bucket, key, nil
+	}
+
+	// Videos uploaded before their owner registered a bucket stay where they were
+	storage, err = cfg.getTenantStorage(video.TenantID)
+	if err != nil {
+		return "", "", err
+	}
+	key, err = storage.ObjectKey(*video.VideoURL)
+	if err != nil {
+		return "", "", err
+	}
+	return storage.Bucket, key, nil
+}
+
 // Function to get asset disk path
 func (cfg apiConfig) getAssetDiskPath(assetPath string) string {
-	
+
 	// Join the root path with the file path
 	return filepath.Join(cfg.assetsRoot, assetPath)
 }
 
-// Function to get asset URL
-func (cfg apiConfig) getAssetURL(assetPath string) string {
-
-	// Format a string to the specified port and full asset disk path
-	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
+// Function to get the asset path back from a stored asset location, reporting false for
+// anything that isn't a local asset
+func (cfg apiConfig) getAssetPathFromURL(url string) (string, bool) {
+	// Assets were saved as URLs on the server's origin before their locations were, and
+	// before the server had a public origin those point at localhost
+	for _, prefix := range []string{assetsPrefix, cfg.getServerOrigin() + assetsPrefix, fmt.Sprintf("http://localhost:%s/assets/", cfg.port)} {
+		if strings.HasPrefix(url, prefix) {
+			return strings.TrimPrefix(url, prefix), true
+		}
+	}
+	return "", false
 }
 
 // Function to gather mediaType's particular extension
@@ -65,4 +257,4 @@ func mediaTypeToExt(mediaType string) string {
 
 	// Return last part of string with "." as prefix
 	return "." + parts[1]
-}
\ No newline at end of file
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// sharePasswordAttempts is how many wrong passwords a share link, or a
+	// client, may send before it has to wait out sharePasswordWindow
+	sharePasswordAttempts = 10
+	sharePasswordWindow   = 15 * time.Minute
+)
+
+// attemptLimiter counts failed guesses at a secret, such as a share link's
+// password, per key. Keys with no attempts left are refused until their
+// window ends, so a slow check like bcrypt can't be run without limit.
+type attemptLimiter struct {
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]attemptWindow
+	pruned   time.Time
+}
+
+type attemptWindow struct {
+	count int
+	ends  time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window}
+}
+
+// Function to use up an attempt for every key, or none of them if any key
+// has none left, reporting how long until they all have one again
+func (l *attemptLimiter) take(keys ...string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempt, ok := l.attempts[key]
+		if ok && now.Before(attempt.ends) && attempt.count >= l.max {
+			wait = max(wait, attempt.ends.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+
+	for _, key := range keys {
+		attempt := l.attempts[key]
+		if !now.Before(attempt.ends) {
+			attempt = attemptWindow{ends: now.Add(l.window)}
+		}
+		attempt.count++
+		l.attempts[key] = attempt
+	}
+	return 0, true
+}
+
+// Function to give back attempts that succeeded, so only failures count
+func (l *attemptLimiter) refund(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		attempt, ok := l.attempts[key]
+		if !ok {
+			continue
+		}
+		attempt.count--
+		if attempt.count <= 0 {
+			delete(l.attempts, key)
+			continue
+		}
+		l.attempts[key] = attempt
+	}
+}
+
+// Function to forget windows that have ended, at most once per window
+func (l *attemptLimiter) prune(now time.Time) {
+	if l.attempts == nil {
+		l.attempts = map[string]attemptWindow{}
+	}
+	if now.Sub(l.pruned) < l.window {
+		return
+	}
+	for key, attempt := range l.attempts {
+		if !now.Before(attempt.ends) {
+			delete(l.attempts, key)
+		}
+	}
+	l.pruned = now
+}
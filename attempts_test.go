@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	l := newAttemptLimiter(2, time.Minute)
+
+	for i := range 2 {
+		if _, ok := l.take("link:a", "client:1"); !ok {
+			t.Fatalf("attempt %d refused, want allowed", i+1)
+		}
+	}
+	wait, ok := l.take("link:a", "client:2")
+	if ok {
+		t.Fatal("third attempt at the link allowed, want refused")
+	}
+	if wait <= 0 || wait > time.Minute {
+		t.Errorf("wait = %v, want within the window", wait)
+	}
+	if _, ok := l.take("link:b", "client:1"); ok {
+		t.Error("third attempt from the client allowed, want refused")
+	}
+
+	// A refused attempt doesn't use up the keys that had room
+	if _, ok := l.take("link:b", "client:2"); !ok {
+		t.Error("attempt at another link from another client refused, want allowed")
+	}
+
+	// Attempts that succeed are given back
+	l.refund("link:a")
+	if _, ok := l.take("link:a"); !ok {
+		t.Error("attempt after a refund refused, want allowed")
+	}
+}
+
+func TestAttemptLimiterWindowEnds(t *testing.T) {
+	l := newAttemptLimiter(1, 10*time.Millisecond)
+	if _, ok := l.take("link:a"); !ok {
+		t.Fatal("first attempt refused")
+	}
+	if _, ok := l.take("link:a"); ok {
+		t.Fatal("second attempt allowed within the window")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := l.take("link:a"); !ok {
+		t.Error("attempt after the window refused, want allowed")
+	}
+}
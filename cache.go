@@ -1,6 +1,13 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
 
 func noCacheMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -8,3 +15,24 @@ func noCacheMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Function to let a CDN cache a page only some viewers may see, for up to
+// maxAge. Whether a viewer gets the page of a video with access rules depends
+// on where they are, so those pages are never shared.
+func (cfg *apiConfig) setViewerCacheHeaders(w http.ResponseWriter, video database.Video, maxAge time.Duration) {
+	rules, err := cfg.db.GetAccessRules(video.ID)
+	if err != nil || rules.Restricted() {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	cdn.SetHeaders(w.Header(), maxAge, cdn.VideoKey(video.ID))
+}
+
+// Function to have the CDN drop its copies of a video's public responses,
+// without holding up the request that changed it
+func (cfg *apiConfig) purgeCachedVideo(video database.Video) {
+	if cfg.cdnPurger == nil {
+		return
+	}
+	go cfg.cdnPurger.PurgeVideo(context.Background(), video)
+}
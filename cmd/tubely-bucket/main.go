@@ -0,0 +1,135 @@
+// Command tubely-bucket sets up the deployment's bucket the way Tubely needs
+// it: a lifecycle rule aborting incomplete multipart uploads, CORS rules for
+// uploads straight from browsers, and optionally versioning:
+//
+//	tubely-bucket -dry-run
+//	S3_MANAGE_BUCKET=true tubely-bucket
+//
+// It reads the same environment as the API server, which makes the same
+// changes at startup when S3_MANAGE_BUCKET=true. Rules Tubely didn't add are
+// kept, and only what differs is changed, so it's safe to run again. Without
+// S3_MANAGE_BUCKET=true it only reports what it would change.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("tubely-bucket: ")
+
+	godotenv.Load(".env")
+
+	manage := boolFromEnv("S3_MANAGE_BUCKET", false)
+	dryRun := flag.Bool("dry-run", boolFromEnv("DRY_RUN_DEFAULT", false) || !manage, "log the changes without making them")
+	flag.Parse()
+	if !*dryRun && !manage {
+		log.Fatal("S3_MANAGE_BUCKET must be true to change the bucket's configuration")
+	}
+
+	s3Bucket := os.Getenv("S3_BUCKET")
+	if s3Bucket == "" {
+		log.Fatal("S3_BUCKET environment variable is not set")
+	}
+
+	s3Region := os.Getenv("S3_REGION")
+	if s3Region == "" {
+		log.Fatal("S3_REGION environment variable is not set")
+	}
+
+	sessionName := os.Getenv("AWS_ASSUME_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "tubely-bucket"
+	}
+	awsCfg, err := processing.LoadAWSConfig(context.Background(), s3Region, processing.AssumeRoleConfig{
+		RoleARN:     os.Getenv("AWS_ASSUME_ROLE_ARN"),
+		ExternalID:  os.Getenv("AWS_ASSUME_ROLE_EXTERNAL_ID"),
+		SessionName: sessionName,
+		Duration:    durationFromEnv("AWS_ASSUME_ROLE_DURATION", time.Hour),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bucketAccess := processing.BucketAccess{
+		ExpectedOwner: os.Getenv("S3_EXPECTED_BUCKET_OWNER"),
+		RequesterPays: boolFromEnv("S3_REQUESTER_PAYS", false),
+		ACL:           types.ObjectCannedACL(os.Getenv("S3_OBJECT_ACL")),
+	}
+	if err := bucketAccess.Validate(); err != nil {
+		log.Fatalf("Invalid bucket access settings: %v", err)
+	}
+
+	setup := processing.BucketSetup{
+		S3Client:                processing.NewS3Client(awsCfg, os.Getenv("S3_ENDPOINT"), bucketAccess),
+		Bucket:                  s3Bucket,
+		AbortMultipartAfterDays: int32(intFromEnv("S3_ABORT_INCOMPLETE_MULTIPART_DAYS", 7)),
+		CORSOrigins:             processing.ParseOrigins(os.Getenv("S3_CORS_ORIGINS")),
+		Versioning:              boolFromEnv("S3_VERSIONING", false),
+		DryRun:                  *dryRun,
+	}
+	if err := setup.Validate(); err != nil {
+		log.Fatalf("Invalid bucket settings: %v", err)
+	}
+
+	changes, err := setup.Apply(context.Background())
+	verb := ""
+	if *dryRun {
+		verb = "would "
+	}
+	for _, change := range changes {
+		log.Printf("%s%s", verb, change)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(changes) == 0 {
+		log.Printf("Bucket %s is already set up", s3Bucket)
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a valid duration: %v", key, err)
+	}
+	return d
+}
+
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", key, err)
+	}
+	return n
+}
+
+func boolFromEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be a boolean: %v", key, err)
+	}
+	return b
+}
@@ -0,0 +1,149 @@
+// Command tubely-migrate-keys moves stored videos to the keys S3_KEY_TEMPLATE
+// gives them, such as after switching from the old orientation prefixes to
+// per-user ones:
+//
+//	S3_KEY_TEMPLATE='{userID}/{videoID}/{random}' tubely-migrate-keys -dry-run
+//
+// It reads the same environment as the API server. Each video is copied to
+// its new key, pointed at it, and only then deleted from its old one, so the
+// command can be stopped and run again at any time. Videos are still served
+// throughout, but run it when few are being uploaded: one replaced while it's
+// being moved is left where it is and reported as failed. With
+// DRY_RUN_DEFAULT=true it only logs the moves unless run with -dry-run=false.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("tubely-migrate-keys: ")
+
+	godotenv.Load(".env")
+
+	dryRun := flag.Bool("dry-run", boolFromEnv("DRY_RUN_DEFAULT", false), "log the moves without making them")
+	flag.Parse()
+
+	pathToDB := os.Getenv("DB_PATH")
+	if pathToDB == "" {
+		log.Fatal("DB_PATH must be set")
+	}
+
+	db, err := database.NewClient(pathToDB)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+
+	s3Bucket := os.Getenv("S3_BUCKET")
+	if s3Bucket == "" {
+		log.Fatal("S3_BUCKET environment variable is not set")
+	}
+
+	s3Region := os.Getenv("S3_REGION")
+	if s3Region == "" {
+		log.Fatal("S3_REGION environment variable is not set")
+	}
+
+	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+	if s3CfDistribution == "" {
+		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	}
+
+	keyTemplate, err := processing.ParseKeyTemplate(os.Getenv("S3_KEY_TEMPLATE"))
+	if err != nil {
+		log.Fatalf("Invalid S3_KEY_TEMPLATE: %v", err)
+	}
+
+	sessionName := os.Getenv("AWS_ASSUME_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "tubely-migrate-keys"
+	}
+	awsCfg, err := processing.LoadAWSConfig(context.Background(), s3Region, processing.AssumeRoleConfig{
+		RoleARN:     os.Getenv("AWS_ASSUME_ROLE_ARN"),
+		ExternalID:  os.Getenv("AWS_ASSUME_ROLE_EXTERNAL_ID"),
+		SessionName: sessionName,
+		Duration:    durationFromEnv("AWS_ASSUME_ROLE_DURATION", time.Hour),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Must match the API server's, since copies are written to the same buckets
+	bucketAccess := processing.BucketAccess{
+		ExpectedOwner: os.Getenv("S3_EXPECTED_BUCKET_OWNER"),
+		RequesterPays: boolFromEnv("S3_REQUESTER_PAYS", false),
+		ACL:           types.ObjectCannedACL(os.Getenv("S3_OBJECT_ACL")),
+	}
+	if err := bucketAccess.Validate(); err != nil {
+		log.Fatalf("Invalid bucket access settings: %v", err)
+	}
+
+	client := processing.NewS3Client(awsCfg, os.Getenv("S3_ENDPOINT"), bucketAccess)
+	migration := processing.KeyMigration{
+		DB:       db,
+		S3Client: client,
+		Buckets: &processing.BucketClients{
+			DB:        db,
+			AWSConfig: awsCfg,
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Default:   client,
+		},
+		Defaults: processing.Storage{Bucket: s3Bucket, BaseURL: s3CfDistribution},
+		Keys:     keyTemplate,
+		DryRun:   *dryRun,
+	}
+
+	// Finish the video being moved on SIGINT/SIGTERM, then stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result, err := migration.Run(ctx)
+	verb := "Moved"
+	if *dryRun {
+		verb = "Would move"
+	}
+	log.Printf("%s %d videos, %d already in place, %d failed", verb, result.Moved, result.Skipped, result.Failed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a valid duration: %v", key, err)
+	}
+	return d
+}
+
+func boolFromEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be a boolean: %v", key, err)
+	}
+	return b
+}
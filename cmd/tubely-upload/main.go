@@ -0,0 +1,351 @@
+// Command tubely-upload uploads a video file, or a stream on stdin, to a
+// Tubely server as a resumable upload, showing its progress. It's meant for
+// scripts and editing pipelines:
+//
+//	tubely-upload -title "Boots" boots.mp4
+//	ffmpeg ... -f mp4 -movflags frag_keyframe+empty_moov - | tubely-upload -video $VIDEO_ID -
+//
+// It logs in with TUBELY_EMAIL and TUBELY_PASSWORD, or uses TUBELY_TOKEN, on
+// the server at TUBELY_URL. On success the video's ID is printed on stdout.
+//
+// An upload of a file that's interrupted, even by the command being killed,
+// carries on from where it stopped when the same command is run again. A
+// stream can be resumed with -resume and the upload ID printed when it
+// started, by piping the same stream in again.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/pkg/tubelyclient"
+	"github.com/google/uuid"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("tubely-upload: ")
+
+	server := flag.String("server", envOr("TUBELY_URL", "http://localhost:8091"), "server to upload to")
+	videoFlag := flag.String("video", "", "ID of the video to upload the file for")
+	title := flag.String("title", "", "create a new video with this title instead of using -video")
+	description := flag.String("description", "", "description of the video created with -title")
+	profile := flag.String("profile", "", "processing profile, your default unless set")
+	notify := flag.String("notify", "", "notification channel for the result (all, email, slack, discord or none), your default unless set")
+	priority := flag.String("priority", "", "processing priority (interactive, normal or bulk), interactive unless set; use bulk for backfills")
+	mediaType := flag.String("type", tubelyclient.DefaultMediaType, "media type of the file")
+	chunkSize := flag.Int("chunk-size", tubelyclient.DefaultChunkSize, "bytes to send per request")
+	retries := flag.Int("retries", tubelyclient.DefaultRetries, "times to retry a chunk after a dropped connection")
+	resume := flag.String("resume", "", "ID of an interrupted upload to carry on with")
+	wait := flag.Bool("wait", false, "wait for the video to be processed")
+	quiet := flag.Bool("quiet", false, "don't show progress")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: tubely-upload [flags] FILE\n\nFILE is - to read from stdin.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*videoFlag == "") == (*title == "") && *resume == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := login(ctx, *server)
+	if err != nil {
+		log.Fatalf("Couldn't log in: %v", err)
+	}
+
+	input, name, err := openInput(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer input.Close()
+
+	opts := tubelyclient.UploadOptions{
+		MediaType: *mediaType,
+		Profile:   *profile,
+		Notify:    *notify,
+		Priority:  *priority,
+		ChunkSize: *chunkSize,
+		Retries:   *retries,
+	}
+	if !*quiet {
+		opts.Progress = newProgressBar(os.Stderr, name).update
+	}
+
+	// Interrupted uploads of a file are remembered so running the same
+	// command again picks them up
+	state := resumeState{}
+	if input != os.Stdin {
+		state, err = loadResumeState(*server, input)
+		if err != nil {
+			log.Printf("Couldn't check for an interrupted upload, starting again: %v", err)
+		}
+	}
+	if *resume != "" {
+		state.UploadID, err = uuid.Parse(*resume)
+		if err != nil {
+			log.Fatalf("Invalid upload ID %q", *resume)
+		}
+	}
+
+	var result tubelyclient.UploadResult
+	if state.UploadID != uuid.Nil {
+		log.Printf("Resuming upload %s", state.UploadID)
+		result, err = client.ResumeUpload(ctx, state.UploadID, input, opts)
+		if *resume == "" && expired(err) {
+			log.Printf("Upload %s has expired, starting again", state.UploadID)
+			state.UploadID = uuid.Nil
+		}
+	}
+	if state.UploadID == uuid.Nil {
+		videoID, videoErr := videoToUpload(ctx, client, *videoFlag, *title, *description)
+		if videoErr != nil {
+			log.Fatal(videoErr)
+		}
+		opts.Started = func(session tubelyclient.UploadSession) {
+			log.Printf("Started upload %s", session.ID)
+			state.UploadID = session.ID
+			if err := state.save(); err != nil {
+				log.Printf("Couldn't save upload state, it won't be resumed automatically: %v", err)
+			}
+		}
+		result, err = client.Upload(ctx, videoID, input, opts)
+	}
+	if !*quiet {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		if state.UploadID != uuid.Nil && resumable(err) {
+			log.Fatalf("Upload failed: %v\nRun again to carry on, or with -resume %s for a stream", err, state.UploadID)
+		}
+		if state.UploadID != uuid.Nil {
+			client.AbortUpload(context.Background(), state.UploadID)
+		}
+		state.forget()
+		log.Fatalf("Upload failed: %v", err)
+	}
+	state.forget()
+
+	video, err := processedVideo(ctx, client, result, *wait)
+	if err != nil {
+		log.Fatalf("Processing failed: %v", err)
+	}
+	fmt.Println(video.ID)
+}
+
+// Function to log in with the token or the email and password from the environment
+func login(ctx context.Context, server string) (*tubelyclient.Client, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Minute}
+	if token := os.Getenv("TUBELY_TOKEN"); token != "" {
+		return tubelyclient.New(server, tubelyclient.WithHTTPClient(httpClient), tubelyclient.WithTokens(token, "")), nil
+	}
+
+	email, password := os.Getenv("TUBELY_EMAIL"), os.Getenv("TUBELY_PASSWORD")
+	if email == "" || password == "" {
+		return nil, errors.New("set TUBELY_TOKEN, or TUBELY_EMAIL and TUBELY_PASSWORD")
+	}
+	client := tubelyclient.New(server, tubelyclient.WithHTTPClient(httpClient))
+	return client, client.Login(ctx, email, password)
+}
+
+// Function to open the file to upload, stdin for "-"
+func openInput(arg string) (*os.File, string, error) {
+	if arg == "-" {
+		return os.Stdin, "stdin", nil
+	}
+	file, err := os.Open(arg)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, filepath.Base(arg), nil
+}
+
+// Function to get the video to upload for, creating it if a title was given
+func videoToUpload(ctx context.Context, client *tubelyclient.Client, videoID, title, description string) (uuid.UUID, error) {
+	if title == "" {
+		id, err := uuid.Parse(videoID)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("invalid video ID %q", videoID)
+		}
+		return id, nil
+	}
+	video, err := client.CreateVideo(ctx, title, description)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("couldn't create video: %w", err)
+	}
+	log.Printf("Created video %s", video.ID)
+	return video.ID, nil
+}
+
+// Function to get the uploaded video, once it's processed if wait is set
+func processedVideo(ctx context.Context, client *tubelyclient.Client, result tubelyclient.UploadResult, wait bool) (tubelyclient.Video, error) {
+	if result.Video != nil {
+		return *result.Video, nil
+	}
+	if !wait {
+		log.Printf("Uploaded, processing as job %s", result.Job.ID)
+		return tubelyclient.Video{ID: result.Job.VideoID}, nil
+	}
+	log.Printf("Uploaded, waiting for job %s", result.Job.ID)
+	return result.Wait(ctx, client, 2*time.Second)
+}
+
+// Function to report whether an upload failed because it no longer exists on the server
+func expired(err error) bool {
+	var apiErr *tubelyclient.Error
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusGone)
+}
+
+// Function to report whether a failed upload can be carried on with later
+func resumable(err error) bool {
+	var apiErr *tubelyclient.Error
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	// Uploads that were complete but couldn't be processed can only start again
+	return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusConflict
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// resumeState remembers the upload of a file, in a file named after the
+// server and the file's path, size and modification time, so a changed file
+// isn't appended to an old upload
+type resumeState struct {
+	UploadID uuid.UUID `json:"upload_id"`
+	path     string
+}
+
+// Function to load the state of an interrupted upload of file, if there is one
+func loadResumeState(server string, file *os.File) (resumeState, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return resumeState{}, err
+	}
+	absPath, err := filepath.Abs(file.Name())
+	if err != nil {
+		return resumeState{}, err
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return resumeState{}, err
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%d\n%d", strings.TrimRight(server, "/"), absPath, info.Size(), info.ModTime().UnixNano())))
+	state := resumeState{path: filepath.Join(cacheDir, "tubely-upload", hex.EncodeToString(key[:16])+".json")}
+	data, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(data, &state)
+}
+
+func (s resumeState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func (s resumeState) forget() {
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+}
+
+// progressBar draws an upload's progress on one line of a terminal
+type progressBar struct {
+	out     io.Writer
+	name    string
+	started time.Time
+	// tty is false when output is redirected, where the bar is drawn a line
+	// at a time no more than every few seconds
+	tty   bool
+	drawn time.Time
+	// resumedAt is where the upload was when it started or resumed, -1
+	// before the first update, so the rate only counts bytes sent now
+	resumedAt int64
+}
+
+func newProgressBar(out *os.File, name string) *progressBar {
+	info, err := out.Stat()
+	tty := err == nil && info.Mode()&os.ModeCharDevice != 0
+	return &progressBar{out: out, name: name, started: time.Now(), tty: tty, resumedAt: -1}
+}
+
+func (p *progressBar) update(sent, total int64) {
+	if p.resumedAt < 0 {
+		p.resumedAt = sent
+	}
+	if !p.tty && time.Since(p.drawn) < 5*time.Second && sent != total {
+		return
+	}
+	p.drawn = time.Now()
+
+	rate := ""
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		rate = formatBytes(int64(float64(sent-p.resumedAt)/elapsed)) + "/s"
+	}
+	line := fmt.Sprintf("%s %s %s", p.name, formatBytes(sent), rate)
+	if total > 0 {
+		const width = 30
+		filled := int(float64(width) * float64(sent) / float64(total))
+		line = fmt.Sprintf("%s [%s%s] %3d%% %s of %s %s", p.name, strings.Repeat("=", filled), strings.Repeat(" ", width-filled),
+			sent*100/total, formatBytes(sent), formatBytes(total), rate)
+	}
+
+	if p.tty {
+		fmt.Fprintf(p.out, "\r\033[K%s", line)
+	} else {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// Function to format a number of bytes for people, like 12.3 MB
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n), ""
+	for _, p := range []string{"kB", "MB", "GB", "TB"} {
+		value /= unit
+		prefix = p
+		if value < unit {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", value, prefix)
+}
@@ -0,0 +1,319 @@
+// Command tubely-worker processes uploaded videos queued by the API server
+// when it runs with PROCESSING_MODE=worker. Any number of workers can share
+// the same database and bucket; a job held by a worker that stops sending
+// heartbeats is put back on the queue for the others.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	godotenv.Load(".env")
+
+	pathToDB := os.Getenv("DB_PATH")
+	if pathToDB == "" {
+		log.Fatal("DB_PATH must be set")
+	}
+
+	db, err := database.NewClient(pathToDB)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
+	}
+
+	s3Bucket := os.Getenv("S3_BUCKET")
+	if s3Bucket == "" {
+		log.Fatal("S3_BUCKET environment variable is not set")
+	}
+
+	s3Region := os.Getenv("S3_REGION")
+	if s3Region == "" {
+		log.Fatal("S3_REGION environment variable is not set")
+	}
+
+	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+	if s3CfDistribution == "" {
+		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	}
+
+	processingDir := os.Getenv("PROCESSING_DIR")
+	if processingDir == "" {
+		processingDir = filepath.Join(os.TempDir(), "tubely-processing")
+	}
+	scratch, err := processing.NewScratch(processing.ParseScratchDirs(processingDir), os.Getenv("PROCESSING_DIR_POLICY"))
+	if err != nil {
+		log.Fatalf("Couldn't set up processing directories: %v", err)
+	}
+	scratchMaxAge := durationFromEnv("SCRATCH_MAX_AGE", 24*time.Hour)
+	scratchSweepInterval := durationFromEnv("SCRATCH_SWEEP_INTERVAL", time.Hour)
+	if scratchMaxAge > 0 && scratchSweepInterval <= 0 {
+		log.Fatal("SCRATCH_SWEEP_INTERVAL must be positive")
+	}
+
+	workerID := os.Getenv("WORKER_ID")
+	if workerID == "" {
+		hostname, _ := os.Hostname()
+		workerID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	pollInterval := durationFromEnv("WORKER_POLL_INTERVAL", 2*time.Second)
+	heartbeatInterval := durationFromEnv("WORKER_HEARTBEAT_INTERVAL", 10*time.Second)
+	staleAfter := durationFromEnv("WORKER_STALE_AFTER", time.Minute)
+	if staleAfter <= heartbeatInterval {
+		log.Fatal("WORKER_STALE_AFTER must be longer than WORKER_HEARTBEAT_INTERVAL")
+	}
+
+	// Interactive jobs are claimed most often, but bulk ones still get a turn
+	priorityWeights, err := processing.ParsePriorityWeights(os.Getenv("JOB_PRIORITY_WEIGHTS"))
+	if err != nil {
+		log.Fatalf("Invalid JOB_PRIORITY_WEIGHTS: %v", err)
+	}
+
+	// Load AWS SDK config, assuming an IAM role if one is configured
+	sessionName := os.Getenv("AWS_ASSUME_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "tubely-worker"
+	}
+	awsCfg, err := processing.LoadAWSConfig(context.Background(), s3Region, processing.AssumeRoleConfig{
+		RoleARN:     os.Getenv("AWS_ASSUME_ROLE_ARN"),
+		ExternalID:  os.Getenv("AWS_ASSUME_ROLE_EXTERNAL_ID"),
+		SessionName: sessionName,
+		Duration:    durationFromEnv("AWS_ASSUME_ROLE_DURATION", time.Hour),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Optionally inject faults into AWS and transcoder calls to rehearse outages
+	chaos := processing.Chaos{
+		ErrorRate:   floatFromEnv("CHAOS_ERROR_RATE", 0),
+		LatencyRate: floatFromEnv("CHAOS_LATENCY_RATE", 0),
+		MaxLatency:  durationFromEnv("CHAOS_MAX_LATENCY", 0),
+	}
+	if err := chaos.Validate(); err != nil {
+		log.Fatalf("Invalid chaos settings: %v", err)
+	}
+	if chaos.Enabled() {
+		log.Printf("Chaos mode enabled: %.0f%% of calls fail, %.0f%% are delayed up to %s",
+			chaos.ErrorRate*100, chaos.LatencyRate*100, chaos.MaxLatency)
+	}
+	awsCfg.HTTPClient = chaos.WrapHTTPClient(awsCfg.HTTPClient)
+
+	// Must match the API server's, since both write to the same buckets
+	bucketAccess := processing.BucketAccess{
+		ExpectedOwner: os.Getenv("S3_EXPECTED_BUCKET_OWNER"),
+		RequesterPays: boolFromEnv("S3_REQUESTER_PAYS", false),
+		ACL:           types.ObjectCannedACL(os.Getenv("S3_OBJECT_ACL")),
+	}
+	if err := bucketAccess.Validate(); err != nil {
+		log.Fatalf("Invalid bucket access settings: %v", err)
+	}
+
+	// Select the transcoder backend for this deployment
+	transcoder, err := processing.NewTranscoder(os.Getenv("TRANSCODER"), awsCfg, processing.FFmpegConfig{
+		Output: os.Getenv("FFMPEG_OUTPUT"),
+	}, processing.MediaConvertConfig{
+		Endpoint:     os.Getenv("MEDIACONVERT_ENDPOINT"),
+		RoleARN:      os.Getenv("MEDIACONVERT_ROLE_ARN"),
+		Queue:        os.Getenv("MEDIACONVERT_QUEUE"),
+		Bucket:       s3Bucket,
+		PollInterval: durationFromEnv("MEDIACONVERT_POLL_INTERVAL", 10*time.Second),
+		// The API server takes the callback and finishes the job
+		Callbacks: os.Getenv("TRANSCODER_WEBHOOK_SECRET") != "",
+	})
+	if err != nil {
+		log.Fatalf("Couldn't set up transcoder: %v", err)
+	}
+	transcoder = chaos.WrapTranscoder(transcoder)
+
+	// ffmpeg and ffprobe invocations, optionally customized by a JSON file
+	commands, err := processing.LoadCommands(os.Getenv("FFMPEG_COMMANDS_FILE"))
+	if err != nil {
+		log.Fatalf("Couldn't load ffmpeg commands: %v", err)
+	}
+	processing.UseCommands(commands)
+
+	// Encode on the GPU when one is usable, falling back to software otherwise
+	accel, err := processing.DetectAccelerator(os.Getenv("HWACCEL"), os.Getenv("VAAPI_DEVICE"))
+	if err != nil {
+		log.Printf("Hardware acceleration unavailable, encoding in software: %v", err)
+	} else if accel.Name != "" {
+		log.Printf("Encoding with %s", accel.Encoder)
+	}
+	processing.UseAccelerator(accel)
+
+	// Large uploads interrupted by a crash carry on from their last part
+	processing.UseMultipartState(db)
+
+	// Profiles must match the API server's so every job's profile is known
+	profiles, err := processing.LoadProfiles(os.Getenv("PROCESSING_PROFILES_FILE"), os.Getenv("PROCESSING_DEFAULT_PROFILE"))
+	if err != nil {
+		log.Fatalf("Couldn't load processing profiles: %v", err)
+	}
+
+	stagingPrefix := os.Getenv("S3_STAGING_PREFIX")
+	if stagingPrefix == "" {
+		stagingPrefix = "staging"
+	}
+
+	// S3-compatible stores may not read their own writes straight away, so stored
+	// videos are looked for before they're handed out. AWS doesn't need it.
+	visibility := processing.VisibilityCheck{}
+	if boolFromEnv("S3_VERIFY_WRITES", os.Getenv("S3_ENDPOINT") != "") {
+		visibility.Attempts = intFromEnv("S3_VERIFY_WRITE_ATTEMPTS", processing.DefaultVisibilityAttempts)
+	}
+
+	// Users' own buckets are reached by assuming the role each one registered
+	client := processing.NewS3Client(awsCfg, os.Getenv("S3_ENDPOINT"), bucketAccess)
+	buckets := &processing.BucketClients{
+		DB:        db,
+		AWSConfig: awsCfg,
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		Default:   client,
+	}
+
+	// Owners hear when their videos finish, linked to the server's watch page when its URL is known
+	notifier := &notify.Notifier{DB: db}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		notifier.SMTP = &notify.SMTP{
+			Host:     host,
+			Port:     stringFromEnv("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     stringFromEnv("SMTP_FROM", "tubely@localhost"),
+		}
+	}
+	if baseURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/"); baseURL != "" {
+		notifier.VideoURL = func(videoID uuid.UUID) string {
+			return baseURL + "/v/" + videoID.String()
+		}
+	}
+
+	// Cached copies of a video's pages are dropped once it points at its processed file
+	var purger *cdn.Purger
+	if purgeURL := os.Getenv("CDN_PURGE_URL"); purgeURL != "" {
+		purger = &cdn.Purger{
+			URL:        purgeURL,
+			Token:      os.Getenv("CDN_PURGE_TOKEN"),
+			HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	worker := &processing.Worker{
+		Processor: &processing.Processor{
+			DB:             db,
+			S3Client:       client,
+			S3Bucket:       s3Bucket,
+			CfDistribution: s3CfDistribution,
+			Scratch:        scratch,
+			StagingPrefix:  stagingPrefix,
+			Transcoder:     transcoder,
+			Profiles:       profiles,
+			Buckets:        buckets,
+			Notifier:       notifier,
+			Purger:         purger,
+			Retry: processing.RetryPolicy{
+				MaxAttempts: intFromEnv("JOB_MAX_ATTEMPTS", processing.MaxJobAttempts),
+				Backoff:     durationFromEnv("JOB_RETRY_BACKOFF", 30*time.Second),
+				MaxBackoff:  durationFromEnv("JOB_RETRY_MAX_BACKOFF", 30*time.Minute),
+			},
+			EncryptHLS: boolFromEnv("HLS_ENCRYPTION", false),
+			Visibility: visibility,
+		},
+		ID:                workerID,
+		PollInterval:      pollInterval,
+		HeartbeatInterval: heartbeatInterval,
+		StaleAfter:        staleAfter,
+		Weights:           priorityWeights,
+	}
+
+	// Stop claiming new jobs on SIGINT/SIGTERM, finishing the current one
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Sweep away scratch files left behind by crashes
+	if scratchMaxAge > 0 {
+		watchdog := &processing.ScratchWatchdog{
+			Scratch:  scratch,
+			DB:       db,
+			MaxAge:   scratchMaxAge,
+			Interval: scratchSweepInterval,
+		}
+		go watchdog.Run(ctx)
+	}
+
+	worker.Run(ctx)
+}
+
+func stringFromEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a valid duration: %v", key, err)
+	}
+	return d
+}
+
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Fatalf("%s must be a positive integer", key)
+	}
+	return n
+}
+
+func boolFromEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be a boolean: %v", key, err)
+	}
+	return b
+}
+
+func floatFromEnv(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number: %v", key, err)
+	}
+	return f
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Content codings the server can compress with, most preferred first
+var compressionEncodings = []string{"gzip", "deflate"}
+
+// Compressors are reused, as each holds several hundred KB of state
+var compressorPools = map[string]*sync.Pool{
+	"gzip": {New: func() any { return gzip.NewWriter(io.Discard) }},
+	// HTTP's deflate is the zlib format, not raw DEFLATE
+	"deflate": {New: func() any { return zlib.NewWriter(io.Discard) }},
+}
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Function to compress text responses, such as JSON, feeds and the pages'
+// HTML, for clients that accept gzip or deflate. Media, images and other
+// bodies that are already compressed are sent as they are.
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressResponseWriter{
+			ResponseWriter: w,
+			encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding")),
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Function to pick the content coding to respond with from Accept-Encoding,
+// "" for none. Codings with q=0 are refused, and otherwise the server's
+// preference wins.
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, encoding := range compressionEncodings {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// Function to report whether responses of a media type are worth compressing
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/vnd.apple.mpegurl":
+		return true
+	}
+	return false
+}
+
+// compressResponseWriter decides when the handler writes the header whether
+// to compress the body, as only then is its type known
+type compressResponseWriter struct {
+	http.ResponseWriter
+	// encoding is the client's choice of coding, "" when it accepts none
+	encoding    string
+	compressor  compressor
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	// Informational responses come before the real one
+	if w.wroteHeader || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	if compressibleType(header.Get("Content-Type")) {
+		// The body depends on Accept-Encoding, even when it's sent as it is
+		header.Add("Vary", "Accept-Encoding")
+		if w.encoding != "" && header.Get("Content-Encoding") == "" && bodyAllowed(status) {
+			header.Set("Content-Encoding", w.encoding)
+			// The length changes, and ranges would be of the compressed body
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			w.compressor = compressorPools[w.encoding].Get().(compressor)
+			w.compressor.Reset(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.compressor != nil {
+		return w.compressor.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Function to send what's been compressed so far, for streamed responses
+func (w *compressResponseWriter) Flush() {
+	if w.compressor != nil {
+		w.compressor.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Function to let http.ResponseController reach the wrapped writer
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Function to finish the compressed body and return the compressor to its pool
+func (w *compressResponseWriter) close() {
+	if w.compressor == nil {
+		return
+	}
+	w.compressor.Close()
+	w.compressor.Reset(io.Discard)
+	compressorPools[w.encoding].Put(w.compressor)
+	w.compressor = nil
+}
+
+// Function to report whether a response with a status has a body to compress
+func bodyAllowed(status int) bool {
+	switch {
+	case status == http.StatusNoContent, status == http.StatusNotModified, status == http.StatusPartialContent:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of thing a destructive request removes
+const (
+	removalObject  = "object"
+	removalFile    = "file"
+	removalVideo   = "video"
+	removalClip    = "clip"
+	removalAccount = "account"
+	removalData    = "database"
+)
+
+// removalPlan is what a destructive request removes. A dry run returns it
+// without removing anything.
+type removalPlan struct {
+	DryRun  bool      `json:"dry_run"`
+	Removes []removal `json:"removes"`
+}
+
+// removal is one thing a destructive request removes: an object in a
+// bucket, a file on disk, or database records
+type removal struct {
+	Kind   string     `json:"kind"`
+	ID     *uuid.UUID `json:"id,omitempty"`
+	Bucket string     `json:"bucket,omitempty"`
+	Key    string     `json:"key,omitempty"`
+	Path   string     `json:"path,omitempty"`
+	// Records counts the database records removed from each table
+	Records map[string]int `json:"records,omitempty"`
+}
+
+func (p *removalPlan) add(r removal) {
+	if p != nil {
+		p.Removes = append(p.Removes, r)
+	}
+}
+
+// Function to tell whether a destructive operation only goes as far as
+// planning. A nil plan, for operations nobody asked to see, always runs.
+func (p *removalPlan) dryRun() bool {
+	return p != nil && p.DryRun
+}
+
+// Function to read ?dryRun=, falling back to DRY_RUN_DEFAULT, writing the
+// error if it isn't a boolean
+func (cfg *apiConfig) parseDryRun(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	value := r.URL.Query().Get("dryRun")
+	if value == "" {
+		return cfg.dryRunDefault, true
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid query", nil, []fieldError{{
+			Field:   "dryRun",
+			Message: "must be true or false",
+		}})
+		return false, false
+	}
+	return dryRun, true
+}
+
+// Function to make a plan for a destructive request, writing the error if
+// ?dryRun= is invalid
+func (cfg *apiConfig) newRemovalPlan(w http.ResponseWriter, r *http.Request) (*removalPlan, bool) {
+	dryRun, ok := cfg.parseDryRun(w, r)
+	if !ok {
+		return nil, false
+	}
+	return &removalPlan{DryRun: dryRun, Removes: []removal{}}, true
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Machine-readable error codes, stable across releases so clients can branch on them
+type errorCode string
+
+const (
+	errCodeBadRequest        errorCode = "BAD_REQUEST"
+	errCodeUnauthorized      errorCode = "UNAUTHORIZED"
+	errCodeForbidden         errorCode = "FORBIDDEN"
+	errCodeNotFound          errorCode = "NOT_FOUND"
+	errCodeConflict          errorCode = "CONFLICT"
+	errCodeExpired           errorCode = "EXPIRED"
+	errCodeInternal          errorCode = "INTERNAL_ERROR"
+	errCodeInvalidMediaType  errorCode = "INVALID_MEDIA_TYPE"
+	errCodeInvalidDuration   errorCode = "INVALID_DURATION"
+	errCodeFileTooLarge      errorCode = "FILE_TOO_LARGE"
+	errCodeLengthRequired    errorCode = "LENGTH_REQUIRED"
+	errCodeQuotaExceeded     errorCode = "QUOTA_EXCEEDED"
+	errCodeValidationFailed  errorCode = "VALIDATION_FAILED"
+	errCodeProcessingFailed  errorCode = "PROCESSING_FAILED"
+	errCodeProcessingTimeout errorCode = "PROCESSING_TIMEOUT"
+	errCodeReadOnly          errorCode = "READ_ONLY"
+	errCodeMaintenance       errorCode = "MAINTENANCE"
+	errCodeGeoRestricted     errorCode = "GEO_RESTRICTED"
+	errCodePasswordRequired  errorCode = "PASSWORD_REQUIRED"
+	errCodeMalwareDetected   errorCode = "MALWARE_DETECTED"
+	errCodeNotInPlan         errorCode = "NOT_IN_PLAN"
+	errCodeArchived          errorCode = "ARCHIVED"
+	errCodePrecondition      errorCode = "PRECONDITION_FAILED"
+	errCodeCancelled         errorCode = "CANCELLED"
+	errCodeRateLimited       errorCode = "RATE_LIMITED"
+)
+
+// HTTP status returned for each error code
+var errorCodeStatus = map[errorCode]int{
+	errCodeBadRequest:        http.StatusBadRequest,
+	errCodeUnauthorized:      http.StatusUnauthorized,
+	errCodeForbidden:         http.StatusForbidden,
+	errCodeNotFound:          http.StatusNotFound,
+	errCodeConflict:          http.StatusConflict,
+	errCodeExpired:           http.StatusGone,
+	errCodeInternal:          http.StatusInternalServerError,
+	errCodeInvalidMediaType:  http.StatusUnsupportedMediaType,
+	errCodeInvalidDuration:   http.StatusUnprocessableEntity,
+	errCodeFileTooLarge:      http.StatusRequestEntityTooLarge,
+	errCodeLengthRequired:    http.StatusLengthRequired,
+	errCodeQuotaExceeded:     http.StatusForbidden,
+	errCodeValidationFailed:  http.StatusBadRequest,
+	errCodeProcessingFailed:  http.StatusInternalServerError,
+	errCodeProcessingTimeout: http.StatusGatewayTimeout,
+	errCodeReadOnly:          http.StatusServiceUnavailable,
+	errCodeMaintenance:       http.StatusServiceUnavailable,
+	errCodeGeoRestricted:     http.StatusUnavailableForLegalReasons,
+	errCodePasswordRequired:  http.StatusUnauthorized,
+	errCodeMalwareDetected:   http.StatusUnprocessableEntity,
+	errCodeNotInPlan:         http.StatusForbidden,
+	errCodeArchived:          http.StatusConflict,
+	errCodePrecondition:      http.StatusPreconditionFailed,
+	errCodeCancelled:         http.StatusConflict,
+	errCodeRateLimited:       http.StatusTooManyRequests,
+}
+
+// fieldError points at a single invalid field in a request
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// apiError is the body of every error response
+type apiError struct {
+	Error     string       `json:"error"`
+	Code      errorCode    `json:"code"`
+	Details   []fieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// Function to return the HTTP status for an error code
+func (code errorCode) status() int {
+	if status, ok := errorCodeStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Function to pick a generic error code for handlers that only supply a status
+func errorCodeForStatus(status int) errorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return errCodeBadRequest
+	case http.StatusUnauthorized:
+		return errCodeUnauthorized
+	case http.StatusForbidden:
+		return errCodeForbidden
+	case http.StatusNotFound:
+		return errCodeNotFound
+	case http.StatusConflict:
+		return errCodeConflict
+	case http.StatusGone:
+		return errCodeExpired
+	case http.StatusUnsupportedMediaType:
+		return errCodeInvalidMediaType
+	case http.StatusLengthRequired:
+		return errCodeLengthRequired
+	case http.StatusRequestEntityTooLarge:
+		return errCodeFileTooLarge
+	default:
+		return errCodeInternal
+	}
+}
+
+// requestError is an error from a helper shared between handlers that knows
+// how it should be reported to the client
+type requestError struct {
+	code errorCode
+	msg  string
+	err  error
+}
+
+func (e *requestError) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// Function to create a requestError reported with the given code
+func newRequestError(code errorCode, msg string, err error) *requestError {
+	return &requestError{code: code, msg: msg, err: err}
+}
+
+// Function to report an error from a shared helper, as a 500 unless it's a requestError
+func respondWithRequestError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		respondWithErrorCode(w, reqErr.code, reqErr.msg, reqErr.err, nil)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Function to get the ETag of a video's metadata. It changes with every
+// update of the video, but not when it's liked or added to a list.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%s.%d"`, video.ID, video.Revision)
+}
+
+// Function to check a request's If-Match header against an ETag, true when
+// it has none. Weak ETags never match, as If-Match compares strongly.
+func ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
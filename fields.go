@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// JSON fields of a video, which ?fields= can choose from
+var videoFieldNames = jsonFieldNames(database.Video{})
+
+// JSON fields of a video as its owner sees it
+var ownedVideoFieldNames = jsonFieldNames(ownedVideo{})
+
+// fieldSelection is the set of JSON fields a client asked for with ?fields=,
+// nil when it didn't ask, for every field
+type fieldSelection map[string]bool
+
+// Function to read ?fields=, a comma-separated list of the JSON fields to respond with.
+// The id is always included, so the items of a list can be told apart.
+func parseFieldSelection(r *http.Request, available []string) (fieldSelection, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+
+	selection := fieldSelection{"id": true}
+	unknown := []string{}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(available, name) {
+			unknown = append(unknown, name)
+			continue
+		}
+		selection[name] = true
+	}
+	if len(unknown) > 0 {
+		return nil, newRequestError(errCodeValidationFailed, fmt.Sprintf("Unknown fields: %s (valid fields: %s)",
+			strings.Join(unknown, ", "), strings.Join(available, ", ")), nil)
+	}
+	return selection, nil
+}
+
+// Function to report whether a field is to be sent, so handlers can skip loading it
+func (s fieldSelection) has(name string) bool {
+	return s == nil || s[name]
+}
+
+// Function to report whether any field holding a video's or thumbnail's URL is selected
+func (s fieldSelection) hasURLs() bool {
+	return s.has("video_url") || s.has("thumbnail_url") || s.has("thumbnail_variants")
+}
+
+// Function to respond with only the selected fields of a JSON object
+func respondWithFields(w http.ResponseWriter, code int, payload any, fields fieldSelection) {
+	data, err := fields.apply(payload)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode response", err)
+		return
+	}
+	respondWithJSON(w, code, data)
+}
+
+// Function to respond with a page of a list, keeping only the selected fields of each item
+func respondWithFieldsList(w http.ResponseWriter, code int, items any, page listPage, fields fieldSelection) {
+	data, err := fields.apply(items)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode response", err)
+		return
+	}
+	respondWithList(w, code, data, page)
+}
+
+// Function to keep only the selected fields of a JSON object, or of each object in a list
+func (s fieldSelection) apply(payload any) (any, error) {
+	if s == nil {
+		return payload, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("[")) {
+		objects := []map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			s.filter(object)
+		}
+		return objects, nil
+	}
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	s.filter(object)
+	return object, nil
+}
+
+// Function to remove the fields that weren't selected from a decoded JSON object
+func (s fieldSelection) filter(object map[string]json.RawMessage) {
+	for name := range object {
+		if !s[name] {
+			delete(object, name)
+		}
+	}
+}
+
+// Function to list the JSON field names a struct encodes with, sorted
+func jsonFieldNames(value any) []string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		panic(err)
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
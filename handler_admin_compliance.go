@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Number of objects checked against storage at once
+const complianceConcurrency = 8
+
+// Problems a compliance check can find with a stored object
+const (
+	complianceMissing            = "missing"
+	complianceUnreadable         = "unreadable"
+	complianceSizeMismatch       = "size_mismatch"
+	complianceUnencrypted        = "unencrypted"
+	complianceEncryptionMismatch = "encryption_mismatch"
+	complianceUntracked          = "untracked"
+)
+
+// Encryption reported for objects that aren't in a bucket
+const (
+	encryptionNone  = "none"
+	encryptionLocal = "local"
+)
+
+// objectAudit is what storage reports about an object, next to what the ledger recorded
+type objectAudit struct {
+	UserID  uuid.UUID     `json:"user_id"`
+	VideoID uuid.NullUUID `json:"video_id"`
+	Kind    string        `json:"kind"`
+	Bucket  string        `json:"bucket"`
+	Key     string        `json:"key"`
+	// RecordedBytes is null for objects the ledger doesn't know about
+	RecordedBytes *int64 `json:"recorded_bytes"`
+	// StoredBytes is null when the object couldn't be read
+	StoredBytes  *int64   `json:"stored_bytes"`
+	Encryption   string   `json:"encryption"`
+	KMSKeyID     string   `json:"kms_key_id,omitempty"`
+	StorageClass string   `json:"storage_class,omitempty"`
+	Issues       []string `json:"issues"`
+	Error        string   `json:"error,omitempty"`
+}
+
+type complianceReport struct {
+	CheckedAt      time.Time      `json:"checked_at"`
+	Objects        int            `json:"objects"`
+	Bytes          int64          `json:"bytes"`
+	ByEncryption   map[string]int `json:"by_encryption"`
+	ByStorageClass map[string]int `json:"by_storage_class"`
+	Discrepancies  []objectAudit  `json:"discrepancies"`
+}
+
+// Audits every live object in the storage ledger, along with uploaded videos
+// the ledger is missing, and reports the ones that don't match
+func (cfg *apiConfig) handlerAdminCompliance(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+	requiredSSE, ok := parseRequiredSSE(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	objects, err := cfg.db.GetStoredObjects(uuid.Nil, now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve stored objects", err)
+		return
+	}
+	videos, err := cfg.db.GetUploadedVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	audits := cfg.auditObjects(r.Context(), objects, videos, requiredSSE)
+	report := complianceReport{
+		CheckedAt:      now,
+		ByEncryption:   map[string]int{},
+		ByStorageClass: map[string]int{},
+		Discrepancies:  []objectAudit{},
+	}
+	for _, audit := range audits {
+		report.Objects++
+		if audit.StoredBytes != nil {
+			report.Bytes += *audit.StoredBytes
+		}
+		if audit.Encryption != "" {
+			report.ByEncryption[audit.Encryption]++
+		}
+		if audit.StorageClass != "" {
+			report.ByStorageClass[audit.StorageClass]++
+		}
+		if len(audit.Issues) > 0 {
+			report.Discrepancies = append(report.Discrepancies, audit)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func (cfg *apiConfig) handlerAdminVideoCompliance(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID uuid.UUID `json:"video_id"`
+		// Encrypted is true when every object stored in a bucket for the video is encrypted at rest
+		Encrypted bool          `json:"encrypted"`
+		Compliant bool          `json:"compliant"`
+		Objects   []objectAudit `json:"objects"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+	requiredSSE, ok := parseRequiredSSE(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	userObjects, err := cfg.db.GetStoredObjects(video.UserID, time.Now().UTC())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve stored objects", err)
+		return
+	}
+	objects := []database.StoredObject{}
+	for _, object := range userObjects {
+		if object.VideoID.Valid && object.VideoID.UUID == video.ID {
+			objects = append(objects, object)
+		}
+	}
+	videos := []database.Video{}
+	if video.VideoURL != nil {
+		videos = append(videos, video)
+	}
+
+	resp := response{
+		VideoID:   video.ID,
+		Encrypted: true,
+		Compliant: true,
+		Objects:   cfg.auditObjects(r.Context(), objects, videos, requiredSSE),
+	}
+	for _, audit := range resp.Objects {
+		// Objects that couldn't be read can't be shown to be encrypted
+		if audit.Encryption == "" || audit.Encryption == encryptionNone {
+			resp.Encrypted = false
+		}
+		if len(audit.Issues) > 0 {
+			resp.Compliant = false
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// Function to read the server-side encryption every object is required to use,
+// empty when any encryption will do
+func parseRequiredSSE(w http.ResponseWriter, r *http.Request) (string, bool) {
+	sse := r.URL.Query().Get("sse")
+	if sse == "" {
+		return "", true
+	}
+	if !slices.Contains(types.ServerSideEncryption("").Values(), types.ServerSideEncryption(sse)) {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid compliance check", nil, []fieldError{{
+			Field:   "sse",
+			Message: "must be AES256, aws:kms or aws:kms:dsse",
+		}})
+		return "", false
+	}
+	return sse, true
+}
+
+// Function to check live ledger entries against storage, along with the stored
+// files of the given videos that the ledger has no entry for
+func (cfg *apiConfig) auditObjects(ctx context.Context, objects []database.StoredObject, videos []database.Video, requiredSSE string) []objectAudit {
+	audits := []objectAudit{}
+	tracked := map[[2]string]bool{}
+	for _, object := range objects {
+		if object.DeletedAt != nil {
+			continue
+		}
+		tracked[[2]string{object.Bucket, object.Key}] = true
+		recorded := object.Bytes
+		audits = append(audits, objectAudit{
+			UserID:        object.UserID,
+			VideoID:       object.VideoID,
+			Kind:          object.Kind,
+			Bucket:        object.Bucket,
+			Key:           object.Key,
+			RecordedBytes: &recorded,
+		})
+	}
+	for _, video := range videos {
+		bucket, key, err := cfg.getVideoLocation(video)
+		if err != nil || tracked[[2]string{bucket, key}] {
+			continue
+		}
+		audits = append(audits, objectAudit{
+			UserID:  video.UserID,
+			VideoID: uuid.NullUUID{UUID: video.ID, Valid: true},
+			Kind:    database.StorageKindVideo,
+			Bucket:  bucket,
+			Key:     key,
+		})
+	}
+
+	// Each check is a round trip to the bucket, so a few run at once
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range complianceConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				cfg.auditObject(ctx, &audits[i], requiredSSE)
+			}
+		}()
+	}
+	for i := range audits {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return audits
+}
+
+// Function to fill in what storage reports about one object and the issues found with it
+func (cfg *apiConfig) auditObject(ctx context.Context, audit *objectAudit, requiredSSE string) {
+	audit.Issues = []string{}
+	if audit.RecordedBytes == nil {
+		audit.Issues = append(audit.Issues, complianceUntracked)
+	}
+
+	// Files in the local assets directory have no encryption to report
+	if audit.Bucket == "" {
+		audit.Encryption = encryptionLocal
+		info, err := os.Stat(cfg.getAssetDiskPath(audit.Key))
+		if errors.Is(err, fs.ErrNotExist) {
+			audit.Issues = append(audit.Issues, complianceMissing)
+			return
+		}
+		if err != nil {
+			audit.Issues = append(audit.Issues, complianceUnreadable)
+			audit.Error = err.Error()
+			return
+		}
+		size := info.Size()
+		audit.StoredBytes = &size
+	} else {
+		client, err := cfg.getS3Client(audit.Bucket)
+		if err != nil {
+			audit.Issues = append(audit.Issues, complianceUnreadable)
+			audit.Error = err.Error()
+			return
+		}
+		head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(audit.Bucket),
+			Key:    aws.String(audit.Key),
+		})
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			audit.Issues = append(audit.Issues, complianceMissing)
+			return
+		}
+		if err != nil {
+			audit.Issues = append(audit.Issues, complianceUnreadable)
+			audit.Error = err.Error()
+			return
+		}
+		audit.StoredBytes = head.ContentLength
+		audit.KMSKeyID = aws.ToString(head.SSEKMSKeyId)
+
+		// S3 leaves out the storage class for STANDARD objects
+		audit.StorageClass = string(head.StorageClass)
+		if audit.StorageClass == "" {
+			audit.StorageClass = string(types.StorageClassStandard)
+		}
+		audit.Encryption = string(head.ServerSideEncryption)
+		switch {
+		case audit.Encryption == "":
+			audit.Encryption = encryptionNone
+			audit.Issues = append(audit.Issues, complianceUnencrypted)
+		case requiredSSE != "" && audit.Encryption != requiredSSE:
+			audit.Issues = append(audit.Issues, complianceEncryptionMismatch)
+		}
+	}
+
+	if audit.RecordedBytes != nil && audit.StoredBytes != nil && *audit.RecordedBytes != *audit.StoredBytes {
+		audit.Issues = append(audit.Issues, complianceSizeMismatch)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Page sizes of the list of dead letters
+const (
+	defaultDeadLetterPageSize = 50
+	maxDeadLetterPageSize     = 200
+)
+
+// Function to list the jobs that failed for good, newest first, so
+// operators can see what's failing without digging through logs
+func (cfg *apiConfig) handlerAdminDeadLettersList(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	query := r.URL.Query()
+	details := []fieldError{}
+	filter := database.DeadLetterFilter{}
+	if value := query.Get("user_id"); value != "" {
+		if err := filter.UserID.Scan(value); err != nil {
+			details = append(details, fieldError{Field: "user_id", Message: "must be a user ID"})
+		}
+	}
+	if value := query.Get("retried"); value != "" {
+		retried, err := strconv.ParseBool(value)
+		if err != nil {
+			details = append(details, fieldError{Field: "retried", Message: "must be true or false"})
+		}
+		filter.Retried = &retried
+	}
+	var cursor uuid.NullUUID
+	if value := query.Get("cursor"); value != "" {
+		if err := cursor.Scan(value); err != nil {
+			details = append(details, fieldError{Field: "cursor", Message: "must be a next_cursor from an earlier page"})
+		}
+	}
+	limit := defaultDeadLetterPageSize
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxDeadLetterPageSize {
+			details = append(details, fieldError{
+				Field:   "limit",
+				Message: fmt.Sprintf("must be between 1 and %d", maxDeadLetterPageSize),
+			})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid query", nil, details)
+		return
+	}
+
+	// Fetch one extra dead letter to know whether there's another page
+	deadLetters, err := cfg.db.GetDeadLetters(filter, cursor, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve dead letters", err)
+		return
+	}
+	total, err := cfg.db.CountDeadLetters(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count dead letters", err)
+		return
+	}
+	page := listPage{Total: total, v1Field: "dead_letters"}
+	if len(deadLetters) > limit {
+		deadLetters = deadLetters[:limit]
+		page.NextCursor = deadLetters[limit-1].ID.String()
+	}
+
+	respondWithList(w, http.StatusOK, deadLetters, page)
+}
+
+func (cfg *apiConfig) handlerAdminDeadLetterGet(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	deadLetter, ok := cfg.getDeadLetter(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deadLetter)
+}
+
+// Function to put a dead letter's job back on the queue, from the start,
+// once whatever made it fail has been fixed
+func (cfg *apiConfig) handlerAdminDeadLetterRetry(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	deadLetter, ok := cfg.getDeadLetter(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := cfg.processor.RetryDeadLetter(deadLetter)
+	switch {
+	case errors.Is(err, processing.ErrDeadLetterRetried),
+		errors.Is(err, processing.ErrSourceNotKept),
+		errors.Is(err, processing.ErrJobNotFailed),
+		errors.Is(err, processing.ErrVideoGone):
+		respondWithErrorCode(w, errCodeConflict, fmt.Sprintf("Couldn't retry dead letter: %v", err), err, nil)
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retry dead letter", err)
+		return
+	}
+
+	cfg.startAsyncJob(job)
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// Function to delete a dead letter once it's been dealt with, along with
+// the upload kept for retrying it
+func (cfg *apiConfig) handlerAdminDeadLetterDelete(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	deadLetter, ok := cfg.getDeadLetter(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.processor.DeleteDeadLetter(context.WithoutCancel(r.Context()), deadLetter); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete dead letter", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to look up the dead letter a request's path names, responding
+// with an error if there isn't one
+func (cfg *apiConfig) getDeadLetter(w http.ResponseWriter, r *http.Request) (database.DeadLetter, bool) {
+	id, err := uuid.Parse(r.PathValue("deadLetterID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid dead letter ID", err)
+		return database.DeadLetter{}, false
+	}
+	deadLetter, err := cfg.db.GetDeadLetter(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dead letter", err)
+		return database.DeadLetter{}, false
+	}
+	if deadLetter.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Dead letter not found", nil)
+		return database.DeadLetter{}, false
+	}
+	return deadLetter, true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Gets the deployment's upload defaults, which apply to whatever users haven't set themselves
+func (cfg *apiConfig) handlerAdminUploadDefaultsGet(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	defaults, err := cfg.db.GetUploadDefaults(uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload defaults", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, defaults)
+}
+
+func (cfg *apiConfig) handlerAdminUploadDefaultsSet(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	params := database.SetUploadDefaultsParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Plans are checked at upload time, since the default applies to every plan
+	details := cfg.validateUploadDefaults(&params, func(name string) error {
+		_, err := cfg.resolveProfile(name)
+		return err
+	})
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid upload defaults", nil, details)
+		return
+	}
+
+	defaults, err := cfg.db.SetUploadDefaults(uuid.Nil, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload defaults", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, defaults)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+type diskReport struct {
+	Assets   processing.DiskUsage      `json:"assets"`
+	Scratch  []processing.DiskUsage    `json:"scratch"`
+	Watchdog *processing.WatchdogStats `json:"watchdog"`
+}
+
+func (cfg *apiConfig) handlerAdminDisk(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	assets, err := processing.MeasureDir(cfg.assetsRoot)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't measure assets directory", err)
+		return
+	}
+	scratch, err := cfg.scratch.Usage()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't measure processing directories", err)
+		return
+	}
+
+	report := diskReport{
+		Assets:  assets,
+		Scratch: scratch,
+	}
+	if cfg.watchdog != nil {
+		stats := cfg.watchdog.Stats()
+		report.Watchdog = &stats
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// Number of checks the fixity report lists by default, and at most
+const (
+	defaultFixityReportSize = 100
+	maxFixityReportSize     = 1000
+)
+
+// Check outcomes that need looking into, the ones the report lists unless asked for others
+var fixityProblems = []string{
+	database.FixityStatusMismatch,
+	database.FixityStatusMissing,
+	database.FixityStatusError,
+}
+
+func (cfg *apiConfig) handlerAdminFixity(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// Checker is null when scheduled checks are disabled
+		Checker  *processing.FixityStats `json:"checker"`
+		ByStatus map[string]int          `json:"by_status"`
+		Checks   []database.FixityCheck  `json:"checks"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	query := r.URL.Query()
+	details := []fieldError{}
+	statuses := fixityProblems
+	switch status := query.Get("status"); status {
+	case "":
+	case "all":
+		statuses = nil
+	case database.FixityStatusOK, database.FixityStatusBaseline, database.FixityStatusMismatch,
+		database.FixityStatusMissing, database.FixityStatusError:
+		statuses = []string{status}
+	default:
+		details = append(details, fieldError{
+			Field:   "status",
+			Message: "must be ok, baseline, mismatch, missing, error or all",
+		})
+	}
+	limit := defaultFixityReportSize
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxFixityReportSize {
+			details = append(details, fieldError{
+				Field:   "limit",
+				Message: fmt.Sprintf("must be between 1 and %d", maxFixityReportSize),
+			})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid query", nil, details)
+		return
+	}
+
+	checks, err := cfg.db.GetFixityChecks(statuses, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve fixity checks", err)
+		return
+	}
+	counts, err := cfg.db.CountFixityChecks()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count fixity checks", err)
+		return
+	}
+
+	resp := response{ByStatus: counts, Checks: checks}
+	if cfg.fixity != nil {
+		stats := cfg.fixity.Stats()
+		resp.Checker = &stats
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// Verifies the next sample right away instead of waiting for the schedule
+func (cfg *apiConfig) handlerAdminFixityRun(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Checked  int                    `json:"checked"`
+		Problems int                    `json:"problems"`
+		Checks   []database.FixityCheck `json:"checks"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+	if cfg.fixity == nil {
+		respondWithError(w, http.StatusConflict, "Fixity checks are disabled", nil)
+		return
+	}
+
+	checks := cfg.fixity.CheckSample(r.Context())
+	resp := response{Checked: len(checks), Checks: checks}
+	for _, check := range checks {
+		if slices.Contains(fixityProblems, check.Status) {
+			resp.Problems++
+		}
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
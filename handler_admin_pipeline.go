@@ -0,0 +1,12 @@
+package main
+
+import "net/http"
+
+func (cfg *apiConfig) handlerAdminPipeline(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+	stages := cfg.ingest.Metrics()
+	respondWithList(w, http.StatusOK, stages, listPage{Total: len(stages)})
+}
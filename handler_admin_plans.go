@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Plan names are short slugs, like "premium"
+var planNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func (cfg *apiConfig) handlerAdminPlansList(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	plans, err := cfg.db.GetPlans()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve plans", err)
+		return
+	}
+
+	respondWithList(w, http.StatusOK, plans, listPage{Total: len(plans)})
+}
+
+// Creates a plan, or replaces an existing plan's limits. Users on it are held
+// to the new limits from their next upload.
+func (cfg *apiConfig) handlerAdminPlanSave(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	name := r.PathValue("plan")
+	params := database.PlanLimits{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	details := []fieldError{}
+	if !planNamePattern.MatchString(name) {
+		details = append(details, fieldError{Field: "plan", Message: "must be up to 64 lowercase letters, digits, - and _"})
+	}
+	if params.UploadLimit != nil && (*params.UploadLimit <= 0 || *params.UploadLimit > processing.MaxUploadStreamSize) {
+		details = append(details, fieldError{Field: "upload_limit", Message: fmt.Sprintf("must be between 1 and %d bytes", processing.MaxUploadStreamSize)})
+	}
+	if params.StorageQuota != nil && *params.StorageQuota < 0 {
+		details = append(details, fieldError{Field: "storage_quota", Message: "must not be negative"})
+	}
+	if params.MaxVideoDuration != nil && *params.MaxVideoDuration <= 0 {
+		details = append(details, fieldError{Field: "max_video_duration", Message: "must be positive"})
+	}
+	if params.MaxLinkExpiry != nil && *params.MaxLinkExpiry <= 0 {
+		details = append(details, fieldError{Field: "max_link_expiry", Message: "must be positive"})
+	}
+	for _, profile := range params.Profiles {
+		if _, ok := cfg.profiles.Get(profile); !ok || profile == "" {
+			details = append(details, fieldError{Field: "profiles", Message: "unknown processing profile: " + profile})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid plan", nil, details)
+		return
+	}
+
+	plan, err := cfg.db.SavePlan(name, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save plan", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, plan)
+}
+
+// Deletes a plan nobody is on any more
+func (cfg *apiConfig) handlerAdminPlanDelete(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	name := r.PathValue("plan")
+	plan, err := cfg.db.GetPlan(name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if plan == nil {
+		respondWithError(w, http.StatusNotFound, "Plan not found", nil)
+		return
+	}
+	users, err := cfg.db.CountPlanUsers(name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count plan's users", err)
+		return
+	}
+	if users > 0 {
+		respondWithErrorCode(w, errCodeConflict, fmt.Sprintf("Plan still has %d users, move them to another plan first", users), nil, nil)
+		return
+	}
+
+	if err := cfg.db.DeletePlan(name); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete plan", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Moves a user to a plan, or back to the default with an empty one
+func (cfg *apiConfig) handlerAdminUserPlanSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Plan string `json:"plan"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if params.Plan != "" {
+		plan, err := cfg.db.GetPlan(params.Plan)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+			return
+		}
+		if plan == nil {
+			respondWithError(w, http.StatusNotFound, "Plan not found", nil)
+			return
+		}
+	}
+
+	if err := cfg.db.SetUserPlan(userID, params.Plan); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
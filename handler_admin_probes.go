@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminProbeGet(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	cfg.respondWithProbe(w, r.PathValue("sha256"))
+}
+
+func (cfg *apiConfig) handlerAdminVideoProbeGet(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.SourceSHA256 == nil {
+		respondWithError(w, http.StatusNotFound, "No probe recorded for video", nil)
+		return
+	}
+
+	cfg.respondWithProbe(w, *video.SourceSHA256)
+}
+
+// Function to respond with the cached ffprobe JSON for a content hash
+func (cfg *apiConfig) respondWithProbe(w http.ResponseWriter, sha256 string) {
+	probe, err := cfg.db.GetProbe(sha256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get probe", err)
+		return
+	}
+	if probe == nil {
+		respondWithError(w, http.StatusNotFound, "No probe cached for hash", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, json.RawMessage(probe))
+}
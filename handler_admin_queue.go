@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// How far back the queue stats count claims unless asked otherwise
+const defaultQueueStatsWindow = time.Hour
+
+// Function to report each job priority's queue, so operators can see
+// whether bulk work is holding up interactive uploads or starving itself
+func (cfg *apiConfig) handlerAdminQueue(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	window := defaultQueueStatsWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Second {
+			respondWithErrorCode(w, errCodeValidationFailed, "Invalid query", err, []fieldError{
+				{Field: "window", Message: "must be a duration of at least 1s, like 15m"},
+			})
+			return
+		}
+		window = parsed
+	}
+
+	stats, err := cfg.db.GetJobQueueStats(window)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get queue stats", err)
+		return
+	}
+	respondWithList(w, http.StatusOK, stats, listPage{Total: len(stats)})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// Number of objects of each kind a reconciliation report lists
+const maxReconcileFindings = 1000
+
+// Shows the latest reconciliation, scheduled or run by hand
+func (cfg *apiConfig) handlerAdminReconcile(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	report := cfg.reconciler.LastReport()
+	if report == nil {
+		respondWithError(w, http.StatusNotFound, "No reconciliation has run since startup", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// Reconciles the buckets against the database now, making any repairs asked for
+func (cfg *apiConfig) handlerAdminReconcileRun(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	query := r.URL.Query()
+	details := []fieldError{}
+	opts := processing.ReconcileOptions{}
+	switch source := query.Get("source"); source {
+	case "", processing.ReconcileSourceList:
+		opts.Source = source
+	case processing.ReconcileSourceInventory:
+		if cfg.reconciler.Inventory == nil {
+			details = append(details, fieldError{
+				Field:   "source",
+				Message: "needs S3_INVENTORY_BUCKET and S3_INVENTORY_PREFIX to be set",
+			})
+		}
+		opts.Source = source
+	default:
+		details = append(details, fieldError{Field: "source", Message: "must be list or inventory"})
+	}
+	repairs, err := processing.ParseRepairs(query.Get("repair"))
+	if err != nil {
+		details = append(details, fieldError{
+			Field:   "repair",
+			Message: "must be a comma-separated list of " + strings.Join([]string{processing.RepairDeleteOrphaned, processing.RepairForgetMissing, processing.RepairRecordUnrecorded}, ", "),
+		})
+	}
+	opts.Repairs = repairs
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid query", nil, details)
+		return
+	}
+	dryRun, ok := cfg.parseDryRun(w, r)
+	if !ok {
+		return
+	}
+	opts.DryRun = dryRun
+
+	report, err := cfg.reconciler.Reconcile(r.Context(), opts)
+	if errors.Is(err, processing.ErrReconcileRunning) {
+		respondWithError(w, http.StatusConflict, "A reconciliation is already running", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reconcile storage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminTenantCreate(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	params := database.CreateTenantParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	details := []fieldError{}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		details = append(details, fieldError{Field: "name", Message: "is required"})
+	}
+	if (params.Bucket == nil) != (params.BaseURL == nil) {
+		details = append(details, fieldError{Field: "bucket", Message: "bucket and base_url must be set together"})
+	}
+	if params.BaseURL != nil {
+		baseURL := strings.TrimSuffix(*params.BaseURL, "/")
+		params.BaseURL = &baseURL
+	}
+	if params.KeyPrefix != "" {
+		prefix := strings.Trim(params.KeyPrefix, "/")
+		if path.Clean(prefix) != prefix || strings.HasPrefix(prefix, "..") {
+			details = append(details, fieldError{Field: "key_prefix", Message: "must be a relative key prefix"})
+		}
+		params.KeyPrefix = prefix
+	}
+	if params.MaxVideos != nil && *params.MaxVideos < 0 {
+		details = append(details, fieldError{Field: "max_videos", Message: "must not be negative"})
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid tenant", nil, details)
+		return
+	}
+
+	tenant, err := cfg.db.CreateTenant(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create tenant", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, tenant)
+}
+
+func (cfg *apiConfig) handlerAdminTenantsList(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	tenants, err := cfg.db.GetTenants()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tenants", err)
+		return
+	}
+
+	respondWithList(w, http.StatusOK, tenants, listPage{Total: len(tenants)})
+}
+
+// Moving a user takes effect straight away, new videos are created in the
+// tenant the user is in when they're created
+func (cfg *apiConfig) handlerAdminUserTenantSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		TenantID uuid.NullUUID `json:"tenant_id"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if params.TenantID.Valid {
+		tenant, err := cfg.db.GetTenant(params.TenantID.UUID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get tenant", err)
+			return
+		}
+		if tenant.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "Tenant not found", nil)
+			return
+		}
+	}
+
+	if err := cfg.db.SetUserTenant(userID, params.TenantID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
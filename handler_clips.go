@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Lifetime of the presigned URL handed out when a clip link is opened
+const clipURLExpiry = time.Hour
+
+const clipsPrefix = "clips"
+
+type clipResponse struct {
+	database.Clip
+	Materialized bool   `json:"materialized"`
+	URL          string `json:"url,omitempty"`
+}
+
+func (cfg *apiConfig) handlerClipCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		StartSeconds     float64 `json:"start_seconds"`
+		EndSeconds       float64 `json:"end_seconds"`
+		Materialize      bool    `json:"materialize"`
+		ExpiresInSeconds int     `json:"expires_in_seconds"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || video.Duration == nil {
+		respondWithError(w, http.StatusConflict, "Video must be uploaded before sharing clips", nil)
+		return
+	}
+	if processing.FormatForKey(*video.VideoURL) == processing.FormatHLS {
+		respondWithError(w, http.StatusConflict, "Clips can't be shared from HLS videos", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Validate the range against the stored duration
+	details := []fieldError{}
+	if params.StartSeconds < 0 || params.StartSeconds >= *video.Duration {
+		details = append(details, fieldError{
+			Field:   "start_seconds",
+			Message: fmt.Sprintf("must be between 0 and %.3f seconds", *video.Duration),
+		})
+	}
+	if params.EndSeconds <= params.StartSeconds || params.EndSeconds > *video.Duration {
+		details = append(details, fieldError{
+			Field:   "end_seconds",
+			Message: fmt.Sprintf("must be after start_seconds and at most %.3f seconds", *video.Duration),
+		})
+	}
+	if params.ExpiresInSeconds < 0 {
+		details = append(details, fieldError{Field: "expires_in_seconds", Message: "must not be negative"})
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid clip", nil, details)
+		return
+	}
+
+	// Plans that limit how long links last don't get clips that never expire
+	plan, err := cfg.userPlan(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	expiresIn := time.Duration(params.ExpiresInSeconds) * time.Second
+	if planMaxExpiry := planMaxLinkExpiry(plan); planMaxExpiry > 0 {
+		if expiresIn > planMaxExpiry {
+			respondWithRequestError(w, linkExpiryNotInPlan(planMaxExpiry))
+			return
+		}
+		if expiresIn == 0 {
+			expiresIn = planMaxExpiry
+		}
+	}
+
+	// Each link gets its own token, so it can be revoked without affecting others
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create clip token", err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		expiry := time.Now().UTC().Add(expiresIn)
+		expiresAt = &expiry
+	}
+
+	clip, err := cfg.db.CreateClip(token, database.CreateClipParams{
+		VideoID:      video.ID,
+		UserID:       video.UserID,
+		StartSeconds: params.StartSeconds,
+		EndSeconds:   params.EndSeconds,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create clip", err)
+		return
+	}
+
+	// Optionally cut the range out into its own file, for players that
+	// don't honor media fragments
+	if params.Materialize {
+		clip, err = cfg.materializeClip(r, video, clip)
+		if errors.Is(err, errVideoArchived) {
+			respondWithRequestError(w, err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create clip file", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusCreated, clipResponse{
+		Clip:         clip,
+		Materialized: clip.ObjectKey != nil,
+	})
+}
+
+func (cfg *apiConfig) handlerClipsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	clips, err := cfg.db.GetClips(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve clips", err)
+		return
+	}
+
+	response := make([]clipResponse, 0, len(clips))
+	for _, clip := range clips {
+		response = append(response, clipResponse{Clip: clip, Materialized: clip.ObjectKey != nil})
+	}
+
+	respondWithList(w, http.StatusOK, response, listPage{Total: len(response)})
+}
+
+// Clip links are opened without logging in, the token is the credential
+func (cfg *apiConfig) handlerClipResolve(w http.ResponseWriter, r *http.Request) {
+	clip, err := cfg.db.GetClipByToken(r.PathValue("token"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get clip", err)
+		return
+	}
+	if clip.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Clip not found", nil)
+		return
+	}
+	if clip.ExpiresAt != nil && time.Now().After(*clip.ExpiresAt) {
+		respondWithErrorCode(w, errCodeExpired, "Clip link has expired", nil, nil)
+		return
+	}
+
+	if !cfg.hotlink.allows(r) {
+		respondWithError(w, http.StatusForbidden, "Embedding from this site isn't allowed", nil)
+		return
+	}
+	if !cfg.checkViewerAccess(w, r, clip.VideoID) {
+		return
+	}
+
+	var url string
+	if clip.ObjectKey != nil {
+		bucket, err := cfg.getClipBucket(clip)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't locate clip", err)
+			return
+		}
+		url, err = cfg.signClipURL(r.Context(), bucket, *clip.ObjectKey, "")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign clip URL", err)
+			return
+		}
+	} else {
+
+		// Point at the full video with a media fragment so players seek to the range
+		video, err := cfg.db.GetVideo(clip.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.VideoURL == nil {
+			respondWithError(w, http.StatusNotFound, "Video not found", nil)
+			return
+		}
+		bucket, key, err := cfg.getVideoLocation(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't locate video", err)
+			return
+		}
+		url, err = cfg.signClipURL(r.Context(), bucket, key, fmt.Sprintf("#t=%.3f,%.3f", clip.StartSeconds, clip.EndSeconds))
+		if err != nil {
+			if errors.Is(err, errVideoArchived) {
+				respondWithRequestError(w, err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+			return
+		}
+	}
+
+	// The token itself isn't echoed back to whoever opened the link
+	clip.Token = ""
+	respondWithJSON(w, http.StatusOK, clipResponse{
+		Clip:         clip,
+		Materialized: clip.ObjectKey != nil,
+		URL:          url,
+	})
+}
+
+// Function to get the bucket a materialized clip is in, whichever of its video's
+// storages the storage ledger has it in
+func (cfg *apiConfig) getClipBucket(clip database.Clip) (string, error) {
+	video, err := cfg.db.GetVideo(clip.VideoID)
+	if err != nil {
+		return "", err
+	}
+	storages, err := cfg.getVideoStorages(video)
+	if err != nil {
+		return "", err
+	}
+	for _, storage := range storages {
+		object, err := cfg.db.GetStoredObject(storage.Bucket, *clip.ObjectKey)
+		if err != nil {
+			return "", err
+		}
+		if object != nil {
+			return storage.Bucket, nil
+		}
+	}
+	return storages[0].Bucket, nil
+}
+
+// Function to get the URL a clip link opens, a single-use playback link when
+// hot-link protection asks for one and a presigned URL otherwise
+func (cfg *apiConfig) signClipURL(ctx context.Context, bucket, key, fragment string) (string, error) {
+	if cfg.hotlink.SingleUseURLs {
+		return cfg.createPlaybackLink(bucket, key, fragment)
+	}
+	url, err := cfg.generatePresignedURL(ctx, bucket, key, clipURLExpiry)
+	if err != nil {
+		return "", err
+	}
+	return url + fragment, nil
+}
+
+func (cfg *apiConfig) handlerClipDelete(w http.ResponseWriter, r *http.Request) {
+	clipID, err := uuid.Parse(r.PathValue("clipID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid clip ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	clip, err := cfg.db.GetClip(clipID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get clip", err)
+		return
+	}
+	if clip.ID == uuid.Nil || clip.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Clip not found", nil)
+		return
+	}
+
+	plan, ok := cfg.newRemovalPlan(w, r)
+	if !ok {
+		return
+	}
+	var bucket string
+	if clip.ObjectKey != nil {
+		bucket, err = cfg.getClipBucket(clip)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't locate clip", err)
+			return
+		}
+	}
+	if plan.DryRun {
+		if clip.ObjectKey != nil {
+			plan.add(removal{Kind: removalObject, Bucket: bucket, Key: *clip.ObjectKey})
+		}
+		plan.add(removal{Kind: removalClip, ID: &clip.ID})
+		respondWithJSON(w, http.StatusOK, plan)
+		return
+	}
+
+	if clip.ObjectKey != nil {
+		if err := cfg.deleteObject(r.Context(), bucket, *clip.ObjectKey, nil); err != nil {
+			log.Printf("Couldn't delete clip object %s: %v", *clip.ObjectKey, err)
+		} else {
+			cfg.forgetStoredObject(bucket, *clip.ObjectKey)
+		}
+	}
+
+	if err := cfg.db.DeleteClip(clipID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete clip", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to trim a clip's range out of its video into a standalone MP4 in the bucket
+func (cfg *apiConfig) materializeClip(r *http.Request, video database.Video, clip database.Clip) (database.Clip, error) {
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		return database.Clip{}, err
+	}
+	sourceURL, err := cfg.generatePresignedURL(r.Context(), bucket, key, clipURLExpiry)
+	if err != nil {
+		return database.Clip{}, err
+	}
+
+	outputPath := cfg.scratch.Path("clip-" + clip.ID.String() + ".mp4")
+	defer os.Remove(outputPath)
+	if err := processing.TrimVideo(sourceURL, clip.StartSeconds, clip.EndSeconds, outputPath); err != nil {
+		return database.Clip{}, err
+	}
+
+	clipFile, err := os.Open(outputPath)
+	if err != nil {
+		return database.Clip{}, err
+	}
+	defer clipFile.Close()
+	clipInfo, err := clipFile.Stat()
+	if err != nil {
+		return database.Clip{}, err
+	}
+
+	// Clips go next to the video, in its owner's or tenant's storage
+	storage, err := cfg.getVideoStorage(video)
+	if err != nil {
+		return database.Clip{}, err
+	}
+	client, err := cfg.getS3Client(storage.Bucket)
+	if err != nil {
+		return database.Clip{}, err
+	}
+	objectKey := storage.Key(path.Join(clipsPrefix, video.ID.String(), clip.ID.String()+".mp4"))
+	_, err = client.PutObject(r.Context(), &s3.PutObjectInput{
+		Bucket:      aws.String(storage.Bucket),
+		Key:         aws.String(objectKey),
+		Body:        clipFile,
+		ContentType: aws.String("video/mp4"),
+	})
+	if err != nil {
+		return database.Clip{}, fmt.Errorf("couldn't upload clip: %w", err)
+	}
+
+	if err := cfg.db.SetClipObjectKey(clip.ID, objectKey); err != nil {
+		return database.Clip{}, err
+	}
+	cfg.recordStoredObject(database.RecordStoredObjectParams{
+		UserID:  clip.UserID,
+		VideoID: uuid.NullUUID{UUID: video.ID, Valid: true},
+		Kind:    database.StorageKindClip,
+		Bucket:  storage.Bucket,
+		Key:     objectKey,
+		Bytes:   clipInfo.Size(),
+	})
+	clip.ObjectKey = &objectKey
+	return clip, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/takeout"
+	"github.com/google/uuid"
+)
+
+// Limits on a Takeout import. Archives only need the metadata, not the
+// videos themselves.
+const (
+	maxTakeoutArchiveSize = 64 << 20
+	maxTakeoutVideos      = 5000
+)
+
+// Imported videos get the YouTube video ID as their external ID, behind this
+// prefix, so importing the same archive again finds them instead of making
+// copies
+const youtubeExternalIDPrefix = "youtube:"
+
+// uploadTicket is where to send the file of an imported video
+type uploadTicket struct {
+	VideoID        uuid.UUID `json:"video_id"`
+	YouTubeVideoID string    `json:"youtube_video_id"`
+	Title          string    `json:"title"`
+	// Created is false for videos an earlier import made
+	Created bool `json:"created"`
+	// Uploaded is true once the video has its file, when there's nothing
+	// left to send
+	Uploaded           bool   `json:"uploaded"`
+	UploadURL          string `json:"upload_url"`
+	ResumableUploadURL string `json:"resumable_upload_url"`
+}
+
+// Function to recreate a YouTube channel's library from the video metadata
+// in a Takeout archive, creating a video for each with its title,
+// description, tags and privacy, and responding with a ticket for each
+// saying where to upload its file
+func (cfg *apiConfig) handlerImportYouTubeTakeout(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Created  int            `json:"created"`
+		Existing int            `json:"existing"`
+		Tickets  []uploadTicket `json:"tickets"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	tenant, err := cfg.getUserTenant(userID)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	cfg.beginUploadBody(w, r)
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	videos, err := takeout.ReadVideos(file, header.Size)
+	if err != nil {
+		respondWithErrorCode(w, errCodeValidationFailed, "Couldn't read Takeout archive", err, []fieldError{
+			{Field: "archive", Message: err.Error()},
+		})
+		return
+	}
+	if len(videos) > maxTakeoutVideos {
+		respondWithErrorCode(w, errCodeValidationFailed, "Takeout archive has too many videos", nil, []fieldError{
+			{Field: "archive", Message: fmt.Sprintf("must list at most %d videos", maxTakeoutVideos)},
+		})
+		return
+	}
+
+	// Videos imported before are only ticketed, so only the rest count
+	// against the tenant's limit
+	existing := map[string]database.Video{}
+	for _, video := range videos {
+		found, err := cfg.db.GetVideoByExternalID(userID, youtubeExternalIDPrefix+video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check for imported videos", err)
+			return
+		}
+		if found.ID != uuid.Nil {
+			existing[video.ID] = found
+		}
+	}
+	if tenant.Valid {
+		if err := cfg.checkTenantRoom(tenant.UUID, len(videos)-len(existing)); err != nil {
+			respondWithRequestError(w, err)
+			return
+		}
+	}
+
+	resp := response{Tickets: []uploadTicket{}}
+	for _, imported := range videos {
+		video, ok := existing[imported.ID]
+		if ok {
+			resp.Existing++
+		} else {
+			video, err = cfg.createImportedVideo(userID, tenant, imported)
+			if errors.Is(err, database.ErrTenantFull) {
+				respondWithRequestError(w, cfg.tenantFullError(tenant.UUID))
+				return
+			}
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Couldn't create video for YouTube video %s", imported.ID), err)
+				return
+			}
+			resp.Created++
+		}
+		resp.Tickets = append(resp.Tickets, uploadTicket{
+			VideoID:            video.ID,
+			YouTubeVideoID:     imported.ID,
+			Title:              video.Title,
+			Created:            !ok,
+			Uploaded:           !video.State.AwaitsFile(),
+			UploadURL:          apiPathFor(r, "/api/video_upload/"+video.ID.String()),
+			ResumableUploadURL: apiPathFor(r, "/api/videos/"+video.ID.String()+"/uploads"),
+		})
+	}
+
+	status := http.StatusOK
+	if resp.Created > 0 {
+		status = http.StatusCreated
+	}
+	respondWithJSON(w, status, resp)
+}
+
+// Function to create a video from one in a Takeout archive. Tags Tubely
+// can't hold are dropped rather than failing the import.
+func (cfg *apiConfig) createImportedVideo(userID uuid.UUID, tenant uuid.NullUUID, imported takeout.Video) (database.Video, error) {
+	externalID := youtubeExternalIDPrefix + imported.ID
+	if len(externalID) > maxExternalIDLength {
+		return database.Video{}, fmt.Errorf("video ID is longer than %d bytes", maxExternalIDLength-len(youtubeExternalIDPrefix))
+	}
+	title := imported.Title
+	if strings.TrimSpace(title) == "" || !utf8.ValidString(title) {
+		title = "YouTube video " + imported.ID
+	}
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       title,
+		Description: imported.Description,
+		UserID:      userID,
+		TenantID:    tenant,
+		ExternalID:  &externalID,
+	})
+	if errors.Is(err, database.ErrExternalIDTaken) {
+		// Another import of the same archive got there first
+		return cfg.db.GetVideoByExternalID(userID, externalID)
+	}
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	tags := []string{}
+	for _, tag := range imported.Tags {
+		tag, err := normalizeTag(tag)
+		if err != nil || slices.Contains(tags, tag) {
+			continue
+		}
+		if len(tags) == maxVideoTags {
+			break
+		}
+		tags = append(tags, tag)
+	}
+	details := database.VideoDetails{
+		Title:       video.Title,
+		Description: video.Description,
+		Visibility:  youtubeVisibility(imported.Privacy),
+		Tags:        tags,
+	}
+	if _, err := cfg.db.UpdateVideoDetails(video.ID, details, nil); err != nil {
+		return database.Video{}, err
+	}
+	return cfg.db.GetVideo(video.ID)
+}
+
+// Function to map a YouTube privacy setting to a visibility. Anything
+// unrecognised is private, so nothing is published by mistake.
+func youtubeVisibility(privacy string) database.Visibility {
+	switch visibility := database.Visibility(privacy); visibility {
+	case database.VisibilityPublic, database.VisibilityUnlisted:
+		return visibility
+	}
+	return database.VisibilityPrivate
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/inbound"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/google/uuid"
+)
+
+const (
+	// Mailgun posts emails of up to 25 MB with the attachments decoded
+	maxInboundEmailSize = 32 << 20
+	// SNS messages are at most 256 KB
+	maxSNSMessageSize     = 256 << 10
+	maxInboundAttachments = 5
+	// How old a Mailgun delivery's signature can be
+	mailgunSignatureMaxAge = 15 * time.Minute
+)
+
+type inboundEmailResponse struct {
+	// Videos is how many attachments are being uploaded
+	Videos int `json:"videos"`
+	// Ignored says why the email was dropped, if it was
+	Ignored string `json:"ignored,omitempty"`
+}
+
+func (cfg *apiConfig) handlerInboundEmailMailgun(w http.ResponseWriter, r *http.Request) {
+	if cfg.mailgun == nil {
+		respondWithError(w, http.StatusNotFound, "Inbound email from Mailgun is disabled", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxInboundEmailSize)
+	email, err := cfg.mailgun.Parse(r, cfg.inboundEmailOptions())
+	if err != nil {
+		respondWithInboundEmailError(w, err)
+		return
+	}
+	cfg.acceptInboundEmail(w, email)
+}
+
+func (cfg *apiConfig) handlerInboundEmailSES(w http.ResponseWriter, r *http.Request) {
+	if cfg.ses == nil {
+		respondWithError(w, http.StatusNotFound, "Inbound email from SES is disabled", nil)
+		return
+	}
+
+	msg, err := cfg.ses.ReadMessage(r.Context(), http.MaxBytesReader(w, r.Body, maxSNSMessageSize))
+	if err != nil {
+		respondWithInboundEmailError(w, err)
+		return
+	}
+
+	switch msg.Type {
+	case inbound.SNSSubscriptionConfirmation:
+		if err := cfg.ses.ConfirmSubscription(r.Context(), msg); err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't confirm SNS subscription", err)
+			return
+		}
+		log.Printf("Confirmed SNS subscription to %s for inbound email", msg.TopicArn)
+		w.WriteHeader(http.StatusNoContent)
+	case inbound.SNSNotification:
+		email, err := cfg.ses.ParseNotification(r.Context(), msg, cfg.inboundEmailOptions())
+		if err != nil {
+			respondWithInboundEmailError(w, err)
+			return
+		}
+		cfg.acceptInboundEmail(w, email)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Function to answer a delivery that couldn't be read. Providers retry
+// anything but a success, so emails that will never be readable are
+// acknowledged and dropped rather than rejected.
+func respondWithInboundEmailError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, inbound.ErrInvalidSignature):
+		respondWithError(w, http.StatusUnauthorized, "Invalid signature", err)
+	case errors.Is(err, inbound.ErrTooManyAttachments):
+		respondWithJSON(w, http.StatusOK, inboundEmailResponse{
+			Ignored: fmt.Sprintf("more than %d video attachments", maxInboundAttachments),
+		})
+	default:
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithJSON(w, http.StatusOK, inboundEmailResponse{Ignored: "email is too large"})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read email", err)
+	}
+}
+
+// Function to decide what of an email's attachments to keep: anything that
+// looks like a video, leaving the pipeline to reject types it can't take
+func (cfg *apiConfig) inboundEmailOptions() inbound.Options {
+	return inbound.Options{
+		Keep: func(filename, mediaType string) bool {
+			return isVideoFileName(filename) || strings.HasPrefix(mediaType, "video/")
+		},
+		// Attachments are encrypted along with other uploads in scratch space
+		CreateTemp: func(pattern string) (inbound.TempFile, error) {
+			file, err := cfg.scratch.CreateTempFile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return file, nil
+		},
+		MaxAttachments: maxInboundAttachments,
+	}
+}
+
+// Function to start uploading an email's videos for the user who sent it,
+// replying by email once they're done
+func (cfg *apiConfig) acceptInboundEmail(w http.ResponseWriter, email inbound.Email) {
+	ignore := func(reason string) {
+		email.Cleanup()
+		respondWithJSON(w, http.StatusOK, inboundEmailResponse{Ignored: reason})
+	}
+
+	if email.From == "" {
+		ignore("not a received email")
+		return
+	}
+	// Anyone can put a user's address in From, so only believe it when the
+	// provider could check it, and never answer strangers
+	if !email.Authenticated {
+		log.Printf("Ignoring inbound email from %s that failed DMARC and DKIM alignment", email.From)
+		ignore("sender couldn't be verified")
+		return
+	}
+	user, err := cfg.db.GetUserByEmail(email.From)
+	if err != nil {
+		email.Cleanup()
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil || user.DeletedAt != nil {
+		ignore("sender isn't a user")
+		return
+	}
+
+	go cfg.uploadInboundEmail(email, user)
+	respondWithJSON(w, http.StatusOK, inboundEmailResponse{Videos: len(email.Attachments)})
+}
+
+// Function to upload each video attached to an email and reply with a
+// share link for each, or why it couldn't be uploaded
+func (cfg *apiConfig) uploadInboundEmail(email inbound.Email, user database.User) {
+	defer email.Cleanup()
+
+	lines := []string{}
+	linked := false
+	for _, attachment := range email.Attachments {
+		title := strings.TrimSuffix(attachment.Filename, filepath.Ext(attachment.Filename))
+		if len(email.Attachments) == 1 && strings.TrimSpace(email.Subject) != "" {
+			title = strings.TrimSpace(email.Subject)
+		}
+		mediaType := attachment.MediaType
+		if isVideoFileName(attachment.Filename) {
+			mediaType = fileMediaTypes[strings.ToLower(filepath.Ext(attachment.Filename))]
+		}
+
+		video, err := cfg.ingestFileAs(user.ID, title, mediaType, attachment.Path, attachment.Filename, database.JobPriorityNormal)
+		if err != nil {
+			log.Printf("Couldn't upload %s emailed by user %s: %v", attachment.Filename, user.ID, err)
+			lines = append(lines, fmt.Sprintf("%s: %s", attachment.Filename, ingestFailureMessage(err)))
+			continue
+		}
+		link, err := cfg.createInboundShareLink(video)
+		if err != nil {
+			log.Printf("Couldn't create a share link for video %s: %v", video.ID, err)
+			lines = append(lines, fmt.Sprintf("%s: uploaded as %q, but a share link couldn't be made", attachment.Filename, video.Title))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", attachment.Filename, cfg.newShareLinkResponse(link, cfg.origin, apiV1Prefix).PageURL))
+		linked = true
+	}
+
+	text := "There were no videos attached to your email. Attach .mp4 files to upload them."
+	if len(lines) > 0 {
+		text = "Here's how the videos you sent got on:\n\n" + strings.Join(lines, "\n")
+	}
+	if linked {
+		if !cfg.ingest.Inline() {
+			text += "\n\nThey're still processing, so the links will work once they're ready."
+		}
+		text += fmt.Sprintf("\n\nThe links expire in %d days.", int(defaultShareLinkExpiry.Hours()/24))
+	}
+	cfg.replyToInboundEmail(email, text)
+}
+
+func (cfg *apiConfig) createInboundShareLink(video database.Video) (database.ShareLink, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.ShareLink{}, err
+	}
+	return cfg.db.CreateShareLink(token, database.CreateShareLinkParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		ExpiresAt: time.Now().UTC().Add(defaultShareLinkExpiry),
+	})
+}
+
+func (cfg *apiConfig) replyToInboundEmail(email inbound.Email, text string) {
+	if !cfg.notifier.EmailEnabled() {
+		log.Printf("Can't reply to inbound email from %s without SMTP_HOST set", email.From)
+		return
+	}
+	subject := "Your Tubely upload"
+	if email.Subject != "" {
+		subject = "Re: " + email.Subject
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	reply := notify.Email{SMTP: *cfg.notifier.SMTP, To: email.From}
+	if err := reply.Send(ctx, notify.Message{Subject: subject, Text: text}); err != nil {
+		log.Printf("Couldn't reply to inbound email from %s: %v", email.From, err)
+	}
+}
+
+// Function to read an email an SES receipt rule stored in S3
+func (cfg *apiConfig) fetchInboundEmail(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	object, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
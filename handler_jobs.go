@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobIDString := r.PathValue("jobID")
+	jobID, err := uuid.Parse(jobIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil || job.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// Function to cancel one of the user's jobs that hasn't finished. Cancelling
+// a job that's already cancelled responds with it again.
+func (cfg *apiConfig) handlerJobCancel(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil || job.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	job, err = cfg.processor.Cancel(context.WithoutCancel(r.Context()), job)
+	if errors.Is(err, processing.ErrJobFinished) {
+		respondWithErrorCode(w, errCodeConflict, "Job has already finished", err, nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't cancel job", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// Function to check every staleAfter for jobs whose worker stopped responding, so they're
+// retried or failed even while no worker is running to notice
+func (cfg *apiConfig) retryStaleJobs(ctx context.Context, staleAfter time.Duration) {
+	ticker := time.NewTicker(staleAfter)
+	defer ticker.Stop()
+
+	for {
+		requeued, failed, err := cfg.processor.RetryStaleJobs(ctx, staleAfter)
+		if err != nil {
+			log.Printf("Couldn't retry stale jobs: %v", err)
+		} else if requeued > 0 || failed > 0 {
+			log.Printf("Requeued %d and failed %d stale job(s)", requeued, failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -40,8 +40,15 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Accounts being deleted can't be logged into
+	if user.DeletedAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+		return
+	}
+
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		user.TenantID.UUID,
 		cfg.jwtSecret,
 		time.Hour*24*30,
 	)
@@ -67,7 +74,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
+		User:         cfg.withAvatarURL(r.Context(), user),
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	})
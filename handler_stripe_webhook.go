@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/billing"
+	"github.com/google/uuid"
+)
+
+const (
+	// Stripe events are well under this
+	maxStripeEventSize = 256 << 10
+	// How old a Stripe event's signature can be
+	stripeSignatureTolerance = 5 * time.Minute
+	// How long events about customers that aren't linked to a user yet are
+	// sent back to be retried, since Stripe doesn't deliver the checkout that
+	// links them first
+	stripeLinkGrace = time.Hour
+)
+
+type stripeWebhookResponse struct {
+	// Ignored says why the event didn't change anything, if it didn't
+	Ignored string `json:"ignored,omitempty"`
+}
+
+// handlerStripeWebhook moves users between plans as their subscriptions
+// change. Stripe retries anything but a 2XX, and can deliver an event more
+// than once or out of order.
+func (cfg *apiConfig) handlerStripeWebhook(w http.ResponseWriter, r *http.Request) {
+	if cfg.stripe == nil {
+		respondWithError(w, http.StatusNotFound, "Stripe webhooks are disabled", nil)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStripeEventSize))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read event", err)
+		return
+	}
+	event, err := cfg.stripe.ParseEvent(r.Header.Get("Stripe-Signature"), body)
+	if errors.Is(err, billing.ErrInvalidSignature) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid signature", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode event", err)
+		return
+	}
+	if event.Subscription == nil && event.Checkout == nil {
+		respondWithJSON(w, http.StatusOK, stripeWebhookResponse{Ignored: "event type isn't handled"})
+		return
+	}
+
+	isNew, err := cfg.db.RecordStripeEvent(event.ID, event.Type)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record event", err)
+		return
+	}
+	if !isNew {
+		respondWithJSON(w, http.StatusOK, stripeWebhookResponse{Ignored: "event was already handled"})
+		return
+	}
+
+	ignored, err := cfg.applyStripeEvent(event)
+	if err != nil {
+		// Let Stripe's retry handle the event again
+		if forgetErr := cfg.db.ForgetStripeEvent(event.ID); forgetErr != nil {
+			log.Printf("Couldn't forget Stripe event %s: %v", event.ID, forgetErr)
+		}
+		respondWithRequestError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, stripeWebhookResponse{Ignored: ignored})
+}
+
+// Function to link a checkout's customer to its user, or move a subscriber
+// to the plan their subscription pays for. It returns why the event changed
+// nothing, if it didn't.
+func (cfg *apiConfig) applyStripeEvent(event billing.Event) (string, error) {
+	if event.Checkout != nil {
+		userID, err := uuid.Parse(event.Checkout.ClientReferenceID)
+		if err != nil || event.Checkout.Customer == "" {
+			return "checkout session has no user or customer", nil
+		}
+		if ignored, err := cfg.linkStripeCustomer(event.Checkout.Customer, userID); ignored != "" || err != nil {
+			return ignored, err
+		}
+		return "", nil
+	}
+
+	sub := event.Subscription
+	customer, err := cfg.db.GetStripeCustomer(sub.Customer)
+	if err != nil {
+		return "", err
+	}
+	// Subscriptions can name their user themselves, for customers created
+	// without Checkout
+	if userID, parseErr := uuid.Parse(sub.UserID); customer == nil && parseErr == nil {
+		if ignored, err := cfg.linkStripeCustomer(sub.Customer, userID); ignored != "" || err != nil {
+			return ignored, err
+		}
+		if customer, err = cfg.db.GetStripeCustomer(sub.Customer); err != nil {
+			return "", err
+		}
+	}
+	if customer == nil {
+		if time.Since(event.Created) < stripeLinkGrace {
+			return "", newRequestError(errCodeConflict, "Stripe customer isn't linked to a user yet", nil)
+		}
+		return "customer isn't linked to a user", nil
+	}
+	if customer.LastEventAt != nil && event.Created.Before(*customer.LastEventAt) {
+		return "a newer event was already applied", nil
+	}
+
+	plan := ""
+	if sub.Entitled() {
+		for _, name := range sub.Plans {
+			found, err := cfg.db.GetPlan(name)
+			if err != nil {
+				return "", err
+			}
+			if found != nil {
+				plan = name
+				break
+			}
+		}
+		if plan == "" {
+			log.Printf("Stripe subscription %s has no price naming a plan, its prices name %v", sub.ID, sub.Plans)
+			return "subscription's prices don't name a plan", nil
+		}
+	} else if customer.SubscriptionID != "" && customer.SubscriptionID != sub.ID {
+		// The plan came from another subscription, which is still going
+		return "subscription isn't the one the plan came from", nil
+	}
+
+	if err := cfg.db.ApplyStripeSubscription(sub.Customer, sub.ID, plan, event.Created); err != nil {
+		return "", err
+	}
+	log.Printf("Stripe subscription %s (%s) moved user %s to plan %q", sub.ID, sub.Status, customer.UserID, plan)
+	return "", nil
+}
+
+// Function to link a Stripe customer to a user, if the user still exists
+func (cfg *apiConfig) linkStripeCustomer(customerID string, userID uuid.UUID) (string, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil || user.DeletedAt != nil {
+		return "user doesn't exist", nil
+	}
+	return "", cfg.db.LinkStripeCustomer(customerID, userID)
+}
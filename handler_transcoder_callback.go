@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Limits on transcoder callbacks
+const (
+	maxCallbackBodySize    = 1 << 20
+	callbackSignatureSkew  = 5 * time.Minute
+	callbackStatusComplete = "complete"
+	callbackStatusError    = "error"
+)
+
+// Function to report whether uploads finish after their request, leaving the client to poll the job
+func (cfg *apiConfig) processesAsync() bool {
+	return cfg.processingMode == processingModeWorker || cfg.asyncTranscode
+}
+
+// Function to start a job that finishes after its request; in worker mode a worker picks it up
+func (cfg *apiConfig) startAsyncJob(job database.Job) {
+	if cfg.processingMode == processingModeWorker {
+		return
+	}
+	go func() {
+		_, err := cfg.processor.Run(context.Background(), job)
+		if err != nil && !errors.Is(err, processing.ErrJobWaiting) && !errors.Is(err, database.ErrJobCancelled) {
+			log.Printf("Job %s failed: %v", job.ID, err)
+		}
+	}()
+}
+
+// handlerTranscoderCallback receives the outcome of a job submitted to an
+// external transcoder. The body must be signed with the shared secret; the
+// sender is expected to retry anything but a 2XX.
+func (cfg *apiConfig) handlerTranscoderCallback(w http.ResponseWriter, r *http.Request) {
+	type output struct {
+		// Key is an object key in the video's bucket, or an s3:// URI
+		Key string `json:"key"`
+	}
+	type parameters struct {
+		JobID      string   `json:"job_id"`
+		ExternalID string   `json:"external_id"`
+		Status     string   `json:"status"`
+		Error      string   `json:"error"`
+		Outputs    []output `json:"outputs"`
+	}
+
+	if cfg.callbackSecret == "" {
+		respondWithError(w, http.StatusNotFound, "Transcoder callbacks are disabled", nil)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBodySize))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read callback", err)
+		return
+	}
+	if err := auth.ValidateSignature(cfg.callbackSecret, r.Header, body, callbackSignatureSkew); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid callback signature", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Map the callback back to its job by our ID, or the transcoder's
+	var job database.Job
+	switch {
+	case params.JobID != "":
+		jobID, err := uuid.Parse(params.JobID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+			return
+		}
+		job, err = cfg.db.GetJob(jobID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+			return
+		}
+	case params.ExternalID != "":
+		job, err = cfg.db.GetJobByExternalID(params.ExternalID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+			return
+		}
+	default:
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid callback", nil, []fieldError{
+			{Field: "job_id", Message: "job_id or external_id is required"},
+		})
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+	if params.ExternalID != "" && (job.ExternalID == nil || *job.ExternalID != params.ExternalID) {
+		respondWithError(w, http.StatusConflict, "Callback doesn't match the job's transcoder job", nil)
+		return
+	}
+
+	status := strings.ToLower(params.Status)
+	switch status {
+	case callbackStatusComplete, "completed", "succeeded":
+		status = callbackStatusComplete
+	case callbackStatusError, "failed", "canceled", "cancelled":
+		status = callbackStatusError
+	default:
+		// Progress updates don't move the job along
+		respondWithJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	// A repeated callback for a job that's already finished is acknowledged as-is
+	if job.State == database.JobStateSucceeded || job.State == database.JobStateFailed || job.State == database.JobStateCancelled {
+		respondWithJSON(w, http.StatusOK, job)
+		return
+	}
+	if job.State != database.JobStateWaiting {
+		respondWithError(w, http.StatusConflict, "Job isn't waiting for its transcoder yet", nil)
+		return
+	}
+
+	if status == callbackStatusError {
+		msg := params.Error
+		if msg == "" {
+			msg = "transcoder reported an error"
+		}
+		if err := cfg.processor.Fail(r.Context(), job, fmt.Errorf("transcoder callback: %s", msg)); err != nil {
+			respondWithError(w, http.StatusConflict, "Couldn't fail job", err)
+			return
+		}
+	} else {
+		objectKey := ""
+		if len(params.Outputs) > 0 {
+			objectKey, err = cfg.callbackObjectKey(job, params.Outputs[0].Key)
+			if err != nil {
+				respondWithErrorCode(w, errCodeValidationFailed, "Invalid callback", err, []fieldError{
+					{Field: "outputs[0].key", Message: err.Error()},
+				})
+				return
+			}
+		}
+		if _, err := cfg.processor.Complete(context.WithoutCancel(r.Context()), job, objectKey); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't complete job", err)
+			return
+		}
+	}
+
+	job, err = cfg.db.GetJob(job.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// Function to turn a reported output location into an object key in the video's bucket
+func (cfg *apiConfig) callbackObjectKey(job database.Job, location string) (string, error) {
+	key := location
+	if rest, ok := strings.CutPrefix(location, "s3://"); ok {
+		bucket, objectKey, _ := strings.Cut(rest, "/")
+		video, err := cfg.db.GetVideo(job.VideoID)
+		if err != nil {
+			return "", err
+		}
+		storage, err := cfg.getVideoStorage(video)
+		if err != nil {
+			return "", err
+		}
+		if bucket != storage.Bucket {
+			return "", fmt.Errorf("must be in bucket %s", storage.Bucket)
+		}
+		key = objectKey
+	}
+
+	// Transcoders may change the extension, but the output must be the job's own
+	base := strings.TrimSuffix(job.ObjectKey, path.Ext(job.ObjectKey))
+	if path.Clean(key) != key || strings.TrimSuffix(key, path.Ext(key)) != base {
+		return "", fmt.Errorf("must be %s with any extension", base)
+	}
+	return key, nil
+}
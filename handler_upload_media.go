@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/google/uuid"
+)
+
+// Combined uploads carry both files in one body. Like videoUploadLimit this
+// is only the default, the video is held to the uploader's plan.
+const mediaUploadLimit = videoUploadLimit + thumbnailUploadLimit
+
+// handlerUploadMedia accepts a video and its thumbnail in one multipart
+// request. The thumbnail is stored while the video is still uploading or
+// processing, and both URLs are committed in a single write.
+func (cfg *apiConfig) handlerUploadMedia(w http.ResponseWriter, r *http.Request) {
+	type thumbnailResult struct {
+		thumbnail storedThumbnail
+		err       error
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		return
+	}
+
+	plan, err := cfg.userPlan(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if !limitUploadBody(w, r, cfg.planUploadLimit(plan)+thumbnailUploadLimit) {
+		return
+	}
+	if err := cfg.checkStorageQuota(userID, plan, r.ContentLength); err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	cfg.beginUploadBody(w, r)
+
+	// Read the parts in whatever order they arrive, without buffering the video
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read multipart body", err)
+		return
+	}
+
+	// Profile, notify and priority fields must come before the video part to apply to it
+	var thumbnailDone chan thumbnailResult
+	var upload *ingest.Upload
+	fields := map[string]string{}
+
+	// The thumbnail is read once, by whichever of the success path or the
+	// cleanup below gets to it first
+	var thumbnailOnce sync.Once
+	var thumbnailStored thumbnailResult
+	awaitThumbnail := func() thumbnailResult {
+		thumbnailOnce.Do(func() { thumbnailStored = <-thumbnailDone })
+		return thumbnailStored
+	}
+
+	// A request that fails part way through leaves nothing behind: the
+	// stored thumbnail is deleted and a job that was never started is
+	// discarded along with its file
+	thumbnailKept, jobHandedOff := false, false
+	defer func() {
+		if thumbnailDone != nil && !thumbnailKept {
+			if result := awaitThumbnail(); result.err == nil {
+				cfg.removeStoredThumbnail(result.thumbnail)
+			}
+		}
+		if upload != nil && upload.Job.ID != uuid.Nil && !jobHandedOff {
+			if _, err := cfg.processor.Cancel(context.WithoutCancel(r.Context()), upload.Job); err != nil {
+				log.Printf("Couldn't discard job %s: %v", upload.Job.ID, err)
+			}
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to read multipart body", err)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch part.FormName() {
+		case "profile", "notify", "priority":
+			value, err := io.ReadAll(io.LimitReader(part, maxProfileNameLength+1))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Unable to read "+part.FormName(), err)
+				return
+			}
+			if upload != nil {
+				respondWithError(w, http.StatusBadRequest, "The "+part.FormName()+" must be sent before the video", nil)
+				return
+			}
+			fields[part.FormName()] = string(value)
+
+		case "external_id":
+			value, err := io.ReadAll(io.LimitReader(part, maxExternalIDLength+1))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Unable to read external_id", err)
+				return
+			}
+			if !cfg.applyUploadExternalID(w, video, string(value)) {
+				return
+			}
+
+		case "thumbnail":
+			if thumbnailDone != nil {
+				respondWithError(w, http.StatusBadRequest, "Only one thumbnail is allowed", nil)
+				return
+			}
+			if !isThumbnailMediaType(mediaType) {
+				respondWithErrorCode(w, errCodeInvalidMediaType, "Invalid thumbnail type", nil, nil)
+				return
+			}
+
+			// Thumbnails are small, so read this one in full and store it
+			// in the background while the video part is still arriving
+			data, err := io.ReadAll(io.LimitReader(part, thumbnailUploadLimit+1))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Unable to read thumbnail", err)
+				return
+			}
+			if len(data) > thumbnailUploadLimit {
+				respondWithErrorCode(w, errCodeFileTooLarge, "Thumbnail exceeds the size limit", nil, nil)
+				return
+			}
+			thumbnailDone = make(chan thumbnailResult, 1)
+			go func() {
+				thumbnail, err := cfg.storeVideoThumbnail(mediaType, bytes.NewReader(data))
+				thumbnailDone <- thumbnailResult{thumbnail, err}
+			}()
+
+		case "video":
+			if upload != nil {
+				respondWithError(w, http.StatusBadRequest, "Only one video is allowed", nil)
+				return
+			}
+			settings, err := cfg.resolveUploadSettings(userID, plan, fields["profile"], fields["notify"])
+			if err != nil {
+				respondWithRequestError(w, err)
+				return
+			}
+			priority, err := parseUploadPriority(fields["priority"])
+			if err != nil {
+				respondWithRequestError(w, err)
+				return
+			}
+			upload = &ingest.Upload{
+				Video:     video,
+				MediaType: mediaType,
+				Profile:   settings.Profile,
+				Notify:    settings.Notify,
+				Priority:  priority,
+				Body:      part,
+			}
+			if err := cfg.ingest.Receive(r.Context(), upload); err != nil {
+				respondWithIngestError(w, err)
+				return
+			}
+			cfg.recordVideoUpload(video.ID, cfg.newHTTPVideoUpload(r, part.FileName(), &upload.Size))
+		}
+		part.Close()
+	}
+
+	var missing []fieldError
+	if upload == nil {
+		missing = append(missing, fieldError{Field: "video", Message: "is required"})
+	}
+	if thumbnailDone == nil {
+		missing = append(missing, fieldError{Field: "thumbnail", Message: "is required"})
+	}
+	if len(missing) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Both a video and a thumbnail are required", nil, missing)
+		return
+	}
+
+	previousThumbnailURL := video.ThumbnailURL
+
+	// Workers or the transcoder finish the video later, so attach the thumbnail now
+	if !cfg.ingest.Inline() {
+		result := awaitThumbnail()
+		if result.err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", result.err)
+			return
+		}
+		result.thumbnail.apply(&video)
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		thumbnailKept = true
+		cfg.recordThumbnail(video, previousThumbnailURL, result.thumbnail)
+		cfg.purgeCachedVideo(video)
+		jobHandedOff = true
+		cfg.startAsyncJob(upload.Job)
+		w.Header().Set("Location", apiPathFor(r, "/api/jobs/"+upload.Job.ID.String()))
+		respondWithJSON(w, http.StatusAccepted, upload.Job)
+		return
+	}
+
+	// Wait for the thumbnail only once the video has been processed, and
+	// record both in the write that completes the job
+	var thumbnail storedThumbnail
+	var thumbnailErr error
+	upload.Update = func(v *database.Video) {
+		result := awaitThumbnail()
+		if result.err != nil {
+			thumbnailErr = result.err
+			return
+		}
+		thumbnail = result.thumbnail
+		thumbnail.apply(v)
+	}
+	// Process settles the job itself, whether or not it succeeds
+	jobHandedOff = true
+	if err := cfg.ingest.Process(r.Context(), upload); err != nil {
+		respondWithIngestError(w, err)
+		return
+	}
+	video = upload.Result
+	if thumbnailErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Video was saved but its thumbnail couldn't be", thumbnailErr)
+		return
+	}
+	thumbnailKept = true
+	cfg.recordThumbnail(video, previousThumbnailURL, thumbnail)
+
+	respondWithJSON(w, http.StatusOK, cfg.withURLs(r.Context(), video))
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads send a video in chunks, each appended at the offset the
+// server reports, so a dropped connection only costs the chunk in flight:
+//
+//	POST   /api/v1/videos/{videoID}/uploads  start a session
+//	PATCH  /api/v1/uploads/{uploadID}        append a chunk at Upload-Offset
+//	GET    /api/v1/uploads/{uploadID}        find where to carry on from
+//	POST   /api/v1/uploads/{uploadID}/complete  process the file
+//	DELETE /api/v1/uploads/{uploadID}        give up
+
+// Header a chunk's starting offset is sent in, and the session's offset is
+// reported in
+const uploadOffsetHeader = "Upload-Offset"
+
+// uploadLocks stops two chunks of the same session being written at once
+type uploadLocks struct {
+	mu   sync.Mutex
+	busy map[uuid.UUID]bool
+}
+
+// Function to claim a session for writing, reporting false if it's already claimed
+func (l *uploadLocks) lock(id uuid.UUID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.busy == nil {
+		l.busy = map[uuid.UUID]bool{}
+	}
+	if l.busy[id] {
+		return false
+	}
+	l.busy[id] = true
+	return true
+}
+
+func (l *uploadLocks) unlock(id uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.busy, id)
+}
+
+func (cfg *apiConfig) handlerUploadSessionCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Size is optional, for streams whose length isn't known until they end
+		Size      *int64 `json:"size"`
+		MediaType string `json:"media_type"`
+		Profile   string `json:"profile"`
+		Notify    string `json:"notify"`
+		Priority  string `json:"priority"`
+		// Filename is the name of the file on the client, kept for the owner
+		Filename   string `json:"filename"`
+		ExternalID string `json:"external_id"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if !video.State.AcceptsUploads() {
+		respondWithErrorCode(w, errCodeConflict, fmt.Sprintf("Video is %s and can't take another upload", video.State), nil, nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	plan, err := cfg.userPlan(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	limit := cfg.planUploadLimit(plan)
+
+	details := []fieldError{}
+	if params.Size != nil && (*params.Size <= 0 || *params.Size > limit) {
+		details = append(details, fieldError{Field: "size", Message: fmt.Sprintf("must be between 1 and %d bytes", limit)})
+	}
+	mediaType, _, err := mime.ParseMediaType(params.MediaType)
+	if err != nil {
+		details = append(details, fieldError{Field: "media_type", Message: "must be a media type such as video/mp4"})
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid upload", nil, details)
+		return
+	}
+	// Defaults are applied when the upload completes, in case they change in between
+	if _, err := cfg.resolveUploadSettings(video.UserID, plan, params.Profile, params.Notify); err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+	priority, err := parseUploadPriority(params.Priority)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+	// Uploads without a size are checked against the quota again once they're complete
+	size := int64(0)
+	if params.Size != nil {
+		size = *params.Size
+	}
+	if err := cfg.checkStorageQuota(video.UserID, plan, size); err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	if !cfg.applyUploadExternalID(w, video, params.ExternalID) {
+		return
+	}
+
+	tempFile, err := cfg.scratch.CreateTempFile("tubely-session-*.part")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	tempFile.Close()
+
+	session, err := cfg.db.CreateUploadSession(database.CreateUploadSessionParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		ExpiresAt: time.Now().UTC().Add(cfg.uploadExpiry),
+		MediaType: mediaType,
+		Profile:   params.Profile,
+		Notify:    params.Notify,
+		Priority:  priority,
+		Size:      params.Size,
+		Filename:  cleanFilename(params.Filename),
+		TempPath:  tempFile.Name(),
+	})
+	if err != nil {
+		os.Remove(tempFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	w.Header().Set("Location", apiPathFor(r, "/api/uploads/"+session.ID.String()))
+	w.Header().Set(uploadOffsetHeader, "0")
+	respondWithJSON(w, http.StatusCreated, session)
+}
+
+func (cfg *apiConfig) handlerUploadSessionGet(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.getOwnedUploadSession(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+// Function to append a chunk to an upload. The chunk must start where the
+// upload has got to; whatever arrives before a dropped connection is kept.
+func (cfg *apiConfig) handlerUploadSessionAppend(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.getOwnedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid chunk", err, []fieldError{
+			{Field: uploadOffsetHeader, Message: "must be the offset the chunk starts at"},
+		})
+		return
+	}
+	if !cfg.uploadLocks.lock(session.ID) {
+		respondWithErrorCode(w, errCodeConflict, "Another chunk of this upload is being sent", nil, nil)
+		return
+	}
+	defer cfg.uploadLocks.unlock(session.ID)
+
+	// Reload now nobody else can move the offset
+	session, err = cfg.db.GetUploadSession(session.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	if offset != session.Offset {
+		respondWithErrorCode(w, errCodeConflict, fmt.Sprintf("Chunk must start at offset %d", session.Offset), nil, nil)
+		return
+	}
+
+	// Uploads without a size are held to the plan the user is on now
+	limit := int64(0)
+	if session.Size != nil {
+		limit = *session.Size
+	} else if limit, err = cfg.userUploadLimit(session.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload limit", err)
+		return
+	}
+	remaining := limit - session.Offset
+	if r.ContentLength > remaining {
+		respondWithErrorCode(w, errCodeFileTooLarge, fmt.Sprintf("Chunk goes past the end of the upload, %d bytes remain", remaining), nil, nil)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, remaining)
+	cfg.beginUploadBody(w, r)
+
+	file, ok := cfg.openUploadSessionFile(w, session)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	// Anything a failed write left past the offset is written over. An
+	// encrypted file only takes the same bytes there again, and the upload
+	// is only read up to its offset, so nothing else of it is ever used.
+	if _, err := file.Seek(session.Offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+	written, copyErr := io.Copy(file, r.Body)
+	if err := file.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	if written > 0 {
+		if _, err := cfg.db.AdvanceUploadSession(session.ID, session.Offset, session.Offset+written); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record chunk", err)
+			return
+		}
+		session.Offset += written
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+
+	if copyErr != nil {
+		if errors.Is(copyErr, processing.ErrScratchRewrite) {
+			respondWithErrorCode(w, errCodeConflict, fmt.Sprintf("Chunk doesn't match what already arrived at offset %d, send the same bytes again", session.Offset), copyErr, nil)
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(copyErr, &maxBytesErr) {
+			respondWithErrorCode(w, errCodeFileTooLarge, fmt.Sprintf("Chunk goes past the end of the upload, %d bytes remain", remaining), copyErr, nil)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Chunk was cut short", copyErr)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+// Function to process an upload once every chunk has arrived, answering the
+// way POST /api/v1/video_upload/{videoID} does
+func (cfg *apiConfig) handlerUploadSessionComplete(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.getOwnedUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.uploadLocks.lock(session.ID) {
+		respondWithErrorCode(w, errCodeConflict, "A chunk of this upload is still being sent", nil, nil)
+		return
+	}
+	defer cfg.uploadLocks.unlock(session.ID)
+
+	session, err := cfg.db.GetUploadSession(session.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if session.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+	if session.Offset == 0 || (session.Size != nil && session.Offset != *session.Size) {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+		respondWithErrorCode(w, errCodeConflict, fmt.Sprintf("Upload is incomplete, %d bytes have arrived", session.Offset), nil, nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	// The user's plan may have changed, or they may have stored more, since the upload started
+	plan, err := cfg.userPlan(session.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if err := cfg.checkPlanUpload(session.UserID, plan, session.Offset); err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+	settings, err := cfg.resolveUploadSettings(session.UserID, plan, session.Profile, session.Notify)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	file, ok := cfg.openUploadSessionFile(w, session)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	upload := &ingest.Upload{
+		Video:     video,
+		MediaType: session.MediaType,
+		Profile:   settings.Profile,
+		Notify:    settings.Notify,
+		Priority:  session.Priority,
+		Body:      io.LimitReader(file, session.Offset),
+	}
+	if err := cfg.ingest.Run(r.Context(), upload); err != nil {
+		respondWithIngestError(w, err)
+		return
+	}
+	cfg.recordVideoUpload(video.ID, cfg.newHTTPVideoUpload(r, session.Filename, &session.Offset))
+
+	// The pipeline kept its own copy, so the chunks aren't needed any more
+	cfg.deleteUploadSession(session)
+	cfg.respondWithIngestedUpload(w, r, upload)
+}
+
+func (cfg *apiConfig) handlerUploadSessionDelete(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.getOwnedUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.uploadLocks.lock(session.ID) {
+		respondWithErrorCode(w, errCodeConflict, "A chunk of this upload is still being sent", nil, nil)
+		return
+	}
+	defer cfg.uploadLocks.unlock(session.ID)
+
+	cfg.deleteUploadSession(session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to get the upload session named in the path, responding with an error
+// and false if it doesn't exist, has expired or belongs to someone else
+func (cfg *apiConfig) getOwnedUploadSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return database.UploadSession{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.UploadSession{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.UploadSession{}, false
+	}
+
+	session, err := cfg.db.GetUploadSession(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.UploadSession{}, false
+	}
+	if session.ID == uuid.Nil || session.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return database.UploadSession{}, false
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		respondWithErrorCode(w, errCodeExpired, "Upload has expired, start a new one", nil, nil)
+		return database.UploadSession{}, false
+	}
+	return session, true
+}
+
+// Function to open the chunks an upload session has received, responding
+// with an error and false if they can't be read. Encrypted chunks can only
+// be read by the process that received them, so a restart loses them.
+func (cfg *apiConfig) openUploadSessionFile(w http.ResponseWriter, session database.UploadSession) (*processing.ScratchFile, bool) {
+	file, err := cfg.scratch.OpenFile(session.TempPath)
+	if errors.Is(err, processing.ErrScratchKeyLost) {
+		cfg.deleteUploadSession(session)
+		respondWithErrorCode(w, errCodeExpired, "Upload was interrupted by a restart, start a new one", err, nil)
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return nil, false
+	}
+	return file, true
+}
+
+// Function to delete an upload session and the chunks it received
+func (cfg *apiConfig) deleteUploadSession(session database.UploadSession) {
+	if err := os.Remove(session.TempPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't delete upload file %s: %v", session.TempPath, err)
+	}
+	if err := cfg.db.DeleteUploadSession(session.ID); err != nil {
+		log.Printf("Couldn't delete upload %s: %v", session.ID, err)
+	}
+}
+
+// Function to delete expired upload sessions, checking every interval until ctx is cancelled
+func (cfg *apiConfig) pruneUploadSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sessions, err := cfg.db.GetUploadSessions(time.Now().UTC(), true)
+		if err != nil {
+			log.Printf("Couldn't list expired uploads: %v", err)
+		}
+		for _, session := range sessions {
+			if cfg.uploadLocks.lock(session.ID) {
+				cfg.deleteUploadSession(session)
+				cfg.uploadLocks.unlock(session.ID)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
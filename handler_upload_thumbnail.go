@@ -1,15 +1,46 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"log"
 	"mime"
 	"net/http"
 	"os"
-	
+	"path/filepath"
+	"strings"
+
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
 	"github.com/google/uuid"
 )
 
+// Number of blurhash components along each axis of a thumbnail placeholder
+const (
+	blurHashXComponents = 4
+	blurHashYComponents = 3
+)
+
+// Longest side of a thumbnail's crop variants
+const thumbnailVariantMaxDimension = 1280
+
+// Crops stored alongside every video thumbnail, named by aspect ratio, so
+// players, grids and vertical feeds can each show one that fits without
+// stretching it
+var thumbnailVariants = []struct {
+	Name          string
+	Width, Height int
+}{
+	{Name: "16:9", Width: 16, Height: 9},
+	{Name: "1:1", Width: 1, Height: 1},
+	{Name: "9:16", Width: 9, Height: 16},
+}
+
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -30,6 +61,23 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Get the video's metadata from database
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+
+	// Check if the authenticated user is not the video owner
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		return
+	}
+
+	// Everything that can be checked without the body has passed, so let the
+	// client send it
+	cfg.beginUploadBody(w, r)
+
 	// Setup a constant for max memory (10 MB)
 	const maxMemory = 10 << 20
 
@@ -47,59 +95,292 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	// Gather the media type from the form file's header
 	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		respondWithErrorCode(w, errCodeInvalidMediaType, "Invalid Content-Type", err, nil)
 		return
 	}
 
 	// Verify correct mediaType - either image/jpeg or image/png
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+	if !isThumbnailMediaType(mediaType) {
+		respondWithErrorCode(w, errCodeInvalidMediaType, "Invalid file type", nil, nil)
 		return
 	}
 
-	// Gather assetPath for data file
-	assetPath := getAssetPath(mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	video, err = cfg.saveThumbnail(video, mediaType, file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.withURLs(r.Context(), video))
+}
+
+// Function to check that an uploaded image is a type thumbnails can be stored as
+func isThumbnailMediaType(mediaType string) bool {
+	return mediaType == "image/jpeg" || mediaType == "image/png"
+}
+
+// Function to store a thumbnail image as a video's thumbnail and update the video
+func (cfg *apiConfig) saveThumbnail(video database.Video, mediaType string, src io.Reader) (database.Video, error) {
+	thumbnail, err := cfg.storeVideoThumbnail(mediaType, src)
+	if err != nil {
+		return database.Video{}, err
+	}
+	previousURL := video.ThumbnailURL
+	thumbnail.apply(&video)
+
+	//Update database with new video metadata
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return database.Video{}, err
+	}
+	cfg.recordThumbnail(video, previousURL, thumbnail)
+	cfg.purgeCachedVideo(video)
+
+	return video, nil
+}
+
+// storedThumbnail is a thumbnail saved to the assets directory, ready to be
+// attached to a video
+type storedThumbnail struct {
+	Location  string
+	AssetPath string
+	Bytes     int64
+	Analysis  *thumbnailAnalysis
+	Variants  []storedThumbnailVariant
+}
+
+// storedThumbnailVariant is one of a thumbnail's crops, saved next to it
+type storedThumbnailVariant struct {
+	Name      string
+	Location  string
+	AssetPath string
+	Bytes     int64
+}
+
+// Function to point a video at a stored thumbnail
+func (t storedThumbnail) apply(video *database.Video) {
+	location := t.Location
+	video.ThumbnailURL = &location
+	video.ThumbnailVariants = map[string]string{}
+	for _, variant := range t.Variants {
+		video.ThumbnailVariants[variant.Name] = variant.Location
+	}
+	video.ThumbnailBlurHash = nil
+	video.ThumbnailDominantColor = nil
+	video.ThumbnailPHash = nil
+	if t.Analysis != nil {
+		video.ThumbnailBlurHash = &t.Analysis.BlurHash
+		video.ThumbnailDominantColor = &t.Analysis.DominantColor
+		video.ThumbnailPHash = &t.Analysis.PHash
+	}
+}
+
+// Function to save a thumbnail image to the assets directory and analyze it
+func (cfg *apiConfig) storeThumbnail(mediaType string, src io.Reader) (storedThumbnail, error) {
 
 	// Create file on server
-	dst, err := os.Create(assetDiskPath)
+	dst, assetPath, err := cfg.createAsset(mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
-		return
+		return storedThumbnail{}, err
 	}
 	defer dst.Close()
 
 	// Save data to newly created file
-	if _, err = io.Copy(dst, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
-		return
+	written, err := io.Copy(dst, src)
+	if err != nil {
+		return storedThumbnail{}, err
 	}
 
-	// Get the video's metadata from database
-	video, err := cfg.db.GetVideo(videoID)
+	// Analyze the stored thumbnail for placeholder data and duplicate detection;
+	// a failure here shouldn't fail the upload
+	thumbnail := storedThumbnail{
+		Location:  assetLocation(assetPath),
+		AssetPath: assetPath,
+		Bytes:     written,
+	}
+	analysis, err := analyzeThumbnail(dst)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
-		return
+		log.Printf("Couldn't analyze thumbnail %s: %v", assetPath, err)
+	} else {
+		thumbnail.Analysis = &analysis
 	}
 
-	// Check if the authenticated user is not the video owner
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
-		return
+	return thumbnail, nil
+}
+
+// Function to save a video's thumbnail to the assets directory along with its crop variants.
+// Variants that can't be made are logged, and the thumbnail is kept without them.
+func (cfg *apiConfig) storeVideoThumbnail(mediaType string, src io.Reader) (storedThumbnail, error) {
+	thumbnail, err := cfg.storeThumbnail(mediaType, src)
+	if err != nil {
+		return storedThumbnail{}, err
+	}
+	variants, err := cfg.storeThumbnailVariants(mediaType, thumbnail.AssetPath)
+	if err != nil {
+		log.Printf("Couldn't crop thumbnail %s: %v", thumbnail.AssetPath, err)
+		return thumbnail, nil
 	}
+	thumbnail.Variants = variants
+	return thumbnail, nil
+}
 
-	// Get asset URL
-	url := cfg.getAssetURL(assetPath)
+// Function to crop a stored thumbnail to each variant's aspect ratio, saving each crop
+// in the thumbnail's format next to it
+func (cfg *apiConfig) storeThumbnailVariants(mediaType, assetPath string) ([]storedThumbnailVariant, error) {
+	file, err := os.Open(cfg.getAssetDiskPath(assetPath))
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
 
-	// Update video URL metadata with asset on server
-	video.ThumbnailURL = &url
+	variants := []storedThumbnailVariant{}
+	for _, spec := range thumbnailVariants {
+		crop := imaging.Fit(imaging.Crop(img, spec.Width, spec.Height), thumbnailVariantMaxDimension)
+		var encoded bytes.Buffer
+		if mediaType == "image/png" {
+			err = png.Encode(&encoded, crop)
+		} else {
+			err = jpeg.Encode(&encoded, crop, &jpeg.Options{Quality: 90})
+		}
+		if err == nil {
+			variantPath := thumbnailVariantPath(assetPath, spec.Width, spec.Height)
+			err = os.WriteFile(cfg.getAssetDiskPath(variantPath), encoded.Bytes(), 0644)
+			variants = append(variants, storedThumbnailVariant{
+				Name:      spec.Name,
+				Location:  assetLocation(variantPath),
+				AssetPath: variantPath,
+				Bytes:     int64(encoded.Len()),
+			})
+		}
+		if err != nil {
+			cfg.removeThumbnailVariants(variants)
+			return nil, err
+		}
+	}
+	return variants, nil
+}
 
-	//Update database with new video metadata
-	err = cfg.db.UpdateVideo(video)
+// Function to get where a thumbnail's crop to an aspect ratio is stored, like abc-16x9.jpeg for abc.jpeg
+func thumbnailVariantPath(assetPath string, width, height int) string {
+	ext := filepath.Ext(assetPath)
+	return fmt.Sprintf("%s-%dx%d%s", strings.TrimSuffix(assetPath, ext), width, height, ext)
+}
+
+// Function to delete crop variants from the assets directory, logging what can't be deleted
+func (cfg *apiConfig) removeThumbnailVariants(variants []storedThumbnailVariant) {
+	for _, variant := range variants {
+		if err := os.Remove(cfg.getAssetDiskPath(variant.AssetPath)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't delete thumbnail variant %s: %v", variant.AssetPath, err)
+		}
+	}
+}
+
+// Function to delete a stored thumbnail and its crops that never made it onto a video
+func (cfg *apiConfig) removeStoredThumbnail(t storedThumbnail) {
+	if err := os.Remove(cfg.getAssetDiskPath(t.AssetPath)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't delete thumbnail %s: %v", t.AssetPath, err)
+	}
+	cfg.removeThumbnailVariants(t.Variants)
+}
+
+// Function to add a video's new thumbnail to the storage ledger and its thumbnail history,
+// keeping the thumbnail it replaced so it can be reverted to
+func (cfg *apiConfig) recordThumbnail(video database.Video, previousURL *string, t storedThumbnail) {
+	cfg.recordStoredObject(database.RecordStoredObjectParams{
+		UserID:  video.UserID,
+		VideoID: uuid.NullUUID{UUID: video.ID, Valid: true},
+		Kind:    database.StorageKindThumbnail,
+		Key:     t.AssetPath,
+		Bytes:   t.Bytes,
+	})
+	for _, variant := range t.Variants {
+		cfg.recordStoredObject(database.RecordStoredObjectParams{
+			UserID:  video.UserID,
+			VideoID: uuid.NullUUID{UUID: video.ID, Valid: true},
+			Kind:    database.StorageKindThumbnail,
+			Key:     variant.AssetPath,
+			Bytes:   variant.Bytes,
+		})
+	}
+
+	// Thumbnails stored before history was kept join it once they're replaced
+	if previousURL != nil {
+		if assetPath, ok := cfg.getAssetPathFromURL(*previousURL); ok && assetPath != t.AssetPath {
+			if err := cfg.rememberUntrackedThumbnail(video.ID, assetPath); err != nil {
+				log.Printf("Couldn't keep previous thumbnail of video %s: %v", video.ID, err)
+			}
+		}
+	}
+
+	params := database.CreateThumbnailParams{
+		VideoID:   video.ID,
+		AssetPath: t.AssetPath,
+		Bytes:     t.Bytes,
+		Variants:  map[string]string{},
+	}
+	for _, variant := range t.Variants {
+		params.Variants[variant.Name] = variant.AssetPath
+	}
+	if t.Analysis != nil {
+		params.BlurHash = &t.Analysis.BlurHash
+		params.DominantColor = &t.Analysis.DominantColor
+		params.PHash = &t.Analysis.PHash
+	}
+	if _, err := cfg.db.CreateThumbnail(params); err != nil {
+		log.Printf("Couldn't add thumbnail to history of video %s: %v", video.ID, err)
+	}
+}
+
+// Function to add a replaced thumbnail to a video's history if it isn't there already
+func (cfg *apiConfig) rememberUntrackedThumbnail(videoID uuid.UUID, assetPath string) error {
+	existing, err := cfg.db.GetThumbnailByAssetPath(videoID, assetPath)
+	if err != nil || existing.ID != uuid.Nil {
+		return err
+	}
+	info, err := os.Stat(cfg.getAssetDiskPath(assetPath))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return cfg.db.CreateSupersededThumbnail(database.CreateThumbnailParams{
+		VideoID:   videoID,
+		AssetPath: assetPath,
+		Bytes:     info.Size(),
+	})
+}
+
+type thumbnailAnalysis struct {
+	BlurHash      string
+	DominantColor string
+	PHash         string
+}
+
+// Function to compute the placeholder data and perceptual hash of a stored thumbnail
+func analyzeThumbnail(file io.ReadSeeker) (thumbnailAnalysis, error) {
+
+	// Rewind to the start of the file before decoding the image
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return thumbnailAnalysis{}, err
+	}
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return thumbnailAnalysis{}, err
+	}
+
+	blurHash, err := imaging.BlurHash(img, blurHashXComponents, blurHashYComponents)
+	if err != nil {
+		return thumbnailAnalysis{}, err
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	return thumbnailAnalysis{
+		BlurHash:      blurHash,
+		DominantColor: imaging.DominantColor(img),
+		PHash:         imaging.FormatHash(imaging.PerceptualHash(img)),
+	}, nil
 }
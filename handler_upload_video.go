@@ -2,30 +2,34 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"net/http"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-
-	// Set limit for upload to 1 GB
-	const uploadLimit = 1 << 30
-
-	// Set http body with upload limit
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+// Longest original filename and user agent kept with an upload, in bytes
+const (
+	maxFilenameLength  = 255
+	maxUserAgentLength = 512
+)
 
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	// Extract the videoID from the URL path parameters and parse it as a UUID
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -61,193 +65,324 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Parse the uploaded video file from the form data
-	file, handler, err := r.FormFile("video")
+	// Hold the upload to the user's plan
+	plan, err := cfg.userPlan(userID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
 		return
 	}
-	defer file.Close()
-
-	// Validate the uploaded file to ensure it's an MP4 video
-	mediaType, _, err := mime.ParseMediaType(handler.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+	limit := cfg.planUploadLimit(plan)
+	if !limitUploadBody(w, r, limit) {
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type, only MP4 is allowed", nil)
+	if err := cfg.checkStorageQuota(userID, plan, r.ContentLength); err != nil {
+		respondWithRequestError(w, err)
 		return
 	}
 
-	// Save the uploaded file to a temporary file on disk
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	// Everything that can be checked without the body has passed, so let the
+	// client send it
+	cfg.beginUploadBody(w, r)
+
+	// Read the parts as they arrive, so the video streams into the pipeline
+	// without being buffered first
+	reader, err := r.MultipartReader()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to read multipart body", err)
 		return
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	if _, err := io.Copy(tempFile, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
-		return
+	// Profile, notify and priority fields must come before the video part to apply to it
+	var upload *ingest.Upload
+	var filename string
+	fields := map[string]string{}
+
+	// A request that fails after the video was received discards its job
+	// along with its file
+	jobHandedOff := false
+	defer func() {
+		if upload != nil && upload.Job.ID != uuid.Nil && !jobHandedOff {
+			if _, err := cfg.processor.Cancel(context.WithoutCancel(r.Context()), upload.Job); err != nil {
+				log.Printf("Couldn't discard job %s: %v", upload.Job.ID, err)
+			}
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondWithErrorCode(w, errCodeFileTooLarge, fmt.Sprintf("Video exceeds your plan's %d byte limit", limit), err, nil)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, "Unable to read multipart body", err)
+			return
+		}
+
+		switch part.FormName() {
+		case "profile", "notify", "priority":
+			value, err := io.ReadAll(io.LimitReader(part, maxProfileNameLength+1))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Unable to read "+part.FormName(), err)
+				return
+			}
+			if upload != nil {
+				respondWithError(w, http.StatusBadRequest, "The "+part.FormName()+" must be sent before the video", nil)
+				return
+			}
+			fields[part.FormName()] = string(value)
+
+		case "external_id":
+			// The video takes the external ID sent with it before it's
+			// processed, so a clash is reported without waiting for processing
+			value, err := io.ReadAll(io.LimitReader(part, maxExternalIDLength+1))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Unable to read external_id", err)
+				return
+			}
+			if !cfg.applyUploadExternalID(w, video, string(value)) {
+				return
+			}
+
+		case "video":
+			if upload != nil {
+				respondWithError(w, http.StatusBadRequest, "Only one video is allowed", nil)
+				return
+			}
+
+			// Read the uploaded file's media type, the pipeline checks it's allowed
+			mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if err != nil {
+				respondWithErrorCode(w, errCodeInvalidMediaType, "Invalid Content-Type", err, nil)
+				return
+			}
+
+			// Process with the profile the client asked for, if any, or the defaults
+			settings, err := cfg.resolveUploadSettings(userID, plan, fields["profile"], fields["notify"])
+			if err != nil {
+				respondWithRequestError(w, err)
+				return
+			}
+			priority, err := parseUploadPriority(fields["priority"])
+			if err != nil {
+				respondWithRequestError(w, err)
+				return
+			}
+
+			upload = &ingest.Upload{
+				Video:     video,
+				MediaType: mediaType,
+				Profile:   settings.Profile,
+				Notify:    settings.Notify,
+				Priority:  priority,
+				Body:      part,
+			}
+			if err := cfg.ingest.Receive(r.Context(), upload); err != nil {
+				respondWithIngestError(w, err)
+				return
+			}
+			filename = part.FileName()
+		}
+		part.Close()
 	}
-
-	// Reset the tempFile's file pointer to the beginning
-	_, err = tempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
+	if upload == nil {
+		respondWithErrorCode(w, errCodeValidationFailed, "A video is required", nil, []fieldError{
+			{Field: "video", Message: "is required"},
+		})
 		return
 	}
 
-	// Determine aspect ratio of video from tempFile
-	directory := ""
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
+	// Process settles the job itself, whether or not it succeeds
+	jobHandedOff = true
+	if err := cfg.ingest.Process(r.Context(), upload); err != nil {
+		respondWithIngestError(w, err)
 		return
 	}
+	cfg.recordVideoUpload(video.ID, cfg.newHTTPVideoUpload(r, filename, &upload.Size))
+	cfg.respondWithIngestedUpload(w, r, upload)
+}
 
-	// Switch statement for specific aspect ratio
-	switch aspectRatio {
-	case "16:9":
-		directory = "landscape"
-	case "9:16":
-		directory = "portrait"
-	default:
-		directory = "other"
+// Function to describe an upload that arrived over HTTP, for recording on its video
+func (cfg *apiConfig) newHTTPVideoUpload(r *http.Request, filename string, size *int64) database.VideoUpload {
+	upload := newVideoUpload(filename, size)
+	if addr, ok := cfg.proxies.clientAddr(r); ok {
+		ip := addr.String()
+		upload.IP = &ip
 	}
+	if userAgent := truncateUTF8(r.UserAgent(), maxUserAgentLength); userAgent != "" {
+		upload.UserAgent = &userAgent
+	}
+	return upload
+}
 
+// Function to describe an upload of a file, whose name is "" and size nil when they aren't known
+func newVideoUpload(filename string, size *int64) database.VideoUpload {
+	uploadedAt := time.Now().UTC()
+	upload := database.VideoUpload{UploadedAt: &uploadedAt, Size: size}
+	if name := cleanFilename(filename); name != "" {
+		upload.OriginalFilename = &name
+	}
+	return upload
+}
 
-	// Setup key for video file
-	key := getAssetPath(mediaType)
-	key = filepath.Join(directory, key)
-
-	// Get Processed file path for video file
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
-		return
+// Function to record where a video's file came from. It's only there for the
+// owner's information, so failing to record it doesn't fail the upload.
+func (cfg *apiConfig) recordVideoUpload(videoID uuid.UUID, upload database.VideoUpload) {
+	if err := cfg.db.SetVideoUpload(videoID, upload); err != nil {
+		log.Printf("Couldn't record the upload of video %s: %v", videoID, err)
 	}
-	defer os.Remove(processedFilePath)
+}
 
-	// Open processed file path
-	processedFile, err := os.Open(processedFilePath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not open processed file", err)
-		return
+// Function to reduce a filename a client sent to its last path element,
+// without control characters, "" when nothing's left
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "." || name == "/" {
+		return ""
 	}
-	defer processedFile.Close()
+	return truncateUTF8(name, maxFilenameLength)
+}
 
-	// Put the object into S3
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:			aws.String(cfg.s3Bucket),
-		Key:			aws.String(key),
-		Body:			tempFile,
-		ContentType:	aws.String(mediaType),
-	})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
-		return
+// Function to cut a string to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
+	return s[:n]
+}
 
-	// Update the VideoURL of the video record in the database
-	url := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
-	video.VideoURL = &url
-	err = cfg.db.UpdateVideo(video)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+// Function to respond to an upload the pipeline has taken, with the processed
+// video, or with its job when processing carries on after the request
+func (cfg *apiConfig) respondWithIngestedUpload(w http.ResponseWriter, r *http.Request, upload *ingest.Upload) {
+	// Hand the job off and let the client poll for the result
+	if !cfg.ingest.Inline() {
+		cfg.startAsyncJob(upload.Job)
+		w.Header().Set("Location", apiPathFor(r, "/api/jobs/"+upload.Job.ID.String()))
+		respondWithJSON(w, http.StatusAccepted, upload.Job)
 		return
 	}
 
 	// Respond with data in JSON format
-	respondWithJSON(w, http.StatusOK, video)
+	respondWithJSON(w, http.StatusOK, cfg.withURLs(r.Context(), upload.Result))
 }
 
-// Function to get aspect ratio from provided filepath
-func getVideoAspectRatio(filePath string) (string, error) {
-
-	// Run ffprobe command with file path argument
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		filePath,
-	)
-
-	// Set exec.Cmd's Stdout field to a pointer to a new bytes.Buffer
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-
-	// Run the command
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe error: %v", err)
+// Function to build the pipeline that takes uploaded videos to processing jobs from
+// the configured stages, running jobs in the request unless a worker or the transcoder
+// finishes them
+func (cfg *apiConfig) newIngestPipeline(stages []ingest.StageConfig) (*ingest.Pipeline, error) {
+	// Uploads can only be held in memory when ffmpeg processes them in the
+	// request, since nothing else can get at them
+	persist := ingest.PersistTemp{
+		Files:      cfg.scratch,
+		Inspectors: []ingest.Inspector{ingest.Sniff{}},
 	}
-
-	// Unmarshal stdout of the command into a JSON struct for width and height
-	var output struct {
-		Streams []struct {
-			Width	int `json:"width"`
-			Height	int `json:"height"`
-		} `json:"streams"`
+	if cfg.clamav != nil {
+		persist.Inspectors = append(persist.Inspectors, *cfg.clamav)
 	}
-	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
-		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
+	inline := !cfg.processesAsync() && slices.ContainsFunc(stages, func(stage ingest.StageConfig) bool {
+		return stage.Name == "process"
+	})
+	if inline && cfg.processor.Transcoder.Name() == processing.TranscoderFFmpeg {
+		persist.MemoryLimit = cfg.memoryUploadLimit
 	}
 
-	// Check to ensure video stream is found
-	if len(output.Streams) == 0 {
-		return "", errors.New("no video streams found")
+	store := ingest.Store{
+		Storage: cfg.getVideoStorage,
+		Keys:    cfg.keyTemplate,
+		NewName: getAssetID,
+		Exists:  cfg.objectExists,
+		Files:   cfg.scratch,
+	}
+	if cfg.processingMode == processingModeWorker {
+		store.Staging = &ingest.Staging{
+			Client: cfg.s3Client,
+			Bucket: cfg.s3Bucket,
+			Prefix: cfg.stagingPrefix,
+			Name:   getAssetPath,
+		}
 	}
 
-	// Perform calculations to determine aspect ratio
-	width := output.Streams[0].Width
-	height := output.Streams[0].Height
-
-	if width == 16 * height / 9 {
-		return "16:9", nil
-	} else if height == 16 * width / 9 {
-		return "9:16", nil
+	available := map[string]ingest.Stage{
+		"validate": ingest.Validate{MediaTypes: []string{"video/mp4"}},
+		"persist":  persist,
+		"probe": ingest.Probe{
+			Prober:      ingest.CachedProber{DB: cfg.db, Scratch: cfg.scratch},
+			MinDuration: cfg.minVideoDuration,
+			MaxDuration: cfg.maxVideoDuration,
+			MaxDurationFor: func(video database.Video) (time.Duration, error) {
+				plan, err := cfg.userPlan(video.UserID)
+				if err != nil {
+					return 0, err
+				}
+				return cfg.planMaxVideoDuration(plan), nil
+			},
+		},
+		"thumbnail": ingest.Thumbnail{
+			Frame: func(filePath string, at float64) ([]byte, error) {
+				input, done, err := cfg.scratch.Plaintext(filePath)
+				if err != nil {
+					return nil, err
+				}
+				defer done()
+				return processing.ExtractFrame(input, at)
+			},
+			FrameData: processing.ExtractFrameData,
+			Save: func(video database.Video, jpeg []byte) (database.Video, error) {
+				return cfg.saveThumbnail(video, "image/jpeg", bytes.NewReader(jpeg))
+			},
+			At: time.Second,
+		},
+		"store":    store,
+		"finalize": ingest.Finalize{Jobs: cfg.db},
+		"process":  nil,
 	}
-	
-	return "other", nil
+	if !cfg.processesAsync() {
+		available["process"] = ingest.Process{Runner: cfg.processor}
+	}
+	pipeline, err := ingest.Build(stages, available)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.States = cfg.db
+	return pipeline, nil
 }
 
-// Function to setup "fast start" for processing videos
-func processVideoForFastStart(inputFilePath string) (string, error) {
-
-	// String for the output filepath
-	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
-
-	// Run command for ffmpeg
-	cmd := exec.Command("ffmpeg",
-		"-i", inputFilePath,
-		"-movflags", "faststart",
-		"-codec", "copy",
-		"-f", "mp4",
-		processedFilePath,
-	)
-
-	// Set exec.Cmd's Stderr field to a pointer to a new bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	// Run the command
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
-	}
+// Kinds of ingest failure and the error code each is reported with
+var ingestErrorCodes = map[ingest.Kind]errorCode{
+	ingest.KindInternal:          errCodeInternal,
+	ingest.KindInvalid:           errCodeBadRequest,
+	ingest.KindInvalidMediaType:  errCodeInvalidMediaType,
+	ingest.KindTooLarge:          errCodeFileTooLarge,
+	ingest.KindInvalidDuration:   errCodeInvalidDuration,
+	ingest.KindProcessingFailed:  errCodeProcessingFailed,
+	ingest.KindProcessingTimeout: errCodeProcessingTimeout,
+	ingest.KindMalware:           errCodeMalwareDetected,
+	ingest.KindCancelled:         errCodeCancelled,
+	ingest.KindConflict:          errCodeConflict,
+}
 
-	// Get file info via os.Stat
-	fileInfo, err := os.Stat(processedFilePath)
-	if err != nil {
-		return "", fmt.Errorf("could not stat processed file: %v", err)
+// Function to report an ingest failure with the error code for its kind
+func respondWithIngestError(w http.ResponseWriter, err error) {
+	var ingestErr *ingest.Error
+	if !errors.As(err, &ingestErr) {
+		respondWithRequestError(w, err)
+		return
 	}
-	
-	// Check processed file is not empty
-	if fileInfo.Size() == 0 {
-		return "", fmt.Errorf("processed file is empty")
+	code, ok := ingestErrorCodes[ingestErr.Kind]
+	if !ok {
+		code = errCodeInternal
 	}
-
-	return processedFilePath, nil
+	respondWithErrorCode(w, code, ingestErr.Msg, ingestErr, nil)
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/google/uuid"
+)
+
+// inlineStage stands in for processing, so the pipeline answers uploads in the request
+type inlineStage struct{}
+
+func (inlineStage) Name() string { return "process" }
+
+func (inlineStage) Run(ctx context.Context, u *ingest.Upload) error { return nil }
+
+func TestRespondWithIngestedUploadResolvesURLs(t *testing.T) {
+	thumbnail := assetLocation("thumbnail.jpg")
+	videoURL := "https://d111111abcdef8.cloudfront.net/landscape/video.mp4"
+	tests := []struct {
+		name       string
+		assets     urlProvider
+		wantPrefix string
+	}{
+		{name: "signed", assets: localURLs{origin: "https://tubely.example.com", secret: []byte("secret")}, wantPrefix: "https://tubely.example.com/assets/thumbnail.jpg?"},
+		{name: "cdn", assets: cdnURLs{baseURL: "https://assets.example.com"}, wantPrefix: "https://assets.example.com/thumbnail.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := apiConfig{
+				ingest: &ingest.Pipeline{ProcessStage: inlineStage{}},
+				urls: urlConfig{
+					Assets:      tt.assets,
+					AssetExpiry: time.Hour,
+					Videos:      cdnURLs{},
+					VideoExpiry: time.Hour,
+				},
+			}
+			upload := &ingest.Upload{Result: database.Video{
+				ID:           uuid.New(),
+				ThumbnailURL: &thumbnail,
+				VideoURL:     &videoURL,
+			}}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/video_upload/"+upload.Result.ID.String(), nil)
+			cfg.respondWithIngestedUpload(v1ResponseWriter{w}, r, upload)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			var got database.Video
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ThumbnailURL == nil || !strings.HasPrefix(*got.ThumbnailURL, tt.wantPrefix) {
+				t.Errorf("thumbnail_url = %v, want it to start with %s", got.ThumbnailURL, tt.wantPrefix)
+			}
+			if got.VideoURL == nil || *got.VideoURL != videoURL {
+				t.Errorf("video_url = %v, want %s", got.VideoURL, videoURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAPIKeyNameLength = 100
+	maxAPIKeysPerUser   = 20
+	// Characters of a key kept to tell it apart in listings
+	apiKeyPrefixLength = len(auth.APIKeyPrefix) + 8
+)
+
+type apiKeyCreatedResponse struct {
+	database.APIKey
+	// Key is only ever returned here, it can't be looked up again
+	Key string `json:"key"`
+}
+
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxAPIKeyNameLength {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid API key", nil, []fieldError{{
+			Field:   "name",
+			Message: fmt.Sprintf("must be between 1 and %d characters", maxAPIKeyNameLength),
+		}})
+		return
+	}
+
+	keys, err := cfg.db.GetUserAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+	if len(keys) >= maxAPIKeysPerUser {
+		respondWithErrorCode(w, errCodeConflict, fmt.Sprintf("Users can have at most %d API keys, revoke one first", maxAPIKeysPerUser), nil, nil)
+		return
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  userID,
+		Name:    params.Name,
+		Prefix:  key[:apiKeyPrefixLength],
+		KeyHash: auth.HashAPIKey(key),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, apiKeyCreatedResponse{APIKey: apiKey, Key: key})
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	keys, err := cfg.db.GetUserAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+
+	respondWithList(w, http.StatusOK, keys, listPage{Total: len(keys)})
+}
+
+func (cfg *apiConfig) handlerAPIKeyDelete(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+
+	key, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if key.ID == uuid.Nil || key.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteAPIKey(key.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to find the user an API key belongs to, a nil user if the key
+// is unknown or the account is being deleted
+func (cfg *apiConfig) authenticateAPIKey(key string) (*database.User, error) {
+	if !strings.HasPrefix(key, auth.APIKeyPrefix) {
+		return nil, nil
+	}
+	apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+	if err != nil || apiKey.ID == uuid.Nil {
+		return nil, err
+	}
+	user, err := cfg.db.GetUser(apiKey.UserID)
+	if err != nil || user == nil || user.DeletedAt != nil {
+		return nil, err
+	}
+	if err := cfg.db.TouchAPIKey(apiKey.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/google/uuid"
+)
+
+// Avatars are scaled down to fit this many pixels on either side
+const avatarMaxDimension = 512
+
+func (cfg *apiConfig) handlerUserAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	cfg.beginUploadBody(w, r)
+
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	// Avatars are checked the same way as thumbnails
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithErrorCode(w, errCodeInvalidMediaType, "Invalid Content-Type", err, nil)
+		return
+	}
+	if !isThumbnailMediaType(mediaType) {
+		respondWithErrorCode(w, errCodeInvalidMediaType, "Invalid file type", nil, nil)
+		return
+	}
+
+	// Re-encode the image at avatar size, which also drops any metadata it carried
+	img, _, err := image.Decode(file)
+	if err != nil {
+		respondWithErrorCode(w, errCodeInvalidMediaType, "File isn't a readable image", err, nil)
+		return
+	}
+	img = imaging.Fit(img, avatarMaxDimension)
+	var encoded bytes.Buffer
+	if mediaType == "image/png" {
+		err = png.Encode(&encoded, img)
+	} else {
+		err = jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode avatar", err)
+		return
+	}
+
+	stored, err := cfg.storeThumbnail(mediaType, &encoded)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar", err)
+		return
+	}
+	if err := cfg.db.SetUserAvatar(userID, &stored.Location); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	cfg.recordStoredObject(database.RecordStoredObjectParams{
+		UserID: userID,
+		Kind:   database.StorageKindAvatar,
+		Key:    stored.AssetPath,
+		Bytes:  stored.Bytes,
+	})
+	cfg.removeAvatar(user.AvatarURL)
+
+	user.AvatarURL = &stored.Location
+	respondWithJSON(w, http.StatusOK, cfg.withAvatarURL(r.Context(), *user))
+}
+
+func (cfg *apiConfig) handlerUserAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	if err := cfg.db.SetUserAvatar(userID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	cfg.removeAvatar(user.AvatarURL)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to delete a replaced avatar from the assets directory and the storage ledger
+func (cfg *apiConfig) removeAvatar(avatarURL *string) {
+	if avatarURL == nil {
+		return
+	}
+	assetPath, ok := cfg.getAssetPathFromURL(*avatarURL)
+	if !ok {
+		return
+	}
+	if err := os.Remove(cfg.getAssetDiskPath(assetPath)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't delete avatar %s: %v", assetPath, err)
+		return
+	}
+	cfg.forgetStoredObject("", assetPath)
+}
+
+// Function to delete a user's avatar as part of deleting their account
+func (cfg *apiConfig) purgeUserAvatar(userID uuid.UUID, plan *removalPlan) error {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil || user.AvatarURL == nil {
+		return err
+	}
+	if assetPath, ok := cfg.getAssetPathFromURL(*user.AvatarURL); ok {
+		return removeFile(cfg.getAssetDiskPath(assetPath), plan)
+	}
+	return nil
+}
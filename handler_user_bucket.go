@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// How long registering a bucket waits to confirm the role can reach it
+const userBucketCheckTimeout = 15 * time.Second
+
+var (
+	// Bucket names usable in virtual-hosted URLs
+	bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+	// AWS region names such as us-east-1
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]$`)
+)
+
+type userBucketResponse struct {
+	// Registration is null until the user registers a bucket
+	Registration *database.UserBucket `json:"registration"`
+	// ExternalID is the sts:ExternalId the role's trust policy must require
+	ExternalID string `json:"external_id"`
+}
+
+func (cfg *apiConfig) handlerUserBucketGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	bucket, err := cfg.db.GetUserBucket(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get bucket", err)
+		return
+	}
+
+	resp := userBucketResponse{ExternalID: userID.String()}
+	if bucket.UserID != uuid.Nil {
+		resp.Registration = &bucket
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// Registers the bucket the user's videos are stored in from now on, once the role is confirmed to reach it
+func (cfg *apiConfig) handlerUserBucketSet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := database.SetUserBucketParams{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.BaseURL = strings.TrimSuffix(params.BaseURL, "/")
+
+	details := []fieldError{}
+	if !bucketNamePattern.MatchString(params.Bucket) {
+		details = append(details, fieldError{Field: "bucket", Message: "must be 3-63 lowercase letters, digits and hyphens"})
+	} else if params.Bucket == cfg.s3Bucket {
+		details = append(details, fieldError{Field: "bucket", Message: "must be a bucket of your own"})
+	}
+	if !regionPattern.MatchString(params.Region) {
+		details = append(details, fieldError{Field: "region", Message: "must be an AWS region, such as us-east-1"})
+	}
+	if _, ok := processing.RoleAccountID(params.RoleARN); !ok {
+		details = append(details, fieldError{Field: "role_arn", Message: "must be an IAM role ARN, such as arn:aws:iam::123456789012:role/tubely"})
+	}
+	if params.BaseURL != "" {
+		if u, err := url.Parse(params.BaseURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			details = append(details, fieldError{Field: "base_url", Message: "must be an https URL"})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid bucket", nil, details)
+		return
+	}
+
+	owner, err := cfg.db.GetUserBucketByName(params.Bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check bucket", err)
+		return
+	}
+	if owner.UserID != uuid.Nil && owner.UserID != userID {
+		respondWithError(w, http.StatusConflict, "Bucket is registered to another user", nil)
+		return
+	}
+	if !cfg.checkUserBucketEmptied(w, userID, params.Bucket) {
+		return
+	}
+
+	// Nothing is saved unless the role can actually reach the bucket
+	ctx, cancel := context.WithTimeout(r.Context(), userBucketCheckTimeout)
+	defer cancel()
+	err = cfg.buckets.CheckUserBucket(ctx, database.UserBucket{UserID: userID, SetUserBucketParams: params})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't reach bucket with the role", err)
+		return
+	}
+
+	bucket, err := cfg.db.SetUserBucket(userID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save bucket", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, userBucketResponse{Registration: &bucket, ExternalID: userID.String()})
+}
+
+// Stops storing the user's videos in their bucket, once nothing is left in it
+func (cfg *apiConfig) handlerUserBucketDelete(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	if !cfg.checkUserBucketEmptied(w, userID, "") {
+		return
+	}
+
+	if err := cfg.db.DeleteUserBucket(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete bucket", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to check that the user's registered bucket, unless it's keep, holds none of their
+// videos, which would be unreachable once the service stops assuming the role, responding if it does
+func (cfg *apiConfig) checkUserBucketEmptied(w http.ResponseWriter, userID uuid.UUID, keep string) bool {
+	current, err := cfg.db.GetUserBucket(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get bucket", err)
+		return false
+	}
+	if current.UserID == uuid.Nil || current.Bucket == keep {
+		return true
+	}
+
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return false
+	}
+	count := 0
+	for _, video := range videos {
+		if bucket, _, err := cfg.getVideoLocation(video); err == nil && bucket == current.Bucket {
+			count++
+		}
+	}
+	if count > 0 {
+		respondWithError(w, http.StatusConflict,
+			fmt.Sprintf("%d videos are still stored in %s; delete them first", count, current.Bucket), nil)
+		return false
+	}
+	return true
+}
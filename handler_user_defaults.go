@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// uploadSettings are what an upload is processed and reported with
+type uploadSettings struct {
+	Profile processing.Profile
+	// Notify is the notification channel, empty for every channel the user set up
+	Notify string
+}
+
+func (cfg *apiConfig) handlerUploadDefaultsGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	defaults, err := cfg.db.GetUploadDefaults(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload defaults", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, defaults)
+}
+
+func (cfg *apiConfig) handlerUploadDefaultsSet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := database.SetUploadDefaultsParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	plan, err := cfg.userPlan(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	details := cfg.validateUploadDefaults(&params, func(name string) error {
+		_, err := cfg.resolvePlanProfile(plan, name)
+		return err
+	})
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid upload defaults", nil, details)
+		return
+	}
+
+	defaults, err := cfg.db.SetUploadDefaults(userID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload defaults", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, defaults)
+}
+
+// Function to check upload defaults before they're saved, using checkProfile to say
+// whether a profile can be chosen. Empty values are treated as unset.
+func (cfg *apiConfig) validateUploadDefaults(params *database.SetUploadDefaultsParams, checkProfile func(name string) error) []fieldError {
+	if params.Profile != nil && *params.Profile == "" {
+		params.Profile = nil
+	}
+	if params.Notify != nil && *params.Notify == "" {
+		params.Notify = nil
+	}
+
+	details := []fieldError{}
+	if params.Profile != nil {
+		if err := checkProfile(*params.Profile); err != nil {
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) {
+				reqErr = &requestError{msg: err.Error()}
+			}
+			details = append(details, fieldError{Field: "profile", Message: reqErr.msg})
+		}
+	}
+	if params.Notify != nil {
+		if err := cfg.checkNotifyChannel(*params.Notify); err != nil {
+			details = append(details, fieldError{Field: "notify", Message: err.Error()})
+		}
+	}
+	return details
+}
+
+// Function to check an upload can be reported on a notification channel
+func (cfg *apiConfig) checkNotifyChannel(channel string) error {
+	if !slices.Contains(notify.ChannelChoices, channel) {
+		return fmt.Errorf("must be one of %v", notify.ChannelChoices)
+	}
+	if channel == notify.ChannelEmail && !cfg.notifier.EmailEnabled() {
+		return errors.New("email notifications aren't available on this server")
+	}
+	return nil
+}
+
+// Function to settle what an upload is processed and reported with. Whatever the upload
+// didn't ask for is taken from the user's defaults, then the deployment's. A default
+// profile the user's plan no longer includes is passed over rather than failing uploads.
+func (cfg *apiConfig) resolveUploadSettings(userID uuid.UUID, plan database.Plan, profileName, channel string) (uploadSettings, error) {
+	settings := uploadSettings{Notify: channel}
+	if channel != "" {
+		if err := cfg.checkNotifyChannel(channel); err != nil {
+			return uploadSettings{}, newRequestError(errCodeValidationFailed, "Invalid notification channel: "+err.Error(), nil)
+		}
+	}
+	if profileName != "" {
+		profile, err := cfg.resolvePlanProfile(plan, profileName)
+		if err != nil {
+			return uploadSettings{}, err
+		}
+		settings.Profile = profile
+	}
+	if profileName != "" && channel != "" {
+		return settings, nil
+	}
+
+	for _, id := range []uuid.UUID{userID, uuid.Nil} {
+		defaults, err := cfg.db.GetUploadDefaults(id)
+		if err != nil {
+			return uploadSettings{}, err
+		}
+		if settings.Profile.Name == "" && defaults.Profile != nil {
+			profile, err := cfg.resolveProfile(*defaults.Profile)
+			if err == nil && plan.AllowsProfile(profile.Name) {
+				settings.Profile = profile
+			}
+		}
+		if settings.Notify == "" && defaults.Notify != nil {
+			settings.Notify = *defaults.Notify
+		}
+	}
+
+	if settings.Profile.Name == "" {
+		profile, err := cfg.resolveProfile("")
+		if err != nil {
+			return uploadSettings{}, err
+		}
+		settings.Profile = profile
+	}
+	return settings, nil
+}
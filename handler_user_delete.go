@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Timeout for delivering the account deletion webhook
+const deletionWebhookTimeout = 10 * time.Second
+
+func (cfg *apiConfig) handlerUserDelete(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	plan, ok := cfg.newRemovalPlan(w, r)
+	if !ok {
+		return
+	}
+
+	// A dry run walks the same purge without deleting anything or locking the account
+	if plan.DryRun {
+		if err := cfg.purgeUserData(r.Context(), userID, plan); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't plan account deletion", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, plan)
+		return
+	}
+
+	// Lock the account and end every session before anything is deleted, so
+	// nothing new can be uploaded while the purge runs
+	if err := cfg.db.LockUser(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lock account", err)
+		return
+	}
+	if err := cfg.db.RevokeUserRefreshTokens(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	go cfg.purgeUser(context.Background(), userID)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Function to reject requests carrying an access token for an account that's being deleted
+func (cfg *apiConfig) rejectDeletedUsers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Refresh tokens and invalid JWTs are left to the handlers
+		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user == nil || user.DeletedAt != nil {
+			respondWithError(w, http.StatusUnauthorized, "Account has been deleted", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Function to finish purging any accounts whose deletion was interrupted
+func (cfg *apiConfig) resumeUserPurges(ctx context.Context) {
+	userIDs, err := cfg.db.GetLockedUserIDs()
+	if err != nil {
+		log.Printf("Couldn't list accounts pending deletion: %v", err)
+		return
+	}
+	for _, userID := range userIDs {
+		cfg.purgeUser(ctx, userID)
+	}
+}
+
+// Function to delete everything stored for a user and then the user itself.
+// Each step is safe to repeat, so an interrupted purge is simply run again.
+func (cfg *apiConfig) purgeUser(ctx context.Context, userID uuid.UUID) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		log.Printf("Couldn't load user %s for deletion: %v", userID, err)
+		return
+	}
+
+	if err := cfg.purgeUserData(ctx, userID, nil); err != nil {
+		log.Printf("Couldn't purge data for user %s, will retry on restart: %v", userID, err)
+		return
+	}
+
+	if err := cfg.db.DeleteUser(userID); err != nil {
+		log.Printf("Couldn't delete user %s: %v", userID, err)
+		return
+	}
+	log.Printf("Deleted user %s", userID)
+
+	cfg.notifyUserDeleted(ctx, *user)
+}
+
+// Function to delete everything stored for a user but the user itself,
+// adding each thing to plan, or only adding them in a dry run
+func (cfg *apiConfig) purgeUserData(ctx context.Context, userID uuid.UUID, plan *removalPlan) error {
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		if err := cfg.purgeVideoStorage(ctx, video, plan); err != nil {
+			return fmt.Errorf("couldn't delete storage for video %s: %w", video.ID, err)
+		}
+		if err := cfg.deleteVideoRecords(video.ID, plan); err != nil {
+			return err
+		}
+		if !plan.dryRun() {
+			cfg.purgeCachedVideo(video)
+		}
+	}
+
+	if err := cfg.deleteObjectsWithPrefix(ctx, path.Join(exportsPrefix, userID.String())+"/", plan); err != nil {
+		return fmt.Errorf("couldn't delete exports: %w", err)
+	}
+	if err := cfg.purgeUserAvatar(userID, plan); err != nil {
+		return fmt.Errorf("couldn't delete avatar: %w", err)
+	}
+	deadLetterSources, err := cfg.db.GetUserDeadLetterSourceKeys(userID)
+	if err != nil {
+		return err
+	}
+	for _, key := range deadLetterSources {
+		if err := cfg.deleteObject(ctx, cfg.s3Bucket, key, plan); err != nil {
+			return fmt.Errorf("couldn't delete kept upload of failed job: %w", err)
+		}
+	}
+	sessions, err := cfg.db.GetUserUploadSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := removeFile(session.TempPath, plan); err != nil {
+			return fmt.Errorf("couldn't delete upload file: %w", err)
+		}
+	}
+	if cfg.sftp != nil {
+		dir := cfg.sftp.userDir(userID)
+		if plan.dryRun() {
+			if _, err := os.Stat(dir); err == nil {
+				plan.add(removal{Kind: removalFile, Path: dir})
+			}
+		} else if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("couldn't delete SFTP files: %w", err)
+		}
+	}
+
+	if plan.dryRun() {
+		records, err := cfg.db.CountUserRecords(userID)
+		if err != nil {
+			return err
+		}
+		plan.add(removal{Kind: removalAccount, ID: &userID, Records: records})
+		return nil
+	}
+	if err := cfg.db.DeleteUserExports(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserBulkOperations(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserComments(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserVideoLists(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.MarkUserStoredObjectsDeleted(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserDeadLetters(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserJobs(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserUploadSessions(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserAPIKeys(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserBucket(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteNotificationSettings(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUploadDefaults(userID); err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteUserStripeCustomers(userID); err != nil {
+		return err
+	}
+	return cfg.db.DeleteUserRefreshTokens(userID)
+}
+
+// Function to delete a video and its records, or only plan to
+func (cfg *apiConfig) deleteVideoRecords(videoID uuid.UUID, plan *removalPlan) error {
+	if plan.dryRun() {
+		records, err := cfg.db.CountVideoRecords(videoID)
+		if err != nil {
+			return err
+		}
+		plan.add(removal{Kind: removalVideo, ID: &videoID, Records: records})
+		return nil
+	}
+	plan.add(removal{Kind: removalVideo, ID: &videoID})
+	return cfg.db.DeleteVideo(videoID)
+}
+
+// Function to delete a video's stored file, thumbnail, candidate frames and clips
+func (cfg *apiConfig) purgeVideoStorage(ctx context.Context, video database.Video, plan *removalPlan) error {
+	if video.VideoURL != nil {
+		if bucket, key, err := cfg.getVideoLocation(video); err == nil {
+			// HLS videos are a playlist plus renditions under one prefix
+			if processing.FormatForKey(key) == processing.FormatHLS {
+				if err := cfg.deleteObjectsWithPrefixIn(ctx, bucket, path.Dir(key)+"/", plan); err != nil {
+					return err
+				}
+			} else if err := cfg.deleteObject(ctx, bucket, key, plan); err != nil {
+				return err
+			}
+		}
+	}
+
+	thumbnailURLs := []string{}
+	if video.ThumbnailURL != nil {
+		thumbnailURLs = append(thumbnailURLs, *video.ThumbnailURL)
+	}
+	for _, url := range video.ThumbnailVariants {
+		thumbnailURLs = append(thumbnailURLs, url)
+	}
+	for _, url := range thumbnailURLs {
+		if assetPath, ok := cfg.getAssetPathFromURL(url); ok {
+			if err := removeFile(cfg.getAssetDiskPath(assetPath), plan); err != nil {
+				return err
+			}
+		}
+	}
+	thumbnails, err := cfg.db.GetThumbnails(video.ID)
+	if err != nil {
+		return err
+	}
+	for _, thumbnail := range thumbnails {
+		if err := removeFile(cfg.getAssetDiskPath(thumbnail.AssetPath), plan); err != nil {
+			return err
+		}
+		for _, assetPath := range thumbnail.Variants {
+			if err := removeFile(cfg.getAssetDiskPath(assetPath), plan); err != nil {
+				return err
+			}
+		}
+	}
+
+	storages, err := cfg.getVideoStorages(video)
+	if err != nil {
+		return err
+	}
+	for _, storage := range storages {
+		for _, prefix := range []string{framesPrefix, clipsPrefix} {
+			if err := cfg.deleteObjectsWithPrefixIn(ctx, storage.Bucket, storage.Key(path.Join(prefix, video.ID.String()))+"/", plan); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Function to delete an object from a bucket, or only plan to
+func (cfg *apiConfig) deleteObject(ctx context.Context, bucket, key string, plan *removalPlan) error {
+	plan.add(removal{Kind: removalObject, Bucket: bucket, Key: key})
+	if plan.dryRun() {
+		return nil
+	}
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// Function to delete a file on disk if it's there, or only plan to
+func removeFile(filePath string, plan *removalPlan) error {
+	if plan.dryRun() {
+		if _, err := os.Stat(filePath); err == nil {
+			plan.add(removal{Kind: removalFile, Path: filePath})
+		}
+		return nil
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	plan.add(removal{Kind: removalFile, Path: filePath})
+	return nil
+}
+
+// Function to delete every object under a prefix in the deployment's bucket
+func (cfg *apiConfig) deleteObjectsWithPrefix(ctx context.Context, prefix string, plan *removalPlan) error {
+	return cfg.deleteObjectsWithPrefixIn(ctx, cfg.s3Bucket, prefix, plan)
+}
+
+// Function to delete every object under a prefix in a bucket, a page at a
+// time, or only list them into plan
+func (cfg *apiConfig) deleteObjectsWithPrefixIn(ctx context.Context, bucket, prefix string, plan *removalPlan) error {
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		return err
+	}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+			plan.add(removal{Kind: removalObject, Bucket: bucket, Key: aws.ToString(object.Key)})
+		}
+		if plan.dryRun() {
+			continue
+		}
+		_, err = client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to tell the configured webhook that an account has been erased,
+// e.g. so a mailer can send the user a confirmation
+func (cfg *apiConfig) notifyUserDeleted(ctx context.Context, user database.User) {
+	if cfg.deletionWebhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(struct {
+		UserID    uuid.UUID `json:"user_id"`
+		Email     string    `json:"email"`
+		DeletedAt time.Time `json:"deleted_at"`
+	}{
+		UserID:    user.ID,
+		Email:     user.Email,
+		DeletedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Couldn't encode deletion webhook for user %s: %v", user.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, deletionWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.deletionWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Couldn't build deletion webhook for user %s: %v", user.ID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Couldn't deliver deletion webhook for user %s: %v", user.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Deletion webhook for user %s returned %s", user.ID, resp.Status)
+	}
+}
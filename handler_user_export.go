@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Lifetimes of the export download link and of the media links inside an export
+const (
+	exportURLExpiry   = 15 * time.Minute
+	exportMediaExpiry = 7 * 24 * time.Hour
+)
+
+const exportsPrefix = "exports"
+
+type exportedVideo struct {
+	database.Video
+	ExternalID *string              `json:"external_id"`
+	Upload     database.VideoUpload `json:"upload"`
+	Metadata   map[string]string    `json:"metadata"`
+	Chapters   []database.Chapter   `json:"chapters"`
+}
+
+type exportedMedia struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Filename is the name the URL downloads the file as
+	Filename  string    `json:"filename"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (cfg *apiConfig) handlerUserExportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IncludeMediaURLs bool `json:"include_media_urls"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// The body is optional
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+
+	export, err := cfg.db.CreateExport(userID, params.IncludeMediaURLs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export", err)
+		return
+	}
+
+	// Archives can take a while for large libraries, so build it in the background
+	go cfg.buildExport(context.Background(), export)
+
+	w.Header().Set("Location", apiPathFor(r, "/api/exports/"+export.ID.String()))
+	respondWithJSON(w, http.StatusAccepted, export)
+}
+
+func (cfg *apiConfig) handlerExportGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Export
+		URL string `json:"url,omitempty"`
+	}
+
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	export, err := cfg.db.GetExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+	if export.ID == uuid.Nil || export.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Export not found", nil)
+		return
+	}
+	if export.State != database.ExportStateReady {
+		respondWithJSON(w, http.StatusOK, response{Export: export})
+		return
+	}
+
+	// Hand out the download link exactly once
+	claimed, err := cfg.db.ClaimExportDownload(export.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't claim export download", err)
+		return
+	}
+	if !claimed {
+		respondWithErrorCode(w, errCodeExpired, "Export has already been downloaded, request a new one", nil, nil)
+		return
+	}
+
+	url, err := cfg.generatePresignedURL(r.Context(), cfg.s3Bucket, *export.ObjectKey, exportURLExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign export URL", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	export.DownloadedAt = &now
+	respondWithJSON(w, http.StatusOK, response{Export: export, URL: url})
+}
+
+// Function to build a user's export archive, upload it and record the result
+func (cfg *apiConfig) buildExport(ctx context.Context, export database.Export) {
+	objectKey, err := cfg.writeExport(ctx, export)
+	if err != nil {
+		log.Printf("Export %s failed: %v", export.ID, err)
+		if err := cfg.db.FailExport(export.ID, err.Error()); err != nil {
+			log.Printf("Couldn't record failure of export %s: %v", export.ID, err)
+		}
+		return
+	}
+
+	if err := cfg.db.CompleteExport(export.ID, objectKey); err != nil {
+		log.Printf("Couldn't complete export %s: %v", export.ID, err)
+	}
+}
+
+// Function to zip up a user's video metadata, thumbnails and optional media links, returning the archive's key
+func (cfg *apiConfig) writeExport(ctx context.Context, export database.Export) (string, error) {
+	videos, err := cfg.db.GetVideos(export.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	archivePath := cfg.scratch.Path("export-" + export.ID.String() + ".zip")
+	archiveFile, err := os.Create(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archivePath)
+	defer archiveFile.Close()
+
+	archive := zip.NewWriter(archiveFile)
+
+	// Metadata for every video, with its chapters
+	exported := make([]exportedVideo, 0, len(videos))
+	for _, video := range videos {
+		chapters, err := cfg.db.GetChapters(video.ID)
+		if err != nil {
+			return "", err
+		}
+		exported = append(exported, exportedVideo{
+			Video:      video,
+			ExternalID: video.ExternalID,
+			Upload:     video.Upload,
+			Metadata:   video.Metadata,
+			Chapters:   chapters,
+		})
+	}
+	if err := writeZipJSON(archive, "videos.json", exported); err != nil {
+		return "", err
+	}
+
+	// Thumbnails stored on this server
+	for _, video := range videos {
+		if video.ThumbnailURL == nil {
+			continue
+		}
+		assetPath, ok := cfg.getAssetPathFromURL(*video.ThumbnailURL)
+		if !ok {
+			continue
+		}
+		if err := writeZipFile(archive, path.Join("thumbnails", video.ID.String()+path.Ext(assetPath)), cfg.getAssetDiskPath(assetPath)); err != nil {
+			return "", err
+		}
+	}
+
+	// Links to the media itself, which is too large to copy into the archive
+	if export.IncludeMediaURLs {
+		linked := []database.Video{}
+		objects := []presignObject{}
+		for _, video := range videos {
+			if video.VideoURL == nil {
+				continue
+			}
+			bucket, key, err := cfg.getVideoLocation(video)
+			if err != nil {
+				continue
+			}
+			linked = append(linked, video)
+			objects = append(objects, presignObject{Bucket: bucket, Key: key, Filename: downloadFilename(video, key)})
+		}
+
+		expiresAt := time.Now().UTC().Add(exportMediaExpiry)
+		urls, errs := cfg.generatePresignedURLs(ctx, objects, exportMediaExpiry)
+		manifest := []exportedMedia{}
+		for i, video := range linked {
+			// Archived videos can't be downloaded until they're restored
+			if errors.Is(errs[i], errVideoArchived) {
+				continue
+			}
+			if errs[i] != nil {
+				log.Printf("Couldn't link video %s in export %s: %v", video.ID, export.ID, errs[i])
+				continue
+			}
+			manifest = append(manifest, exportedMedia{
+				VideoID:   video.ID,
+				Filename:  objects[i].Filename,
+				URL:       urls[i],
+				ExpiresAt: expiresAt,
+			})
+		}
+		if err := writeZipJSON(archive, "media.json", manifest); err != nil {
+			return "", err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+	if _, err := archiveFile.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	objectKey := path.Join(exportsPrefix, export.UserID.String(), export.ID.String()+".zip")
+	_, err = cfg.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.s3Bucket),
+		Key:         aws.String(objectKey),
+		Body:        archiveFile,
+		ContentType: aws.String("application/zip"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't upload export: %w", err)
+	}
+
+	return objectKey, nil
+}
+
+// Function to add a value to a zip archive as an indented JSON file
+func writeZipJSON(archive *zip.Writer, name string, value any) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// Function to copy a file on disk into a zip archive
+func writeZipFile(archive *zip.Writer, name, diskPath string) error {
+	file, err := os.Open(diskPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// feedConfig decides what the per-user feeds link to.
+type feedConfig struct {
+	// PresignedURLs makes enclosures presigned URLs generated for each
+	// request, for buckets that aren't publicly readable.
+	PresignedURLs bool
+	// URLExpiry is how long presigned enclosure URLs stay valid.
+	URLExpiry time.Duration
+	// MaxItems is the number of most recent videos a feed lists.
+	MaxItems int
+}
+
+// Feed variants
+const (
+	feedKindRSS     = "rss"
+	feedKindAtom    = "atom"
+	feedKindPodcast = "podcast"
+)
+
+// How long feed readers may cache a feed whose enclosures are public URLs
+const feedCacheMaxAge = 5 * time.Minute
+
+type rssFeed struct {
+	XMLName  xml.Name   `xml:"rss"`
+	Version  string     `xml:"version,attr"`
+	AtomNS   string     `xml:"xmlns:atom,attr"`
+	ITunesNS string     `xml:"xmlns:itunes,attr,omitempty"`
+	Channel  rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title          string          `xml:"title"`
+	Link           string          `xml:"link"`
+	Description    string          `xml:"description"`
+	Self           atomLink        `xml:"atom:link"`
+	LastBuildDate  string          `xml:"lastBuildDate"`
+	ITunesAuthor   string          `xml:"itunes:author,omitempty"`
+	ITunesImage    *itunesImage    `xml:"itunes:image,omitempty"`
+	ITunesExplicit string          `xml:"itunes:explicit,omitempty"`
+	ITunesCategory *itunesCategory `xml:"itunes:category,omitempty"`
+	Items          []rssItem       `xml:"item"`
+}
+
+type rssItem struct {
+	Title          string        `xml:"title"`
+	Link           string        `xml:"link"`
+	Description    string        `xml:"description"`
+	GUID           rssGUID       `xml:"guid"`
+	PubDate        string        `xml:"pubDate"`
+	Enclosure      *rssEnclosure `xml:"enclosure,omitempty"`
+	ITunesDuration int           `xml:"itunes:duration,omitempty"`
+	ITunesImage    *itunesImage  `xml:"itunes:image,omitempty"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type itunesCategory struct {
+	Text string `xml:"text,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Summary   string     `xml:"summary"`
+	Links     []atomLink `xml:"link"`
+}
+
+// feedItem is a published video with the enclosure a feed links to
+type feedItem struct {
+	Video     database.Video
+	Enclosure *rssEnclosure
+}
+
+func (cfg *apiConfig) handlerUserFeedRSS(w http.ResponseWriter, r *http.Request) {
+	cfg.serveUserFeed(w, r, feedKindRSS)
+}
+
+func (cfg *apiConfig) handlerUserFeedAtom(w http.ResponseWriter, r *http.Request) {
+	cfg.serveUserFeed(w, r, feedKindAtom)
+}
+
+func (cfg *apiConfig) handlerUserPodcast(w http.ResponseWriter, r *http.Request) {
+	cfg.serveUserFeed(w, r, feedKindPodcast)
+}
+
+// Feeds are read without logging in, so they only list videos that have been uploaded
+func (cfg *apiConfig) serveUserFeed(w http.ResponseWriter, r *http.Request, kind string) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil || user.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	items, err := cfg.getFeedItems(r, userID, kind)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build feed", err)
+		return
+	}
+	// A podcast only exists once there's something to listen to
+	if kind == feedKindPodcast && len(items) == 0 {
+		respondWithError(w, http.StatusNotFound, "User has no audio renditions", nil)
+		return
+	}
+
+	resolvedUser := cfg.withAvatarURL(r.Context(), *user)
+	user = &resolvedUser
+	serverOrigin := cfg.getRequestOrigin(r)
+	selfURL := serverOrigin + r.URL.Path
+	var feed any
+	contentType := "application/rss+xml; charset=utf-8"
+	switch kind {
+	case feedKindAtom:
+		feed = cfg.buildAtomFeed(user, items, serverOrigin, selfURL)
+		contentType = "application/atom+xml; charset=utf-8"
+	default:
+		feed = cfg.buildRSSFeed(user, items, serverOrigin, selfURL, kind == feedKindPodcast)
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode feed", err)
+		return
+	}
+
+	// Presigned enclosures are regenerated for every request, so the feed mustn't be cached past them
+	if cfg.feeds.PresignedURLs {
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedCacheMaxAge.Seconds())))
+		w.Header().Set("Surrogate-Key", cdn.FeedKey(user.ID))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// Function to get the user's most recent published public videos with their
+// enclosures. Podcasts only list audio renditions. Videos the reader can't watch from where
+// they are are left out.
+func (cfg *apiConfig) getFeedItems(r *http.Request, userID uuid.UUID, kind string) ([]feedItem, error) {
+	videos, err := cfg.db.GetFilteredVideos(userID, database.VideoFilter{Visibility: database.VisibilityPublic})
+	if err != nil {
+		return nil, err
+	}
+
+	items := []feedItem{}
+	next := 0
+	for len(items) < cfg.feeds.MaxItems && next < len(videos) {
+		// Take the next videos the feed could list, enough to fill it if they can all be linked
+		batch := []feedItem{}
+		for ; next < len(videos) && len(batch) < cfg.feeds.MaxItems-len(items); next++ {
+			video := videos[next]
+			// Archived videos can't be played until they're restored
+			if video.State != database.VideoStateReady {
+				continue
+			}
+			if kind == feedKindPodcast && processing.FormatForKey(*video.VideoURL) != processing.FormatM4A {
+				continue
+			}
+			allowed, err := cfg.viewerAllowed(r, video.ID)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				continue
+			}
+			batch = append(batch, feedItem{Video: video})
+		}
+
+		// Presigning dominates long feeds, so the batch is linked concurrently
+		errs := make([]error, len(batch))
+		cfg.forEachConcurrently(len(batch), func(i int) {
+			video := batch[i].Video
+			batch[i].Enclosure, errs[i] = cfg.getFeedEnclosure(r.Context(), video, processing.FormatForKey(*video.VideoURL))
+			batch[i].Video.ThumbnailURL = cfg.resolveAssetURL(r.Context(), video.ThumbnailURL)
+		})
+		for i, item := range batch {
+			switch {
+			case errors.Is(errs[i], errVideoArchived):
+				// Left out until it's restored
+			case errs[i] != nil:
+				// One video that can't be linked doesn't take the rest of the feed down
+				log.Printf("Couldn't link video %s in the feed of user %s: %v", item.Video.ID, userID, errs[i])
+			default:
+				items = append(items, item)
+			}
+		}
+	}
+	return items, nil
+}
+
+// Function to get the enclosure of a published video, presigning it if configured
+func (cfg *apiConfig) getFeedEnclosure(ctx context.Context, video database.Video, format string) (*rssEnclosure, error) {
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		return nil, err
+	}
+	// Archived videos can't be played from any URL until they're restored
+	if err := cfg.checkObjectPlayable(bucket, key); err != nil {
+		return nil, err
+	}
+	length, err := cfg.db.GetStoredObjectBytes(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	enclosure := &rssEnclosure{
+		URL:    *video.VideoURL,
+		Length: length,
+		Type:   processing.Profile{Format: format}.ContentType(),
+	}
+
+	// HLS playlists point at their segments by relative path, which a presigned URL can't cover.
+	// Feeds that aren't presigned link to videos like every other response does.
+	switch {
+	case !cfg.feeds.PresignedURLs && format != processing.FormatHLS:
+		enclosure.URL, err = cfg.urls.Videos.fileURL(ctx, storedFile{
+			URL:    *video.VideoURL,
+			Locate: func() (string, string, error) { return bucket, key, nil },
+		}, cfg.urls.VideoExpiry)
+	case !cfg.feeds.PresignedURLs:
+	case format != processing.FormatHLS:
+		enclosure.URL, err = cfg.generatePresignedURL(ctx, bucket, key, cfg.feeds.URLExpiry)
+	case cfg.hls.Enabled:
+		enclosure.URL, err = cfg.getHLSProxyURL(video.ID, cfg.feeds.URLExpiry)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return enclosure, nil
+}
+
+func (cfg *apiConfig) buildRSSFeed(user *database.User, items []feedItem, serverOrigin, selfURL string, podcast bool) rssFeed {
+	channel := rssChannel{
+		Title:         "Tubely videos",
+		Link:          serverOrigin + "/app/",
+		Description:   fmt.Sprintf("Videos uploaded to Tubely by user %s", user.ID),
+		Self:          atomLink{Rel: "self", Href: selfURL, Type: "application/rss+xml"},
+		LastBuildDate: time.Now().UTC().Format(time.RFC1123Z),
+		Items:         []rssItem{},
+	}
+	feed := rssFeed{Version: "2.0", AtomNS: "http://www.w3.org/2005/Atom"}
+	if podcast {
+		feed.ITunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+		channel.Title = "Tubely podcast"
+		channel.ITunesAuthor = "Tubely"
+		channel.ITunesExplicit = "false"
+		channel.ITunesCategory = &itunesCategory{Text: "TV & Film"}
+		if user.AvatarURL != nil {
+			channel.ITunesImage = &itunesImage{Href: *user.AvatarURL}
+		}
+	}
+
+	for _, item := range items {
+		video := item.Video
+		rssItem := rssItem{
+			Title:       video.Title,
+			Link:        fmt.Sprintf("%s/api/videos/%s", serverOrigin, video.ID),
+			Description: video.Description,
+			GUID:        rssGUID{Value: video.ID.String()},
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure:   item.Enclosure,
+		}
+		if podcast {
+			if video.Duration != nil {
+				rssItem.ITunesDuration = int(math.Round(*video.Duration))
+			}
+			if video.ThumbnailURL != nil {
+				rssItem.ITunesImage = &itunesImage{Href: *video.ThumbnailURL}
+			}
+		}
+		channel.Items = append(channel.Items, rssItem)
+	}
+
+	feed.Channel = channel
+	return feed
+}
+
+func (cfg *apiConfig) buildAtomFeed(user *database.User, items []feedItem, serverOrigin, selfURL string) atomFeed {
+	feed := atomFeed{
+		ID:      "urn:uuid:" + user.ID.String(),
+		Title:   "Tubely videos",
+		Updated: user.CreatedAt.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "Tubely"},
+		Links: []atomLink{
+			{Rel: "self", Href: selfURL, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: serverOrigin + "/app/"},
+		},
+		Entries: []atomEntry{},
+	}
+
+	for _, item := range items {
+		video := item.Video
+		if updated := video.UpdatedAt.UTC().Format(time.RFC3339); updated > feed.Updated {
+			feed.Updated = updated
+		}
+		entry := atomEntry{
+			ID:        "urn:uuid:" + video.ID.String(),
+			Title:     video.Title,
+			Updated:   video.UpdatedAt.UTC().Format(time.RFC3339),
+			Published: video.CreatedAt.UTC().Format(time.RFC3339),
+			Summary:   video.Description,
+			Links: []atomLink{
+				{Rel: "alternate", Href: fmt.Sprintf("%s/api/videos/%s", serverOrigin, video.ID)},
+			},
+		}
+		if item.Enclosure != nil {
+			entry.Links = append(entry.Links, atomLink{
+				Rel:    "enclosure",
+				Href:   item.Enclosure.URL,
+				Type:   item.Enclosure.Type,
+				Length: item.Enclosure.Length,
+			})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+)
+
+type notificationSettingsResponse struct {
+	database.NotificationSettings
+	// EmailAvailable is false when the server has no mail server to send through
+	EmailAvailable bool `json:"email_available"`
+}
+
+func (cfg *apiConfig) handlerNotificationSettingsGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	settings, err := cfg.db.GetNotificationSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification settings", err)
+		return
+	}
+	settings.UserID = userID
+
+	respondWithJSON(w, http.StatusOK, notificationSettingsResponse{
+		NotificationSettings: settings,
+		EmailAvailable:       cfg.notifier.EmailEnabled(),
+	})
+}
+
+func (cfg *apiConfig) handlerNotificationSettingsSet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := database.SetNotificationSettingsParams{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	details := []fieldError{}
+	if params.Email && !cfg.notifier.EmailEnabled() {
+		details = append(details, fieldError{Field: "email", Message: "email notifications aren't available on this server"})
+	}
+	if params.SlackWebhookURL != "" {
+		if err := notify.CheckSlackURL(params.SlackWebhookURL); err != nil {
+			details = append(details, fieldError{Field: "slack_webhook_url", Message: "must be a Slack incoming webhook URL: " + err.Error()})
+		}
+	}
+	if params.DiscordWebhookURL != "" {
+		if err := notify.CheckDiscordURL(params.DiscordWebhookURL); err != nil {
+			details = append(details, fieldError{Field: "discord_webhook_url", Message: "must be a Discord webhook URL: " + err.Error()})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid notification settings", nil, details)
+		return
+	}
+
+	settings, err := cfg.db.SetNotificationSettings(userID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save notification settings", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, notificationSettingsResponse{
+		NotificationSettings: settings,
+		EmailAvailable:       cfg.notifier.EmailEnabled(),
+	})
+}
+
+// Sends a test message over every channel the user set up, reporting how each delivery went
+func (cfg *apiConfig) handlerNotificationTest(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Deliveries []notify.Delivery `json:"deliveries"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	settings, err := cfg.db.GetNotificationSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification settings", err)
+		return
+	}
+
+	deliveries := cfg.notifier.Send(r.Context(), settings, notify.Message{
+		Subject: "Test notification",
+		Text:    "Notifications are set up. You'll hear here when your videos finish processing.",
+	})
+	if len(deliveries) == 0 {
+		respondWithError(w, http.StatusConflict, "No notification channels are set up", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Deliveries: deliveries})
+}
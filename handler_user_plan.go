@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// planResponse is what the caller's plan entitles them to, with the server's
+// defaults filled in, and how much of it they're using
+type planResponse struct {
+	// Plan is empty for users on the default plan
+	Plan         string `json:"plan"`
+	UploadLimit  int64  `json:"upload_limit"`
+	StorageQuota *int64 `json:"storage_quota"`
+	StoredBytes  int64  `json:"stored_bytes"`
+	// MaxVideoDuration and MaxLinkExpiry are in seconds, null for no limit
+	MaxVideoDuration *int     `json:"max_video_duration"`
+	MaxLinkExpiry    *int     `json:"max_link_expiry"`
+	Profiles         []string `json:"profiles"`
+}
+
+func (cfg *apiConfig) handlerUserPlan(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	plan, err := cfg.userPlan(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	stored, err := cfg.db.GetUserStoredBytes(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+
+	resp := planResponse{
+		Plan:          plan.Name,
+		UploadLimit:   cfg.planUploadLimit(plan),
+		StorageQuota:  plan.StorageQuota,
+		StoredBytes:   stored,
+		MaxLinkExpiry: plan.MaxLinkExpiry,
+		Profiles:      []string{},
+	}
+	if maxDuration := cfg.planMaxVideoDuration(plan); maxDuration > 0 {
+		seconds := int(maxDuration.Seconds())
+		resp.MaxVideoDuration = &seconds
+	}
+	// Only the ffmpeg transcoder runs profiles, so there are none to pick otherwise
+	if cfg.transcoderRunsProfiles() {
+		for _, name := range cfg.profiles.Names() {
+			if plan.AllowsProfile(name) {
+				resp.Profiles = append(resp.Profiles, name)
+			}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Maximum number of countries or networks a video's rules can list
+const maxAccessRuleEntries = 250
+
+func (cfg *apiConfig) handlerVideoAccessGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := cfg.db.GetAccessRules(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get access rules", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, rules)
+}
+
+func (cfg *apiConfig) handlerVideoAccessUpdate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := database.AccessRules{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Rules are stored normalized: upper-case country codes and masked networks
+	details := []fieldError{}
+	rules := database.AccessRules{AllowedCountries: []string{}, AllowedCIDRs: []string{}}
+	if len(params.AllowedCountries) > maxAccessRuleEntries || len(params.AllowedCIDRs) > maxAccessRuleEntries {
+		details = append(details, fieldError{
+			Field:   "allowed_countries",
+			Message: fmt.Sprintf("each list can have at most %d entries", maxAccessRuleEntries),
+		})
+	}
+	for i, entry := range params.AllowedCountries {
+		country := strings.ToUpper(strings.TrimSpace(entry))
+		if !isCountryCode(country) {
+			details = append(details, fieldError{
+				Field:   fmt.Sprintf("allowed_countries[%d]", i),
+				Message: "must be a two-letter ISO 3166-1 country code",
+			})
+			continue
+		}
+		if !slices.Contains(rules.AllowedCountries, country) {
+			rules.AllowedCountries = append(rules.AllowedCountries, country)
+		}
+	}
+	for i, entry := range params.AllowedCIDRs {
+		prefix, err := parseNetwork(strings.TrimSpace(entry))
+		if err != nil {
+			details = append(details, fieldError{
+				Field:   fmt.Sprintf("allowed_cidrs[%d]", i),
+				Message: "must be an IP address or CIDR, such as 203.0.113.0/24",
+			})
+			continue
+		}
+		if !slices.Contains(rules.AllowedCIDRs, prefix.String()) {
+			rules.AllowedCIDRs = append(rules.AllowedCIDRs, prefix.String())
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid access rules", nil, details)
+		return
+	}
+	if len(rules.AllowedCountries) > 0 && cfg.geoIP == nil {
+		respondWithError(w, http.StatusConflict, "Country rules need a GeoIP provider, set GEOIP_PROVIDER", nil)
+		return
+	}
+
+	if err := cfg.db.SetAccessRules(video.ID, rules); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update access rules", err)
+		return
+	}
+	cfg.purgeCachedVideo(video)
+
+	respondWithJSON(w, http.StatusOK, rules)
+}
+
+// Function to check a viewer may watch a video before handing out a URL for it,
+// responding with 451 when they can't
+func (cfg *apiConfig) checkViewerAccess(w http.ResponseWriter, r *http.Request, videoID uuid.UUID) bool {
+	allowed, err := cfg.viewerAllowed(r, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access rules", err)
+		return false
+	}
+	if !allowed {
+		respondWithErrorCode(w, errCodeGeoRestricted, "Video isn't available in your location", nil, nil)
+		return false
+	}
+	return true
+}
+
+// Function to report whether the request's client may watch a video. Viewers whose
+// country can't be determined are only allowed in by their address.
+func (cfg *apiConfig) viewerAllowed(r *http.Request, videoID uuid.UUID) (bool, error) {
+	rules, err := cfg.db.GetAccessRules(videoID)
+	if err != nil {
+		return false, err
+	}
+	if !rules.Restricted() {
+		return true, nil
+	}
+
+	addr, ok := cfg.proxies.clientAddr(r)
+	if !ok {
+		return false, nil
+	}
+	for _, cidr := range rules.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err == nil && prefix.Contains(addr) {
+			return true, nil
+		}
+	}
+
+	if len(rules.AllowedCountries) == 0 || cfg.geoIP == nil {
+		return false, nil
+	}
+	country, err := cfg.geoIP.Country(r, addr)
+	if err != nil {
+		log.Printf("Couldn't look up the country of %s: %v", addr, err)
+		return false, nil
+	}
+	return slices.Contains(rules.AllowedCountries, country), nil
+}
+
+// Function to parse a CIDR, or a single address as a network of one
+func parseNetwork(value string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// Function to report whether value looks like an ISO 3166-1 alpha-2 code
+func isCountryCode(value string) bool {
+	if len(value) != 2 {
+		return false
+	}
+	for _, c := range value {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
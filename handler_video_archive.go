@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+const (
+	// How many days a restored copy is kept when the restore doesn't say
+	defaultRestoreDays = 7
+	// Longest a restored copy can be kept, since it's billed alongside the archive
+	maxRestoreDays = 30
+)
+
+// errVideoArchived is returned instead of a URL for an object in cold storage
+var errVideoArchived = newRequestError(errCodeArchived, "Video is archived, restore it to play it", nil)
+
+func (cfg *apiConfig) handlerVideoArchive(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		StorageClass types.StorageClass `json:"storage_class"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	if params.StorageClass == "" {
+		params.StorageClass = types.StorageClassGlacier
+	}
+	if !slices.Contains(processing.ArchiveStorageClasses, params.StorageClass) {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid archive", nil, []fieldError{{
+			Field:   "storage_class",
+			Message: fmt.Sprintf("must be one of %v", processing.ArchiveStorageClasses),
+		}})
+		return
+	}
+
+	if !video.State.CanTransition(database.VideoStateArchived) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Video is %s, only ready videos can be archived", video.State), nil)
+		return
+	}
+	// Playlists point at many segment objects, which would each need restoring
+	if processing.FormatForKey(*video.VideoURL) == processing.FormatHLS {
+		respondWithError(w, http.StatusConflict, "HLS videos can't be archived", nil)
+		return
+	}
+	archive, err := cfg.getVideoArchive(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get archive", err)
+		return
+	}
+	if archive != nil {
+		respondWithError(w, http.StatusConflict, "Video is already archived", nil)
+		return
+	}
+
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video", err)
+		return
+	}
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get S3 client", err)
+		return
+	}
+	if err := processing.ArchiveObject(r.Context(), client, bucket, key, params.StorageClass); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't archive video", err)
+		return
+	}
+
+	archived, err := cfg.db.ArchiveVideo(video.ID, bucket, key, string(params.StorageClass))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record archive", err)
+		return
+	}
+	if err := cfg.db.TransitionVideo(video.ID, database.VideoStateArchived); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark video archived", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, archived)
+}
+
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tier types.Tier `json:"tier"`
+		Days int        `json:"days"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	if params.Tier == "" {
+		params.Tier = types.TierStandard
+	}
+	if params.Days == 0 {
+		params.Days = defaultRestoreDays
+	}
+
+	archive, err := cfg.getVideoArchive(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get archive", err)
+		return
+	}
+	if archive == nil {
+		respondWithError(w, http.StatusConflict, "Video isn't archived", nil)
+		return
+	}
+
+	details := []fieldError{}
+	if !slices.Contains(processing.RestoreTiers, params.Tier) {
+		details = append(details, fieldError{Field: "tier", Message: fmt.Sprintf("must be one of %v", processing.RestoreTiers)})
+	} else if params.Tier == types.TierExpedited && archive.StorageClass == string(types.StorageClassDeepArchive) {
+		details = append(details, fieldError{Field: "tier", Message: "Deep Archive can't be restored with the Expedited tier"})
+	}
+	if params.Days < 1 || params.Days > maxRestoreDays {
+		details = append(details, fieldError{Field: "days", Message: fmt.Sprintf("must be between 1 and %d", maxRestoreDays)})
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid restore", nil, details)
+		return
+	}
+
+	switch {
+	case archive.State == database.ArchiveStateRestoring:
+		respondWithJSON(w, http.StatusAccepted, archive)
+		return
+	case archive.Playable(time.Now()):
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Video is already restored until %s", archive.RestoredUntil.Format(time.RFC3339)), nil)
+		return
+	}
+
+	client, err := cfg.getS3Client(archive.Bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get S3 client", err)
+		return
+	}
+	if err := processing.RestoreObject(r.Context(), client, archive.Bucket, archive.ObjectKey, params.Tier, params.Days); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+
+	if err := cfg.db.StartVideoRestore(video.ID, string(params.Tier)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record restore", err)
+		return
+	}
+	archive, err = cfg.db.GetVideoArchive(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get archive", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, archive)
+}
+
+// handlerVideoArchiveGet reports where a video is in the archive workflow,
+// checking with S3 first if a restore is running
+func (cfg *apiConfig) handlerVideoArchiveGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	archive, err := cfg.getVideoArchive(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get archive", err)
+		return
+	}
+	if archive == nil {
+		respondWithError(w, http.StatusNotFound, "Video isn't archived", nil)
+		return
+	}
+
+	switch {
+	case archive.State == database.ArchiveStateRestoring:
+		if err := cfg.checkVideoRestore(r.Context(), *archive); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check restore", err)
+			return
+		}
+		if archive, err = cfg.db.GetVideoArchive(video.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get archive", err)
+			return
+		}
+	case archive.State == database.ArchiveStateRestored && !archive.Playable(time.Now()):
+		// The poller moves it back to archived on its next pass
+		archive.State = database.ArchiveStateArchived
+	}
+	respondWithJSON(w, http.StatusOK, archive)
+}
+
+// Function to get the archive record of a video's current file, nil if it isn't
+// archived or was archived before the video was uploaded again
+func (cfg *apiConfig) getVideoArchive(video database.Video) (*database.VideoArchive, error) {
+	archive, err := cfg.db.GetVideoArchive(video.ID)
+	if err != nil || archive == nil {
+		return nil, err
+	}
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Bucket != bucket || archive.ObjectKey != key {
+		return nil, nil
+	}
+	return archive, nil
+}
+
+// Function to refuse URLs for objects in cold storage, unless a restored copy can be read
+func (cfg apiConfig) checkObjectPlayable(bucket, key string) error {
+	archive, err := cfg.db.GetObjectArchive(bucket, key)
+	if err != nil {
+		return err
+	}
+	if archive != nil && !archive.Playable(time.Now()) {
+		return errVideoArchived
+	}
+	return nil
+}
+
+// Function to check whether a running restore has finished, recording it and telling
+// the video's owner if it has
+func (cfg *apiConfig) checkVideoRestore(ctx context.Context, archive database.VideoArchive) error {
+	client, err := cfg.getS3Client(archive.Bucket)
+	if err != nil {
+		return err
+	}
+	restoredUntil, err := processing.RestoredUntil(ctx, client, archive.Bucket, archive.ObjectKey)
+	if err != nil || restoredUntil.IsZero() {
+		return err
+	}
+
+	finished, err := cfg.db.FinishVideoRestore(archive.VideoID, restoredUntil)
+	if err != nil || !finished {
+		return err
+	}
+	video, err := cfg.db.GetVideo(archive.VideoID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Video %s was restored until %s", video.ID, restoredUntil.Format(time.RFC3339))
+	go cfg.notifier.VideoRestored(context.Background(), video, restoredUntil)
+	return nil
+}
+
+// Function to poll S3 for finished restores every interval, and put videos whose
+// restored copies have expired back in the archive
+func (cfg *apiConfig) pollVideoRestores(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cfg.db.ExpireVideoRestores(time.Now().UTC()); err != nil {
+			log.Printf("Couldn't expire restored videos: %v", err)
+		}
+		archives, err := cfg.db.GetRestoringVideoArchives()
+		if err != nil {
+			log.Printf("Couldn't list restoring videos: %v", err)
+		}
+		for _, archive := range archives {
+			if err := cfg.checkVideoRestore(ctx, archive); err != nil {
+				log.Printf("Couldn't check restore of video %s: %v", archive.VideoID, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
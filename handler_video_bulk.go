@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Most videos one bulk operation can change
+const maxBulkVideos = 1000
+
+// Function to start an operation on many of the user's videos at once. It
+// runs in the background, and its report is at the Location in the response.
+// Deletes can be dry runs, which respond with the plan straight away.
+func (cfg *apiConfig) handlerVideosBulk(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Operation  database.BulkOperationKind `json:"operation"`
+		VideoIDs   []string                   `json:"video_ids"`
+		Visibility *database.Visibility       `json:"visibility"`
+		Tag        *string                    `json:"tag"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	details := []fieldError{}
+	switch params.Operation {
+	case database.BulkDelete:
+	case database.BulkSetVisibility:
+		if params.Visibility == nil {
+			details = append(details, fieldError{Field: "visibility", Message: "is required to set visibility"})
+		} else {
+			details = append(details, validateVisibility(*params.Visibility)...)
+		}
+	case database.BulkAddTag:
+		if params.Tag == nil {
+			details = append(details, fieldError{Field: "tag", Message: "is required to add a tag"})
+		} else if tag, err := normalizeTag(*params.Tag); err != nil {
+			details = append(details, fieldError{Field: "tag", Message: err.Error()})
+		} else {
+			params.Tag = &tag
+		}
+	default:
+		details = append(details, fieldError{Field: "operation", Message: "must be delete, set_visibility or add_tag"})
+	}
+	// The same video sent twice is only changed once
+	videoIDs := []uuid.UUID{}
+	for i, value := range params.VideoIDs {
+		videoID, err := uuid.Parse(value)
+		if err != nil {
+			details = append(details, fieldError{Field: fmt.Sprintf("video_ids[%d]", i), Message: "must be a video ID"})
+			continue
+		}
+		if !slices.Contains(videoIDs, videoID) {
+			videoIDs = append(videoIDs, videoID)
+		}
+	}
+	if len(params.VideoIDs) == 0 {
+		details = append(details, fieldError{Field: "video_ids", Message: "must not be empty"})
+	} else if len(videoIDs) > maxBulkVideos {
+		details = append(details, fieldError{Field: "video_ids", Message: fmt.Sprintf("must be at most %d videos", maxBulkVideos)})
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid bulk operation", nil, details)
+		return
+	}
+
+	if params.Operation == database.BulkDelete {
+		plan, ok := cfg.newRemovalPlan(w, r)
+		if !ok {
+			return
+		}
+		if plan.DryRun {
+			if err := cfg.planBulkDelete(userID, videoIDs, plan); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't plan bulk delete", err)
+				return
+			}
+			respondWithJSON(w, http.StatusOK, plan)
+			return
+		}
+	}
+
+	operation, err := cfg.db.CreateBulkOperation(database.CreateBulkOperationParams{
+		UserID:     userID,
+		Operation:  params.Operation,
+		Visibility: params.Visibility,
+		Tag:        params.Tag,
+		VideoIDs:   videoIDs,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create bulk operation", err)
+		return
+	}
+
+	go cfg.runBulkOperation(operation)
+
+	w.Header().Set("Location", apiPathFor(r, "/api/bulk/"+operation.ID.String()))
+	respondWithJSON(w, http.StatusAccepted, operation)
+}
+
+func (cfg *apiConfig) handlerBulkOperationGet(w http.ResponseWriter, r *http.Request) {
+	operationID, err := uuid.Parse(r.PathValue("operationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid bulk operation ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	operation, err := cfg.db.GetBulkOperation(operationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get bulk operation", err)
+		return
+	}
+	if operation.ID == uuid.Nil || operation.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Bulk operation not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, operation)
+}
+
+// Function to add to plan what deleting the user's videos would remove.
+// Videos that aren't the user's are left out, as the real delete fails them.
+func (cfg *apiConfig) planBulkDelete(userID uuid.UUID, videoIDs []uuid.UUID, plan *removalPlan) error {
+	for _, videoID := range videoIDs {
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			return err
+		}
+		if video.ID == uuid.Nil || video.UserID != userID {
+			continue
+		}
+		if err := cfg.deleteVideoRecords(video.ID, plan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to apply a bulk operation to each of its videos in turn,
+// recording each result as it goes so the report shows progress
+func (cfg *apiConfig) runBulkOperation(operation database.BulkOperation) {
+	for _, result := range operation.Results {
+		status, err := cfg.applyBulkOperation(operation, result.VideoID)
+		var message *string
+		if err != nil {
+			status = database.BulkResultFailed
+			text := err.Error()
+			message = &text
+		}
+		if err := cfg.db.SetBulkResult(operation.ID, result.VideoID, status, message); err != nil {
+			log.Printf("Bulk operation %s failed: %v", operation.ID, err)
+			text := "couldn't record result"
+			if err := cfg.db.CompleteBulkOperation(operation.ID, &text); err != nil {
+				log.Printf("Couldn't record failure of bulk operation %s: %v", operation.ID, err)
+			}
+			return
+		}
+	}
+
+	if err := cfg.db.CompleteBulkOperation(operation.ID, nil); err != nil {
+		log.Printf("Couldn't complete bulk operation %s: %v", operation.ID, err)
+	}
+}
+
+// Function to apply a bulk operation to one video. Errors are reported to
+// the client in the operation's results, so they're worded for it.
+func (cfg *apiConfig) applyBulkOperation(operation database.BulkOperation, videoID uuid.UUID) (database.BulkResultStatus, error) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Bulk operation %s couldn't get video %s: %v", operation.ID, videoID, err)
+		return "", fmt.Errorf("couldn't get video")
+	}
+	// Other users' videos look the same as ones that don't exist
+	if video.ID == uuid.Nil || video.UserID != operation.UserID {
+		return "", fmt.Errorf("video not found")
+	}
+
+	switch operation.Operation {
+	case database.BulkDelete:
+		if err := cfg.deleteVideoRecords(video.ID, nil); err != nil {
+			log.Printf("Bulk operation %s couldn't delete video %s: %v", operation.ID, videoID, err)
+			return "", fmt.Errorf("couldn't delete video")
+		}
+	case database.BulkSetVisibility:
+		if video.Visibility == *operation.Visibility {
+			return database.BulkResultSkipped, nil
+		}
+		if err := cfg.db.SetVideoVisibility(video.ID, *operation.Visibility); err != nil {
+			log.Printf("Bulk operation %s couldn't update video %s: %v", operation.ID, videoID, err)
+			return "", fmt.Errorf("couldn't update video")
+		}
+	case database.BulkAddTag:
+		if slices.Contains(video.Tags, *operation.Tag) {
+			return database.BulkResultSkipped, nil
+		}
+		added, err := cfg.db.AddVideoTag(video.ID, *operation.Tag, maxVideoTags)
+		if err != nil {
+			log.Printf("Bulk operation %s couldn't tag video %s: %v", operation.ID, videoID, err)
+			return "", fmt.Errorf("couldn't update video")
+		}
+		if !added {
+			// The video may have been tagged since it was read
+			video, err := cfg.db.GetVideo(video.ID)
+			if err == nil && slices.Contains(video.Tags, *operation.Tag) {
+				return database.BulkResultSkipped, nil
+			}
+			return "", fmt.Errorf("video already has %d tags", maxVideoTags)
+		}
+	}
+
+	cfg.purgeCachedVideo(video)
+	return database.BulkResultDone, nil
+}
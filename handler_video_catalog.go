@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Formats the catalog can be exported in
+const (
+	catalogFormatCSV   = "csv"
+	catalogFormatJSONL = "jsonl"
+)
+
+// How many rows are written between flushes, so long catalogs reach the
+// client as they're read rather than all at the end
+const catalogFlushRows = 100
+
+// catalogEntry is one video of a catalog export
+type catalogEntry struct {
+	ID                uuid.UUID           `json:"id"`
+	CreatedAt         time.Time           `json:"created_at"`
+	UpdatedAt         time.Time           `json:"updated_at"`
+	Title             string              `json:"title"`
+	Description       string              `json:"description"`
+	Visibility        database.Visibility `json:"visibility"`
+	Tags              []string            `json:"tags"`
+	ExternalID        *string             `json:"external_id"`
+	State             database.VideoState `json:"state"`
+	Duration          *float64            `json:"duration"`
+	ProcessingProfile *string             `json:"processing_profile"`
+	// StoredBytes is the size of everything stored for the video: its file,
+	// renditions, thumbnails and clips
+	StoredBytes      int64             `json:"stored_bytes"`
+	UploadSize       *int64            `json:"upload_size"`
+	OriginalFilename *string           `json:"original_filename"`
+	UploadedAt       *time.Time        `json:"uploaded_at"`
+	Metadata         map[string]string `json:"metadata"`
+	// URLs are only included when asked for
+	PageURL      *string `json:"page_url,omitempty"`
+	VideoURL     *string `json:"video_url,omitempty"`
+	ThumbnailURL *string `json:"thumbnail_url,omitempty"`
+}
+
+// catalogColumn is a column of the CSV catalog
+type catalogColumn struct {
+	name  string
+	value func(entry catalogEntry) string
+}
+
+var catalogColumns = []catalogColumn{
+	{"id", func(e catalogEntry) string { return e.ID.String() }},
+	{"created_at", func(e catalogEntry) string { return e.CreatedAt.UTC().Format(time.RFC3339) }},
+	{"updated_at", func(e catalogEntry) string { return e.UpdatedAt.UTC().Format(time.RFC3339) }},
+	{"title", func(e catalogEntry) string { return csvText(e.Title) }},
+	{"description", func(e catalogEntry) string { return csvText(e.Description) }},
+	{"visibility", func(e catalogEntry) string { return string(e.Visibility) }},
+	{"tags", func(e catalogEntry) string { return csvJSON(e.Tags) }},
+	{"external_id", func(e catalogEntry) string { return csvText(derefString(e.ExternalID)) }},
+	{"state", func(e catalogEntry) string { return string(e.State) }},
+	{"duration", func(e catalogEntry) string {
+		if e.Duration == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*e.Duration, 'f', -1, 64)
+	}},
+	{"processing_profile", func(e catalogEntry) string { return derefString(e.ProcessingProfile) }},
+	{"stored_bytes", func(e catalogEntry) string { return strconv.FormatInt(e.StoredBytes, 10) }},
+	{"upload_size", func(e catalogEntry) string {
+		if e.UploadSize == nil {
+			return ""
+		}
+		return strconv.FormatInt(*e.UploadSize, 10)
+	}},
+	{"original_filename", func(e catalogEntry) string { return csvText(derefString(e.OriginalFilename)) }},
+	{"uploaded_at", func(e catalogEntry) string {
+		if e.UploadedAt == nil {
+			return ""
+		}
+		return e.UploadedAt.UTC().Format(time.RFC3339)
+	}},
+	{"metadata", func(e catalogEntry) string { return csvJSON(e.Metadata) }},
+}
+
+var catalogURLColumns = []catalogColumn{
+	{"page_url", func(e catalogEntry) string { return derefString(e.PageURL) }},
+	{"video_url", func(e catalogEntry) string { return derefString(e.VideoURL) }},
+	{"thumbnail_url", func(e catalogEntry) string { return derefString(e.ThumbnailURL) }},
+}
+
+// Function to stream the user's whole catalog, or the part matching the
+// same filters as the list of videos, as CSV or JSON Lines for reporting
+func (cfg *apiConfig) handlerVideosExport(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	query := r.URL.Query()
+	details := []fieldError{}
+	format := query.Get("format")
+	if format == "" {
+		format = catalogFormatCSV
+	}
+	if format != catalogFormatCSV && format != catalogFormatJSONL {
+		details = append(details, fieldError{Field: "format", Message: "must be csv or jsonl"})
+	}
+	includeURLs := false
+	if value := query.Get("include_urls"); value != "" {
+		includeURLs, err = strconv.ParseBool(value)
+		if err != nil {
+			details = append(details, fieldError{Field: "include_urls", Message: "must be true or false"})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid query", nil, details)
+		return
+	}
+	filter, err := parseVideoFilter(r)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	// What's looked up per video is read up front, so the videos themselves
+	// can be streamed
+	storedBytes, err := cfg.db.GetUserVideoStoredBytes(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+
+	newEntry := func(video database.Video) catalogEntry {
+		entry := catalogEntry{
+			ID:                video.ID,
+			CreatedAt:         video.CreatedAt,
+			UpdatedAt:         video.UpdatedAt,
+			Title:             video.Title,
+			Description:       video.Description,
+			Visibility:        video.Visibility,
+			Tags:              video.Tags,
+			ExternalID:        video.ExternalID,
+			State:             video.State,
+			Duration:          video.Duration,
+			ProcessingProfile: video.ProcessingProfile,
+			StoredBytes:       storedBytes[video.ID],
+			UploadSize:        video.Upload.Size,
+			OriginalFilename:  video.Upload.OriginalFilename,
+			UploadedAt:        video.Upload.UploadedAt,
+			Metadata:          video.Metadata,
+		}
+		if includeURLs {
+			pageURL := videoPageURL(cfg.getRequestOrigin(r), video.ID)
+			entry.PageURL = &pageURL
+			resolved := cfg.withURLs(r.Context(), video)
+			entry.VideoURL = resolved.VideoURL
+			entry.ThumbnailURL = resolved.ThumbnailURL
+		}
+		return entry
+	}
+
+	columns := catalogColumns
+	if includeURLs {
+		columns = append(columns[:len(columns):len(columns)], catalogURLColumns...)
+	}
+	var writeHeader, flush func() error
+	var writeEntry func(entry catalogEntry) error
+	contentType := "text/csv; charset=utf-8"
+	if format == catalogFormatJSONL {
+		contentType = "application/x-ndjson"
+		encoder := json.NewEncoder(w)
+		writeHeader = func() error { return nil }
+		writeEntry = func(entry catalogEntry) error { return encoder.Encode(entry) }
+		flush = func() error { return nil }
+	} else {
+		out := csv.NewWriter(w)
+		writeHeader = func() error {
+			header := make([]string, len(columns))
+			for i, column := range columns {
+				header[i] = column.name
+			}
+			return out.Write(header)
+		}
+		writeEntry = func(entry catalogEntry) error {
+			row := make([]string, len(columns))
+			for i, column := range columns {
+				row[i] = column.value(entry)
+			}
+			return out.Write(row)
+		}
+		flush = func() error {
+			out.Flush()
+			return out.Error()
+		}
+	}
+
+	filename := fmt.Sprintf("videos-%s.%s", time.Now().UTC().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// The status has been sent, so failures from here on cut the export
+	// short and are only logged
+	rows := 0
+	controller := http.NewResponseController(w)
+	err = writeHeader()
+	if err == nil {
+		err = cfg.db.EachFilteredVideo(userID, filter, func(video database.Video) error {
+			if err := writeEntry(newEntry(video)); err != nil {
+				return err
+			}
+			rows++
+			if rows%catalogFlushRows == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+				controller.Flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Printf("Couldn't write catalog export: %v", err)
+	}
+}
+
+// Function to keep text a user wrote from being run as a formula when the
+// CSV is opened in a spreadsheet
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// Function to put a list or object in one CSV cell as JSON
+func csvJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Maximum length of a chapter title
+const maxChapterTitleLength = 200
+
+func (cfg *apiConfig) handlerChaptersCreate(w http.ResponseWriter, r *http.Request) {
+	type chapterParams struct {
+		StartSeconds float64 `json:"start_seconds"`
+		Title        string  `json:"title"`
+	}
+	type parameters struct {
+		Chapters []chapterParams `json:"chapters"`
+		Embed    bool            `json:"embed"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Chapters) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one chapter is required", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		return
+	}
+
+	// Chapters can only be validated once the video's duration is known
+	if video.Duration == nil {
+		respondWithError(w, http.StatusConflict, "Video must be uploaded before adding chapters", nil)
+		return
+	}
+	if params.Embed && processing.FormatForKey(*video.VideoURL) == processing.FormatHLS {
+		respondWithError(w, http.StatusConflict, "Chapters can't be embedded in HLS videos", nil)
+		return
+	}
+
+	// Validate every marker against the stored duration and the existing markers
+	existing, err := cfg.db.GetChapters(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+	starts := make(map[float64]bool, len(existing))
+	for _, chapter := range existing {
+		starts[chapter.StartSeconds] = true
+	}
+	details := []fieldError{}
+	for i, chapter := range params.Chapters {
+		if chapter.StartSeconds < 0 || chapter.StartSeconds >= *video.Duration {
+			details = append(details, fieldError{
+				Field:   fmt.Sprintf("chapters[%d].start_seconds", i),
+				Message: fmt.Sprintf("must be between 0 and %.3f seconds", *video.Duration),
+			})
+		} else if starts[chapter.StartSeconds] {
+			details = append(details, fieldError{
+				Field:   fmt.Sprintf("chapters[%d].start_seconds", i),
+				Message: fmt.Sprintf("a chapter already starts at %.3f seconds", chapter.StartSeconds),
+			})
+		}
+		title := strings.TrimSpace(chapter.Title)
+		if title == "" || len(title) > maxChapterTitleLength {
+			details = append(details, fieldError{
+				Field:   fmt.Sprintf("chapters[%d].title", i),
+				Message: fmt.Sprintf("must be between 1 and %d characters", maxChapterTitleLength),
+			})
+		}
+		starts[chapter.StartSeconds] = true
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid chapters", nil, details)
+		return
+	}
+
+	// Save the new markers
+	for _, chapter := range params.Chapters {
+		_, err := cfg.db.CreateChapter(database.CreateChapterParams{
+			VideoID:      videoID,
+			StartSeconds: chapter.StartSeconds,
+			Title:        strings.TrimSpace(chapter.Title),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create chapter", err)
+			return
+		}
+	}
+
+	// Optionally reprocess the stored file so players see the markers
+	if params.Embed {
+		if err := cfg.reprocessStoredVideo(r.Context(), video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't embed chapters in video", err)
+			return
+		}
+	}
+
+	chapters, err := cfg.db.GetChapters(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, chapters)
+}
+
+func (cfg *apiConfig) handlerChaptersList(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	chapters, err := cfg.db.GetChapters(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+
+	respondWithList(w, http.StatusOK, chapters, listPage{Total: len(chapters)})
+}
+
+func (cfg *apiConfig) handlerChapterDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	chapterID, err := uuid.Parse(r.PathValue("chapterID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chapter ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	chapter, err := cfg.db.GetChapter(chapterID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapter", err)
+		return
+	}
+	if chapter.VideoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Chapter not found", nil)
+		return
+	}
+
+	err = cfg.db.DeleteChapter(chapterID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chapter", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to write a video's chapters to an ffmetadata file, returning "" if there are none
+func (cfg *apiConfig) writeVideoChapterMetadata(videoID uuid.UUID, duration float64) (string, error) {
+	chapters, err := cfg.db.GetChapters(videoID)
+	if err != nil {
+		return "", err
+	}
+	return processing.WriteChapterMetadata(chapters, duration)
+}
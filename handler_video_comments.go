@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Maximum length of a comment, in characters
+const maxCommentLength = 2000
+
+// Page sizes for listing comments
+const (
+	defaultCommentPageSize = 20
+	maxCommentPageSize     = 100
+)
+
+func (cfg *apiConfig) handlerCommentsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body     string        `json:"body"`
+		ParentID uuid.NullUUID `json:"parent_id"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if _, ok := cfg.getViewableVideo(w, videoID, userID); !ok {
+		return
+	}
+
+	details := []fieldError{}
+	body := strings.TrimSpace(params.Body)
+	if msg := validateCommentBody(body); msg != "" {
+		details = append(details, fieldError{Field: "body", Message: msg})
+	}
+
+	// Replies must stay within the video they reply to
+	if params.ParentID.Valid {
+		parent, err := cfg.db.GetComment(params.ParentID.UUID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+			return
+		}
+		if parent.ID == uuid.Nil || parent.VideoID != videoID {
+			details = append(details, fieldError{Field: "parent_id", Message: "must be a comment on this video"})
+		} else if parent.DeletedAt != nil {
+			details = append(details, fieldError{Field: "parent_id", Message: "can't reply to a deleted comment"})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid comment", nil, details)
+		return
+	}
+
+	comment, err := cfg.db.CreateComment(database.CreateCommentParams{
+		VideoID:  videoID,
+		UserID:   userID,
+		ParentID: params.ParentID,
+		Body:     body,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, comment)
+}
+
+func (cfg *apiConfig) handlerCommentsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	// Top-level comments are listed unless parent_id asks for a comment's replies
+	query := r.URL.Query()
+	details := []fieldError{}
+	var parentID, cursor uuid.NullUUID
+	if value := query.Get("parent_id"); value != "" {
+		if err := parentID.Scan(value); err != nil {
+			details = append(details, fieldError{Field: "parent_id", Message: "must be a comment ID"})
+		}
+	}
+	if value := query.Get("cursor"); value != "" {
+		if err := cursor.Scan(value); err != nil {
+			details = append(details, fieldError{Field: "cursor", Message: "must be a next_cursor from an earlier page"})
+		}
+	}
+	limit := defaultCommentPageSize
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxCommentPageSize {
+			details = append(details, fieldError{
+				Field:   "limit",
+				Message: fmt.Sprintf("must be between 1 and %d", maxCommentPageSize),
+			})
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid query", nil, details)
+		return
+	}
+
+	if _, ok := cfg.getViewableVideo(w, videoID, cfg.optionalUserID(r)); !ok {
+		return
+	}
+
+	// Fetch one extra comment to know whether there's another page
+	comments, err := cfg.db.GetComments(videoID, parentID, cursor, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve comments", err)
+		return
+	}
+	total, err := cfg.db.CountComments(videoID, parentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count comments", err)
+		return
+	}
+	page := listPage{Total: total, v1Field: "comments"}
+	if len(comments) > limit {
+		comments = comments[:limit]
+		page.NextCursor = comments[limit-1].ID.String()
+	}
+
+	respondWithList(w, http.StatusOK, comments, page)
+}
+
+func (cfg *apiConfig) handlerCommentUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	comment, userID, ok := cfg.getComment(w, r)
+	if !ok {
+		return
+	}
+	if comment.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this comment", nil)
+		return
+	}
+	if comment.DeletedAt != nil {
+		respondWithError(w, http.StatusConflict, "Comment has been deleted", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	body := strings.TrimSpace(params.Body)
+	if msg := validateCommentBody(body); msg != "" {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid comment", nil, []fieldError{{Field: "body", Message: msg}})
+		return
+	}
+
+	if err := cfg.db.UpdateComment(comment.ID, body); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update comment", err)
+		return
+	}
+	comment, err = cfg.db.GetComment(comment.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, comment)
+}
+
+// Comments can be deleted by their author, or moderated by the video's owner
+func (cfg *apiConfig) handlerCommentDelete(w http.ResponseWriter, r *http.Request) {
+	comment, userID, ok := cfg.getComment(w, r)
+	if !ok {
+		return
+	}
+	if comment.UserID != userID {
+		video, err := cfg.db.GetVideo(comment.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You can't delete this comment", nil)
+			return
+		}
+	}
+
+	if err := cfg.db.DeleteComment(comment.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete comment", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to authenticate the request and look up the comment in its path
+func (cfg *apiConfig) getComment(w http.ResponseWriter, r *http.Request) (database.Comment, uuid.UUID, bool) {
+	commentID, err := uuid.Parse(r.PathValue("commentID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment ID", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+
+	comment, err := cfg.db.GetComment(commentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+	if comment.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Comment not found", nil)
+		return database.Comment{}, uuid.Nil, false
+	}
+
+	return comment, userID, true
+}
+
+// Function to check a trimmed comment body, returning why it's invalid
+func validateCommentBody(body string) string {
+	if body == "" || utf8.RuneCountInString(body) > maxCommentLength {
+		return fmt.Sprintf("must be between 1 and %d characters", maxCommentLength)
+	}
+	return ""
+}
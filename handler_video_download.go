@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// How long a download link works for, long enough to start a large download on a slow connection
+const downloadURLExpiry = 15 * time.Minute
+
+// Function to send a video's owner to a temporary link that downloads its
+// stored file, named after the file they uploaded
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video must be uploaded before it can be downloaded", nil)
+		return
+	}
+	if processing.FormatForKey(*video.VideoURL) == processing.FormatHLS {
+		respondWithError(w, http.StatusConflict, "HLS videos can't be downloaded as one file", nil)
+		return
+	}
+
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video", err)
+		return
+	}
+	downloadURL, err := cfg.generateDownloadURL(r.Context(), bucket, key, downloadFilename(video, key), downloadURLExpiry)
+	if err != nil {
+		if errors.Is(err, errVideoArchived) {
+			respondWithRequestError(w, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, downloadURL, http.StatusFound)
+}
+
+// Function to name a video's stored file after the file it was uploaded as,
+// or after the video when that isn't known. The extension is the stored
+// file's, since processing may have changed its format.
+func downloadFilename(video database.Video, key string) string {
+	stem := video.ID.String()
+	if video.Upload.OriginalFilename != nil {
+		name := *video.Upload.OriginalFilename
+		if name = strings.TrimSuffix(name, path.Ext(name)); name != "" {
+			stem = name
+		}
+	}
+	return stem + path.Ext(key)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+)
+
+// Default maximum Hamming distance for two hashes to count as near-duplicates
+const defaultDuplicateThreshold = 10
+
+type duplicatePair struct {
+	Kind     string           `json:"kind"`
+	Distance int              `json:"distance"`
+	Videos   []database.Video `json:"videos"`
+}
+
+func (cfg *apiConfig) handlerVideoDuplicates(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Allow the caller to loosen or tighten the match threshold
+	threshold := defaultDuplicateThreshold
+	if thresholdString := r.URL.Query().Get("threshold"); thresholdString != "" {
+		threshold, err = strconv.Atoi(thresholdString)
+		if err != nil || threshold < 0 || threshold > 64 {
+			respondWithError(w, http.StatusBadRequest, "Invalid threshold, must be between 0 and 64", err)
+			return
+		}
+	}
+
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	groups := findDuplicateVideos(videos, threshold)
+	for i := range groups {
+		groups[i].Videos = cfg.withURLsAll(r.Context(), groups[i].Videos)
+	}
+	respondWithList(w, http.StatusOK, groups, listPage{Total: len(groups)})
+}
+
+// Function to pair up videos whose thumbnail hashes or video fingerprints are within threshold
+func findDuplicateVideos(videos []database.Video, threshold int) []duplicatePair {
+
+	// Decode the stored hashes once up front
+	thumbnailHashes := make(map[int]uint64)
+	fingerprints := make(map[int][]uint64)
+	for i, video := range videos {
+		if video.ThumbnailPHash != nil {
+			if hash, err := imaging.ParseHash(*video.ThumbnailPHash); err == nil {
+				thumbnailHashes[i] = hash
+			}
+		}
+		if video.VideoFingerprint != nil {
+			if fingerprint, err := imaging.ParseFingerprint(*video.VideoFingerprint); err == nil && len(fingerprint) > 0 {
+				fingerprints[i] = fingerprint
+			}
+		}
+	}
+
+	// Compare every pair, preferring the video fingerprint since it's the stronger signal
+	pairs := []duplicatePair{}
+	for i := 0; i < len(videos); i++ {
+		for j := i + 1; j < len(videos); j++ {
+			if a, ok := fingerprints[i]; ok {
+				if b, ok := fingerprints[j]; ok {
+					distance, err := imaging.FingerprintDistance(a, b)
+					if err == nil && distance <= threshold {
+						pairs = append(pairs, duplicatePair{
+							Kind:     "video",
+							Distance: distance,
+							Videos:   []database.Video{videos[i], videos[j]},
+						})
+						continue
+					}
+				}
+			}
+
+			a, okA := thumbnailHashes[i]
+			b, okB := thumbnailHashes[j]
+			if !okA || !okB {
+				continue
+			}
+			if distance := imaging.HammingDistance(a, b); distance <= threshold {
+				pairs = append(pairs, duplicatePair{
+					Kind:     "thumbnail",
+					Distance: distance,
+					Videos:   []database.Video{videos[i], videos[j]},
+				})
+			}
+		}
+	}
+
+	// Closest matches first
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Distance < pairs[j].Distance
+	})
+
+	return pairs
+}
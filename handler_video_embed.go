@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// embedConfig decides how long an embedded player can play for.
+type embedConfig struct {
+	// TokenExpiry is how long a player page can keep fetching playback URLs
+	TokenExpiry time.Duration
+	// URLExpiry is how long each playback URL stays valid
+	URLExpiry time.Duration
+}
+
+// Maximum number of sites a video can be embedded on
+const maxEmbedOrigins = 20
+
+type embedResponse struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	// EmbedURL and HTML are null while the video can't be embedded
+	EmbedURL *string `json:"embed_url"`
+	HTML     *string `json:"html"`
+}
+
+// embedPage is what the player page template renders
+type embedPage struct {
+	Title        string
+	ThumbnailURL string
+	PlaybackURL  string
+	TokenURL     string
+	Token        string
+	// TokenExpiresIn is how many seconds the token lasts
+	TokenExpiresIn int
+}
+
+var embedPageTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="robots" content="noindex">
+  <title>{{.Title}} - Tubely</title>
+  <style>html, body { margin: 0; height: 100%; background: #000; } video { width: 100%; height: 100%; }</style>
+</head>
+<body>
+  <video id="player"{{if .ThumbnailURL}} poster="{{.ThumbnailURL}}"{{end}} controls playsinline></video>
+  <script>
+    const player = document.getElementById("player");
+    const playbackURL = {{.PlaybackURL}};
+    const tokenURL = {{.TokenURL}};
+    let token = {{.Token}};
+    let reloaded = false;
+    let urlTimer;
+
+    // Refresh things just before they expire: with a tenth of their time, and at least 30 seconds, to spare
+    function refreshDelay(expiresIn) {
+      return Math.max(expiresIn - Math.max(expiresIn / 10, 30), 1) * 1000;
+    }
+
+    // Swap the token for a new one before it expires, so playback URLs can
+    // still be fetched however long the page stays open
+    async function refreshToken() {
+      const res = await fetch(tokenURL, { method: "POST", headers: { Authorization: "Bearer " + token } });
+      if (!res.ok) {
+        return;
+      }
+      const data = await res.json();
+      token = data.token;
+      setTimeout(refreshToken, refreshDelay(data.expires_in));
+    }
+
+    // Playback URLs expire, so fetch a fresh one just before then and carry
+    // on from the same spot, and again if one stops working anyway
+    async function load(resume, play) {
+      const res = await fetch(playbackURL, { headers: { Authorization: "Bearer " + token } });
+      if (!res.ok) {
+        return;
+      }
+      const data = await res.json();
+      const time = player.currentTime;
+      player.src = data.url;
+      if (resume) {
+        player.currentTime = time;
+        if (play) {
+          player.play();
+        }
+      }
+      clearTimeout(urlTimer);
+      urlTimer = setTimeout(() => load(true, !player.paused), refreshDelay(data.expires_in));
+    }
+    player.addEventListener("error", () => {
+      if (!reloaded) {
+        reloaded = true;
+        load(true, true);
+      }
+    });
+    player.addEventListener("playing", () => { reloaded = false; });
+    load(false, false);
+    setTimeout(refreshToken, refreshDelay({{.TokenExpiresIn}}));
+  </script>
+</body>
+</html>
+`))
+
+func (cfg *apiConfig) handlerVideoEmbedGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	origins, err := cfg.db.GetEmbedOrigins(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get embed settings", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.newEmbedResponse(video, origins, cfg.getRequestOrigin(r)))
+}
+
+func (cfg *apiConfig) handlerVideoEmbedUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		AllowedOrigins []string `json:"allowed_origins"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Origins are stored normalized, so they can be matched against Referer headers
+	details := []fieldError{}
+	if len(params.AllowedOrigins) > maxEmbedOrigins {
+		details = append(details, fieldError{
+			Field:   "allowed_origins",
+			Message: fmt.Sprintf("can list at most %d sites", maxEmbedOrigins),
+		})
+	}
+	origins := []string{}
+	seen := make(map[string]bool)
+	for i, entry := range params.AllowedOrigins {
+		origin, ok := originOf(strings.TrimSpace(entry))
+		if !ok || !(strings.HasPrefix(origin, "https://") || strings.HasPrefix(origin, "http://")) {
+			details = append(details, fieldError{
+				Field:   fmt.Sprintf("allowed_origins[%d]", i),
+				Message: "must be an http or https origin, such as https://blog.example.com",
+			})
+			continue
+		}
+		if !seen[origin] {
+			seen[origin] = true
+			origins = append(origins, origin)
+		}
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid embed settings", nil, details)
+		return
+	}
+
+	if err := cfg.db.SetEmbedOrigins(video.ID, origins); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update embed settings", err)
+		return
+	}
+	cfg.purgeCachedVideo(video)
+
+	respondWithJSON(w, http.StatusOK, cfg.newEmbedResponse(video, origins, cfg.getRequestOrigin(r)))
+}
+
+// Player pages are public, but browsers only show them framed on the video's allowed sites
+func (cfg *apiConfig) handlerEmbedPlayer(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, ok := cfg.getPublicVideo(w, videoID)
+	if !ok {
+		return
+	}
+	origins, err := cfg.db.GetEmbedOrigins(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get embed settings", err)
+		return
+	}
+	if len(origins) == 0 {
+		respondWithError(w, http.StatusNotFound, "Video can't be embedded", nil)
+		return
+	}
+	if !cfg.checkViewerAccess(w, r, video.ID) {
+		return
+	}
+
+	// Browsers send the embedding page's origin as the Referer, reject any other site early
+	if referer := r.Header.Get("Referer"); referer != "" {
+		origin, ok := originOf(referer)
+		if !ok || (origin != cfg.getRequestOrigin(r) && !slices.Contains(origins, origin)) {
+			respondWithError(w, http.StatusForbidden, "Embedding from this site isn't allowed", nil)
+			return
+		}
+	}
+
+	token, err := auth.MakeEmbedToken(video.ID, cfg.jwtSecret, cfg.embeds.TokenExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create embed token", err)
+		return
+	}
+	page := embedPage{
+		Title:          video.Title,
+		PlaybackURL:    fmt.Sprintf("/api/v1/embed/%s/playback", video.ID),
+		TokenURL:       fmt.Sprintf("/api/v1/embed/%s/token", video.ID),
+		Token:          token,
+		TokenExpiresIn: int(cfg.embeds.TokenExpiry.Seconds()),
+	}
+	if thumbnailURL := cfg.resolveAssetURL(r.Context(), video.ThumbnailURL); thumbnailURL != nil {
+		page.ThumbnailURL = *thumbnailURL
+	}
+
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'self' "+strings.Join(origins, " "))
+	w.Header().Set("Cache-Control", "no-store")
+	cfg.setViewerCacheHeaders(w, video, cfg.embedPageMaxAge())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	embedPageTemplate.Execute(w, page)
+}
+
+// embedPlaybackResponse is a playback URL for an embedded player. The
+// ExpiresIn fields say how many seconds are left, so players can refresh
+// just in time without trusting their own clock.
+type embedPlaybackResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int       `json:"expires_in"`
+}
+
+// embedTokenResponse is a new embed token for a player that's still open.
+type embedTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int       `json:"expires_in"`
+}
+
+func (cfg *apiConfig) handlerEmbedPlayback(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeEmbed(w, r)
+	if !ok {
+		return
+	}
+
+	url, expiresAt, err := cfg.signVideoURL(r.Context(), video, cfg.embeds.URLExpiry)
+	if err != nil {
+		if errors.Is(err, errVideoArchived) {
+			respondWithRequestError(w, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, embedPlaybackResponse{
+		URL:       url,
+		ExpiresAt: expiresAt,
+		ExpiresIn: secondsUntil(expiresAt),
+	})
+}
+
+// handlerEmbedTokenRefresh swaps a player's embed token for a new one before
+// it expires, so a player left open can keep fetching playback URLs for as
+// long as the video may still be embedded. It doesn't sign anything, so
+// players can call it as often as they need to.
+func (cfg *apiConfig) handlerEmbedTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeEmbed(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := auth.MakeEmbedToken(video.ID, cfg.jwtSecret, cfg.embeds.TokenExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create embed token", err)
+		return
+	}
+	expiresAt := time.Now().UTC().Add(cfg.embeds.TokenExpiry)
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, embedTokenResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		ExpiresIn: secondsUntil(expiresAt),
+	})
+}
+
+// Function to check a request carries an embed token for a video that can
+// still be embedded and watched by the viewer, writing the error if not. The
+// embed token stands in for a login, and only works for the video it was
+// issued for.
+func (cfg *apiConfig) authorizeEmbed(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find embed token", err)
+		return database.Video{}, false
+	}
+	tokenVideoID, err := auth.ValidateEmbedToken(token, cfg.jwtSecret)
+	if err != nil || tokenVideoID != videoID {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate embed token", err)
+		return database.Video{}, false
+	}
+
+	video, ok := cfg.getPublicVideo(w, videoID)
+	if !ok {
+		return database.Video{}, false
+	}
+	// Tokens already handed out stop working once embedding is turned off
+	origins, err := cfg.db.GetEmbedOrigins(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get embed settings", err)
+		return database.Video{}, false
+	}
+	if len(origins) == 0 {
+		respondWithError(w, http.StatusForbidden, "Video can't be embedded", nil)
+		return database.Video{}, false
+	}
+	if !cfg.checkViewerAccess(w, r, video.ID) {
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// Function to count the whole seconds left until t, never less than zero
+func secondsUntil(t time.Time) int {
+	return max(int(time.Until(t).Seconds()), 0)
+}
+
+// Function to build the embed settings of a video, with the code to paste when it's embeddable
+func (cfg *apiConfig) newEmbedResponse(video database.Video, origins []string, serverOrigin string) embedResponse {
+	resp := embedResponse{AllowedOrigins: origins}
+	if len(origins) == 0 {
+		return resp
+	}
+
+	embedURL := fmt.Sprintf("%s/embed/%s", serverOrigin, video.ID)
+	width, height := cfg.embedSize(video, 0, 0)
+	html := fmt.Sprintf(
+		`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="fullscreen; picture-in-picture" allowfullscreen></iframe>`,
+		embedURL, width, height,
+	)
+	resp.EmbedURL = &embedURL
+	resp.HTML = &html
+	return resp
+}
+
+// Function to get how long a CDN may keep a player page. Everyone it's sent
+// to shares the embed token in it, so it's kept for at most half the time the
+// page leaves itself to swap the token for a new one.
+func (cfg *apiConfig) embedPageMaxAge() time.Duration {
+	margin := max(cfg.embeds.TokenExpiry/10, 30*time.Second)
+	return min(cfg.cdnMaxAge, margin/2)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Longest external ID a video can have, in bytes
+const maxExternalIDLength = 255
+
+// Function to find one of the user's videos by the ID another system gave it.
+// The ID is a query parameter rather than part of the path, where it would
+// clash with the routes under /api/videos/{videoID}/.
+func (cfg *apiConfig) handlerVideoByExternalID(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	externalID := r.URL.Query().Get("external_id")
+	if details := validateExternalID(externalID); details != nil {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid external ID", nil, details)
+		return
+	}
+
+	video, err := cfg.db.GetVideoByExternalID(userID, externalID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video has this external ID", nil)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, newOwnedVideo(cfg.withURLs(r.Context(), video)))
+}
+
+// Function to check an external ID a client sent, returning what's wrong
+// with it or nil
+func validateExternalID(externalID string) []fieldError {
+	message := ""
+	switch {
+	case externalID == "":
+		message = "must not be empty"
+	case len(externalID) > maxExternalIDLength:
+		message = fmt.Sprintf("must be at most %d bytes", maxExternalIDLength)
+	case strings.IndexFunc(externalID, unicode.IsControl) >= 0:
+		message = "must not contain control characters"
+	default:
+		return nil
+	}
+	return []fieldError{{Field: "external_id", Message: message}}
+}
+
+// Function to give a video the external ID sent with its upload, if one was,
+// responding with an error and false if it's invalid or taken
+func (cfg *apiConfig) applyUploadExternalID(w http.ResponseWriter, video database.Video, externalID string) bool {
+	if externalID == "" {
+		return true
+	}
+	if details := validateExternalID(externalID); details != nil {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid external ID", nil, details)
+		return false
+	}
+	if !externalIDChanged(video, &externalID) {
+		return true
+	}
+	if err := cfg.setVideoExternalID(video, &externalID); err != nil {
+		respondWithRequestError(w, err)
+		return false
+	}
+	return true
+}
+
+// Function to report whether setting a video's external ID would change it
+func externalIDChanged(video database.Video, externalID *string) bool {
+	if video.ExternalID == nil || externalID == nil {
+		return video.ExternalID != externalID
+	}
+	return *video.ExternalID != *externalID
+}
+
+// Function to give a video an external ID, or with nil remove it. A clash
+// with another of the owner's videos is a conflict naming that video.
+func (cfg *apiConfig) setVideoExternalID(video database.Video, externalID *string) error {
+	err := cfg.db.SetVideoExternalID(video.ID, externalID)
+	if errors.Is(err, database.ErrExternalIDTaken) {
+		return cfg.externalIDConflict(video.UserID, *externalID)
+	}
+	return err
+}
+
+// Function to report that one of a user's videos already has an external ID,
+// as a conflict naming the video so a syncing client can update it instead
+func (cfg *apiConfig) externalIDConflict(userID uuid.UUID, externalID string) error {
+	other, err := cfg.db.GetVideoByExternalID(userID, externalID)
+	if err != nil {
+		return err
+	}
+	return newRequestError(errCodeConflict, fmt.Sprintf("External ID is already used by video %s", other.ID), nil)
+}
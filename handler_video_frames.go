@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Limits and expiry for candidate thumbnail frames
+const (
+	defaultFrameCount = 5
+	maxFrameCount     = 10
+	frameURLExpiry    = time.Hour
+	framesPrefix      = "frames"
+)
+
+type videoFrame struct {
+	Key           string  `json:"key"`
+	OffsetSeconds float64 `json:"offset_seconds"`
+	URL           string  `json:"url"`
+}
+
+func (cfg *apiConfig) handlerVideoFrames(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || video.Duration == nil {
+		respondWithError(w, http.StatusConflict, "Video must be uploaded before extracting frames", nil)
+		return
+	}
+	if processing.FormatForKey(*video.VideoURL) != processing.FormatMP4 {
+		respondWithError(w, http.StatusConflict, "Frames can only be extracted from MP4 videos", nil)
+		return
+	}
+
+	count := defaultFrameCount
+	if countString := r.URL.Query().Get("count"); countString != "" {
+		var err error
+		count, err = strconv.Atoi(countString)
+		if err != nil || count < 1 || count > maxFrameCount {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid count, must be between 1 and %d", maxFrameCount), err)
+			return
+		}
+	}
+
+	// Let ffmpeg seek within the stored object instead of downloading all of it
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video", err)
+		return
+	}
+	sourceURL, err := cfg.generatePresignedURL(r.Context(), bucket, key, frameURLExpiry)
+	if err != nil {
+		if errors.Is(err, errVideoArchived) {
+			respondWithRequestError(w, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	// Frames go next to the video, in its owner's or tenant's storage
+	storage, err := cfg.getVideoStorage(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+	client, err := cfg.getS3Client(storage.Bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+
+	// Take frames from the middle of evenly sized slices, avoiding the very
+	// first and last frames which are often black
+	frames := make([]videoFrame, 0, count)
+	for i := 0; i < count; i++ {
+		offset := *video.Duration * (float64(i) + 0.5) / float64(count)
+		frame, err := processing.ExtractFrame(sourceURL, offset)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+			return
+		}
+
+		frameKey, err := cfg.newObjectKey(r.Context(), storage.Bucket, storage.Key(path.Join(framesPrefix, video.ID.String())), "image/jpeg")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store frame", err)
+			return
+		}
+		_, err = client.PutObject(r.Context(), &s3.PutObjectInput{
+			Bucket:      aws.String(storage.Bucket),
+			Key:         aws.String(frameKey),
+			Body:        bytes.NewReader(frame),
+			ContentType: aws.String("image/jpeg"),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store frame", err)
+			return
+		}
+
+		frameURL, err := cfg.generatePresignedURL(r.Context(), storage.Bucket, frameKey, frameURLExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign frame URL", err)
+			return
+		}
+		frames = append(frames, videoFrame{
+			Key:           frameKey,
+			OffsetSeconds: offset,
+			URL:           frameURL,
+		})
+	}
+
+	respondWithList(w, http.StatusOK, frames, listPage{Total: len(frames)})
+}
+
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key string `json:"key"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	storage, err := cfg.getVideoStorage(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+	client, err := cfg.getS3Client(storage.Bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+
+	// Only frames extracted from this video can be selected
+	prefix := storage.Key(path.Join(framesPrefix, video.ID.String())) + "/"
+	if !strings.HasPrefix(params.Key, prefix) || path.Clean(params.Key) != params.Key {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid frame", nil, []fieldError{
+			{Field: "key", Message: "must be a frame returned for this video"},
+		})
+		return
+	}
+
+	object, err := client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(storage.Bucket),
+		Key:    aws.String(params.Key),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find frame", err)
+		return
+	}
+	defer object.Body.Close()
+
+	video, err = cfg.saveThumbnail(video, "image/jpeg", object.Body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+		return
+	}
+
+	// The candidates aren't needed once one has been chosen
+	if err := cfg.deleteObjectsWithPrefixIn(r.Context(), storage.Bucket, prefix, nil); err != nil {
+		log.Printf("Couldn't clean up frames for video %s: %v", video.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.withURLs(r.Context(), video))
+}
+
+// Function to authenticate the caller and load the video in the path, responding with an error if they don't own it
+func (cfg *apiConfig) getOwnedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return database.Video{}, false
+	}
+
+	return video, true
+}
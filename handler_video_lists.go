@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoLike(w http.ResponseWriter, r *http.Request) {
+	userID, videoID, ok := cfg.getListVideo(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.LikeVideo(userID, videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like video", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerVideoUnlike(w http.ResponseWriter, r *http.Request) {
+	userID, videoID, ok := cfg.getListVideo(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.UnlikeVideo(userID, videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike video", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerLikedVideos(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	fields, err := parseFieldSelection(r, videoFieldNames)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	videos, err := cfg.db.GetLikedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve liked videos", err)
+		return
+	}
+
+	videos = visibleVideos(videos, userID)
+	respondWithFieldsList(w, http.StatusOK, cfg.withSelectedURLs(r.Context(), videos, fields), listPage{Total: len(videos)}, fields)
+}
+
+func (cfg *apiConfig) handlerWatchLaterAdd(w http.ResponseWriter, r *http.Request) {
+	userID, videoID, ok := cfg.getListVideo(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.AddWatchLater(userID, videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to watch later", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerWatchLaterRemove(w http.ResponseWriter, r *http.Request) {
+	userID, videoID, ok := cfg.getListVideo(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.RemoveWatchLater(userID, videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video from watch later", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerWatchLaterList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	fields, err := parseFieldSelection(r, videoFieldNames)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	videos, err := cfg.db.GetWatchLater(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watch later", err)
+		return
+	}
+
+	videos = visibleVideos(videos, userID)
+	respondWithFieldsList(w, http.StatusOK, cfg.withSelectedURLs(r.Context(), videos, fields), listPage{Total: len(videos)}, fields)
+}
+
+// Function to authenticate the request and check the video in its path exists.
+// Any user can like or save any video they can see, which leaves out other
+// users' private videos.
+func (cfg *apiConfig) getListVideo(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	if _, ok := cfg.getViewableVideo(w, videoID, userID); !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, videoID, true
+}
+
+// Function to fill in a single video's like and watch-later counts
+func (cfg *apiConfig) loadVideoCounts(video database.Video) (database.Video, error) {
+	videos := []database.Video{video}
+	if err := cfg.db.LoadVideoCounts(videos); err != nil {
+		return database.Video{}, err
+	}
+	return videos[0], nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -12,6 +14,7 @@ import (
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
+		ExternalID *string `json:"external_id"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -24,6 +27,11 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
+	tenant, err := cfg.getUserTenant(userID)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -33,14 +41,36 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	if params.ExternalID != nil {
+		if details := validateExternalID(*params.ExternalID); details != nil {
+			respondWithErrorCode(w, errCodeValidationFailed, "Invalid video", nil, details)
+			return
+		}
+		params.CreateVideoParams.ExternalID = params.ExternalID
+	}
+
+	// Videos belong to the tenant the caller is in now
+	params.TenantID = tenant
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	if errors.Is(err, database.ErrExternalIDTaken) {
+		err = cfg.externalIDConflict(userID, *params.ExternalID)
+	}
+	if errors.Is(err, database.ErrTenantFull) {
+		err = cfg.tenantFullError(tenant.UUID)
+	}
 	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			respondWithRequestError(w, err)
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusCreated, newOwnedVideo(cfg.withURLs(r.Context(), video)))
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
@@ -71,16 +101,48 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
 		return
 	}
+	plan, ok := cfg.newRemovalPlan(w, r)
+	if !ok {
+		return
+	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.deleteVideoRecords(videoID, plan)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	if plan.DryRun {
+		respondWithJSON(w, http.StatusOK, plan)
+		return
+	}
+	cfg.purgeCachedVideo(video)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// ownedVideo is a video as its owner sees it, with its external ID, where its
+// file came from and its metadata
+type ownedVideo struct {
+	database.Video
+	ExternalID *string              `json:"external_id"`
+	Upload     database.VideoUpload `json:"upload"`
+	Metadata   map[string]string    `json:"metadata"`
+}
+
+// Function to show a video to its owner
+func newOwnedVideo(video database.Video) ownedVideo {
+	return ownedVideo{video, video.ExternalID, video.Upload, video.Metadata}
+}
+
+// Function to show videos to their owner
+func ownedVideos(videos []database.Video) []ownedVideo {
+	owned := make([]ownedVideo, len(videos))
+	for i, video := range videos {
+		owned[i] = newOwnedVideo(video)
+	}
+	return owned
+}
+
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -89,13 +151,172 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fields, err := parseFieldSelection(r, videoFieldNames)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	// Private videos are only shown to their owner, and never cached
+	private := video.Visibility == database.VisibilityPrivate
+	if private && !videoVisibleTo(video, cfg.optionalUserID(r)) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if !cfg.checkViewerAccess(w, r, video.ID) {
+		return
+	}
+	if fields.has("like_count") || fields.has("watch_later_count") {
+		video, err = cfg.loadVideoCounts(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count likes", err)
+			return
+		}
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	if private {
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else if fields.has("video_url") {
+		cfg.setViewerCacheHeaders(w, video, cfg.videoURLsMaxAge())
+	} else {
+		cfg.setViewerCacheHeaders(w, video, cfg.cdnMaxAge)
+	}
+	if fields.hasURLs() {
+		video = cfg.withURLs(r.Context(), video)
+	}
+	respondWithFields(w, http.StatusOK, video, fields)
+}
+
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		// ExternalID replaces the video's external ID, or with "" removes it
+		ExternalID *string              `json:"external_id"`
+		Visibility *database.Visibility `json:"visibility"`
+		// Tags replace all of the video's tags
+		Tags *[]string `json:"tags"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil && strings.TrimSpace(*params.Title) == "" {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid video", nil, []fieldError{
+			{Field: "title", Message: "must not be empty"},
+		})
+		return
+	}
+	if params.Visibility != nil {
+		if details := validateVisibility(*params.Visibility); details != nil {
+			respondWithErrorCode(w, errCodeValidationFailed, "Invalid video", nil, details)
+			return
+		}
+	}
+	var tags []string
+	if params.Tags != nil {
+		var details []fieldError
+		if tags, details = normalizeTags(*params.Tags); details != nil {
+			respondWithErrorCode(w, errCodeValidationFailed, "Invalid video", nil, details)
+			return
+		}
+	}
+	var externalID *string
+	if params.ExternalID != nil && *params.ExternalID != "" {
+		if details := validateExternalID(*params.ExternalID); details != nil {
+			respondWithErrorCode(w, errCodeValidationFailed, "Invalid video", nil, details)
+			return
+		}
+		externalID = params.ExternalID
+	}
+	changeExternalID := params.ExternalID != nil && externalIDChanged(video, externalID)
+
+	// With If-Match, the update only goes through if nobody else has changed
+	// the video since the client read it
+	etag := videoETag(video)
+	if !ifMatch(r, etag) {
+		respondWithErrorCode(w, errCodePrecondition, "Video has changed since it was read", nil, nil)
+		return
+	}
+	var revision *int
+	if r.Header.Get("If-Match") != "" {
+		revision = &video.Revision
+	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	details := database.VideoDetails{
+		Title:       video.Title,
+		Description: video.Description,
+		Visibility:  video.Visibility,
+		Tags:        video.Tags,
+	}
+	if params.Title != nil {
+		details.Title = *params.Title
+	}
+	if params.Description != nil {
+		details.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		details.Visibility = *params.Visibility
+	}
+	if params.Tags != nil {
+		details.Tags = tags
+	}
+	// Check the external ID is free first, so a clash doesn't leave the
+	// rest of the update applied
+	if changeExternalID && externalID != nil {
+		other, err := cfg.db.GetVideoByExternalID(video.UserID, *externalID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check external ID", err)
+			return
+		}
+		if other.ID != uuid.Nil {
+			respondWithRequestError(w, cfg.externalIDConflict(video.UserID, *externalID))
+			return
+		}
+	}
+	updated, err := cfg.db.UpdateVideoDetails(video.ID, details, revision)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if !updated {
+		respondWithErrorCode(w, errCodePrecondition, "Video has changed since it was read", nil, nil)
+		return
+	}
+	if changeExternalID {
+		if err := cfg.setVideoExternalID(video, externalID); err != nil {
+			respondWithRequestError(w, err)
+			return
+		}
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	cfg.purgeCachedVideo(video)
+	video, err = cfg.loadVideoCounts(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count likes", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, newOwnedVideo(cfg.withURLs(r.Context(), video)))
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -110,11 +331,30 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	fields, err := parseFieldSelection(r, ownedVideoFieldNames)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+	filter, err := parseVideoFilter(r)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return
+	}
+
+	videos, err := cfg.db.GetFilteredVideos(userID, filter)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	// Counting takes a query per list for every batch of videos, so it's skipped when
+	// the counts aren't wanted
+	if fields.has("like_count") || fields.has("watch_later_count") {
+		if err := cfg.db.LoadVideoCounts(videos); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count likes", err)
+			return
+		}
+	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	respondWithFieldsList(w, http.StatusOK, ownedVideos(cfg.withSelectedURLs(r.Context(), videos, fields)), listPage{Total: len(videos)}, fields)
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Limits on a video's metadata. The size is of its JSON encoding, so it
+// bounds the keys, the values and how many there are together.
+const (
+	maxMetadataSize       = 8 << 10
+	maxMetadataValueBytes = 1024
+	// How many times a change is retried when another change to the same
+	// video gets in first
+	maxMetadataAttempts = 3
+)
+
+// Metadata keys are short identifiers, without the ":" that separates a key
+// from its value in list filters
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func (cfg *apiConfig) handlerVideoMetadataGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video.Metadata)
+}
+
+// Function to replace all of a video's metadata
+func (cfg *apiConfig) handlerVideoMetadataReplace(w http.ResponseWriter, r *http.Request) {
+	params := map[string]string{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters, metadata must be an object of strings", err)
+		return
+	}
+	cfg.changeVideoMetadata(w, r, func(metadata map[string]string) {
+		clear(metadata)
+		maps.Copy(metadata, params)
+	})
+}
+
+func (cfg *apiConfig) handlerVideoMetadataSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Value *string `json:"value"`
+	}
+
+	key := r.PathValue("key")
+	if !metadataKeyPattern.MatchString(key) {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid metadata key", nil, []fieldError{
+			{Field: "key", Message: "must be 1 to 64 letters, digits, '_', '.' or '-'"},
+		})
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Value == nil {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid metadata", nil, []fieldError{
+			{Field: "value", Message: "is required"},
+		})
+		return
+	}
+	cfg.changeVideoMetadata(w, r, func(metadata map[string]string) {
+		metadata[key] = *params.Value
+	})
+}
+
+func (cfg *apiConfig) handlerVideoMetadataDelete(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	cfg.changeVideoMetadata(w, r, func(metadata map[string]string) {
+		delete(metadata, key)
+	})
+}
+
+// Function to apply change to the metadata of the video named in the path and
+// respond with the result. A change that loses a race with another is tried
+// again on the new metadata, unless the client sent If-Match, in which case
+// it fails like any other update of a video that has changed since.
+func (cfg *apiConfig) changeVideoMetadata(w http.ResponseWriter, r *http.Request, change func(metadata map[string]string)) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if !ifMatch(r, videoETag(video)) {
+		respondWithErrorCode(w, errCodePrecondition, "Video has changed since it was read", nil, nil)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		metadata := maps.Clone(video.Metadata)
+		change(metadata)
+		if details := validateVideoMetadata(metadata); len(details) > 0 {
+			respondWithErrorCode(w, errCodeValidationFailed, "Invalid metadata", nil, details)
+			return
+		}
+
+		updated, err := cfg.db.SetVideoMetadata(video.ID, metadata, video.Revision)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update metadata", err)
+			return
+		}
+		if updated {
+			break
+		}
+		if r.Header.Get("If-Match") != "" || attempt == maxMetadataAttempts {
+			respondWithErrorCode(w, errCodePrecondition, "Video has changed since it was read", nil, nil)
+			return
+		}
+		video, err = cfg.db.GetVideo(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	cfg.purgeCachedVideo(video)
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video.Metadata)
+}
+
+// Function to check metadata against the limits on keys, values and size
+func validateVideoMetadata(metadata map[string]string) []fieldError {
+	details := []fieldError{}
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			details = append(details, fieldError{Field: "metadata." + key, Message: "key must be 1 to 64 letters, digits, '_', '.' or '-'"})
+			continue
+		}
+		if len(value) > maxMetadataValueBytes {
+			details = append(details, fieldError{Field: "metadata." + key, Message: fmt.Sprintf("must be at most %d bytes", maxMetadataValueBytes)})
+		}
+	}
+	if len(details) > 0 {
+		return details
+	}
+	if data, _ := json.Marshal(metadata); len(data) > maxMetadataSize {
+		details = append(details, fieldError{Field: "metadata", Message: fmt.Sprintf("must be at most %d bytes as JSON", maxMetadataSize)})
+	}
+	return details
+}
+
+// Function to parse the ?metadata= filters of a list of videos. Each is a
+// key the videos must have, or key:value for the value it must have.
+func parseMetadataFilters(r *http.Request) ([]database.MetadataFilter, error) {
+	filters := []database.MetadataFilter{}
+	for _, param := range r.URL.Query()["metadata"] {
+		key, value, hasValue := strings.Cut(param, ":")
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, newRequestError(errCodeValidationFailed, fmt.Sprintf("Invalid metadata filter %q, must be key or key:value", param), nil)
+		}
+		filter := database.MetadataFilter{Key: key}
+		if hasValue {
+			filter.Value = &value
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Size a player is embedded at when the caller doesn't ask for a smaller one
+const (
+	embedLongSide  = 640
+	embedShortSide = 360
+)
+
+// videoPage is what the watch page template renders
+type videoPage struct {
+	Title        string
+	Description  string
+	PageURL      string
+	OEmbedURL    string
+	VideoURL     string
+	VideoType    string
+	ThumbnailURL string
+	Width        int
+	Height       int
+}
+
+var videoPageTemplate = template.Must(template.New("video").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}} - Tubely</title>
+  <meta name="description" content="{{.Description}}">
+  <link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+  <meta property="og:site_name" content="Tubely">
+  <meta property="og:type" content="video.other">
+  <meta property="og:title" content="{{.Title}}">
+  <meta property="og:description" content="{{.Description}}">
+  <meta property="og:url" content="{{.PageURL}}">
+  {{- if .ThumbnailURL}}
+  <meta property="og:image" content="{{.ThumbnailURL}}">
+  {{- end}}
+  <meta property="og:video" content="{{.VideoURL}}">
+  <meta property="og:video:secure_url" content="{{.VideoURL}}">
+  <meta property="og:video:type" content="{{.VideoType}}">
+  <meta property="og:video:width" content="{{.Width}}">
+  <meta property="og:video:height" content="{{.Height}}">
+  <meta name="twitter:card" content="player">
+  <meta name="twitter:title" content="{{.Title}}">
+  <meta name="twitter:description" content="{{.Description}}">
+  {{- if .ThumbnailURL}}
+  <meta name="twitter:image" content="{{.ThumbnailURL}}">
+  {{- end}}
+  <meta name="twitter:player" content="{{.PageURL}}">
+  <meta name="twitter:player:width" content="{{.Width}}">
+  <meta name="twitter:player:height" content="{{.Height}}">
+  <meta name="twitter:player:stream" content="{{.VideoURL}}">
+  <meta name="twitter:player:stream:content_type" content="{{.VideoType}}">
+  <style>html, body { margin: 0; height: 100%; background: #000; } video { width: 100%; height: 100%; }</style>
+</head>
+<body>
+  <video src="{{.VideoURL}}"{{if .ThumbnailURL}} poster="{{.ThumbnailURL}}"{{end}} controls playsinline></video>
+</body>
+</html>
+`))
+
+// Watch pages are public so link previews can be built from them
+func (cfg *apiConfig) handlerVideoPage(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, ok := cfg.getPublicVideo(w, videoID)
+	if !ok {
+		return
+	}
+	if !cfg.checkViewerAccess(w, r, video.ID) {
+		return
+	}
+
+	serverOrigin := cfg.getRequestOrigin(r)
+	width, height := cfg.embedSize(video, 0, 0)
+	page := videoPage{
+		Title:       video.Title,
+		Description: video.Description,
+		PageURL:     videoPageURL(serverOrigin, video.ID),
+		OEmbedURL: fmt.Sprintf("%s/oembed?%s", serverOrigin, url.Values{
+			"url":    {videoPageURL(serverOrigin, video.ID)},
+			"format": {"json"},
+		}.Encode()),
+		VideoType: processing.Profile{Format: processing.FormatForKey(*video.VideoURL)}.ContentType(),
+		Width:     width,
+		Height:    height,
+	}
+	resolved := cfg.withURLs(r.Context(), video)
+	if resolved.VideoURL != nil {
+		page.VideoURL = *resolved.VideoURL
+	}
+	if resolved.ThumbnailURL != nil {
+		page.ThumbnailURL = *resolved.ThumbnailURL
+	}
+
+	cfg.setViewerCacheHeaders(w, video, cfg.videoURLsMaxAge())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	videoPageTemplate.Execute(w, page)
+}
+
+// handlerOEmbed implements the oEmbed provider endpoint (https://oembed.com)
+// for watch page URLs.
+func (cfg *apiConfig) handlerOEmbed(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Version      string `json:"version"`
+		Type         string `json:"type"`
+		ProviderName string `json:"provider_name"`
+		ProviderURL  string `json:"provider_url"`
+		Title        string `json:"title"`
+		ThumbnailURL string `json:"thumbnail_url,omitempty"`
+		HTML         string `json:"html"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+	}
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		respondWithError(w, http.StatusNotImplemented, "Only the json format is supported", nil)
+		return
+	}
+	maxWidth, err1 := parseOptionalInt(query.Get("maxwidth"))
+	maxHeight, err2 := parseOptionalInt(query.Get("maxheight"))
+	if err1 != nil || err2 != nil {
+		respondWithError(w, http.StatusBadRequest, "maxwidth and maxheight must be positive integers", nil)
+		return
+	}
+
+	videoID, ok := videoIDFromPageURL(query.Get("url"))
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Not a Tubely video URL", nil)
+		return
+	}
+	video, ok := cfg.getPublicVideo(w, videoID)
+	if !ok {
+		return
+	}
+
+	serverOrigin := cfg.getRequestOrigin(r)
+	width, height := cfg.embedSize(video, maxWidth, maxHeight)
+	resp := response{
+		Version:      "1.0",
+		Type:         "video",
+		ProviderName: "Tubely",
+		ProviderURL:  serverOrigin + "/app/",
+		Title:        video.Title,
+		HTML: fmt.Sprintf(
+			`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="fullscreen; picture-in-picture" allowfullscreen></iframe>`,
+			template.HTMLEscapeString(videoPageURL(serverOrigin, video.ID)), width, height,
+		),
+		Width:  width,
+		Height: height,
+	}
+	if thumbnailURL := cfg.resolveAssetURL(r.Context(), video.ThumbnailURL); thumbnailURL != nil {
+		resp.ThumbnailURL = *thumbnailURL
+	}
+
+	cdn.SetHeaders(w.Header(), cfg.cdnMaxAge, cdn.VideoKey(video.ID))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Function to get a video that has finished processing, responding with 404 otherwise
+func (cfg *apiConfig) getPublishedVideo(w http.ResponseWriter, videoID uuid.UUID) (database.Video, bool) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// Function to get a published video that anyone with a link can watch,
+// responding with 404 for private videos too. Share links and clips are
+// grants from the owner, so they use getPublishedVideo and still play them.
+func (cfg *apiConfig) getPublicVideo(w http.ResponseWriter, videoID uuid.UUID) (database.Video, bool) {
+	video, ok := cfg.getPublishedVideo(w, videoID)
+	if !ok {
+		return database.Video{}, false
+	}
+	if video.Visibility == database.VisibilityPrivate {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// Function to get the URL of a video's watch page
+func (cfg *apiConfig) getVideoPageURL(videoID uuid.UUID) string {
+	return videoPageURL(cfg.getServerOrigin(), videoID)
+}
+
+// Function to get the URL of a video's watch page on the given origin
+func videoPageURL(serverOrigin string, videoID uuid.UUID) string {
+	return fmt.Sprintf("%s/v/%s", serverOrigin, videoID)
+}
+
+// Function to get the video ID from a watch page URL, reporting false for any other URL.
+// Only the path is checked, so the page can be reached under any host name.
+func videoIDFromPageURL(rawURL string) (uuid.UUID, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	dir, id := path.Split(u.Path)
+	if dir != "/v/" {
+		return uuid.Nil, false
+	}
+	videoID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return videoID, true
+}
+
+// Function to get the size a video is embedded at, from its orientation, scaled down to
+// fit the limits that are set
+func (cfg *apiConfig) embedSize(video database.Video, maxWidth, maxHeight int) (int, int) {
+	width, height := embedLongSide, embedShortSide
+	if cfg.isPortrait(video) {
+		width, height = embedShortSide, embedLongSide
+	}
+	if maxWidth > 0 && width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+	return width, height
+}
+
+// Function to report whether a video is portrait, from the probe of its upload, or for
+// videos uploaded before probes were cached, from the orientation its key was filed under
+func (cfg *apiConfig) isPortrait(video database.Video) bool {
+	if orientation, ok := processing.VideoOrientation(cfg.db, video); ok {
+		return orientation == "portrait"
+	}
+	return video.VideoURL != nil && strings.Contains(*video.VideoURL, "/portrait/")
+}
+
+// Function to parse an optional positive integer query parameter, 0 when it's missing
+func parseOptionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a positive integer", value)
+	}
+	return n, nil
+}
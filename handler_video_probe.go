@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// How long ffprobe has to read the stored video through its signed URL
+const probeURLExpiry = 15 * time.Minute
+
+func (cfg *apiConfig) handlerVideoProbe(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video must be uploaded before it can be probed", nil)
+		return
+	}
+	if processing.FormatForKey(*video.VideoURL) == processing.FormatHLS {
+		respondWithError(w, http.StatusConflict, "HLS videos can't be probed", nil)
+		return
+	}
+
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't locate video", err)
+		return
+	}
+
+	// The ETag changes whenever the object is rewritten, so a reprocessed
+	// video is probed again rather than served a stale result
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reach video's bucket", err)
+		return
+	}
+	head, err := client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find stored video", err)
+		return
+	}
+	etag := aws.ToString(head.ETag)
+
+	probe, err := cfg.db.GetObjectProbe(bucket, key, etag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get probe", err)
+		return
+	}
+
+	if probe == nil {
+		// ffprobe only reads the ranges it needs from the signed URL
+		sourceURL, err := cfg.generatePresignedURL(r.Context(), bucket, key, probeURLExpiry)
+		if err != nil {
+			if errors.Is(err, errVideoArchived) {
+				respondWithRequestError(w, err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+			return
+		}
+		probe, err = processing.ProbeFile(sourceURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't probe video", err)
+			return
+		}
+		if _, err := processing.ParseProbe(probe); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't probe video", err)
+			return
+		}
+		if err := cfg.db.SaveObjectProbe(bucket, key, etag, probe); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save probe", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, json.RawMessage(probe))
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Lifetime of a share link when the owner doesn't pick one, and the longest allowed
+const (
+	defaultShareLinkExpiry = 7 * 24 * time.Hour
+	maxShareLinkExpiry     = 365 * 24 * time.Hour
+)
+
+// Lifetime of the presigned URL handed out each time a share link is opened,
+// cut short if the link itself expires first
+const shareURLExpiry = time.Hour
+
+// Header viewers send the password of a protected share link in
+const sharePasswordHeader = "X-Share-Password"
+
+// Longest password bcrypt can hash
+const maxSharePasswordBytes = 72
+
+type shareLinkResponse struct {
+	database.ShareLink
+	PasswordProtected bool   `json:"password_protected"`
+	URL               string `json:"url"`
+	PageURL           string `json:"page_url"`
+}
+
+// sharePage is what the share link page template renders
+type sharePage struct {
+	Title             string
+	PasswordProtected bool
+	ResolveURL        string
+	PasswordHeader    string
+}
+
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="robots" content="noindex">
+  <title>{{.Title}} - Tubely</title>
+  <style>
+    html, body { margin: 0; height: 100%; background: #000; color: #fff; font-family: sans-serif; }
+    video { width: 100%; height: 100%; }
+    form { display: flex; flex-direction: column; gap: 0.5em; max-width: 20em; margin: 20vh auto; }
+  </style>
+</head>
+<body>
+  <form id="gate"{{if not .PasswordProtected}} hidden{{end}}>
+    <label for="password">This video is password protected</label>
+    <input id="password" type="password" autocomplete="off" required autofocus>
+    <button type="submit">Watch</button>
+    <p id="message"></p>
+  </form>
+  <video id="player" controls playsinline hidden></video>
+  <script>
+    const gate = document.getElementById("gate");
+    const message = document.getElementById("message");
+    const player = document.getElementById("player");
+    const resolveURL = {{.ResolveURL}};
+    const passwordHeader = {{.PasswordHeader}};
+
+    // Every successful request counts as a view, so only ask once the viewer is ready to watch
+    async function open(password) {
+      const headers = password ? { [passwordHeader]: password } : {};
+      const res = await fetch(resolveURL, { headers });
+      const body = await res.json();
+      if (!res.ok) {
+        gate.hidden = false;
+        message.textContent = body.error;
+        return;
+      }
+      document.title = body.title + " - Tubely";
+      gate.hidden = true;
+      player.hidden = false;
+      if (body.thumbnail_url) {
+        player.poster = body.thumbnail_url;
+      }
+      player.src = body.url;
+    }
+    gate.addEventListener("submit", (event) => {
+      event.preventDefault();
+      open(document.getElementById("password").value);
+    });
+    {{if not .PasswordProtected}}open("");{{end}}
+  </script>
+</body>
+</html>
+`))
+
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+		MaxViews         *int   `json:"max_views"`
+		Password         string `json:"password"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video must be uploaded before it can be shared", nil)
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	plan, err := cfg.userPlan(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	planMaxExpiry := planMaxLinkExpiry(plan)
+
+	details := []fieldError{}
+	expiry := time.Duration(params.ExpiresInSeconds) * time.Second
+	if params.ExpiresInSeconds == 0 {
+		expiry = defaultShareLinkExpiry
+		if planMaxExpiry > 0 {
+			expiry = min(expiry, planMaxExpiry)
+		}
+	}
+	if params.ExpiresInSeconds < 0 || expiry > maxShareLinkExpiry {
+		details = append(details, fieldError{
+			Field:   "expires_in_seconds",
+			Message: fmt.Sprintf("must be between 1 and %d", int(maxShareLinkExpiry.Seconds())),
+		})
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		details = append(details, fieldError{Field: "max_views", Message: "must be at least 1"})
+	}
+	if len(params.Password) > maxSharePasswordBytes {
+		details = append(details, fieldError{
+			Field:   "password",
+			Message: fmt.Sprintf("must be at most %d bytes", maxSharePasswordBytes),
+		})
+	}
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid share link", nil, details)
+		return
+	}
+	if planMaxExpiry > 0 && expiry > planMaxExpiry {
+		respondWithRequestError(w, linkExpiryNotInPlan(planMaxExpiry))
+		return
+	}
+
+	passwordHash := ""
+	if params.Password != "" {
+		passwordHash, err = auth.HashPassword(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+	}
+
+	// Each link gets its own token, so it can be revoked without affecting others
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share token", err)
+		return
+	}
+	link, err := cfg.db.CreateShareLink(token, database.CreateShareLinkParams{
+		VideoID:      video.ID,
+		UserID:       video.UserID,
+		ExpiresAt:    time.Now().UTC().Add(expiry),
+		MaxViews:     params.MaxViews,
+		PasswordHash: passwordHash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, cfg.newShareLinkResponse(link, cfg.getRequestOrigin(r), apiPathFor(r, apiPrefix)))
+}
+
+func (cfg *apiConfig) handlerShareLinksList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.GetShareLinks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve share links", err)
+		return
+	}
+
+	response := make([]shareLinkResponse, 0, len(links))
+	for _, link := range links {
+		response = append(response, cfg.newShareLinkResponse(link, cfg.getRequestOrigin(r), apiPathFor(r, apiPrefix)))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerShareLinkDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(r.PathValue("shareID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID", err)
+		return
+	}
+
+	link, err := cfg.db.GetShareLink(shareID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil || link.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteShareLink(link.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete share link", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The page a share link is opened from in a browser. It asks for the password when
+// the link has one, then fetches the video the same way an API client would.
+func (cfg *apiConfig) handlerSharePage(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	link, ok := cfg.getOpenableShareLink(w, token)
+	if !ok {
+		return
+	}
+
+	// The title is only shown once the password has been checked
+	page := sharePage{
+		Title:             "Shared video",
+		PasswordProtected: link.PasswordHash != "",
+		ResolveURL:        apiPathFor(r, apiPrefix+"share/"+token),
+		PasswordHeader:    sharePasswordHeader,
+	}
+
+	// Keep the token out of the Referer sent when the video loads from the bucket
+	w.Header().Set("Referrer-Policy", "strict-origin")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	sharePageTemplate.Execute(w, page)
+}
+
+// Share links are opened without logging in, the token is the credential, along
+// with the password for links that have one. Every time one is opened counts as a view.
+func (cfg *apiConfig) handlerShareLinkResolve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Title        string     `json:"title"`
+		Description  string     `json:"description"`
+		ThumbnailURL *string    `json:"thumbnail_url"`
+		Duration     *float64   `json:"duration"`
+		URL          string     `json:"url"`
+		URLExpiresAt time.Time  `json:"url_expires_at"`
+		ExpiresAt    time.Time  `json:"expires_at"`
+		Views        int        `json:"views"`
+		MaxViews     *int       `json:"max_views"`
+		LastViewedAt *time.Time `json:"last_viewed_at"`
+	}
+
+	link, ok := cfg.getOpenableShareLink(w, r.PathValue("token"))
+	if !ok {
+		return
+	}
+	if link.PasswordHash != "" {
+		password := r.Header.Get(sharePasswordHeader)
+		if password == "" {
+			respondWithErrorCode(w, errCodePasswordRequired, "Share link needs a password", nil, nil)
+			return
+		}
+		// Guesses are limited per link and per client, so neither can be brute forced
+		attempts := []string{"link:" + link.ID.String()}
+		if addr, ok := cfg.proxies.clientAddr(r); ok {
+			attempts = append(attempts, "client:"+addr.String())
+		}
+		if wait, ok := cfg.shareAttempts.take(attempts...); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+			respondWithErrorCode(w, errCodeRateLimited, "Too many incorrect passwords, try again later", nil, nil)
+			return
+		}
+		if err := auth.CheckPasswordHash(password, link.PasswordHash); err != nil {
+			respondWithErrorCode(w, errCodePasswordRequired, "Incorrect password", err, nil)
+			return
+		}
+		cfg.shareAttempts.refund(attempts...)
+	}
+
+	if !cfg.hotlink.allows(r) {
+		respondWithError(w, http.StatusForbidden, "Embedding from this site isn't allowed", nil)
+		return
+	}
+	if !cfg.checkViewerAccess(w, r, link.VideoID) {
+		return
+	}
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	// The URL never outlives the link, whatever the presign lifetime
+	now := time.Now().UTC()
+	url, urlExpiresAt, err := cfg.signVideoURL(r.Context(), video, min(shareURLExpiry, link.ExpiresAt.Sub(now)))
+	if err != nil {
+		if errors.Is(err, errVideoArchived) {
+			respondWithRequestError(w, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	// The view is only counted once a URL is ready, and concurrent opens can't exceed the cap
+	counted, err := cfg.db.RecordShareLinkView(link.ID, now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record view", err)
+		return
+	}
+	if !counted {
+		respondWithErrorCode(w, errCodeExpired, "Share link has no views left", nil, nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		Title:        video.Title,
+		Description:  video.Description,
+		ThumbnailURL: cfg.resolveAssetURL(r.Context(), video.ThumbnailURL),
+		Duration:     video.Duration,
+		URL:          url,
+		URLExpiresAt: urlExpiresAt,
+		ExpiresAt:    link.ExpiresAt,
+		Views:        link.Views + 1,
+		MaxViews:     link.MaxViews,
+		LastViewedAt: &now,
+	})
+}
+
+// Function to get a share link that can still be opened, responding with 404 or 410 otherwise
+func (cfg *apiConfig) getOpenableShareLink(w http.ResponseWriter, token string) (database.ShareLink, bool) {
+	link, err := cfg.db.GetShareLinkByToken(token)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return database.ShareLink{}, false
+	}
+	if link.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return database.ShareLink{}, false
+	}
+	if !time.Now().UTC().Before(link.ExpiresAt) {
+		respondWithErrorCode(w, errCodeExpired, "Share link has expired", nil, nil)
+		return database.ShareLink{}, false
+	}
+	if link.MaxViews != nil && link.Views >= *link.MaxViews {
+		respondWithErrorCode(w, errCodeExpired, "Share link has no views left", nil, nil)
+		return database.ShareLink{}, false
+	}
+	return link, true
+}
+
+// Function to build the owner's view of a share link, with the URLs it's opened at.
+// apiBase is the versioned API prefix the link's API URL is given under.
+func (cfg *apiConfig) newShareLinkResponse(link database.ShareLink, serverOrigin, apiBase string) shareLinkResponse {
+	return shareLinkResponse{
+		ShareLink:         link,
+		PasswordProtected: link.PasswordHash != "",
+		URL:               fmt.Sprintf("%sshare/%s", serverOrigin+apiBase, link.Token),
+		PageURL:           fmt.Sprintf("%s/s/%s", serverOrigin, link.Token),
+	}
+}
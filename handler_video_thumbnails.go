@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type thumbnailVersion struct {
+	database.Thumbnail
+	URL      string            `json:"url"`
+	Variants map[string]string `json:"variants"`
+	Current  bool              `json:"current"`
+}
+
+func (cfg *apiConfig) handlerThumbnailsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	thumbnails, err := cfg.db.GetThumbnails(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thumbnails", err)
+		return
+	}
+
+	versions := make([]thumbnailVersion, 0, len(thumbnails))
+	for _, thumbnail := range thumbnails {
+		variants := map[string]string{}
+		for name, assetPath := range thumbnail.Variants {
+			variants[name] = cfg.getAssetURL(r.Context(), assetPath)
+		}
+		versions = append(versions, thumbnailVersion{
+			Thumbnail: thumbnail,
+			URL:       cfg.getAssetURL(r.Context(), thumbnail.AssetPath),
+			Variants:  variants,
+			Current:   thumbnail.SupersededAt == nil,
+		})
+	}
+
+	respondWithList(w, http.StatusOK, versions, listPage{Total: len(versions)})
+}
+
+func (cfg *apiConfig) handlerThumbnailRevert(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	thumbnailID, err := uuid.Parse(r.PathValue("thumbnailID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid thumbnail ID", err)
+		return
+	}
+
+	thumbnail, err := cfg.db.GetThumbnail(thumbnailID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail", err)
+		return
+	}
+	if thumbnail.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
+		return
+	}
+	if _, err := os.Stat(cfg.getAssetDiskPath(thumbnail.AssetPath)); err != nil {
+		respondWithError(w, http.StatusGone, "Thumbnail is no longer stored", err)
+		return
+	}
+
+	stored := storedThumbnail{
+		Location:  assetLocation(thumbnail.AssetPath),
+		AssetPath: thumbnail.AssetPath,
+		Bytes:     thumbnail.Bytes,
+	}
+	// Variants are left out if they're gone, rather than pointing the video at missing files
+	for _, spec := range thumbnailVariants {
+		assetPath, ok := thumbnail.Variants[spec.Name]
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(cfg.getAssetDiskPath(assetPath)); err != nil {
+			continue
+		}
+		stored.Variants = append(stored.Variants, storedThumbnailVariant{
+			Name:      spec.Name,
+			Location:  assetLocation(assetPath),
+			AssetPath: assetPath,
+		})
+	}
+	if thumbnail.BlurHash != nil && thumbnail.DominantColor != nil && thumbnail.PHash != nil {
+		stored.Analysis = &thumbnailAnalysis{
+			BlurHash:      *thumbnail.BlurHash,
+			DominantColor: *thumbnail.DominantColor,
+			PHash:         *thumbnail.PHash,
+		}
+	}
+	stored.apply(&video)
+
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if err := cfg.db.SetCurrentThumbnail(video.ID, thumbnail.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update thumbnail history", err)
+		return
+	}
+	cfg.purgeCachedVideo(video)
+
+	respondWithJSON(w, http.StatusOK, cfg.withURLs(r.Context(), video))
+}
+
+// Function to delete superseded thumbnails once they've been kept for the retention period,
+// checking every interval until ctx is cancelled. Nothing is deleted outside normal mode.
+func (cfg *apiConfig) pruneThumbnailHistory(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if !cfg.serviceMode.frozen() {
+			cfg.deleteSupersededThumbnails(retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Function to delete the superseded thumbnails older than retention
+func (cfg *apiConfig) deleteSupersededThumbnails(retention time.Duration) {
+	thumbnails, err := cfg.db.GetSupersededThumbnails(retention)
+	if err != nil {
+		log.Printf("Couldn't list superseded thumbnails: %v", err)
+	}
+	for _, thumbnail := range thumbnails {
+		err := os.Remove(cfg.getAssetDiskPath(thumbnail.AssetPath))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't delete superseded thumbnail %s: %v", thumbnail.AssetPath, err)
+			continue
+		}
+		cfg.forgetStoredObject("", thumbnail.AssetPath)
+		for _, assetPath := range thumbnail.Variants {
+			err := os.Remove(cfg.getAssetDiskPath(assetPath))
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("Couldn't delete superseded thumbnail variant %s: %v", assetPath, err)
+			}
+			cfg.forgetStoredObject("", assetPath)
+		}
+		if err := cfg.db.DeleteThumbnail(thumbnail.ID); err != nil {
+			log.Printf("Couldn't remove thumbnail %s from history: %v", thumbnail.ID, err)
+		}
+	}
+	if len(thumbnails) > 0 {
+		log.Printf("Deleted %d thumbnail(s) superseded more than %s ago", len(thumbnails), retention)
+	}
+}
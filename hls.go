@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// hlsProxyConfig decides how HLS videos are streamed when the bucket isn't
+// public.
+type hlsProxyConfig struct {
+	// Enabled hands out proxy URLs for HLS videos instead of their CloudFront URL
+	Enabled bool
+	// ProxySegments streams segments through the server instead of
+	// presigning them in the playlists
+	ProxySegments bool
+	// SegmentURLExpiry is how long presigned segment URLs last on top of the
+	// video's duration, so a viewer who starts within it can finish
+	SegmentURLExpiry time.Duration
+}
+
+// Largest playlist the proxy will rewrite
+const maxHLSPlaylistSize = 4 << 20
+
+// S3 doesn't accept presigned URLs valid for more than a week
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// Function to get a URL that streams an HLS video through the proxy for up to expiry
+func (cfg *apiConfig) getHLSProxyURL(videoID uuid.UUID, expiry time.Duration) (string, error) {
+	token, err := auth.MakeStreamToken(videoID, cfg.jwtSecret, expiry)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/v1/hls/%s/%s", cfg.getServerOrigin(), token, processing.HLSPlaylistName), nil
+}
+
+// The stream token is the credential, since HLS players can't send headers
+func (cfg *apiConfig) handlerHLSMasterPlaylist(w http.ResponseWriter, r *http.Request) {
+	cfg.serveHLSFile(w, r, processing.HLSPlaylistName)
+}
+
+func (cfg *apiConfig) handlerHLSFile(w http.ResponseWriter, r *http.Request) {
+	rendition, file := r.PathValue("rendition"), r.PathValue("file")
+	if !validHLSName(rendition) || !validHLSName(file) {
+		respondWithError(w, http.StatusBadRequest, "Invalid file path", nil)
+		return
+	}
+	cfg.serveHLSFile(w, r, rendition+"/"+file)
+}
+
+// Function to check a path segment of an HLS file names a file in the
+// video's output. Path values are decoded, so an encoded slash could
+// otherwise climb out of it.
+func validHLSName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+// Encrypted videos' players fetch the key with the same stream token as the playlists
+func (cfg *apiConfig) handlerHLSKey(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeStream(w, r)
+	if !ok {
+		return
+	}
+	key, err := cfg.db.GetHLSKey(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get key", err)
+		return
+	}
+	if key == nil {
+		respondWithError(w, http.StatusNotFound, "Video isn't encrypted", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(key)
+}
+
+// Function to check a request carries a stream token for an HLS video that
+// can still be played, writing the error if not
+func (cfg *apiConfig) authorizeStream(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	if !cfg.hls.Enabled {
+		respondWithError(w, http.StatusNotFound, "HLS proxy is disabled", nil)
+		return database.Video{}, false
+	}
+	if !cfg.hotlink.allows(r) {
+		respondWithError(w, http.StatusForbidden, "Embedding from this site isn't allowed", nil)
+		return database.Video{}, false
+	}
+	videoID, err := auth.ValidateStreamToken(r.PathValue("token"), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate stream token", err)
+		return database.Video{}, false
+	}
+	video, ok := cfg.getPublishedVideo(w, videoID)
+	if !ok {
+		return database.Video{}, false
+	}
+	if processing.FormatForKey(*video.VideoURL) != processing.FormatHLS {
+		respondWithError(w, http.StatusNotFound, "Video has no HLS output", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// Function to serve a file of a video's HLS output by its path under the
+// master playlist: playlists are rewritten, and segments are streamed or
+// redirected to a presigned URL
+func (cfg *apiConfig) serveHLSFile(w http.ResponseWriter, r *http.Request, name string) {
+	video, ok := cfg.authorizeStream(w, r)
+	if !ok {
+		return
+	}
+	bucket, masterKey, err := cfg.getVideoLocation(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	key := path.Join(path.Dir(masterKey), name)
+	if !strings.HasPrefix(key, path.Dir(masterKey)+"/") {
+		respondWithError(w, http.StatusNotFound, "File not found", nil)
+		return
+	}
+
+	if path.Ext(name) == ".m3u8" {
+		cfg.serveHLSPlaylist(w, r, video, bucket, key)
+		return
+	}
+	if !cfg.hls.ProxySegments {
+		signedURL, err := cfg.generatePresignedURL(r.Context(), bucket, key, cfg.hlsSegmentURLExpiry(video))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign URL", err)
+			return
+		}
+		http.Redirect(w, r, signedURL, http.StatusFound)
+		return
+	}
+	cfg.serveHLSSegment(w, r, bucket, key)
+}
+
+// Function to serve a playlist with its segments presigned, on every fetch so
+// the URLs are always fresh. Variant playlists are left relative, so players
+// fetch them through the proxy too, and the key of an encrypted video is
+// pointed at the key endpoint under the same token.
+func (cfg *apiConfig) serveHLSPlaylist(w http.ResponseWriter, r *http.Request, video database.Video, bucket, key string) {
+	object, err := cfg.getHLSObject(r.Context(), bucket, key, "")
+	if err != nil {
+		respondWithHLSObjectError(w, err)
+		return
+	}
+	defer object.Body.Close()
+	playlist, err := io.ReadAll(io.LimitReader(object.Body, maxHLSPlaylistSize+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't read playlist", err)
+		return
+	}
+	if len(playlist) > maxHLSPlaylistSize {
+		respondWithError(w, http.StatusBadGateway, "Playlist is too large", nil)
+		return
+	}
+
+	prefix := path.Dir(key)
+	outputPrefix := path.Dir(prefix)
+	if path.Base(key) == processing.HLSPlaylistName {
+		outputPrefix = prefix
+	}
+	expiry := cfg.hlsSegmentURLExpiry(video)
+	// Byte-range playlists name the same file over and over, so each is only signed once
+	signed := map[string]string{}
+	keyURL := cfg.getServerOrigin() + apiPathFor(r, apiPrefix+"hls/"+r.PathValue("token")+"/key")
+	rewritten, err := processing.RewritePlaylistURIs(playlist, func(uri string) (string, error) {
+		if uri == processing.HLSKeyURI {
+			return keyURL, nil
+		}
+		u, err := url.Parse(uri)
+		if err != nil || u.IsAbs() || strings.HasPrefix(u.Path, "/") {
+			return uri, nil
+		}
+		if cfg.hls.ProxySegments || path.Ext(u.Path) == ".m3u8" {
+			return uri, nil
+		}
+		segmentKey := path.Join(prefix, u.Path)
+		if !strings.HasPrefix(segmentKey, outputPrefix+"/") {
+			return uri, nil
+		}
+		if signedURL, ok := signed[segmentKey]; ok {
+			return signedURL, nil
+		}
+		signedURL, err := cfg.generatePresignedURL(r.Context(), bucket, segmentKey, expiry)
+		if err != nil {
+			return "", err
+		}
+		signed[segmentKey] = signedURL
+		return signedURL, nil
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign playlist", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(rewritten)
+}
+
+// Function to stream a segment from the bucket, passing byte ranges through
+// so players can seek within it
+func (cfg *apiConfig) serveHLSSegment(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	object, err := cfg.getHLSObject(r.Context(), bucket, key, r.Header.Get("Range"))
+	if err != nil {
+		respondWithHLSObjectError(w, err)
+		return
+	}
+	defer object.Body.Close()
+
+	w.Header().Set("Content-Type", aws.ToString(object.ContentType))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if object.ETag != nil {
+		w.Header().Set("ETag", *object.ETag)
+	}
+	if object.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*object.ContentLength, 10))
+	}
+	status := http.StatusOK
+	if object.ContentRange != nil {
+		w.Header().Set("Content-Range", *object.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	io.Copy(w, object.Body)
+}
+
+// Function to read an object of an HLS output, optionally just a byte range of it
+func (cfg *apiConfig) getHLSObject(ctx context.Context, bucket, key, byteRange string) (*s3.GetObjectOutput, error) {
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if byteRange != "" {
+		input.Range = aws.String(byteRange)
+	}
+	return client.GetObject(ctx, input)
+}
+
+// Function to answer a failed read of an HLS object, telling missing files
+// and unsatisfiable ranges apart from storage errors
+func respondWithHLSObjectError(w http.ResponseWriter, err error) {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		respondWithError(w, http.StatusNotFound, "File not found", nil)
+		return
+	}
+	var responseErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable {
+		respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Range not satisfiable", nil)
+		return
+	}
+	respondWithError(w, http.StatusBadGateway, "Couldn't read from storage", err)
+}
+
+// Function to get how long a video's presigned segment URLs should last
+func (cfg *apiConfig) hlsSegmentURLExpiry(video database.Video) time.Duration {
+	expiry := cfg.hls.SegmentURLExpiry
+	if video.Duration != nil {
+		expiry += time.Duration(*video.Duration * float64(time.Second))
+	}
+	return min(expiry, maxPresignExpiry)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHLSFileRejectsTraversal(t *testing.T) {
+	cfg := apiConfig{
+		jwtSecret: "secret",
+		hls:       hlsProxyConfig{Enabled: true},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/hls/{token}/{rendition}/{file}", cfg.handlerHLSFile)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "encoded traversal in rendition", path: "/api/v1/hls/token/..%2F..%2Fother/x", wantStatus: http.StatusBadRequest},
+		{name: "encoded traversal in file", path: "/api/v1/hls/token/720p/..%2F..%2Fother", wantStatus: http.StatusBadRequest},
+		{name: "parent rendition", path: "/api/v1/hls/token/%2E%2E/x", wantStatus: http.StatusBadRequest},
+		{name: "encoded backslash", path: "/api/v1/hls/token/720p/..%5Cother", wantStatus: http.StatusBadRequest},
+		{name: "file under a rendition", path: "/api/v1/hls/token/720p/segment0.ts", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
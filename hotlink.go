@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// hotlinkPolicy decides which sites may embed assets and whether shared
+// links hand out single-use URLs.
+type hotlinkPolicy struct {
+	// AllowedOrigins lists the scheme://host[:port] of sites allowed to
+	// embed assets. Empty disables the Referer/Origin check.
+	AllowedOrigins map[string]bool
+	// RequireReferer rejects requests that carry neither header, which
+	// also blocks opening an asset directly.
+	RequireReferer bool
+	// SingleUseURLs makes shared links resolve to single-use playback links
+	// instead of presigned URLs that can be reused until they expire.
+	SingleUseURLs bool
+	// URLExpiry is how long a playback link and the presigned URL it
+	// redirects to stay valid.
+	URLExpiry time.Duration
+}
+
+// Function to parse a comma-separated list of origins, normalizing each to scheme://host
+func parseAllowedOrigins(list string) (map[string]bool, error) {
+	origins := make(map[string]bool)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		origin, ok := originOf(entry)
+		if !ok {
+			return nil, fmt.Errorf("invalid origin %q, expected scheme://host", entry)
+		}
+		origins[origin] = true
+	}
+	return origins, nil
+}
+
+// Function to reduce a URL to its lowercased scheme://host[:port]
+func originOf(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// Function to check the Origin or Referer of a request against the allowed origins
+func (p hotlinkPolicy) allows(r *http.Request) bool {
+	if len(p.AllowedOrigins) == 0 {
+		return true
+	}
+
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return !p.RequireReferer
+	}
+	origin, ok := originOf(source)
+	return ok && p.AllowedOrigins[origin]
+}
+
+// Function to reject requests embedding a resource from a site that isn't allowed
+func (p hotlinkPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.allows(r) {
+			respondWithError(w, http.StatusForbidden, "Embedding from this site isn't allowed", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Function to create a single-use playback link for a stored object, returning its URL
+func (cfg *apiConfig) createPlaybackLink(bucket, key, fragment string) (string, error) {
+	if err := cfg.checkObjectPlayable(bucket, key); err != nil {
+		return "", err
+	}
+	if err := cfg.db.DeleteExpiredPlaybackLinks(time.Now().UTC()); err != nil {
+		log.Printf("Couldn't delete expired playback links: %v", err)
+	}
+
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	err = cfg.db.CreatePlaybackLink(nonce, database.CreatePlaybackLinkParams{
+		Bucket:    bucket,
+		ObjectKey: key,
+		Fragment:  fragment,
+		ExpiresAt: time.Now().UTC().Add(cfg.hotlink.URLExpiry),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/v1/play/%s", cfg.getServerOrigin(), nonce), nil
+}
+
+// Playback links are opened without logging in, the nonce is the credential
+func (cfg *apiConfig) handlerPlaybackLink(w http.ResponseWriter, r *http.Request) {
+	if !cfg.hotlink.allows(r) {
+		respondWithError(w, http.StatusForbidden, "Embedding from this site isn't allowed", nil)
+		return
+	}
+
+	nonce := r.PathValue("nonce")
+	link, err := cfg.db.GetPlaybackLink(nonce)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playback link", err)
+		return
+	}
+	if link.Nonce == "" {
+		respondWithError(w, http.StatusNotFound, "Playback link not found", nil)
+		return
+	}
+	if time.Now().After(link.ExpiresAt) {
+		respondWithErrorCode(w, errCodeExpired, "Playback link has expired", nil, nil)
+		return
+	}
+
+	used, err := cfg.db.UsePlaybackLink(nonce)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't use playback link", err)
+		return
+	}
+	if !used {
+		respondWithErrorCode(w, errCodeExpired, "Playback link has already been used", nil, nil)
+		return
+	}
+
+	signedURL, err := cfg.generatePresignedURL(r.Context(), link.Bucket, link.ObjectKey, cfg.hotlink.URLExpiry)
+	if err != nil {
+		if errors.Is(err, errVideoArchived) {
+			respondWithRequestError(w, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign URL", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, signedURL+link.Fragment, http.StatusFound)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/google/uuid"
+)
+
+// Media types of the local files the SFTP gateway and the watch folder
+// process, by extension. Files with other names are left alone.
+var fileMediaTypes = map[string]string{
+	".mp4": "video/mp4",
+}
+
+// Function to report whether a file's name says it's a video to process
+func isVideoFileName(name string) bool {
+	_, ok := fileMediaTypes[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// Function to create a video titled after a local file and run the file
+// through the ingest pipeline, as if it had been uploaded over HTTP. Files
+// arrive this way in batches, so they're processed as bulk jobs.
+func (cfg *apiConfig) ingestFile(userID uuid.UUID, name, filePath string) (database.Video, error) {
+	title := strings.TrimSuffix(name, filepath.Ext(name))
+	return cfg.ingestFileAs(userID, title, fileMediaTypes[strings.ToLower(filepath.Ext(name))], filePath, name, database.JobPriorityBulk)
+}
+
+// Function to create a video with the given title for a local file and run
+// the file through the ingest pipeline, recording filename as the name it
+// arrived under
+func (cfg *apiConfig) ingestFileAs(userID uuid.UUID, title, mediaType, filePath, filename string, priority database.JobPriority) (database.Video, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if user == nil || user.DeletedAt != nil {
+		return database.Video{}, errors.New("user no longer exists")
+	}
+
+	params := database.CreateVideoParams{
+		Title:  title,
+		UserID: user.ID,
+	}
+	params.TenantID = user.TenantID
+	// Email attachments may have been saved encrypted
+	file, err := cfg.scratch.Open(filePath)
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer file.Close()
+	size, err := file.Size()
+	if err != nil {
+		return database.Video{}, err
+	}
+	plan, err := cfg.userPlan(user.ID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if err := cfg.checkPlanUpload(user.ID, plan, size); err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return database.Video{}, &ingest.Error{Stage: "create", Msg: reqErr.msg}
+		}
+		return database.Video{}, err
+	}
+	settings, err := cfg.resolveUploadSettings(user.ID, plan, "", "")
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	video, err := cfg.db.CreateVideo(params)
+	if errors.Is(err, database.ErrTenantFull) {
+		err = cfg.tenantFullError(user.TenantID.UUID)
+	}
+	if err != nil {
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return database.Video{}, &ingest.Error{Stage: "create", Msg: reqErr.msg}
+		}
+		return database.Video{}, err
+	}
+	upload := &ingest.Upload{
+		Video:     video,
+		MediaType: mediaType,
+		Profile:   settings.Profile,
+		Notify:    settings.Notify,
+		Priority:  priority,
+		Body:      file,
+	}
+	if err := cfg.ingest.Run(context.Background(), upload); err != nil {
+		// Nothing was stored for the video, so don't leave it behind empty
+		if deleteErr := cfg.db.DeleteVideo(video.ID); deleteErr != nil {
+			log.Printf("Couldn't delete video %s after failing to ingest %s: %v", video.ID, filePath, deleteErr)
+		}
+		return database.Video{}, err
+	}
+	cfg.recordVideoUpload(video.ID, newVideoUpload(filename, &size))
+	if !cfg.ingest.Inline() {
+		cfg.startAsyncJob(upload.Job)
+	}
+	return video, nil
+}
+
+// Function to describe why a file couldn't be ingested, for the person who
+// dropped it rather than the logs
+func ingestFailureMessage(err error) string {
+	var ingestErr *ingest.Error
+	if errors.As(err, &ingestErr) {
+		return ingestErr.Msg
+	}
+	return "Couldn't process the file"
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -18,6 +19,8 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	TokenTypeEmbed  TokenType = "tubely-embed"
+	TokenTypeStream TokenType = "tubely-stream"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -34,48 +37,136 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// accessClaims are the claims carried by an access token. TenantID is
+// empty for users outside any tenant.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
 func MakeJWT(
 	userID uuid.UUID,
+	tenantID uuid.UUID,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
-	})
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	}
+	if tenantID != uuid.Nil {
+		claims.TenantID = tenantID.String()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(signingKey)
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	userID, _, err := ValidateJWTTenant(tokenString, tokenSecret)
+	return userID, err
+}
+
+// ValidateJWTTenant validates an access token and returns both the user and
+// the tenant it was issued for, uuid.Nil if the user has no tenant.
+func ValidateJWTTenant(tokenString, tokenSecret string) (uuid.UUID, uuid.UUID, error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, uuid.Nil, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, uuid.Nil, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, uuid.Nil, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return uuid.Nil, uuid.Nil, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	tenantID := uuid.Nil
+	if claimsStruct.TenantID != "" {
+		tenantID, err = uuid.Parse(claimsStruct.TenantID)
+		if err != nil {
+			return uuid.Nil, uuid.Nil, fmt.Errorf("invalid tenant ID: %w", err)
+		}
+	}
+	return id, tenantID, nil
+}
+
+// MakeEmbedToken returns a token that lets an embedded player fetch playback
+// URLs for one video, without logging in.
+func MakeEmbedToken(videoID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeVideoToken(TokenTypeEmbed, videoID, tokenSecret, expiresIn)
+}
+
+// ValidateEmbedToken validates an embed token and returns the video it was
+// issued for.
+func ValidateEmbedToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return validateVideoToken(TokenTypeEmbed, tokenString, tokenSecret)
+}
+
+// MakeStreamToken returns a token that lets an HLS player fetch one video's
+// playlists and segments through the server, without logging in.
+func MakeStreamToken(videoID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeVideoToken(TokenTypeStream, videoID, tokenSecret, expiresIn)
+}
+
+// ValidateStreamToken validates a stream token and returns the video it was
+// issued for.
+func ValidateStreamToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return validateVideoToken(TokenTypeStream, tokenString, tokenSecret)
+}
+
+// makeVideoToken returns a token of the given type for one video.
+func makeVideoToken(tokenType TokenType, videoID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   videoID.String(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// validateVideoToken validates a token of the given type and returns the
+// video it was issued for.
+func validateVideoToken(tokenType TokenType, tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if claims.Issuer != string(tokenType) {
+		return uuid.Nil, errors.New("invalid issuer")
+	}
+
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid video ID: %w", err)
 	}
 	return id, nil
 }
@@ -114,3 +205,22 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// APIKeyPrefix starts every user API key, so leaked keys are easy to spot.
+const APIKeyPrefix = "tubely_"
+
+// MakeAPIKey returns a new random user API key.
+func MakeAPIKey() (string, error) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + token, nil
+}
+
+// HashAPIKey returns the hash a user API key is stored and looked up by.
+// Keys are long and random, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
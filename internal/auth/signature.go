@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers carrying a webhook's signature and the time it was signed
+const (
+	SignatureHeader          = "X-Tubely-Signature"
+	SignatureTimestampHeader = "X-Tubely-Timestamp"
+)
+
+// SignPayload returns the signature header value for a webhook body sent at
+// timestamp: an HMAC-SHA256 of "<timestamp>.<body>" keyed by secret.
+func SignPayload(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp.Unix())
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateSignature checks a webhook's signature headers against its body,
+// rejecting requests signed more than tolerance away from now so a captured
+// request can't be replayed later.
+func ValidateSignature(secret string, headers http.Header, body []byte, tolerance time.Duration) error {
+	timestampHeader := headers.Get(SignatureTimestampHeader)
+	signature := headers.Get(SignatureHeader)
+	if timestampHeader == "" || signature == "" {
+		return errors.New("missing signature headers")
+	}
+
+	unix, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return errors.New("malformed signature timestamp")
+	}
+	timestamp := time.Unix(unix, 0)
+	if skew := time.Since(timestamp); skew > tolerance || skew < -tolerance {
+		return errors.New("signature timestamp is outside the allowed window")
+	}
+
+	expected := SignPayload(secret, timestamp, body)
+	if !strings.HasPrefix(signature, "sha256=") || !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package billing reads the webhook events Stripe sends about customers'
+// subscriptions, which decide the plan each customer is on.
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events about subscriptions all share this prefix, and carry the
+// subscription as it is after the change. Deleted subscriptions are
+// "canceled".
+const subscriptionEventPrefix = "customer.subscription."
+
+// EventCheckoutCompleted is the type of event sent when a customer finishes
+// a Checkout session.
+const EventCheckoutCompleted = "checkout.session.completed"
+
+// Metadata keys read from prices and subscriptions
+const (
+	metadataPlanKey = "plan"
+	metadataUserKey = "user_id"
+)
+
+// ErrInvalidSignature is returned when an event can't be shown to come from
+// Stripe.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Stripe reads events sent to a Stripe webhook endpoint.
+type Stripe struct {
+	// WebhookSecret is the endpoint's signing secret, "whsec_..."
+	WebhookSecret string
+	// Tolerance is how far an event's signature timestamp can be from now,
+	// to limit replays
+	Tolerance time.Duration
+}
+
+// Event is a Stripe event, with only what's needed to manage plans.
+type Event struct {
+	ID      string
+	Type    string
+	Created time.Time
+	// Subscription is set for customer.subscription.* events
+	Subscription *Subscription
+	// Checkout is set for checkout.session.completed events
+	Checkout *Checkout
+}
+
+// Subscription is the state of a subscription after an event.
+type Subscription struct {
+	ID       string
+	Customer string
+	Status   string
+	// Plans names the plan of each of the subscription's prices, from the
+	// price's lookup key or else its "plan" metadata
+	Plans []string
+	// UserID is the subscription's "user_id" metadata, if it was given
+	UserID string
+}
+
+// Entitled reports whether the subscriber should get what they're paying
+// for. Failed renewals keep it while Stripe retries the payment.
+func (s Subscription) Entitled() bool {
+	switch s.Status {
+	case "active", "trialing", "past_due":
+		return true
+	}
+	return false
+}
+
+// Checkout is a completed Checkout session, which links the customer it
+// created to the user who started it.
+type Checkout struct {
+	Customer string
+	// ClientReferenceID is the ID of the user, passed when the session was
+	// created
+	ClientReferenceID string
+}
+
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeSubscription struct {
+	ID       string            `json:"id"`
+	Customer string            `json:"customer"`
+	Status   string            `json:"status"`
+	Metadata map[string]string `json:"metadata"`
+	Items    struct {
+		Data []struct {
+			Price struct {
+				LookupKey string            `json:"lookup_key"`
+				Metadata  map[string]string `json:"metadata"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+type stripeCheckout struct {
+	Customer          string `json:"customer"`
+	ClientReferenceID string `json:"client_reference_id"`
+}
+
+// ParseEvent checks an event's Stripe-Signature header against its body
+// and reads it. Events of types that don't concern plans are returned with
+// neither Subscription nor Checkout set.
+func (s Stripe) ParseEvent(signature string, body []byte) (Event, error) {
+	if err := s.verify(signature, body); err != nil {
+		return Event{}, err
+	}
+
+	raw := stripeEvent{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return Event{}, fmt.Errorf("couldn't decode event: %w", err)
+	}
+	if raw.ID == "" || raw.Type == "" {
+		return Event{}, errors.New("event has no ID or type")
+	}
+	event := Event{ID: raw.ID, Type: raw.Type, Created: time.Unix(raw.Created, 0).UTC()}
+
+	switch {
+	case strings.HasPrefix(raw.Type, subscriptionEventPrefix):
+		sub := stripeSubscription{}
+		if err := json.Unmarshal(raw.Data.Object, &sub); err != nil {
+			return Event{}, fmt.Errorf("couldn't decode subscription: %w", err)
+		}
+		event.Subscription = &Subscription{
+			ID:       sub.ID,
+			Customer: sub.Customer,
+			Status:   sub.Status,
+			Plans:    []string{},
+			UserID:   sub.Metadata[metadataUserKey],
+		}
+		for _, item := range sub.Items.Data {
+			plan := item.Price.LookupKey
+			if plan == "" {
+				plan = item.Price.Metadata[metadataPlanKey]
+			}
+			if plan != "" {
+				event.Subscription.Plans = append(event.Subscription.Plans, plan)
+			}
+		}
+	case raw.Type == EventCheckoutCompleted:
+		checkout := stripeCheckout{}
+		if err := json.Unmarshal(raw.Data.Object, &checkout); err != nil {
+			return Event{}, fmt.Errorf("couldn't decode checkout session: %w", err)
+		}
+		event.Checkout = &Checkout{Customer: checkout.Customer, ClientReferenceID: checkout.ClientReferenceID}
+	}
+	return event, nil
+}
+
+// verify checks the Stripe-Signature header, like "t=1700000000,v1=abc...",
+// which can carry several v1 signatures while the secret is being rolled.
+func (s Stripe) verify(header string, body []byte) error {
+	var timestamp string
+	signatures := []string{}
+	for _, field := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or invalid timestamp", ErrInvalidSignature)
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > s.Tolerance || age < -s.Tolerance {
+		return fmt.Errorf("%w: timestamp is outside the tolerance", ErrInvalidSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no signature matches", ErrInvalidSignature)
+}
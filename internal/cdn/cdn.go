@@ -0,0 +1,95 @@
+// Package cdn lets a CDN in front of the server cache public reads of videos,
+// and tells it to forget them when the videos change.
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// purgeTimeout bounds each purge request, so a slow CDN can't pile them up.
+const purgeTimeout = 10 * time.Second
+
+// VideoKey is the surrogate key of every cached response about one video:
+// its metadata, watch page, player page and oEmbed.
+func VideoKey(videoID uuid.UUID) string {
+	return "video-" + videoID.String()
+}
+
+// FeedKey is the surrogate key of a user's cached feeds.
+func FeedKey(userID uuid.UUID) string {
+	return "feed-" + userID.String()
+}
+
+// SetHeaders lets shared caches keep a response for up to maxAge, tagged with
+// keys so it can be purged before then. Browsers are told to check back every
+// time, so owners see their own edits straight away. With maxAge at 0 the
+// response is only tagged.
+func SetHeaders(header http.Header, maxAge time.Duration, keys ...string) {
+	if maxAge > 0 {
+		header.Set("Cache-Control", fmt.Sprintf("public, max-age=0, s-maxage=%d", int(maxAge.Seconds())))
+	}
+	header.Set("Surrogate-Key", strings.Join(keys, " "))
+}
+
+// Purger asks a CDN to drop the cached responses about videos that changed,
+// by posting their surrogate keys to a purge endpoint as
+// {"surrogate_keys": [...]}. A nil Purger does nothing.
+type Purger struct {
+	URL string
+	// Token is sent as a bearer token, if set
+	Token      string
+	HTTPClient *http.Client
+}
+
+// PurgeVideo drops the cached responses about a video and its owner's feeds.
+// Failures are logged rather than returned, since the change stands either
+// way and the cached copies still expire on their own.
+func (p *Purger) PurgeVideo(ctx context.Context, video database.Video) {
+	if p == nil {
+		return
+	}
+	keys := []string{VideoKey(video.ID), FeedKey(video.UserID)}
+	if err := p.purge(ctx, keys); err != nil {
+		log.Printf("Couldn't purge cached responses for video %s: %v", video.ID, err)
+	}
+}
+
+func (p *Purger) purge(ctx context.Context, keys []string) error {
+	body, err := json.Marshal(struct {
+		SurrogateKeys []string `json:"surrogate_keys"`
+	}{keys})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, purgeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("purge endpoint returned %s", resp.Status)
+	}
+	return nil
+}
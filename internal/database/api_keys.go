@@ -0,0 +1,138 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a long-lived credential a user creates for tools that can't
+// log in interactively, such as the SFTP gateway. Only a hash of the key is
+// stored; the key itself is shown once, when it's created.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// Prefix is the start of the key, so users can tell their keys apart
+	Prefix  string `json:"prefix"`
+	KeyHash string `json:"-"`
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		last_used_at,
+		user_id,
+		name,
+		prefix,
+		key_hash`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+	)
+	return key, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.Name, params.Prefix, params.KeyHash)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.getAPIKey("id", id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	return c.getAPIKey("id", id)
+}
+
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	return c.getAPIKey("key_hash", keyHash)
+}
+
+func (c Client) getAPIKey(column string, value any) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE ` + column + ` = ?
+	`
+
+	key, err := scanAPIKey(c.db.QueryRow(query, value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+func (c Client) GetUserAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records that a key has just been used.
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	_, err := c.db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", time.Now().UTC(), id)
+	return err
+}
+
+func (c Client) DeleteAPIKey(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM api_keys WHERE id = ?", id)
+	return err
+}
+
+func (c Client) DeleteUserAPIKeys(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM api_keys WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BulkOperationKind is what a bulk operation does to each of its videos.
+type BulkOperationKind string
+
+const (
+	BulkDelete        BulkOperationKind = "delete"
+	BulkSetVisibility BulkOperationKind = "set_visibility"
+	BulkAddTag        BulkOperationKind = "add_tag"
+)
+
+type BulkOperationState string
+
+const (
+	BulkOperationRunning   BulkOperationState = "running"
+	BulkOperationCompleted BulkOperationState = "completed"
+	// BulkOperationFailed operations stopped before reaching every video
+	BulkOperationFailed BulkOperationState = "failed"
+)
+
+// BulkResultStatus is what happened to one video of a bulk operation.
+type BulkResultStatus string
+
+const (
+	BulkResultPending BulkResultStatus = "pending"
+	BulkResultDone    BulkResultStatus = "done"
+	// BulkResultSkipped videos were already as the operation would leave them
+	BulkResultSkipped BulkResultStatus = "skipped"
+	BulkResultFailed  BulkResultStatus = "failed"
+)
+
+type BulkOperation struct {
+	ID          uuid.UUID          `json:"id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UserID      uuid.UUID          `json:"user_id"`
+	Operation   BulkOperationKind  `json:"operation"`
+	Visibility  *Visibility        `json:"visibility,omitempty"`
+	Tag         *string            `json:"tag,omitempty"`
+	State       BulkOperationState `json:"state"`
+	Error       *string            `json:"error"`
+	CompletedAt *time.Time         `json:"completed_at"`
+	// Counts is how many videos have each result status
+	Counts  map[BulkResultStatus]int `json:"counts"`
+	Results []BulkResult             `json:"results"`
+}
+
+type BulkResult struct {
+	VideoID uuid.UUID        `json:"video_id"`
+	Status  BulkResultStatus `json:"status"`
+	Error   *string          `json:"error,omitempty"`
+}
+
+type CreateBulkOperationParams struct {
+	UserID     uuid.UUID
+	Operation  BulkOperationKind
+	Visibility *Visibility
+	Tag        *string
+	VideoIDs   []uuid.UUID
+}
+
+const bulkOperationColumns = `
+		id,
+		created_at,
+		user_id,
+		operation,
+		visibility,
+		tag,
+		state,
+		error,
+		completed_at`
+
+// CreateBulkOperation records a bulk operation with a pending result for each
+// of its videos, in the order given.
+func (c Client) CreateBulkOperation(params CreateBulkOperationParams) (BulkOperation, error) {
+	id := uuid.New()
+	tx, err := c.db.Begin()
+	if err != nil {
+		return BulkOperation{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO bulk_operations (
+		id,
+		created_at,
+		user_id,
+		operation,
+		visibility,
+		tag,
+		state
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, id, params.UserID, params.Operation, params.Visibility, params.Tag, BulkOperationRunning)
+	if err != nil {
+		return BulkOperation{}, err
+	}
+	for position, videoID := range params.VideoIDs {
+		query := `
+		INSERT INTO bulk_operation_results (operation_id, position, video_id, status)
+		VALUES (?, ?, ?, ?)
+		`
+		if _, err := tx.Exec(query, id, position, videoID, BulkResultPending); err != nil {
+			return BulkOperation{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return BulkOperation{}, err
+	}
+
+	return c.GetBulkOperation(id)
+}
+
+// GetBulkOperation returns a bulk operation with the results for its videos
+// so far.
+func (c Client) GetBulkOperation(id uuid.UUID) (BulkOperation, error) {
+	query := `
+	SELECT` + bulkOperationColumns + `
+	FROM bulk_operations
+	WHERE id = ?
+	`
+	var operation BulkOperation
+	err := c.db.QueryRow(query, id).Scan(
+		&operation.ID,
+		&operation.CreatedAt,
+		&operation.UserID,
+		&operation.Operation,
+		&operation.Visibility,
+		&operation.Tag,
+		&operation.State,
+		&operation.Error,
+		&operation.CompletedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BulkOperation{}, nil
+		}
+		return BulkOperation{}, err
+	}
+
+	query = `
+	SELECT video_id, status, error
+	FROM bulk_operation_results
+	WHERE operation_id = ?
+	ORDER BY position
+	`
+	rows, err := c.db.Query(query, id)
+	if err != nil {
+		return BulkOperation{}, err
+	}
+	defer rows.Close()
+
+	operation.Counts = map[BulkResultStatus]int{}
+	operation.Results = []BulkResult{}
+	for rows.Next() {
+		var result BulkResult
+		if err := rows.Scan(&result.VideoID, &result.Status, &result.Error); err != nil {
+			return BulkOperation{}, err
+		}
+		operation.Counts[result.Status]++
+		operation.Results = append(operation.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return BulkOperation{}, err
+	}
+
+	return operation, nil
+}
+
+// SetBulkResult records what happened to one video of a bulk operation.
+func (c Client) SetBulkResult(operationID, videoID uuid.UUID, status BulkResultStatus, message *string) error {
+	query := `
+	UPDATE bulk_operation_results
+	SET status = ?, error = ?
+	WHERE operation_id = ? AND video_id = ?
+	`
+	_, err := c.db.Exec(query, status, message, operationID, videoID)
+	return err
+}
+
+// CompleteBulkOperation marks a bulk operation as finished, with message set
+// if it stopped early, and fails the results it didn't get to.
+func (c Client) CompleteBulkOperation(id uuid.UUID, message *string) error {
+	state := BulkOperationCompleted
+	if message != nil {
+		state = BulkOperationFailed
+	}
+	query := `
+	UPDATE bulk_operation_results
+	SET status = ?, error = ?
+	WHERE operation_id = ? AND status = ?
+	`
+	if _, err := c.db.Exec(query, BulkResultFailed, message, id, BulkResultPending); err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE bulk_operations
+	SET state = ?, error = ?, completed_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, state, message, id)
+	return err
+}
+
+// FailInterruptedBulkOperations marks bulk operations that were still running
+// when the server stopped as failed, along with the videos they hadn't
+// reached yet, so those can be sent again.
+func (c Client) FailInterruptedBulkOperations() error {
+	message := "interrupted by server restart"
+	query := `
+	UPDATE bulk_operation_results
+	SET status = ?, error = ?
+	WHERE status = ? AND operation_id IN (SELECT id FROM bulk_operations WHERE state = ?)
+	`
+	_, err := c.db.Exec(query, BulkResultFailed, message, BulkResultPending, BulkOperationRunning)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE bulk_operations
+	SET state = ?, error = ?, completed_at = CURRENT_TIMESTAMP
+	WHERE state = ?
+	`
+	_, err = c.db.Exec(query, BulkOperationFailed, message, BulkOperationRunning)
+	return err
+}
+
+func (c Client) DeleteUserBulkOperations(userID uuid.UUID) error {
+	query := `
+	DELETE FROM bulk_operation_results
+	WHERE operation_id IN (SELECT id FROM bulk_operations WHERE user_id = ?)
+	`
+	if _, err := c.db.Exec(query, userID); err != nil {
+		return err
+	}
+
+	query = `
+	DELETE FROM bulk_operations
+	WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, userID)
+	return err
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Chapter struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateChapterParams
+}
+
+type CreateChapterParams struct {
+	VideoID      uuid.UUID `json:"video_id"`
+	StartSeconds float64   `json:"start_seconds"`
+	Title        string    `json:"title"`
+}
+
+func (c Client) CreateChapter(params CreateChapterParams) (Chapter, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO chapters (
+		id,
+		created_at,
+		video_id,
+		start_seconds,
+		title
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.StartSeconds, params.Title)
+	if err != nil {
+		return Chapter{}, err
+	}
+
+	return c.GetChapter(id)
+}
+
+func (c Client) GetChapter(id uuid.UUID) (Chapter, error) {
+	query := `
+	SELECT id, created_at, video_id, start_seconds, title
+	FROM chapters
+	WHERE id = ?
+	`
+
+	var chapter Chapter
+	err := c.db.QueryRow(query, id).Scan(
+		&chapter.ID,
+		&chapter.CreatedAt,
+		&chapter.VideoID,
+		&chapter.StartSeconds,
+		&chapter.Title,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Chapter{}, nil
+		}
+		return Chapter{}, err
+	}
+
+	return chapter, nil
+}
+
+func (c Client) GetChapters(videoID uuid.UUID) ([]Chapter, error) {
+	query := `
+	SELECT id, created_at, video_id, start_seconds, title
+	FROM chapters
+	WHERE video_id = ?
+	ORDER BY start_seconds ASC
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		var chapter Chapter
+		if err := rows.Scan(
+			&chapter.ID,
+			&chapter.CreatedAt,
+			&chapter.VideoID,
+			&chapter.StartSeconds,
+			&chapter.Title,
+		); err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	return chapters, nil
+}
+
+func (c Client) DeleteChapter(id uuid.UUID) error {
+	query := `
+	DELETE FROM chapters
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
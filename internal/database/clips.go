@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Clip struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Token     string    `json:"token"`
+	ObjectKey *string   `json:"-"`
+	CreateClipParams
+}
+
+type CreateClipParams struct {
+	VideoID      uuid.UUID  `json:"video_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	StartSeconds float64    `json:"start_seconds"`
+	EndSeconds   float64    `json:"end_seconds"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+const clipColumns = `
+		id,
+		created_at,
+		token,
+		object_key,
+		video_id,
+		user_id,
+		start_seconds,
+		end_seconds,
+		expires_at`
+
+func scanClip(row rowScanner) (Clip, error) {
+	var clip Clip
+	err := row.Scan(
+		&clip.ID,
+		&clip.CreatedAt,
+		&clip.Token,
+		&clip.ObjectKey,
+		&clip.VideoID,
+		&clip.UserID,
+		&clip.StartSeconds,
+		&clip.EndSeconds,
+		&clip.ExpiresAt,
+	)
+	return clip, err
+}
+
+func (c Client) CreateClip(token string, params CreateClipParams) (Clip, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO clips (
+		id,
+		created_at,
+		token,
+		video_id,
+		user_id,
+		start_seconds,
+		end_seconds,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		token,
+		params.VideoID,
+		params.UserID,
+		params.StartSeconds,
+		params.EndSeconds,
+		params.ExpiresAt,
+	)
+	if err != nil {
+		return Clip{}, err
+	}
+
+	return c.getClip("id", id)
+}
+
+func (c Client) GetClip(id uuid.UUID) (Clip, error) {
+	return c.getClip("id", id)
+}
+
+func (c Client) GetClipByToken(token string) (Clip, error) {
+	return c.getClip("token", token)
+}
+
+func (c Client) getClip(column string, value any) (Clip, error) {
+	query := `
+	SELECT` + clipColumns + `
+	FROM clips
+	WHERE ` + column + ` = ?
+	`
+
+	clip, err := scanClip(c.db.QueryRow(query, value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Clip{}, nil
+		}
+		return Clip{}, err
+	}
+
+	return clip, nil
+}
+
+func (c Client) GetClips(videoID uuid.UUID) ([]Clip, error) {
+	query := `
+	SELECT` + clipColumns + `
+	FROM clips
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clips := []Clip{}
+	for rows.Next() {
+		clip, err := scanClip(rows)
+		if err != nil {
+			return nil, err
+		}
+		clips = append(clips, clip)
+	}
+
+	return clips, nil
+}
+
+func (c Client) SetClipObjectKey(id uuid.UUID, objectKey string) error {
+	query := `
+	UPDATE clips
+	SET object_key = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, objectKey, id)
+	return err
+}
+
+func (c Client) DeleteClip(id uuid.UUID) error {
+	query := `
+	DELETE FROM clips
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
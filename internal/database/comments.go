@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Comment struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set once the comment has been removed; its body is
+	// erased, but it stays in its thread while it has replies.
+	DeletedAt *time.Time `json:"deleted_at"`
+	// ReplyCount counts the comment's direct replies.
+	ReplyCount int `json:"reply_count"`
+	CreateCommentParams
+}
+
+type CreateCommentParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	// ParentID is the comment this one replies to, if any.
+	ParentID uuid.NullUUID `json:"parent_id"`
+	Body     string        `json:"body"`
+}
+
+const commentColumns = `
+	c.id, c.created_at, c.updated_at, c.deleted_at, c.video_id, c.user_id, c.parent_id, c.body,
+	(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS reply_count
+`
+
+func scanComment(row interface{ Scan(...any) error }) (Comment, error) {
+	var comment Comment
+	err := row.Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.DeletedAt,
+		&comment.VideoID,
+		&comment.UserID,
+		&comment.ParentID,
+		&comment.Body,
+		&comment.ReplyCount,
+	)
+	return comment, err
+}
+
+func (c Client) CreateComment(params CreateCommentParams) (Comment, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO comments (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		parent_id,
+		body
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.UserID, params.ParentID, params.Body)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	return c.GetComment(id)
+}
+
+func (c Client) GetComment(id uuid.UUID) (Comment, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments c
+	WHERE c.id = ?
+	`
+
+	comment, err := scanComment(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, nil
+		}
+		return Comment{}, err
+	}
+
+	return comment, nil
+}
+
+// GetComments returns up to limit comments on a video replying to parentID,
+// or its top-level comments when parentID is null, oldest first. Paging
+// continues after the comment with ID after, when it's set. Deleted comments
+// are only returned while they have replies.
+func (c Client) GetComments(videoID uuid.UUID, parentID, after uuid.NullUUID, limit int) ([]Comment, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments c
+	WHERE c.video_id = ?
+		AND c.parent_id IS ?
+		AND (c.deleted_at IS NULL OR EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id))
+	`
+	args := []any{videoID, parentID}
+	if after.Valid {
+		query += `
+		AND (c.created_at, c.rowid) > (SELECT created_at, rowid FROM comments WHERE id = ?)
+		`
+		args = append(args, after)
+	}
+	query += `
+	ORDER BY c.created_at ASC, c.rowid ASC
+	LIMIT ?
+	`
+	args = append(args, limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// CountComments counts the comments GetComments pages through
+func (c Client) CountComments(videoID uuid.UUID, parentID uuid.NullUUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM comments c
+	WHERE c.video_id = ?
+		AND c.parent_id IS ?
+		AND (c.deleted_at IS NULL OR EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id))
+	`
+	var count int
+	err := c.db.QueryRow(query, videoID, parentID).Scan(&count)
+	return count, err
+}
+
+func (c Client) UpdateComment(id uuid.UUID, body string) error {
+	query := `
+	UPDATE comments
+	SET body = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, body, id)
+	return err
+}
+
+// DeleteComment erases a comment's body and marks it deleted, keeping its
+// place in the thread for any replies.
+func (c Client) DeleteComment(id uuid.UUID) error {
+	query := `
+	UPDATE comments
+	SET body = '', deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// DeleteUserComments deletes every comment a user wrote, on any video.
+func (c Client) DeleteUserComments(userID uuid.UUID) error {
+	query := `
+	UPDATE comments
+	SET body = '', deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID)
+	return err
+}
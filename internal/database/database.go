@@ -55,6 +55,79 @@ func (c *Client) autoMigrate() error {
 		return err
 	}
 
+	tenantTable := `
+	CREATE TABLE IF NOT EXISTS tenants (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT UNIQUE NOT NULL,
+		bucket TEXT,
+		base_url TEXT,
+		key_prefix TEXT NOT NULL DEFAULT '',
+		max_videos INTEGER
+	);
+	`
+	_, err = c.db.Exec(tenantTable)
+	if err != nil {
+		return err
+	}
+
+	planTable := `
+	CREATE TABLE IF NOT EXISTS plans (
+		name TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		upload_limit INTEGER,
+		storage_quota INTEGER,
+		max_video_duration INTEGER,
+		profiles TEXT,
+		max_link_expiry INTEGER
+	);
+	`
+	_, err = c.db.Exec(planTable)
+	if err != nil {
+		return err
+	}
+
+	stripeCustomerTable := `
+	CREATE TABLE IF NOT EXISTS stripe_customers (
+		customer_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		subscription_id TEXT NOT NULL DEFAULT '',
+		last_event_at TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(stripeCustomerTable)
+	if err != nil {
+		return err
+	}
+
+	stripeEventTable := `
+	CREATE TABLE IF NOT EXISTS stripe_events (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		type TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(stripeEventTable)
+	if err != nil {
+		return err
+	}
+
+	userColumns := []struct{ name, definition string }{
+		{"deleted_at", "TIMESTAMP"},
+		{"tenant_id", "TEXT REFERENCES tenants(id)"},
+		{"avatar_url", "TEXT"},
+		{"plan", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, col := range userColumns {
+		if err := c.addColumnIfNotExists("users", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
 		id TEXT PRIMARY KEY,
@@ -72,18 +145,666 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
-	return nil
+
+	videoColumns := []struct{ name, definition string }{
+		{"thumbnail_blurhash", "TEXT"},
+		{"thumbnail_dominant_color", "TEXT"},
+		{"thumbnail_phash", "TEXT"},
+		{"video_fingerprint", "TEXT"},
+		{"duration", "REAL"},
+		{"source_sha256", "TEXT"},
+		{"tenant_id", "TEXT"},
+		{"processing_profile", "TEXT"},
+		{"embed_origins", "TEXT"},
+		{"access_rules", "TEXT"},
+		{"thumbnail_variants", "TEXT"},
+		{"revision", "INTEGER NOT NULL DEFAULT 0"},
+		{"original_filename", "TEXT"},
+		{"upload_size", "INTEGER"},
+		{"uploaded_at", "TIMESTAMP"},
+		{"uploader_ip", "TEXT"},
+		{"uploader_user_agent", "TEXT"},
+		{"metadata", "TEXT"},
+		{"external_id", "TEXT"},
+		{"visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"tags", "TEXT"},
+		// Filled in for videos from before it by backfillVideoStates
+		{"state", "TEXT"},
+	}
+	for _, col := range videoColumns {
+		if err := c.addColumnIfNotExists("videos", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	_, err = c.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS videos_user_external_id ON videos(user_id, external_id) WHERE external_id IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+
+	chapterTable := `
+	CREATE TABLE IF NOT EXISTS chapters (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		start_seconds REAL NOT NULL,
+		title TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(chapterTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailTable := `
+	CREATE TABLE IF NOT EXISTS thumbnails (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		superseded_at TIMESTAMP,
+		video_id TEXT NOT NULL,
+		asset_path TEXT NOT NULL,
+		bytes INTEGER NOT NULL,
+		blurhash TEXT,
+		dominant_color TEXT,
+		phash TEXT,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(thumbnailTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailColumns := []struct{ name, definition string }{
+		{"variants", "TEXT"},
+	}
+	for _, col := range thumbnailColumns {
+		if err := c.addColumnIfNotExists("thumbnails", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		state TEXT NOT NULL,
+		checkpoint TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		source_path TEXT NOT NULL,
+		processed_path TEXT,
+		object_key TEXT,
+		duration REAL NOT NULL,
+		fingerprint TEXT,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	probeCacheTable := `
+	CREATE TABLE IF NOT EXISTS probe_cache (
+		sha256 TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		probe_json TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(probeCacheTable)
+	if err != nil {
+		return err
+	}
+
+	objectProbeTable := `
+	CREATE TABLE IF NOT EXISTS object_probes (
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		etag TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		probe_json TEXT NOT NULL,
+		PRIMARY KEY(bucket, key)
+	);
+	`
+	_, err = c.db.Exec(objectProbeTable)
+	if err != nil {
+		return err
+	}
+
+	clipTable := `
+	CREATE TABLE IF NOT EXISTS clips (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		token TEXT UNIQUE NOT NULL,
+		object_key TEXT,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		start_seconds REAL NOT NULL,
+		end_seconds REAL NOT NULL,
+		expires_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(clipTable)
+	if err != nil {
+		return err
+	}
+
+	shareLinkTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		token TEXT UNIQUE NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		max_views INTEGER,
+		views INTEGER NOT NULL DEFAULT 0,
+		last_viewed_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(shareLinkTable)
+	if err != nil {
+		return err
+	}
+
+	shareColumns := []struct{ name, definition string }{
+		{"password_hash", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, col := range shareColumns {
+		if err := c.addColumnIfNotExists("share_links", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	playbackLinkTable := `
+	CREATE TABLE IF NOT EXISTS playback_links (
+		nonce TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		bucket TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		fragment TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(playbackLinkTable)
+	if err != nil {
+		return err
+	}
+
+	commentTable := `
+	CREATE TABLE IF NOT EXISTS comments (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		parent_id TEXT,
+		body TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(parent_id) REFERENCES comments(id)
+	);
+	CREATE INDEX IF NOT EXISTS comments_thread ON comments(video_id, parent_id, created_at);
+	`
+	_, err = c.db.Exec(commentTable)
+	if err != nil {
+		return err
+	}
+
+	// Likes and watch-later lists share a shape, one row per user and video
+	for _, table := range []string{likesTable, watchLaterTable} {
+		listTable := `
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
+			user_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY(user_id, video_id),
+			FOREIGN KEY(video_id) REFERENCES videos(id)
+		);
+		CREATE INDEX IF NOT EXISTS ` + table + `_video ON ` + table + `(video_id);
+		`
+		_, err = c.db.Exec(listTable)
+		if err != nil {
+			return err
+		}
+	}
+
+	exportTable := `
+	CREATE TABLE IF NOT EXISTS exports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		state TEXT NOT NULL,
+		include_media_urls BOOLEAN NOT NULL DEFAULT FALSE,
+		object_key TEXT,
+		error TEXT,
+		completed_at TIMESTAMP,
+		downloaded_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(exportTable)
+	if err != nil {
+		return err
+	}
+
+	// Results outlive the videos they're about, so a report still shows
+	// which videos a bulk delete removed
+	bulkOperationTables := `
+	CREATE TABLE IF NOT EXISTS bulk_operations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		visibility TEXT,
+		tag TEXT,
+		state TEXT NOT NULL,
+		error TEXT,
+		completed_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS bulk_operation_results (
+		operation_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		video_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT,
+		PRIMARY KEY(operation_id, position),
+		FOREIGN KEY(operation_id) REFERENCES bulk_operations(id)
+	);
+	CREATE INDEX IF NOT EXISTS bulk_operations_user ON bulk_operations(user_id);
+	`
+	_, err = c.db.Exec(bulkOperationTables)
+	if err != nil {
+		return err
+	}
+
+	// Dead letters outlive their videos, so the failure can still be looked
+	// into after the owner gave up and deleted the video
+	deadLetterTable := `
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		job_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		profile TEXT NOT NULL,
+		priority TEXT NOT NULL,
+		error TEXT NOT NULL,
+		stderr TEXT,
+		probe_json TEXT,
+		trace TEXT NOT NULL,
+		source_key TEXT,
+		retried_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS dead_letters_user ON dead_letters(user_id);
+	`
+	_, err = c.db.Exec(deadLetterTable)
+	if err != nil {
+		return err
+	}
+
+	// S3 gives each multipart upload its own ID, so that's the key
+	multipartUploadTables := `
+	CREATE TABLE IF NOT EXISTS multipart_uploads (
+		upload_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		size INTEGER,
+		part_size INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS multipart_uploads_key ON multipart_uploads(bucket, key);
+	CREATE TABLE IF NOT EXISTS multipart_upload_parts (
+		upload_id TEXT NOT NULL,
+		part_number INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY(upload_id, part_number),
+		FOREIGN KEY(upload_id) REFERENCES multipart_uploads(upload_id)
+	);
+	`
+	_, err = c.db.Exec(multipartUploadTables)
+	if err != nil {
+		return err
+	}
+
+	storageObjectTable := `
+	CREATE TABLE IF NOT EXISTS storage_objects (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		video_id TEXT,
+		kind TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		bytes INTEGER NOT NULL,
+		UNIQUE(bucket, key)
+	);
+	`
+	_, err = c.db.Exec(storageObjectTable)
+	if err != nil {
+		return err
+	}
+
+	storageObjectColumns := []struct{ name, definition string }{
+		{"sha256", "TEXT"},
+		{"fixity_checked_at", "TIMESTAMP"},
+	}
+	for _, col := range storageObjectColumns {
+		if err := c.addColumnIfNotExists("storage_objects", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	fixityCheckTable := `
+	CREATE TABLE IF NOT EXISTS fixity_checks (
+		id TEXT PRIMARY KEY,
+		checked_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		video_id TEXT,
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		method TEXT NOT NULL,
+		status TEXT NOT NULL,
+		expected_sha256 TEXT,
+		actual_sha256 TEXT,
+		error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS fixity_checks_status ON fixity_checks(status, checked_at);
+	`
+	_, err = c.db.Exec(fixityCheckTable)
+	if err != nil {
+		return err
+	}
+
+	userBucketTable := `
+	CREATE TABLE IF NOT EXISTS user_buckets (
+		user_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		bucket TEXT UNIQUE NOT NULL,
+		region TEXT NOT NULL,
+		role_arn TEXT NOT NULL,
+		base_url TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userBucketTable)
+	if err != nil {
+		return err
+	}
+
+	notificationSettingsTable := `
+	CREATE TABLE IF NOT EXISTS notification_settings (
+		user_id TEXT PRIMARY KEY,
+		updated_at TIMESTAMP NOT NULL,
+		email BOOLEAN NOT NULL DEFAULT FALSE,
+		slack_webhook_url TEXT NOT NULL DEFAULT '',
+		discord_webhook_url TEXT NOT NULL DEFAULT '',
+		on_success BOOLEAN NOT NULL DEFAULT TRUE,
+		on_failure BOOLEAN NOT NULL DEFAULT TRUE,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(notificationSettingsTable)
+	if err != nil {
+		return err
+	}
+
+	jobColumns := []struct{ name, definition string }{
+		{"source_key", "TEXT"},
+		{"worker_id", "TEXT"},
+		{"heartbeat_at", "TIMESTAMP"},
+		{"external_id", "TEXT"},
+		{"source_sha256", "TEXT"},
+		{"profile", "TEXT"},
+		{"retry_at", "TIMESTAMP"},
+		{"notify", "TEXT NOT NULL DEFAULT ''"},
+		{"priority", "TEXT NOT NULL DEFAULT 'normal'"},
+		{"claimed_at", "TIMESTAMP"},
+		{"trace", "TEXT"},
+	}
+	for _, col := range jobColumns {
+		if err := c.addColumnIfNotExists("jobs", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	uploadSessionTable := `
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		profile TEXT NOT NULL DEFAULT '',
+		size INTEGER,
+		received INTEGER NOT NULL DEFAULT 0,
+		temp_path TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS upload_sessions_expires ON upload_sessions(expires_at);
+	`
+	_, err = c.db.Exec(uploadSessionTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_user ON api_keys(user_id);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	hlsKeyTable := `
+	CREATE TABLE IF NOT EXISTS hls_keys (
+		video_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		key BLOB NOT NULL
+	);
+	`
+	_, err = c.db.Exec(hlsKeyTable)
+	if err != nil {
+		return err
+	}
+
+	videoArchiveTable := `
+	CREATE TABLE IF NOT EXISTS video_archives (
+		video_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		bucket TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		storage_class TEXT NOT NULL,
+		state TEXT NOT NULL,
+		restore_tier TEXT,
+		restore_requested_at TIMESTAMP,
+		restored_until TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_archives_object ON video_archives(bucket, object_key);
+	CREATE INDEX IF NOT EXISTS video_archives_state ON video_archives(state);
+	`
+	_, err = c.db.Exec(videoArchiveTable)
+	if err != nil {
+		return err
+	}
+
+	uploadDefaultsTable := `
+	CREATE TABLE IF NOT EXISTS upload_defaults (
+		user_id TEXT PRIMARY KEY,
+		updated_at TIMESTAMP NOT NULL,
+		profile TEXT,
+		notify TEXT
+	);
+	`
+	_, err = c.db.Exec(uploadDefaultsTable)
+	if err != nil {
+		return err
+	}
+
+	uploadSessionColumns := []struct{ name, definition string }{
+		{"notify", "TEXT NOT NULL DEFAULT ''"},
+		{"original_filename", "TEXT NOT NULL DEFAULT ''"},
+		{"priority", "TEXT NOT NULL DEFAULT 'interactive'"},
+	}
+	for _, col := range uploadSessionColumns {
+		if err := c.addColumnIfNotExists("upload_sessions", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	return c.backfillVideoStates()
 }
 
-func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
-		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
+// backfillVideoStates works out the state of videos from before states were
+// recorded, from what used to stand for them: a stored file, an archive of
+// it, the latest job and an open upload session.
+func (c *Client) backfillVideoStates() error {
+	query := `
+	UPDATE videos
+	SET state = CASE
+		WHEN video_url IS NOT NULL AND EXISTS (
+			SELECT 1 FROM video_archives
+			WHERE video_archives.video_id = videos.id AND videos.video_url LIKE '%/' || video_archives.object_key
+		) THEN ?
+		WHEN video_url IS NOT NULL THEN ?
+		WHEN (
+			SELECT jobs.state FROM jobs WHERE jobs.video_id = videos.id ORDER BY jobs.created_at DESC, jobs.rowid DESC LIMIT 1
+		) IN (?, ?, ?) THEN ?
+		WHEN (
+			SELECT jobs.state FROM jobs WHERE jobs.video_id = videos.id ORDER BY jobs.created_at DESC, jobs.rowid DESC LIMIT 1
+		) = ? THEN ?
+		WHEN EXISTS (SELECT 1 FROM upload_sessions WHERE upload_sessions.video_id = videos.id) THEN ?
+		ELSE ?
+	END
+	WHERE state IS NULL
+	`
+	_, err := c.db.Exec(query,
+		VideoStateArchived,
+		VideoStateReady,
+		JobStateQueued, JobStateRunning, JobStateWaiting, VideoStateProcessing,
+		JobStateFailed, VideoStateFailed,
+		VideoStateUploading,
+		VideoStateDraft,
+	)
+	return err
+}
+
+func (c *Client) addColumnIfNotExists(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
-		return fmt.Errorf("failed to reset table videos: %w", err)
+	return nil
+}
+
+// Tables Reset empties, in the order it empties them
+var resetTables = []string{
+	"refresh_tokens",
+	"stripe_customers",
+	"stripe_events",
+	"users",
+	"storage_objects",
+	"multipart_upload_parts",
+	"multipart_uploads",
+	"fixity_checks",
+	"user_buckets",
+	"notification_settings",
+	"upload_defaults",
+	"upload_sessions",
+	"api_keys",
+	"hls_keys",
+	"exports",
+	"bulk_operation_results",
+	"bulk_operations",
+	"probe_cache",
+	"object_probes",
+	"dead_letters",
+	"jobs",
+	"clips",
+	"share_links",
+	"playback_links",
+	"video_likes",
+	"watch_later",
+	"comments",
+	"chapters",
+	"thumbnails",
+	"video_archives",
+	"videos",
+	"tenants",
+	"plans",
+}
+
+func (c Client) Reset() error {
+	for _, table := range resetTables {
+		if _, err := c.db.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to reset table %s: %w", table, err)
+		}
 	}
 	return nil
 }
+
+// CountResetRecords returns how many records each table Reset empties has.
+func (c Client) CountResetRecords() (map[string]int, error) {
+	counts := map[string]int{}
+	for _, table := range resetTables {
+		var count int
+		if err := c.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			return nil, err
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
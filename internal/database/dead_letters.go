@@ -0,0 +1,296 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter is what was known about a job when it failed for good, kept so
+// the failure can be looked into and the job retried once it's understood.
+type DeadLetter struct {
+	ID        uuid.UUID   `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	JobID     uuid.UUID   `json:"job_id"`
+	VideoID   uuid.UUID   `json:"video_id"`
+	UserID    uuid.UUID   `json:"user_id"`
+	Attempts  int         `json:"attempts"`
+	Profile   string      `json:"profile"`
+	Priority  JobPriority `json:"priority"`
+	Error     string      `json:"error"`
+	// Stderr is what ffmpeg wrote before it failed, if the job failed there
+	Stderr *string `json:"stderr"`
+	// Probe is the ffprobe JSON of the job's source, if it could be probed
+	Probe json.RawMessage `json:"probe"`
+	Trace []JobTraceEntry `json:"trace"`
+	// SourceKey is the staging object the job's source is kept in, so it
+	// can be retried after its scratch files are gone
+	SourceKey *string    `json:"source_key"`
+	RetriedAt *time.Time `json:"retried_at"`
+}
+
+type CreateDeadLetterParams struct {
+	Job       Job
+	Error     string
+	Stderr    *string
+	Probe     json.RawMessage
+	SourceKey *string
+}
+
+const deadLetterColumns = `
+		id,
+		created_at,
+		job_id,
+		video_id,
+		user_id,
+		attempts,
+		profile,
+		priority,
+		error,
+		stderr,
+		probe_json,
+		trace,
+		source_key,
+		retried_at`
+
+func scanDeadLetter(row rowScanner) (DeadLetter, error) {
+	var deadLetter DeadLetter
+	var probe sql.NullString
+	var trace string
+	err := row.Scan(
+		&deadLetter.ID,
+		&deadLetter.CreatedAt,
+		&deadLetter.JobID,
+		&deadLetter.VideoID,
+		&deadLetter.UserID,
+		&deadLetter.Attempts,
+		&deadLetter.Profile,
+		&deadLetter.Priority,
+		&deadLetter.Error,
+		&deadLetter.Stderr,
+		&probe,
+		&trace,
+		&deadLetter.SourceKey,
+		&deadLetter.RetriedAt,
+	)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	if probe.Valid {
+		deadLetter.Probe = json.RawMessage(probe.String)
+	}
+	deadLetter.Trace = []JobTraceEntry{}
+	if err := json.Unmarshal([]byte(trace), &deadLetter.Trace); err != nil {
+		return DeadLetter{}, err
+	}
+	return deadLetter, nil
+}
+
+func (c Client) CreateDeadLetter(params CreateDeadLetterParams) (DeadLetter, error) {
+	trace := params.Job.Trace
+	if trace == nil {
+		trace = []JobTraceEntry{}
+	}
+	traceJSON, err := json.Marshal(trace)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	var probe *string
+	if params.Probe != nil {
+		s := string(params.Probe)
+		probe = &s
+	}
+
+	id := uuid.New()
+	query := `
+	INSERT INTO dead_letters (
+		id,
+		created_at,
+		job_id,
+		video_id,
+		user_id,
+		attempts,
+		profile,
+		priority,
+		error,
+		stderr,
+		probe_json,
+		trace,
+		source_key
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = c.db.Exec(
+		query,
+		id,
+		params.Job.ID,
+		params.Job.VideoID,
+		params.Job.UserID,
+		params.Job.Attempts,
+		params.Job.Profile,
+		params.Job.Priority,
+		params.Error,
+		params.Stderr,
+		probe,
+		string(traceJSON),
+		params.SourceKey,
+	)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	return c.GetDeadLetter(id)
+}
+
+func (c Client) GetDeadLetter(id uuid.UUID) (DeadLetter, error) {
+	query := `
+	SELECT` + deadLetterColumns + `
+	FROM dead_letters
+	WHERE id = ?
+	`
+
+	deadLetter, err := scanDeadLetter(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DeadLetter{}, nil
+		}
+		return DeadLetter{}, err
+	}
+
+	return deadLetter, nil
+}
+
+// DeadLetterFilter narrows the dead letters GetDeadLetters lists.
+type DeadLetterFilter struct {
+	UserID uuid.NullUUID
+	// Retried, if set, lists only dead letters that have or haven't been
+	// retried
+	Retried *bool
+}
+
+func (f DeadLetterFilter) where() (string, []any) {
+	where := "1 = 1"
+	args := []any{}
+	if f.UserID.Valid {
+		where += " AND d.user_id = ?"
+		args = append(args, f.UserID)
+	}
+	if f.Retried != nil {
+		if *f.Retried {
+			where += " AND d.retried_at IS NOT NULL"
+		} else {
+			where += " AND d.retried_at IS NULL"
+		}
+	}
+	return where, args
+}
+
+// GetDeadLetters returns up to limit dead letters matching filter, newest
+// first, starting after the dead letter before if set.
+func (c Client) GetDeadLetters(filter DeadLetterFilter, before uuid.NullUUID, limit int) ([]DeadLetter, error) {
+	where, args := filter.where()
+	query := `
+	SELECT` + deadLetterColumns + `
+	FROM dead_letters d
+	WHERE ` + where
+	if before.Valid {
+		query += `
+		AND (d.created_at, d.rowid) < (SELECT created_at, rowid FROM dead_letters WHERE id = ?)
+		`
+		args = append(args, before)
+	}
+	query += `
+	ORDER BY d.created_at DESC, d.rowid DESC
+	LIMIT ?
+	`
+	args = append(args, limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadLetters := []DeadLetter{}
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+// CountDeadLetters counts the dead letters GetDeadLetters pages through
+func (c Client) CountDeadLetters(filter DeadLetterFilter) (int, error) {
+	where, args := filter.where()
+	var count int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM dead_letters d WHERE `+where, args...).Scan(&count)
+	return count, err
+}
+
+// MarkDeadLetterRetried records that a dead letter's job was put back on
+// the queue. It reports false, changing nothing, if it already was.
+func (c Client) MarkDeadLetterRetried(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE dead_letters
+	SET retried_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND retried_at IS NULL
+	`
+	result, err := c.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	marked, err := result.RowsAffected()
+	return marked > 0, err
+}
+
+// ClearDeadLetterRetried undoes MarkDeadLetterRetried when the job couldn't
+// be put back on the queue after all.
+func (c Client) ClearDeadLetterRetried(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE dead_letters SET retried_at = NULL WHERE id = ?`, id)
+	return err
+}
+
+func (c Client) DeleteDeadLetter(id uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM dead_letters WHERE id = ?`, id)
+	return err
+}
+
+// GetUserDeadLetterSourceKeys returns the staging objects kept for a user's
+// dead letters that haven't been retried, whose jobs no longer own them.
+func (c Client) GetUserDeadLetterSourceKeys(userID uuid.UUID) ([]string, error) {
+	query := `
+	SELECT source_key
+	FROM dead_letters
+	WHERE user_id = ? AND source_key IS NOT NULL AND retried_at IS NULL
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) DeleteUserDeadLetters(userID uuid.UUID) error {
+	query := `
+	DELETE FROM dead_letters
+	WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, userID)
+	return err
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ExportState string
+
+const (
+	ExportStatePending ExportState = "pending"
+	ExportStateReady   ExportState = "ready"
+	ExportStateFailed  ExportState = "failed"
+)
+
+type Export struct {
+	ID               uuid.UUID   `json:"id"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UserID           uuid.UUID   `json:"user_id"`
+	State            ExportState `json:"state"`
+	IncludeMediaURLs bool        `json:"include_media_urls"`
+	ObjectKey        *string     `json:"-"`
+	Error            *string     `json:"error"`
+	CompletedAt      *time.Time  `json:"completed_at"`
+	DownloadedAt     *time.Time  `json:"downloaded_at"`
+}
+
+const exportColumns = `
+		id,
+		created_at,
+		user_id,
+		state,
+		include_media_urls,
+		object_key,
+		error,
+		completed_at,
+		downloaded_at`
+
+func scanExport(row rowScanner) (Export, error) {
+	var export Export
+	err := row.Scan(
+		&export.ID,
+		&export.CreatedAt,
+		&export.UserID,
+		&export.State,
+		&export.IncludeMediaURLs,
+		&export.ObjectKey,
+		&export.Error,
+		&export.CompletedAt,
+		&export.DownloadedAt,
+	)
+	return export, err
+}
+
+func (c Client) CreateExport(userID uuid.UUID, includeMediaURLs bool) (Export, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO exports (
+		id,
+		created_at,
+		user_id,
+		state,
+		include_media_urls
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, userID, ExportStatePending, includeMediaURLs)
+	if err != nil {
+		return Export{}, err
+	}
+
+	return c.GetExport(id)
+}
+
+func (c Client) GetExport(id uuid.UUID) (Export, error) {
+	query := `
+	SELECT` + exportColumns + `
+	FROM exports
+	WHERE id = ?
+	`
+
+	export, err := scanExport(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Export{}, nil
+		}
+		return Export{}, err
+	}
+
+	return export, nil
+}
+
+func (c Client) CompleteExport(id uuid.UUID, objectKey string) error {
+	query := `
+	UPDATE exports
+	SET state = ?, object_key = ?, completed_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, ExportStateReady, objectKey, id)
+	return err
+}
+
+func (c Client) FailExport(id uuid.UUID, message string) error {
+	query := `
+	UPDATE exports
+	SET state = ?, error = ?, completed_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, ExportStateFailed, message, id)
+	return err
+}
+
+// FailInterruptedExports marks exports that were still being built when the
+// server stopped as failed, so they can be requested again.
+func (c Client) FailInterruptedExports() error {
+	query := `
+	UPDATE exports
+	SET state = ?, error = ?, completed_at = CURRENT_TIMESTAMP
+	WHERE state = ?
+	`
+	_, err := c.db.Exec(query, ExportStateFailed, "interrupted by server restart", ExportStatePending)
+	return err
+}
+
+// ClaimExportDownload marks a ready export as downloaded, returning false if
+// it had already been downloaded. Download links are single use.
+func (c Client) ClaimExportDownload(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE exports
+	SET downloaded_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND state = ? AND downloaded_at IS NULL
+	`
+	result, err := c.db.Exec(query, id, ExportStateReady)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	return claimed == 1, err
+}
+
+func (c Client) DeleteUserExports(userID uuid.UUID) error {
+	query := `
+	DELETE FROM exports
+	WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, userID)
+	return err
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outcomes of a fixity check
+const (
+	// FixityStatusOK means the object still has the checksum it was stored with
+	FixityStatusOK = "ok"
+	// FixityStatusBaseline means the object had no checksum yet, so the one
+	// computed became the one later checks compare against
+	FixityStatusBaseline = "baseline"
+	FixityStatusMismatch = "mismatch"
+	FixityStatusMissing  = "missing"
+	FixityStatusError    = "error"
+)
+
+// Ways an object's checksum can be verified
+const (
+	// FixityMethodChecksum compares the checksum S3 keeps for the object
+	FixityMethodChecksum = "checksum"
+	// FixityMethodDownload reads the whole object and hashes it
+	FixityMethodDownload = "download"
+)
+
+// FixityCheck is the outcome of verifying one stored object's contents.
+type FixityCheck struct {
+	ID             uuid.UUID     `json:"id"`
+	CheckedAt      time.Time     `json:"checked_at"`
+	UserID         uuid.UUID     `json:"user_id"`
+	VideoID        uuid.NullUUID `json:"video_id"`
+	Bucket         string        `json:"bucket"`
+	Key            string        `json:"key"`
+	Method         string        `json:"method"`
+	Status         string        `json:"status"`
+	ExpectedSHA256 *string       `json:"expected_sha256"`
+	ActualSHA256   *string       `json:"actual_sha256"`
+	Error          *string       `json:"error"`
+}
+
+// GetFixitySample returns up to limit live objects in buckets, the ones
+// verified longest ago first and never verified ones before those.
+func (c Client) GetFixitySample(limit int) ([]StoredObject, error) {
+	query := `
+	SELECT` + storedObjectColumns + `
+	FROM storage_objects
+	WHERE deleted_at IS NULL AND bucket != ''
+	ORDER BY fixity_checked_at IS NOT NULL, fixity_checked_at ASC, created_at ASC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []StoredObject{}
+	for rows.Next() {
+		object, err := scanStoredObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, rows.Err()
+}
+
+// RecordFixityCheck saves the outcome of a check and, unless the check
+// failed to run, marks the object as verified. A baseline check also saves
+// the checksum it computed.
+func (c Client) RecordFixityCheck(check FixityCheck) (FixityCheck, error) {
+	check.ID = uuid.New()
+	_, err := c.db.Exec(`
+	INSERT INTO fixity_checks (
+		id,
+		checked_at,
+		user_id,
+		video_id,
+		bucket,
+		key,
+		method,
+		status,
+		expected_sha256,
+		actual_sha256,
+		error
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		check.ID,
+		check.CheckedAt,
+		check.UserID,
+		check.VideoID,
+		check.Bucket,
+		check.Key,
+		check.Method,
+		check.Status,
+		check.ExpectedSHA256,
+		check.ActualSHA256,
+		check.Error,
+	)
+	if err != nil {
+		return FixityCheck{}, err
+	}
+
+	// A check that couldn't run verified nothing, so the object stays near the front of the queue
+	if check.Status == FixityStatusError {
+		return check, nil
+	}
+	_, err = c.db.Exec(`
+	UPDATE storage_objects
+	SET fixity_checked_at = ?,
+		sha256 = CASE WHEN ? THEN ? ELSE sha256 END
+	WHERE bucket = ? AND key = ? AND deleted_at IS NULL
+	`, check.CheckedAt, check.Status == FixityStatusBaseline, check.ActualSHA256, check.Bucket, check.Key)
+	if err != nil {
+		return FixityCheck{}, err
+	}
+
+	return check, nil
+}
+
+// GetFixityChecks returns the most recent checks, newest first, only those
+// with one of the given statuses unless none are given.
+func (c Client) GetFixityChecks(statuses []string, limit int) ([]FixityCheck, error) {
+	query := `
+	SELECT id, checked_at, user_id, video_id, bucket, key, method, status, expected_sha256, actual_sha256, error
+	FROM fixity_checks
+	`
+	args := []any{}
+	if len(statuses) > 0 {
+		query += " WHERE status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	query += " ORDER BY checked_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checks := []FixityCheck{}
+	for rows.Next() {
+		var check FixityCheck
+		if err := rows.Scan(
+			&check.ID,
+			&check.CheckedAt,
+			&check.UserID,
+			&check.VideoID,
+			&check.Bucket,
+			&check.Key,
+			&check.Method,
+			&check.Status,
+			&check.ExpectedSHA256,
+			&check.ActualSHA256,
+			&check.Error,
+		); err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, rows.Err()
+}
+
+// CountFixityChecks returns how many checks have had each status.
+func (c Client) CountFixityChecks() (map[string]int, error) {
+	rows, err := c.db.Query("SELECT status, COUNT(*) FROM fixity_checks GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
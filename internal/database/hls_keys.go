@@ -0,0 +1,43 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// GetHLSKey returns the AES-128 key a video's HLS segments are encrypted
+// with, or nil if the video has none.
+func (c Client) GetHLSKey(videoID uuid.UUID) ([]byte, error) {
+	query := `
+	SELECT key
+	FROM hls_keys
+	WHERE video_id = ?
+	`
+
+	var key []byte
+	err := c.db.QueryRow(query, videoID).Scan(&key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// CreateHLSKey stores the key a video's HLS segments are encrypted with,
+// unless the video already has one, and returns the key the video ends up
+// with.
+func (c Client) CreateHLSKey(videoID uuid.UUID, key []byte) ([]byte, error) {
+	query := `
+	INSERT INTO hls_keys (video_id, created_at, key)
+	VALUES (?, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT(video_id) DO NOTHING
+	`
+	if _, err := c.db.Exec(query, videoID, key); err != nil {
+		return nil, err
+	}
+	return c.GetHLSKey(videoID)
+}
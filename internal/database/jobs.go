@@ -0,0 +1,689 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobState string
+
+const (
+	JobStateQueued    JobState = "queued"
+	JobStateRunning   JobState = "running"
+	JobStateSucceeded JobState = "succeeded"
+	JobStateFailed    JobState = "failed"
+	// JobStateWaiting is a job handed to an external transcoder that will
+	// report back through a callback rather than being polled
+	JobStateWaiting JobState = "waiting"
+	// JobStateCancelled is a job its owner stopped before it finished
+	JobStateCancelled JobState = "cancelled"
+)
+
+// ErrJobCancelled is returned when updating a job that has been cancelled,
+// which nothing may move out of that state.
+var ErrJobCancelled = errors.New("job was cancelled")
+
+// JobPriority is the class of work a job belongs to. Workers share their
+// time between the classes by weight, so a backlog of bulk jobs can't hold
+// up uploads someone is waiting on.
+type JobPriority string
+
+const (
+	// JobPriorityInteractive is for uploads a user is waiting on
+	JobPriorityInteractive JobPriority = "interactive"
+	JobPriorityNormal      JobPriority = "normal"
+	// JobPriorityBulk is for backfills and migrations, which can wait
+	JobPriorityBulk JobPriority = "bulk"
+)
+
+// JobPriorities lists the priorities, highest first.
+var JobPriorities = []JobPriority{JobPriorityInteractive, JobPriorityNormal, JobPriorityBulk}
+
+func (p JobPriority) Valid() bool {
+	switch p {
+	case JobPriorityInteractive, JobPriorityNormal, JobPriorityBulk:
+		return true
+	}
+	return false
+}
+
+// JobCheckpoint records the last processing stage a job completed, so an
+// interrupted job can pick up where it left off.
+type JobCheckpoint string
+
+const (
+	JobCheckpointReceived  JobCheckpoint = "received"
+	JobCheckpointProcessed JobCheckpoint = "processed"
+	JobCheckpointStored    JobCheckpoint = "stored"
+)
+
+type Job struct {
+	ID            uuid.UUID     `json:"id"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	State         JobState      `json:"state"`
+	Checkpoint    JobCheckpoint `json:"checkpoint"`
+	ProcessedPath *string       `json:"-"`
+	Fingerprint   *string       `json:"-"`
+	Attempts      int           `json:"attempts"`
+	Error         *string       `json:"error"`
+	WorkerID      *string       `json:"worker_id"`
+	HeartbeatAt   *time.Time    `json:"heartbeat_at"`
+	ExternalID    *string       `json:"external_id"`
+	// RetryAt is when a job put back on the queue after its worker stopped
+	// responding can be claimed again
+	RetryAt *time.Time `json:"retry_at"`
+	// ClaimedAt is when a worker last took the job off the queue
+	ClaimedAt *time.Time `json:"claimed_at"`
+	// Trace records each stage the job has run, across all its attempts
+	Trace []JobTraceEntry `json:"trace"`
+	CreateJobParams
+}
+
+// JobTraceEntry is one run of a processing stage of a job.
+type JobTraceEntry struct {
+	Attempt   int       `json:"attempt"`
+	Stage     string    `json:"stage"`
+	WorkerID  *string   `json:"worker_id"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration_seconds"`
+	Error     *string   `json:"error"`
+}
+
+type CreateJobParams struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	MediaType  string    `json:"media_type"`
+	SourcePath string    `json:"-"`
+	// SourceKey is the staging object holding the upload when jobs are
+	// processed by workers that can't see the API server's disk.
+	SourceKey    *string `json:"-"`
+	ObjectKey    string  `json:"object_key"`
+	Duration     float64 `json:"duration"`
+	SourceSHA256 string  `json:"source_sha256"`
+	// Profile names the processing profile the upload was given, empty for
+	// the deployment's default
+	Profile string `json:"profile"`
+	// Notify is the notification channel the result is sent on, empty for
+	// every channel the user set up
+	Notify string `json:"notify"`
+	// Priority is the class the job is scheduled in, normal when unset
+	Priority JobPriority `json:"priority"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		state,
+		checkpoint,
+		media_type,
+		source_path,
+		source_key,
+		processed_path,
+		object_key,
+		duration,
+		fingerprint,
+		attempts,
+		error,
+		worker_id,
+		heartbeat_at,
+		external_id,
+		source_sha256,
+		profile,
+		retry_at,
+		notify,
+		priority,
+		claimed_at,
+		trace`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	var objectKey, sourceSHA256, profile, trace sql.NullString
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.VideoID,
+		&job.UserID,
+		&job.State,
+		&job.Checkpoint,
+		&job.MediaType,
+		&job.SourcePath,
+		&job.SourceKey,
+		&job.ProcessedPath,
+		&objectKey,
+		&job.Duration,
+		&job.Fingerprint,
+		&job.Attempts,
+		&job.Error,
+		&job.WorkerID,
+		&job.HeartbeatAt,
+		&job.ExternalID,
+		&sourceSHA256,
+		&profile,
+		&job.RetryAt,
+		&job.Notify,
+		&job.Priority,
+		&job.ClaimedAt,
+		&trace,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+	job.ObjectKey = objectKey.String
+	job.SourceSHA256 = sourceSHA256.String
+	job.Profile = profile.String
+	job.Trace = []JobTraceEntry{}
+	if trace.Valid && trace.String != "" {
+		if err := json.Unmarshal([]byte(trace.String), &job.Trace); err != nil {
+			return Job{}, err
+		}
+	}
+	return job, nil
+}
+
+// CreateJob queues a processing job for a video, moving a video without a
+// file to processing in the same write. It returns ErrInvalidVideoTransition
+// for a video that can't take an upload.
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	if params.Priority == "" {
+		params.Priority = JobPriorityNormal
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Job{}, err
+	}
+	defer tx.Rollback()
+
+	if err := startVideoProcessing(tx, params.VideoID); err != nil {
+		return Job{}, err
+	}
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		state,
+		checkpoint,
+		media_type,
+		source_path,
+		source_key,
+		object_key,
+		duration,
+		source_sha256,
+		profile,
+		notify,
+		priority
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(
+		query,
+		id,
+		params.VideoID,
+		params.UserID,
+		JobStateQueued,
+		JobCheckpointReceived,
+		params.MediaType,
+		params.SourcePath,
+		params.SourceKey,
+		params.ObjectKey,
+		params.Duration,
+		params.SourceSHA256,
+		params.Profile,
+		params.Notify,
+		params.Priority,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// GetIncompleteJobs returns jobs that were queued or running, oldest first.
+func (c Client) GetIncompleteJobs() ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE state IN (?, ?)
+	ORDER BY created_at ASC
+	`
+
+	rows, err := c.db.Query(query, JobStateQueued, JobStateRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// GetJobByExternalID returns the job whose work was submitted to an external
+// service under id, or a zero Job if there is none.
+func (c Client) GetJobByExternalID(id string) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE external_id = ?
+	`
+
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// UpdateJob saves a job, returning ErrJobCancelled instead if it has been
+// cancelled since it was read.
+func (c Client) UpdateJob(job Job) error {
+	query := `
+	UPDATE jobs
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		state = ?,
+		checkpoint = ?,
+		source_key = ?,
+		object_key = ?,
+		processed_path = ?,
+		duration = ?,
+		fingerprint = ?,
+		attempts = ?,
+		error = ?,
+		external_id = ?
+	WHERE id = ? AND state != ?
+	`
+
+	result, err := c.db.Exec(
+		query,
+		job.State,
+		job.Checkpoint,
+		job.SourceKey,
+		job.ObjectKey,
+		job.ProcessedPath,
+		job.Duration,
+		job.Fingerprint,
+		job.Attempts,
+		job.Error,
+		job.ExternalID,
+		job.ID,
+		JobStateCancelled,
+	)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil || updated > 0 {
+		return err
+	}
+	current, err := c.GetJob(job.ID)
+	if err != nil {
+		return err
+	}
+	if current.State == JobStateCancelled {
+		return ErrJobCancelled
+	}
+	return nil
+}
+
+// AppendJobTrace adds a stage's run to the end of a job's trace.
+func (c Client) AppendJobTrace(id uuid.UUID, entry JobTraceEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE jobs
+	SET trace = json_insert(COALESCE(trace, '[]'), '$[#]', json(?))
+	WHERE id = ?
+	`
+	_, err = c.db.Exec(query, string(data), id)
+	return err
+}
+
+// RequeueFailedJob puts a failed job back on the queue from the start,
+// processing the source staged at sourceKey, with its attempts and trace
+// reset, and its video back to processing if it has no file. It reports
+// false, changing nothing, if the job isn't failed.
+func (c Client) RequeueFailedJob(id uuid.UUID, sourceKey string) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		checkpoint = ?,
+		source_key = ?,
+		processed_path = NULL,
+		fingerprint = NULL,
+		attempts = 0,
+		error = NULL,
+		worker_id = NULL,
+		heartbeat_at = NULL,
+		external_id = NULL,
+		retry_at = NULL,
+		trace = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND state = ?
+	`
+	result, err := tx.Exec(query, JobStateQueued, JobCheckpointReceived, sourceKey, id, JobStateFailed)
+	if err != nil {
+		return false, err
+	}
+	requeued, err := result.RowsAffected()
+	if err != nil || requeued == 0 {
+		return false, err
+	}
+
+	var videoID uuid.UUID
+	if err := tx.QueryRow(`SELECT video_id FROM jobs WHERE id = ?`, id).Scan(&videoID); err != nil {
+		return false, err
+	}
+	if err := startVideoProcessing(tx, videoID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// CancelJob cancels a job that hasn't finished, recording reason as its
+// error. It reports false, changing nothing, if the job had already
+// finished.
+func (c Client) CancelJob(id uuid.UUID, reason string) (bool, error) {
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND state IN (?, ?, ?)
+	`
+	result, err := c.db.Exec(query, JobStateCancelled, reason, id, JobStateQueued, JobStateRunning, JobStateWaiting)
+	if err != nil {
+		return false, err
+	}
+	cancelled, err := result.RowsAffected()
+	return cancelled > 0, err
+}
+
+// ClaimJob atomically assigns a queued job to workerID: the oldest job of
+// the first priority in order that has one waiting. Priorities left out of
+// order come after it, highest first. It returns false if there was nothing
+// to claim.
+func (c Client) ClaimJob(workerID string, order []JobPriority) (Job, bool, error) {
+	// Rank each priority by its place in order, the rest after them
+	rank := "CASE priority"
+	args := []any{}
+	for i, priority := range slices.Concat(order, JobPriorities) {
+		rank += " WHEN ? THEN ?"
+		args = append(args, priority, i)
+	}
+	rank += " ELSE ? END"
+	args = append(args, len(order)+len(JobPriorities))
+
+	for {
+		var id uuid.UUID
+		err := c.db.QueryRow(`
+		SELECT id
+		FROM jobs
+		WHERE state = ? AND (retry_at IS NULL OR retry_at <= datetime('now'))
+		ORDER BY `+rank+`, created_at ASC
+		LIMIT 1
+		`, append([]any{JobStateQueued}, args...)...).Scan(&id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return Job{}, false, nil
+			}
+			return Job{}, false, err
+		}
+
+		// Only one worker's update can match while the job is still queued
+		result, err := c.db.Exec(`
+		UPDATE jobs
+		SET
+			state = ?,
+			worker_id = ?,
+			heartbeat_at = CURRENT_TIMESTAMP,
+			claimed_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND state = ?
+		`, JobStateRunning, workerID, id, JobStateQueued)
+		if err != nil {
+			return Job{}, false, err
+		}
+		claimed, err := result.RowsAffected()
+		if err != nil {
+			return Job{}, false, err
+		}
+		if claimed == 0 {
+			// Another worker got there first, try the next job
+			continue
+		}
+
+		job, err := c.GetJob(id)
+		return job, err == nil, err
+	}
+}
+
+// JobQueueStats describes the jobs of one priority.
+type JobQueueStats struct {
+	Priority JobPriority `json:"priority"`
+	Queued   int         `json:"queued"`
+	Running  int         `json:"running"`
+	// OldestWait is how long the job that has waited longest has been queued
+	OldestWait float64 `json:"oldest_wait_seconds"`
+	// Claimed counts the jobs workers took off the queue within the window
+	// the stats were asked for, and the waits are theirs
+	Claimed     int     `json:"claimed"`
+	AverageWait float64 `json:"average_wait_seconds"`
+	MaxWait     float64 `json:"max_wait_seconds"`
+	Succeeded   int     `json:"succeeded"`
+	Failed      int     `json:"failed"`
+}
+
+// GetJobQueueStats returns the stats of each priority, highest first.
+// Claims, waits and outcomes count jobs claimed within window.
+func (c Client) GetJobQueueStats(window time.Duration) ([]JobQueueStats, error) {
+	query := `
+	SELECT
+		priority,
+		COUNT(*) FILTER (WHERE state = ?),
+		COUNT(*) FILTER (WHERE state IN (?, ?)),
+		COALESCE(MAX((julianday('now') - julianday(created_at)) * 86400) FILTER (WHERE state = ?), 0),
+		COUNT(*) FILTER (WHERE claimed_at >= datetime('now', ?)),
+		COALESCE(AVG((julianday(claimed_at) - julianday(created_at)) * 86400) FILTER (WHERE claimed_at >= datetime('now', ?)), 0),
+		COALESCE(MAX((julianday(claimed_at) - julianday(created_at)) * 86400) FILTER (WHERE claimed_at >= datetime('now', ?)), 0),
+		COUNT(*) FILTER (WHERE state = ? AND claimed_at >= datetime('now', ?)),
+		COUNT(*) FILTER (WHERE state = ? AND claimed_at >= datetime('now', ?))
+	FROM jobs
+	GROUP BY priority
+	`
+	since := secondsAgo(window)
+	rows, err := c.db.Query(query,
+		JobStateQueued,
+		JobStateRunning, JobStateWaiting,
+		JobStateQueued,
+		since, since, since,
+		JobStateSucceeded, since,
+		JobStateFailed, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPriority := map[JobPriority]JobQueueStats{}
+	for rows.Next() {
+		var stats JobQueueStats
+		err := rows.Scan(
+			&stats.Priority,
+			&stats.Queued,
+			&stats.Running,
+			&stats.OldestWait,
+			&stats.Claimed,
+			&stats.AverageWait,
+			&stats.MaxWait,
+			&stats.Succeeded,
+			&stats.Failed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		byPriority[stats.Priority] = stats
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	all := make([]JobQueueStats, 0, len(JobPriorities))
+	for _, priority := range JobPriorities {
+		stats := byPriority[priority]
+		stats.Priority = priority
+		all = append(all, stats)
+	}
+	return all, nil
+}
+
+// HeartbeatJob records that workerID is still working on a job, returning
+// the job's state so the worker can stop if it has been cancelled.
+func (c Client) HeartbeatJob(id uuid.UUID, workerID string) (JobState, error) {
+	query := `
+	UPDATE jobs
+	SET heartbeat_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND worker_id = ?
+	`
+	if _, err := c.db.Exec(query, id, workerID); err != nil {
+		return "", err
+	}
+	var state JobState
+	err := c.db.QueryRow(`SELECT state FROM jobs WHERE id = ?`, id).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return state, err
+}
+
+// GetStaleJobs returns running jobs whose worker hasn't sent a heartbeat
+// within staleAfter, oldest first.
+func (c Client) GetStaleJobs(staleAfter time.Duration) ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE state = ?
+		AND worker_id IS NOT NULL
+		AND heartbeat_at < datetime('now', ?)
+	ORDER BY created_at ASC
+	`
+
+	rows, err := c.db.Query(query, JobStateRunning, secondsAgo(staleAfter))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// RequeueStaleJob puts a job back on the queue to be claimed again after
+// delay, recording why. It reports false, changing nothing, if the job's
+// worker has sent a heartbeat within staleAfter after all.
+func (c Client) RequeueStaleJob(id uuid.UUID, staleAfter, delay time.Duration, reason string) (bool, error) {
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		worker_id = NULL,
+		error = ?,
+		retry_at = datetime('now', ?),
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+		AND state = ?
+		AND heartbeat_at < datetime('now', ?)
+	`
+	result, err := c.db.Exec(
+		query,
+		JobStateQueued,
+		reason,
+		fmt.Sprintf("+%d seconds", int(delay.Seconds())),
+		id,
+		JobStateRunning,
+		secondsAgo(staleAfter),
+	)
+	if err != nil {
+		return false, err
+	}
+	requeued, err := result.RowsAffected()
+	return requeued > 0, err
+}
+
+// secondsAgo is a datetime modifier for d before now.
+func secondsAgo(d time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int(d.Seconds()))
+}
+
+func (c Client) DeleteUserJobs(userID uuid.UUID) error {
+	query := `
+	DELETE FROM jobs
+	WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, userID)
+	return err
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MultipartUpload is a multipart upload to S3 in progress, with the parts
+// it has stored so far, kept so an upload interrupted by a crash can carry
+// on from its last part.
+type MultipartUpload struct {
+	// UploadID is the ID S3 gave the upload
+	UploadID  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Bucket    string
+	Key       string
+	// Size is the size of the whole object, nil for a stream of unknown
+	// length, which can't be resumed
+	Size     *int64
+	PartSize int64
+	Parts    []MultipartPart
+}
+
+// MultipartPart is a part S3 has stored.
+type MultipartPart struct {
+	Number int32
+	ETag   string
+	Size   int64
+}
+
+type CreateMultipartUploadParams struct {
+	UploadID string
+	Bucket   string
+	Key      string
+	Size     *int64
+	PartSize int64
+}
+
+func (c Client) CreateMultipartUpload(params CreateMultipartUploadParams) error {
+	query := `
+	INSERT INTO multipart_uploads (
+		upload_id,
+		created_at,
+		updated_at,
+		bucket,
+		key,
+		size,
+		part_size
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.UploadID, params.Bucket, params.Key, params.Size, params.PartSize)
+	return err
+}
+
+// GetResumableMultipartUpload returns the latest upload of an object of
+// size bytes to key in parts of partSize, with its parts in order, or a
+// zero MultipartUpload if there is none.
+func (c Client) GetResumableMultipartUpload(bucket, key string, size, partSize int64) (MultipartUpload, error) {
+	query := `
+	SELECT upload_id, created_at, updated_at, bucket, key, size, part_size
+	FROM multipart_uploads
+	WHERE bucket = ? AND key = ? AND size = ? AND part_size = ?
+	ORDER BY created_at DESC, rowid DESC
+	LIMIT 1
+	`
+	var upload MultipartUpload
+	err := c.db.QueryRow(query, bucket, key, size, partSize).Scan(
+		&upload.UploadID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.Bucket,
+		&upload.Key,
+		&upload.Size,
+		&upload.PartSize,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return MultipartUpload{}, nil
+		}
+		return MultipartUpload{}, err
+	}
+
+	rows, err := c.db.Query(`
+	SELECT part_number, etag, size
+	FROM multipart_upload_parts
+	WHERE upload_id = ?
+	ORDER BY part_number ASC
+	`, upload.UploadID)
+	if err != nil {
+		return MultipartUpload{}, err
+	}
+	defer rows.Close()
+
+	upload.Parts = []MultipartPart{}
+	for rows.Next() {
+		var part MultipartPart
+		if err := rows.Scan(&part.Number, &part.ETag, &part.Size); err != nil {
+			return MultipartUpload{}, err
+		}
+		upload.Parts = append(upload.Parts, part)
+	}
+	return upload, rows.Err()
+}
+
+// RecordMultipartPart records a part S3 has stored, replacing any earlier
+// upload of the same part.
+func (c Client) RecordMultipartPart(uploadID string, part MultipartPart) error {
+	query := `
+	INSERT INTO multipart_upload_parts (upload_id, part_number, etag, size)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(upload_id, part_number) DO UPDATE SET etag = excluded.etag, size = excluded.size
+	`
+	if _, err := c.db.Exec(query, uploadID, part.Number, part.ETag, part.Size); err != nil {
+		return err
+	}
+	_, err := c.db.Exec(`UPDATE multipart_uploads SET updated_at = CURRENT_TIMESTAMP WHERE upload_id = ?`, uploadID)
+	return err
+}
+
+// DeleteMultipartUpload forgets an upload once it's completed or aborted.
+func (c Client) DeleteMultipartUpload(uploadID string) error {
+	if _, err := c.db.Exec(`DELETE FROM multipart_upload_parts WHERE upload_id = ?`, uploadID); err != nil {
+		return err
+	}
+	_, err := c.db.Exec(`DELETE FROM multipart_uploads WHERE upload_id = ?`, uploadID)
+	return err
+}
+
+// GetMultipartUploadBuckets returns the buckets uploads are in progress in.
+func (c Client) GetMultipartUploadBuckets() ([]string, error) {
+	rows, err := c.db.Query(`SELECT DISTINCT bucket FROM multipart_uploads ORDER BY bucket`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []string{}
+	for rows.Next() {
+		var bucket string
+		if err := rows.Scan(&bucket); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// DeleteStaleMultipartUploads forgets uploads that haven't stored a part
+// within maxAge, returning how many it forgot.
+func (c Client) DeleteStaleMultipartUploads(maxAge time.Duration) (int, error) {
+	_, err := c.db.Exec(`
+	DELETE FROM multipart_upload_parts
+	WHERE upload_id IN (SELECT upload_id FROM multipart_uploads WHERE updated_at < datetime('now', ?))
+	`, secondsAgo(maxAge))
+	if err != nil {
+		return 0, err
+	}
+	result, err := c.db.Exec(`DELETE FROM multipart_uploads WHERE updated_at < datetime('now', ?)`, secondsAgo(maxAge))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// GetActiveMultipartUploadIDs returns the uploads that have stored a part,
+// or started, within maxAge.
+func (c Client) GetActiveMultipartUploadIDs(maxAge time.Duration) (map[string]bool, error) {
+	rows, err := c.db.Query(`SELECT upload_id FROM multipart_uploads WHERE updated_at >= datetime('now', ?)`, secondsAgo(maxAge))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NotificationSettings are where and when a user is told that their videos
+// finished processing. Users who never saved any get no notifications.
+type NotificationSettings struct {
+	UserID    uuid.UUID `json:"user_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	SetNotificationSettingsParams
+}
+
+type SetNotificationSettingsParams struct {
+	// Email sends notifications to the account's email address
+	Email             bool   `json:"email"`
+	SlackWebhookURL   string `json:"slack_webhook_url"`
+	DiscordWebhookURL string `json:"discord_webhook_url"`
+	OnSuccess         bool   `json:"on_success"`
+	OnFailure         bool   `json:"on_failure"`
+}
+
+func (c Client) SetNotificationSettings(userID uuid.UUID, params SetNotificationSettingsParams) (NotificationSettings, error) {
+	query := `
+	INSERT INTO notification_settings (
+		user_id,
+		updated_at,
+		email,
+		slack_webhook_url,
+		discord_webhook_url,
+		on_success,
+		on_failure
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		updated_at = excluded.updated_at,
+		email = excluded.email,
+		slack_webhook_url = excluded.slack_webhook_url,
+		discord_webhook_url = excluded.discord_webhook_url,
+		on_success = excluded.on_success,
+		on_failure = excluded.on_failure
+	`
+	_, err := c.db.Exec(
+		query,
+		userID,
+		time.Now().UTC(),
+		params.Email,
+		params.SlackWebhookURL,
+		params.DiscordWebhookURL,
+		params.OnSuccess,
+		params.OnFailure,
+	)
+	if err != nil {
+		return NotificationSettings{}, err
+	}
+
+	return c.GetNotificationSettings(userID)
+}
+
+// GetNotificationSettings returns a user's settings, or the zero value if
+// they never saved any.
+func (c Client) GetNotificationSettings(userID uuid.UUID) (NotificationSettings, error) {
+	query := `
+	SELECT user_id, updated_at, email, slack_webhook_url, discord_webhook_url, on_success, on_failure
+	FROM notification_settings
+	WHERE user_id = ?
+	`
+
+	var settings NotificationSettings
+	err := c.db.QueryRow(query, userID).Scan(
+		&settings.UserID,
+		&settings.UpdatedAt,
+		&settings.Email,
+		&settings.SlackWebhookURL,
+		&settings.DiscordWebhookURL,
+		&settings.OnSuccess,
+		&settings.OnFailure,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return NotificationSettings{}, nil
+		}
+		return NotificationSettings{}, err
+	}
+
+	return settings, nil
+}
+
+func (c Client) DeleteNotificationSettings(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM notification_settings WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Plan is a billing tier, setting what its users are entitled to. Users
+// without one are held to the server's defaults.
+type Plan struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	PlanLimits
+}
+
+// PlanLimits are a plan's entitlements. Nil limits fall back to the
+// server's defaults.
+type PlanLimits struct {
+	// UploadLimit is the largest video, in bytes, users can upload.
+	UploadLimit *int64 `json:"upload_limit"`
+	// StorageQuota caps the bytes each user can have stored at once.
+	StorageQuota *int64 `json:"storage_quota"`
+	// MaxVideoDuration caps how long, in seconds, each video can be.
+	MaxVideoDuration *int `json:"max_video_duration"`
+	// Profiles lists the processing profiles users can ask for, any of
+	// them if empty. Uploads that don't ask for one get the default.
+	Profiles []string `json:"profiles"`
+	// MaxLinkExpiry caps how long, in seconds, share links and clips last.
+	MaxLinkExpiry *int `json:"max_link_expiry"`
+}
+
+// AllowsProfile reports whether users on the plan can ask for a profile.
+func (l PlanLimits) AllowsProfile(name string) bool {
+	if len(l.Profiles) == 0 {
+		return true
+	}
+	for _, profile := range l.Profiles {
+		if profile == name {
+			return true
+		}
+	}
+	return false
+}
+
+const planColumns = `
+		name,
+		created_at,
+		updated_at,
+		upload_limit,
+		storage_quota,
+		max_video_duration,
+		profiles,
+		max_link_expiry`
+
+func scanPlan(row rowScanner) (Plan, error) {
+	var plan Plan
+	var profiles sql.NullString
+	err := row.Scan(
+		&plan.Name,
+		&plan.CreatedAt,
+		&plan.UpdatedAt,
+		&plan.UploadLimit,
+		&plan.StorageQuota,
+		&plan.MaxVideoDuration,
+		&profiles,
+		&plan.MaxLinkExpiry,
+	)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan.Profiles = []string{}
+	if profiles.Valid && profiles.String != "" {
+		if err := json.Unmarshal([]byte(profiles.String), &plan.Profiles); err != nil {
+			return Plan{}, err
+		}
+	}
+	return plan, nil
+}
+
+// SavePlan creates a plan, or replaces the limits of an existing one.
+func (c Client) SavePlan(name string, limits PlanLimits) (Plan, error) {
+	var profiles *string
+	if len(limits.Profiles) > 0 {
+		data, err := json.Marshal(limits.Profiles)
+		if err != nil {
+			return Plan{}, err
+		}
+		value := string(data)
+		profiles = &value
+	}
+
+	query := `
+	INSERT INTO plans (
+		name,
+		created_at,
+		updated_at,
+		upload_limit,
+		storage_quota,
+		max_video_duration,
+		profiles,
+		max_link_expiry
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		upload_limit = excluded.upload_limit,
+		storage_quota = excluded.storage_quota,
+		max_video_duration = excluded.max_video_duration,
+		profiles = excluded.profiles,
+		max_link_expiry = excluded.max_link_expiry
+	`
+	_, err := c.db.Exec(
+		query,
+		name,
+		limits.UploadLimit,
+		limits.StorageQuota,
+		limits.MaxVideoDuration,
+		profiles,
+		limits.MaxLinkExpiry,
+	)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan, err := c.GetPlan(name)
+	if err != nil {
+		return Plan{}, err
+	}
+	return *plan, nil
+}
+
+// GetPlan returns the plan with a name, or nil if there isn't one.
+func (c Client) GetPlan(name string) (*Plan, error) {
+	query := `
+	SELECT` + planColumns + `
+	FROM plans
+	WHERE name = ?
+	`
+
+	plan, err := scanPlan(c.db.QueryRow(query, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+func (c Client) GetPlans() ([]Plan, error) {
+	query := `
+	SELECT` + planColumns + `
+	FROM plans
+	ORDER BY name ASC
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []Plan{}
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, nil
+}
+
+// DeletePlan removes a plan. It doesn't check whether anyone is on it.
+func (c Client) DeletePlan(name string) error {
+	_, err := c.db.Exec("DELETE FROM plans WHERE name = ?", name)
+	return err
+}
+
+// CountPlanUsers returns how many users are on a plan.
+func (c Client) CountPlanUsers(name string) (int, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM users WHERE plan = ?", name).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PlaybackLink is a single-use link to a stored object. Opening it redirects
+// to a short-lived presigned URL, so a copied link stops working once it has
+// been used.
+type PlaybackLink struct {
+	Nonce     string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatePlaybackLinkParams
+}
+
+type CreatePlaybackLinkParams struct {
+	Bucket    string    `json:"-"`
+	ObjectKey string    `json:"-"`
+	Fragment  string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c Client) CreatePlaybackLink(nonce string, params CreatePlaybackLinkParams) error {
+	query := `
+	INSERT INTO playback_links (
+		nonce,
+		created_at,
+		bucket,
+		object_key,
+		fragment,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, nonce, params.Bucket, params.ObjectKey, params.Fragment, params.ExpiresAt)
+	return err
+}
+
+func (c Client) GetPlaybackLink(nonce string) (PlaybackLink, error) {
+	query := `
+	SELECT nonce, created_at, used_at, bucket, object_key, fragment, expires_at
+	FROM playback_links
+	WHERE nonce = ?
+	`
+
+	var link PlaybackLink
+	err := c.db.QueryRow(query, nonce).Scan(
+		&link.Nonce,
+		&link.CreatedAt,
+		&link.UsedAt,
+		&link.Bucket,
+		&link.ObjectKey,
+		&link.Fragment,
+		&link.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PlaybackLink{}, nil
+		}
+		return PlaybackLink{}, err
+	}
+
+	return link, nil
+}
+
+// UsePlaybackLink marks a link as used, returning false if it already was.
+func (c Client) UsePlaybackLink(nonce string) (bool, error) {
+	query := `
+	UPDATE playback_links
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE nonce = ? AND used_at IS NULL
+	`
+	result, err := c.db.Exec(query, nonce)
+	if err != nil {
+		return false, err
+	}
+	used, err := result.RowsAffected()
+	return used == 1, err
+}
+
+// DeleteExpiredPlaybackLinks removes links that can no longer be opened.
+func (c Client) DeleteExpiredPlaybackLinks(now time.Time) error {
+	query := `
+	DELETE FROM playback_links
+	WHERE expires_at < ? OR used_at IS NOT NULL
+	`
+	_, err := c.db.Exec(query, now)
+	return err
+}
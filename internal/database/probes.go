@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetProbe returns the cached ffprobe JSON for a file's SHA-256, or nil if
+// those bytes haven't been probed.
+func (c Client) GetProbe(sha256 string) ([]byte, error) {
+	query := `
+	SELECT probe_json
+	FROM probe_cache
+	WHERE sha256 = ?
+	`
+
+	var probe string
+	err := c.db.QueryRow(query, sha256).Scan(&probe)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return []byte(probe), nil
+}
+
+func (c Client) SaveProbe(sha256 string, probe []byte) error {
+	query := `
+	INSERT INTO probe_cache (sha256, created_at, probe_json)
+	VALUES (?, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT(sha256) DO UPDATE SET probe_json = excluded.probe_json
+	`
+	_, err := c.db.Exec(query, sha256, string(probe))
+	return err
+}
+
+// GetObjectProbe returns the cached ffprobe JSON for a stored object, or nil
+// if it hasn't been probed since it last changed.
+func (c Client) GetObjectProbe(bucket, key, etag string) ([]byte, error) {
+	query := `
+	SELECT probe_json
+	FROM object_probes
+	WHERE bucket = ? AND key = ? AND etag = ?
+	`
+
+	var probe string
+	err := c.db.QueryRow(query, bucket, key, etag).Scan(&probe)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return []byte(probe), nil
+}
+
+// SaveObjectProbe caches the ffprobe JSON for a stored object, replacing any
+// probe of an earlier version of it.
+func (c Client) SaveObjectProbe(bucket, key, etag string, probe []byte) error {
+	query := `
+	INSERT INTO object_probes (bucket, key, etag, created_at, probe_json)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT(bucket, key) DO UPDATE SET
+		etag = excluded.etag,
+		created_at = excluded.created_at,
+		probe_json = excluded.probe_json
+	`
+	_, err := c.db.Exec(query, bucket, key, etag, string(probe))
+	return err
+}
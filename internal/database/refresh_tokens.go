@@ -81,3 +81,22 @@ func (c Client) DeleteRefreshToken(token string) error {
 	_, err := c.db.Exec(query, token)
 	return err
 }
+
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
+func (c Client) DeleteUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
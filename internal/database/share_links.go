@@ -0,0 +1,157 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ShareLink struct {
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Token        string     `json:"token"`
+	Views        int        `json:"views"`
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+	CreateShareLinkParams
+}
+
+type CreateShareLinkParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// MaxViews caps how many times the link can be opened, nil for no cap
+	MaxViews *int `json:"max_views"`
+	// PasswordHash is the bcrypt hash viewers' passwords are checked
+	// against, empty for links anyone with the URL can open
+	PasswordHash string `json:"-"`
+}
+
+const shareLinkColumns = `
+		id,
+		created_at,
+		token,
+		views,
+		last_viewed_at,
+		video_id,
+		user_id,
+		expires_at,
+		max_views,
+		password_hash`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(
+		&link.ID,
+		&link.CreatedAt,
+		&link.Token,
+		&link.Views,
+		&link.LastViewedAt,
+		&link.VideoID,
+		&link.UserID,
+		&link.ExpiresAt,
+		&link.MaxViews,
+		&link.PasswordHash,
+	)
+	return link, err
+}
+
+func (c Client) CreateShareLink(token string, params CreateShareLinkParams) (ShareLink, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (
+		id,
+		created_at,
+		token,
+		video_id,
+		user_id,
+		expires_at,
+		max_views,
+		password_hash
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, token, params.VideoID, params.UserID, params.ExpiresAt, params.MaxViews, params.PasswordHash)
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	return c.getShareLink("id", id)
+}
+
+func (c Client) GetShareLink(id uuid.UUID) (ShareLink, error) {
+	return c.getShareLink("id", id)
+}
+
+func (c Client) GetShareLinkByToken(token string) (ShareLink, error) {
+	return c.getShareLink("token", token)
+}
+
+func (c Client) getShareLink(column string, value any) (ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE ` + column + ` = ?
+	`
+
+	link, err := scanShareLink(c.db.QueryRow(query, value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+
+	return link, nil
+}
+
+func (c Client) GetShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// RecordShareLinkView counts a view of a link, reporting false without
+// counting it if the link has expired or used up its views. Concurrent
+// views can't push a link past its cap.
+func (c Client) RecordShareLinkView(id uuid.UUID, now time.Time) (bool, error) {
+	query := `
+	UPDATE share_links
+	SET views = views + 1, last_viewed_at = ?
+	WHERE id = ? AND expires_at > ? AND (max_views IS NULL OR views < max_views)
+	`
+	result, err := c.db.Exec(query, now, id, now)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (c Client) DeleteShareLink(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM share_links WHERE id = ?", id)
+	return err
+}
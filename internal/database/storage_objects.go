@@ -0,0 +1,251 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of stored object tracked for storage accounting
+const (
+	StorageKindVideo     = "video"
+	StorageKindThumbnail = "thumbnail"
+	StorageKindClip      = "clip"
+	StorageKindAvatar    = "avatar"
+)
+
+// StoredObject is one file kept on behalf of a user, in a bucket or, when
+// Bucket is empty, in the local assets directory.
+type StoredObject struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	// FixityCheckedAt is when the object's contents were last verified
+	FixityCheckedAt *time.Time `json:"fixity_checked_at"`
+	RecordStoredObjectParams
+}
+
+type RecordStoredObjectParams struct {
+	UserID  uuid.UUID     `json:"user_id"`
+	VideoID uuid.NullUUID `json:"video_id"`
+	Kind    string        `json:"kind"`
+	Bucket  string        `json:"bucket"`
+	Key     string        `json:"key"`
+	Bytes   int64         `json:"bytes"`
+	// SHA256 is the hex digest of the object's contents, nil until it's known
+	SHA256 *string `json:"sha256"`
+}
+
+const storedObjectColumns = `
+		id,
+		created_at,
+		updated_at,
+		deleted_at,
+		fixity_checked_at,
+		user_id,
+		video_id,
+		kind,
+		bucket,
+		key,
+		bytes,
+		sha256`
+
+func scanStoredObject(row rowScanner) (StoredObject, error) {
+	var object StoredObject
+	err := row.Scan(
+		&object.ID,
+		&object.CreatedAt,
+		&object.UpdatedAt,
+		&object.DeletedAt,
+		&object.FixityCheckedAt,
+		&object.UserID,
+		&object.VideoID,
+		&object.Kind,
+		&object.Bucket,
+		&object.Key,
+		&object.Bytes,
+		&object.SHA256,
+	)
+	return object, err
+}
+
+// RecordStoredObject adds an object to the storage ledger, or updates its
+// size and checksum if the key was written before.
+func (c Client) RecordStoredObject(params RecordStoredObjectParams) error {
+	query := `
+	INSERT INTO storage_objects (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		video_id,
+		kind,
+		bucket,
+		key,
+		bytes,
+		sha256
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (bucket, key) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		deleted_at = NULL,
+		user_id = excluded.user_id,
+		video_id = excluded.video_id,
+		kind = excluded.kind,
+		bytes = excluded.bytes,
+		sha256 = excluded.sha256,
+		fixity_checked_at = NULL
+	`
+	_, err := c.db.Exec(
+		query,
+		uuid.New(),
+		params.UserID,
+		params.VideoID,
+		params.Kind,
+		params.Bucket,
+		params.Key,
+		params.Bytes,
+		params.SHA256,
+	)
+	return err
+}
+
+// GetStoredObject returns the live ledger entry for an object, or nil if
+// there isn't one.
+func (c Client) GetStoredObject(bucket, key string) (*StoredObject, error) {
+	query := `
+	SELECT` + storedObjectColumns + `
+	FROM storage_objects
+	WHERE bucket = ? AND key = ? AND deleted_at IS NULL
+	`
+
+	object, err := scanStoredObject(c.db.QueryRow(query, bucket, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &object, nil
+}
+
+// MarkStoredObjectDeleted records that an object has been removed from storage.
+func (c Client) MarkStoredObjectDeleted(bucket, key string) error {
+	query := `
+	UPDATE storage_objects
+	SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE bucket = ? AND key = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, bucket, key)
+	return err
+}
+
+// GetStoredObjects returns every ledger entry, for all users if userID is
+// uuid.Nil, that was live at any point after since.
+func (c Client) GetStoredObjects(userID uuid.UUID, since time.Time) ([]StoredObject, error) {
+	query := `
+	SELECT` + storedObjectColumns + `
+	FROM storage_objects
+	WHERE (deleted_at IS NULL OR deleted_at >= ?)
+	`
+	args := []any{since}
+	if userID != uuid.Nil {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY created_at ASC"
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []StoredObject{}
+	for rows.Next() {
+		object, err := scanStoredObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// GetVideoStoredBytes returns the bytes currently stored for a video across all its variants.
+func (c Client) GetVideoStoredBytes(videoID uuid.UUID) (int64, error) {
+	var total int64
+	err := c.db.QueryRow(`
+	SELECT COALESCE(SUM(bytes), 0)
+	FROM storage_objects
+	WHERE video_id = ? AND deleted_at IS NULL
+	`, videoID).Scan(&total)
+	return total, err
+}
+
+// GetUserVideoStoredBytes returns the bytes currently stored for each of a
+// user's videos that has any.
+func (c Client) GetUserVideoStoredBytes(userID uuid.UUID) (map[uuid.UUID]int64, error) {
+	rows, err := c.db.Query(`
+	SELECT video_id, SUM(bytes)
+	FROM storage_objects
+	WHERE user_id = ? AND video_id IS NOT NULL AND deleted_at IS NULL
+	GROUP BY video_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[uuid.UUID]int64{}
+	for rows.Next() {
+		var videoID uuid.UUID
+		var total int64
+		if err := rows.Scan(&videoID, &total); err != nil {
+			return nil, err
+		}
+		totals[videoID] = total
+	}
+	return totals, rows.Err()
+}
+
+// GetUserStoredBytes returns the bytes a user has stored right now.
+func (c Client) GetUserStoredBytes(userID uuid.UUID) (int64, error) {
+	var total int64
+	err := c.db.QueryRow(`
+	SELECT COALESCE(SUM(bytes), 0)
+	FROM storage_objects
+	WHERE user_id = ? AND deleted_at IS NULL
+	`, userID).Scan(&total)
+	return total, err
+}
+
+// MarkUserStoredObjectsDeleted records that everything a user stored has
+// been removed. The entries are kept so usage already accrued can still be billed.
+func (c Client) MarkUserStoredObjectsDeleted(userID uuid.UUID) error {
+	query := `
+	UPDATE storage_objects
+	SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID)
+	return err
+}
+
+// GetStoredObjectBytes returns the size of a stored object, or 0 if the
+// ledger has no live entry for it.
+func (c Client) GetStoredObjectBytes(bucket, key string) (int64, error) {
+	var bytes int64
+	err := c.db.QueryRow(`
+	SELECT bytes
+	FROM storage_objects
+	WHERE bucket = ? AND key = ? AND deleted_at IS NULL
+	`, bucket, key).Scan(&bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return bytes, err
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StripeCustomer links a Stripe customer to the user paying as them.
+type StripeCustomer struct {
+	CustomerID string
+	UserID     uuid.UUID
+	// SubscriptionID is the subscription the user's plan was last set from
+	SubscriptionID string
+	// LastEventAt is when the last event applied was created, so events
+	// Stripe delivers out of order don't undo newer ones
+	LastEventAt *time.Time
+}
+
+// LinkStripeCustomer records which user a Stripe customer is, moving the
+// customer to them if it was linked to someone else.
+func (c Client) LinkStripeCustomer(customerID string, userID uuid.UUID) error {
+	query := `
+	INSERT INTO stripe_customers (customer_id, created_at, updated_at, user_id)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT(customer_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		user_id = excluded.user_id
+	`
+	_, err := c.db.Exec(query, customerID, userID)
+	return err
+}
+
+// GetStripeCustomer returns a linked Stripe customer, or nil if it isn't linked.
+func (c Client) GetStripeCustomer(customerID string) (*StripeCustomer, error) {
+	query := `
+	SELECT customer_id, user_id, subscription_id, last_event_at
+	FROM stripe_customers
+	WHERE customer_id = ?
+	`
+	customer := StripeCustomer{}
+	err := c.db.QueryRow(query, customerID).Scan(&customer.CustomerID, &customer.UserID, &customer.SubscriptionID, &customer.LastEventAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// ApplyStripeSubscription moves a customer's user to a plan, recording the
+// subscription and event it came from in the same transaction.
+func (c Client) ApplyStripeSubscription(customerID, subscriptionID, plan string, eventAt time.Time) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	err = tx.QueryRow("SELECT user_id FROM stripe_customers WHERE customer_id = ?", customerID).Scan(&userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	UPDATE stripe_customers
+	SET subscription_id = ?, last_event_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE customer_id = ?
+	`, subscriptionID, eventAt, customerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	UPDATE users
+	SET plan = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, plan, userID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUserStripeCustomers unlinks a user's Stripe customers. Events about
+// them are answered as unknown customers from then on.
+func (c Client) DeleteUserStripeCustomers(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM stripe_customers WHERE user_id = ?", userID)
+	return err
+}
+
+// RecordStripeEvent records that an event is being handled. It returns false
+// if the event was already recorded, since Stripe can deliver an event more
+// than once.
+func (c Client) RecordStripeEvent(id, eventType string) (bool, error) {
+	result, err := c.db.Exec(`
+	INSERT INTO stripe_events (id, created_at, type)
+	VALUES (?, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT(id) DO NOTHING
+	`, id, eventType)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ForgetStripeEvent removes an event's record after handling it failed, so
+// Stripe's retry is handled rather than skipped.
+func (c Client) ForgetStripeEvent(id string) error {
+	_, err := c.db.Exec("DELETE FROM stripe_events WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTenantFull is returned when a video can't be created because its
+// tenant already has as many videos as its MaxVideos allows.
+var ErrTenantFull = errors.New("tenant has reached its video limit")
+
+type Tenant struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreateTenantParams
+}
+
+type CreateTenantParams struct {
+	Name string `json:"name"`
+	// Bucket and BaseURL override the deployment's bucket and CloudFront
+	// distribution for the tenant's videos; both must be set together.
+	Bucket  *string `json:"bucket"`
+	BaseURL *string `json:"base_url"`
+	// KeyPrefix is prepended to every key the tenant's videos are stored under.
+	KeyPrefix string `json:"key_prefix"`
+	// MaxVideos caps how many videos the tenant's users can create in total.
+	MaxVideos *int `json:"max_videos"`
+}
+
+const tenantColumns = `
+		id,
+		created_at,
+		updated_at,
+		name,
+		bucket,
+		base_url,
+		key_prefix,
+		max_videos`
+
+func scanTenant(row rowScanner) (Tenant, error) {
+	var tenant Tenant
+	err := row.Scan(
+		&tenant.ID,
+		&tenant.CreatedAt,
+		&tenant.UpdatedAt,
+		&tenant.Name,
+		&tenant.Bucket,
+		&tenant.BaseURL,
+		&tenant.KeyPrefix,
+		&tenant.MaxVideos,
+	)
+	return tenant, err
+}
+
+func (c Client) CreateTenant(params CreateTenantParams) (Tenant, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO tenants (
+		id,
+		created_at,
+		updated_at,
+		name,
+		bucket,
+		base_url,
+		key_prefix,
+		max_videos
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.Name,
+		params.Bucket,
+		params.BaseURL,
+		params.KeyPrefix,
+		params.MaxVideos,
+	)
+	if err != nil {
+		return Tenant{}, err
+	}
+
+	return c.GetTenant(id)
+}
+
+func (c Client) GetTenant(id uuid.UUID) (Tenant, error) {
+	query := `
+	SELECT` + tenantColumns + `
+	FROM tenants
+	WHERE id = ?
+	`
+
+	tenant, err := scanTenant(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tenant{}, nil
+		}
+		return Tenant{}, err
+	}
+
+	return tenant, nil
+}
+
+func (c Client) GetTenants() ([]Tenant, error) {
+	query := `
+	SELECT` + tenantColumns + `
+	FROM tenants
+	ORDER BY name ASC
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []Tenant{}
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, nil
+}
+
+func (c Client) CountTenantVideos(id uuid.UUID) (int, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM videos WHERE tenant_id = ?", id).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Thumbnail is one image a video has used as its thumbnail. The current one
+// has no SupersededAt.
+type Thumbnail struct {
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	SupersededAt *time.Time `json:"superseded_at"`
+	CreateThumbnailParams
+}
+
+type CreateThumbnailParams struct {
+	VideoID       uuid.UUID `json:"video_id"`
+	AssetPath     string    `json:"-"`
+	Bytes         int64     `json:"bytes"`
+	BlurHash      *string   `json:"blurhash"`
+	DominantColor *string   `json:"dominant_color"`
+	PHash         *string   `json:"-"`
+	// Variants are the asset paths of the thumbnail's crops, by aspect ratio
+	Variants map[string]string `json:"-"`
+}
+
+const thumbnailColumns = `
+		id,
+		created_at,
+		superseded_at,
+		video_id,
+		asset_path,
+		bytes,
+		blurhash,
+		dominant_color,
+		phash,
+		variants`
+
+func scanThumbnail(row rowScanner) (Thumbnail, error) {
+	var thumbnail Thumbnail
+	var variants sql.NullString
+	err := row.Scan(
+		&thumbnail.ID,
+		&thumbnail.CreatedAt,
+		&thumbnail.SupersededAt,
+		&thumbnail.VideoID,
+		&thumbnail.AssetPath,
+		&thumbnail.Bytes,
+		&thumbnail.BlurHash,
+		&thumbnail.DominantColor,
+		&thumbnail.PHash,
+		&variants,
+	)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+
+	thumbnail.Variants = map[string]string{}
+	if variants.Valid && variants.String != "" {
+		if err := json.Unmarshal([]byte(variants.String), &thumbnail.Variants); err != nil {
+			return Thumbnail{}, err
+		}
+	}
+	return thumbnail, nil
+}
+
+// CreateThumbnail adds a video's new current thumbnail to its history,
+// superseding the one before it.
+func (c Client) CreateThumbnail(params CreateThumbnailParams) (Thumbnail, error) {
+	var variants *string
+	if len(params.Variants) > 0 {
+		data, err := json.Marshal(params.Variants)
+		if err != nil {
+			return Thumbnail{}, err
+		}
+		value := string(data)
+		variants = &value
+	}
+
+	id := uuid.New()
+	query := `
+	INSERT INTO thumbnails (
+		id,
+		created_at,
+		video_id,
+		asset_path,
+		bytes,
+		blurhash,
+		dominant_color,
+		phash,
+		variants
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.VideoID,
+		params.AssetPath,
+		params.Bytes,
+		params.BlurHash,
+		params.DominantColor,
+		params.PHash,
+		variants,
+	)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+
+	if err := c.SetCurrentThumbnail(params.VideoID, id); err != nil {
+		return Thumbnail{}, err
+	}
+	return c.GetThumbnail(id)
+}
+
+// CreateSupersededThumbnail adds a thumbnail that's no longer in use to a
+// video's history, for thumbnails stored before history was kept.
+func (c Client) CreateSupersededThumbnail(params CreateThumbnailParams) error {
+	query := `
+	INSERT INTO thumbnails (
+		id,
+		created_at,
+		superseded_at,
+		video_id,
+		asset_path,
+		bytes
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), params.VideoID, params.AssetPath, params.Bytes)
+	return err
+}
+
+func (c Client) GetThumbnail(id uuid.UUID) (Thumbnail, error) {
+	query := `
+	SELECT` + thumbnailColumns + `
+	FROM thumbnails
+	WHERE id = ?
+	`
+
+	thumbnail, err := scanThumbnail(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Thumbnail{}, nil
+		}
+		return Thumbnail{}, err
+	}
+
+	return thumbnail, nil
+}
+
+// GetThumbnailByAssetPath returns the history entry for a video's thumbnail
+// file, or a zero Thumbnail if it isn't in the history.
+func (c Client) GetThumbnailByAssetPath(videoID uuid.UUID, assetPath string) (Thumbnail, error) {
+	query := `
+	SELECT` + thumbnailColumns + `
+	FROM thumbnails
+	WHERE video_id = ? AND asset_path = ?
+	`
+
+	thumbnail, err := scanThumbnail(c.db.QueryRow(query, videoID, assetPath))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Thumbnail{}, nil
+		}
+		return Thumbnail{}, err
+	}
+
+	return thumbnail, nil
+}
+
+// GetThumbnails returns a video's thumbnail history, newest first.
+func (c Client) GetThumbnails(videoID uuid.UUID) ([]Thumbnail, error) {
+	query := `
+	SELECT` + thumbnailColumns + `
+	FROM thumbnails
+	WHERE video_id = ?
+	ORDER BY created_at DESC, rowid DESC
+	`
+	return c.queryThumbnails(query, videoID)
+}
+
+// GetSupersededThumbnails returns thumbnails that stopped being used more
+// than olderThan ago.
+func (c Client) GetSupersededThumbnails(olderThan time.Duration) ([]Thumbnail, error) {
+	query := `
+	SELECT` + thumbnailColumns + `
+	FROM thumbnails
+	WHERE superseded_at IS NOT NULL
+		AND superseded_at < datetime('now', ?)
+	ORDER BY superseded_at ASC
+	`
+	return c.queryThumbnails(query, fmt.Sprintf("-%d seconds", int(olderThan.Seconds())))
+}
+
+func (c Client) queryThumbnails(query string, args ...any) ([]Thumbnail, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	thumbnails := []Thumbnail{}
+	for rows.Next() {
+		thumbnail, err := scanThumbnail(rows)
+		if err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, thumbnail)
+	}
+
+	return thumbnails, nil
+}
+
+// SetCurrentThumbnail marks one of a video's thumbnails as current and
+// every other one as superseded, keeping the time it was first superseded.
+func (c Client) SetCurrentThumbnail(videoID, id uuid.UUID) error {
+	query := `
+	UPDATE thumbnails
+	SET superseded_at = CASE
+		WHEN id = ? THEN NULL
+		ELSE COALESCE(superseded_at, CURRENT_TIMESTAMP)
+	END
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, id, videoID)
+	return err
+}
+
+func (c Client) DeleteThumbnail(id uuid.UUID) error {
+	query := `
+	DELETE FROM thumbnails
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
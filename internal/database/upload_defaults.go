@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadDefaults are the settings a user's uploads get when the upload
+// doesn't ask for others. The deployment's defaults are stored under
+// uuid.Nil, and apply to whatever a user hasn't set.
+type UploadDefaults struct {
+	UserID    uuid.UUID `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+	SetUploadDefaultsParams
+}
+
+type SetUploadDefaultsParams struct {
+	// Profile names the processing profile, nil to fall back
+	Profile *string `json:"profile"`
+	// Notify is the notification channel processing results are sent on,
+	// nil to fall back
+	Notify *string `json:"notify"`
+}
+
+// SetUploadDefaults saves a user's upload defaults, or the deployment's for
+// uuid.Nil, replacing any they had.
+func (c Client) SetUploadDefaults(userID uuid.UUID, params SetUploadDefaultsParams) (UploadDefaults, error) {
+	query := `
+	INSERT INTO upload_defaults (user_id, updated_at, profile, notify)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		updated_at = excluded.updated_at,
+		profile = excluded.profile,
+		notify = excluded.notify
+	`
+	_, err := c.db.Exec(query, userID, time.Now().UTC(), params.Profile, params.Notify)
+	if err != nil {
+		return UploadDefaults{}, err
+	}
+
+	return c.GetUploadDefaults(userID)
+}
+
+// GetUploadDefaults returns a user's upload defaults, or the deployment's
+// for uuid.Nil. It returns the zero value if none were saved.
+func (c Client) GetUploadDefaults(userID uuid.UUID) (UploadDefaults, error) {
+	query := `
+	SELECT user_id, updated_at, profile, notify
+	FROM upload_defaults
+	WHERE user_id = ?
+	`
+
+	var defaults UploadDefaults
+	err := c.db.QueryRow(query, userID).Scan(
+		&defaults.UserID,
+		&defaults.UpdatedAt,
+		&defaults.Profile,
+		&defaults.Notify,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UploadDefaults{}, nil
+		}
+		return UploadDefaults{}, err
+	}
+
+	return defaults, nil
+}
+
+func (c Client) DeleteUploadDefaults(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM upload_defaults WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadSession is a video upload sent in chunks, which can pick up where
+// it left off after a dropped connection.
+type UploadSession struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Offset is how many bytes have been received, where the next chunk starts
+	Offset int64 `json:"offset"`
+	CreateUploadSessionParams
+}
+
+type CreateUploadSessionParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MediaType string    `json:"media_type"`
+	Profile   string    `json:"profile"`
+	// Notify is the notification channel the processed upload is reported on
+	Notify string `json:"notify"`
+	// Priority is the class the upload's processing job is scheduled in
+	Priority JobPriority `json:"priority"`
+	// Size is the length of the whole file, nil when it isn't known up
+	// front, such as for a stream
+	Size *int64 `json:"size"`
+	// Filename is the name of the file being uploaded, if the client gave it
+	Filename string `json:"filename"`
+	// TempPath is the scratch file the chunks are appended to
+	TempPath string `json:"-"`
+}
+
+const uploadSessionColumns = `
+		id,
+		created_at,
+		updated_at,
+		received,
+		video_id,
+		user_id,
+		expires_at,
+		media_type,
+		profile,
+		notify,
+		priority,
+		size,
+		original_filename,
+		temp_path`
+
+func scanUploadSession(row rowScanner) (UploadSession, error) {
+	var session UploadSession
+	err := row.Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.Offset,
+		&session.VideoID,
+		&session.UserID,
+		&session.ExpiresAt,
+		&session.MediaType,
+		&session.Profile,
+		&session.Notify,
+		&session.Priority,
+		&session.Size,
+		&session.Filename,
+		&session.TempPath,
+	)
+	return session, err
+}
+
+// CreateUploadSession starts an upload session, moving its video to
+// uploading if it has no file.
+func (c Client) CreateUploadSession(params CreateUploadSessionParams) (UploadSession, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	tx, err := c.db.Begin()
+	if err != nil {
+		return UploadSession{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO upload_sessions (
+		id,
+		created_at,
+		updated_at,
+		expires_at,
+		video_id,
+		user_id,
+		media_type,
+		profile,
+		notify,
+		priority,
+		size,
+		original_filename,
+		temp_path
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, id, now, now, params.ExpiresAt, params.VideoID, params.UserID,
+		params.MediaType, params.Profile, params.Notify, params.Priority, params.Size, params.Filename, params.TempPath)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	query = `
+	UPDATE videos
+	SET state = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND state IN (?, ?)
+	`
+	if _, err := tx.Exec(query, VideoStateUploading, params.VideoID, VideoStateDraft, VideoStateFailed); err != nil {
+		return UploadSession{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return UploadSession{}, err
+	}
+
+	return c.GetUploadSession(id)
+}
+
+func (c Client) GetUploadSession(id uuid.UUID) (UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE id = ?
+	`
+
+	session, err := scanUploadSession(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UploadSession{}, nil
+		}
+		return UploadSession{}, err
+	}
+
+	return session, nil
+}
+
+// GetUploadSessions returns the unexpired sessions at now, or the expired
+// ones when expired is true.
+func (c Client) GetUploadSessions(now time.Time, expired bool) ([]UploadSession, error) {
+	condition := "expires_at > ?"
+	if expired {
+		condition = "expires_at <= ?"
+	}
+	return c.getUploadSessions(condition, now)
+}
+
+func (c Client) GetUserUploadSessions(userID uuid.UUID) ([]UploadSession, error) {
+	return c.getUploadSessions("user_id = ?", userID)
+}
+
+func (c Client) getUploadSessions(condition string, value any) ([]UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE ` + condition + `
+	ORDER BY created_at
+	`
+
+	rows, err := c.db.Query(query, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UploadSession{}
+	for rows.Next() {
+		session, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// AdvanceUploadSession moves a session's offset from one position to the
+// next, reporting false without changing it if the offset isn't at from,
+// so two clients can't both append the same range.
+func (c Client) AdvanceUploadSession(id uuid.UUID, from, to int64) (bool, error) {
+	query := `
+	UPDATE upload_sessions
+	SET received = ?, updated_at = ?
+	WHERE id = ? AND received = ?
+	`
+	result, err := c.db.Exec(query, to, time.Now().UTC(), id, from)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// DeleteUploadSession deletes an upload session. A video still uploading
+// goes back to draft once it has no sessions left; one whose upload was
+// completed has moved on already.
+func (c Client) DeleteUploadSession(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var videoID uuid.UUID
+	err = tx.QueryRow("SELECT video_id FROM upload_sessions WHERE id = ?", id).Scan(&videoID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM upload_sessions WHERE id = ?", id); err != nil {
+		return err
+	}
+	query := `
+	UPDATE videos
+	SET state = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND state = ? AND NOT EXISTS (SELECT 1 FROM upload_sessions WHERE video_id = videos.id)
+	`
+	if _, err := tx.Exec(query, VideoStateDraft, videoID, VideoStateUploading); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) DeleteUserUploadSessions(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM upload_sessions WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserBucket is a bucket in a user's own AWS account that their videos are
+// stored in, reached by assuming a role they created for this service.
+type UserBucket struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	SetUserBucketParams
+}
+
+type SetUserBucketParams struct {
+	Bucket  string `json:"bucket"`
+	Region  string `json:"region"`
+	RoleARN string `json:"role_arn"`
+	// BaseURL is where the bucket's objects are served from, such as a
+	// CloudFront distribution in front of it. Empty means the bucket itself.
+	BaseURL string `json:"base_url"`
+}
+
+const userBucketColumns = `
+		user_id,
+		created_at,
+		updated_at,
+		bucket,
+		region,
+		role_arn,
+		base_url`
+
+func scanUserBucket(row rowScanner) (UserBucket, error) {
+	var bucket UserBucket
+	err := row.Scan(
+		&bucket.UserID,
+		&bucket.CreatedAt,
+		&bucket.UpdatedAt,
+		&bucket.Bucket,
+		&bucket.Region,
+		&bucket.RoleARN,
+		&bucket.BaseURL,
+	)
+	return bucket, err
+}
+
+// SetUserBucket registers a user's bucket, replacing the one they had.
+func (c Client) SetUserBucket(userID uuid.UUID, params SetUserBucketParams) (UserBucket, error) {
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO user_buckets (
+		user_id,
+		created_at,
+		updated_at,
+		bucket,
+		region,
+		role_arn,
+		base_url
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		updated_at = excluded.updated_at,
+		bucket = excluded.bucket,
+		region = excluded.region,
+		role_arn = excluded.role_arn,
+		base_url = excluded.base_url
+	`
+	_, err := c.db.Exec(
+		query,
+		userID,
+		now,
+		now,
+		params.Bucket,
+		params.Region,
+		params.RoleARN,
+		params.BaseURL,
+	)
+	if err != nil {
+		return UserBucket{}, err
+	}
+
+	return c.GetUserBucket(userID)
+}
+
+func (c Client) GetUserBucket(userID uuid.UUID) (UserBucket, error) {
+	return c.getUserBucket("user_id", userID)
+}
+
+// GetUserBucketByName returns the registration of a bucket, or the zero
+// value if it isn't any user's.
+func (c Client) GetUserBucketByName(bucket string) (UserBucket, error) {
+	return c.getUserBucket("bucket", bucket)
+}
+
+func (c Client) getUserBucket(column string, value any) (UserBucket, error) {
+	query := `
+	SELECT` + userBucketColumns + `
+	FROM user_buckets
+	WHERE ` + column + ` = ?
+	`
+
+	bucket, err := scanUserBucket(c.db.QueryRow(query, value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserBucket{}, nil
+		}
+		return UserBucket{}, err
+	}
+
+	return bucket, nil
+}
+
+func (c Client) DeleteUserBucket(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM user_buckets WHERE user_id = ?", userID)
+	return err
+}
@@ -12,6 +12,16 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set once the user has asked for their account to be
+	// deleted; the account stays locked until its data has been purged.
+	DeletedAt *time.Time `json:"-"`
+	// TenantID is the organization the user belongs to, if any.
+	TenantID uuid.NullUUID `json:"tenant_id"`
+	// AvatarURL is the user's profile picture, if they've uploaded one.
+	AvatarURL *string `json:"avatar_url"`
+	// Plan names the tier the user is on, which sets limits such as how
+	// large a video they can upload; empty for the default.
+	Plan string `json:"plan"`
 	CreateUserParams
 }
 
@@ -53,13 +63,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, deleted_at, tenant_id, avatar_url, plan
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.DeletedAt, &user.TenantID, &user.AvatarURL, &user.Plan)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,15 +85,17 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.password, u.tenant_id, u.avatar_url, u.plan
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
+			AND rt.revoked_at IS NULL
+			AND u.deleted_at IS NULL
 	`
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password, &user.TenantID, &user.AvatarURL, &user.Plan)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -117,13 +129,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, deleted_at, tenant_id, avatar_url, plan
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.DeletedAt, &user.TenantID, &user.AvatarURL, &user.Plan)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -145,3 +157,112 @@ func (c Client) DeleteUser(id uuid.UUID) error {
 	_, err := c.db.Exec(query, id.String())
 	return err
 }
+
+// Queries counting the records of a user that purging their account deletes or erases
+var userRecordQueries = map[string]string{
+	"api_keys":              "SELECT COUNT(*) FROM api_keys WHERE user_id = ?",
+	"bulk_operations":       "SELECT COUNT(*) FROM bulk_operations WHERE user_id = ?",
+	"comments":              "SELECT COUNT(*) FROM comments WHERE user_id = ? AND deleted_at IS NULL",
+	"dead_letters":          "SELECT COUNT(*) FROM dead_letters WHERE user_id = ?",
+	"exports":               "SELECT COUNT(*) FROM exports WHERE user_id = ?",
+	"jobs":                  "SELECT COUNT(*) FROM jobs WHERE user_id = ?",
+	"notification_settings": "SELECT COUNT(*) FROM notification_settings WHERE user_id = ?",
+	"refresh_tokens":        "SELECT COUNT(*) FROM refresh_tokens WHERE user_id = ?",
+	"storage_objects":       "SELECT COUNT(*) FROM storage_objects WHERE user_id = ? AND deleted_at IS NULL",
+	"stripe_customers":      "SELECT COUNT(*) FROM stripe_customers WHERE user_id = ?",
+	"upload_defaults":       "SELECT COUNT(*) FROM upload_defaults WHERE user_id = ?",
+	"upload_sessions":       "SELECT COUNT(*) FROM upload_sessions WHERE user_id = ?",
+	"user_buckets":          "SELECT COUNT(*) FROM user_buckets WHERE user_id = ?",
+	"users":                 "SELECT COUNT(*) FROM users WHERE id = ?",
+	likesTable:              "SELECT COUNT(*) FROM " + likesTable + " WHERE user_id = ?",
+	watchLaterTable:         "SELECT COUNT(*) FROM " + watchLaterTable + " WHERE user_id = ?",
+}
+
+// CountUserRecords returns how many records of a user each table has,
+// leaving out their videos' own records. Comments are erased rather than
+// deleted, and ledger entries marked deleted, but they're counted too.
+func (c Client) CountUserRecords(userID uuid.UUID) (map[string]int, error) {
+	counts := map[string]int{}
+	for table, query := range userRecordQueries {
+		var count int
+		if err := c.db.QueryRow(query, userID.String()).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			counts[table] = count
+		}
+	}
+	return counts, nil
+}
+
+// LockUser marks a user as deleted, locking them out while their data is purged.
+func (c Client) LockUser(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+// GetLockedUserIDs returns users whose deletion was requested but hasn't finished.
+func (c Client) GetLockedUserIDs() ([]uuid.UUID, error) {
+	query := `
+		SELECT id
+		FROM users
+		WHERE deleted_at IS NOT NULL
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, userID)
+	}
+
+	return ids, nil
+}
+
+func (c Client) SetUserTenant(id uuid.UUID, tenantID uuid.NullUUID) error {
+	query := `
+		UPDATE users
+		SET tenant_id = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, tenantID, id.String())
+	return err
+}
+
+// SetUserPlan moves a user to a plan, or back to the default when plan is empty.
+func (c Client) SetUserPlan(id uuid.UUID, plan string) error {
+	query := `
+		UPDATE users
+		SET plan = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, plan, id.String())
+	return err
+}
+
+// SetUserAvatar points a user at a new avatar, or clears it when avatarURL is nil.
+func (c Client) SetUserAvatar(id uuid.UUID, avatarURL *string) error {
+	query := `
+		UPDATE users
+		SET avatar_url = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, avatarURL, id.String())
+	return err
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ArchiveState string
+
+const (
+	// ArchiveStateArchived videos are in cold storage and can't be played
+	ArchiveStateArchived ArchiveState = "archived"
+	// ArchiveStateRestoring videos are waiting on a restore to finish
+	ArchiveStateRestoring ArchiveState = "restoring"
+	// ArchiveStateRestored videos have a temporary copy that can be played
+	// until RestoredUntil
+	ArchiveStateRestored ArchiveState = "restored"
+)
+
+// VideoArchive records that a video's file was moved to cold storage. It
+// only applies while the video's file is still the archived object, so a
+// new upload is playable straight away.
+type VideoArchive struct {
+	VideoID      uuid.UUID    `json:"video_id"`
+	CreatedAt    time.Time    `json:"archived_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	Bucket       string       `json:"-"`
+	ObjectKey    string       `json:"-"`
+	StorageClass string       `json:"storage_class"`
+	State        ArchiveState `json:"state"`
+	RestoreTier  *string      `json:"restore_tier"`
+	// RestoreRequestedAt is when the latest restore was asked for
+	RestoreRequestedAt *time.Time `json:"restore_requested_at"`
+	RestoredUntil      *time.Time `json:"restored_until"`
+}
+
+// Playable reports whether the archived object can be read at now.
+func (a VideoArchive) Playable(now time.Time) bool {
+	return a.State == ArchiveStateRestored && a.RestoredUntil != nil && now.Before(*a.RestoredUntil)
+}
+
+const videoArchiveColumns = `
+		video_id,
+		created_at,
+		updated_at,
+		bucket,
+		object_key,
+		storage_class,
+		state,
+		restore_tier,
+		restore_requested_at,
+		restored_until`
+
+func scanVideoArchive(row rowScanner) (VideoArchive, error) {
+	var archive VideoArchive
+	err := row.Scan(
+		&archive.VideoID,
+		&archive.CreatedAt,
+		&archive.UpdatedAt,
+		&archive.Bucket,
+		&archive.ObjectKey,
+		&archive.StorageClass,
+		&archive.State,
+		&archive.RestoreTier,
+		&archive.RestoreRequestedAt,
+		&archive.RestoredUntil,
+	)
+	return archive, err
+}
+
+// ArchiveVideo records that a video's file was moved to a cold storage
+// class, replacing any earlier archive of the video.
+func (c Client) ArchiveVideo(videoID uuid.UUID, bucket, key, storageClass string) (VideoArchive, error) {
+	query := `
+	INSERT INTO video_archives (video_id, created_at, updated_at, bucket, object_key, storage_class, state)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		created_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP,
+		bucket = excluded.bucket,
+		object_key = excluded.object_key,
+		storage_class = excluded.storage_class,
+		state = excluded.state,
+		restore_tier = NULL,
+		restore_requested_at = NULL,
+		restored_until = NULL
+	`
+	if _, err := c.db.Exec(query, videoID, bucket, key, storageClass, ArchiveStateArchived); err != nil {
+		return VideoArchive{}, err
+	}
+	archive, err := c.GetVideoArchive(videoID)
+	if err != nil {
+		return VideoArchive{}, err
+	}
+	return *archive, nil
+}
+
+// GetVideoArchive returns a video's archive record, or nil if it was never
+// archived.
+func (c Client) GetVideoArchive(videoID uuid.UUID) (*VideoArchive, error) {
+	query := `
+	SELECT` + videoArchiveColumns + `
+	FROM video_archives
+	WHERE video_id = ?
+	`
+	archive, err := scanVideoArchive(c.db.QueryRow(query, videoID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// GetObjectArchive returns the archive record of a stored object, or nil if
+// it isn't archived.
+func (c Client) GetObjectArchive(bucket, key string) (*VideoArchive, error) {
+	query := `
+	SELECT` + videoArchiveColumns + `
+	FROM video_archives
+	WHERE bucket = ? AND object_key = ?
+	`
+	archive, err := scanVideoArchive(c.db.QueryRow(query, bucket, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// GetRestoringVideoArchives returns the archives waiting on a restore.
+func (c Client) GetRestoringVideoArchives() ([]VideoArchive, error) {
+	query := `
+	SELECT` + videoArchiveColumns + `
+	FROM video_archives
+	WHERE state = ?
+	ORDER BY restore_requested_at ASC
+	`
+	rows, err := c.db.Query(query, ArchiveStateRestoring)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := []VideoArchive{}
+	for rows.Next() {
+		archive, err := scanVideoArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return archives, rows.Err()
+}
+
+// StartVideoRestore records that a restore of a video's archived file was
+// asked for.
+func (c Client) StartVideoRestore(videoID uuid.UUID, tier string) error {
+	query := `
+	UPDATE video_archives
+	SET state = ?, restore_tier = ?, restore_requested_at = ?, restored_until = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, ArchiveStateRestoring, tier, time.Now().UTC(), videoID)
+	return err
+}
+
+// FinishVideoRestore records that a video's restored copy is readable
+// until restoredUntil. It returns false if the restore was already
+// recorded as finished, so its owner is only told once.
+func (c Client) FinishVideoRestore(videoID uuid.UUID, restoredUntil time.Time) (bool, error) {
+	query := `
+	UPDATE video_archives
+	SET state = ?, restored_until = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE video_id = ? AND state = ?
+	`
+	result, err := c.db.Exec(query, ArchiveStateRestored, restoredUntil, videoID, ArchiveStateRestoring)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ExpireVideoRestores moves archives whose restored copies have expired
+// back to archived.
+func (c Client) ExpireVideoRestores(now time.Time) error {
+	query := `
+	UPDATE video_archives
+	SET state = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE state = ? AND restored_until <= ?
+	`
+	_, err := c.db.Exec(query, ArchiveStateArchived, ArchiveStateRestored, now)
+	return err
+}
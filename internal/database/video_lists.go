@@ -0,0 +1,154 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The per-user video lists
+const (
+	likesTable      = "video_likes"
+	watchLaterTable = "watch_later"
+)
+
+// Videos are counted this many at a time, well under SQLite's variable limit
+const countBatchSize = 500
+
+func (c Client) LikeVideo(userID, videoID uuid.UUID) error {
+	return c.addToList(likesTable, userID, videoID)
+}
+
+func (c Client) UnlikeVideo(userID, videoID uuid.UUID) error {
+	return c.removeFromList(likesTable, userID, videoID)
+}
+
+// GetLikedVideos returns the videos a user has liked, most recent like first.
+func (c Client) GetLikedVideos(userID uuid.UUID) ([]Video, error) {
+	return c.getList(likesTable, userID)
+}
+
+func (c Client) AddWatchLater(userID, videoID uuid.UUID) error {
+	return c.addToList(watchLaterTable, userID, videoID)
+}
+
+func (c Client) RemoveWatchLater(userID, videoID uuid.UUID) error {
+	return c.removeFromList(watchLaterTable, userID, videoID)
+}
+
+// GetWatchLater returns a user's watch-later list, most recently added first.
+func (c Client) GetWatchLater(userID uuid.UUID) ([]Video, error) {
+	return c.getList(watchLaterTable, userID)
+}
+
+// DeleteUserVideoLists removes a user's likes and watch-later list.
+func (c Client) DeleteUserVideoLists(userID uuid.UUID) error {
+	for _, table := range []string{likesTable, watchLaterTable} {
+		if _, err := c.db.Exec("DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adding a video that's already on the list keeps its original position
+func (c Client) addToList(table string, userID, videoID uuid.UUID) error {
+	query := `
+	INSERT OR IGNORE INTO ` + table + ` (user_id, video_id, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, userID, videoID)
+	return err
+}
+
+func (c Client) removeFromList(table string, userID, videoID uuid.UUID) error {
+	query := `
+	DELETE FROM ` + table + `
+	WHERE user_id = ? AND video_id = ?
+	`
+	_, err := c.db.Exec(query, userID, videoID)
+	return err
+}
+
+func (c Client) getList(table string, userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	JOIN (
+		SELECT video_id, created_at AS listed_at, rowid AS listed_order
+		FROM ` + table + `
+		WHERE user_id = ?
+	) list ON list.video_id = videos.id
+	ORDER BY list.listed_at DESC, list.listed_order DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return videos, c.LoadVideoCounts(videos)
+}
+
+// LoadVideoCounts fills in the like and watch-later counts of videos, with
+// one query per list for each batch of videos rather than one per video.
+func (c Client) LoadVideoCounts(videos []Video) error {
+	for start := 0; start < len(videos); start += countBatchSize {
+		batch := videos[start:min(start+countBatchSize, len(videos))]
+
+		index := make(map[uuid.UUID]*Video, len(batch))
+		args := make([]any, len(batch))
+		for i := range batch {
+			index[batch[i].ID] = &batch[i]
+			args[i] = batch[i].ID
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+
+		for _, table := range []string{likesTable, watchLaterTable} {
+			query := `
+			SELECT video_id, COUNT(*)
+			FROM ` + table + `
+			WHERE video_id IN (` + placeholders + `)
+			GROUP BY video_id
+			`
+			rows, err := c.db.Query(query, args...)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var videoID uuid.UUID
+				var count int
+				if err := rows.Scan(&videoID, &count); err != nil {
+					rows.Close()
+					return err
+				}
+				if video, ok := index[videoID]; ok {
+					if table == likesTable {
+						video.LikeCount = count
+					} else {
+						video.WatchLaterCount = count
+					}
+				}
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
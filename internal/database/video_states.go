@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidVideoTransition is returned when a video can't move from the
+// state it's in to the one asked for.
+var ErrInvalidVideoTransition = errors.New("invalid video state transition")
+
+// VideoState is where a video is in its life.
+type VideoState string
+
+const (
+	// VideoStateDraft videos have been created but have no file
+	VideoStateDraft VideoState = "draft"
+	// VideoStateUploading videos have a resumable upload under way
+	VideoStateUploading VideoState = "uploading"
+	// VideoStateProcessing videos have a job processing their first file
+	VideoStateProcessing VideoState = "processing"
+	// VideoStateReady videos have a file that can be played
+	VideoStateReady VideoState = "ready"
+	// VideoStateFailed videos' processing failed, leaving them without a file
+	VideoStateFailed VideoState = "failed"
+	// VideoStateArchived videos' file is in cold storage and has to be
+	// restored to be played
+	VideoStateArchived VideoState = "archived"
+	// VideoStateQuarantined videos' upload was flagged as malware. They
+	// can't take another upload, only be deleted.
+	VideoStateQuarantined VideoState = "quarantined"
+)
+
+// VideoStates are the states, in the order a video usually goes through them.
+var VideoStates = []VideoState{
+	VideoStateDraft,
+	VideoStateUploading,
+	VideoStateProcessing,
+	VideoStateReady,
+	VideoStateFailed,
+	VideoStateArchived,
+	VideoStateQuarantined,
+}
+
+// videoTransitions are the states a video can move to from each state.
+// Videos with a file keep their state while a new upload is processed,
+// since they go on playing the old file until the new one replaces it.
+var videoTransitions = map[VideoState][]VideoState{
+	VideoStateDraft:      {VideoStateUploading, VideoStateProcessing, VideoStateQuarantined},
+	VideoStateUploading:  {VideoStateDraft, VideoStateProcessing, VideoStateQuarantined},
+	VideoStateProcessing: {VideoStateReady, VideoStateFailed, VideoStateDraft},
+	VideoStateReady:      {VideoStateArchived},
+	VideoStateFailed:     {VideoStateUploading, VideoStateProcessing, VideoStateQuarantined},
+	VideoStateArchived:   {VideoStateReady},
+}
+
+// Valid reports whether s is one of the states.
+func (s VideoState) Valid() bool {
+	return slices.Contains(VideoStates, s)
+}
+
+// CanTransition reports whether a video in state s can move to state to.
+// Staying in the same state is always allowed.
+func (s VideoState) CanTransition(to VideoState) bool {
+	return s == to || slices.Contains(videoTransitions[s], to)
+}
+
+// AwaitsFile reports whether a video in state s still needs its file sent.
+func (s VideoState) AwaitsFile() bool {
+	return s == VideoStateDraft || s == VideoStateUploading || s == VideoStateFailed
+}
+
+// AcceptsUploads reports whether a video in state s can be given a file.
+func (s VideoState) AcceptsUploads() bool {
+	return s != VideoStateQuarantined
+}
+
+// TransitionVideo moves a video to state to, if it can get there from the
+// state it's in. It returns ErrInvalidVideoTransition, saying which state
+// the video is in, if it can't. Moving a video to the state it's in, or one
+// that no longer exists, does nothing.
+func (c Client) TransitionVideo(id uuid.UUID, to VideoState) error {
+	from := []any{}
+	for state, targets := range videoTransitions {
+		if slices.Contains(targets, to) {
+			from = append(from, state)
+		}
+	}
+	if len(from) > 0 {
+		query := `
+		UPDATE videos
+		SET state = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND state IN (?` + strings.Repeat(", ?", len(from)-1) + `)
+		`
+		result, err := c.db.Exec(query, append([]any{to, id}, from...)...)
+		if err != nil {
+			return err
+		}
+		moved, err := result.RowsAffected()
+		if err != nil || moved > 0 {
+			return err
+		}
+	}
+
+	var current VideoState
+	err := c.db.QueryRow(`SELECT state FROM videos WHERE id = ?`, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current != to {
+		return fmt.Errorf("%w: video is %s, so it can't be %s", ErrInvalidVideoTransition, current, to)
+	}
+	return nil
+}
+
+// startVideoProcessing moves a video without a file to processing as a job
+// for it is queued, refusing videos that can't take an upload. Videos with
+// a file keep their state.
+func startVideoProcessing(tx *sql.Tx, videoID uuid.UUID) error {
+	var state VideoState
+	err := tx.QueryRow(`SELECT state FROM videos WHERE id = ?`, videoID).Scan(&state)
+	if err != nil {
+		return err
+	}
+	if !state.AcceptsUploads() {
+		return fmt.Errorf("%w: video is %s, so it can't be processed", ErrInvalidVideoTransition, state)
+	}
+	if state == VideoStateProcessing || !state.CanTransition(VideoStateProcessing) {
+		return nil
+	}
+	query := `
+	UPDATE videos
+	SET state = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, VideoStateProcessing, videoID)
+	return err
+}
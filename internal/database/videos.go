@@ -2,44 +2,288 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrExternalIDTaken is returned when another of the user's videos already
+// has the external ID.
+var ErrExternalIDTaken = errors.New("external ID is already in use")
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                     uuid.UUID `json:"id"`
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at"`
+	ThumbnailURL           *string   `json:"thumbnail_url"`
+	ThumbnailBlurHash      *string   `json:"thumbnail_blurhash"`
+	ThumbnailDominantColor *string   `json:"thumbnail_dominant_color"`
+	ThumbnailPHash         *string   `json:"-"`
+	// ThumbnailVariants are crops of the thumbnail by aspect ratio, like
+	// "16:9", empty for thumbnails stored without them
+	ThumbnailVariants map[string]string `json:"thumbnail_variants"`
+	VideoURL          *string           `json:"video_url"`
+	State             VideoState        `json:"state"`
+	Visibility        Visibility        `json:"visibility"`
+	Tags              []string          `json:"tags"`
+	VideoFingerprint  *string           `json:"-"`
+	Duration          *float64          `json:"duration"`
+	SourceSHA256      *string           `json:"-"`
+	ProcessingProfile *string           `json:"processing_profile"`
+	// Revision goes up with every update, so clients can tell whether the
+	// video changed since they read it
+	Revision int `json:"-"`
+	// Upload is only for the owner, so it's left out of the video's JSON
+	Upload VideoUpload `json:"-"`
+	// Metadata is the owner's own keys and values, such as IDs in other
+	// systems. It's only for the owner too.
+	Metadata map[string]string `json:"-"`
+	// Filled in by LoadVideoCounts
+	LikeCount       int `json:"like_count"`
+	WatchLaterCount int `json:"watch_later_count"`
 	CreateVideoParams
 }
 
+// Visibility is who can find a video.
+type Visibility string
+
+const (
+	// VisibilityPublic videos are listed in their owner's feeds
+	VisibilityPublic Visibility = "public"
+	// VisibilityUnlisted videos can be watched by anyone with a link, but
+	// aren't listed
+	VisibilityUnlisted Visibility = "unlisted"
+	// VisibilityPrivate videos can only be seen by their owner, and through
+	// share links and clips the owner makes
+	VisibilityPrivate Visibility = "private"
+)
+
+// Valid reports whether v is one of the visibilities.
+func (v Visibility) Valid() bool {
+	return v == VisibilityPublic || v == VisibilityUnlisted || v == VisibilityPrivate
+}
+
+// VideoUpload is where a video's file came from, as the uploader sent it.
+// Fields are null for videos uploaded before it was recorded, and for what
+// an upload didn't say.
+type VideoUpload struct {
+	// OriginalFilename is the file's name on the uploader's device
+	OriginalFilename *string `json:"original_filename"`
+	// Size is the file's size in bytes as the client gave it
+	Size       *int64     `json:"size"`
+	UploadedAt *time.Time `json:"uploaded_at"`
+	IP         *string    `json:"ip"`
+	UserAgent  *string    `json:"user_agent"`
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// TenantID namespaces the video's storage and counts it against the
+	// tenant's quota. It's taken from the owner's access token, never the body.
+	TenantID uuid.NullUUID `json:"-"`
+	// ExternalID is the video's ID in another system, unique among its
+	// owner's videos. It's only shown to the owner.
+	ExternalID *string `json:"-"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_blurhash,
+		thumbnail_dominant_color,
+		thumbnail_phash,
+		thumbnail_variants,
 		video_url,
-		user_id
+		state,
+		video_fingerprint,
+		duration,
+		source_sha256,
+		processing_profile,
+		user_id,
+		tenant_id,
+		revision,
+		original_filename,
+		upload_size,
+		uploaded_at,
+		uploader_ip,
+		uploader_user_agent,
+		metadata,
+		external_id,
+		visibility,
+		tags`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var variants, metadata, tags sql.NullString
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailBlurHash,
+		&video.ThumbnailDominantColor,
+		&video.ThumbnailPHash,
+		&variants,
+		&video.VideoURL,
+		&video.State,
+		&video.VideoFingerprint,
+		&video.Duration,
+		&video.SourceSHA256,
+		&video.ProcessingProfile,
+		&video.UserID,
+		&video.TenantID,
+		&video.Revision,
+		&video.Upload.OriginalFilename,
+		&video.Upload.Size,
+		&video.Upload.UploadedAt,
+		&video.Upload.IP,
+		&video.Upload.UserAgent,
+		&metadata,
+		&video.ExternalID,
+		&video.Visibility,
+		&tags,
+	)
+	if err != nil {
+		return Video{}, err
+	}
+
+	video.ThumbnailVariants = map[string]string{}
+	if variants.Valid && variants.String != "" {
+		if err := json.Unmarshal([]byte(variants.String), &video.ThumbnailVariants); err != nil {
+			return Video{}, err
+		}
+	}
+	video.Tags = []string{}
+	if tags.Valid && tags.String != "" {
+		if err := json.Unmarshal([]byte(tags.String), &video.Tags); err != nil {
+			return Video{}, err
+		}
+	}
+	video.Metadata = map[string]string{}
+	if metadata.Valid && metadata.String != "" {
+		if err := json.Unmarshal([]byte(metadata.String), &video.Metadata); err != nil {
+			return Video{}, err
+		}
+	}
+	return video, nil
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	return c.GetFilteredVideos(userID, VideoFilter{})
+}
+
+// VideoFilter narrows a list of videos to those that match all of its
+// conditions. Its zero value matches every video.
+type VideoFilter struct {
+	Metadata []MetadataFilter
+	// Tags are tags the videos must all have
+	Tags []string
+	// Visibility is the visibility the videos must have, if set
+	Visibility Visibility
+	// State is the state the videos must be in, if set
+	State VideoState
+}
+
+// MetadataFilter matches videos whose metadata has Key, set to Value if
+// Value isn't nil.
+type MetadataFilter struct {
+	Key   string
+	Value *string
+}
+
+// GetFilteredVideos returns a user's videos that match filter. Metadata keys
+// are looked up as JSON object members, so they mustn't contain quotes.
+func (c Client) GetFilteredVideos(userID uuid.UUID, filter VideoFilter) ([]Video, error) {
+	videos := []Video{}
+	err := c.EachFilteredVideo(userID, filter, func(video Video) error {
+		videos = append(videos, video)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+// EachFilteredVideo calls fn with each of a user's videos that match filter,
+// newest first, reading them one at a time so a whole catalog needn't be
+// held in memory. It stops at the first error fn returns.
+func (c Client) EachFilteredVideo(userID uuid.UUID, filter VideoFilter, fn func(Video) error) error {
+	conditions := ""
+	args := []any{userID}
+	for _, tag := range filter.Tags {
+		conditions += " AND EXISTS (SELECT 1 FROM json_each(videos.tags) WHERE json_each.value = ?)"
+		args = append(args, tag)
+	}
+	if filter.Visibility != "" {
+		conditions += " AND visibility = ?"
+		args = append(args, filter.Visibility)
+	}
+	if filter.State != "" {
+		conditions += " AND state = ?"
+		args = append(args, filter.State)
+	}
+	for _, filter := range filter.Metadata {
+		keyPath := `$."` + filter.Key + `"`
+		if filter.Value == nil {
+			conditions += " AND json_type(metadata, ?) IS NOT NULL"
+			args = append(args, keyPath)
+		} else {
+			conditions += " AND json_extract(metadata, ?) = ?"
+			args = append(args, keyPath, *filter.Value)
+		}
+	}
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
+	WHERE user_id = ?` + conditions + `
 	ORDER BY created_at DESC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(video); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetUploadedVideos returns every video, for all users, that has a stored file.
+func (c Client) GetUploadedVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_url IS NOT NULL
+	ORDER BY created_at ASC
+	`
+
+	rows, err := c.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -47,25 +291,20 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
+// CreateVideo creates a draft video. Videos of a tenant are only created
+// while it has room for them under its MaxVideos, counted in the same
+// statement so concurrent creates can't go over it; ErrTenantFull is
+// returned when it doesn't.
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
 	query := `
@@ -75,42 +314,41 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		tenant_id,
+		external_id,
+		state
+	)
+	SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?
+	WHERE NOT EXISTS (
+		SELECT 1 FROM tenants
+		WHERE id = ? AND max_videos IS NOT NULL
+		AND max_videos <= (SELECT COUNT(*) FROM videos WHERE tenant_id = tenants.id)
+	)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	result, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.TenantID, params.ExternalID, VideoStateDraft, params.TenantID)
+	if err != nil {
+		return Video{}, externalIDError(err)
+	}
+	created, err := result.RowsAffected()
 	if err != nil {
 		return Video{}, err
 	}
+	if created == 0 {
+		return Video{}, ErrTenantFull
+	}
 
 	return c.GetVideo(id)
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,15 +359,75 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// GetVideoByExternalID returns the user's video with an external ID, or the
+// zero Video if they have none.
+func (c Client) GetVideoByExternalID(userID uuid.UUID, externalID string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND external_id = ?
+	`
+
+	video, err := scanVideo(c.db.QueryRow(query, userID, externalID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+
+	return video, nil
+}
+
+// SetVideoExternalID sets or, with nil, removes a video's external ID.
+func (c Client) SetVideoExternalID(id uuid.UUID, externalID *string) error {
+	query := `
+	UPDATE videos
+	SET external_id = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, externalID, id)
+	return externalIDError(err)
+}
+
+// externalIDError turns a clash on the unique index of external IDs into
+// ErrExternalIDTaken
+func externalIDError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrExternalIDTaken
+	}
+	return err
+}
+
 func (c Client) UpdateVideo(video Video) error {
+	var variants *string
+	if len(video.ThumbnailVariants) > 0 {
+		data, err := json.Marshal(video.ThumbnailVariants)
+		if err != nil {
+			return err
+		}
+		value := string(data)
+		variants = &value
+	}
+
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_blurhash = ?,
+		thumbnail_dominant_color = ?,
+		thumbnail_phash = ?,
+		thumbnail_variants = ?,
 		video_url = ?,
-		user_id = ?
+		video_fingerprint = ?,
+		duration = ?,
+		source_sha256 = ?,
+		processing_profile = ?,
+		user_id = ?,
+		revision = revision + 1
 	WHERE id = ?
 	`
 
@@ -137,15 +435,160 @@ func (c Client) UpdateVideo(video Video) error {
 		query,
 		video.Title,
 		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
+		video.ThumbnailURL,
+		video.ThumbnailBlurHash,
+		video.ThumbnailDominantColor,
+		video.ThumbnailPHash,
+		variants,
+		video.VideoURL,
+		video.VideoFingerprint,
+		video.Duration,
+		video.SourceSHA256,
+		video.ProcessingProfile,
 		video.UserID,
 		video.ID,
 	)
 	return err
 }
 
+// SetVideoUpload records where a video's file came from. It isn't part of
+// the video's metadata, so its revision stays the same.
+func (c Client) SetVideoUpload(id uuid.UUID, upload VideoUpload) error {
+	query := `
+	UPDATE videos
+	SET original_filename = ?, upload_size = ?, uploaded_at = ?, uploader_ip = ?, uploader_user_agent = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, upload.OriginalFilename, upload.Size, upload.UploadedAt, upload.IP, upload.UserAgent, id)
+	return err
+}
+
+// SetVideoMetadata replaces a video's metadata, if the video is still at
+// revision. It reports false without changing anything if it isn't, so
+// concurrent changes to different keys can't overwrite each other.
+func (c Client) SetVideoMetadata(id uuid.UUID, metadata map[string]string, revision int) (bool, error) {
+	var encoded *string
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return false, err
+		}
+		value := string(data)
+		encoded = &value
+	}
+
+	query := `
+	UPDATE videos
+	SET metadata = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revision = ?
+	`
+	result, err := c.db.Exec(query, encoded, id, revision)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// VideoDetails are the parts of a video its owner edits directly.
+type VideoDetails struct {
+	Title       string
+	Description string
+	Visibility  Visibility
+	Tags        []string
+}
+
+// UpdateVideoDetails sets a video's details. With revision set, it reports
+// false without changing anything if the video has been updated since that
+// revision, so concurrent edits can't overwrite each other.
+func (c Client) UpdateVideoDetails(id uuid.UUID, details VideoDetails, revision *int) (bool, error) {
+	tags, err := encodeTags(details.Tags)
+	if err != nil {
+		return false, err
+	}
+	query := `
+	UPDATE videos
+	SET title = ?, description = ?, visibility = ?, tags = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND (? IS NULL OR revision = ?)
+	`
+	result, err := c.db.Exec(query, details.Title, details.Description, details.Visibility, tags, id, revision, revision)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// SetVideoVisibility changes who can find a video.
+func (c Client) SetVideoVisibility(id uuid.UUID, visibility Visibility) error {
+	query := `
+	UPDATE videos
+	SET visibility = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, visibility, id)
+	return err
+}
+
+// AddVideoTag adds a tag to the end of a video's tags, unless the video
+// already has it or has maxTags tags. It reports whether the tag was added.
+func (c Client) AddVideoTag(id uuid.UUID, tag string, maxTags int) (bool, error) {
+	query := `
+	UPDATE videos
+	SET tags = json_insert(COALESCE(tags, '[]'), '$[#]', ?), revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+		AND json_array_length(COALESCE(tags, '[]')) < ?
+		AND NOT EXISTS (SELECT 1 FROM json_each(COALESCE(videos.tags, '[]')) WHERE json_each.value = ?)
+	`
+	result, err := c.db.Exec(query, tag, id, maxTags, tag)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func encodeTags(tags []string) (*string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	value := string(data)
+	return &value, nil
+}
+
+// Tables holding records of a video, deleted along with it
+var videoRecordTables = []string{
+	"chapters",
+	"clips",
+	"share_links",
+	"thumbnails",
+	"comments",
+	"video_likes",
+	"watch_later",
+	"hls_keys",
+	"video_archives",
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	for _, table := range videoRecordTables {
+		if _, err := c.db.Exec("DELETE FROM "+table+" WHERE video_id = ?", id); err != nil {
+			return err
+		}
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
@@ -153,3 +596,104 @@ func (c Client) DeleteVideo(id uuid.UUID) error {
 	_, err := c.db.Exec(query, id)
 	return err
 }
+
+// CountVideoRecords returns how many records of a video each table has,
+// which is what DeleteVideo would delete.
+func (c Client) CountVideoRecords(id uuid.UUID) (map[string]int, error) {
+	counts := map[string]int{}
+	count := func(table, column string) error {
+		var n int
+		if err := c.db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+column+" = ?", id).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			counts[table] = n
+		}
+		return nil
+	}
+	for _, table := range videoRecordTables {
+		if err := count(table, "video_id"); err != nil {
+			return nil, err
+		}
+	}
+	if err := count("videos", "id"); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// GetEmbedOrigins returns the origins a video may be embedded from, empty
+// if it can't be embedded.
+func (c Client) GetEmbedOrigins(id uuid.UUID) ([]string, error) {
+	var encoded sql.NullString
+	err := c.db.QueryRow("SELECT embed_origins FROM videos WHERE id = ?", id).Scan(&encoded)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	origins := []string{}
+	if encoded.Valid && encoded.String != "" {
+		if err := json.Unmarshal([]byte(encoded.String), &origins); err != nil {
+			return nil, err
+		}
+	}
+	return origins, nil
+}
+
+// SetEmbedOrigins replaces the origins a video may be embedded from.
+func (c Client) SetEmbedOrigins(id uuid.UUID, origins []string) error {
+	var encoded *string
+	if len(origins) > 0 {
+		data, err := json.Marshal(origins)
+		if err != nil {
+			return err
+		}
+		value := string(data)
+		encoded = &value
+	}
+	_, err := c.db.Exec("UPDATE videos SET embed_origins = ? WHERE id = ?", encoded, id)
+	return err
+}
+
+// AccessRules limit where a video can be watched from. A viewer is allowed
+// if their address is in one of AllowedCIDRs or they're in one of
+// AllowedCountries. Videos without rules can be watched from anywhere.
+type AccessRules struct {
+	AllowedCountries []string `json:"allowed_countries"`
+	AllowedCIDRs     []string `json:"allowed_cidrs"`
+}
+
+// Restricted reports whether the rules limit viewers at all.
+func (r AccessRules) Restricted() bool {
+	return len(r.AllowedCountries) > 0 || len(r.AllowedCIDRs) > 0
+}
+
+func (c Client) GetAccessRules(id uuid.UUID) (AccessRules, error) {
+	var encoded sql.NullString
+	err := c.db.QueryRow("SELECT access_rules FROM videos WHERE id = ?", id).Scan(&encoded)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return AccessRules{}, err
+	}
+
+	rules := AccessRules{AllowedCountries: []string{}, AllowedCIDRs: []string{}}
+	if encoded.Valid && encoded.String != "" {
+		if err := json.Unmarshal([]byte(encoded.String), &rules); err != nil {
+			return AccessRules{}, err
+		}
+	}
+	return rules, nil
+}
+
+func (c Client) SetAccessRules(id uuid.UUID, rules AccessRules) error {
+	var encoded *string
+	if rules.Restricted() {
+		data, err := json.Marshal(rules)
+		if err != nil {
+			return err
+		}
+		value := string(data)
+		encoded = &value
+	}
+	_, err := c.db.Exec("UPDATE videos SET access_rules = ? WHERE id = ?", encoded, id)
+	return err
+}
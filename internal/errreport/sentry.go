@@ -0,0 +1,179 @@
+// Package errreport sends crashes to Sentry, or to anything that speaks its
+// protocol such as GlitchTip, with the request that caused them.
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// sendTimeout bounds each report, so a slow reporter can't pile them up.
+const sendTimeout = 10 * time.Second
+
+// Event is a crash to report.
+type Event struct {
+	Message string
+	// Stack is the crashed goroutine's frames, innermost first
+	Stack     []runtime.Frame
+	Time      time.Time
+	RequestID string
+	Method    string
+	// URL mustn't carry credentials, as it's sent as it is
+	URL       string
+	UserAgent string
+}
+
+// Sentry reports events to the project of a Sentry DSN. A nil Sentry does
+// nothing.
+type Sentry struct {
+	endpoint string
+	dsn      string
+	key      string
+	// Environment tells deployments apart, such as "production", if set
+	Environment string
+	HTTPClient  *http.Client
+}
+
+// NewSentry parses a DSN such as https://<key>@o1.ingest.sentry.io/<project>.
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("not a DSN")
+	}
+	path, project, _ := cutLast(strings.TrimSuffix(u.Path, "/"), "/")
+	if project == "" {
+		return nil, errors.New("DSN has no project ID")
+	}
+	return &Sentry{
+		endpoint:   fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, path, project),
+		dsn:        dsn,
+		key:        u.User.Username(),
+		HTTPClient: &http.Client{Timeout: sendTimeout},
+	}, nil
+}
+
+// Report sends an event. Failures are logged rather than returned, since
+// there's nowhere else to report them to.
+func (s *Sentry) Report(ctx context.Context, ev Event) {
+	if s == nil {
+		return
+	}
+	if err := s.send(ctx, ev); err != nil {
+		log.Printf("Couldn't report error %s to Sentry: %v", ev.RequestID, err)
+	}
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+	Request *sentryRequest `json:"request,omitempty"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"abs_path"`
+	Line     int    `json:"lineno"`
+}
+
+type sentryRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (s *Sentry) send(ctx context.Context, ev Event) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   ev.Time.UTC(),
+		Platform:    "go",
+		Level:       "fatal",
+		Environment: s.Environment,
+	}
+	event.ServerName, _ = os.Hostname()
+	if ev.RequestID != "" {
+		event.Tags = map[string]string{"request_id": ev.RequestID}
+	}
+	exception := sentryException{Type: "panic", Value: ev.Message}
+	// Sentry lists frames outermost first
+	for i := len(ev.Stack) - 1; i >= 0; i-- {
+		frame := ev.Stack[i]
+		exception.Stacktrace.Frames = append(exception.Stacktrace.Frames, sentryFrame{
+			Function: frame.Function,
+			Filename: frame.File,
+			Line:     frame.Line,
+		})
+	}
+	event.Exception.Values = []sentryException{exception}
+	if ev.URL != "" {
+		event.Request = &sentryRequest{Method: ev.Method, URL: ev.URL}
+		if ev.UserAgent != "" {
+			event.Request.Headers = map[string]string{"User-Agent": ev.UserAgent}
+		}
+	}
+
+	// An envelope is a header, then each item's header and payload, a line each
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	envelopeHeader := map[string]any{"event_id": event.EventID, "dsn": s.dsn, "sent_at": time.Now().UTC()}
+	for _, part := range []any{envelopeHeader, map[string]string{"type": "event"}, event} {
+		if err := encoder.Encode(part); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=tubely/1.0", s.key))
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Sentry returned %s", resp.Status)
+	}
+	return nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,102 @@
+// Package geoip finds the country a viewer is in, for videos that may only
+// be watched from some countries.
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Provider looks up the country a request comes from, as an upper-case ISO
+// 3166-1 alpha-2 code, or "" when it isn't known. addr is the client's
+// address.
+type Provider interface {
+	Country(r *http.Request, addr netip.Addr) (string, error)
+}
+
+// Header trusts a country header set by a CDN or load balancer in front of
+// the server, such as CloudFront-Viewer-Country. Only use it when clients
+// can't reach the server directly, or they can send any country they like.
+type Header struct {
+	Name string
+}
+
+func (h Header) Country(r *http.Request, addr netip.Addr) (string, error) {
+	return strings.ToUpper(strings.TrimSpace(r.Header.Get(h.Name))), nil
+}
+
+// RangeFile looks addresses up in a CSV file of network,country rows, such
+// as "203.0.113.0/24,AU", matching the most specific network.
+type RangeFile struct {
+	networks map[int]map[netip.Prefix]string
+	// Prefix lengths present in networks, longest first
+	lengths []int
+}
+
+// LoadRangeFile reads a range file. A header row and blank lines are
+// skipped.
+func LoadRangeFile(filePath string) (*RangeFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f := &RangeFile{networks: make(map[int]map[netip.Prefix]string)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		row := strings.TrimSpace(scanner.Text())
+		if row == "" {
+			continue
+		}
+		network, country, ok := strings.Cut(row, ",")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected network,country", filePath, line)
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(network))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: %w", filePath, line, err)
+		}
+		f.add(prefix.Masked(), strings.ToUpper(strings.TrimSpace(country)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RangeFile) add(prefix netip.Prefix, country string) {
+	bits := prefix.Bits()
+	if f.networks[bits] == nil {
+		f.networks[bits] = make(map[netip.Prefix]string)
+		f.lengths = append(f.lengths, bits)
+		slices.Sort(f.lengths)
+		slices.Reverse(f.lengths)
+	}
+	f.networks[bits][prefix] = country
+}
+
+func (f *RangeFile) Country(r *http.Request, addr netip.Addr) (string, error) {
+	addr = addr.Unmap()
+	for _, bits := range f.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return "", err
+		}
+		if country, ok := f.networks[bits][prefix]; ok {
+			return country, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strings"
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurHashMaxDim bounds the size of the image the hash is computed from.
+// The hash only keeps a handful of low frequency components, so sampling
+// the full resolution thumbnail buys nothing but CPU time.
+const blurHashMaxDim = 64
+
+// BlurHash encodes img as a blurhash string with the given number of
+// horizontal and vertical components (each between 1 and 9).
+func BlurHash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", errors.New("blurhash components must be between 1 and 9")
+	}
+
+	pixels := downsample(img, blurHashMaxDim)
+	bounds := pixels.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", errors.New("image has no pixels")
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := basisY * math.Cos(math.Pi*float64(i)*float64(x)/float64(width))
+					offset := pixels.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+					r += basis * sRGBToLinear(pixels.Pix[offset])
+					g += basis * sRGBToLinear(pixels.Pix[offset+1])
+					b += basis * sRGBToLinear(pixels.Pix[offset+2])
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			for _, v := range factor {
+				actualMaximum = math.Max(actualMaximum, math.Abs(v))
+			}
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encode83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	hash.WriteString(encode83(encodeDC(dc), 4))
+	for _, factor := range ac {
+		hash.WriteString(encode83(encodeAC(factor, maximumValue), 2))
+	}
+
+	return hash.String(), nil
+}
+
+func encodeDC(value [3]float64) int {
+	return linearToSRGB(value[0])<<16 + linearToSRGB(value[1])<<8 + linearToSRGB(value[2])
+}
+
+func encodeAC(value [3]float64, maximumValue float64) int {
+	quant := func(v float64) int {
+		return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+	}
+	return quant(value[0])*19*19 + quant(value[1])*19 + quant(value[2])
+}
+
+func encode83(value, length int) string {
+	var result strings.Builder
+	for i := 1; i <= length; i++ {
+		divisor := int(math.Pow(83, float64(length-i)))
+		result.WriteByte(base83Chars[(value/divisor)%83])
+	}
+	return result.String()
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
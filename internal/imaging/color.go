@@ -0,0 +1,82 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// DominantColor returns the most common color of img as a "#rrggbb" string.
+// Pixels are bucketed by their 4 most significant bits per channel and the
+// average color of the most populated bucket is returned.
+func DominantColor(img image.Image) string {
+	pixels := downsample(img, blurHashMaxDim)
+	bounds := pixels.Bounds()
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := map[int]*bucket{}
+	var best *bucket
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			offset := pixels.PixOffset(x, y)
+			r, g, b, a := pixels.Pix[offset], pixels.Pix[offset+1], pixels.Pix[offset+2], pixels.Pix[offset+3]
+			if a == 0 {
+				continue
+			}
+
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			bk, ok := buckets[key]
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.count++
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+
+			if best == nil || bk.count > best.count {
+				best = bk
+			}
+		}
+	}
+
+	if best == nil {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+// downsample returns an RGBA copy of img no larger than maxDim on either
+// side, using nearest-neighbour sampling.
+func downsample(img image.Image, maxDim int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	scale := 1.0
+	if width > maxDim || height > maxDim {
+		scale = float64(maxDim) / float64(max(width, height))
+	}
+	dstWidth := max(1, int(float64(width)*scale))
+	dstHeight := max(1, int(float64(height)*scale))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	if scale == 1.0 {
+		draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+		return dst
+	}
+
+	for y := 0; y < dstHeight; y++ {
+		srcY := bounds.Min.Y + y*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			srcX := bounds.Min.X + x*width/dstWidth
+			dst.Set(x, y, color.RGBAModel.Convert(img.At(srcX, srcY)))
+		}
+	}
+	return dst
+}
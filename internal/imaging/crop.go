@@ -0,0 +1,87 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Crop returns the largest part of img with the aspect ratio aspectW:aspectH.
+// The crop slides along the side that has to be cut to wherever the image
+// has the most detail, measured as the difference in brightness between
+// neighbouring pixels, so subjects that aren't centred stay in frame. Flat
+// images are cropped from the centre.
+func Crop(img image.Image, aspectW, aspectH int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	cropWidth, cropHeight := width, height
+	if width*aspectH > height*aspectW {
+		cropWidth = max(1, height*aspectW/aspectH)
+	} else {
+		cropHeight = max(1, width*aspectH/aspectW)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	if cropWidth == width && cropHeight == height {
+		return src
+	}
+
+	// Detail along the side being cut, one entry per column or row
+	horizontal := cropWidth < width
+	detail := make([]int, height)
+	if horizontal {
+		detail = make([]int, width)
+	}
+	luma := func(x, y int) int {
+		return int(color.GrayModel.Convert(src.RGBAAt(x, y)).(color.Gray).Y)
+	}
+	for y := 0; y < height-1; y++ {
+		for x := 0; x < width-1; x++ {
+			l := luma(x, y)
+			d := abs(l-luma(x+1, y)) + abs(l-luma(x, y+1))
+			if horizontal {
+				detail[x] += d
+			} else {
+				detail[y] += d
+			}
+		}
+	}
+
+	size := cropHeight
+	if horizontal {
+		size = cropWidth
+	}
+	best := (len(detail) - size) / 2
+	bestSum := windowSum(detail, best, size)
+	sum := windowSum(detail, 0, size)
+	for start := 0; start+size <= len(detail); start++ {
+		if start > 0 {
+			sum += detail[start+size-1] - detail[start-1]
+		}
+		if sum > bestSum {
+			best, bestSum = start, sum
+		}
+	}
+
+	rect := image.Rect(0, best, width, best+size)
+	if horizontal {
+		rect = image.Rect(best, 0, best+size, height)
+	}
+	return src.SubImage(rect)
+}
+
+func windowSum(values []int, start, size int) int {
+	sum := 0
+	for _, v := range values[start : start+size] {
+		sum += v
+	}
+	return sum
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
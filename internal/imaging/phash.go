@@ -0,0 +1,140 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PHashSize is the side length of the grayscale image a perceptual hash is
+// computed from. Callers producing their own frames (e.g. ffmpeg scaling to
+// a raw gray buffer) should use it so no resampling is needed.
+const PHashSize = 32
+
+const phashLowFreq = 8
+
+// PerceptualHash computes a 64-bit DCT based perceptual hash of img.
+func PerceptualHash(img image.Image) uint64 {
+	pixels := downsample(img, PHashSize)
+	bounds := pixels.Bounds()
+
+	gray := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			offset := pixels.PixOffset(x, y)
+			r, g, b := float64(pixels.Pix[offset]), float64(pixels.Pix[offset+1]), float64(pixels.Pix[offset+2])
+			gray = append(gray, uint8(0.299*r+0.587*g+0.114*b))
+		}
+	}
+
+	hash, _ := PerceptualHashGray(gray, bounds.Dx(), bounds.Dy())
+	return hash
+}
+
+// PerceptualHashGray computes the perceptual hash of an 8-bit grayscale
+// buffer of the given dimensions.
+func PerceptualHashGray(pix []byte, width, height int) (uint64, error) {
+	if width <= 0 || height <= 0 || len(pix) < width*height {
+		return 0, errors.New("grayscale buffer is smaller than its dimensions")
+	}
+
+	// Nearest-neighbour resample to a PHashSize square
+	var samples [PHashSize][PHashSize]float64
+	for y := 0; y < PHashSize; y++ {
+		srcY := y * height / PHashSize
+		for x := 0; x < PHashSize; x++ {
+			srcX := x * width / PHashSize
+			samples[y][x] = float64(pix[srcY*width+srcX])
+		}
+	}
+
+	// Only the low frequency corner of the DCT is needed
+	var coefficients [phashLowFreq * phashLowFreq]float64
+	for v := 0; v < phashLowFreq; v++ {
+		for u := 0; u < phashLowFreq; u++ {
+			sum := 0.0
+			for y := 0; y < PHashSize; y++ {
+				cosY := math.Cos(float64(2*y+1) * float64(v) * math.Pi / (2 * PHashSize))
+				for x := 0; x < PHashSize; x++ {
+					sum += samples[y][x] * cosY * math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*PHashSize))
+				}
+			}
+			coefficients[v*phashLowFreq+u] = sum
+		}
+	}
+
+	// The DC term dominates and says nothing about structure, so leave it out
+	// of the median
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash, nil
+}
+
+// HammingDistance returns the number of differing bits between two hashes.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash encodes a hash as a fixed width hex string.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash decodes a hash produced by FormatHash.
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// FormatFingerprint encodes a sequence of frame hashes as a single string.
+func FormatFingerprint(hashes []uint64) string {
+	parts := make([]string, len(hashes))
+	for i, hash := range hashes {
+		parts[i] = FormatHash(hash)
+	}
+	return strings.Join(parts, ":")
+}
+
+// ParseFingerprint decodes a fingerprint produced by FormatFingerprint.
+func ParseFingerprint(s string) ([]uint64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ":")
+	hashes := make([]uint64, len(parts))
+	for i, part := range parts {
+		hash, err := ParseHash(part)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+// FingerprintDistance returns the average Hamming distance between the
+// aligned frame hashes of two fingerprints. Fingerprints of different
+// lengths are compared over their common prefix.
+func FingerprintDistance(a, b []uint64) (int, error) {
+	n := min(len(a), len(b))
+	if n == 0 {
+		return 0, errors.New("empty fingerprint")
+	}
+	total := 0
+	for i := 0; i < n; i++ {
+		total += HammingDistance(a[i], b[i])
+	}
+	return (total + n/2) / n, nil
+}
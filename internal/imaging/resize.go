@@ -0,0 +1,49 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Fit returns img scaled down to at most maxDim on either side, keeping its
+// aspect ratio. Each output pixel averages the source pixels it covers, so
+// large photos shrink without aliasing. Images that already fit are returned
+// unchanged.
+func Fit(img image.Image, maxDim int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxDim && height <= maxDim {
+		return img
+	}
+
+	scale := float64(maxDim) / float64(max(width, height))
+	dstWidth := max(1, int(float64(width)*scale))
+	dstHeight := max(1, int(float64(height)*scale))
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := y*height/dstHeight, max((y+1)*height/dstHeight, y*height/dstHeight+1)
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := x*width/dstWidth, max((x+1)*width/dstWidth, x*width/dstWidth+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					offset := src.PixOffset(sx, sy)
+					for c := range sum {
+						sum[c] += int(src.Pix[offset+c])
+					}
+				}
+			}
+			count := (y1 - y0) * (x1 - x0)
+			offset := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,185 @@
+// Package inbound reads emails delivered to the server by an inbound mail
+// provider, Mailgun or Amazon SES, saving the attachments worth keeping to
+// temporary files.
+package inbound
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Email is an email a provider delivered.
+type Email struct {
+	// From is the address in the From header
+	From    string
+	Subject string
+	// Authenticated is true when the provider vouched for the From domain,
+	// through DMARC or a DKIM signature aligned with it, so From can be believed
+	Authenticated bool
+	Attachments   []Attachment
+}
+
+// Attachment is an attachment saved to a temporary file.
+type Attachment struct {
+	Filename  string
+	MediaType string
+	Path      string
+}
+
+// Cleanup deletes the attachments' files.
+func (e Email) Cleanup() {
+	for _, a := range e.Attachments {
+		os.Remove(a.Path)
+	}
+}
+
+// TempFile is a file an attachment is saved to.
+type TempFile interface {
+	io.WriteCloser
+	Name() string
+}
+
+// Options control which attachments are saved, and where.
+type Options struct {
+	// Keep reports whether an attachment should be saved
+	Keep func(filename, mediaType string) bool
+	// CreateTemp creates the file an attachment is saved to
+	CreateTemp func(pattern string) (TempFile, error)
+	// MaxAttachments caps how many attachments are saved, 0 for no cap
+	MaxAttachments int
+}
+
+// ErrInvalidSignature is returned when a delivery can't be shown to come
+// from the provider.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrTooManyAttachments is returned when an email has more attachments
+// worth keeping than Options.MaxAttachments.
+var ErrTooManyAttachments = errors.New("too many attachments")
+
+// save saves an attachment to a temporary file, if it's one to keep.
+func (o Options) save(email *Email, filename, mediaType string, body io.Reader) error {
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) || !o.Keep(filename, mediaType) {
+		return nil
+	}
+	if o.MaxAttachments > 0 && len(email.Attachments) >= o.MaxAttachments {
+		return ErrTooManyAttachments
+	}
+
+	file, err := o.CreateTemp("tubely-email-*")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	attachment := Attachment{Filename: filename, MediaType: mediaType, Path: file.Name()}
+	if _, err := io.Copy(file, body); err != nil {
+		os.Remove(attachment.Path)
+		return fmt.Errorf("couldn't save attachment %s: %w", filename, err)
+	}
+	email.Attachments = append(email.Attachments, attachment)
+	return nil
+}
+
+// ParseMIME reads a raw email, saving its attachments. Authenticated is
+// left false; it's up to the provider to vouch for the sender.
+func ParseMIME(r io.Reader, opts Options) (Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return Email{}, fmt.Errorf("couldn't read email: %w", err)
+	}
+
+	email := Email{Subject: decodeHeader(msg.Header.Get("Subject"))}
+	if from, err := parseAddress(msg.Header.Get("From")); err == nil {
+		email.From = from
+	}
+	if err := walkPart(&email, opts, msg.Header, msg.Body); err != nil {
+		email.Cleanup()
+		return Email{}, err
+	}
+	return email, nil
+}
+
+// partHeader is what's needed of mail.Header and textproto.MIMEHeader.
+type partHeader interface {
+	Get(key string) string
+}
+
+// walkPart saves the attachments in one part of an email, descending into
+// multipart parts.
+func walkPart(email *Email, opts Options, header partHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("couldn't read email part: %w", err)
+			}
+			if err := walkPart(email, opts, part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	filename := params["name"]
+	if _, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && dispositionParams["filename"] != "" {
+		filename = dispositionParams["filename"]
+	}
+	if filename == "" {
+		return nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	return opts.save(email, decodeHeader(filename), mediaType, body)
+}
+
+// decodeHeader decodes a header that may be RFC 2047 encoded, like
+// "=?UTF-8?B?...?=", leaving it as it is if it can't be decoded.
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// parseAddress gets the bare address out of a header like
+// "Boots <boots@example.com>".
+func parseAddress(value string) (string, error) {
+	address, err := mail.ParseAddress(value)
+	if err != nil {
+		return "", err
+	}
+	return address.Address, nil
+}
+
+// mediaTypeOf gets the media type from a Content-Type, without parameters.
+func mediaTypeOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
@@ -0,0 +1,125 @@
+package inbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailgun reads emails forwarded by a Mailgun route.
+type Mailgun struct {
+	// SigningKey is the account's HTTP webhook signing key
+	SigningKey string
+	// MaxAge is how old a delivery's timestamp can be, to limit replays
+	MaxAge time.Duration
+}
+
+// Parse checks a route delivery's signature and reads the email, saving
+// its attachments. r's body must already be size limited.
+func (m Mailgun) Parse(r *http.Request, opts Options) (Email, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return Email{}, err
+	}
+	defer r.MultipartForm.RemoveAll()
+	if err := m.verify(r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")); err != nil {
+		return Email{}, err
+	}
+
+	email := Email{Subject: r.FormValue("subject")}
+	if from, err := parseAddress(r.FormValue("from")); err == nil {
+		email.From = from
+		email.Authenticated = mailgunDKIMAligned(r.FormValue("message-headers"), from)
+	}
+
+	for field, files := range r.MultipartForm.File {
+		if !strings.HasPrefix(field, "attachment-") {
+			continue
+		}
+		for _, fileHeader := range files {
+			file, err := fileHeader.Open()
+			if err != nil {
+				email.Cleanup()
+				return Email{}, err
+			}
+			err = opts.save(&email, fileHeader.Filename, mediaTypeOf(fileHeader.Header.Get("Content-Type")), file)
+			file.Close()
+			if err != nil {
+				email.Cleanup()
+				return Email{}, err
+			}
+		}
+	}
+	return email, nil
+}
+
+// verify checks a delivery was signed with the signing key recently.
+func (m Mailgun) verify(timestamp, token, signature string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or invalid timestamp", ErrInvalidSignature)
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > m.MaxAge || age < -m.MaxAge {
+		return fmt.Errorf("%w: timestamp is too old", ErrInvalidSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(m.SigningKey))
+	mac.Write([]byte(timestamp + token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("%w: signature doesn't match", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// mailgunDKIMAligned reports whether Mailgun's DKIM check passed for a
+// message signed by the domain of from, or one sharing it as a parent, from
+// the headers of the message. A pass for some other domain's signature, or
+// SPF's pass for the envelope sender, says nothing about who wrote From.
+func mailgunDKIMAligned(messageHeaders, from string) bool {
+	headers := [][2]string{}
+	if err := json.Unmarshal([]byte(messageHeaders), &headers); err != nil {
+		return false
+	}
+	at := strings.LastIndex(from, "@")
+	if at < 0 {
+		return false
+	}
+	fromDomain := from[at+1:]
+	passed, aligned := false, false
+	for _, header := range headers {
+		switch strings.ToLower(header[0]) {
+		case "x-mailgun-dkim-check-result":
+			passed = strings.EqualFold(strings.TrimSpace(header[1]), "pass")
+		case "dkim-signature":
+			aligned = aligned || domainsAligned(dkimSigningDomain(header[1]), fromDomain)
+		}
+	}
+	return passed && aligned
+}
+
+// dkimSigningDomain returns the d= tag of a DKIM-Signature header.
+func dkimSigningDomain(signature string) string {
+	for _, tag := range strings.Split(signature, ";") {
+		name, value, ok := strings.Cut(tag, "=")
+		if ok && strings.TrimSpace(name) == "d" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// domainsAligned reports whether a signing domain is relaxed aligned with
+// the From domain: the same, or one a subdomain of the other.
+func domainsAligned(signing, from string) bool {
+	signing, from = strings.ToLower(strings.TrimSuffix(signing, ".")), strings.ToLower(from)
+	if signing == "" {
+		return false
+	}
+	return signing == from || strings.HasSuffix(from, "."+signing) || strings.HasSuffix(signing, "."+from)
+}
@@ -0,0 +1,286 @@
+package inbound
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SNS message types
+const (
+	SNSNotification             = "Notification"
+	SNSSubscriptionConfirmation = "SubscriptionConfirmation"
+	SNSUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// SNSMessage is a message Amazon SNS posts to an HTTPS subscription.
+type SNSMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// Only certificates and subscription URLs on SNS's own hosts are trusted
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// SES reads emails that an SES receipt rule published to an SNS topic,
+// either with the email in the notification or stored in S3.
+type SES struct {
+	// TopicArn is the only topic notifications are accepted from
+	TopicArn string
+	// Fetch reads an email an S3 action stored
+	Fetch func(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	// HTTPClient fetches signing certificates and confirms subscriptions
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// ReadMessage reads and verifies an SNS message from a request body.
+func (s *SES) ReadMessage(ctx context.Context, body io.Reader) (SNSMessage, error) {
+	var msg SNSMessage
+	if err := json.NewDecoder(body).Decode(&msg); err != nil {
+		return SNSMessage{}, fmt.Errorf("couldn't decode SNS message: %w", err)
+	}
+	if msg.TopicArn != s.TopicArn {
+		return SNSMessage{}, fmt.Errorf("%w: unexpected topic %s", ErrInvalidSignature, msg.TopicArn)
+	}
+	if err := s.verify(ctx, msg); err != nil {
+		return SNSMessage{}, err
+	}
+	return msg, nil
+}
+
+// ConfirmSubscription confirms the subscription a SubscriptionConfirmation
+// message is about, so SNS starts sending notifications.
+func (s *SES) ConfirmSubscription(ctx context.Context, msg SNSMessage) error {
+	if err := checkSNSURL(msg.SubscribeURL); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, msg.SubscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("confirming subscription returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sesNotification is the part of an SES receipt notification that's needed.
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		CommonHeaders struct {
+			From    []string `json:"from"`
+			Subject string   `json:"subject"`
+		} `json:"commonHeaders"`
+	} `json:"mail"`
+	Receipt struct {
+		DMARCVerdict sesVerdict `json:"dmarcVerdict"`
+		Action       struct {
+			Type       string `json:"type"`
+			Encoding   string `json:"encoding"`
+			BucketName string `json:"bucketName"`
+			ObjectKey  string `json:"objectKey"`
+		} `json:"action"`
+	} `json:"receipt"`
+	Content string `json:"content"`
+}
+
+type sesVerdict struct {
+	Status string `json:"status"`
+}
+
+// ParseNotification reads the email a Notification message is about,
+// saving its attachments. Notifications that aren't about a received email
+// give an Email without a sender.
+func (s *SES) ParseNotification(ctx context.Context, msg SNSMessage, opts Options) (Email, error) {
+	var notification sesNotification
+	if err := json.Unmarshal([]byte(msg.Message), &notification); err != nil {
+		return Email{}, fmt.Errorf("couldn't decode SES notification: %w", err)
+	}
+	if notification.NotificationType != "Received" {
+		return Email{}, nil
+	}
+
+	var raw io.Reader
+	action := notification.Receipt.Action
+	switch action.Type {
+	case "S3":
+		object, err := s.Fetch(ctx, action.BucketName, action.ObjectKey)
+		if err != nil {
+			return Email{}, fmt.Errorf("couldn't fetch email from S3: %w", err)
+		}
+		defer object.Close()
+		raw = object
+	case "SNS":
+		raw = strings.NewReader(notification.Content)
+		if strings.EqualFold(action.Encoding, "BASE64") {
+			raw = base64.NewDecoder(base64.StdEncoding, raw)
+		}
+	default:
+		return Email{}, fmt.Errorf("unsupported SES action %q", action.Type)
+	}
+
+	email, err := ParseMIME(raw, opts)
+	if err != nil {
+		return Email{}, err
+	}
+	// SES's parsed headers are what its checks were run against
+	if len(notification.Mail.CommonHeaders.From) > 0 {
+		if from, err := parseAddress(notification.Mail.CommonHeaders.From[0]); err == nil {
+			email.From = from
+		}
+	}
+	// SPF and DKIM alone can pass for any domain the sender controls, but
+	// DMARC only passes when one of them did for the From domain
+	email.Authenticated = strings.EqualFold(notification.Receipt.DMARCVerdict.Status, "PASS")
+	return email, nil
+}
+
+// verify checks an SNS message's signature against SNS's certificate.
+func (s *SES) verify(ctx context.Context, msg SNSMessage) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: unsupported signature version %q", ErrInvalidSignature, msg.SignatureVersion)
+	}
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	cert, err := s.certificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate doesn't have an RSA key", ErrInvalidSignature)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(msg.stringToSign()))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(msg.stringToSign()))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// stringToSign builds the text SNS signs, which depends on the message's
+// type.
+func (m SNSMessage) stringToSign() string {
+	fields := [][2]string{{"Message", m.Message}, {"MessageId", m.MessageID}}
+	if m.Type == SNSNotification {
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", m.SubscribeURL})
+	}
+	fields = append(fields, [2]string{"Timestamp", m.Timestamp})
+	if m.Type != SNSNotification {
+		fields = append(fields, [2]string{"Token", m.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", m.TopicArn}, [2]string{"Type", m.Type})
+
+	var b strings.Builder
+	for _, field := range fields {
+		b.WriteString(field[0] + "\n" + field[1] + "\n")
+	}
+	return b.String()
+}
+
+// certificate fetches a signing certificate, once per URL.
+func (s *SES) certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if err := checkSNSURL(certURL); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	cert, ok := s.certs[certURL]
+	s.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch signing certificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching signing certificate returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing certificate isn't PEM encoded")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.certs == nil {
+		s.certs = map[string]*x509.Certificate{}
+	}
+	s.certs[certURL] = cert
+	return cert, nil
+}
+
+// checkSNSURL makes sure a URL in a message points at SNS, so a forged
+// message can't make the server fetch anything else.
+func checkSNSURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || !snsHost.MatchString(u.Hostname()) {
+		return fmt.Errorf("%w: %q isn't an SNS URL", ErrInvalidSignature, rawURL)
+	}
+	return nil
+}
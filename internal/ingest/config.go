@@ -0,0 +1,150 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// StageConfig declares one stage of a configured pipeline.
+type StageConfig struct {
+	Name string `json:"name"`
+	// Timeout bounds each attempt at the stage; zero means no limit
+	Timeout Duration `json:"timeout"`
+	// Retries is how many more times an internal failure is attempted,
+	// only for stages that are safe to repeat
+	Retries int `json:"retries"`
+	// Optional stages log their failures instead of failing the upload
+	Optional bool `json:"optional"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultStages is the pipeline used when none is configured.
+func DefaultStages() []StageConfig {
+	return []StageConfig{
+		{Name: "validate"},
+		{Name: "persist"},
+		{Name: "probe"},
+		{Name: "store"},
+		{Name: "finalize"},
+		{Name: "process"},
+	}
+}
+
+// LoadStages reads the stages declared in the JSON file at filePath, falling
+// back to DefaultStages when filePath is empty.
+func LoadStages(filePath string) ([]StageConfig, error) {
+	if filePath == "" {
+		return DefaultStages(), nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Stages []StageConfig `json:"stages"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %w", filePath, err)
+	}
+	if len(config.Stages) == 0 {
+		return nil, fmt.Errorf("%s declares no stages", filePath)
+	}
+	return config.Stages, nil
+}
+
+// Retryable is implemented by stages that are safe to run again after a
+// failure. Stages that consume the request body are not.
+type Retryable interface {
+	Retryable() bool
+}
+
+// Dependent is implemented by stages that need others to have run first.
+type Dependent interface {
+	Requires() []string
+}
+
+// The stages every pipeline needs to get from a request body to a job
+var requiredStages = []string{"persist", "store", "finalize"}
+
+// processStageName is the stage that runs the job, always last
+const processStageName = "process"
+
+// Build assembles a pipeline from configs, taking each stage from available
+// by name. A stage that's available as nil is skipped, such as process when
+// jobs finish elsewhere.
+func Build(configs []StageConfig, available map[string]Stage) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	seen := make(map[string]bool)
+	for i, config := range configs {
+		stage, ok := available[config.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown stage %q", config.Name)
+		}
+		if seen[config.Name] {
+			return nil, fmt.Errorf("stage %q is declared twice", config.Name)
+		}
+		if config.Timeout < 0 || config.Retries < 0 {
+			return nil, fmt.Errorf("stage %q: timeout and retries can't be negative", config.Name)
+		}
+		if config.Optional && slices.Contains(requiredStages, config.Name) {
+			return nil, fmt.Errorf("stage %q can't be optional", config.Name)
+		}
+		if config.Retries > 0 {
+			if retryable, ok := stage.(Retryable); !ok || !retryable.Retryable() {
+				return nil, fmt.Errorf("stage %q can't be retried", config.Name)
+			}
+		}
+		if dependent, ok := stage.(Dependent); ok {
+			for _, name := range dependent.Requires() {
+				if !seen[name] {
+					return nil, fmt.Errorf("stage %q must come after %q", config.Name, name)
+				}
+			}
+		}
+		if config.Name == processStageName && i != len(configs)-1 {
+			return nil, errors.New("stage \"process\" must come last")
+		}
+		seen[config.Name] = true
+
+		if stage == nil {
+			continue
+		}
+		configured := newConfiguredStage(stage, config)
+		if config.Name == processStageName {
+			pipeline.ProcessStage = configured
+		} else {
+			pipeline.Stages = append(pipeline.Stages, configured)
+		}
+	}
+
+	for _, name := range requiredStages {
+		if !seen[name] {
+			return nil, fmt.Errorf("stage %q is required", name)
+		}
+	}
+	return pipeline, nil
+}
@@ -0,0 +1,196 @@
+// Package ingest takes an uploaded video from the request body to a
+// processing job, as a pipeline of small stages.
+//
+// Each stage reads and fills in fields of an Upload, and depends only on the
+// narrow interfaces it declares, so it can be exercised with fakes and new
+// stages (scanning, transcoding) slot in between the existing ones:
+//
+//	validate -> persist temp -> probe -> store -> finalize -> process
+//
+// Persist hashes the body and hands it to any inspectors, such as a content
+// sniffer and a virus scanner, on its way to disk, so checks that need
+// every byte don't each read the saved file again.
+//
+// Small uploads can skip the disk entirely: persist keeps them in memory,
+// and the later stages pipe them into ffprobe and ffmpeg.
+//
+// Receive runs the stages up to the job being created. Process runs the job
+// in the request when uploads are processed inline, and is left out when a
+// worker or an external transcoder finishes it later.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Upload is a single video upload as it moves through the pipeline.
+type Upload struct {
+	Video     database.Video
+	MediaType string
+	Profile   processing.Profile
+	// Notify is the notification channel the result is sent on, empty for
+	// every channel the owner set up
+	Notify string
+	// Priority is the class the processing job is scheduled in
+	Priority database.JobPriority
+	Body     io.Reader
+	// Update, if set, is applied to the video in the write that records
+	// the processed file
+	Update func(*database.Video)
+
+	// Filled in by the stages
+	TempPath string
+	// Data holds the upload instead of TempPath when it's small enough to
+	// be processed in memory
+	Data []byte
+	// Size is how many bytes of the body were read
+	Size        int64
+	SHA256      string
+	Duration    float64
+	Orientation string
+	ObjectKey   string
+	SourceKey   *string
+	Job         database.Job
+	Result      database.Video
+
+	// handedOff is set once a job owns the temp file
+	handedOff bool
+}
+
+// Stage is one step of the pipeline.
+type Stage interface {
+	Name() string
+	Run(ctx context.Context, u *Upload) error
+}
+
+// VideoTransitioner moves videos between states, such as a database.Client.
+type VideoTransitioner interface {
+	TransitionVideo(id uuid.UUID, to database.VideoState) error
+}
+
+// Pipeline runs an upload's stages in order.
+type Pipeline struct {
+	Stages []Stage
+	// ProcessStage runs the job after Stages; nil when jobs finish elsewhere
+	ProcessStage Stage
+	// States, if set, quarantines videos whose upload is found to be malware
+	States VideoTransitioner
+}
+
+// Receive runs the stages up to and including creating the job. The temp
+// file is removed on failure, or when the job uses a staged copy instead.
+func (p *Pipeline) Receive(ctx context.Context, u *Upload) error {
+	defer func() {
+		if u.TempPath != "" && !u.handedOff {
+			os.Remove(u.TempPath)
+		}
+	}()
+
+	if !u.Video.State.AcceptsUploads() {
+		return &Error{Stage: "receive", Kind: KindConflict, Msg: fmt.Sprintf("Video is %s and can't take another upload", u.Video.State)}
+	}
+	for _, stage := range p.Stages {
+		if err := run(ctx, stage, u); err != nil {
+			p.quarantine(u, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// quarantine moves the video of an upload found to be malware to
+// quarantined. Videos with a file of their own to play keep it.
+func (p *Pipeline) quarantine(u *Upload, err error) {
+	var ingestErr *Error
+	if p.States == nil || !errors.As(err, &ingestErr) || ingestErr.Kind != KindMalware {
+		return
+	}
+	err = p.States.TransitionVideo(u.Video.ID, database.VideoStateQuarantined)
+	if err != nil && !errors.Is(err, database.ErrInvalidVideoTransition) {
+		log.Printf("Couldn't quarantine video %s: %v", u.Video.ID, err)
+	}
+}
+
+// Process runs the upload's job, if this pipeline processes jobs itself.
+func (p *Pipeline) Process(ctx context.Context, u *Upload) error {
+	if p.ProcessStage == nil {
+		return nil
+	}
+	return run(ctx, p.ProcessStage, u)
+}
+
+// Inline reports whether Process runs jobs in the request.
+func (p *Pipeline) Inline() bool {
+	return p.ProcessStage != nil
+}
+
+// Run receives the upload and then processes it.
+func (p *Pipeline) Run(ctx context.Context, u *Upload) error {
+	if err := p.Receive(ctx, u); err != nil {
+		return err
+	}
+	return p.Process(ctx, u)
+}
+
+func run(ctx context.Context, stage Stage, u *Upload) error {
+	err := stage.Run(ctx, u)
+	if err == nil {
+		return nil
+	}
+	var ingestErr *Error
+	if errors.As(err, &ingestErr) {
+		if ingestErr.Stage == "" {
+			ingestErr.Stage = stage.Name()
+		}
+		return err
+	}
+	return &Error{Stage: stage.Name(), Kind: KindInternal, Msg: "Couldn't " + stage.Name() + " upload", Err: err}
+}
+
+// Kind says how a failed upload should be reported to the client.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindInvalid
+	KindInvalidMediaType
+	KindTooLarge
+	KindInvalidDuration
+	KindProcessingFailed
+	KindProcessingTimeout
+	KindMalware
+	KindCancelled
+	KindConflict
+)
+
+// Error is a stage failure, with a message fit for the client.
+type Error struct {
+	Stage string
+	Kind  Kind
+	Msg   string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s", e.Stage, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Stage, e.Msg, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind Kind, msg string, err error) *Error {
+	return &Error{Kind: kind, Msg: msg, Err: err}
+}
@@ -0,0 +1,168 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inspector examines an upload's bytes while persist saves them, so the
+// hash, every inspector and the write to disk share a single read of the
+// body instead of each reading the saved file again.
+type Inspector interface {
+	Name() string
+	// Inspect reads the upload from r, returning an *Error to reject it.
+	// It may return before reading everything; the rest is skipped.
+	Inspect(ctx context.Context, u *Upload, r io.Reader) error
+}
+
+// inspection feeds an upload to its inspectors as it's read, each in its own
+// goroutine. A rejection stops the read, since the next write fails with it.
+type inspection struct {
+	writers []*io.PipeWriter
+	errs    []error
+	wg      sync.WaitGroup
+}
+
+func startInspection(ctx context.Context, u *Upload, inspectors []Inspector) *inspection {
+	in := &inspection{errs: make([]error, len(inspectors))}
+	for i, inspector := range inspectors {
+		reader, writer := io.Pipe()
+		in.writers = append(in.writers, writer)
+		in.wg.Add(1)
+		go func() {
+			defer in.wg.Done()
+			err := inspector.Inspect(ctx, u, reader)
+			if err == nil {
+				io.Copy(io.Discard, reader)
+				return
+			}
+			var ingestErr *Error
+			if !errors.As(err, &ingestErr) {
+				err = newError(KindInternal, "Couldn't "+inspector.Name()+" upload", err)
+			}
+			in.errs[i] = err
+			reader.CloseWithError(err)
+		}()
+	}
+	return in
+}
+
+func (in *inspection) Write(p []byte) (int, error) {
+	for _, writer := range in.writers {
+		if _, err := writer.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// finish ends the inspectors' input with readErr, EOF if nil, and waits for
+// them. It returns the rejection that stopped the read, or any once the
+// whole upload was read; failures caused by readErr are left to the caller.
+func (in *inspection) finish(readErr error) error {
+	for _, writer := range in.writers {
+		writer.CloseWithError(readErr)
+	}
+	in.wg.Wait()
+	for _, err := range in.errs {
+		if err != nil && (readErr == nil || errors.Is(readErr, err)) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sniff checks an upload's first bytes are an MP4 file, rather than
+// trusting the media type the client declared.
+type Sniff struct{}
+
+func (Sniff) Name() string { return "sniff" }
+
+func (Sniff) Inspect(ctx context.Context, u *Upload, r io.Reader) error {
+	// MP4 files start with an ftyp box, its size followed by its type
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[4:]) != "ftyp" {
+		return newError(KindInvalidMediaType, "File is not an MP4 video", nil)
+	}
+	return nil
+}
+
+// ClamAV scans uploads with a clamd daemon, streaming them to it as they
+// arrive.
+type ClamAV struct {
+	// Address is clamd's TCP address, like "localhost:3310", or the path of
+	// its Unix socket
+	Address string
+	Timeout time.Duration
+}
+
+func (ClamAV) Name() string { return "scan" }
+
+// Largest chunk sent to clamd at once
+const clamavChunkSize = 64 << 10
+
+func (s ClamAV) Inspect(ctx context.Context, u *Upload, r io.Reader) error {
+	network := "tcp"
+	if strings.HasPrefix(s.Address, "/") {
+		network = "unix"
+	}
+	dialer := net.Dialer{Timeout: s.Timeout}
+	conn, err := dialer.DialContext(ctx, network, s.Address)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	// INSTREAM takes the file as chunks, each after its length, ending with
+	// an empty one. Uploads arrive slowly, so the deadline only covers the
+	// verdict.
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+	chunk := make([]byte, 4+clamavChunkSize)
+	for {
+		n, readErr := r.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				return fmt.Errorf("couldn't send upload to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+
+	if s.Timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.Timeout))
+	}
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil {
+		return fmt.Errorf("couldn't read clamd's verdict: %w", err)
+	}
+	verdict := string(bytes.TrimSuffix(reply, []byte{0}))
+	verdict = strings.TrimPrefix(verdict, "stream: ")
+	switch {
+	case verdict == "OK":
+		return nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return newError(KindMalware, "Upload was rejected by the virus scanner", fmt.Errorf("clamd found %s", strings.TrimSuffix(verdict, " FOUND")))
+	default:
+		return fmt.Errorf("clamd couldn't scan upload: %s", verdict)
+	}
+}
@@ -0,0 +1,149 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// How long to wait before retrying a stage, multiplied by the attempt number
+const retryBackoff = 500 * time.Millisecond
+
+// StageMetrics counts what a stage has done since startup.
+type StageMetrics struct {
+	Name        string     `json:"name"`
+	Timeout     Duration   `json:"timeout"`
+	Retries     int        `json:"retries"`
+	Optional    bool       `json:"optional"`
+	Runs        int64      `json:"runs"`
+	Failures    int64      `json:"failures"`
+	Timeouts    int64      `json:"timeouts"`
+	RetriedRuns int64      `json:"retried_runs"`
+	TotalTime   Duration   `json:"total_time"`
+	MaxTime     Duration   `json:"max_time"`
+	LastRunAt   *time.Time `json:"last_run_at"`
+	LastError   *string    `json:"last_error"`
+}
+
+// configuredStage runs a stage with its configured timeout and retries,
+// recording metrics for each run.
+type configuredStage struct {
+	stage  Stage
+	config StageConfig
+
+	mu      sync.Mutex
+	metrics StageMetrics
+}
+
+func newConfiguredStage(stage Stage, config StageConfig) *configuredStage {
+	return &configuredStage{
+		stage:  stage,
+		config: config,
+		metrics: StageMetrics{
+			Name:     config.Name,
+			Timeout:  config.Timeout,
+			Retries:  config.Retries,
+			Optional: config.Optional,
+		},
+	}
+}
+
+func (s *configuredStage) Name() string { return s.stage.Name() }
+
+func (s *configuredStage) Run(ctx context.Context, u *Upload) error {
+	start := time.Now()
+
+	var err error
+	attempts := 1
+	for ; ; attempts++ {
+		err = s.attempt(ctx, u)
+		if err == nil || attempts > s.config.Retries || !retryable(err) {
+			break
+		}
+		if !sleep(ctx, time.Duration(attempts)*retryBackoff) {
+			break
+		}
+	}
+
+	s.record(time.Since(start), attempts, err)
+	if err != nil && s.config.Optional {
+		log.Printf("Optional stage %s failed for video %s, continuing: %v", s.config.Name, u.Video.ID, err)
+		return nil
+	}
+	return err
+}
+
+func (s *configuredStage) attempt(ctx context.Context, u *Upload) error {
+	if s.config.Timeout <= 0 {
+		return s.stage.Run(ctx, u)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout))
+	defer cancel()
+	return s.stage.Run(ctx, u)
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Only internal failures are worth another attempt, a bad upload stays bad
+func retryable(err error) bool {
+	var ingestErr *Error
+	return !errors.As(err, &ingestErr) || ingestErr.Kind == KindInternal
+}
+
+func (s *configuredStage) record(elapsed time.Duration, attempts int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	s.metrics.Runs++
+	s.metrics.LastRunAt = &now
+	s.metrics.TotalTime += Duration(elapsed)
+	if Duration(elapsed) > s.metrics.MaxTime {
+		s.metrics.MaxTime = Duration(elapsed)
+	}
+	if attempts > 1 {
+		s.metrics.RetriedRuns++
+	}
+	if err != nil {
+		msg := err.Error()
+		s.metrics.Failures++
+		s.metrics.LastError = &msg
+		if errors.Is(err, context.DeadlineExceeded) {
+			s.metrics.Timeouts++
+		}
+	}
+}
+
+func (s *configuredStage) snapshot() StageMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.metrics
+}
+
+// Metrics returns the metrics of every configured stage, in pipeline order.
+func (p *Pipeline) Metrics() []StageMetrics {
+	stages := p.Stages
+	if p.ProcessStage != nil {
+		stages = append(stages[:len(stages):len(stages)], p.ProcessStage)
+	}
+
+	metrics := make([]StageMetrics, 0, len(stages))
+	for _, stage := range stages {
+		if configured, ok := stage.(*configuredStage); ok {
+			metrics = append(metrics, configured.snapshot())
+		}
+	}
+	return metrics
+}
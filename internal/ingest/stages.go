@@ -0,0 +1,425 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// Validate rejects uploads of a media type that isn't allowed.
+type Validate struct {
+	MediaTypes []string
+}
+
+func (Validate) Name() string { return "validate" }
+
+func (s Validate) Run(ctx context.Context, u *Upload) error {
+	if !slices.Contains(s.MediaTypes, u.MediaType) {
+		return newError(KindInvalidMediaType, "Invalid file type, only MP4 is allowed", nil)
+	}
+	return nil
+}
+
+// TempFiles creates the scratch files uploads are saved to, encrypting them
+// if it was set up to, and opens them again, such as a *processing.Scratch.
+type TempFiles interface {
+	CreateTempFile(pattern string) (*processing.ScratchFile, error)
+	Open(name string) (*processing.ScratchFile, error)
+}
+
+// PersistTemp saves the body to a temp file, where it survives a restart
+// until its job has finished with it, hashing it on the way so identical
+// bytes can reuse earlier analysis. Inspectors see the body as it's read,
+// and can reject it before it has all arrived.
+type PersistTemp struct {
+	Files      TempFiles
+	Inspectors []Inspector
+	// MemoryLimit is the size up to which uploads are kept in Data instead,
+	// 0 to always use a temp file. They're only kept if ffmpeg can read them
+	// through a pipe, and not for HLS profiles, which are written to disk.
+	MemoryLimit int64
+}
+
+func (PersistTemp) Name() string { return "persist" }
+
+func (s PersistTemp) Run(ctx context.Context, u *Upload) error {
+	hash := sha256.New()
+	inspection := startInspection(ctx, u, s.Inspectors)
+	body := io.TeeReader(u.Body, io.MultiWriter(hash, inspection))
+
+	if s.MemoryLimit > 0 && u.Profile.Format != processing.FormatHLS {
+		data, err := io.ReadAll(io.LimitReader(body, s.MemoryLimit+1))
+		if err != nil {
+			return readError(err, inspection.finish(err))
+		}
+		if int64(len(data)) <= s.MemoryLimit && processing.StreamableMP4(data) {
+			if err := inspection.finish(nil); err != nil {
+				return err
+			}
+			u.Data = data
+			u.Size = int64(len(data))
+			u.SHA256 = hex.EncodeToString(hash.Sum(nil))
+			return nil
+		}
+		// Too big, or it has to be read from disk: write what's been read first
+		body = io.MultiReader(bytes.NewReader(data), body)
+	}
+
+	tempFile, err := s.Files.CreateTempFile("tubely-upload-*.mp4")
+	if err != nil {
+		inspection.finish(err)
+		return newError(KindInternal, "Could not create temp file", err)
+	}
+	defer tempFile.Close()
+	u.TempPath = tempFile.Name()
+
+	u.Size, err = io.Copy(tempFile, body)
+	if err := readError(err, inspection.finish(err)); err != nil {
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		return newError(KindInternal, "Could not write file to disk", err)
+	}
+	u.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// readError reports a failure to save the body: an inspector's rejection,
+// or else err, which is too large if it went over the request's size limit.
+func readError(err, rejection error) error {
+	if rejection != nil {
+		return rejection
+	}
+	if err == nil {
+		return nil
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return newError(KindTooLarge, "Upload exceeds the size limit", err)
+	}
+	return newError(KindInternal, "Could not write file to disk", err)
+}
+
+// Prober probes a saved upload whose SHA-256 is known, on disk or in memory.
+type Prober interface {
+	Probe(filePath, sha256Hex string) (processing.Probe, error)
+	ProbeData(data []byte, sha256Hex string) (processing.Probe, error)
+}
+
+// CachedProber probes with ffprobe, reusing results for identical bytes.
+type CachedProber struct {
+	DB database.Client
+	// Scratch, if set, decrypts the uploads saved to it for ffprobe
+	Scratch *processing.Scratch
+}
+
+func (p CachedProber) Probe(filePath, sha256Hex string) (processing.Probe, error) {
+	if p.Scratch != nil {
+		return processing.CachedProbeScratch(p.DB, p.Scratch, filePath, sha256Hex)
+	}
+	return processing.CachedProbe(p.DB, filePath, sha256Hex)
+}
+
+func (p CachedProber) ProbeData(data []byte, sha256Hex string) (processing.Probe, error) {
+	return processing.CachedProbeData(p.DB, data, sha256Hex)
+}
+
+// Probe reads the upload's duration and aspect ratio, enforcing the duration
+// limits before any time is spent processing or uploading it. A zero limit
+// is not enforced.
+type Probe struct {
+	Prober      Prober
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// MaxDurationFor, if set, returns the longest video the owner may
+	// upload, such as their plan's limit, or 0 for MaxDuration
+	MaxDurationFor func(video database.Video) (time.Duration, error)
+}
+
+func (Probe) Name() string       { return "probe" }
+func (Probe) Retryable() bool    { return true }
+func (Probe) Requires() []string { return []string{"persist"} }
+
+func (s Probe) Run(ctx context.Context, u *Upload) error {
+	var probe processing.Probe
+	var err error
+	if u.Data != nil {
+		probe, err = s.Prober.ProbeData(u.Data, u.SHA256)
+	} else {
+		probe, err = s.Prober.Probe(u.TempPath, u.SHA256)
+	}
+	if err != nil {
+		return newError(KindInvalid, "Error probing video", err)
+	}
+	u.Duration, err = probe.Duration()
+	if err != nil {
+		return newError(KindInvalid, "Error determining duration", err)
+	}
+	maxDuration := s.MaxDuration
+	if s.MaxDurationFor != nil {
+		ownerMax, err := s.MaxDurationFor(u.Video)
+		if err != nil {
+			return newError(KindInternal, "Couldn't get duration limit", err)
+		}
+		if ownerMax > 0 {
+			maxDuration = ownerMax
+		}
+	}
+	if err := s.checkDuration(u.Duration, maxDuration); err != nil {
+		return newError(KindInvalidDuration, "Invalid video duration: "+err.Error(), nil)
+	}
+
+	// Key templates can file videos by orientation
+	aspectRatio, err := probe.AspectRatio()
+	if err != nil {
+		return newError(KindInternal, "Error determining aspect ratio", err)
+	}
+	u.Orientation = processing.OrientationForAspectRatio(aspectRatio)
+	return nil
+}
+
+func (s Probe) checkDuration(seconds float64, maxDuration time.Duration) error {
+	duration := time.Duration(seconds * float64(time.Second))
+	if s.MinDuration > 0 && duration < s.MinDuration {
+		return fmt.Errorf("video is too short, minimum duration is %s", s.MinDuration)
+	}
+	if maxDuration > 0 && duration > maxDuration {
+		return fmt.Errorf("video is too long, maximum duration is %s", maxDuration)
+	}
+	return nil
+}
+
+// Thumbnail gives videos that don't have a thumbnail yet one taken from a
+// frame of the upload.
+type Thumbnail struct {
+	// Frame returns the frame at the given offset in seconds as a JPEG
+	Frame func(filePath string, at float64) ([]byte, error)
+	// FrameData is Frame for uploads held in memory
+	FrameData func(data []byte, at float64) ([]byte, error)
+	// Save stores a JPEG as the video's thumbnail, returning the updated video
+	Save func(video database.Video, jpeg []byte) (database.Video, error)
+	// At is the offset of the frame, capped to the middle of short videos
+	At time.Duration
+}
+
+func (Thumbnail) Name() string       { return "thumbnail" }
+func (Thumbnail) Retryable() bool    { return true }
+func (Thumbnail) Requires() []string { return []string{"probe"} }
+
+func (s Thumbnail) Run(ctx context.Context, u *Upload) error {
+	if u.Video.ThumbnailURL != nil {
+		return nil
+	}
+
+	at := min(s.At.Seconds(), u.Duration/2)
+	var frame []byte
+	var err error
+	if u.Data != nil {
+		frame, err = s.FrameData(u.Data, at)
+	} else {
+		frame, err = s.Frame(u.TempPath, at)
+	}
+	if err != nil {
+		return newError(KindInternal, "Couldn't extract a thumbnail", err)
+	}
+	video, err := s.Save(u.Video, frame)
+	if err != nil {
+		return newError(KindInternal, "Couldn't save thumbnail", err)
+	}
+	u.Video = video
+	return nil
+}
+
+// Store picks the key the processed video will be stored under, laid out by
+// Keys and namespaced under the owner's tenant or in their own bucket, and
+// stages the upload in the bucket when Staging is set.
+type Store struct {
+	Storage func(video database.Video) (processing.Storage, error)
+	// Keys lays out object keys, processing.DefaultKeyTemplate if empty
+	Keys processing.KeyTemplate
+	// NewName returns a random name for a stored object
+	NewName func() (string, error)
+	// Exists reports whether a bucket already has an object under a key, so
+	// a new upload never takes over another's key
+	Exists  func(ctx context.Context, bucket, key string) (bool, error)
+	Staging *Staging
+	// Files opens uploads saved to a temp file, to stage them
+	Files TempFiles
+}
+
+// Staging copies uploads to the bucket, for workers that can't see this
+// host's disk.
+type Staging struct {
+	Client processing.ObjectUploader
+	Bucket string
+	Prefix string
+	// Name returns a random object name for the media type
+	Name func(mediaType string) (string, error)
+}
+
+func (Store) Name() string       { return "store" }
+func (Store) Retryable() bool    { return true }
+func (Store) Requires() []string { return []string{"probe"} }
+
+func (s Store) Run(ctx context.Context, u *Upload) error {
+	storage, err := s.Storage(u.Video)
+	if err != nil {
+		return newError(KindInternal, "Couldn't resolve video storage", err)
+	}
+	objectKey, err := processing.UnusedName(func() (string, error) {
+		random, err := s.NewName()
+		if err != nil {
+			return "", err
+		}
+		name := s.Keys.Name(processing.KeyFields{
+			UserID:      u.Video.UserID,
+			VideoID:     u.Video.ID,
+			Orientation: u.Orientation,
+			Profile:     u.Profile.Name,
+			Random:      random,
+		})
+		return storage.Key(u.Profile.ObjectName(name)), nil
+	}, func(key string) (bool, error) {
+		return s.Exists(ctx, storage.Bucket, key)
+	})
+	if err != nil {
+		return newError(KindInternal, "Couldn't pick a key for the video", err)
+	}
+	u.ObjectKey = objectKey
+
+	if s.Staging == nil {
+		return nil
+	}
+	var body io.Reader = bytes.NewReader(u.Data)
+	size := int64(len(u.Data))
+	if u.Data == nil {
+		file, err := s.Files.Open(u.TempPath)
+		if err != nil {
+			return newError(KindInternal, "Could not open upload", err)
+		}
+		defer file.Close()
+		size, err = file.Size()
+		if err != nil {
+			return newError(KindInternal, "Could not open upload", err)
+		}
+		body = file
+	}
+
+	sourceKey, err := processing.UnusedName(func() (string, error) {
+		name, err := s.Staging.Name(u.MediaType)
+		return path.Join(s.Staging.Prefix, name), err
+	}, func(key string) (bool, error) {
+		return s.Exists(ctx, s.Staging.Bucket, key)
+	})
+	if err != nil {
+		return newError(KindInternal, "Error staging upload", err)
+	}
+	err = processing.UploadObject(ctx, s.Staging.Client, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Staging.Bucket),
+		Key:         aws.String(sourceKey),
+		Body:        body,
+		ContentType: aws.String(u.MediaType),
+	}, size)
+	if err != nil {
+		return newError(KindInternal, "Error staging upload", err)
+	}
+	u.SourceKey = &sourceKey
+	return nil
+}
+
+// JobCreator persists processing jobs, such as a database.Client.
+type JobCreator interface {
+	CreateJob(params database.CreateJobParams) (database.Job, error)
+}
+
+// Finalize persists the processing job, so the upload can be resumed after a
+// crash. Jobs without a staged copy take over the temp file.
+type Finalize struct {
+	Jobs JobCreator
+}
+
+func (Finalize) Name() string       { return "finalize" }
+func (Finalize) Retryable() bool    { return true }
+func (Finalize) Requires() []string { return []string{"store"} }
+
+func (s Finalize) Run(ctx context.Context, u *Upload) error {
+	job, err := s.Jobs.CreateJob(database.CreateJobParams{
+		VideoID:      u.Video.ID,
+		UserID:       u.Video.UserID,
+		MediaType:    u.MediaType,
+		SourcePath:   u.TempPath,
+		SourceKey:    u.SourceKey,
+		ObjectKey:    u.ObjectKey,
+		Duration:     u.Duration,
+		SourceSHA256: u.SHA256,
+		Profile:      u.Profile.Name,
+		Notify:       u.Notify,
+		Priority:     u.Priority,
+	})
+	if errors.Is(err, database.ErrInvalidVideoTransition) {
+		return newError(KindConflict, "Video can't take another upload", err)
+	}
+	if err != nil {
+		return newError(KindInternal, "Couldn't create processing job", err)
+	}
+	u.Job = job
+	u.handedOff = u.SourceKey == nil
+	return nil
+}
+
+// Runner runs processing jobs, such as a *processing.Processor.
+type Runner interface {
+	RunWith(ctx context.Context, job database.Job, update func(*database.Video)) (database.Video, error)
+	RunWithSource(ctx context.Context, job database.Job, source []byte, update func(*database.Video)) (database.Video, error)
+}
+
+// Process runs the upload's job within the request.
+type Process struct {
+	Runner Runner
+}
+
+func (Process) Name() string       { return "process" }
+func (Process) Requires() []string { return []string{"finalize"} }
+
+func (s Process) Run(ctx context.Context, u *Upload) error {
+
+	// Run the job without the request's cancellation, so a client disconnecting
+	// mid-way doesn't abandon a half-finished job, but within any configured timeout
+	runCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithDeadline(runCtx, deadline)
+		defer cancel()
+	}
+	var video database.Video
+	var err error
+	if u.Data != nil {
+		video, err = s.Runner.RunWithSource(runCtx, u.Job, u.Data, u.Update)
+	} else {
+		video, err = s.Runner.RunWith(runCtx, u.Job, u.Update)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return newError(KindProcessingTimeout, "Timed out processing video", err)
+		}
+		if errors.Is(err, database.ErrJobCancelled) {
+			return newError(KindCancelled, "Processing was cancelled", err)
+		}
+		return newError(KindProcessingFailed, "Error processing video", err)
+	}
+	u.Result = video
+	return nil
+}
@@ -0,0 +1,604 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// fakeTempFiles creates temp files in a test's directory, remembering their paths.
+type fakeTempFiles struct {
+	scratch *processing.Scratch
+	err     error
+	paths   []string
+}
+
+func newFakeTempFiles(t *testing.T, encrypt bool, err error) *fakeTempFiles {
+	t.Helper()
+	scratch, scratchErr := processing.NewScratch([]string{t.TempDir()}, "")
+	if scratchErr != nil {
+		t.Fatal(scratchErr)
+	}
+	if encrypt {
+		if err := scratch.EnableEncryption(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &fakeTempFiles{scratch: scratch, err: err}
+}
+
+func (f *fakeTempFiles) CreateTempFile(pattern string) (*processing.ScratchFile, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	file, err := f.scratch.CreateTempFile(pattern)
+	if err == nil {
+		f.paths = append(f.paths, file.Name())
+	}
+	return file, err
+}
+
+func (f *fakeTempFiles) Open(name string) (*processing.ScratchFile, error) {
+	return f.scratch.Open(name)
+}
+
+// fakeProber returns the same probe for every upload.
+type fakeProber struct {
+	probe processing.Probe
+	err   error
+}
+
+func (p fakeProber) Probe(filePath, sha256Hex string) (processing.Probe, error) {
+	return p.probe, p.err
+}
+
+func (p fakeProber) ProbeData(data []byte, sha256Hex string) (processing.Probe, error) {
+	return p.probe, p.err
+}
+
+// fakeJobs creates jobs without a database.
+type fakeJobs struct {
+	err error
+}
+
+func (j fakeJobs) CreateJob(params database.CreateJobParams) (database.Job, error) {
+	if j.err != nil {
+		return database.Job{}, j.err
+	}
+	return database.Job{ID: uuid.New(), CreateJobParams: params}, nil
+}
+
+// fakeStage runs a function as a stage.
+type fakeStage struct {
+	name string
+	run  func(u *Upload) error
+}
+
+func (s fakeStage) Name() string { return s.name }
+
+func (s fakeStage) Run(ctx context.Context, u *Upload) error { return s.run(u) }
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+func videoProbe(duration string, width, height int) processing.Probe {
+	return processing.Probe{
+		Streams: []processing.ProbeStream{{CodecType: "video", Width: width, Height: height}},
+		Format:  processing.ProbeFormat{Duration: duration},
+	}
+}
+
+func errorKind(t *testing.T, err error) Kind {
+	t.Helper()
+	var ingestErr *Error
+	if !errors.As(err, &ingestErr) {
+		t.Fatalf("error %v is not an *Error", err)
+	}
+	return ingestErr.Kind
+}
+
+func TestValidate(t *testing.T) {
+	stage := Validate{MediaTypes: []string{"video/mp4"}}
+	tests := []struct {
+		mediaType string
+		wantErr   bool
+	}{
+		{"video/mp4", false},
+		{"video/quicktime", true},
+		{"image/png", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mediaType, func(t *testing.T) {
+			err := stage.Run(context.Background(), &Upload{MediaType: tt.mediaType})
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Run() = %v, want nil", err)
+				}
+				return
+			}
+			if kind := errorKind(t, err); kind != KindInvalidMediaType {
+				t.Errorf("Run() kind = %v, want KindInvalidMediaType", kind)
+			}
+		})
+	}
+}
+
+func TestPersistTemp(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     io.Reader
+		encrypt  bool
+		files    error
+		wantKind Kind
+		wantErr  bool
+	}{
+		{name: "saves body", body: strings.NewReader("video bytes")},
+		{name: "saves body encrypted", body: strings.NewReader("video bytes"), encrypt: true},
+		{name: "over size limit", body: errReader{&http.MaxBytesError{Limit: 10}}, wantKind: KindTooLarge, wantErr: true},
+		{name: "read failure", body: errReader{errors.New("connection reset")}, wantKind: KindInternal, wantErr: true},
+		{name: "no temp file", body: strings.NewReader("video bytes"), files: errors.New("disk full"), wantKind: KindInternal, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := newFakeTempFiles(t, tt.encrypt, tt.files)
+			u := &Upload{Body: tt.body}
+			err := PersistTemp{Files: files}.Run(context.Background(), u)
+			if tt.wantErr {
+				if kind := errorKind(t, err); kind != tt.wantKind {
+					t.Errorf("Run() kind = %v, want %v", kind, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() = %v, want nil", err)
+			}
+			onDisk, err := os.ReadFile(u.TempPath)
+			if err != nil {
+				t.Fatalf("reading temp file: %v", err)
+			}
+			if encrypted := !strings.Contains(string(onDisk), "video bytes"); encrypted != tt.encrypt {
+				t.Errorf("temp file encrypted = %v, want %v", encrypted, tt.encrypt)
+			}
+			file, err := files.Open(u.TempPath)
+			if err != nil {
+				t.Fatalf("opening temp file: %v", err)
+			}
+			defer file.Close()
+			data, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatalf("reading temp file: %v", err)
+			}
+			if string(data) != "video bytes" {
+				t.Errorf("temp file = %q, want %q", data, "video bytes")
+			}
+			if u.SHA256 == "" {
+				t.Error("SHA256 was not set")
+			}
+			if u.Size != int64(len("video bytes")) {
+				t.Errorf("Size = %d, want %d", u.Size, len("video bytes"))
+			}
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name            string
+		probe           processing.Probe
+		proberErr       error
+		min, max        time.Duration
+		ownerMax        time.Duration
+		wantKind        Kind
+		wantErr         bool
+		wantOrientation string
+	}{
+		{name: "landscape", probe: videoProbe("30", 1920, 1080), wantOrientation: "landscape"},
+		{name: "portrait", probe: videoProbe("30", 1080, 1920), wantOrientation: "portrait"},
+		{name: "square", probe: videoProbe("30", 1080, 1080), wantOrientation: "other"},
+		{name: "within limits", probe: videoProbe("30", 1920, 1080), min: time.Second, max: time.Minute, wantOrientation: "landscape"},
+		{name: "too short", probe: videoProbe("0.5", 1920, 1080), min: time.Second, wantKind: KindInvalidDuration, wantErr: true},
+		{name: "too long", probe: videoProbe("61", 1920, 1080), max: time.Minute, wantKind: KindInvalidDuration, wantErr: true},
+		{name: "owner limit overrides", probe: videoProbe("61", 1920, 1080), max: time.Minute, ownerMax: 2 * time.Minute, wantOrientation: "landscape"},
+		{name: "over owner limit", probe: videoProbe("121", 1920, 1080), ownerMax: 2 * time.Minute, wantKind: KindInvalidDuration, wantErr: true},
+		{name: "no duration", probe: videoProbe("", 1920, 1080), wantKind: KindInvalid, wantErr: true},
+		{name: "probe fails", proberErr: errors.New("not a video"), wantKind: KindInvalid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stage := Probe{
+				Prober:      fakeProber{probe: tt.probe, err: tt.proberErr},
+				MinDuration: tt.min,
+				MaxDuration: tt.max,
+			}
+			if tt.ownerMax > 0 {
+				stage.MaxDurationFor = func(database.Video) (time.Duration, error) { return tt.ownerMax, nil }
+			}
+			u := &Upload{TempPath: "upload.mp4"}
+			err := stage.Run(context.Background(), u)
+			if tt.wantErr {
+				if kind := errorKind(t, err); kind != tt.wantKind {
+					t.Errorf("Run() kind = %v, want %v", kind, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() = %v, want nil", err)
+			}
+			if u.Orientation != tt.wantOrientation {
+				t.Errorf("Orientation = %q, want %q", u.Orientation, tt.wantOrientation)
+			}
+		})
+	}
+}
+
+func TestReceiveTempFile(t *testing.T) {
+	failing := fakeStage{name: "store", run: func(*Upload) error { return errors.New("bucket unavailable") }}
+	tests := []struct {
+		name     string
+		stages   func(files TempFiles) []Stage
+		wantKept bool
+	}{
+		{
+			name: "removed on failure",
+			stages: func(files TempFiles) []Stage {
+				return []Stage{PersistTemp{Files: files}, failing}
+			},
+		},
+		{
+			name: "removed when job isn't created",
+			stages: func(files TempFiles) []Stage {
+				return []Stage{PersistTemp{Files: files}, Finalize{Jobs: fakeJobs{err: errors.New("database locked")}}}
+			},
+		},
+		{
+			name: "kept once handed off",
+			stages: func(files TempFiles) []Stage {
+				return []Stage{PersistTemp{Files: files}, Finalize{Jobs: fakeJobs{}}}
+			},
+			wantKept: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := newFakeTempFiles(t, false, nil)
+			pipeline := Pipeline{Stages: tt.stages(files)}
+			u := &Upload{Body: strings.NewReader("video bytes")}
+			err := pipeline.Receive(context.Background(), u)
+			if tt.wantKept && err != nil {
+				t.Fatalf("Receive() = %v, want nil", err)
+			}
+			if !tt.wantKept && err == nil {
+				t.Fatal("Receive() = nil, want an error")
+			}
+			if len(files.paths) != 1 {
+				t.Fatalf("created %d temp files, want 1", len(files.paths))
+			}
+			_, statErr := os.Stat(files.paths[0])
+			if kept := statErr == nil; kept != tt.wantKept {
+				t.Errorf("temp file kept = %v, want %v", kept, tt.wantKept)
+			}
+			if tt.wantKept && u.Job.SourcePath != files.paths[0] {
+				t.Errorf("job source = %q, want %q", u.Job.SourcePath, files.paths[0])
+			}
+		})
+	}
+}
+
+func TestReceiveErrorStage(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantStage string
+		wantKind  Kind
+	}{
+		{name: "stage error", err: newError(KindInvalid, "Bad upload", nil), wantStage: "check", wantKind: KindInvalid},
+		{name: "plain error", err: errors.New("boom"), wantStage: "check", wantKind: KindInternal},
+		{name: "named stage kept", err: &Error{Stage: "scan", Kind: KindMalware, Msg: "Infected"}, wantStage: "scan", wantKind: KindMalware},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := Pipeline{Stages: []Stage{
+				fakeStage{name: "check", run: func(*Upload) error { return tt.err }},
+			}}
+			err := pipeline.Receive(context.Background(), &Upload{})
+			var ingestErr *Error
+			if !errors.As(err, &ingestErr) {
+				t.Fatalf("Receive() = %v, want an *Error", err)
+			}
+			if ingestErr.Stage != tt.wantStage {
+				t.Errorf("Stage = %q, want %q", ingestErr.Stage, tt.wantStage)
+			}
+			if ingestErr.Kind != tt.wantKind {
+				t.Errorf("Kind = %v, want %v", ingestErr.Kind, tt.wantKind)
+			}
+			if !strings.HasPrefix(err.Error(), tt.wantStage+": ") {
+				t.Errorf("Error() = %q, want it to start with the stage", err.Error())
+			}
+		})
+	}
+}
+
+func TestFinalizeConflict(t *testing.T) {
+	u := &Upload{TempPath: filepath.Join(t.TempDir(), "upload.mp4")}
+	err := Finalize{Jobs: fakeJobs{err: database.ErrInvalidVideoTransition}}.Run(context.Background(), u)
+	if kind := errorKind(t, err); kind != KindConflict {
+		t.Errorf("Run() kind = %v, want KindConflict", kind)
+	}
+	if u.handedOff {
+		t.Error("upload was handed off without a job")
+	}
+}
+
+// fakeUploader keeps objects put to it in memory, failing every put with err if it's set.
+type fakeUploader struct {
+	processing.ObjectUploader
+	err     error
+	objects map[string][]byte
+}
+
+func (u *fakeUploader) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if u.objects == nil {
+		u.objects = map[string][]byte{}
+	}
+	u.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+// fakeRunner returns the same result for every job, remembering how it was run.
+type fakeRunner struct {
+	video  database.Video
+	err    error
+	source []byte
+	ctxErr error
+}
+
+func (r *fakeRunner) RunWith(ctx context.Context, job database.Job, update func(*database.Video)) (database.Video, error) {
+	r.ctxErr = ctx.Err()
+	return r.video, r.err
+}
+
+func (r *fakeRunner) RunWithSource(ctx context.Context, job database.Job, source []byte, update func(*database.Video)) (database.Video, error) {
+	r.source = source
+	return r.RunWith(ctx, job, update)
+}
+
+// newTestStore returns a Store that names objects name1, name2, and so on,
+// treating name1 as already taken.
+func newTestStore(staging *Staging, files TempFiles) Store {
+	names := 0
+	return Store{
+		Storage: func(database.Video) (processing.Storage, error) {
+			return processing.Storage{Bucket: "tenant-bucket", KeyPrefix: "acme"}, nil
+		},
+		Keys: "{random}",
+		NewName: func() (string, error) {
+			names++
+			return fmt.Sprintf("name%d", names), nil
+		},
+		Exists: func(ctx context.Context, bucket, key string) (bool, error) {
+			return strings.HasSuffix(key, "name1.mp4"), nil
+		},
+		Staging: staging,
+		Files:   files,
+	}
+}
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		file       string
+		storageErr error
+		uploadErr  error
+		staged     bool
+		wantKind   Kind
+		wantErr    bool
+	}{
+		{name: "picks an unused key in the video's storage"},
+		{name: "stages upload from memory", data: []byte("video bytes"), staged: true},
+		{name: "stages upload from temp file", file: "video bytes", staged: true},
+		{name: "no storage", storageErr: errors.New("tenant not found"), wantKind: KindInternal, wantErr: true},
+		{name: "staging fails", data: []byte("video bytes"), staged: true, uploadErr: errors.New("access denied"), wantKind: KindInternal, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := newFakeTempFiles(t, true, nil)
+			uploader := &fakeUploader{err: tt.uploadErr}
+			var staging *Staging
+			if tt.staged {
+				staging = &Staging{
+					Client: uploader,
+					Bucket: "staging-bucket",
+					Prefix: "uploads",
+					Name:   func(string) (string, error) { return "source.mp4", nil },
+				}
+			}
+			stage := newTestStore(staging, files)
+			if tt.storageErr != nil {
+				stage.Storage = func(database.Video) (processing.Storage, error) {
+					return processing.Storage{}, tt.storageErr
+				}
+			}
+
+			u := &Upload{MediaType: "video/mp4", Data: tt.data}
+			if tt.file != "" {
+				file, err := files.CreateTempFile("upload-*.mp4")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := file.Write([]byte(tt.file)); err != nil {
+					t.Fatal(err)
+				}
+				file.Close()
+				u.TempPath = file.Name()
+			}
+
+			err := stage.Run(context.Background(), u)
+			if tt.wantErr {
+				if kind := errorKind(t, err); kind != tt.wantKind {
+					t.Errorf("Run() kind = %v, want %v", kind, tt.wantKind)
+				}
+				if u.SourceKey != nil {
+					t.Errorf("SourceKey = %q, want nil", *u.SourceKey)
+				}
+				if len(uploader.objects) != 0 {
+					t.Errorf("objects left in the bucket: %v", uploader.objects)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() = %v, want nil", err)
+			}
+			if u.ObjectKey != "acme/name2.mp4" {
+				t.Errorf("ObjectKey = %q, want %q", u.ObjectKey, "acme/name2.mp4")
+			}
+			if !tt.staged {
+				if u.SourceKey != nil {
+					t.Errorf("SourceKey = %q, want nil", *u.SourceKey)
+				}
+				return
+			}
+			if u.SourceKey == nil || *u.SourceKey != "uploads/source.mp4" {
+				t.Fatalf("SourceKey = %v, want %q", u.SourceKey, "uploads/source.mp4")
+			}
+			if got := string(uploader.objects["staging-bucket/uploads/source.mp4"]); got != "video bytes" {
+				t.Errorf("staged object = %q, want %q", got, "video bytes")
+			}
+		})
+	}
+}
+
+func TestReceiveStaged(t *testing.T) {
+	tests := []struct {
+		name      string
+		uploadErr error
+		wantErr   bool
+	}{
+		{name: "temp file removed once staged"},
+		{name: "temp file removed when staging fails", uploadErr: errors.New("access denied"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := newFakeTempFiles(t, false, nil)
+			uploader := &fakeUploader{err: tt.uploadErr}
+			staging := &Staging{
+				Client: uploader,
+				Bucket: "staging-bucket",
+				Name:   func(string) (string, error) { return "source.mp4", nil },
+			}
+			pipeline := Pipeline{Stages: []Stage{
+				PersistTemp{Files: files},
+				newTestStore(staging, files),
+				Finalize{Jobs: fakeJobs{}},
+			}}
+			u := &Upload{MediaType: "video/mp4", Body: strings.NewReader("video bytes")}
+			err := pipeline.Receive(context.Background(), u)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Receive() = nil, want an error")
+				}
+				if u.Job.ID != uuid.Nil {
+					t.Error("job was created for an upload that wasn't staged")
+				}
+				if len(uploader.objects) != 0 {
+					t.Errorf("objects left in the bucket: %v", uploader.objects)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Receive() = %v, want nil", err)
+				}
+				if u.Job.SourceKey == nil || *u.Job.SourceKey != "source.mp4" {
+					t.Errorf("job source key = %v, want %q", u.Job.SourceKey, "source.mp4")
+				}
+			}
+			if len(files.paths) != 1 {
+				t.Fatalf("created %d temp files, want 1", len(files.paths))
+			}
+			if _, err := os.Stat(files.paths[0]); !os.IsNotExist(err) {
+				t.Errorf("temp file kept, want it removed (stat error %v)", err)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	processed := database.Video{ID: uuid.New(), State: database.VideoStateReady}
+	tests := []struct {
+		name     string
+		data     []byte
+		err      error
+		wantKind Kind
+		wantErr  bool
+	}{
+		{name: "runs from temp file"},
+		{name: "runs from memory", data: []byte("video bytes")},
+		{name: "job cancelled", err: fmt.Errorf("transcoding: %w", database.ErrJobCancelled), wantKind: KindCancelled, wantErr: true},
+		{name: "timed out", err: context.DeadlineExceeded, wantKind: KindProcessingTimeout, wantErr: true},
+		{name: "processing fails", err: errors.New("ffmpeg exited 1"), wantKind: KindProcessingFailed, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{video: processed, err: tt.err}
+			pipeline := Pipeline{ProcessStage: Process{Runner: runner}}
+
+			// The client has gone, but the job still runs to the end
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			u := &Upload{Data: tt.data}
+			err := pipeline.Process(ctx, u)
+			if runner.ctxErr != nil {
+				t.Errorf("job ran with context error %v, want none", runner.ctxErr)
+			}
+			if string(runner.source) != string(tt.data) {
+				t.Errorf("job source = %q, want %q", runner.source, tt.data)
+			}
+			if tt.wantErr {
+				var ingestErr *Error
+				if !errors.As(err, &ingestErr) {
+					t.Fatalf("Process() = %v, want an *Error", err)
+				}
+				if ingestErr.Kind != tt.wantKind {
+					t.Errorf("Process() kind = %v, want %v", ingestErr.Kind, tt.wantKind)
+				}
+				if ingestErr.Stage != "process" {
+					t.Errorf("Stage = %q, want %q", ingestErr.Stage, "process")
+				}
+				if u.Result.ID != uuid.Nil {
+					t.Error("Result was set for a failed job")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process() = %v, want nil", err)
+			}
+			if u.Result.ID != processed.ID {
+				t.Errorf("Result = %v, want %v", u.Result.ID, processed.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+)
+
+// SMTP is the mail server email notifications are sent through.
+type SMTP struct {
+	Host string
+	Port string
+	// Username and Password authenticate with the server when set
+	Username string
+	Password string
+	From     string
+}
+
+// Email sends messages to one address.
+type Email struct {
+	SMTP SMTP
+	To   string
+}
+
+func (Email) Name() string { return "email" }
+
+// Send delivers the message, upgrading to TLS when the server offers it.
+// net/smtp can't be cancelled, so ctx is only checked before sending.
+func (e Email) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if e.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", e.SMTP.Username, e.SMTP.Password, e.SMTP.Host)
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		e.SMTP.From, e.To, headerValue(msg.Subject), strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+	return smtp.SendMail(net.JoinHostPort(e.SMTP.Host, e.SMTP.Port), auth, e.SMTP.From, []string{e.To}, []byte(body))
+}
+
+// headerValue keeps a value on one header line, so a title can't add headers.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// Slack posts messages to an incoming webhook.
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+func (Slack) Name() string { return "slack" }
+
+func (s Slack) Send(ctx context.Context, msg Message) error {
+	return postJSON(ctx, s.Client, s.URL, map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", msg.Subject, msg.Text),
+	})
+}
+
+// Discord posts messages to a channel webhook.
+type Discord struct {
+	URL    string
+	Client *http.Client
+}
+
+func (Discord) Name() string { return "discord" }
+
+func (d Discord) Send(ctx context.Context, msg Message) error {
+	return postJSON(ctx, d.Client, d.URL, map[string]string{
+		"content": fmt.Sprintf("**%s**\n%s", msg.Subject, msg.Text),
+	})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// CheckSlackURL reports whether rawURL is a Slack incoming webhook. Only
+// Slack's own host is accepted, so the server can't be pointed at anything else.
+func CheckSlackURL(rawURL string) error {
+	return checkWebhookURL(rawURL, []string{"hooks.slack.com"}, "/services/")
+}
+
+// CheckDiscordURL reports whether rawURL is a Discord channel webhook.
+func CheckDiscordURL(rawURL string) error {
+	return checkWebhookURL(rawURL, []string{"discord.com", "discordapp.com"}, "/api/webhooks/")
+}
+
+func checkWebhookURL(rawURL string, hosts []string, pathPrefix string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.User != nil || !strings.HasPrefix(u.Path, pathPrefix) {
+		return errors.New("not a webhook URL")
+	}
+	for _, host := range hosts {
+		if u.Host == host {
+			return nil
+		}
+	}
+	return fmt.Errorf("host must be %s", strings.Join(hosts, " or "))
+}
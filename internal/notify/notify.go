@@ -0,0 +1,210 @@
+// Package notify tells creators when their uploads finish processing, over
+// whichever channels they set up: email, Slack or Discord.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// sendTimeout bounds each delivery, so a slow channel can't hold up the job
+// that finished.
+const sendTimeout = 10 * time.Second
+
+// Choices of where an upload's processing result is sent. ChannelAll sends
+// it over every channel the user set up, ChannelNone nowhere, and the rest
+// over that channel alone.
+const (
+	ChannelAll     = "all"
+	ChannelEmail   = "email"
+	ChannelSlack   = "slack"
+	ChannelDiscord = "discord"
+	ChannelNone    = "none"
+)
+
+// ChannelChoices are the values an upload's notification channel can take.
+var ChannelChoices = []string{ChannelAll, ChannelEmail, ChannelSlack, ChannelDiscord, ChannelNone}
+
+// Message is a notification, written for people rather than programs.
+type Message struct {
+	Subject string
+	Text    string
+}
+
+// Channel delivers messages to one destination.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// Delivery is the outcome of sending a message over one channel.
+type Delivery struct {
+	Channel string  `json:"channel"`
+	Error   *string `json:"error"`
+}
+
+// Notifier sends users the notifications their settings ask for.
+type Notifier struct {
+	DB database.Client
+	// SMTP sends email; nil turns email notifications off
+	SMTP *SMTP
+	// HTTPClient posts to webhooks
+	HTTPClient *http.Client
+	// VideoURL returns the page a video is watched on, or "" when it isn't known
+	VideoURL func(videoID uuid.UUID) string
+}
+
+// EmailEnabled reports whether email notifications can be sent.
+func (n *Notifier) EmailEnabled() bool {
+	return n.SMTP != nil
+}
+
+// JobFinished tells a job's owner that their video is ready, or that
+// processing failed with jobErr, if they asked to hear about it, over the
+// channel the upload chose. Delivery problems are logged rather than
+// returned, since the job's outcome stands either way.
+func (n *Notifier) JobFinished(ctx context.Context, job database.Job, jobErr error) {
+	if job.Notify == ChannelNone {
+		return
+	}
+	settings, err := n.DB.GetNotificationSettings(job.UserID)
+	if err != nil {
+		log.Printf("Couldn't load notification settings for user %s: %v", job.UserID, err)
+		return
+	}
+	if jobErr == nil && !settings.OnSuccess || jobErr != nil && !settings.OnFailure {
+		return
+	}
+	settings = onlyChannel(settings, job.Notify)
+	video, err := n.DB.GetVideo(job.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		return
+	}
+
+	msg := Message{
+		Subject: fmt.Sprintf("%q is ready", video.Title),
+		Text:    fmt.Sprintf("Your video %q finished processing and is ready to watch.", video.Title),
+	}
+	if jobErr != nil {
+		msg = Message{
+			Subject: fmt.Sprintf("%q failed to process", video.Title),
+			Text:    fmt.Sprintf("Processing your video %q failed: %v", video.Title, jobErr),
+		}
+	}
+	if n.VideoURL != nil {
+		if url := n.VideoURL(video.ID); url != "" {
+			msg.Text += "\n\n" + url
+		}
+	}
+
+	for _, delivery := range n.Send(ctx, settings, msg) {
+		if delivery.Error != nil {
+			log.Printf("Couldn't notify user %s by %s: %s", job.UserID, delivery.Channel, *delivery.Error)
+		}
+	}
+}
+
+// VideoRestored tells a video's owner that the restore they asked for
+// finished and the video can be played until restoredUntil. It's sent
+// whenever they have channels set up, since they asked for the restore.
+func (n *Notifier) VideoRestored(ctx context.Context, video database.Video, restoredUntil time.Time) {
+	settings, err := n.DB.GetNotificationSettings(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't load notification settings for user %s: %v", video.UserID, err)
+		return
+	}
+
+	msg := Message{
+		Subject: fmt.Sprintf("%q is playable again", video.Title),
+		Text: fmt.Sprintf("Your video %q was restored from the archive and can be played until %s.",
+			video.Title, restoredUntil.Format(time.RFC1123)),
+	}
+	if n.VideoURL != nil {
+		if url := n.VideoURL(video.ID); url != "" {
+			msg.Text += "\n\n" + url
+		}
+	}
+
+	for _, delivery := range n.Send(ctx, settings, msg) {
+		if delivery.Error != nil {
+			log.Printf("Couldn't notify user %s by %s: %s", video.UserID, delivery.Channel, *delivery.Error)
+		}
+	}
+}
+
+// Send delivers a message over every channel in settings.
+func (n *Notifier) Send(ctx context.Context, settings database.NotificationSettings, msg Message) []Delivery {
+	deliveries := []Delivery{}
+	channels, err := n.channels(settings)
+	if err != nil {
+		msg := err.Error()
+		deliveries = append(deliveries, Delivery{Channel: "email", Error: &msg})
+	}
+
+	for _, channel := range channels {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		delivery := Delivery{Channel: channel.Name()}
+		if err := channel.Send(sendCtx, msg); err != nil {
+			msg := err.Error()
+			delivery.Error = &msg
+		}
+		cancel()
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// onlyChannel narrows settings to one channel choice, leaving them as they
+// are for ChannelAll or no choice.
+func onlyChannel(settings database.NotificationSettings, channel string) database.NotificationSettings {
+	if channel == "" || channel == ChannelAll {
+		return settings
+	}
+	if channel != ChannelEmail {
+		settings.Email = false
+	}
+	if channel != ChannelSlack {
+		settings.SlackWebhookURL = ""
+	}
+	if channel != ChannelDiscord {
+		settings.DiscordWebhookURL = ""
+	}
+	return settings
+}
+
+// channels returns the channels a user's settings turn on, leaving out
+// email with an error if their address can't be found.
+func (n *Notifier) channels(settings database.NotificationSettings) ([]Channel, error) {
+	client := n.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	channels := []Channel{}
+	var emailErr error
+	if settings.Email && n.SMTP != nil {
+		user, err := n.DB.GetUser(settings.UserID)
+		if err == nil && user == nil {
+			err = errors.New("user not found")
+		}
+		if err != nil {
+			emailErr = fmt.Errorf("couldn't find email address: %w", err)
+		} else {
+			channels = append(channels, Email{SMTP: *n.SMTP, To: user.Email})
+		}
+	}
+	if settings.SlackWebhookURL != "" {
+		channels = append(channels, Slack{URL: settings.SlackWebhookURL, Client: client})
+	}
+	if settings.DiscordWebhookURL != "" {
+		channels = append(channels, Discord{URL: settings.DiscordWebhookURL, Client: client})
+	}
+	return channels, emailErr
+}
@@ -0,0 +1,94 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ArchiveStorageClasses are the storage classes objects can be archived to.
+// Objects in them have to be restored before they can be read.
+var ArchiveStorageClasses = []types.StorageClass{
+	types.StorageClassGlacier,
+	types.StorageClassDeepArchive,
+}
+
+// RestoreTiers are the retrieval tiers a restore can ask for, fastest first.
+// Deep Archive can't be restored with the expedited tier.
+var RestoreTiers = []types.Tier{
+	types.TierExpedited,
+	types.TierStandard,
+	types.TierBulk,
+}
+
+// restoreExpiryPattern reads when a finished restore's copy expires from
+// the x-amz-restore header, like
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+var restoreExpiryPattern = regexp.MustCompile(`expiry-date="([^"]+)"`)
+
+// ArchiveObject moves an object to an archive storage class by copying it
+// over itself, keeping its metadata and asking S3 for a SHA-256 checksum so
+// fixity checks keep working. Objects over 5 GB can't be copied in one
+// request, and fail.
+func ArchiveObject(ctx context.Context, client *s3.Client, bucket, key string, storageClass types.StorageClass) error {
+	source := (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(source),
+		StorageClass:      storageClass,
+		MetadataDirective: types.MetadataDirectiveCopy,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	return err
+}
+
+// RestoreObject asks S3 for a temporary, readable copy of an archived
+// object, kept for days once it's ready. Asking again while a restore is
+// running succeeds without starting another.
+func RestoreObject(ctx context.Context, client *s3.Client, bucket, key string, tier types.Tier, days int) error {
+	_, err := client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(int32(days)),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: tier},
+		},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+// RestoredUntil reports when the restored copy of an archived object
+// expires, or the zero time while it's still being restored or was never
+// asked for.
+func RestoredUntil(ctx context.Context, client *s3.Client, bucket, key string) (time.Time, error) {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	match := restoreExpiryPattern.FindStringSubmatch(aws.ToString(head.Restore))
+	if match == nil {
+		return time.Time{}, nil
+	}
+	expiry, err := time.Parse(time.RFC1123, match[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("couldn't read restore expiry %q: %w", match[1], err)
+	}
+	return expiry.UTC(), nil
+}
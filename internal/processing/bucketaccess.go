@@ -0,0 +1,129 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// accountIDPattern matches a 12-digit AWS account ID.
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// BucketAccess describes how to use buckets owned by another AWS account.
+// It's applied to every call an S3 client makes, presigned URLs included.
+// The zero value adds nothing.
+type BucketAccess struct {
+	// ExpectedOwner is the account ID buckets must belong to. Calls to a
+	// bucket owned by any other account fail with 403 instead of reading or
+	// writing someone else's data.
+	ExpectedOwner string
+
+	// RequesterPays agrees to pay for requests to buckets that have
+	// Requester Pays turned on, which otherwise reject them.
+	RequesterPays bool
+
+	// ACL is the canned ACL written objects get, such as
+	// bucket-owner-full-control so the bucket's owner can manage them.
+	// Leave it empty for buckets with ACLs disabled (BucketOwnerEnforced),
+	// which already own every object and reject any ACL but that one.
+	ACL types.ObjectCannedACL
+}
+
+// Validate reports whether the settings are usable.
+func (a BucketAccess) Validate() error {
+	if a.ExpectedOwner != "" && !accountIDPattern.MatchString(a.ExpectedOwner) {
+		return fmt.Errorf("expected bucket owner %q must be a 12-digit AWS account ID", a.ExpectedOwner)
+	}
+	if a.ACL != "" && !slices.Contains(a.ACL.Values(), a.ACL) {
+		return fmt.Errorf("unknown object ACL %q", a.ACL)
+	}
+	return nil
+}
+
+// Enabled reports whether any calls will be changed.
+func (a BucketAccess) Enabled() bool {
+	return a.ExpectedOwner != "" || a.RequesterPays || a.ACL != ""
+}
+
+// addMiddleware fills in the settings on each call's input before it's
+// serialized, leaving anything a caller set itself alone.
+func (a BucketAccess) addMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("BucketAccess", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		a.apply(in.Parameters)
+		return next.HandleInitialize(ctx, in)
+	}), middleware.Before)
+}
+
+func (a BucketAccess) apply(params any) {
+	var owner **string
+	var payer *types.RequestPayer
+	var acl *types.ObjectCannedACL
+	switch in := params.(type) {
+	case *s3.PutObjectInput:
+		owner, payer, acl = &in.ExpectedBucketOwner, &in.RequestPayer, &in.ACL
+	case *s3.CreateMultipartUploadInput:
+		owner, payer, acl = &in.ExpectedBucketOwner, &in.RequestPayer, &in.ACL
+	case *s3.CopyObjectInput:
+		owner, payer, acl = &in.ExpectedBucketOwner, &in.RequestPayer, &in.ACL
+	case *s3.UploadPartInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.CompleteMultipartUploadInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.AbortMultipartUploadInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.ListPartsInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.ListMultipartUploadsInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.GetObjectInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.HeadObjectInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.DeleteObjectInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.DeleteObjectsInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.ListObjectsV2Input:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.RestoreObjectInput:
+		owner, payer = &in.ExpectedBucketOwner, &in.RequestPayer
+	case *s3.GetBucketLifecycleConfigurationInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.PutBucketLifecycleConfigurationInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.GetBucketCorsInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.PutBucketCorsInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.GetBucketVersioningInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.PutBucketVersioningInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.GetBucketPolicyInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.GetBucketOwnershipControlsInput:
+		owner = &in.ExpectedBucketOwner
+	case *s3.GetPublicAccessBlockInput:
+		owner = &in.ExpectedBucketOwner
+	default:
+		return
+	}
+
+	if a.ExpectedOwner != "" && *owner == nil {
+		*owner = aws.String(a.ExpectedOwner)
+	}
+	if payer != nil && a.RequesterPays && *payer == "" {
+		*payer = types.RequestPayerRequester
+	}
+	if acl != nil && a.ACL != "" && *acl == "" {
+		*acl = a.ACL
+	}
+}
@@ -0,0 +1,244 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Ways to react to a bucket that isn't set up for the configured features
+const (
+	BucketCheckOff    = "off"
+	BucketCheckWarn   = "warn"
+	BucketCheckStrict = "strict"
+)
+
+// Methods a browser uploading straight to the bucket needs: PUT for single
+// uploads and parts, POST to start and complete multipart uploads.
+var directUploadMethods = []string{"PUT", "POST"}
+
+// Canned ACLs that make objects readable by others, which a public access
+// block with BlockPublicAcls rejects.
+var publicACLs = []types.ObjectCannedACL{
+	types.ObjectCannedACLPublicRead,
+	types.ObjectCannedACLPublicReadWrite,
+	types.ObjectCannedACLAuthenticatedRead,
+}
+
+// BucketCheck checks that a bucket's CORS rules, policy and ownership
+// settings let the configured features work, so a misconfigured bucket
+// shows up at startup instead of at the first upload or playback.
+type BucketCheck struct {
+	S3Client *s3.Client
+	Bucket   string
+
+	// DirectUploadOrigins are the sites that upload to the bucket straight
+	// from the browser, which CORS must let PUT and POST and read ETags.
+	DirectUploadOrigins []string
+
+	// PublicReads is set when objects are linked to by their plain URL
+	// instead of a presigned one, which the bucket policy must let the
+	// public or CloudFront read.
+	PublicReads bool
+
+	// ACL is the canned ACL objects are written with, which the bucket's
+	// object ownership and public access block must accept.
+	ACL types.ObjectCannedACL
+}
+
+// Check returns a description of each problem found, with what to do about
+// it. A setting that can't be read is reported as a problem too.
+func (c BucketCheck) Check(ctx context.Context) []string {
+	problems := []string{}
+	if len(c.DirectUploadOrigins) > 0 {
+		problems = append(problems, c.checkCORS(ctx)...)
+	}
+	if c.PublicReads {
+		problems = append(problems, c.checkPublicReads(ctx)...)
+	}
+	if c.ACL != "" {
+		problems = append(problems, c.checkACL(ctx)...)
+	}
+	return problems
+}
+
+func (c BucketCheck) checkCORS(ctx context.Context) []string {
+	out, err := c.S3Client.GetBucketCors(ctx, &s3.GetBucketCorsInput{Bucket: aws.String(c.Bucket)})
+	if err != nil && !isAPIError(err, "NoSuchCORSConfiguration") {
+		return []string{fmt.Sprintf("couldn't read CORS rules to check direct uploads (needs s3:GetBucketCORS): %v", err)}
+	}
+	rules := []types.CORSRule{}
+	if out != nil {
+		rules = out.CORSRules
+	}
+
+	problems := []string{}
+	for _, origin := range c.DirectUploadOrigins {
+		for _, method := range directUploadMethods {
+			// S3 answers a preflight with the first rule matching its origin and method
+			i := slices.IndexFunc(rules, func(r types.CORSRule) bool {
+				return slices.ContainsFunc(r.AllowedOrigins, func(o string) bool { return matchWildcard(o, origin) }) &&
+					slices.Contains(r.AllowedMethods, method)
+			})
+			if i < 0 {
+				problems = append(problems, fmt.Sprintf(
+					"CORS doesn't allow %s from %s, so direct uploads from it will fail; add a rule allowing it or set S3_MANAGE_BUCKET=true",
+					method, origin))
+				continue
+			}
+			rule := rules[i]
+			if !slices.ContainsFunc(rule.AllowedHeaders, func(h string) bool { return matchWildcard(strings.ToLower(h), "content-type") }) {
+				problems = append(problems, fmt.Sprintf(
+					"CORS rule for %s %s doesn't allow the Content-Type header, so browsers' uploads will fail preflight; add it (or *) to AllowedHeaders",
+					method, origin))
+			}
+			if method == "PUT" && !slices.ContainsFunc(rule.ExposeHeaders, func(h string) bool { return strings.EqualFold(h, "ETag") }) {
+				problems = append(problems, fmt.Sprintf(
+					"CORS rule for PUT %s doesn't expose ETag, so browsers can't complete multipart uploads; add it to ExposeHeaders",
+					origin))
+			}
+		}
+	}
+	return problems
+}
+
+func (c BucketCheck) checkPublicReads(ctx context.Context) []string {
+	out, err := c.S3Client.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{Bucket: aws.String(c.Bucket)})
+	if err != nil && !isAPIError(err, "NoSuchBucketPolicy") {
+		return []string{fmt.Sprintf("couldn't read the bucket policy to check unsigned links (needs s3:GetBucketPolicy): %v", err)}
+	}
+	publicRead, cloudFrontRead := false, false
+	if out != nil {
+		publicRead, cloudFrontRead, err = policyGrantsRead(aws.ToString(out.Policy))
+		if err != nil {
+			return []string{fmt.Sprintf("couldn't parse the bucket policy: %v", err)}
+		}
+	}
+	if cloudFrontRead {
+		return nil
+	}
+	if !publicRead {
+		return []string{"the bucket policy doesn't let CloudFront or the public read objects, so unsigned video links will get 403; " +
+			"grant s3:GetObject to the S3_CF_DISTRO distribution, or set FEED_PRESIGNED_URLS=true and HLS_PROXY=true"}
+	}
+
+	block, err := c.S3Client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(c.Bucket)})
+	if err != nil {
+		if isAPIError(err, "NoSuchPublicAccessBlockConfiguration") {
+			return nil
+		}
+		return []string{fmt.Sprintf("couldn't read the public access block to check unsigned links (needs s3:GetBucketPublicAccessBlock): %v", err)}
+	}
+	if settings := block.PublicAccessBlockConfiguration; settings != nil && aws.ToBool(settings.RestrictPublicBuckets) {
+		return []string{"the bucket policy lets the public read objects, but the public access block restricts public buckets, so unsigned video links will get 403; " +
+			"grant s3:GetObject to the S3_CF_DISTRO distribution instead, or turn off RestrictPublicBuckets"}
+	}
+	return nil
+}
+
+func (c BucketCheck) checkACL(ctx context.Context) []string {
+	ownership, err := c.S3Client.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{Bucket: aws.String(c.Bucket)})
+	switch {
+	case err == nil:
+		enforced := ownership.OwnershipControls != nil && slices.ContainsFunc(ownership.OwnershipControls.Rules, func(r types.OwnershipControlsRule) bool {
+			return r.ObjectOwnership == types.ObjectOwnershipBucketOwnerEnforced
+		})
+		if enforced && c.ACL != types.ObjectCannedACLBucketOwnerFullControl {
+			return []string{fmt.Sprintf("the bucket has ACLs disabled (BucketOwnerEnforced), so writes with S3_OBJECT_ACL=%s will be rejected; leave S3_OBJECT_ACL empty", c.ACL)}
+		}
+	case !isAPIError(err, "OwnershipControlsNotFoundError"):
+		return []string{fmt.Sprintf("couldn't read object ownership to check S3_OBJECT_ACL (needs s3:GetBucketOwnershipControls): %v", err)}
+	}
+
+	if !slices.Contains(publicACLs, c.ACL) {
+		return nil
+	}
+	block, err := c.S3Client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(c.Bucket)})
+	if err != nil {
+		if isAPIError(err, "NoSuchPublicAccessBlockConfiguration") {
+			return nil
+		}
+		return []string{fmt.Sprintf("couldn't read the public access block to check S3_OBJECT_ACL (needs s3:GetBucketPublicAccessBlock): %v", err)}
+	}
+	if settings := block.PublicAccessBlockConfiguration; settings != nil && aws.ToBool(settings.BlockPublicAcls) {
+		return []string{fmt.Sprintf("the public access block has BlockPublicAcls on, so writes with S3_OBJECT_ACL=%s will be rejected; use a bucket policy to make objects public instead", c.ACL)}
+	}
+	return nil
+}
+
+// bucketPolicy is the part of a bucket policy that says who may read.
+type bucketPolicy struct {
+	Statement policyList[policyStatement]
+}
+
+type policyStatement struct {
+	Effect    string
+	Principal policyPrincipal
+	Action    policyList[string]
+}
+
+// policyPrincipal is either "*" or a map from principal type to one or
+// more principals.
+type policyPrincipal map[string]policyList[string]
+
+func (p *policyPrincipal) UnmarshalJSON(data []byte) error {
+	var everyone string
+	if err := json.Unmarshal(data, &everyone); err == nil {
+		*p = policyPrincipal{"AWS": {everyone}}
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]policyList[string])(p))
+}
+
+// policyList is a policy element that may be a single value or a list.
+type policyList[T any] []T
+
+func (l *policyList[T]) UnmarshalJSON(data []byte) error {
+	var one T
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = policyList[T]{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]T)(l))
+}
+
+// policyGrantsRead reports whether a bucket policy allows the public, and
+// whether it allows CloudFront, to get objects. Conditions are ignored, so
+// a statement limited to one distribution counts.
+func policyGrantsRead(document string) (public, cloudFront bool, err error) {
+	var policy bucketPolicy
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return false, false, err
+	}
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" ||
+			!slices.ContainsFunc(statement.Action, func(a string) bool { return matchWildcard(strings.ToLower(a), "s3:getobject") }) {
+			continue
+		}
+		if slices.Contains(statement.Principal["AWS"], "*") {
+			public = true
+		}
+		if slices.Contains(statement.Principal["Service"], "cloudfront.amazonaws.com") ||
+			slices.ContainsFunc(statement.Principal["AWS"], func(p string) bool { return strings.Contains(p, ":cloudfront:user/") }) {
+			cloudFront = true
+		}
+	}
+	return public, cloudFront, nil
+}
+
+// matchWildcard reports whether s matches pattern, where pattern may
+// contain one * standing for any run of characters, as S3's CORS origins
+// and policies' actions do.
+func matchWildcard(pattern, s string) bool {
+	prefix, suffix, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == s
+	}
+	return len(s) >= len(prefix)+len(suffix) && strings.HasPrefix(s, prefix) && strings.HasSuffix(s, suffix)
+}
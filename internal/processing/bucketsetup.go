@@ -0,0 +1,247 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// IDs of the rules BucketSetup manages. Rules with any other ID are left
+// as they are.
+const (
+	abortMultipartRuleID = "tubely-abort-incomplete-multipart"
+	directUploadsCORSID  = "tubely-direct-uploads"
+)
+
+// Browsers uploading straight to presigned URLs send parts with PUT and
+// start and complete multipart uploads with POST, and need each part's
+// ETag to complete one.
+var (
+	directUploadsCORSMethods       = []string{"GET", "HEAD", "POST", "PUT"}
+	directUploadsCORSExposeHeaders = []string{"ETag"}
+)
+
+const directUploadsCORSMaxAge = 3600
+
+// BucketSetup brings a bucket's configuration in line with what Tubely
+// needs. Each part is only changed when it differs, so applying it again
+// changes nothing, and other rules in the bucket's configuration are kept.
+type BucketSetup struct {
+	S3Client *s3.Client
+	Bucket   string
+
+	// AbortMultipartAfterDays adds a lifecycle rule aborting multipart
+	// uploads that haven't completed this many days after they started, a
+	// backstop for the multipart sweep. 0 leaves the lifecycle alone.
+	AbortMultipartAfterDays int32
+
+	// CORSOrigins are the sites allowed to upload to and play from the
+	// bucket directly. Empty leaves CORS alone.
+	CORSOrigins []string
+
+	// Versioning turns on versioning. It's never turned off again, since a
+	// versioned bucket can only be suspended.
+	Versioning bool
+
+	// DryRun reports the changes without making them
+	DryRun bool
+}
+
+// Validate reports whether the settings are usable.
+func (s BucketSetup) Validate() error {
+	if s.AbortMultipartAfterDays < 0 {
+		return fmt.Errorf("days to abort incomplete multipart uploads after can't be negative")
+	}
+	for _, origin := range s.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid CORS origin %q, expected scheme://host or *", origin)
+		}
+	}
+	return nil
+}
+
+// ParseOrigins splits a comma-separated list of origins for CORSOrigins.
+func ParseOrigins(list string) []string {
+	origins := []string{}
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return origins
+}
+
+// Apply makes the changes the bucket needs, returning a description of
+// each. With DryRun set it only returns them.
+func (s BucketSetup) Apply(ctx context.Context) ([]string, error) {
+	changes := []string{}
+	if s.AbortMultipartAfterDays > 0 {
+		change, err := s.applyLifecycle(ctx)
+		if err != nil {
+			return changes, fmt.Errorf("couldn't set up lifecycle rule: %w", err)
+		}
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+	if len(s.CORSOrigins) > 0 {
+		change, err := s.applyCORS(ctx)
+		if err != nil {
+			return changes, fmt.Errorf("couldn't set up CORS: %w", err)
+		}
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+	if s.Versioning {
+		change, err := s.applyVersioning(ctx)
+		if err != nil {
+			return changes, fmt.Errorf("couldn't turn on versioning: %w", err)
+		}
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (s BucketSetup) applyLifecycle(ctx context.Context) (string, error) {
+	current, err := s.S3Client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.Bucket),
+	})
+	if err != nil && !isAPIError(err, "NoSuchLifecycleConfiguration") {
+		return "", err
+	}
+	rules := []types.LifecycleRule{}
+	var minimumSize types.TransitionDefaultMinimumObjectSize
+	if current != nil {
+		rules = current.Rules
+		minimumSize = current.TransitionDefaultMinimumObjectSize
+	}
+
+	rule := types.LifecycleRule{
+		ID:     aws.String(abortMultipartRuleID),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilter{Prefix: aws.String("")},
+		AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int32(s.AbortMultipartAfterDays),
+		},
+	}
+	change := fmt.Sprintf("add lifecycle rule aborting incomplete multipart uploads after %d days", s.AbortMultipartAfterDays)
+	i := slices.IndexFunc(rules, func(r types.LifecycleRule) bool { return aws.ToString(r.ID) == abortMultipartRuleID })
+	if i >= 0 {
+		existing := rules[i]
+		if existing.Status == types.ExpirationStatusEnabled &&
+			existing.AbortIncompleteMultipartUpload != nil &&
+			aws.ToInt32(existing.AbortIncompleteMultipartUpload.DaysAfterInitiation) == s.AbortMultipartAfterDays &&
+			existing.Filter != nil && aws.ToString(existing.Filter.Prefix) == "" && existing.Filter.And == nil && existing.Filter.Tag == nil {
+			return "", nil
+		}
+		change = fmt.Sprintf("update lifecycle rule to abort incomplete multipart uploads after %d days", s.AbortMultipartAfterDays)
+		rules = slices.Clone(rules)
+		rules[i] = rule
+	} else {
+		rules = append(slices.Clone(rules), rule)
+	}
+	if s.DryRun {
+		return change, nil
+	}
+
+	_, err = s.S3Client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                             aws.String(s.Bucket),
+		LifecycleConfiguration:             &types.BucketLifecycleConfiguration{Rules: rules},
+		TransitionDefaultMinimumObjectSize: minimumSize,
+	})
+	return change, err
+}
+
+func (s BucketSetup) applyCORS(ctx context.Context) (string, error) {
+	current, err := s.S3Client.GetBucketCors(ctx, &s3.GetBucketCorsInput{
+		Bucket: aws.String(s.Bucket),
+	})
+	if err != nil && !isAPIError(err, "NoSuchCORSConfiguration") {
+		return "", err
+	}
+	rules := []types.CORSRule{}
+	if current != nil {
+		rules = current.CORSRules
+	}
+
+	origins := slices.Sorted(slices.Values(s.CORSOrigins))
+	rule := types.CORSRule{
+		ID:             aws.String(directUploadsCORSID),
+		AllowedOrigins: origins,
+		AllowedMethods: directUploadsCORSMethods,
+		AllowedHeaders: []string{"*"},
+		ExposeHeaders:  directUploadsCORSExposeHeaders,
+		MaxAgeSeconds:  aws.Int32(directUploadsCORSMaxAge),
+	}
+	change := fmt.Sprintf("add CORS rule for direct uploads from %v", origins)
+	i := slices.IndexFunc(rules, func(r types.CORSRule) bool { return aws.ToString(r.ID) == directUploadsCORSID })
+	if i >= 0 {
+		existing := rules[i]
+		if slices.Equal(slices.Sorted(slices.Values(existing.AllowedOrigins)), origins) &&
+			slices.Equal(slices.Sorted(slices.Values(existing.AllowedMethods)), directUploadsCORSMethods) &&
+			slices.Equal(existing.AllowedHeaders, rule.AllowedHeaders) &&
+			slices.Equal(existing.ExposeHeaders, directUploadsCORSExposeHeaders) &&
+			aws.ToInt32(existing.MaxAgeSeconds) == directUploadsCORSMaxAge {
+			return "", nil
+		}
+		change = fmt.Sprintf("update CORS rule for direct uploads from %v", origins)
+		rules = slices.Clone(rules)
+		rules[i] = rule
+	} else {
+		rules = append(slices.Clone(rules), rule)
+	}
+	if s.DryRun {
+		return change, nil
+	}
+
+	_, err = s.S3Client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+		Bucket:            aws.String(s.Bucket),
+		CORSConfiguration: &types.CORSConfiguration{CORSRules: rules},
+	})
+	return change, err
+}
+
+func (s BucketSetup) applyVersioning(ctx context.Context) (string, error) {
+	current, err := s.S3Client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(s.Bucket),
+	})
+	if err != nil {
+		return "", err
+	}
+	if current.Status == types.BucketVersioningStatusEnabled {
+		return "", nil
+	}
+	change := "turn on versioning"
+	if s.DryRun {
+		return change, nil
+	}
+
+	_, err = s.S3Client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket: aws.String(s.Bucket),
+		VersioningConfiguration: &types.VersioningConfiguration{
+			Status: types.BucketVersioningStatusEnabled,
+		},
+	})
+	return change, err
+}
+
+// isAPIError reports whether err is an error response from S3 with code.
+func isAPIError(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
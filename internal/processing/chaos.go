@@ -0,0 +1,107 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ErrInjectedFault is returned by calls failed on purpose by Chaos.
+var ErrInjectedFault = errors.New("injected fault")
+
+// Chaos injects latency and errors into storage and transcoder calls, so
+// retries, cleanup and job recovery can be exercised before a real outage
+// does it. The zero value injects nothing.
+type Chaos struct {
+	// ErrorRate is the fraction of calls, from 0 to 1, that fail.
+	ErrorRate float64
+
+	// LatencyRate is the fraction of calls delayed by up to MaxLatency.
+	LatencyRate float64
+	MaxLatency  time.Duration
+}
+
+// Validate reports whether the rates are usable.
+func (c Chaos) Validate() error {
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("error rate %v must be between 0 and 1", c.ErrorRate)
+	}
+	if c.LatencyRate < 0 || c.LatencyRate > 1 {
+		return fmt.Errorf("latency rate %v must be between 0 and 1", c.LatencyRate)
+	}
+	if c.LatencyRate > 0 && c.MaxLatency <= 0 {
+		return errors.New("max latency must be set when injecting latency")
+	}
+	return nil
+}
+
+// Enabled reports whether any faults will be injected.
+func (c Chaos) Enabled() bool {
+	return c.ErrorRate > 0 || (c.LatencyRate > 0 && c.MaxLatency > 0)
+}
+
+// inject may delay, then may fail, a call to op.
+func (c Chaos) inject(ctx context.Context, op string) error {
+	if c.LatencyRate > 0 && rand.Float64() < c.LatencyRate {
+		delay := time.Duration(rand.Int64N(int64(c.MaxLatency)) + 1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if c.ErrorRate > 0 && rand.Float64() < c.ErrorRate {
+		return fmt.Errorf("%s: %w", op, ErrInjectedFault)
+	}
+	return nil
+}
+
+// WrapHTTPClient returns an AWS SDK HTTP client that injects faults into
+// every request, or next unchanged when chaos is disabled. Injected errors
+// look like transport failures, so the SDK's own retries apply to them.
+func (c Chaos) WrapHTTPClient(next aws.HTTPClient) aws.HTTPClient {
+	if !c.Enabled() {
+		return next
+	}
+	return chaosHTTPClient{next: next, chaos: c}
+}
+
+// WrapTranscoder returns a transcoder that injects faults before each
+// transcode, or next unchanged when chaos is disabled.
+func (c Chaos) WrapTranscoder(next Transcoder) Transcoder {
+	if !c.Enabled() {
+		return next
+	}
+	return chaosTranscoder{Transcoder: next, chaos: c}
+}
+
+type chaosHTTPClient struct {
+	next  aws.HTTPClient
+	chaos Chaos
+}
+
+func (h chaosHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if err := h.chaos.inject(req.Context(), req.Method+" "+req.URL.Host); err != nil {
+		return nil, err
+	}
+	return h.next.Do(req)
+}
+
+type chaosTranscoder struct {
+	Transcoder
+	chaos Chaos
+}
+
+func (t chaosTranscoder) Transcode(ctx context.Context, req TranscodeRequest) (TranscodeResult, error) {
+	if err := t.chaos.inject(ctx, t.Name()+" transcode"); err != nil {
+		return TranscodeResult{}, err
+	}
+	return t.Transcoder.Transcode(ctx, req)
+}
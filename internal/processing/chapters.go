@@ -0,0 +1,66 @@
+package processing
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// WriteChapterMetadata writes chapters to an ffmetadata file for a video of
+// the given duration, returning "" if there is nothing to embed. Markers
+// that no longer fit the video, e.g. after uploading a shorter cut, are
+// dropped.
+func WriteChapterMetadata(chapters []database.Chapter, duration float64) (string, error) {
+
+	valid := []database.Chapter{}
+	for _, chapter := range chapters {
+		if chapter.StartSeconds < duration {
+			valid = append(valid, chapter)
+		}
+	}
+	if len(valid) == 0 {
+		return "", nil
+	}
+
+	// Each chapter ends where the next one starts, the last at the end of the video
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, chapter := range valid {
+		end := duration
+		if i+1 < len(valid) {
+			end = valid[i+1].StartSeconds
+		}
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(chapter.StartSeconds*1000),
+			int64(end*1000),
+			escapeFFMetadata(chapter.Title),
+		)
+	}
+
+	metadataFile, err := os.CreateTemp("", "tubely-chapters-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer metadataFile.Close()
+
+	if _, err := metadataFile.WriteString(b.String()); err != nil {
+		os.Remove(metadataFile.Name())
+		return "", err
+	}
+
+	return metadataFile.Name(), nil
+}
+
+// escapeFFMetadata escapes the characters ffmetadata treats specially.
+func escapeFFMetadata(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`=`, `\=`,
+		`;`, `\;`,
+		`#`, `\#`,
+		"\n", "\\\n",
+	)
+	return replacer.Replace(value)
+}
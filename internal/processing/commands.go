@@ -0,0 +1,291 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Names of the ffmpeg and ffprobe invocations that can be customized
+const (
+	CommandProbe       = "probe"
+	CommandProcess     = "process"
+	CommandFingerprint = "fingerprint"
+	CommandFrame       = "frame"
+	CommandTrim        = "trim"
+)
+
+// commandSpec describes an invocation: the tool it runs, the placeholders
+// its template may use and must use, and the template used by default.
+//
+// A scalar placeholder such as {input} is replaced wherever it appears in
+// an argument. A list placeholder such as {profile} must be a whole
+// argument, and expands to any number of arguments.
+type commandSpec struct {
+	tool     string
+	scalars  []string
+	lists    []string
+	required []string
+	defaults []string
+}
+
+var commandSpecs = map[string]commandSpec{
+	CommandProbe: {
+		tool:     "ffprobe",
+		scalars:  []string{"input"},
+		required: []string{"input"},
+		defaults: []string{"-v", "error", "-print_format", "json", "-show_streams", "-show_format", "{input}"},
+	},
+	CommandProcess: {
+		tool:     "ffmpeg",
+		scalars:  []string{"input", "output"},
+		lists:    []string{"hwaccel", "metadata", "profile", "format"},
+		required: []string{"input", "output", "profile", "format"},
+		defaults: []string{"-y", "{hwaccel}", "-i", "{input}", "{metadata}", "{profile}", "{format}", "{output}"},
+	},
+	CommandFingerprint: {
+		tool:     "ffmpeg",
+		scalars:  []string{"input", "filter", "frames"},
+		required: []string{"input", "filter", "frames"},
+		defaults: []string{"-v", "error", "-i", "{input}", "-vf", "{filter}", "-frames:v", "{frames}", "-f", "rawvideo", "pipe:1"},
+	},
+	CommandFrame: {
+		tool:     "ffmpeg",
+		scalars:  []string{"input", "at"},
+		required: []string{"input", "at"},
+		defaults: []string{"-v", "error", "-ss", "{at}", "-i", "{input}", "-frames:v", "1", "-q:v", "2", "-f", "image2", "-c:v", "mjpeg", "pipe:1"},
+	},
+	CommandTrim: {
+		tool:     "ffmpeg",
+		scalars:  []string{"input", "output", "start", "duration"},
+		required: []string{"input", "output", "start", "duration"},
+		defaults: []string{
+			"-y", "-v", "error",
+			"-ss", "{start}", "-i", "{input}", "-t", "{duration}",
+			"-map", "0", "-codec", "copy", "-avoid_negative_ts", "make_zero",
+			"-movflags", "faststart", "-f", "mp4",
+			"{output}",
+		},
+	},
+}
+
+// defaultAllowedFlags are the options templates and profiles may pass,
+// named without stream specifiers (so "-c" allows "-c:v" and "-c:a:1").
+// Options that read or write arbitrary files or URLs are left out.
+var defaultAllowedFlags = []string{
+	// General and input options
+	"-y", "-v", "-loglevel", "-nostats", "-hide_banner", "-i", "-f", "-ss", "-t", "-to",
+	"-threads", "-probesize", "-analyzeduration",
+	// Stream selection and metadata
+	"-map", "-map_metadata", "-map_chapters", "-vn", "-an", "-sn", "-dn",
+	// Codecs and quality
+	"-c", "-codec", "-vcodec", "-acodec", "-preset", "-crf", "-qp", "-cq", "-q", "-b", "-maxrate",
+	"-minrate", "-bufsize", "-rc", "-profile", "-level", "-tune", "-g", "-keyint_min",
+	"-sc_threshold", "-bf", "-x264-params", "-x265-params", "-pix_fmt", "-r", "-s", "-ar", "-ac",
+	"-frames", "-vf", "-af", "-filter", "-filter_complex",
+	// Hardware acceleration
+	"-hwaccel", "-hwaccel_device", "-hwaccel_output_format", "-init_hw_device",
+	"-filter_hw_device", "-vaapi_device",
+	// Muxing
+	"-movflags", "-avoid_negative_ts", "-var_stream_map", "-hls_time", "-hls_playlist_type",
+	"-hls_segment_filename", "-hls_segment_type", "-hls_flags", "-master_pl_name",
+	// ffprobe output
+	"-print_format", "-of", "-show_streams", "-show_format", "-show_chapters", "-show_entries",
+	"-select_streams",
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Commands holds the templates ffmpeg and ffprobe are invoked with.
+type Commands struct {
+	FFmpegPath   string
+	FFprobePath  string
+	templates    map[string][]string
+	allowedFlags map[string]bool
+}
+
+// commandsConfig is the JSON form of Commands.
+type commandsConfig struct {
+	FFmpegPath   string              `json:"ffmpeg_path"`
+	FFprobePath  string              `json:"ffprobe_path"`
+	Templates    map[string][]string `json:"templates"`
+	AllowedFlags []string            `json:"allowed_flags"`
+}
+
+// activeCommands are the templates the package runs. They're replaced once
+// at startup by UseCommands, before any processing starts.
+var activeCommands = DefaultCommands()
+
+// DefaultCommands returns the built-in templates.
+func DefaultCommands() Commands {
+	commands := Commands{
+		FFmpegPath:   "ffmpeg",
+		FFprobePath:  "ffprobe",
+		templates:    map[string][]string{},
+		allowedFlags: map[string]bool{},
+	}
+	for name, spec := range commandSpecs {
+		commands.templates[name] = spec.defaults
+	}
+	for _, flag := range defaultAllowedFlags {
+		commands.allowedFlags[flag] = true
+	}
+	return commands
+}
+
+// LoadCommands returns the built-in templates, overridden by any in the JSON
+// file at filePath. The file may also name the binaries and allow further
+// flags. Every template is checked against its placeholders and the flag
+// allowlist.
+func LoadCommands(filePath string) (Commands, error) {
+	commands := DefaultCommands()
+	if filePath == "" {
+		return commands, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return Commands{}, err
+	}
+	var config commandsConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Commands{}, fmt.Errorf("couldn't parse %s: %w", filePath, err)
+	}
+
+	if config.FFmpegPath != "" {
+		commands.FFmpegPath = config.FFmpegPath
+	}
+	if config.FFprobePath != "" {
+		commands.FFprobePath = config.FFprobePath
+	}
+	for _, flag := range config.AllowedFlags {
+		if !strings.HasPrefix(flag, "-") {
+			return Commands{}, fmt.Errorf("allowed flag %q must start with -", flag)
+		}
+		commands.allowedFlags[flagName(flag)] = true
+	}
+	for name, template := range config.Templates {
+		if err := commands.validateTemplate(name, template); err != nil {
+			return Commands{}, err
+		}
+		commands.templates[name] = template
+	}
+
+	return commands, nil
+}
+
+// UseCommands makes commands the templates used for all processing.
+func UseCommands(commands Commands) {
+	activeCommands = commands
+}
+
+// CheckArgs returns an error if args use an option outside the allowlist.
+func (c Commands) CheckArgs(args []string) error {
+	for _, arg := range args {
+		if !isFlag(arg) {
+			continue
+		}
+		if !c.allowedFlags[flagName(arg)] {
+			return fmt.Errorf("option %s is not allowed", arg)
+		}
+	}
+	return nil
+}
+
+func (c Commands) validateTemplate(name string, template []string) error {
+	spec, ok := commandSpecs[name]
+	if !ok {
+		return fmt.Errorf("unknown command template %q", name)
+	}
+
+	used := map[string]bool{}
+	for _, arg := range template {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(arg, -1) {
+			placeholder := match[1]
+			switch {
+			case slices.Contains(spec.scalars, placeholder):
+			case slices.Contains(spec.lists, placeholder):
+				if arg != match[0] {
+					return fmt.Errorf("template %q: {%s} must be a whole argument", name, placeholder)
+				}
+			default:
+				return fmt.Errorf("template %q: unknown placeholder {%s}", name, placeholder)
+			}
+			used[placeholder] = true
+		}
+	}
+	for _, placeholder := range spec.required {
+		if !used[placeholder] {
+			return fmt.Errorf("template %q: missing placeholder {%s}", name, placeholder)
+		}
+	}
+
+	if err := c.CheckArgs(template); err != nil {
+		return fmt.Errorf("template %q: %w", name, err)
+	}
+	return nil
+}
+
+// command builds the named invocation, substituting scalar and list values
+// into its template. Values are passed as separate arguments, never through
+// a shell, so they can't inject further options.
+func (c Commands) command(name string, scalars map[string]string, lists map[string][]string) *exec.Cmd {
+	tool, args := c.args(name, scalars, lists)
+	return exec.Command(tool, args...)
+}
+
+// commandContext is command for an invocation that's killed when ctx is
+// done, along with every process it started.
+func (c Commands) commandContext(ctx context.Context, name string, scalars map[string]string, lists map[string][]string) *exec.Cmd {
+	tool, args := c.args(name, scalars, lists)
+	cmd := exec.CommandContext(ctx, tool, args...)
+	killProcessGroupOnCancel(cmd)
+	return cmd
+}
+
+// args returns the tool and arguments of the named invocation.
+func (c Commands) args(name string, scalars map[string]string, lists map[string][]string) (string, []string) {
+	spec := commandSpecs[name]
+
+	pairs := []string{}
+	for placeholder, value := range scalars {
+		pairs = append(pairs, "{"+placeholder+"}", value)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	args := []string{}
+	for _, arg := range c.templates[name] {
+		if match := placeholderPattern.FindStringSubmatch(arg); match != nil && match[0] == arg && slices.Contains(spec.lists, match[1]) {
+			args = append(args, lists[match[1]]...)
+			continue
+		}
+		args = append(args, replacer.Replace(arg))
+	}
+
+	tool := c.FFmpegPath
+	if spec.tool == "ffprobe" {
+		tool = c.FFprobePath
+	}
+	return tool, args
+}
+
+// isFlag reports whether an argument is an option name rather than a value,
+// so negative numbers aren't mistaken for options.
+func isFlag(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	next := arg[1]
+	return (next >= 'a' && next <= 'z') || (next >= 'A' && next <= 'Z')
+}
+
+// flagName strips any stream specifier from an option, so "-b:v:0" is "-b".
+func flagName(flag string) string {
+	name, _, _ := strings.Cut(flag, ":")
+	return name
+}
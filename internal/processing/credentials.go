@@ -0,0 +1,91 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// credentialsRefreshWindow is how long before expiry cached credentials are
+// refreshed, so a request never starts with credentials about to lapse.
+const credentialsRefreshWindow = 5 * time.Minute
+
+// credentialsCheckTimeout bounds the credential fetch made at startup.
+const credentialsCheckTimeout = 30 * time.Second
+
+// AssumeRoleConfig describes an IAM role to act as instead of the
+// credentials found in the environment.
+type AssumeRoleConfig struct {
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+	Duration    time.Duration
+}
+
+// LoadAWSConfig loads the SDK configuration for region. When a role is set,
+// the default credentials are only used to assume it, and the role's
+// temporary credentials are refreshed before they expire. Credentials are
+// fetched once up front so a misconfiguration fails at startup rather than
+// on the first upload.
+func LoadAWSConfig(ctx context.Context, region string, role AssumeRoleConfig) (aws.Config, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if role.RoleARN != "" {
+		awsCfg.Credentials = assumeRole(awsCfg, role)
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, credentialsCheckTimeout)
+	defer cancel()
+	if _, err := awsCfg.Credentials.Retrieve(checkCtx); err != nil {
+		if role.RoleARN != "" {
+			return aws.Config{}, fmt.Errorf("couldn't assume role %s: %w", role.RoleARN, err)
+		}
+		return aws.Config{}, fmt.Errorf("couldn't load AWS credentials: %w", err)
+	}
+
+	return awsCfg, nil
+}
+
+// assumeRole returns credentials for role, obtained with awsCfg's own
+// credentials and refreshed before they expire.
+func assumeRole(awsCfg aws.Config, role AssumeRoleConfig) aws.CredentialsProvider {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), role.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		if role.ExternalID != "" {
+			o.ExternalID = aws.String(role.ExternalID)
+		}
+		if role.SessionName != "" {
+			o.RoleSessionName = role.SessionName
+		}
+		if role.Duration > 0 {
+			o.Duration = role.Duration
+		}
+	})
+	return aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsRefreshWindow
+	})
+}
+
+// NewS3Client returns an S3 client for awsCfg. A non-empty endpoint points
+// it at an S3-compatible service such as MinIO instead of AWS, addressing
+// buckets by path since those services rarely resolve bucket subdomains.
+// Every call the client makes follows access.
+func NewS3Client(awsCfg aws.Config, endpoint string, access BucketAccess) *s3.Client {
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+		if access.Enabled() {
+			o.APIOptions = append(o.APIOptions, access.addMiddleware)
+		}
+	})
+}
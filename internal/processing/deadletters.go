@@ -0,0 +1,82 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrDeadLetterRetried is returned when retrying a dead letter whose job
+	// was already put back on the queue.
+	ErrDeadLetterRetried = errors.New("dead letter has already been retried")
+	// ErrSourceNotKept is returned when retrying a dead letter whose job's
+	// source couldn't be kept when it failed.
+	ErrSourceNotKept = errors.New("the failed job's upload wasn't kept, so it can't be retried")
+	// ErrJobNotFailed is returned when retrying a dead letter whose job is
+	// no longer failed, or no longer exists.
+	ErrJobNotFailed = errors.New("the dead letter's job is no longer failed")
+	// ErrVideoGone is returned when retrying a dead letter whose video has
+	// been deleted.
+	ErrVideoGone = errors.New("video no longer exists")
+)
+
+// RetryDeadLetter puts a dead letter's job back on the queue to be
+// processed from the start, from the source kept when it failed. The job
+// isn't run; callers processing jobs inline must run it themselves.
+func (p *Processor) RetryDeadLetter(deadLetter database.DeadLetter) (database.Job, error) {
+	if deadLetter.RetriedAt != nil {
+		return database.Job{}, ErrDeadLetterRetried
+	}
+	if deadLetter.SourceKey == nil {
+		return database.Job{}, ErrSourceNotKept
+	}
+	video, err := p.DB.GetVideo(deadLetter.VideoID)
+	if err != nil {
+		return database.Job{}, err
+	}
+	if video.ID == uuid.Nil {
+		return database.Job{}, ErrVideoGone
+	}
+
+	// Marking it first means only one of two concurrent retries requeues the job
+	marked, err := p.DB.MarkDeadLetterRetried(deadLetter.ID)
+	if err != nil {
+		return database.Job{}, err
+	}
+	if !marked {
+		return database.Job{}, ErrDeadLetterRetried
+	}
+	requeued, err := p.DB.RequeueFailedJob(deadLetter.JobID, *deadLetter.SourceKey)
+	if err == nil && !requeued {
+		err = ErrJobNotFailed
+	}
+	if err != nil {
+		if clearErr := p.DB.ClearDeadLetterRetried(deadLetter.ID); clearErr != nil {
+			return database.Job{}, fmt.Errorf("%w, and couldn't clear its retry: %v", err, clearErr)
+		}
+		return database.Job{}, err
+	}
+
+	return p.DB.GetJob(deadLetter.JobID)
+}
+
+// DeleteDeadLetter deletes a dead letter along with the source kept for
+// it, unless its job was retried and owns the source again.
+func (p *Processor) DeleteDeadLetter(ctx context.Context, deadLetter database.DeadLetter) error {
+	if deadLetter.SourceKey != nil && deadLetter.RetriedAt == nil {
+		_, err := p.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(p.S3Bucket),
+			Key:    deadLetter.SourceKey,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't delete kept upload %s: %w", *deadLetter.SourceKey, err)
+		}
+	}
+	return p.DB.DeleteDeadLetter(deadLetter.ID)
+}
@@ -0,0 +1,64 @@
+package processing
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// DiskUsage reports how much a directory holds and how full the filesystem
+// under it is. FreeBytes and TotalBytes are zero where the platform can't
+// report them.
+type DiskUsage struct {
+	Path       string `json:"path"`
+	Files      int    `json:"files"`
+	UsedBytes  int64  `json:"used_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// MeasureDir walks dir and totals the files in it. Files removed during the
+// walk are skipped rather than failing it.
+func MeasureDir(dir string) (DiskUsage, error) {
+	usage := DiskUsage{Path: dir}
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		usage.Files++
+		usage.UsedBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return DiskUsage{}, err
+	}
+
+	if free, total, err := diskSpace(dir); err == nil {
+		usage.FreeBytes = free
+		usage.TotalBytes = total
+	}
+	return usage, nil
+}
+
+// Usage measures every scratch directory.
+func (s *Scratch) Usage() ([]DiskUsage, error) {
+	usages := make([]DiskUsage, 0, len(s.dirs))
+	for _, dir := range s.dirs {
+		usage, err := MeasureDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
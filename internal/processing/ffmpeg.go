@@ -0,0 +1,395 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+)
+
+// FFmpegError is a failed ffmpeg run of a processing profile, keeping what
+// ffmpeg wrote to stderr so a failed job's dead letter can show it.
+type FFmpegError struct {
+	Stderr string
+	Err    error
+}
+
+func (e *FFmpegError) Error() string {
+	return fmt.Sprintf("error processing video: %s, %v", e.Stderr, e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// VideoAspectRatio returns "16:9", "9:16" or "other" for the first video stream at filePath.
+func VideoAspectRatio(filePath string) (string, error) {
+	raw, err := ProbeFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	probe, err := ParseProbe(raw)
+	if err != nil {
+		return "", err
+	}
+	return probe.AspectRatio()
+}
+
+// ProcessVideoForFastStart remuxes a video with its moov atom up front,
+// optionally embedding chapters and tags from an ffmetadata file, and
+// returns the path of the processed file.
+func ProcessVideoForFastStart(inputFilePath, metadataFilePath string) (string, error) {
+	profile, _ := DefaultProfiles().Get(ProfilePassthrough)
+	return ProcessVideo(context.Background(), inputFilePath, metadataFilePath, profile, nil)
+}
+
+// ProcessVideo runs a video through a processing profile, optionally
+// embedding chapters and tags from an ffmetadata file, and returns the path
+// of the processed file. For HLS profiles the path is a directory holding
+// the master playlist and a directory per rendition. If hlsKey is set, HLS
+// segments are encrypted with it using AES-128, and the playlists point
+// players at HLSKeyURI for it; the key itself is never written to the
+// output.
+//
+// When a hardware encoder is in use and the profile encodes with libx264,
+// the hardware encoder is tried first, falling back to software if it fails.
+// ffmpeg is killed if ctx is done before it finishes.
+func ProcessVideo(ctx context.Context, inputFilePath, metadataFilePath string, profile Profile, hlsKey []byte) (string, error) {
+	return processVideoWithFallback(ctx, inputFilePath, inputFilePath, metadataFilePath, profile, hlsKey)
+}
+
+// processVideoWithFallback runs ProcessVideo on input, such as a URL, with
+// its output written beside workPath, falling back from hardware to
+// software encoding.
+func processVideoWithFallback(ctx context.Context, input, workPath, metadataFilePath string, profile Profile, hlsKey []byte) (string, error) {
+	if accel := activeAccelerator; accel.Name != "" {
+		if hwArgs, ok := accel.rewrite(profile.Args); ok {
+			processedFilePath, err := processVideo(ctx, input, workPath, metadataFilePath, profile, hlsKey, hwArgs, accel.inputArgs())
+			if err == nil || ctx.Err() != nil {
+				return processedFilePath, err
+			}
+			log.Printf("Hardware encoding with %s failed, falling back to software: %v", accel.Name, err)
+		}
+	}
+	return processVideo(ctx, input, workPath, metadataFilePath, profile, hlsKey, profile.Args, nil)
+}
+
+// processVideo runs ffmpeg for ProcessVideo with the given encoding options
+// and hardware input options.
+func processVideo(ctx context.Context, input, workPath, metadataFilePath string, profile Profile, hlsKey []byte, profileArgs, hwaccelArgs []string) (string, error) {
+
+	// String for the output filepath
+	processedFilePath := fmt.Sprintf("%s.processing", workPath)
+
+	// Map chapters from the metadata file if given
+	metadataArgs := []string{}
+	if metadataFilePath != "" && profile.EmbedsChapters() {
+		metadataArgs = []string{
+			"-f", "ffmetadata", "-i", metadataFilePath,
+			"-map_metadata", "1",
+			"-map_chapters", "1",
+		}
+	}
+
+	var formatArgs []string
+	outputPath := processedFilePath
+	switch profile.Format {
+	case FormatHLS:
+		os.RemoveAll(processedFilePath)
+		if err := os.MkdirAll(processedFilePath, 0755); err != nil {
+			return "", err
+		}
+		formatArgs = []string{
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(processedFilePath, "%v", "segment%03d.ts"),
+			"-master_pl_name", HLSPlaylistName,
+		}
+		if hlsKey != nil {
+			keyInfoPath, err := writeHLSKeyInfo(workPath, hlsKey)
+			if err != nil {
+				os.RemoveAll(processedFilePath)
+				return "", err
+			}
+			defer removeHLSKeyInfo(workPath)
+			formatArgs = append(formatArgs, "-hls_key_info_file", keyInfoPath)
+		}
+		outputPath = filepath.Join(processedFilePath, "%v", "index.m3u8")
+	case FormatM4A:
+		formatArgs = []string{"-f", "ipod"}
+	default:
+		formatArgs = []string{"-f", "mp4"}
+	}
+
+	// Run command for ffmpeg
+	cmd := activeCommands.commandContext(ctx, CommandProcess,
+		map[string]string{"input": input, "output": outputPath},
+		map[string][]string{"hwaccel": hwaccelArgs, "metadata": metadataArgs, "profile": profileArgs, "format": formatArgs},
+	)
+
+	// Set exec.Cmd's Stderr field to a pointer to a new bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(processedFilePath)
+		return "", &FFmpegError{Stderr: stderr.String(), Err: err}
+	}
+
+	// Check the output is not empty
+	checkPath := processedFilePath
+	if profile.Format == FormatHLS {
+		checkPath = filepath.Join(processedFilePath, HLSPlaylistName)
+	}
+	fileInfo, err := os.Stat(checkPath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat processed file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		return "", fmt.Errorf("processed file is empty")
+	}
+
+	return processedFilePath, nil
+}
+
+// StreamVideo runs a video through an MP4 or M4A processing profile like
+// ProcessVideo, but hands ffmpeg's output to upload as it's produced instead
+// of writing it to disk. A streamed file can't be rewritten to move its moov
+// atom up front, so it's written as a fragmented MP4, which players can also
+// start before it has fully downloaded. If ffmpeg fails, upload's reader
+// returns the error rather than EOF so a partial file is never stored.
+func StreamVideo(ctx context.Context, inputFilePath, metadataFilePath string, profile Profile, upload func(io.Reader) error) error {
+	return streamVideoWithFallback(ctx, inputFilePath, nil, metadataFilePath, profile, upload)
+}
+
+// StreamVideoData is StreamVideo for a video held in memory, which is piped
+// into ffmpeg so neither it nor the output ever touches the disk. The video
+// must be readable from start to end without seeking, see StreamableMP4.
+func StreamVideoData(ctx context.Context, data []byte, metadataFilePath string, profile Profile, upload func(io.Reader) error) error {
+	return streamVideoWithFallback(ctx, pipeInput, data, metadataFilePath, profile, upload)
+}
+
+// streamVideoWithFallback runs StreamVideo on input, or on data piped in if
+// set, falling back from hardware to software encoding like ProcessVideo.
+func streamVideoWithFallback(ctx context.Context, input string, data []byte, metadataFilePath string, profile Profile, upload func(io.Reader) error) error {
+	if profile.Format == FormatHLS {
+		return errors.New("HLS profiles can't be streamed")
+	}
+	if accel := activeAccelerator; accel.Name != "" {
+		if hwArgs, ok := accel.rewrite(profile.Args); ok {
+			err := streamVideo(ctx, input, data, metadataFilePath, profile, hwArgs, accel.inputArgs(), upload)
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+			log.Printf("Hardware encoding with %s failed, falling back to software: %v", accel.Name, err)
+		}
+	}
+	return streamVideo(ctx, input, data, metadataFilePath, profile, profile.Args, nil, upload)
+}
+
+// streamVideo runs ffmpeg for StreamVideo with the given encoding options
+// and hardware input options. Each attempt pipes data in afresh.
+func streamVideo(ctx context.Context, source string, data []byte, metadataFilePath string, profile Profile, profileArgs, hwaccelArgs []string, upload func(io.Reader) error) error {
+
+	metadataArgs := []string{}
+	if metadataFilePath != "" && profile.EmbedsChapters() {
+		metadataArgs = []string{
+			"-f", "ffmetadata", "-i", metadataFilePath,
+			"-map_metadata", "1",
+			"-map_chapters", "1",
+		}
+	}
+
+	// These come after the profile's options, so they replace its faststart flag
+	muxer := "mp4"
+	if profile.Format == FormatM4A {
+		muxer = "ipod"
+	}
+	formatArgs := []string{"-f", muxer, "-movflags", "frag_keyframe+empty_moov+default_base_moof"}
+
+	cmd := activeCommands.commandContext(ctx, CommandProcess,
+		map[string]string{"input": source, "output": "pipe:1"},
+		map[string][]string{"hwaccel": hwaccelArgs, "metadata": metadataArgs, "profile": profileArgs, "format": formatArgs},
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if data != nil {
+		cmd.Stdin = bytes.NewReader(data)
+	}
+	output, input := io.Pipe()
+	cmd.Stdout = input
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error processing video: %v", err)
+	}
+
+	// End the output with ffmpeg's error, if any, once it exits
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			err = &FFmpegError{Stderr: stderr.String(), Err: err}
+		}
+		input.CloseWithError(err)
+		done <- err
+	}()
+
+	// An ffmpeg failure reaches upload through its reader, so upload's error
+	// is the more useful one; if upload gave up early, stop ffmpeg writing
+	uploadErr := upload(output)
+	output.CloseWithError(errors.New("upload stopped reading"))
+	ffmpegErr := <-done
+	if uploadErr != nil {
+		return uploadErr
+	}
+	return ffmpegErr
+}
+
+// VideoDuration returns the duration of a video in seconds.
+func VideoDuration(filePath string) (float64, error) {
+	raw, err := ProbeFile(filePath)
+	if err != nil {
+		return 0, err
+	}
+	probe, err := ParseProbe(raw)
+	if err != nil {
+		return 0, err
+	}
+	return probe.Duration()
+}
+
+// Number of frames sampled across a video to build its fingerprint
+const fingerprintFrames = 8
+
+// VideoFingerprint builds a perceptual fingerprint from frames sampled evenly across a video.
+func VideoFingerprint(filePath string, duration float64) (string, error) {
+	return videoFingerprint(filePath, nil, duration)
+}
+
+// VideoFingerprintData is VideoFingerprint for a video held in memory.
+func VideoFingerprintData(data []byte, duration float64) (string, error) {
+	return videoFingerprint(pipeInput, bytes.NewReader(data), duration)
+}
+
+// videoFingerprint fingerprints input, reading it from stdin if set.
+func videoFingerprint(input string, stdin io.Reader, duration float64) (string, error) {
+
+	// Frames are spread across the whole duration of the video
+	if duration <= 0 {
+		return "", errors.New("video has no duration")
+	}
+
+	// Have ffmpeg emit small grayscale frames as raw bytes on stdout
+	frameSize := imaging.PHashSize * imaging.PHashSize
+	filter := fmt.Sprintf("fps=%f,scale=%d:%d,format=gray",
+		float64(fingerprintFrames)/duration, imaging.PHashSize, imaging.PHashSize)
+	cmd := activeCommands.command(CommandFingerprint, map[string]string{
+		"input":  input,
+		"filter": filter,
+		"frames": strconv.Itoa(fingerprintFrames),
+	}, nil)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error sampling frames: %s, %v", stderr.String(), err)
+	}
+
+	// Hash each complete frame
+	frames := stdout.Bytes()
+	hashes := []uint64{}
+	for len(frames) >= frameSize {
+		hash, err := imaging.PerceptualHashGray(frames[:frameSize], imaging.PHashSize, imaging.PHashSize)
+		if err != nil {
+			return "", err
+		}
+		hashes = append(hashes, hash)
+		frames = frames[frameSize:]
+	}
+	if len(hashes) == 0 {
+		return "", errors.New("no frames sampled")
+	}
+
+	return imaging.FormatFingerprint(hashes), nil
+}
+
+// ExtractFrame returns the frame at the given offset in seconds as a JPEG.
+// The input may be a local path or a URL ffmpeg can read, such as a
+// presigned object URL, in which case only the data around the offset is
+// fetched.
+func ExtractFrame(input string, at float64) ([]byte, error) {
+	return extractFrame(input, nil, at)
+}
+
+// ExtractFrameData is ExtractFrame for a video held in memory.
+func ExtractFrameData(data []byte, at float64) ([]byte, error) {
+	return extractFrame(pipeInput, bytes.NewReader(data), at)
+}
+
+// extractFrame extracts a frame from input, reading it from stdin if set.
+func extractFrame(input string, stdin io.Reader, at float64) ([]byte, error) {
+	cmd := activeCommands.command(CommandFrame, map[string]string{
+		"input": input,
+		"at":    strconv.FormatFloat(at, 'f', 3, 64),
+	}, nil)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error extracting frame: %s, %v", stderr.String(), err)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("no frame at %.3f seconds", at)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// TrimVideo writes the part of input between start and end seconds to
+// outputFilePath as a fast-start MP4. Streams are copied, so the clip starts
+// on the keyframe at or before start.
+func TrimVideo(input string, start, end float64, outputFilePath string) error {
+	if end <= start {
+		return errors.New("clip end must be after its start")
+	}
+
+	cmd := activeCommands.command(CommandTrim, map[string]string{
+		"input":    input,
+		"output":   outputFilePath,
+		"start":    strconv.FormatFloat(start, 'f', 3, 64),
+		"duration": strconv.FormatFloat(end-start, 'f', 3, 64),
+	}, nil)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error trimming video: %s, %v", stderr.String(), err)
+	}
+
+	fileInfo, err := os.Stat(outputFilePath)
+	if err != nil {
+		return fmt.Errorf("could not stat trimmed file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		return errors.New("trimmed file is empty")
+	}
+
+	return nil
+}
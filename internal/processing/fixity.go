@@ -0,0 +1,228 @@
+package processing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// FixityChecker periodically verifies that a sample of stored objects still
+// have the SHA-256 they were stored with, the ones verified longest ago
+// first, and records every outcome so mismatches can be reported.
+type FixityChecker struct {
+	DB         database.Client
+	S3Client   *s3.Client
+	SampleSize int
+	Interval   time.Duration
+	// Buckets resolves the client for users' own buckets; without it every
+	// bucket uses S3Client
+	Buckets *BucketClients
+
+	mu    sync.Mutex
+	stats FixityStats
+}
+
+// FixityStats counts what the checker has verified since startup.
+type FixityStats struct {
+	SampleSize   int            `json:"sample_size"`
+	Interval     string         `json:"interval"`
+	LastRunAt    *time.Time     `json:"last_run_at"`
+	LastChecked  int            `json:"last_checked"`
+	ByStatus     map[string]int `json:"by_status"`
+	FailedRuns   int            `json:"failed_runs"`
+	LastRunError *string        `json:"last_run_error"`
+}
+
+// Run checks a sample once immediately and then every Interval until ctx is cancelled.
+func (f *FixityChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+
+	for {
+		f.CheckSample(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckSample verifies the next sample of objects and returns the outcomes.
+func (f *FixityChecker) CheckSample(ctx context.Context) []database.FixityCheck {
+	checks, err := f.checkSample(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	f.stats.LastRunAt = &now
+	f.stats.LastChecked = len(checks)
+	if f.stats.ByStatus == nil {
+		f.stats.ByStatus = map[string]int{}
+	}
+	for _, check := range checks {
+		f.stats.ByStatus[check.Status]++
+	}
+	if err != nil {
+		msg := err.Error()
+		f.stats.FailedRuns++
+		f.stats.LastRunError = &msg
+		log.Printf("Fixity check failed: %v", err)
+	}
+
+	return checks
+}
+
+// Stats returns the checker's running totals.
+func (f *FixityChecker) Stats() FixityStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := f.stats
+	stats.ByStatus = map[string]int{}
+	for status, count := range f.stats.ByStatus {
+		stats.ByStatus[status] = count
+	}
+	stats.SampleSize = f.SampleSize
+	stats.Interval = f.Interval.String()
+	return stats
+}
+
+func (f *FixityChecker) checkSample(ctx context.Context) ([]database.FixityCheck, error) {
+	objects, err := f.DB.GetFixitySample(f.SampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []database.FixityCheck{}
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			return checks, ctx.Err()
+		}
+
+		check := f.Check(ctx, object)
+		check, err := f.DB.RecordFixityCheck(check)
+		if err != nil {
+			return checks, err
+		}
+		if check.Status != database.FixityStatusOK && check.Status != database.FixityStatusBaseline {
+			log.Printf("Fixity check of %s/%s found %s", check.Bucket, check.Key, check.Status)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// Check verifies one object. The checksum S3 keeps is used when it covers the
+// whole object, otherwise the object is downloaded and hashed. Objects stored
+// without a known checksum get one recorded as their baseline.
+func (f *FixityChecker) Check(ctx context.Context, object database.StoredObject) database.FixityCheck {
+	check := database.FixityCheck{
+		CheckedAt:      time.Now().UTC(),
+		UserID:         object.UserID,
+		VideoID:        object.VideoID,
+		Bucket:         object.Bucket,
+		Key:            object.Key,
+		Method:         database.FixityMethodChecksum,
+		ExpectedSHA256: object.SHA256,
+	}
+
+	actual, err := f.storedChecksum(ctx, object)
+	if err == nil && actual == "" {
+		check.Method = database.FixityMethodDownload
+		actual, err = f.downloadChecksum(ctx, object)
+	}
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	switch {
+	case errors.As(err, &notFound) || errors.As(err, &noSuchKey):
+		check.Status = database.FixityStatusMissing
+		return check
+	case err != nil:
+		msg := err.Error()
+		check.Status = database.FixityStatusError
+		check.Error = &msg
+		return check
+	}
+
+	check.ActualSHA256 = &actual
+	switch {
+	case object.SHA256 == nil:
+		check.Status = database.FixityStatusBaseline
+	case *object.SHA256 == actual:
+		check.Status = database.FixityStatusOK
+	default:
+		check.Status = database.FixityStatusMismatch
+	}
+	return check
+}
+
+// storedChecksum returns the hex SHA-256 S3 keeps for an object, or "" when
+// it doesn't keep one for the whole object
+func (f *FixityChecker) storedChecksum(ctx context.Context, object database.StoredObject) (string, error) {
+	client, err := f.client(object.Bucket)
+	if err != nil {
+		return "", err
+	}
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(object.Bucket),
+		Key:          aws.String(object.Key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Multipart uploads get a checksum of their part checksums, like "abc=-3"
+	checksum := aws.ToString(head.ChecksumSHA256)
+	if checksum == "" || strings.Contains(checksum, "-") {
+		return "", nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil {
+		return "", nil
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// downloadChecksum reads an object from the bucket and returns its hex SHA-256
+func (f *FixityChecker) downloadChecksum(ctx context.Context, object database.StoredObject) (string, error) {
+	client, err := f.client(object.Bucket)
+	if err != nil {
+		return "", err
+	}
+	result, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(object.Bucket),
+		Key:    aws.String(object.Key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer result.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, result.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// client returns the S3 client for a bucket objects are stored in.
+func (f *FixityChecker) client(bucket string) (*s3.Client, error) {
+	if f.Buckets == nil {
+		return f.S3Client, nil
+	}
+	return f.Buckets.Client(bucket)
+}
@@ -0,0 +1,94 @@
+package processing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// URI attributes of tags such as EXT-X-MAP, EXT-X-KEY and EXT-X-MEDIA
+var playlistURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// RewritePlaylistURIs rewrites every URI in an HLS playlist with rewrite:
+// the lines naming segments and variant playlists, and the URI attributes of
+// tags. Everything else, including EXT-X-BYTERANGE tags, is left as it is,
+// so byte ranges apply to the rewritten URIs just as they did before.
+func RewritePlaylistURIs(playlist []byte, rewrite func(uri string) (string, error)) ([]byte, error) {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	scanner.Buffer(make([]byte, 0, 64<<10), len(playlist)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "#"):
+			var rewriteErr error
+			line = playlistURIAttribute.ReplaceAllStringFunc(line, func(attribute string) string {
+				uri := playlistURIAttribute.FindStringSubmatch(attribute)[1]
+				rewritten, err := rewrite(uri)
+				if err != nil {
+					rewriteErr = err
+					return attribute
+				}
+				return `URI="` + rewritten + `"`
+			})
+			if rewriteErr != nil {
+				return nil, rewriteErr
+			}
+		case strings.TrimSpace(line) != "":
+			rewritten, err := rewrite(strings.TrimSpace(line))
+			if err != nil {
+				return nil, err
+			}
+			line = rewritten
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// HLSKeyURI is the key URI written into the playlists of encrypted HLS
+// outputs. It isn't fetchable as it is: whatever serves the playlists
+// rewrites it to a URL that hands the key only to viewers allowed to play.
+const HLSKeyURI = "tubely:hls-key"
+
+// Length of an AES-128 key
+const HLSKeySize = 16
+
+// NewHLSKey returns a random AES-128 key for encrypting a video's segments.
+func NewHLSKey() ([]byte, error) {
+	key := make([]byte, HLSKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// writeHLSKeyInfo writes the key and the key info file ffmpeg reads it
+// through beside the input, outside the output directory so the key is
+// never uploaded, and returns the key info file's path. Without an IV
+// line, ffmpeg uses each segment's sequence number as its IV.
+func writeHLSKeyInfo(inputFilePath string, key []byte) (string, error) {
+	keyPath := inputFilePath + ".key"
+	keyInfoPath := inputFilePath + ".keyinfo"
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(keyInfoPath, []byte(HLSKeyURI+"\n"+keyPath+"\n"), 0600); err != nil {
+		os.Remove(keyPath)
+		return "", err
+	}
+	return keyInfoPath, nil
+}
+
+// removeHLSKeyInfo deletes the files writeHLSKeyInfo wrote.
+func removeHLSKeyInfo(inputFilePath string) {
+	os.Remove(inputFilePath + ".key")
+	os.Remove(inputFilePath + ".keyinfo")
+}
@@ -0,0 +1,173 @@
+package processing
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Hardware acceleration modes a deployment can select
+const (
+	HWAccelNone         = "none"
+	HWAccelAuto         = "auto"
+	HWAccelVAAPI        = "vaapi"
+	HWAccelNVENC        = "nvenc"
+	HWAccelVideoToolbox = "videotoolbox"
+)
+
+// DefaultVAAPIDevice is the render node VAAPI encodes on unless configured otherwise.
+const DefaultVAAPIDevice = "/dev/dri/renderD128"
+
+// Accelerator is a hardware H.264 encoder used in place of libx264. The zero
+// value means software encoding.
+type Accelerator struct {
+	Name    string
+	Encoder string
+	Device  string
+}
+
+// activeAccelerator is the hardware encoder the package uses. It's set once
+// at startup by UseAccelerator, before any processing starts.
+var activeAccelerator Accelerator
+
+// UseAccelerator makes accel the hardware encoder used for processing.
+func UseAccelerator(accel Accelerator) {
+	activeAccelerator = accel
+}
+
+// DetectAccelerator returns a working hardware encoder for mode, checking
+// each candidate with a one-frame test encode. Auto mode tries every encoder
+// the platform might have and settles for software encoding if none work; an
+// explicitly chosen encoder that doesn't work is an error.
+func DetectAccelerator(mode, vaapiDevice string) (Accelerator, error) {
+	if vaapiDevice == "" {
+		vaapiDevice = DefaultVAAPIDevice
+	}
+	candidates := map[string]Accelerator{
+		HWAccelNVENC:        {Name: HWAccelNVENC, Encoder: "h264_nvenc"},
+		HWAccelVAAPI:        {Name: HWAccelVAAPI, Encoder: "h264_vaapi", Device: vaapiDevice},
+		HWAccelVideoToolbox: {Name: HWAccelVideoToolbox, Encoder: "h264_videotoolbox"},
+	}
+
+	switch mode {
+	case "", HWAccelNone:
+		return Accelerator{}, nil
+	case HWAccelAuto:
+		order := []string{HWAccelNVENC, HWAccelVAAPI}
+		if runtime.GOOS == "darwin" {
+			order = []string{HWAccelVideoToolbox}
+		}
+		for _, name := range order {
+			if err := candidates[name].test(); err == nil {
+				return candidates[name], nil
+			}
+		}
+		return Accelerator{}, nil
+	default:
+		accel, ok := candidates[mode]
+		if !ok {
+			return Accelerator{}, fmt.Errorf("unknown hardware acceleration %q", mode)
+		}
+		if err := accel.test(); err != nil {
+			return Accelerator{}, fmt.Errorf("%s isn't usable: %w", mode, err)
+		}
+		return accel, nil
+	}
+}
+
+// test encodes a single blank frame with the accelerator.
+func (a Accelerator) test() error {
+	if a.Device != "" {
+		if _, err := os.Stat(a.Device); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"-hide_banner", "-v", "error"}
+	args = append(args, a.inputArgs()...)
+	args = append(args, "-f", "lavfi", "-i", "color=black:s=256x256:d=0.1")
+	if a.Name == HWAccelVAAPI {
+		args = append(args, "-vf", "format=nv12,hwupload")
+	}
+	args = append(args, "-c:v", a.Encoder, "-frames:v", "1", "-f", "null", "-")
+
+	cmd := exec.Command(activeCommands.FFmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("test encode failed: %s, %v", strings.TrimSpace(stderr.String()), err)
+	}
+	return nil
+}
+
+// inputArgs are the options placed before the input for the accelerator.
+func (a Accelerator) inputArgs() []string {
+	if a.Name == HWAccelVAAPI {
+		return []string{"-vaapi_device", a.Device}
+	}
+	return nil
+}
+
+// rewrite translates profile options that encode with libx264 to use the
+// accelerator. It returns false if the profile doesn't encode with libx264,
+// or does so in a way the accelerator can't take over.
+func (a Accelerator) rewrite(args []string) ([]string, bool) {
+	if a.Name == "" {
+		return nil, false
+	}
+	// Uploading frames to the GPU would need adding to every filter graph output
+	if a.Name == HWAccelVAAPI && slices.Contains(args, "-filter_complex") {
+		return nil, false
+	}
+
+	rewritten := []string{}
+	encodes := false
+	hasFilter := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		// Options without a value, such as -vn, are followed by another option
+		if !isFlag(arg) || i+1 >= len(args) || isFlag(args[i+1]) {
+			rewritten = append(rewritten, arg)
+			continue
+		}
+		value := args[i+1]
+
+		switch name := flagName(arg); {
+		case (name == "-c" || name == "-codec" || name == "-vcodec") && value == "libx264":
+			rewritten = append(rewritten, arg, a.Encoder)
+			encodes = true
+		case name == "-crf":
+			// Each encoder has its own constant-quality option
+			switch a.Name {
+			case HWAccelNVENC:
+				rewritten = append(rewritten, "-cq", value)
+			case HWAccelVAAPI:
+				rewritten = append(rewritten, "-qp", value)
+			}
+		case name == "-preset":
+			if a.Name == HWAccelNVENC && slices.Contains([]string{"slow", "medium", "fast"}, value) {
+				rewritten = append(rewritten, arg, value)
+			}
+		case name == "-tune" || name == "-x264-params":
+		case name == "-pix_fmt" && a.Name == HWAccelVAAPI:
+		case name == "-vf" && a.Name == HWAccelVAAPI:
+			rewritten = append(rewritten, arg, value+",format=nv12,hwupload")
+			hasFilter = true
+		default:
+			rewritten = append(rewritten, arg, value)
+		}
+		i++
+	}
+	if !encodes {
+		return nil, false
+	}
+
+	if a.Name == HWAccelVAAPI && !hasFilter {
+		rewritten = append([]string{"-vf", "format=nv12,hwupload"}, rewritten...)
+	}
+	return rewritten, true
+}
@@ -0,0 +1,226 @@
+package processing
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ListedObject is an object found by listing a bucket or reading its inventory.
+type ListedObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListBucket calls visit for every object in a bucket, returning when the
+// listing started.
+func ListBucket(ctx context.Context, client *s3.Client, bucket string, visit func(ListedObject) error) (time.Time, error) {
+	listedAt := time.Now().UTC()
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return listedAt, err
+		}
+		for _, object := range page.Contents {
+			err := visit(ListedObject{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+			if err != nil {
+				return listedAt, err
+			}
+		}
+	}
+	return listedAt, nil
+}
+
+// InventoryLocation is where an S3 Inventory configuration delivers its
+// reports. Reading a daily inventory costs a few requests however large the
+// bucket is, where listing it takes one for every thousand objects.
+type InventoryLocation struct {
+	Bucket string
+	// Prefix is the configuration's destination prefix followed by the
+	// source bucket and the configuration's ID, like
+	// "inventory/tubely-123456789/daily"
+	Prefix string
+}
+
+// inventoryManifest is the manifest.json delivered with each inventory report.
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	CreationTimestamp string `json:"creationTimestamp"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// Inventory report fields an object is read from
+var requiredInventoryFields = []string{"Key", "Size", "LastModifiedDate"}
+
+// ReadInventory calls visit for every current object in the latest
+// inventory report of sourceBucket, returning when the report was taken.
+// Only CSV reports can be read, and they must include each object's size
+// and last modified date.
+func ReadInventory(ctx context.Context, client *s3.Client, location InventoryLocation, sourceBucket string, visit func(ListedObject) error) (time.Time, error) {
+	manifest, err := latestInventoryManifest(ctx, client, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if manifest.SourceBucket != sourceBucket {
+		return time.Time{}, fmt.Errorf("inventory is of bucket %s, not %s", manifest.SourceBucket, sourceBucket)
+	}
+	if manifest.FileFormat != "CSV" {
+		return time.Time{}, fmt.Errorf("inventory is in %s format, only CSV can be read", manifest.FileFormat)
+	}
+	millis, err := strconv.ParseInt(manifest.CreationTimestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid inventory creation time %q", manifest.CreationTimestamp)
+	}
+	takenAt := time.UnixMilli(millis).UTC()
+
+	fields := map[string]int{}
+	for i, field := range strings.Split(manifest.FileSchema, ",") {
+		fields[strings.TrimSpace(field)] = i
+	}
+	for _, field := range requiredInventoryFields {
+		if _, ok := fields[field]; !ok {
+			return takenAt, fmt.Errorf("inventory doesn't include %s", field)
+		}
+	}
+
+	for _, file := range manifest.Files {
+		if err := readInventoryFile(ctx, client, location.Bucket, file.Key, fields, visit); err != nil {
+			return takenAt, fmt.Errorf("couldn't read inventory file %s: %w", file.Key, err)
+		}
+	}
+	return takenAt, nil
+}
+
+// latestInventoryManifest returns the manifest of the newest complete
+// report. Reports are delivered under folders named for when they were
+// taken, like "2026-10-14T01-00Z", and a report is complete once its
+// manifest is written.
+func latestInventoryManifest(ctx context.Context, client *s3.Client, location InventoryLocation) (inventoryManifest, error) {
+	prefix := strings.TrimSuffix(location.Prefix, "/") + "/"
+	folders := []string{}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(location.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return inventoryManifest{}, err
+		}
+		for _, folder := range page.CommonPrefixes {
+			folders = append(folders, aws.ToString(folder.Prefix))
+		}
+	}
+	// The timestamps sort in the order they were taken
+	slices.Sort(folders)
+	slices.Reverse(folders)
+
+	for _, folder := range folders {
+		if path.Base(folder) == "hive" {
+			continue
+		}
+		object, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(location.Bucket),
+			Key:    aws.String(folder + "manifest.json"),
+		})
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			continue
+		}
+		if err != nil {
+			return inventoryManifest{}, err
+		}
+		var manifest inventoryManifest
+		err = json.NewDecoder(object.Body).Decode(&manifest)
+		object.Body.Close()
+		if err != nil {
+			return inventoryManifest{}, fmt.Errorf("couldn't read %smanifest.json: %w", folder, err)
+		}
+		return manifest, nil
+	}
+	return inventoryManifest{}, fmt.Errorf("no inventory reports under s3://%s/%s", location.Bucket, prefix)
+}
+
+// readInventoryFile calls visit for each current object in one gzipped CSV
+// file of a report. Noncurrent versions and delete markers, in reports of
+// versioned buckets, are skipped.
+func readInventoryFile(ctx context.Context, client *s3.Client, bucket, key string, fields map[string]int, visit func(ListedObject) error) error {
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+	gz, err := gzip.NewReader(object.Body)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	reader := csv.NewReader(gz)
+	reader.FieldsPerRecord = len(fields)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i, ok := fields["IsLatest"]; ok && record[i] != "true" {
+			continue
+		}
+		if i, ok := fields["IsDeleteMarker"]; ok && record[i] == "true" {
+			continue
+		}
+
+		// Keys are URL-encoded, as they may hold characters CSV can't
+		objectKey, err := url.QueryUnescape(record[fields["Key"]])
+		if err != nil {
+			return fmt.Errorf("invalid key %q: %w", record[fields["Key"]], err)
+		}
+		size, err := strconv.ParseInt(record[fields["Size"]], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid size of %s: %w", objectKey, err)
+		}
+		lastModified, err := time.Parse(time.RFC3339, record[fields["LastModifiedDate"]])
+		if err != nil {
+			return fmt.Errorf("invalid last modified date of %s: %w", objectKey, err)
+		}
+		if err := visit(ListedObject{Key: objectKey, Size: size, LastModified: lastModified}); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Orientations keys used to be filed under, before templates
+var legacyOrientations = []string{"landscape", "portrait", "other"}
+
+// KeyMigration moves stored videos whose keys don't follow a key template to
+// the keys it gives them, within the bucket they're in. Each video is copied
+// first, then pointed at its new key, and only then deleted from its old
+// one, so an interrupted migration can be run again.
+type KeyMigration struct {
+	DB       database.Client
+	S3Client *s3.Client
+	// Buckets resolves the client for users' own buckets; without it every
+	// bucket uses S3Client
+	Buckets  *BucketClients
+	Defaults Storage
+	Keys     KeyTemplate
+	// DryRun logs the moves without making them
+	DryRun bool
+}
+
+// KeyMigrationResult counts what a migration did.
+type KeyMigrationResult struct {
+	Moved   int
+	Skipped int
+	Failed  int
+}
+
+// Run migrates every uploaded video, carrying on past videos that fail.
+func (m KeyMigration) Run(ctx context.Context) (KeyMigrationResult, error) {
+	videos, err := m.DB.GetUploadedVideos()
+	if err != nil {
+		return KeyMigrationResult{}, err
+	}
+
+	result := KeyMigrationResult{}
+	for _, video := range videos {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		moved, err := m.migrate(ctx, video)
+		switch {
+		case err != nil:
+			log.Printf("Couldn't move video %s: %v", video.ID, err)
+			result.Failed++
+		case moved:
+			result.Moved++
+		default:
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
+
+// migrate moves one video, reporting false if it's already where the
+// template puts it.
+func (m KeyMigration) migrate(ctx context.Context, video database.Video) (bool, error) {
+	storage, key, err := VideoLocation(m.DB, video, m.Defaults)
+	if err != nil {
+		return false, err
+	}
+	format := FormatForKey(key)
+	newKey := storage.Key(Profile{Format: format}.ObjectName(m.Keys.Name(m.fields(video, storage, key))))
+	if newKey == key {
+		return false, nil
+	}
+	if m.DryRun {
+		log.Printf("Would move video %s from %s to %s in %s", video.ID, key, newKey, storage.Bucket)
+		return true, nil
+	}
+
+	client, err := m.client(storage.Bucket)
+	if err != nil {
+		return false, err
+	}
+	keys := map[string]string{key: newKey}
+	if format == FormatHLS {
+		keys, err = m.hlsKeys(ctx, client, storage.Bucket, path.Dir(key), path.Dir(newKey))
+		if err != nil {
+			return false, err
+		}
+	}
+	for from, to := range keys {
+		if err := m.copyObject(ctx, client, storage.Bucket, from, to); err != nil {
+			return false, fmt.Errorf("couldn't copy %s: %w", from, err)
+		}
+	}
+
+	// Don't clobber a replacement uploaded while the copies were made
+	current, err := m.DB.GetVideo(video.ID)
+	if err != nil {
+		return false, err
+	}
+	if current.ID == uuid.Nil || current.VideoURL == nil || *current.VideoURL != *video.VideoURL {
+		m.deleteObjects(ctx, client, storage.Bucket, mapValues(keys))
+		return false, errors.New("video changed while it was being moved")
+	}
+	url := storage.URL(newKey)
+	current.VideoURL = &url
+	if err := m.DB.UpdateVideo(current); err != nil {
+		m.deleteObjects(ctx, client, storage.Bucket, mapValues(keys))
+		return false, err
+	}
+
+	for from, to := range keys {
+		m.moveLedgerEntry(storage.Bucket, from, to)
+	}
+	m.deleteObjects(ctx, client, storage.Bucket, mapKeys(keys))
+	log.Printf("Moved video %s from %s to %s in %s", video.ID, key, newKey, storage.Bucket)
+	return true, nil
+}
+
+// fields recovers what a video's key was made from. The random name is kept,
+// so a video that's moved again lands on a predictable key.
+func (m KeyMigration) fields(video database.Video, storage Storage, key string) KeyFields {
+	name := path.Base(key)
+	if FormatForKey(key) == FormatHLS {
+		name = path.Base(path.Dir(key))
+	}
+	fields := KeyFields{
+		UserID:      video.UserID,
+		VideoID:     video.ID,
+		Orientation: "other",
+		Profile:     ProfilePassthrough,
+		Random:      strings.TrimSuffix(name, path.Ext(name)),
+	}
+	if video.ProcessingProfile != nil {
+		fields.Profile = *video.ProcessingProfile
+	}
+
+	relative := strings.TrimPrefix(strings.TrimPrefix(key, storage.KeyPrefix), "/")
+	if orientation, ok := VideoOrientation(m.DB, video); ok {
+		fields.Orientation = orientation
+	} else if dir, _, ok := strings.Cut(relative, "/"); ok {
+		for _, orientation := range legacyOrientations {
+			if dir == orientation {
+				fields.Orientation = orientation
+			}
+		}
+	}
+	return fields
+}
+
+// hlsKeys maps every object of an HLS output to its key under the new prefix.
+func (m KeyMigration) hlsKeys(ctx context.Context, client *s3.Client, bucket, fromPrefix, toPrefix string) (map[string]string, error) {
+	keys := map[string]string{}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(fromPrefix + "/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			from := aws.ToString(object.Key)
+			keys[from] = path.Join(toPrefix, strings.TrimPrefix(from, fromPrefix+"/"))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no objects under %s", fromPrefix)
+	}
+	return keys, nil
+}
+
+// copyObject copies an object within a bucket, asking S3 for a SHA-256
+// checksum so fixity checks keep working on the copy. Objects over 5 GB
+// can't be copied in one request, and fail.
+func (m KeyMigration) copyObject(ctx context.Context, client *s3.Client, bucket, from, to string) error {
+	source := (&url.URL{Path: bucket + "/" + from}).EscapedPath()
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(to),
+		CopySource:        aws.String(source),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	return err
+}
+
+// moveLedgerEntry points an object's storage ledger entry at its new key.
+// The old entry is kept as deleted, so usage history stays accurate.
+func (m KeyMigration) moveLedgerEntry(bucket, from, to string) {
+	object, err := m.DB.GetStoredObject(bucket, from)
+	if err != nil || object == nil {
+		if err != nil {
+			log.Printf("Couldn't read ledger entry for %s: %v", from, err)
+		}
+		return
+	}
+	params := object.RecordStoredObjectParams
+	params.Key = to
+	if err := m.DB.RecordStoredObject(params); err != nil {
+		log.Printf("Couldn't record %s in the ledger: %v", to, err)
+		return
+	}
+	if err := m.DB.MarkStoredObjectDeleted(bucket, from); err != nil {
+		log.Printf("Couldn't mark %s deleted in the ledger: %v", from, err)
+	}
+}
+
+// deleteObjects deletes objects, logging rather than failing since the
+// video no longer depends on them.
+func (m KeyMigration) deleteObjects(ctx context.Context, client *s3.Client, bucket string, keys []string) {
+	for _, key := range keys {
+		_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			log.Printf("Couldn't delete %s: %v", key, err)
+		}
+	}
+}
+
+// client returns the S3 client for a bucket videos are stored in.
+func (m KeyMigration) client(bucket string) (*s3.Client, error) {
+	if m.Buckets == nil {
+		return m.S3Client, nil
+	}
+	return m.Buckets.Client(bucket)
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func mapValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, value := range m {
+		values = append(values, value)
+	}
+	return values
+}
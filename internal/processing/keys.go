@@ -0,0 +1,163 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// KeyTemplate lays out the keys processed videos are stored under, such as
+// "{userID}/{videoID}/{random}". The output format's extension, or its
+// directory for HLS, is added to the result, and tenants' key prefixes are
+// put in front of it.
+type KeyTemplate string
+
+// DefaultKeyTemplate files videos by orientation, as keys always were before
+// templates could be configured.
+const DefaultKeyTemplate KeyTemplate = "{orientation}/{random}"
+
+// KeyFields are the values a key template's placeholders are filled with.
+type KeyFields struct {
+	UserID  uuid.UUID
+	VideoID uuid.UUID
+	// Orientation is "landscape", "portrait" or "other"
+	Orientation string
+	// Profile is the name of the processing profile
+	Profile string
+	// Random is a random name, unique to each upload
+	Random string
+}
+
+// Placeholders such as {userID}
+var keyPlaceholderPattern = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+// ParseKeyTemplate checks a key template, returning DefaultKeyTemplate for
+// an empty one. Templates must use {random}, so a new upload never
+// overwrites an object that's still being served.
+func ParseKeyTemplate(template string) (KeyTemplate, error) {
+	if template == "" {
+		return DefaultKeyTemplate, nil
+	}
+	for _, match := range keyPlaceholderPattern.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case "userID", "videoID", "orientation", "profile", "random":
+		default:
+			return "", fmt.Errorf("key template has unknown placeholder %s", match[0])
+		}
+	}
+	if !strings.Contains(template, "{random}") {
+		return "", fmt.Errorf("key template must use {random}")
+	}
+	if strings.ContainsAny(keyPlaceholderPattern.ReplaceAllString(template, ""), "{}") {
+		return "", fmt.Errorf("key template has an unclosed placeholder")
+	}
+	for _, segment := range strings.Split(template, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("key template %q must be a relative path without empty, . or .. segments", template)
+		}
+	}
+	return KeyTemplate(template), nil
+}
+
+// Name fills in the template, returning the object name to pass to
+// Profile.ObjectName.
+func (t KeyTemplate) Name(fields KeyFields) string {
+	if t == "" {
+		t = DefaultKeyTemplate
+	}
+	replacer := strings.NewReplacer(
+		"{userID}", fields.UserID.String(),
+		"{videoID}", fields.VideoID.String(),
+		"{orientation}", fields.Orientation,
+		"{profile}", fields.Profile,
+		"{random}", fields.Random,
+	)
+	return path.Clean(replacer.Replace(string(t)))
+}
+
+// OrientationForAspectRatio returns the orientation videos of an aspect
+// ratio from Probe.AspectRatio are filed under.
+func OrientationForAspectRatio(aspectRatio string) string {
+	switch aspectRatio {
+	case "16:9":
+		return "landscape"
+	case "9:16":
+		return "portrait"
+	default:
+		return "other"
+	}
+}
+
+// VideoOrientation returns the orientation of a video's upload, from the
+// cached probe of its bytes, or false if it can't be told.
+func VideoOrientation(db database.Client, video database.Video) (string, bool) {
+	if video.SourceSHA256 == nil {
+		return "", false
+	}
+	raw, err := db.GetProbe(*video.SourceSHA256)
+	if err != nil || raw == nil {
+		return "", false
+	}
+	probe, err := ParseProbe(raw)
+	if err != nil {
+		return "", false
+	}
+	aspectRatio, err := probe.AspectRatio()
+	if err != nil {
+		return "", false
+	}
+	return OrientationForAspectRatio(aspectRatio), true
+}
+
+// MaxNameAttempts is how many random names are tried for a new object or
+// file before giving up, should they somehow keep being taken.
+const MaxNameAttempts = 5
+
+// ErrNameTaken is returned when every random name tried was already taken.
+var ErrNameTaken = errors.New("couldn't find an unused name")
+
+// ObjectHeader reads objects' metadata, such as an *s3.Client.
+type ObjectHeader interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// ObjectExists reports whether a bucket has an object under key.
+func ObjectExists(ctx context.Context, client ObjectHeader, bucket, key string) (bool, error) {
+	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UnusedName calls newName until it returns a name exists reports isn't
+// taken, failing with ErrNameTaken after MaxNameAttempts.
+func UnusedName(newName func() (string, error), exists func(name string) (bool, error)) (string, error) {
+	for attempt := 0; attempt < MaxNameAttempts; attempt++ {
+		name, err := newName()
+		if err != nil {
+			return "", err
+		}
+		taken, err := exists(name)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+	}
+	return "", ErrNameTaken
+}
@@ -0,0 +1,262 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const mediaConvertAPIVersion = "2017-08-29"
+
+// MediaConvertTranscoder submits jobs to AWS Elemental MediaConvert, which
+// reads the staged source from the bucket and writes the output straight to
+// the job's object key. It talks to the MediaConvert REST API directly,
+// signing requests with the deployment's AWS credentials.
+type MediaConvertTranscoder struct {
+	awsCfg     aws.Config
+	cfg        MediaConvertConfig
+	signer     *v4.Signer
+	httpClient *http.Client
+}
+
+// NewMediaConvertTranscoder validates cfg and returns a MediaConvert backend.
+func NewMediaConvertTranscoder(awsCfg aws.Config, cfg MediaConvertConfig) (*MediaConvertTranscoder, error) {
+	if cfg.RoleARN == "" {
+		return nil, errors.New("MediaConvert requires an IAM role ARN")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("MediaConvert requires a bucket")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://mediaconvert.%s.amazonaws.com", awsCfg.Region)
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 10 * time.Second
+	}
+
+	return &MediaConvertTranscoder{
+		awsCfg:     awsCfg,
+		cfg:        cfg,
+		signer:     v4.NewSigner(),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (t *MediaConvertTranscoder) Name() string {
+	return TranscoderMediaConvert
+}
+
+func (t *MediaConvertTranscoder) Transcode(ctx context.Context, req TranscodeRequest) (TranscodeResult, error) {
+	if len(req.Chapters) > 0 {
+		log.Printf("MediaConvert doesn't embed chapters, skipping %d marker(s) for video %s", len(req.Chapters), req.Job.VideoID)
+	}
+
+	// Pick up a job submitted before an interruption rather than paying for it twice
+	externalID := ""
+	if req.Job.ExternalID != nil {
+		externalID = *req.Job.ExternalID
+	} else {
+		sourceKey, err := req.RemoteSource(ctx)
+		if err != nil {
+			return TranscodeResult{}, err
+		}
+
+		externalID, err = t.submit(ctx, req.Job.ID.String(), sourceKey, req.Bucket, req.Job.ObjectKey)
+		if err != nil {
+			return TranscodeResult{}, err
+		}
+		if err := req.SaveExternalID(externalID); err != nil {
+			return TranscodeResult{}, err
+		}
+	}
+
+	if t.cfg.Callbacks {
+		return TranscodeResult{Pending: true}, nil
+	}
+
+	if err := t.wait(ctx, externalID); err != nil {
+		return TranscodeResult{}, err
+	}
+
+	return TranscodeResult{Stored: true}, nil
+}
+
+type mediaConvertJob struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// submit creates a MediaConvert job producing a progressive-download MP4 at objectKey.
+func (t *MediaConvertTranscoder) submit(ctx context.Context, jobID, sourceKey, bucket, objectKey string) (string, error) {
+
+	// MediaConvert appends the container extension to the destination itself
+	if bucket == "" {
+		bucket = t.cfg.Bucket
+	}
+	destination := fmt.Sprintf("s3://%s/%s", bucket, strings.TrimSuffix(objectKey, path.Ext(objectKey)))
+
+	body := map[string]any{
+		"role": t.cfg.RoleARN,
+		"userMetadata": map[string]string{
+			"tubelyJobId": jobID,
+		},
+		"settings": map[string]any{
+			"inputs": []map[string]any{{
+				"fileInput":      fmt.Sprintf("s3://%s/%s", t.cfg.Bucket, sourceKey),
+				"timecodeSource": "ZEROBASED",
+				"videoSelector":  map[string]any{},
+				"audioSelectors": map[string]any{
+					"Audio Selector 1": map[string]any{"defaultSelection": "DEFAULT"},
+				},
+			}},
+			"outputGroups": []map[string]any{{
+				"name": "File Group",
+				"outputGroupSettings": map[string]any{
+					"type": "FILE_GROUP_SETTINGS",
+					"fileGroupSettings": map[string]any{
+						"destination": destination,
+					},
+				},
+				"outputs": []map[string]any{{
+					"containerSettings": map[string]any{
+						"container": "MP4",
+						"mp4Settings": map[string]any{
+							"moovPlacement": "PROGRESSIVE_DOWNLOAD",
+						},
+					},
+					"videoDescription": map[string]any{
+						"codecSettings": map[string]any{
+							"codec": "H_264",
+							"h264Settings": map[string]any{
+								"rateControlMode": "QVBR",
+								"maxBitrate":      8000000,
+								"qvbrSettings": map[string]any{
+									"qvbrQualityLevel": 7,
+								},
+							},
+						},
+					},
+					"audioDescriptions": []map[string]any{{
+						"audioSourceName": "Audio Selector 1",
+						"codecSettings": map[string]any{
+							"codec": "AAC",
+							"aacSettings": map[string]any{
+								"bitrate":    96000,
+								"codingMode": "CODING_MODE_2_0",
+								"sampleRate": 48000,
+							},
+						},
+					}},
+				}},
+			}},
+		},
+	}
+	if t.cfg.Queue != "" {
+		body["queue"] = t.cfg.Queue
+	}
+
+	var response struct {
+		Job mediaConvertJob `json:"job"`
+	}
+	if err := t.do(ctx, http.MethodPost, "/jobs", body, &response); err != nil {
+		return "", fmt.Errorf("couldn't submit MediaConvert job: %w", err)
+	}
+	if response.Job.ID == "" {
+		return "", errors.New("MediaConvert returned no job ID")
+	}
+
+	return response.Job.ID, nil
+}
+
+// CancelExternal cancels a MediaConvert job that hasn't finished.
+func (t *MediaConvertTranscoder) CancelExternal(ctx context.Context, externalID string) error {
+	var response struct{}
+	if err := t.do(ctx, http.MethodDelete, "/jobs/"+externalID, nil, &response); err != nil {
+		return fmt.Errorf("couldn't cancel MediaConvert job %s: %w", externalID, err)
+	}
+	return nil
+}
+
+// wait polls a MediaConvert job until it reaches a terminal status.
+func (t *MediaConvertTranscoder) wait(ctx context.Context, externalID string) error {
+	for {
+		var response struct {
+			Job mediaConvertJob `json:"job"`
+		}
+		if err := t.do(ctx, http.MethodGet, "/jobs/"+externalID, nil, &response); err != nil {
+			return fmt.Errorf("couldn't poll MediaConvert job %s: %w", externalID, err)
+		}
+
+		switch response.Job.Status {
+		case "COMPLETE":
+			return nil
+		case "ERROR", "CANCELED":
+			return fmt.Errorf("MediaConvert job %s %s: %s", externalID, strings.ToLower(response.Job.Status), response.Job.ErrorMessage)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.cfg.PollInterval):
+		}
+	}
+}
+
+// do sends a SigV4 signed request to the MediaConvert API.
+func (t *MediaConvertTranscoder) do(ctx context.Context, method, resource string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	url := strings.TrimSuffix(t.cfg.Endpoint, "/") + "/" + mediaConvertAPIVersion + resource
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	creds, err := t.awsCfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve AWS credentials: %w", err)
+	}
+	payloadHash := sha256.Sum256(payload)
+	err = t.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "mediaconvert", t.awsCfg.Region, time.Now())
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("MediaConvert responded %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,42 @@
+package processing
+
+import (
+	"encoding/binary"
+)
+
+// Input ffmpeg and ffprobe are given to read a video piped into them
+const pipeInput = "pipe:0"
+
+// StreamableMP4 reports whether an MP4 held in memory can be read through a
+// pipe: its moov atom, the index of where every frame is, has to come
+// before the media data. Files written without faststart keep it at the
+// end, and ffmpeg can't go back for the frames after reading it.
+func StreamableMP4(data []byte) bool {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		header := uint64(8)
+		switch size {
+		case 0:
+			// The box runs to the end of the file
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return false
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+
+		switch string(data[4:8]) {
+		case "moov":
+			return true
+		case "mdat":
+			return false
+		}
+		if size < header || size > uint64(len(data)) {
+			return false
+		}
+		data = data[size:]
+	}
+	return false
+}
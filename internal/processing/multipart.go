@@ -0,0 +1,400 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Size of each part of a streamed upload. S3 requires every part but the
+// last to be at least 5 MiB, and allows at most 10,000 parts, so this caps
+// a streamed object at about 80 GiB.
+const multipartPartSize = 8 << 20
+
+// MaxUploadStreamSize is the largest object UploadStream can store.
+const MaxUploadStreamSize = multipartPartSize * 10000
+
+// MaxPutObjectSize is the largest object a single PutObject can store.
+// Anything bigger has to be uploaded in parts.
+const MaxPutObjectSize = 5 << 30
+
+// Each request of an upload is tried this many times while S3 fails in a
+// way that may not happen again, such as a 5XX response, throttling or a
+// dropped connection, waiting uploadRetryBackoff before the first retry and
+// twice as long before each one after. This is on top of the SDK's own
+// quick retries, to ride out outages of several seconds.
+const (
+	uploadAttempts     = 4
+	uploadRetryBackoff = 2 * time.Second
+)
+
+// transientErrors classifies errors the way the SDK's own retries do.
+var transientErrors = retry.IsErrorRetryables(retry.DefaultRetryables)
+
+// activeMultipartState keeps the state of multipart uploads, so one
+// interrupted by a crash carries on from its last part. Without it uploads
+// start over.
+var activeMultipartState *database.Client
+
+// UseMultipartState keeps the state of multipart uploads in db from now on.
+func UseMultipartState(db database.Client) {
+	activeMultipartState = &db
+}
+
+// ObjectUploader uploads objects whole or in parts, such as an *s3.Client.
+type ObjectUploader interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// UploadObject stores input's body, size bytes long, with a single
+// PutObject when it fits in one and as a multipart upload when it doesn't.
+// S3 can't check a checksum of the whole object against a multipart
+// upload, so input's is dropped for those.
+//
+// Requests failing transiently are tried again if the body can be rewound.
+// A multipart upload of a body that can seek is resumable: if it's
+// interrupted, uploading the same object again carries on from the parts
+// S3 already has.
+func UploadObject(ctx context.Context, client ObjectUploader, input *s3.PutObjectInput, size int64) error {
+	bucket, key := aws.ToString(input.Bucket), aws.ToString(input.Key)
+	if size <= MaxPutObjectSize {
+		put := func() error {
+			_, err := client.PutObject(ctx, input)
+			return err
+		}
+		rewind := rewinder(input.Body)
+		if rewind == nil {
+			return put()
+		}
+		return retryTransient(ctx, "Upload of "+key, rewind, put)
+	}
+	if body, ok := input.Body.(io.ReadSeeker); ok {
+		return uploadResumable(ctx, client, bucket, key, aws.ToString(input.ContentType), body, size)
+	}
+	return UploadStream(ctx, client, bucket, key, aws.ToString(input.ContentType), input.Body)
+}
+
+// UploadStream uploads everything read from r to key as a multipart upload,
+// one part at a time, so an object of unknown length can be stored without
+// first being written to disk. If r returns an error the upload is aborted
+// and nothing is left at key.
+func UploadStream(ctx context.Context, client ObjectUploader, bucket, key, contentType string, r io.Reader) error {
+	upload, err := startMultipart(ctx, client, bucket, key, contentType, nil)
+	if err != nil {
+		return err
+	}
+
+	err = upload.uploadParts(ctx, r)
+	if err == nil {
+		err = upload.complete(ctx)
+	}
+	if err != nil {
+		// What was read from r can't be read again, so there's no resuming
+		upload.abort(ctx)
+		return err
+	}
+	return nil
+}
+
+// uploadResumable uploads body, size bytes from where it's positioned, as
+// a multipart upload, carrying on from an interrupted upload of the same
+// object if there is one. If it fails in a way that trying again could
+// fix, the upload is left for the next attempt to resume, or for the
+// MultipartSweeper to abort if there isn't one.
+func uploadResumable(ctx context.Context, client ObjectUploader, bucket, key, contentType string, body io.ReadSeeker, size int64) error {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	upload, err := resumeMultipart(ctx, client, bucket, key, size)
+	if err != nil {
+		return err
+	}
+	if upload == nil {
+		upload, err = startMultipart(ctx, client, bucket, key, contentType, &size)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Resuming upload of %s from part %d", key, len(upload.parts)+1)
+	}
+
+	_, err = body.Seek(start+int64(len(upload.parts))*multipartPartSize, io.SeekStart)
+	if err == nil {
+		err = upload.uploadParts(ctx, io.LimitReader(body, size-int64(len(upload.parts))*multipartPartSize))
+	}
+	if err == nil {
+		err = upload.complete(ctx)
+	}
+	if err != nil {
+		if ctx.Err() != nil || transientErrors.IsErrorRetryable(err) == aws.TrueTernary {
+			log.Printf("Upload of %s stopped after %d parts, it can be resumed: %v", key, len(upload.parts), err)
+		} else {
+			upload.abort(ctx)
+		}
+		return err
+	}
+	return nil
+}
+
+// multipartUpload is a multipart upload in progress and the parts it has
+// stored.
+type multipartUpload struct {
+	client   ObjectUploader
+	bucket   string
+	key      string
+	uploadID *string
+	parts    []types.CompletedPart
+	// resumable uploads record each part they store
+	resumable bool
+}
+
+// startMultipart starts a multipart upload of an object that's size bytes
+// long, or of unknown length if size is nil, recording it so it can be
+// resumed or aborted after a crash.
+func startMultipart(ctx context.Context, client ObjectUploader, bucket, key, contentType string, size *int64) (*multipartUpload, error) {
+	var created *s3.CreateMultipartUploadOutput
+	err := retryTransient(ctx, "Start of upload of "+key, nil, func() error {
+		var err error
+		created, err = client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			ContentType: aws.String(contentType),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start multipart upload: %w", err)
+	}
+
+	upload := &multipartUpload{
+		client:   client,
+		bucket:   bucket,
+		key:      key,
+		uploadID: created.UploadId,
+	}
+	if activeMultipartState != nil {
+		err := activeMultipartState.CreateMultipartUpload(database.CreateMultipartUploadParams{
+			UploadID: aws.ToString(created.UploadId),
+			Bucket:   bucket,
+			Key:      key,
+			Size:     size,
+			PartSize: multipartPartSize,
+		})
+		if err != nil {
+			log.Printf("Couldn't record upload of %s, it won't be resumable: %v", key, err)
+		} else {
+			upload.resumable = size != nil
+		}
+	}
+	return upload, nil
+}
+
+// resumeMultipart returns the recorded upload of an object of size bytes
+// to key, with the parts S3 still has from its first part on, or nil if
+// there's none to resume.
+func resumeMultipart(ctx context.Context, client ObjectUploader, bucket, key string, size int64) (*multipartUpload, error) {
+	if activeMultipartState == nil {
+		return nil, nil
+	}
+	saved, err := activeMultipartState.GetResumableMultipartUpload(bucket, key, size, multipartPartSize)
+	if err != nil || saved.UploadID == "" {
+		return nil, err
+	}
+
+	stored := map[int32]string{}
+	paginator := s3.NewListPartsPaginator(client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(saved.UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			// Aborted, perhaps by the sweeper, or completed after all
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload" {
+				forgetMultipart(saved.UploadID)
+				return nil, nil
+			}
+			return nil, fmt.Errorf("couldn't list parts of upload to resume: %w", err)
+		}
+		for _, part := range page.Parts {
+			stored[aws.ToInt32(part.PartNumber)] = aws.ToString(part.ETag)
+		}
+	}
+
+	upload := &multipartUpload{
+		client:    client,
+		bucket:    bucket,
+		key:       key,
+		uploadID:  aws.String(saved.UploadID),
+		resumable: true,
+	}
+	// Only whole parts, in an unbroken run from the first, are kept; anything
+	// after is uploaded again
+	for i, part := range saved.Parts {
+		if part.Number != int32(i+1) || part.Size != multipartPartSize || stored[part.Number] != part.ETag {
+			break
+		}
+		upload.parts = append(upload.parts, types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(part.Number),
+		})
+	}
+	return upload, nil
+}
+
+// uploadParts reads r in multipartPartSize chunks and uploads each as a
+// part, numbered on from the parts already stored.
+func (u *multipartUpload) uploadParts(ctx context.Context, r io.Reader) error {
+	buf := make([]byte, multipartPartSize)
+	for partNumber := int32(len(u.parts) + 1); ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return readErr
+		}
+
+		// An empty final read only ends the upload, unless nothing was read at all
+		if n > 0 || len(u.parts) == 0 {
+			body := bytes.NewReader(buf[:n])
+			var uploaded *s3.UploadPartOutput
+			err := retryTransient(ctx, fmt.Sprintf("Part %d of upload of %s", partNumber, u.key), rewinder(body), func() error {
+				var err error
+				uploaded, err = u.client.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:        aws.String(u.bucket),
+					Key:           aws.String(u.key),
+					UploadId:      u.uploadID,
+					PartNumber:    aws.Int32(partNumber),
+					Body:          body,
+					ContentLength: aws.Int64(int64(n)),
+				})
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't upload part %d: %w", partNumber, err)
+			}
+			u.parts = append(u.parts, types.CompletedPart{
+				ETag:       uploaded.ETag,
+				PartNumber: aws.Int32(partNumber),
+			})
+			if u.resumable {
+				err := activeMultipartState.RecordMultipartPart(aws.ToString(u.uploadID), database.MultipartPart{
+					Number: partNumber,
+					ETag:   aws.ToString(uploaded.ETag),
+					Size:   int64(n),
+				})
+				if err != nil {
+					log.Printf("Couldn't record part %d of upload of %s: %v", partNumber, u.key, err)
+				}
+			}
+		}
+
+		if readErr != nil {
+			return nil
+		}
+	}
+}
+
+// complete assembles the stored parts into the object.
+func (u *multipartUpload) complete(ctx context.Context) error {
+	err := retryTransient(ctx, "Completion of upload of "+u.key, nil, func() error {
+		_, err := u.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(u.bucket),
+			Key:             aws.String(u.key),
+			UploadId:        u.uploadID,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: u.parts},
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't complete multipart upload: %w", err)
+	}
+	forgetMultipart(aws.ToString(u.uploadID))
+	return nil
+}
+
+// abort deletes the stored parts.
+func (u *multipartUpload) abort(ctx context.Context) {
+	// Abort even if ctx is done, or the parts are billed until the sweeper
+	// or a lifecycle rule cleans them up
+	_, err := u.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.bucket),
+		Key:      aws.String(u.key),
+		UploadId: u.uploadID,
+	})
+	if err != nil {
+		log.Printf("Couldn't abort multipart upload of %s: %v", u.key, err)
+		return
+	}
+	forgetMultipart(aws.ToString(u.uploadID))
+}
+
+// forgetMultipart drops the record of an upload that's finished.
+func forgetMultipart(uploadID string) {
+	if activeMultipartState == nil {
+		return
+	}
+	if err := activeMultipartState.DeleteMultipartUpload(uploadID); err != nil {
+		log.Printf("Couldn't forget multipart upload %s: %v", uploadID, err)
+	}
+}
+
+// rewinder returns a function moving body back to where it is now, so a
+// request can send it again, or nil if it can't seek.
+func rewinder(body io.Reader) func() error {
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}
+}
+
+// retryTransient runs request, running it again after a backoff while it
+// fails transiently, up to uploadAttempts times. Requests sending a body
+// must give rewind to move it back to the start before each retry.
+func retryTransient(ctx context.Context, what string, rewind func() error, request func() error) error {
+	backoff := uploadRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := request()
+		if err == nil || attempt == uploadAttempts || ctx.Err() != nil || transientErrors.IsErrorRetryable(err) != aws.TrueTernary {
+			return err
+		}
+		if rewind != nil {
+			if rewindErr := rewind(); rewindErr != nil {
+				return err
+			}
+		}
+		log.Printf("%s failed, trying again in %s: %v", what, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,130 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// MultipartSweeper periodically aborts multipart uploads started more than
+// MaxAge ago that haven't stored a part within MaxAge either. Crashes and
+// uploads that were never resumed leave them behind, and S3 bills for
+// their parts until they're aborted.
+type MultipartSweeper struct {
+	DB       database.Client
+	S3Client *s3.Client
+	// Buckets resolves the client for users' own buckets; without it every
+	// bucket uses S3Client
+	Buckets *BucketClients
+	// Bucket is the deployment's bucket, which is always swept. Other
+	// buckets are swept while uploads to them are recorded.
+	Bucket   string
+	MaxAge   time.Duration
+	Interval time.Duration
+}
+
+// Run sweeps once immediately and then every Interval until ctx is cancelled.
+func (s *MultipartSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		aborted, err := s.Sweep(ctx)
+		if err != nil {
+			log.Printf("Multipart upload sweep failed: %v", err)
+		}
+		if aborted > 0 {
+			log.Printf("Aborted %d multipart upload(s) older than %s", aborted, s.MaxAge)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep aborts stale multipart uploads, returning how many it aborted.
+func (s *MultipartSweeper) Sweep(ctx context.Context) (int, error) {
+	buckets, err := s.DB.GetMultipartUploadBuckets()
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Contains(buckets, s.Bucket) {
+		buckets = append([]string{s.Bucket}, buckets...)
+	}
+	// A long upload can outlast MaxAge, but not without storing a part
+	active, err := s.DB.GetActiveMultipartUploadIDs(s.MaxAge)
+	if err != nil {
+		return 0, err
+	}
+
+	aborted := 0
+	errs := []error{}
+	cutoff := time.Now().Add(-s.MaxAge)
+	for _, bucket := range buckets {
+		n, err := s.sweepBucket(ctx, bucket, cutoff, active)
+		aborted += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bucket %s: %w", bucket, err))
+		}
+	}
+
+	// Records of uploads S3 no longer has, completed or aborted elsewhere
+	if _, err := s.DB.DeleteStaleMultipartUploads(s.MaxAge); err != nil {
+		errs = append(errs, err)
+	}
+	return aborted, errors.Join(errs...)
+}
+
+func (s *MultipartSweeper) sweepBucket(ctx context.Context, bucket string, cutoff time.Time, active map[string]bool) (int, error) {
+	client := s.S3Client
+	if s.Buckets != nil {
+		var err error
+		client, err = s.Buckets.Client(bucket)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	aborted := 0
+	paginator := s3.NewListMultipartUploadsPaginator(client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return aborted, err
+		}
+		for _, upload := range page.Uploads {
+			uploadID := aws.ToString(upload.UploadId)
+			if upload.Initiated == nil || upload.Initiated.After(cutoff) || active[uploadID] {
+				continue
+			}
+			_, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			var apiErr smithy.APIError
+			if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload") {
+				log.Printf("Couldn't abort multipart upload of %s: %v", aws.ToString(upload.Key), err)
+				continue
+			}
+			if err := s.DB.DeleteMultipartUpload(uploadID); err != nil {
+				log.Printf("Couldn't forget multipart upload %s: %v", uploadID, err)
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
+}
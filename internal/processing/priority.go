@@ -0,0 +1,88 @@
+package processing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// PriorityWeights is each priority's share of the jobs a worker claims
+// while every priority has jobs waiting. A priority with no weight is only
+// claimed when the others have nothing queued.
+type PriorityWeights map[database.JobPriority]int
+
+// DefaultPriorityWeights give interactive jobs most of the workers' time
+// without leaving bulk jobs waiting forever.
+var DefaultPriorityWeights = PriorityWeights{
+	database.JobPriorityInteractive: 6,
+	database.JobPriorityNormal:      3,
+	database.JobPriorityBulk:        1,
+}
+
+// ParsePriorityWeights parses weights such as
+// "interactive=6,normal=3,bulk=1". Priorities left out keep their default
+// weight, so an empty string gives the defaults.
+func ParsePriorityWeights(s string) (PriorityWeights, error) {
+	weights := PriorityWeights{}
+	for priority, weight := range DefaultPriorityWeights {
+		weights[priority] = weight
+	}
+	if strings.TrimSpace(s) == "" {
+		return weights, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("priority weight %q must look like priority=weight", part)
+		}
+		priority := database.JobPriority(strings.TrimSpace(name))
+		if !priority.Valid() {
+			return nil, fmt.Errorf("unknown priority %q, must be interactive, normal or bulk", name)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight of priority %s must be a whole number of at least 0", priority)
+		}
+		weights[priority] = weight
+	}
+	return weights, nil
+}
+
+// priorityScheduler picks the priority a worker looks at first for each
+// claim, with smooth weighted round robin so a priority's turns are spread
+// out rather than bunched together.
+type priorityScheduler struct {
+	weights PriorityWeights
+	current map[database.JobPriority]int
+}
+
+func newPriorityScheduler(weights PriorityWeights) *priorityScheduler {
+	return &priorityScheduler{
+		weights: weights,
+		current: map[database.JobPriority]int{},
+	}
+}
+
+// next returns the priority whose turn it is, or "" when no priority has a
+// weight and jobs are simply claimed highest priority first.
+func (s *priorityScheduler) next() database.JobPriority {
+	total := 0
+	var picked database.JobPriority
+	for _, priority := range database.JobPriorities {
+		weight := s.weights[priority]
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		s.current[priority] += weight
+		if picked == "" || s.current[priority] > s.current[picked] {
+			picked = priority
+		}
+	}
+	if picked != "" {
+		s.current[picked] -= total
+	}
+	return picked
+}
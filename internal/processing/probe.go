@@ -0,0 +1,166 @@
+package processing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Probe is the subset of ffprobe's JSON output the pipeline relies on.
+type Probe struct {
+	Streams []ProbeStream `json:"streams"`
+	Format  ProbeFormat   `json:"format"`
+}
+
+type ProbeStream struct {
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+type ProbeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	Size       string `json:"size"`
+}
+
+// ProbeFile runs ffprobe against filePath and returns its raw JSON output.
+func ProbeFile(filePath string) ([]byte, error) {
+	return probe(filePath, nil)
+}
+
+// ProbeData runs ffprobe against a video held in memory, piping it in, and
+// returns its raw JSON output.
+func ProbeData(data []byte) ([]byte, error) {
+	return probe(pipeInput, bytes.NewReader(data))
+}
+
+// probe runs ffprobe against input, reading it from stdin if set.
+func probe(input string, stdin io.Reader) ([]byte, error) {
+	cmd := activeCommands.command(CommandProbe, map[string]string{"input": input}, nil)
+
+	var stdout bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe error: %v", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// ParseProbe decodes raw ffprobe JSON.
+func ParseProbe(raw []byte) (Probe, error) {
+	var probe Probe
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return Probe{}, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	return probe, nil
+}
+
+// CachedProbe returns the probe for a file whose SHA-256 is already known,
+// running ffprobe only if identical bytes haven't been probed before.
+func CachedProbe(db database.Client, filePath, sha256Hex string) (Probe, error) {
+	return cachedProbe(db, sha256Hex, func() ([]byte, error) {
+		return ProbeFile(filePath)
+	})
+}
+
+// CachedProbeScratch is CachedProbe for a file made with
+// Scratch.CreateTempFile, which is only served decrypted to ffprobe if it
+// has to be probed.
+func CachedProbeScratch(db database.Client, scratch *Scratch, filePath, sha256Hex string) (Probe, error) {
+	return cachedProbe(db, sha256Hex, func() ([]byte, error) {
+		input, done, err := scratch.Plaintext(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		return ProbeFile(input)
+	})
+}
+
+// CachedProbeData is CachedProbe for a video held in memory.
+func CachedProbeData(db database.Client, data []byte, sha256Hex string) (Probe, error) {
+	return cachedProbe(db, sha256Hex, func() ([]byte, error) {
+		return ProbeData(data)
+	})
+}
+
+func cachedProbe(db database.Client, sha256Hex string, probe func() ([]byte, error)) (Probe, error) {
+	raw, err := db.GetProbe(sha256Hex)
+	if err != nil {
+		return Probe{}, err
+	}
+
+	if raw == nil {
+		raw, err = probe()
+		if err != nil {
+			return Probe{}, err
+		}
+		if err := db.SaveProbe(sha256Hex, raw); err != nil {
+			return Probe{}, err
+		}
+	}
+
+	return ParseProbe(raw)
+}
+
+// Duration returns the container duration in seconds.
+func (p Probe) Duration() (float64, error) {
+	if p.Format.Duration == "" {
+		return 0, errors.New("probe has no duration")
+	}
+	duration, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse video duration: %v", err)
+	}
+	return duration, nil
+}
+
+// AspectRatio returns "16:9", "9:16" or "other" for the first video stream.
+func (p Probe) AspectRatio() (string, error) {
+	for _, stream := range p.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+
+		// Perform calculations to determine aspect ratio
+		width := stream.Width
+		height := stream.Height
+
+		if width == 16*height/9 {
+			return "16:9", nil
+		} else if height == 16*width/9 {
+			return "9:16", nil
+		}
+
+		return "other", nil
+	}
+
+	return "", errors.New("no video streams found")
+}
+
+// FileSHA256 returns the hex SHA-256 of the file at filePath.
+func FileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
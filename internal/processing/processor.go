@@ -0,0 +1,1078 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// MaxJobAttempts is the number of times a job is started before it is given
+// up on, so a job that crashes its process can't do so forever, unless the
+// Processor's Retry says otherwise.
+const MaxJobAttempts = 3
+
+// ErrJobWaiting is returned when a job has been handed to a transcoder that
+// will report its outcome later through a callback.
+var ErrJobWaiting = errors.New("job is waiting for the transcoder to report back")
+
+// ErrJobFinished is returned when cancelling a job that has already
+// succeeded or failed.
+var ErrJobFinished = errors.New("job has already finished")
+
+// Stages of a job, as its trace names them
+const (
+	StageProcess  = "process"
+	StageStore    = "store"
+	StageFinalize = "finalize"
+)
+
+// maxDeadLetterStderr is how much of ffmpeg's stderr a dead letter keeps.
+// The end is kept, since that's where ffmpeg says what went wrong.
+const maxDeadLetterStderr = 64 << 10
+
+// Processor runs video processing jobs, persisting a checkpoint after each
+// stage so an interrupted job can be resumed by any process sharing the
+// database and bucket.
+type Processor struct {
+	DB             database.Client
+	S3Client       *s3.Client
+	S3Bucket       string
+	CfDistribution string
+	Scratch        *Scratch
+	StagingPrefix  string
+	Transcoder     Transcoder
+	Profiles       Profiles
+	// Buckets resolves the client for users' own buckets; without it every
+	// bucket uses S3Client
+	Buckets *BucketClients
+	// Notifier is told when jobs succeed or fail, if set
+	Notifier JobNotifier
+	// Purger is told when a video is pointed at its processed file, if set
+	Purger VideoPurger
+	// Retry governs jobs interrupted by a crash
+	Retry RetryPolicy
+	// EncryptHLS encrypts the segments of HLS outputs with AES-128, using a
+	// key per video kept in the database
+	EncryptHLS bool
+	// Visibility checks stored videos can be read before they're handed out
+	Visibility VisibilityCheck
+
+	// running holds a way to stop each job this process is running
+	mu      sync.Mutex
+	running map[uuid.UUID]context.CancelCauseFunc
+}
+
+// ExternalCanceller is a Transcoder that can stop work it submitted to an
+// external service, such as a *MediaConvertTranscoder.
+type ExternalCanceller interface {
+	CancelExternal(ctx context.Context, externalID string) error
+}
+
+// JobNotifier is told when a job finishes, such as a *notify.Notifier.
+type JobNotifier interface {
+	JobFinished(ctx context.Context, job database.Job, jobErr error)
+}
+
+// VideoPurger drops cached copies of a video's public responses, such as a *cdn.Purger.
+type VideoPurger interface {
+	PurgeVideo(ctx context.Context, video database.Video)
+}
+
+// Run moves a job from its last checkpoint to completion and returns the
+// updated video.
+func (p *Processor) Run(ctx context.Context, job database.Job) (database.Video, error) {
+	return p.RunWith(ctx, job, nil)
+}
+
+// RunWith is Run, additionally applying update to the video in the same
+// write that points it at the processed file.
+func (p *Processor) RunWith(ctx context.Context, job database.Job, update func(*database.Video)) (database.Video, error) {
+	return p.run(ctx, job, nil, update)
+}
+
+// RunWithSource is RunWith for a job whose upload is held in memory rather
+// than at its SourcePath. Nothing is written to scratch space, but the job
+// can't be resumed if the process stops before it finishes.
+func (p *Processor) RunWithSource(ctx context.Context, job database.Job, source []byte, update func(*database.Video)) (database.Video, error) {
+	return p.run(ctx, job, source, update)
+}
+
+func (p *Processor) run(ctx context.Context, job database.Job, source []byte, update func(*database.Video)) (database.Video, error) {
+
+	// Give up on jobs that keep getting interrupted
+	if job.Attempts >= p.Retry.maxAttempts() {
+		err := fmt.Errorf("job gave up after %d attempts", job.Attempts)
+		p.fail(ctx, job, source, err)
+		return database.Video{}, err
+	}
+
+	// The job can be cancelled while it runs, which kills its ffmpeg
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	p.track(job.ID, cancel)
+	defer p.untrack(job.ID)
+
+	// Mark the job as running
+	job.State = database.JobStateRunning
+	job.Attempts++
+	job.Error = nil
+	if err := p.DB.UpdateJob(job); err != nil {
+		if errors.Is(err, database.ErrJobCancelled) {
+			p.discard(ctx, job)
+		}
+		return database.Video{}, err
+	}
+
+	video, err := p.advance(ctx, &job, source, update)
+	if err != nil {
+		if errors.Is(err, database.ErrJobCancelled) || errors.Is(context.Cause(ctx), database.ErrJobCancelled) {
+			p.discard(ctx, job)
+			return database.Video{}, database.ErrJobCancelled
+		}
+		if !errors.Is(err, ErrJobWaiting) {
+			p.fail(ctx, job, source, err)
+		}
+		return database.Video{}, err
+	}
+
+	return video, nil
+}
+
+// Cancel stops a job that hasn't finished. A job running in this process
+// is stopped straight away, killing its ffmpeg, and cleans up after itself.
+// A job running on a worker is stopped by the worker at its next heartbeat;
+// what it left in the bucket is removed here as well. Work submitted to an
+// external transcoder is cancelled there too.
+func (p *Processor) Cancel(ctx context.Context, job database.Job) (database.Job, error) {
+	cancelled, err := p.DB.CancelJob(job.ID, "cancelled by owner")
+	if err != nil {
+		return database.Job{}, err
+	}
+	if !cancelled {
+		current, err := p.DB.GetJob(job.ID)
+		if err != nil {
+			return database.Job{}, err
+		}
+		if current.State == database.JobStateCancelled {
+			return current, nil
+		}
+		return database.Job{}, ErrJobFinished
+	}
+
+	// Read the job again for anything it recorded since, such as its
+	// staged source or its transcoder's job
+	current, err := p.DB.GetJob(job.ID)
+	if err != nil {
+		return database.Job{}, err
+	}
+	if current.ExternalID != nil {
+		if canceller, ok := p.transcoder().(ExternalCanceller); ok {
+			if err := canceller.CancelExternal(ctx, *current.ExternalID); err != nil {
+				log.Printf("Couldn't cancel transcoder job %s for job %s: %v", *current.ExternalID, job.ID, err)
+			}
+		}
+	}
+	if !p.stop(job.ID) {
+		p.discard(ctx, current)
+	}
+	return current, nil
+}
+
+// track records how to stop a job this process is running.
+func (p *Processor) track(id uuid.UUID, cancel context.CancelCauseFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running == nil {
+		p.running = map[uuid.UUID]context.CancelCauseFunc{}
+	}
+	p.running[id] = cancel
+}
+
+func (p *Processor) untrack(id uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.running, id)
+}
+
+// stop cancels a job if this process is running it, reporting whether it was.
+func (p *Processor) stop(id uuid.UUID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cancel, ok := p.running[id]
+	if ok {
+		cancel(database.ErrJobCancelled)
+	}
+	return ok
+}
+
+// ResumeIncomplete re-runs every job left queued or running by a previous
+// process. It's only safe when no other process is working on jobs.
+func (p *Processor) ResumeIncomplete(ctx context.Context) {
+	jobs, err := p.DB.GetIncompleteJobs()
+	if err != nil {
+		log.Printf("Couldn't load incomplete jobs: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		log.Printf("Resuming job %s for video %s from checkpoint %s", job.ID, job.VideoID, job.Checkpoint)
+		if _, err := p.Run(ctx, job); err != nil {
+			if errors.Is(err, ErrJobWaiting) {
+				log.Printf("Job %s is waiting for its transcoder to report back", job.ID)
+				continue
+			}
+			if errors.Is(err, database.ErrJobCancelled) {
+				log.Printf("Job %s was cancelled", job.ID)
+				continue
+			}
+			log.Printf("Job %s failed: %v", job.ID, err)
+			continue
+		}
+		log.Printf("Job %s finished", job.ID)
+	}
+}
+
+func (p *Processor) advance(ctx context.Context, job *database.Job, source []byte, update func(*database.Video)) (database.Video, error) {
+	if job.ObjectKey == "" {
+		return database.Video{}, errors.New("job has no object key")
+	}
+
+	// The processed file lives in scratch space, so redo processing if it's gone
+	if job.Checkpoint == database.JobCheckpointProcessed && !fileExists(job.ProcessedPath) {
+		job.Checkpoint = database.JobCheckpointReceived
+	}
+
+	if job.Checkpoint == database.JobCheckpointReceived {
+		err := p.traced(job, StageProcess, func() error {
+			return p.process(ctx, job, source)
+		})
+		if err != nil {
+			return database.Video{}, err
+		}
+	}
+
+	if job.Checkpoint == database.JobCheckpointProcessed {
+		err := p.traced(job, StageStore, func() error {
+			return p.store(ctx, job)
+		})
+		if err != nil {
+			return database.Video{}, err
+		}
+	}
+
+	return p.tracedFinalize(ctx, job, update)
+}
+
+// traced runs a stage of a job, adding how long it took and how it ended
+// to the job's trace. A failure to record the trace doesn't fail the job.
+func (p *Processor) traced(job *database.Job, stage string, run func() error) error {
+	started := time.Now()
+	err := run()
+
+	entry := database.JobTraceEntry{
+		Attempt:   job.Attempts,
+		Stage:     stage,
+		WorkerID:  job.WorkerID,
+		StartedAt: started.UTC(),
+		Duration:  time.Since(started).Seconds(),
+	}
+	if err != nil && !errors.Is(err, ErrJobWaiting) {
+		// ffmpeg's stderr is kept whole in the dead letter, not in every entry
+		msg := err.Error()
+		var ffmpegErr *FFmpegError
+		if errors.As(err, &ffmpegErr) {
+			msg = fmt.Sprintf("ffmpeg failed: %v", ffmpegErr.Err)
+		}
+		entry.Error = &msg
+	}
+	if err := p.DB.AppendJobTrace(job.ID, entry); err != nil {
+		log.Printf("Couldn't record %s stage of job %s: %v", stage, job.ID, err)
+	}
+	job.Trace = append(job.Trace, entry)
+	return err
+}
+
+// tracedFinalize is finalize as a traced stage.
+func (p *Processor) tracedFinalize(ctx context.Context, job *database.Job, update func(*database.Video)) (database.Video, error) {
+	var video database.Video
+	err := p.traced(job, StageFinalize, func() error {
+		var err error
+		video, err = p.finalize(ctx, job, update)
+		return err
+	})
+	return video, err
+}
+
+// process hands the job to the transcoder and fingerprints the result. The
+// source is read from memory if given, or else from the job's SourcePath.
+func (p *Processor) process(ctx context.Context, job *database.Job, source []byte) error {
+
+	chapters, err := p.DB.GetChapters(job.VideoID)
+	if err != nil {
+		return err
+	}
+	storage, err := p.storage(job.VideoID)
+	if err != nil {
+		return err
+	}
+	profile, err := p.profile(*job)
+	if err != nil {
+		return err
+	}
+	var hlsKey []byte
+	if p.EncryptHLS && profile.Format == FormatHLS {
+		hlsKey, err = p.hlsKey(job.VideoID)
+		if err != nil {
+			return fmt.Errorf("couldn't get HLS key: %w", err)
+		}
+	}
+
+	result, err := p.transcoder().Transcode(ctx, TranscodeRequest{
+		Job:      *job,
+		Chapters: chapters,
+		Profile:  profile,
+		HLSKey:   hlsKey,
+		Bucket:   storage.Bucket,
+		Source:   source,
+		LocalSource: func(ctx context.Context) (SourceFile, error) {
+			return p.localSource(ctx, *job)
+		},
+		RemoteSource: func(ctx context.Context) (string, error) {
+			return p.remoteSource(ctx, job)
+		},
+		StoreStream: func(ctx context.Context, r io.Reader) error {
+			client, err := p.client(storage.Bucket)
+			if err != nil {
+				return err
+			}
+			return UploadStream(ctx, client, storage.Bucket, job.ObjectKey, profile.ContentType(), r)
+		},
+		SaveExternalID: func(id string) error {
+			job.ExternalID = &id
+			return p.DB.UpdateJob(*job)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Fingerprint the output, or the source if the output never touched this
+	// host or has no single file; a failure here shouldn't fail the job
+	fingerprintInput := result.ProcessedPath
+	if profile.Format != FormatMP4 {
+		fingerprintInput = ""
+	}
+	if fingerprintInput == "" {
+		if input, done, ok := p.sourceInput(*job); ok {
+			defer done()
+			fingerprintInput = input
+		}
+	}
+	job.Fingerprint = nil
+	if fingerprintInput != "" || source != nil {
+		var fingerprint string
+		var err error
+		if fingerprintInput != "" {
+			fingerprint, err = VideoFingerprint(fingerprintInput, job.Duration)
+		} else {
+			fingerprint, err = VideoFingerprintData(source, job.Duration)
+		}
+		if err != nil {
+			log.Printf("Couldn't fingerprint video %s: %v", job.VideoID, err)
+		} else {
+			job.Fingerprint = &fingerprint
+		}
+	}
+
+	if result.Pending {
+		job.State = database.JobStateWaiting
+		if err := p.DB.UpdateJob(*job); err != nil {
+			return err
+		}
+		return ErrJobWaiting
+	}
+
+	if result.Stored {
+		p.recordStoredSize(ctx, storage.Bucket, *job)
+		job.Checkpoint = database.JobCheckpointStored
+		return p.DB.UpdateJob(*job)
+	}
+
+	job.ProcessedPath = &result.ProcessedPath
+	job.Checkpoint = database.JobCheckpointProcessed
+	return p.DB.UpdateJob(*job)
+}
+
+func (p *Processor) defaultStorage() Storage {
+	return Storage{Bucket: p.S3Bucket, BaseURL: p.CfDistribution}
+}
+
+// storage returns where a video's processed file is stored, which depends
+// on the video's owner and their tenant.
+func (p *Processor) storage(videoID uuid.UUID) (Storage, error) {
+	video, err := p.DB.GetVideo(videoID)
+	if err != nil {
+		return Storage{}, err
+	}
+	if video.ID == uuid.Nil {
+		return Storage{}, errors.New("video no longer exists")
+	}
+	return VideoStorage(p.DB, video, p.defaultStorage())
+}
+
+// client returns the S3 client for a bucket videos are stored in.
+func (p *Processor) client(bucket string) (*s3.Client, error) {
+	if p.Buckets == nil {
+		return p.S3Client, nil
+	}
+	return p.Buckets.Client(bucket)
+}
+
+// profile returns the processing profile a job was uploaded with.
+func (p *Processor) profile(job database.Job) (Profile, error) {
+	profile, ok := p.Profiles.Get(job.Profile)
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown processing profile %q", job.Profile)
+	}
+	return profile, nil
+}
+
+func (p *Processor) transcoder() Transcoder {
+	if p.Transcoder == nil {
+		return FFmpegTranscoder{}
+	}
+	return p.Transcoder
+}
+
+// store uploads the processed output to its final key. Outputs that are a
+// directory, such as HLS renditions, are uploaded file by file under the
+// key's prefix.
+func (p *Processor) store(ctx context.Context, job *database.Job) error {
+
+	storage, err := p.storage(job.VideoID)
+	if err != nil {
+		return err
+	}
+	profile, err := p.profile(*job)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(*job.ProcessedPath)
+	if err != nil {
+		return fmt.Errorf("could not open processed file: %w", err)
+	}
+	if !info.IsDir() {
+		if err := p.storeFile(ctx, storage.Bucket, *job, *job.ProcessedPath, job.ObjectKey, profile.ContentType()); err != nil {
+			return err
+		}
+	} else {
+		prefix := path.Dir(job.ObjectKey)
+		err := filepath.WalkDir(*job.ProcessedPath, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(*job.ProcessedPath, filePath)
+			if err != nil {
+				return err
+			}
+			key := path.Join(prefix, filepath.ToSlash(rel))
+			return p.storeFile(ctx, storage.Bucket, *job, filePath, key, contentTypeForFile(filePath))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	job.Checkpoint = database.JobCheckpointStored
+	if err := p.DB.UpdateJob(*job); err != nil {
+		return err
+	}
+
+	os.RemoveAll(*job.ProcessedPath)
+	return nil
+}
+
+// storeFile uploads one processed file and adds it to the storage ledger.
+func (p *Processor) storeFile(ctx context.Context, bucket string, job database.Job, filePath, key, contentType string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("could not open processed file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// S3 checks the upload against the checksum and keeps it, so fixity
+	// checks can compare against it later without downloading the object
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("could not hash processed file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := hash.Sum(nil)
+	digest := hex.EncodeToString(sum)
+
+	client, err := p.client(bucket)
+	if err != nil {
+		return err
+	}
+	err = UploadObject(ctx, client, &s3.PutObjectInput{
+		Bucket:         aws.String(bucket),
+		Key:            aws.String(key),
+		Body:           file,
+		ContentType:    aws.String(contentType),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+	}, info.Size())
+	if err != nil {
+		return fmt.Errorf("error uploading file to S3: %w", err)
+	}
+
+	err = p.DB.RecordStoredObject(database.RecordStoredObjectParams{
+		UserID:  job.UserID,
+		VideoID: uuid.NullUUID{UUID: job.VideoID, Valid: true},
+		Kind:    database.StorageKindVideo,
+		Bucket:  bucket,
+		Key:     key,
+		Bytes:   info.Size(),
+		SHA256:  &digest,
+	})
+	if err != nil {
+		log.Printf("Couldn't record stored size of %s: %v", key, err)
+	}
+	return nil
+}
+
+// hlsKey returns the key to encrypt a video's HLS segments with, creating
+// it the first time. Reprocessing reuses the key, so players that fetched it
+// for the old segments can keep playing while the new ones replace them.
+func (p *Processor) hlsKey(videoID uuid.UUID) ([]byte, error) {
+	key, err := p.DB.GetHLSKey(videoID)
+	if err != nil || key != nil {
+		return key, err
+	}
+	key, err = NewHLSKey()
+	if err != nil {
+		return nil, err
+	}
+	return p.DB.CreateHLSKey(videoID, key)
+}
+
+// contentTypeForFile returns the media type of a file in an HLS output.
+func contentTypeForFile(filePath string) string {
+	switch filepath.Ext(filePath) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s", ".mp4":
+		return "video/mp4"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// finalize points the video record at the stored object and completes the job.
+func (p *Processor) finalize(ctx context.Context, job *database.Job, update func(*database.Video)) (database.Video, error) {
+
+	video, err := p.DB.GetVideo(job.VideoID)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't find video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, errors.New("video no longer exists")
+	}
+
+	storage, err := VideoStorage(p.DB, video, p.defaultStorage())
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	// URLs for the video are signed as soon as it's pointed at the object
+	if p.Visibility.Attempts > 0 {
+		client, err := p.client(storage.Bucket)
+		if err != nil {
+			return database.Video{}, err
+		}
+		if err := p.Visibility.WaitForObject(ctx, client, storage.Bucket, job.ObjectKey); err != nil {
+			return database.Video{}, fmt.Errorf("couldn't confirm the stored video is readable: %w", err)
+		}
+	}
+
+	url := storage.URL(job.ObjectKey)
+	video.VideoURL = &url
+	video.Duration = &job.Duration
+	video.VideoFingerprint = job.Fingerprint
+	if job.SourceSHA256 != "" {
+		video.SourceSHA256 = &job.SourceSHA256
+	}
+	video.ProcessingProfile = nil
+	if p.transcoder().Name() == TranscoderFFmpeg {
+		if profile, err := p.profile(*job); err == nil {
+			video.ProcessingProfile = &profile.Name
+		}
+	}
+	if update != nil {
+		update(&video)
+	}
+	if err := p.DB.UpdateVideo(video); err != nil {
+		return database.Video{}, fmt.Errorf("couldn't update video: %w", err)
+	}
+	if err := p.DB.TransitionVideo(video.ID, database.VideoStateReady); err != nil {
+		return database.Video{}, fmt.Errorf("couldn't mark video ready: %w", err)
+	}
+	video.State = database.VideoStateReady
+	if p.Purger != nil {
+		go p.Purger.PurgeVideo(context.WithoutCancel(ctx), video)
+	}
+
+	job.State = database.JobStateSucceeded
+	if err := p.DB.UpdateJob(*job); err != nil {
+		return database.Video{}, err
+	}
+
+	p.cleanup(ctx, *job)
+	p.notify(ctx, *job, nil)
+	return video, nil
+}
+
+// Complete finishes a waiting job whose transcoder reported through a
+// callback that it stored the output, at objectKey if that differs from the
+// job's own key.
+func (p *Processor) Complete(ctx context.Context, job database.Job, objectKey string) (database.Video, error) {
+	if job.State != database.JobStateWaiting {
+		return database.Video{}, fmt.Errorf("job %s is %s, not waiting", job.ID, job.State)
+	}
+	if objectKey != "" {
+		job.ObjectKey = objectKey
+	}
+
+	storage, err := p.storage(job.VideoID)
+	if err != nil {
+		p.fail(ctx, job, nil, err)
+		return database.Video{}, err
+	}
+	p.recordStoredSize(ctx, storage.Bucket, job)
+
+	job.State = database.JobStateRunning
+	job.Checkpoint = database.JobCheckpointStored
+	if err := p.DB.UpdateJob(job); err != nil {
+		return database.Video{}, err
+	}
+
+	video, err := p.tracedFinalize(ctx, &job, nil)
+	if err != nil {
+		p.fail(ctx, job, nil, err)
+		return database.Video{}, err
+	}
+	return video, nil
+}
+
+// Fail marks a waiting job as failed after its transcoder reported an error
+// through a callback.
+func (p *Processor) Fail(ctx context.Context, job database.Job, jobErr error) error {
+	if job.State != database.JobStateWaiting {
+		return fmt.Errorf("job %s is %s, not waiting", job.ID, job.State)
+	}
+	p.fail(ctx, job, nil, jobErr)
+	return nil
+}
+
+// recordStoredSize adds the size of an object a transcoder stored itself to
+// the storage ledger, asking the bucket since there's nothing on disk to measure.
+func (p *Processor) recordStoredSize(ctx context.Context, bucket string, job database.Job) {
+	client, err := p.client(bucket)
+	if err != nil {
+		log.Printf("Couldn't get size of %s for job %s: %v", job.ObjectKey, job.ID, err)
+		return
+	}
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(job.ObjectKey),
+	})
+	if err != nil {
+		log.Printf("Couldn't get size of %s for job %s: %v", job.ObjectKey, job.ID, err)
+		return
+	}
+
+	err = p.DB.RecordStoredObject(database.RecordStoredObjectParams{
+		UserID:  job.UserID,
+		VideoID: uuid.NullUUID{UUID: job.VideoID, Valid: true},
+		Kind:    database.StorageKindVideo,
+		Bucket:  bucket,
+		Key:     job.ObjectKey,
+		Bytes:   aws.ToInt64(head.ContentLength),
+	})
+	if err != nil {
+		log.Printf("Couldn't record stored size for job %s: %v", job.ID, err)
+	}
+}
+
+// fail marks a job as failed, records it as a dead letter and releases its
+// scratch files. The source, read from memory if given, is kept in the
+// staging area for the dead letter to be retried from.
+func (p *Processor) fail(ctx context.Context, job database.Job, source []byte, jobErr error) {
+	msg := jobErr.Error()
+	job.State = database.JobStateFailed
+	job.Error = &msg
+	if err := p.DB.UpdateJob(job); err != nil {
+		if errors.Is(err, database.ErrJobCancelled) {
+			p.discard(ctx, job)
+			return
+		}
+		log.Printf("Couldn't mark job %s as failed: %v", job.ID, err)
+	}
+	p.settleVideo(job, database.VideoStateFailed)
+
+	// The dead letter owns the staged source once it's recorded
+	retained := p.deadLetter(ctx, &job, source, jobErr)
+	leftover := job
+	if retained {
+		leftover.SourceKey = nil
+	}
+	p.cleanup(ctx, leftover)
+	p.notify(ctx, job, jobErr)
+}
+
+// deadLetter records a job that failed for good with what's known about
+// why: ffmpeg's stderr, a probe of its source and its trace. It reports
+// whether the job's source was kept, in which case cleanup must leave its
+// staging object be.
+func (p *Processor) deadLetter(ctx context.Context, job *database.Job, source []byte, jobErr error) bool {
+	ctx = context.WithoutCancel(ctx)
+	params := database.CreateDeadLetterParams{
+		Job:   *job,
+		Error: jobErr.Error(),
+		Probe: p.sourceProbe(*job, source),
+	}
+	var ffmpegErr *FFmpegError
+	if errors.As(jobErr, &ffmpegErr) {
+		stderr := ffmpegErr.Stderr
+		if len(stderr) > maxDeadLetterStderr {
+			stderr = stderr[len(stderr)-maxDeadLetterStderr:]
+		}
+		params.Stderr = &stderr
+	}
+	if err := p.retainSource(ctx, job, source); err != nil {
+		log.Printf("Couldn't keep source of failed job %s: %v", job.ID, err)
+	} else {
+		params.SourceKey = job.SourceKey
+	}
+
+	if _, err := p.DB.CreateDeadLetter(params); err != nil {
+		log.Printf("Couldn't record dead letter for job %s: %v", job.ID, err)
+		return false
+	}
+	return params.SourceKey != nil
+}
+
+// sourceProbe returns the ffprobe JSON of a job's source, from the probe
+// cache or by probing it if it's still at hand, or nil if neither works.
+func (p *Processor) sourceProbe(job database.Job, source []byte) json.RawMessage {
+	if job.SourceSHA256 != "" {
+		raw, err := p.DB.GetProbe(job.SourceSHA256)
+		if err == nil && raw != nil {
+			return raw
+		}
+	}
+
+	var raw []byte
+	var err error
+	if source != nil {
+		raw, err = ProbeData(source)
+	} else {
+		input, done, ok := p.sourceInput(job)
+		if !ok {
+			return nil
+		}
+		defer done()
+		raw, err = ProbeFile(input)
+	}
+	if err != nil || !json.Valid(raw) {
+		log.Printf("Couldn't probe source of failed job %s: %v", job.ID, err)
+		return nil
+	}
+	return raw
+}
+
+// retainSource makes sure a failed job's source is in the staging area,
+// uploading it from memory or scratch space if it never was.
+func (p *Processor) retainSource(ctx context.Context, job *database.Job, source []byte) error {
+	if job.SourceKey != nil {
+		return nil
+	}
+	if source != nil {
+		return p.stageSource(ctx, job, bytes.NewReader(source))
+	}
+	_, err := p.remoteSource(ctx, job)
+	return err
+}
+
+// notify tells the Notifier how a job ended, even if it ended because ctx was cancelled.
+func (p *Processor) notify(ctx context.Context, job database.Job, jobErr error) {
+	if p.Notifier == nil {
+		return
+	}
+	p.Notifier.JobFinished(context.WithoutCancel(ctx), job, jobErr)
+}
+
+// localSource returns the job's uploaded source on this host for ffmpeg,
+// downloading it from its staging object if the upload landed elsewhere.
+// Downloads are saved to scratch space like uploads, encrypted if uploads
+// are, and removed with the job's other scratch files.
+func (p *Processor) localSource(ctx context.Context, job database.Job) (SourceFile, error) {
+	sourcePath, ok := p.sourcePath(job)
+	if !ok {
+		var err error
+		sourcePath, err = p.downloadSource(ctx, job)
+		if err != nil {
+			return SourceFile{}, err
+		}
+	}
+	input, done, err := p.Scratch.Plaintext(sourcePath)
+	if err != nil {
+		return SourceFile{}, err
+	}
+	return SourceFile{Path: sourcePath, Input: input, Done: done}, nil
+}
+
+// downloadSource downloads the job's staged upload to scratch space and
+// returns its path.
+func (p *Processor) downloadSource(ctx context.Context, job database.Job) (string, error) {
+	if job.SourceKey == nil {
+		return "", fmt.Errorf("uploaded file is no longer available: %w", os.ErrNotExist)
+	}
+	object, err := p.S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.S3Bucket),
+		Key:    job.SourceKey,
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't download staged upload: %w", err)
+	}
+	defer object.Body.Close()
+
+	// Write under a temporary name so a partial file is never mistaken for the source
+	partial, err := p.Scratch.CreateTempFile("download-*.partial")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(partial.Name())
+	defer partial.Close()
+
+	if _, err := io.Copy(partial, object.Body); err != nil {
+		return "", fmt.Errorf("couldn't write upload to disk: %w", err)
+	}
+	if err := partial.Close(); err != nil {
+		return "", err
+	}
+	downloadPath := filepath.Join(filepath.Dir(partial.Name()), downloadName(job))
+	if err := os.Rename(partial.Name(), downloadPath); err != nil {
+		return "", err
+	}
+
+	return downloadPath, nil
+}
+
+// remoteSource returns the bucket key of the job's source, staging the
+// local upload first if it was never staged.
+func (p *Processor) remoteSource(ctx context.Context, job *database.Job) (string, error) {
+	if job.SourceKey != nil {
+		return *job.SourceKey, nil
+	}
+
+	sourceFile, err := p.openSource(*job)
+	if err != nil {
+		return "", fmt.Errorf("uploaded file is no longer available: %w", err)
+	}
+	defer sourceFile.Close()
+
+	if err := p.stageSource(ctx, job, sourceFile); err != nil {
+		return "", err
+	}
+	return *job.SourceKey, nil
+}
+
+// stageSource uploads a job's source to its staging object and records it
+// as the job's SourceKey.
+func (p *Processor) stageSource(ctx context.Context, job *database.Job, body io.Reader) error {
+	sourceKey := path.Join(p.StagingPrefix, fmt.Sprintf("job-%s.mp4", job.ID))
+	_, err := p.S3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(p.S3Bucket),
+		Key:         aws.String(sourceKey),
+		Body:        body,
+		ContentType: aws.String(job.MediaType),
+	})
+	if err != nil {
+		return fmt.Errorf("error staging upload: %w", err)
+	}
+
+	job.SourceKey = &sourceKey
+	return p.DB.UpdateJob(*job)
+}
+
+// sourcePath returns the path of the job's uploaded source on this host,
+// if there's one: the upload itself, or else a copy downloaded earlier.
+// Either may be encrypted.
+func (p *Processor) sourcePath(job database.Job) (string, bool) {
+	if fileExists(&job.SourcePath) {
+		return job.SourcePath, true
+	}
+	return p.Scratch.Find(downloadName(job))
+}
+
+// sourceInput returns an input ffmpeg can read the job's uploaded source
+// from, if it's on this host, and done to call once ffmpeg has finished.
+func (p *Processor) sourceInput(job database.Job) (input string, done func(), ok bool) {
+	sourcePath, ok := p.sourcePath(job)
+	if !ok {
+		return "", nil, false
+	}
+	input, done, err := p.Scratch.Plaintext(sourcePath)
+	if err != nil {
+		log.Printf("Couldn't read source of job %s: %v", job.ID, err)
+		return "", nil, false
+	}
+	return input, done, true
+}
+
+// openSource opens the job's uploaded source in scratch space, decrypting
+// it as it's read if it was saved encrypted.
+func (p *Processor) openSource(job database.Job) (*ScratchFile, error) {
+	if !fileExists(&job.SourcePath) {
+		return nil, os.ErrNotExist
+	}
+	return p.Scratch.Open(job.SourcePath)
+}
+
+// downloadName is the file a job's staged upload is downloaded to, in
+// whichever scratch directory it was given.
+func downloadName(job database.Job) string {
+	return fmt.Sprintf("job-%s.mp4", job.ID)
+}
+
+// cleanup removes a job's scratch files and staging object.
+func (p *Processor) cleanup(ctx context.Context, job database.Job) {
+	os.Remove(job.SourcePath)
+	p.Scratch.Remove(downloadName(job))
+	if job.ProcessedPath != nil {
+		os.RemoveAll(*job.ProcessedPath)
+	}
+
+	if job.SourceKey != nil {
+		_, err := p.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(p.S3Bucket),
+			Key:    job.SourceKey,
+		})
+		if err != nil {
+			log.Printf("Couldn't delete staged upload %s: %v", *job.SourceKey, err)
+		}
+	}
+}
+
+// discard cleans up after a cancelled job: its scratch files and staging
+// object, and whatever of its output reached the bucket, unless the video
+// already points at it.
+func (p *Processor) discard(ctx context.Context, job database.Job) {
+	ctx = context.WithoutCancel(ctx)
+	p.cleanup(ctx, job)
+	p.settleVideo(job, database.VideoStateDraft)
+	if job.ObjectKey == "" {
+		return
+	}
+
+	video, err := p.DB.GetVideo(job.VideoID)
+	if err != nil {
+		log.Printf("Couldn't check output of cancelled job %s: %v", job.ID, err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		return
+	}
+	storage, err := VideoStorage(p.DB, video, p.defaultStorage())
+	if err != nil {
+		log.Printf("Couldn't find output of cancelled job %s: %v", job.ID, err)
+		return
+	}
+	if video.VideoURL != nil && *video.VideoURL == storage.URL(job.ObjectKey) {
+		return
+	}
+	client, err := p.client(storage.Bucket)
+	if err != nil {
+		log.Printf("Couldn't remove output of cancelled job %s: %v", job.ID, err)
+		return
+	}
+
+	// HLS output is a playlist plus renditions under one prefix
+	keys := []string{job.ObjectKey}
+	if FormatForKey(job.ObjectKey) == FormatHLS {
+		keys = nil
+		paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(storage.Bucket),
+			Prefix: aws.String(path.Dir(job.ObjectKey) + "/"),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				log.Printf("Couldn't list output of cancelled job %s: %v", job.ID, err)
+				return
+			}
+			for _, object := range page.Contents {
+				keys = append(keys, aws.ToString(object.Key))
+			}
+		}
+	}
+	for _, key := range keys {
+		_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(storage.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			log.Printf("Couldn't delete %s of cancelled job %s: %v", key, job.ID, err)
+			continue
+		}
+		if err := p.DB.MarkStoredObjectDeleted(storage.Bucket, key); err != nil {
+			log.Printf("Couldn't record deletion of %s: %v", key, err)
+		}
+	}
+}
+
+// settleVideo moves a job's video to state once the job is over. Videos
+// that can't go there, such as ones with an older file still to play, keep
+// the state they're in.
+func (p *Processor) settleVideo(job database.Job, state database.VideoState) {
+	err := p.DB.TransitionVideo(job.VideoID, state)
+	if err != nil && !errors.Is(err, database.ErrInvalidVideoTransition) {
+		log.Printf("Couldn't mark video %s as %s: %v", job.VideoID, state, err)
+	}
+}
+
+// fileExists reports whether an optional path points at an existing file.
+func fileExists(path *string) bool {
+	if path == nil {
+		return false
+	}
+	_, err := os.Stat(*path)
+	return err == nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package processing
+
+import "os/exec"
+
+// killProcessGroupOnCancel leaves cmd's context to kill only cmd itself, as
+// process groups aren't available on this platform.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
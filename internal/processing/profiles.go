@@ -0,0 +1,213 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+)
+
+// Output formats a processing profile can produce
+const (
+	FormatMP4 = "mp4"
+	FormatM4A = "m4a"
+	FormatHLS = "hls"
+)
+
+// Names of the built-in processing profiles
+const (
+	ProfilePassthrough   = "passthrough"
+	ProfileWebOptimized  = "web-optimized"
+	ProfileHLSRenditions = "hls+renditions"
+	ProfileAudioOnly     = "audio-only"
+)
+
+// HLSPlaylistName is the master playlist of an HLS output, which the
+// video's URL points at. Renditions sit in numbered directories beside it.
+const HLSPlaylistName = "master.m3u8"
+
+// Length of HLS segments, in seconds
+const hlsSegmentSeconds = 6
+
+// Profile is a named way of processing uploads. Its Args are the ffmpeg
+// output options, placed between the inputs and the output path.
+type Profile struct {
+	Name   string   `json:"name"`
+	Format string   `json:"format"`
+	Args   []string `json:"args"`
+}
+
+// ContentType returns the media type of the profile's output.
+func (p Profile) ContentType() string {
+	switch p.Format {
+	case FormatM4A:
+		return "audio/mp4"
+	case FormatHLS:
+		return "application/vnd.apple.mpegurl"
+	default:
+		return "video/mp4"
+	}
+}
+
+// ObjectName returns the name of the object a video's URL points at for an
+// output named id. HLS outputs are a directory of objects, named by their
+// master playlist.
+func (p Profile) ObjectName(id string) string {
+	switch p.Format {
+	case FormatM4A:
+		return id + ".m4a"
+	case FormatHLS:
+		return path.Join(id, HLSPlaylistName)
+	default:
+		return id + ".mp4"
+	}
+}
+
+// EmbedsChapters reports whether the profile's output can carry chapter markers.
+func (p Profile) EmbedsChapters() bool {
+	return p.Format != FormatHLS
+}
+
+func (p Profile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("profile has no name")
+	}
+	switch p.Format {
+	case FormatMP4, FormatM4A:
+	case FormatHLS:
+		// Each rendition is written to its own directory, named by ffmpeg's %v
+		if !slices.Contains(p.Args, "-var_stream_map") {
+			return fmt.Errorf("profile %q: hls profiles must set -var_stream_map", p.Name)
+		}
+	default:
+		return fmt.Errorf("profile %q: unknown format %q", p.Name, p.Format)
+	}
+	return nil
+}
+
+// FormatForKey returns the format of the output stored at an object key or
+// URL. HLS outputs are stored under the prefix of their master playlist.
+func FormatForKey(key string) string {
+	switch {
+	case path.Base(key) == HLSPlaylistName:
+		return FormatHLS
+	case path.Ext(key) == ".m4a":
+		return FormatM4A
+	default:
+		return FormatMP4
+	}
+}
+
+// Profiles are the processing profiles available to uploads, by name.
+type Profiles struct {
+	byName      map[string]Profile
+	defaultName string
+}
+
+// DefaultProfiles returns the built-in profiles, defaulting to a
+// fast-start remux that leaves the streams untouched.
+func DefaultProfiles() Profiles {
+	profiles := Profiles{byName: map[string]Profile{}, defaultName: ProfilePassthrough}
+	for _, profile := range []Profile{
+		{
+			Name:   ProfilePassthrough,
+			Format: FormatMP4,
+			Args:   []string{"-movflags", "faststart", "-codec", "copy"},
+		},
+		{
+			Name:   ProfileWebOptimized,
+			Format: FormatMP4,
+			Args: []string{
+				"-c:v", "libx264", "-preset", "medium", "-crf", "23", "-pix_fmt", "yuv420p",
+				"-vf", "scale='min(1920,iw)':-2",
+				"-c:a", "aac", "-b:a", "128k",
+				"-movflags", "faststart",
+			},
+		},
+		{
+			Name:   ProfileHLSRenditions,
+			Format: FormatHLS,
+			Args: []string{
+				"-filter_complex", "[0:v]split=3[v0][v1][v2];[v0]scale=-2:1080[v0out];[v1]scale=-2:720[v1out];[v2]scale=-2:480[v2out]",
+				"-map", "[v0out]", "-map", "[v1out]", "-map", "[v2out]",
+				"-map", "0:a:0", "-map", "0:a:0", "-map", "0:a:0",
+				"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+				"-b:v:0", "5000k", "-b:v:1", "2800k", "-b:v:2", "1400k",
+				"-c:a", "aac", "-b:a", "128k",
+				"-var_stream_map", "v:0,a:0 v:1,a:1 v:2,a:2",
+			},
+		},
+		{
+			Name:   ProfileAudioOnly,
+			Format: FormatM4A,
+			Args:   []string{"-vn", "-c:a", "aac", "-b:a", "128k", "-movflags", "faststart"},
+		},
+	} {
+		profiles.byName[profile.Name] = profile
+	}
+	return profiles
+}
+
+// LoadProfiles returns the built-in profiles plus any defined in the JSON
+// file at filePath, which replace built-ins of the same name. defaultName
+// picks the profile used when an upload doesn't ask for one. Profile options
+// are checked against the flag allowlist of the commands in use, so call
+// UseCommands first.
+func LoadProfiles(filePath, defaultName string) (Profiles, error) {
+	profiles := DefaultProfiles()
+
+	if filePath != "" {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return Profiles{}, err
+		}
+		var custom []Profile
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return Profiles{}, fmt.Errorf("couldn't parse %s: %w", filePath, err)
+		}
+		for _, profile := range custom {
+			if err := profile.validate(); err != nil {
+				return Profiles{}, err
+			}
+			if err := activeCommands.CheckArgs(profile.Args); err != nil {
+				return Profiles{}, fmt.Errorf("profile %q: %w", profile.Name, err)
+			}
+			profiles.byName[profile.Name] = profile
+		}
+	}
+
+	if defaultName != "" {
+		if _, ok := profiles.byName[defaultName]; !ok {
+			return Profiles{}, fmt.Errorf("default profile %q is not defined", defaultName)
+		}
+		profiles.defaultName = defaultName
+	}
+	return profiles, nil
+}
+
+// Get returns the named profile, or the default profile if name is empty.
+func (p Profiles) Get(name string) (Profile, bool) {
+	if p.byName == nil {
+		p = DefaultProfiles()
+	}
+	if name == "" {
+		name = p.defaultName
+	}
+	profile, ok := p.byName[name]
+	return profile, ok
+}
+
+// Names lists the available profiles alphabetically.
+func (p Profiles) Names() []string {
+	if p.byName == nil {
+		p = DefaultProfiles()
+	}
+	names := make([]string, 0, len(p.byName))
+	for name := range p.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
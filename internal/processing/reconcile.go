@@ -0,0 +1,524 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Where a reconciliation reads a bucket's objects from
+const (
+	ReconcileSourceList      = "list"
+	ReconcileSourceInventory = "inventory"
+)
+
+// Repairs a reconciliation can make to what it finds
+const (
+	// RepairDeleteOrphaned deletes objects nothing in the database refers to
+	RepairDeleteOrphaned = "delete_orphaned"
+	// RepairForgetMissing marks ledger entries of objects that are gone as deleted
+	RepairForgetMissing = "forget_missing"
+	// RepairRecordUnrecorded adds videos' files the ledger is missing to it
+	RepairRecordUnrecorded = "record_unrecorded"
+)
+
+// ErrReconcileRunning is returned when a reconciliation is asked for while
+// another is still going.
+var ErrReconcileRunning = errors.New("a reconciliation is already running")
+
+// ParseRepairs reads a comma-separated list of repairs.
+func ParseRepairs(value string) ([]string, error) {
+	repairs := []string{}
+	for _, repair := range strings.Split(value, ",") {
+		repair = strings.TrimSpace(repair)
+		switch repair {
+		case "":
+			continue
+		case RepairDeleteOrphaned, RepairForgetMissing, RepairRecordUnrecorded:
+		default:
+			return nil, fmt.Errorf("unknown repair %q", repair)
+		}
+		if !slices.Contains(repairs, repair) {
+			repairs = append(repairs, repair)
+		}
+	}
+	return repairs, nil
+}
+
+// Reconciler compares the objects in the buckets videos are stored in with
+// what the database says should be there: the storage ledger and every
+// video's file. It reports objects nothing refers to, records of objects
+// that are gone, and videos' files the ledger doesn't know about, and can
+// repair each of them.
+//
+// Users' own buckets aren't reconciled, since they can hold files this
+// service didn't write.
+type Reconciler struct {
+	DB       database.Client
+	S3Client *s3.Client
+	// Buckets resolves the client for tenants' buckets; without it every
+	// bucket uses S3Client
+	Buckets  *BucketClients
+	Defaults Storage
+	// Inventory, when set, is read for the default bucket instead of listing it
+	Inventory *InventoryLocation
+	// Exclude are key prefixes other code looks after, such as staged uploads
+	Exclude []string
+	// MinAge keeps objects written since a reconciliation started, less
+	// MinAge, from being reported as orphaned, since the records for them
+	// may not have been written yet
+	MinAge time.Duration
+	// MaxFindings caps how many objects of each kind a report lists
+	MaxFindings int
+	// Interval is how often Run reconciles, with Repairs
+	Interval time.Duration
+	Repairs  []string
+
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *ReconcileReport
+}
+
+// ReconcileOptions pick how a single reconciliation runs.
+type ReconcileOptions struct {
+	// Source is ReconcileSourceList to list the default bucket even when it
+	// has an inventory; other buckets are always listed
+	Source  string
+	Repairs []string
+	// DryRun checks what each repair would do without making it
+	DryRun bool
+}
+
+// ReconcileReport is the outcome of a reconciliation.
+type ReconcileReport struct {
+	StartedAt  time.Time              `json:"started_at"`
+	FinishedAt time.Time              `json:"finished_at"`
+	Repairs    []string               `json:"repairs"`
+	DryRun     bool                   `json:"dry_run"`
+	Buckets    []BucketReconciliation `json:"buckets"`
+}
+
+// BucketReconciliation is what was found in one bucket.
+type BucketReconciliation struct {
+	Bucket string `json:"bucket"`
+	Source string `json:"source"`
+	// ListedAt is when the bucket was listed or its inventory taken
+	ListedAt   time.Time         `json:"listed_at"`
+	Objects    int               `json:"objects"`
+	Bytes      int64             `json:"bytes"`
+	Orphaned   ReconcileFindings `json:"orphaned"`
+	Missing    ReconcileFindings `json:"missing"`
+	Unrecorded ReconcileFindings `json:"unrecorded"`
+	// Error is why the bucket couldn't be reconciled, if it couldn't
+	Error string `json:"error,omitempty"`
+}
+
+// ReconcileFindings are the objects of one kind a reconciliation found.
+type ReconcileFindings struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+	// Repaired counts the objects repaired, or that would be in a dry run
+	Repaired int `json:"repaired"`
+	// Objects lists the first of them
+	Objects []ReconcileObject `json:"objects"`
+}
+
+// ReconcileObject is an object a reconciliation found a problem with.
+type ReconcileObject struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+	// LastModified is null for objects that are missing
+	LastModified *time.Time `json:"last_modified"`
+	// UserID, VideoID and Kind are what the database records for the
+	// object, empty for orphaned ones
+	UserID   *uuid.UUID `json:"user_id,omitempty"`
+	VideoID  *uuid.UUID `json:"video_id,omitempty"`
+	Kind     string     `json:"kind,omitempty"`
+	Repaired bool       `json:"repaired"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// reconcileRecord is something the database says is stored under a key.
+type reconcileRecord struct {
+	userID    uuid.UUID
+	videoID   uuid.NullUUID
+	kind      string
+	bytes     int64
+	createdAt time.Time
+	// inLedger is false for videos' files the ledger has no entry for
+	inLedger bool
+	seen     bool
+}
+
+// Run reconciles once every Interval until ctx is cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := r.Reconcile(ctx, ReconcileOptions{Repairs: r.Repairs})
+		if err != nil && !errors.Is(err, ErrReconcileRunning) {
+			log.Printf("Reconciliation failed: %v", err)
+		}
+	}
+}
+
+// LastReport returns the report of the latest reconciliation, or nil if
+// none has run since startup.
+func (r *Reconciler) LastReport() *ReconcileReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// Reconcile reconciles every bucket videos are stored in, making the
+// repairs asked for. Buckets that can't be read are reported with their
+// error rather than failing the whole reconciliation.
+func (r *Reconciler) Reconcile(ctx context.Context, opts ReconcileOptions) (ReconcileReport, error) {
+	if !r.running.TryLock() {
+		return ReconcileReport{}, ErrReconcileRunning
+	}
+	defer r.running.Unlock()
+
+	report := ReconcileReport{
+		StartedAt: time.Now().UTC(),
+		Repairs:   opts.Repairs,
+		DryRun:    opts.DryRun,
+		Buckets:   []BucketReconciliation{},
+	}
+	if report.Repairs == nil {
+		report.Repairs = []string{}
+	}
+	buckets, records, err := r.records()
+	if err != nil {
+		return report, err
+	}
+
+	for _, bucket := range buckets {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		source := ReconcileSourceList
+		if r.Inventory != nil && bucket == r.Defaults.Bucket && opts.Source != ReconcileSourceList {
+			source = ReconcileSourceInventory
+		}
+		result := r.reconcileBucket(ctx, bucket, source, records[bucket], opts)
+		if result.Error != "" {
+			log.Printf("Couldn't reconcile bucket %s: %s", bucket, result.Error)
+		}
+		report.Buckets = append(report.Buckets, result)
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	r.mu.Lock()
+	r.last = &report
+	r.mu.Unlock()
+	return report, nil
+}
+
+// records returns the buckets to reconcile, the deployment's and tenants',
+// and what the database says is in each.
+func (r *Reconciler) records() ([]string, map[string]map[string]*reconcileRecord, error) {
+	buckets := []string{r.Defaults.Bucket}
+	tenants, err := r.DB.GetTenants()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tenant := range tenants {
+		if tenant.Bucket != nil && !slices.Contains(buckets, *tenant.Bucket) {
+			buckets = append(buckets, *tenant.Bucket)
+		}
+	}
+
+	records := map[string]map[string]*reconcileRecord{}
+	for _, bucket := range buckets {
+		records[bucket] = map[string]*reconcileRecord{}
+	}
+	objects, err := r.DB.GetStoredObjects(uuid.Nil, time.Now().UTC())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, object := range objects {
+		if object.DeletedAt != nil || records[object.Bucket] == nil {
+			continue
+		}
+		records[object.Bucket][object.Key] = &reconcileRecord{
+			userID:    object.UserID,
+			videoID:   object.VideoID,
+			kind:      object.Kind,
+			bytes:     object.Bytes,
+			createdAt: object.CreatedAt,
+			inLedger:  true,
+		}
+	}
+
+	videos, err := r.DB.GetUploadedVideos()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, video := range videos {
+		storage, key, err := VideoLocation(r.DB, video, r.Defaults)
+		if err != nil || records[storage.Bucket] == nil || records[storage.Bucket][key] != nil {
+			continue
+		}
+		records[storage.Bucket][key] = &reconcileRecord{
+			userID:    video.UserID,
+			videoID:   uuid.NullUUID{UUID: video.ID, Valid: true},
+			kind:      database.StorageKindVideo,
+			createdAt: video.UpdatedAt,
+		}
+	}
+	return buckets, records, nil
+}
+
+// reconcileBucket compares one bucket's objects with its records.
+func (r *Reconciler) reconcileBucket(ctx context.Context, bucket, source string, records map[string]*reconcileRecord, opts ReconcileOptions) BucketReconciliation {
+	result := BucketReconciliation{
+		Bucket:     bucket,
+		Source:     source,
+		Orphaned:   ReconcileFindings{Objects: []ReconcileObject{}},
+		Missing:    ReconcileFindings{Objects: []ReconcileObject{}},
+		Unrecorded: ReconcileFindings{Objects: []ReconcileObject{}},
+	}
+	client, err := r.client(bucket)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// Everything under an HLS output's folder belongs to its master playlist
+	hlsFolders := map[string]bool{}
+	for key := range records {
+		if FormatForKey(key) == FormatHLS {
+			hlsFolders[path.Dir(key)] = true
+		}
+	}
+	exclude := r.Exclude
+	if r.Inventory != nil && r.Inventory.Bucket == bucket {
+		exclude = append(slices.Clone(exclude), strings.TrimSuffix(r.Inventory.Prefix, "/")+"/")
+	}
+
+	orphaned := []ListedObject{}
+	unrecorded := []ListedObject{}
+	visit := func(object ListedObject) error {
+		for _, prefix := range exclude {
+			if strings.HasPrefix(object.Key, prefix) {
+				return nil
+			}
+		}
+		result.Objects++
+		result.Bytes += object.Size
+
+		if record, ok := records[object.Key]; ok {
+			record.seen = true
+			if !record.inLedger {
+				unrecorded = append(unrecorded, object)
+			}
+			return nil
+		}
+		for dir := path.Dir(object.Key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if hlsFolders[dir] {
+				return nil
+			}
+		}
+		orphaned = append(orphaned, object)
+		return nil
+	}
+
+	switch source {
+	case ReconcileSourceInventory:
+		result.ListedAt, err = ReadInventory(ctx, client, *r.Inventory, bucket, visit)
+	default:
+		result.ListedAt, err = ListBucket(ctx, client, bucket, visit)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Orphaned = r.orphaned(ctx, client, bucket, orphaned, result.ListedAt, slices.Contains(opts.Repairs, RepairDeleteOrphaned), opts.DryRun)
+	result.Unrecorded = r.unrecorded(bucket, records, unrecorded, slices.Contains(opts.Repairs, RepairRecordUnrecorded), opts.DryRun)
+	result.Missing = r.missing(ctx, client, bucket, records, result.ListedAt, slices.Contains(opts.Repairs, RepairForgetMissing), opts.DryRun)
+	return result
+}
+
+// orphaned reports objects nothing refers to, deleting them if repair is set.
+func (r *Reconciler) orphaned(ctx context.Context, client *s3.Client, bucket string, objects []ListedObject, listedAt time.Time, repair, dryRun bool) ReconcileFindings {
+	findings := ReconcileFindings{Objects: []ReconcileObject{}}
+	cutoff := listedAt.Add(-r.MinAge)
+	for _, object := range objects {
+		if !object.LastModified.Before(cutoff) {
+			continue
+		}
+		found := ReconcileObject{Key: object.Key, Bytes: object.Size, LastModified: &object.LastModified}
+		findings.Count++
+		findings.Bytes += object.Size
+
+		if repair {
+			err := r.deleteOrphan(ctx, client, bucket, object.Key, dryRun)
+			if err != nil {
+				found.Error = err.Error()
+				log.Printf("Couldn't delete orphaned object %s/%s: %v", bucket, object.Key, err)
+			} else {
+				found.Repaired = true
+				findings.Repaired++
+			}
+		}
+		if len(findings.Objects) < r.MaxFindings {
+			findings.Objects = append(findings.Objects, found)
+		}
+	}
+	return findings
+}
+
+// deleteOrphan deletes an orphaned object, unless the ledger has gained an
+// entry for it since the records were read.
+func (r *Reconciler) deleteOrphan(ctx context.Context, client *s3.Client, bucket, key string, dryRun bool) error {
+	object, err := r.DB.GetStoredObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	if object != nil {
+		return errors.New("object was recorded during the reconciliation")
+	}
+	if dryRun {
+		return nil
+	}
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// unrecorded reports videos' files the ledger has no entry for, adding them
+// at their listed size if repair is set.
+func (r *Reconciler) unrecorded(bucket string, records map[string]*reconcileRecord, objects []ListedObject, repair, dryRun bool) ReconcileFindings {
+	findings := ReconcileFindings{Objects: []ReconcileObject{}}
+	for _, object := range objects {
+		record := records[object.Key]
+		found := newReconcileObject(object.Key, record)
+		found.Bytes = object.Size
+		found.LastModified = &object.LastModified
+		findings.Count++
+		findings.Bytes += object.Size
+
+		if repair && dryRun {
+			found.Repaired = true
+			findings.Repaired++
+		} else if repair {
+			err := r.DB.RecordStoredObject(database.RecordStoredObjectParams{
+				UserID:  record.userID,
+				VideoID: record.videoID,
+				Kind:    record.kind,
+				Bucket:  bucket,
+				Key:     object.Key,
+				Bytes:   object.Size,
+			})
+			if err != nil {
+				found.Error = err.Error()
+				log.Printf("Couldn't record %s/%s in the ledger: %v", bucket, object.Key, err)
+			} else {
+				found.Repaired = true
+				findings.Repaired++
+			}
+		}
+		if len(findings.Objects) < r.MaxFindings {
+			findings.Objects = append(findings.Objects, found)
+		}
+	}
+	return findings
+}
+
+// missing reports records of objects the listing didn't have. Records made
+// after the listing was taken are left out, as the listing couldn't have
+// them. With repair set, ledger entries of objects that really are gone are
+// marked deleted; an inventory can be a day old, so each is checked first.
+func (r *Reconciler) missing(ctx context.Context, client *s3.Client, bucket string, records map[string]*reconcileRecord, listedAt time.Time, repair, dryRun bool) ReconcileFindings {
+	findings := ReconcileFindings{Objects: []ReconcileObject{}}
+	keys := []string{}
+	for key, record := range records {
+		if !record.seen && record.createdAt.Before(listedAt) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		record := records[key]
+		found := newReconcileObject(key, record)
+		findings.Count++
+		findings.Bytes += record.bytes
+
+		if repair && record.inLedger {
+			err := r.forgetMissing(ctx, client, bucket, key, dryRun)
+			if err != nil {
+				found.Error = err.Error()
+			} else {
+				found.Repaired = true
+				findings.Repaired++
+			}
+		}
+		if len(findings.Objects) < r.MaxFindings {
+			findings.Objects = append(findings.Objects, found)
+		}
+	}
+	return findings
+}
+
+// forgetMissing marks an object's ledger entry deleted once the bucket
+// confirms it's gone.
+func (r *Reconciler) forgetMissing(ctx context.Context, client *s3.Client, bucket, key string, dryRun bool) error {
+	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if err == nil {
+		return errors.New("object exists")
+	}
+	if !errors.As(err, &notFound) {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	return r.DB.MarkStoredObjectDeleted(bucket, key)
+}
+
+// client returns the S3 client for a bucket.
+func (r *Reconciler) client(bucket string) (*s3.Client, error) {
+	if r.Buckets == nil {
+		return r.S3Client, nil
+	}
+	return r.Buckets.Client(bucket)
+}
+
+func newReconcileObject(key string, record *reconcileRecord) ReconcileObject {
+	found := ReconcileObject{Key: key, Bytes: record.bytes, Kind: record.kind}
+	userID := record.userID
+	found.UserID = &userID
+	if record.videoID.Valid {
+		videoID := record.videoID.UUID
+		found.VideoID = &videoID
+	}
+	return found
+}
@@ -0,0 +1,72 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// RetryPolicy is how often, and how soon, a job whose worker stopped
+// responding is tried again.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a job is started before it fails,
+	// MaxJobAttempts when zero
+	MaxAttempts int
+	// Backoff is the wait before a job's first retry, doubling for each
+	// attempt after that up to MaxBackoff. Zero retries right away.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (r RetryPolicy) maxAttempts() int {
+	if r.MaxAttempts <= 0 {
+		return MaxJobAttempts
+	}
+	return r.MaxAttempts
+}
+
+// delay returns how long to wait before starting a job that has been
+// started attempts times.
+func (r RetryPolicy) delay(attempts int) time.Duration {
+	delay := r.Backoff
+	for i := 1; i < attempts && delay > 0 && (r.MaxBackoff <= 0 || delay < r.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+	return delay
+}
+
+// RetryStaleJobs finds running jobs whose worker hasn't sent a heartbeat
+// within staleAfter, presumably because it crashed. Each is put back on the
+// queue to be retried after a backoff, or failed, telling its owner, once it
+// has used up its attempts. It returns how many jobs were requeued and failed.
+func (p *Processor) RetryStaleJobs(ctx context.Context, staleAfter time.Duration) (int, int, error) {
+	jobs, err := p.DB.GetStaleJobs(staleAfter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	requeued, failed := 0, 0
+	for _, job := range jobs {
+		if job.Attempts >= p.Retry.maxAttempts() {
+			p.fail(ctx, job, nil, fmt.Errorf("job gave up after %d attempts, the last worker stopped responding", job.Attempts))
+			failed++
+			continue
+		}
+
+		delay := p.Retry.delay(job.Attempts)
+		reason := fmt.Sprintf("worker stopped responding during attempt %d", job.Attempts)
+		ok, err := p.DB.RequeueStaleJob(job.ID, staleAfter, delay, reason)
+		if err != nil {
+			return requeued, failed, err
+		}
+		if ok {
+			log.Printf("Job %s for video %s will be retried in %s: %s", job.ID, job.VideoID, delay, reason)
+			requeued++
+		}
+	}
+	return requeued, failed, nil
+}
@@ -0,0 +1,122 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Ways of choosing which scratch directory a new file goes in
+const (
+	ScratchRoundRobin = "round-robin"
+	ScratchFreeSpace  = "free-space"
+)
+
+// Scratch spreads temporary and processing files across one or more
+// directories, so a host with several local disks can work on more uploads
+// at once without them contending for the same disk.
+type Scratch struct {
+	dirs   []string
+	policy string
+	next   atomic.Uint64
+	// cipher encrypts files made with CreateTempFile, if set
+	cipher *scratchCipher
+}
+
+// NewScratch creates any of dirs that don't exist yet and returns a Scratch
+// choosing between them by policy.
+func NewScratch(dirs []string, policy string) (*Scratch, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("at least one scratch directory is required")
+	}
+	switch policy {
+	case "", ScratchRoundRobin:
+		policy = ScratchRoundRobin
+	case ScratchFreeSpace:
+	default:
+		return nil, fmt.Errorf("unknown scratch policy %q", policy)
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("couldn't create scratch directory %s: %w", dir, err)
+		}
+	}
+
+	return &Scratch{dirs: dirs, policy: policy}, nil
+}
+
+// ParseScratchDirs splits a PATH-style list of directories, ignoring empty
+// entries.
+func ParseScratchDirs(list string) []string {
+	dirs := []string{}
+	for _, dir := range filepath.SplitList(list) {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Dirs returns every scratch directory.
+func (s *Scratch) Dirs() []string {
+	return s.dirs
+}
+
+// Dir returns the directory the next file should be written to.
+func (s *Scratch) Dir() string {
+	if len(s.dirs) == 1 {
+		return s.dirs[0]
+	}
+
+	if s.policy == ScratchFreeSpace {
+		best, bestFree := "", uint64(0)
+		for _, dir := range s.dirs {
+			free, _, err := diskSpace(dir)
+			if err != nil {
+				continue
+			}
+			if best == "" || free > bestFree {
+				best, bestFree = dir, free
+			}
+		}
+		if best != "" {
+			return best
+		}
+		// Free space is unavailable on this platform, take turns instead
+	}
+
+	i := s.next.Add(1) - 1
+	return s.dirs[i%uint64(len(s.dirs))]
+}
+
+// Path returns a path for name in the next scratch directory.
+func (s *Scratch) Path(name string) string {
+	return filepath.Join(s.Dir(), name)
+}
+
+// CreateTemp creates a new temporary file in the next scratch directory.
+func (s *Scratch) CreateTemp(pattern string) (*os.File, error) {
+	return os.CreateTemp(s.Dir(), pattern)
+}
+
+// Find returns the path of name in whichever scratch directory holds it.
+func (s *Scratch) Find(name string) (string, bool) {
+	for _, dir := range s.dirs {
+		filePath := filepath.Join(dir, name)
+		if _, err := os.Stat(filePath); err == nil {
+			return filePath, true
+		}
+	}
+	return "", false
+}
+
+// Remove deletes name from every scratch directory.
+func (s *Scratch) Remove(name string) {
+	for _, dir := range s.dirs {
+		os.Remove(filepath.Join(dir, name))
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package processing
+
+import "errors"
+
+// diskSpace isn't supported on this platform, so the free-space policy falls
+// back to round-robin and disk usage reports no filesystem sizes.
+func diskSpace(dir string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk space is not available on this platform")
+}
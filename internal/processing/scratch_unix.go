@@ -0,0 +1,15 @@
+//go:build unix
+
+package processing
+
+import "syscall"
+
+// diskSpace returns the bytes available to unprivileged users and the total
+// size of the filesystem holding dir.
+func diskSpace(dir string) (free, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
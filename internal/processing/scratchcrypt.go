@@ -0,0 +1,353 @@
+package processing
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrScratchKeyLost is returned when opening a scratch file encrypted by
+// another process, such as before a restart. Its key is gone, so nothing
+// can read it any more.
+var ErrScratchKeyLost = errors.New("scratch file was encrypted with another process's key")
+
+// ErrScratchRewrite is returned when changing bytes already written to an
+// encrypted scratch file, which would encrypt them with the same keystream
+// as the bytes they replace.
+var ErrScratchRewrite = errors.New("encrypted scratch file can't be rewritten with other bytes")
+
+// Encrypted scratch files start with this, then the ID of the key and the
+// file's IV. No video starts with it, so files written before encryption
+// was turned on are still read as plaintext.
+var scratchMagic = []byte("TBLYENC1")
+
+const (
+	scratchKeyIDSize  = 8
+	scratchHeaderSize = 8 + scratchKeyIDSize + aes.BlockSize
+)
+
+// scratchCipher is the AES-256 key scratch files are encrypted with. It's
+// generated at startup and only ever held in memory, so a file copied off a
+// shared disk can't be read even by someone who can read the process's
+// environment or configuration.
+type scratchCipher struct {
+	block cipher.Block
+	id    [scratchKeyIDSize]byte
+}
+
+func newScratchCipher() (*scratchCipher, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	c := &scratchCipher{block: block}
+	if _, err := rand.Read(c.id[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// EnableEncryption encrypts files made with CreateTempFile from now on,
+// with AES-CTR under a key that lives only in this process's memory. Files
+// made with CreateTemp are left as plaintext, since they're for ffmpeg to
+// read or write.
+func (s *Scratch) EnableEncryption() error {
+	c, err := newScratchCipher()
+	if err != nil {
+		return fmt.Errorf("couldn't generate scratch key: %w", err)
+	}
+	s.cipher = c
+	return nil
+}
+
+// CreateTempFile creates a new temporary file in the next scratch
+// directory, encrypted if EnableEncryption has been called.
+func (s *Scratch) CreateTempFile(pattern string) (*ScratchFile, error) {
+	file, err := os.CreateTemp(s.Dir(), pattern)
+	if err != nil {
+		return nil, err
+	}
+	if s.cipher == nil {
+		return &ScratchFile{file: file}, nil
+	}
+
+	header := make([]byte, 0, scratchHeaderSize)
+	header = append(header, scratchMagic...)
+	header = append(header, s.cipher.id[:]...)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	header = append(header, iv...)
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &ScratchFile{file: file, cipher: s.cipher, iv: iv, offset: scratchHeaderSize}, nil
+}
+
+// OpenFile opens a file made with CreateTempFile for reading and writing,
+// decrypting it if it was encrypted. It returns ErrScratchKeyLost for files
+// encrypted by another process.
+func (s *Scratch) OpenFile(name string) (*ScratchFile, error) {
+	return s.openFile(name, os.O_RDWR)
+}
+
+// Open opens a file made with CreateTempFile, or any other file, for
+// reading, decrypting it if it was encrypted.
+func (s *Scratch) Open(name string) (*ScratchFile, error) {
+	return s.openFile(name, os.O_RDONLY)
+}
+
+func (s *Scratch) openFile(name string, flag int) (*ScratchFile, error) {
+	file, err := os.OpenFile(name, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, scratchHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		file.Close()
+		return nil, err
+	}
+	if n < scratchHeaderSize || !bytes.Equal(header[:len(scratchMagic)], scratchMagic) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &ScratchFile{file: file}, nil
+	}
+
+	id := header[len(scratchMagic) : len(scratchMagic)+scratchKeyIDSize]
+	if s.cipher == nil || !bytes.Equal(id, s.cipher.id[:]) {
+		file.Close()
+		return nil, ErrScratchKeyLost
+	}
+	iv := header[len(scratchMagic)+scratchKeyIDSize:]
+	return &ScratchFile{file: file, cipher: s.cipher, iv: iv, offset: scratchHeaderSize}, nil
+}
+
+// Plaintext returns an input ffmpeg can read the contents of name from.
+// Files that aren't encrypted are read where they are. Encrypted ones are
+// served decrypted from a server on the loopback interface, at a path
+// nobody else can guess, so no decrypted copy is ever written to disk.
+// ffmpeg seeks in them with range requests, which a pipe couldn't do for
+// files with their index at the end. done stops the server, so call it as
+// soon as ffmpeg has finished with the file.
+func (s *Scratch) Plaintext(name string) (input string, done func(), err error) {
+	file, err := s.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	encrypted := file.Encrypted()
+	file.Close()
+	if !encrypted {
+		return name, func() {}, nil
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", nil, err
+	}
+	urlPath := "/" + hex.EncodeToString(token) + filepath.Ext(name)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't serve %s to ffmpeg: %w", name, err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != urlPath {
+			http.NotFound(w, r)
+			return
+		}
+		// Each request gets its own file, since ffmpeg may read ranges of
+		// it over several connections
+		file, err := s.Open(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+		http.ServeContent(w, r, "", time.Time{}, file)
+	})}
+	go server.Serve(listener)
+	return "http://" + listener.Addr().String() + urlPath, func() { server.Close() }, nil
+}
+
+// ScratchFile is a file made with CreateTempFile. Offsets and sizes are of
+// the plaintext, whether or not it's encrypted on disk.
+//
+// Bytes written to an encrypted file can only be written again unchanged,
+// and it can't be shrunk: the keystream at each offset never changes, so
+// two different plaintexts encrypted with it would give away their XOR.
+type ScratchFile struct {
+	file   *os.File
+	cipher *scratchCipher
+	iv     []byte
+	// offset is the size of the header in front of the contents
+	offset int64
+}
+
+// Name returns the path of the file.
+func (f *ScratchFile) Name() string {
+	return f.file.Name()
+}
+
+func (f *ScratchFile) Read(p []byte) (int, error) {
+	if f.cipher == nil {
+		return f.file.Read(p)
+	}
+	at, err := f.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.file.Read(p)
+	f.xorKeyStream(p[:n], p[:n], at-f.offset)
+	return n, err
+}
+
+func (f *ScratchFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.file.ReadAt(p, off+f.offset)
+	f.xorKeyStream(p[:n], p[:n], off)
+	return n, err
+}
+
+func (f *ScratchFile) Write(p []byte) (int, error) {
+	if f.cipher == nil {
+		return f.file.Write(p)
+	}
+	at, err := f.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.checkRewrite(p, at-f.offset); err != nil {
+		return 0, err
+	}
+	return f.file.Write(f.encrypt(p, at-f.offset))
+}
+
+func (f *ScratchFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.checkRewrite(p, off); err != nil {
+		return 0, err
+	}
+	return f.file.WriteAt(f.encrypt(p, off), off+f.offset)
+}
+
+func (f *ScratchFile) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		offset += f.offset
+	}
+	at, err := f.file.Seek(offset, whence)
+	return at - f.offset, err
+}
+
+// Encrypted reports whether the file is encrypted on disk.
+func (f *ScratchFile) Encrypted() bool {
+	return f.cipher != nil
+}
+
+// Size returns the size of the contents.
+func (f *ScratchFile) Size() (int64, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size() - f.offset, nil
+}
+
+// Truncate changes the size of the contents. Encrypted files can only
+// grow, since what was cut off could be written again with other bytes.
+func (f *ScratchFile) Truncate(size int64) error {
+	if f.cipher != nil {
+		current, err := f.Size()
+		if err != nil {
+			return err
+		}
+		if size < current {
+			return ErrScratchRewrite
+		}
+	}
+	return f.file.Truncate(size + f.offset)
+}
+
+func (f *ScratchFile) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *ScratchFile) Close() error {
+	return f.file.Close()
+}
+
+// checkRewrite refuses writing p at off in an encrypted file if it would
+// change bytes already written there. The same bytes encrypt to the same
+// ciphertext, so writing them again, as a retried write does, is allowed.
+func (f *ScratchFile) checkRewrite(p []byte, off int64) error {
+	if f.cipher == nil {
+		return nil
+	}
+	size, err := f.Size()
+	if err != nil || off >= size {
+		return err
+	}
+	written := make([]byte, min(int64(len(p)), size-off))
+	if _, err := f.ReadAt(written, off); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(written, p[:len(written)]) {
+		return ErrScratchRewrite
+	}
+	return nil
+}
+
+// encrypt returns p encrypted for writing at off, leaving p as it was.
+func (f *ScratchFile) encrypt(p []byte, off int64) []byte {
+	if f.cipher == nil {
+		return p
+	}
+	out := make([]byte, len(p))
+	f.xorKeyStream(out, p, off)
+	return out
+}
+
+// xorKeyStream XORs src with the keystream from off into dst, which is all
+// encrypting or decrypting takes in CTR mode. Any offset can be read or
+// written directly, by starting the counter at the block holding it.
+func (f *ScratchFile) xorKeyStream(dst, src []byte, off int64) {
+	if f.cipher == nil || len(src) == 0 {
+		return
+	}
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, f.iv)
+	// Add the block number to the IV as a big-endian 128-bit number, the
+	// way the counter is incremented
+	carry := uint64(off / aes.BlockSize)
+	for i := aes.BlockSize - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + carry&0xff
+		counter[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+
+	stream := cipher.NewCTR(f.cipher.block, counter)
+	if skip := off % aes.BlockSize; skip > 0 {
+		discard := make([]byte, skip)
+		stream.XORKeyStream(discard, discard)
+	}
+	stream.XORKeyStream(dst, src)
+}
@@ -0,0 +1,64 @@
+package processing
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Storage is where a video is stored and served from.
+type Storage struct {
+	Bucket    string
+	BaseURL   string
+	KeyPrefix string
+}
+
+// Key returns the full object key for a key relative to the storage.
+func (s Storage) Key(key string) string {
+	if s.KeyPrefix == "" {
+		return key
+	}
+	return path.Join(s.KeyPrefix, key)
+}
+
+// URL returns the URL an object in the storage is served from.
+func (s Storage) URL(key string) string {
+	return fmt.Sprintf("%s/%s", s.BaseURL, key)
+}
+
+// ObjectKey returns the key of the object a URL from URL points at.
+func (s Storage) ObjectKey(url string) (string, error) {
+	prefix := s.BaseURL + "/"
+	if !strings.HasPrefix(url, prefix) {
+		return "", fmt.Errorf("video URL %q is not served from %s", url, s.BaseURL)
+	}
+	return strings.TrimPrefix(url, prefix), nil
+}
+
+// TenantStorage returns the storage for a tenant's videos, falling back to
+// defaults for anything the tenant doesn't override. Videos without a tenant
+// use the defaults.
+func TenantStorage(db database.Client, tenantID uuid.NullUUID, defaults Storage) (Storage, error) {
+	if !tenantID.Valid {
+		return defaults, nil
+	}
+
+	tenant, err := db.GetTenant(tenantID.UUID)
+	if err != nil {
+		return Storage{}, err
+	}
+	if tenant.ID == uuid.Nil {
+		return Storage{}, fmt.Errorf("tenant %s not found", tenantID.UUID)
+	}
+
+	storage := defaults
+	if tenant.Bucket != nil && tenant.BaseURL != nil {
+		storage.Bucket = *tenant.Bucket
+		storage.BaseURL = *tenant.BaseURL
+	}
+	storage.KeyPrefix = path.Join(defaults.KeyPrefix, tenant.KeyPrefix)
+	return storage, nil
+}
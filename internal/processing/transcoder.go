@@ -0,0 +1,195 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Transcoder backends a deployment can select
+const (
+	TranscoderFFmpeg       = "ffmpeg"
+	TranscoderMediaConvert = "mediaconvert"
+)
+
+// Transcoder turns a job's uploaded source into the file served to viewers.
+type Transcoder interface {
+	Name() string
+	Transcode(ctx context.Context, req TranscodeRequest) (TranscodeResult, error)
+}
+
+// TranscodeRequest describes a job to a Transcoder. Backends fetch the
+// source in whichever form they need: a path on this host, or an object in
+// the bucket.
+type TranscodeRequest struct {
+	Job      database.Job
+	Chapters []database.Chapter
+
+	// Profile is how the job asked for its upload to be processed. Only the
+	// ffmpeg backend honors it.
+	Profile Profile
+
+	// HLSKey is the AES-128 key to encrypt HLS segments with, nil to leave
+	// them in the clear. Only the ffmpeg backend honors it.
+	HLSKey []byte
+
+	// Bucket is where the job's object key is stored, for transcoders that
+	// write their output to the bucket themselves.
+	Bucket string
+
+	// Source is the whole upload, for small uploads kept in memory rather
+	// than written to scratch space. Only the ffmpeg backend accepts it,
+	// piping it through ffmpeg into StoreStream.
+	Source []byte
+
+	// LocalSource returns the source on this host, downloading it from
+	// staging if needed.
+	LocalSource func(ctx context.Context) (SourceFile, error)
+
+	// RemoteSource returns the bucket key of the source, staging it if needed.
+	RemoteSource func(ctx context.Context) (string, error)
+
+	// StoreStream uploads everything read from r to the job's object key,
+	// for transcoders that produce their output as a stream.
+	StoreStream func(ctx context.Context, r io.Reader) error
+
+	// SaveExternalID persists the ID of work submitted to an external
+	// service, so a resumed job can pick it up instead of resubmitting.
+	SaveExternalID func(id string) error
+}
+
+// SourceFile is a job's source on this host, as ffmpeg reads it.
+type SourceFile struct {
+	// Path is the file in scratch space, which output is written beside
+	Path string
+	// Input is what ffmpeg reads it from: Path, or a loopback URL serving
+	// it decrypted if it's encrypted on disk
+	Input string
+	// Done releases Input once ffmpeg has finished with it
+	Done func()
+}
+
+// TranscodeResult reports where a transcoder left its output.
+type TranscodeResult struct {
+	// ProcessedPath is a file on this host for the processor to upload to
+	// the job's object key.
+	ProcessedPath string
+
+	// Stored is set when the transcoder wrote the job's object key itself.
+	Stored bool
+
+	// Pending is set when the transcoder will report the outcome through a
+	// callback instead of waiting for it.
+	Pending bool
+}
+
+// Where the ffmpeg backend writes its output
+const (
+	FFmpegOutputFile = "file"
+	FFmpegOutputPipe = "pipe"
+)
+
+// FFmpegConfig holds the settings for the ffmpeg backend.
+type FFmpegConfig struct {
+	// Output is FFmpegOutputFile to write the processed file to scratch
+	// space before uploading it, or FFmpegOutputPipe to upload it as ffmpeg
+	// produces it.
+	Output string
+}
+
+// MediaConvertConfig holds the settings for the MediaConvert backend.
+type MediaConvertConfig struct {
+	Endpoint     string
+	RoleARN      string
+	Queue        string
+	Bucket       string
+	PollInterval time.Duration
+
+	// Callbacks leaves jobs waiting for a signed callback once submitted,
+	// instead of polling MediaConvert until they finish.
+	Callbacks bool
+}
+
+// NewTranscoder returns the named transcoder backend.
+func NewTranscoder(kind string, awsCfg aws.Config, ff FFmpegConfig, mc MediaConvertConfig) (Transcoder, error) {
+	switch kind {
+	case "", TranscoderFFmpeg:
+		switch ff.Output {
+		case "", FFmpegOutputFile:
+			return FFmpegTranscoder{}, nil
+		case FFmpegOutputPipe:
+			return FFmpegTranscoder{Pipe: true}, nil
+		default:
+			return nil, fmt.Errorf("unknown ffmpeg output %q", ff.Output)
+		}
+	case TranscoderMediaConvert:
+		return NewMediaConvertTranscoder(awsCfg, mc)
+	default:
+		return nil, fmt.Errorf("unknown transcoder %q", kind)
+	}
+}
+
+// FFmpegTranscoder runs the source through the job's processing profile
+// with a local ffmpeg binary.
+type FFmpegTranscoder struct {
+	// Pipe streams MP4 and M4A output straight into the bucket, so only the
+	// source takes up scratch space. HLS output is always written to disk.
+	Pipe bool
+}
+
+func (FFmpegTranscoder) Name() string {
+	return TranscoderFFmpeg
+}
+
+func (t FFmpegTranscoder) Transcode(ctx context.Context, req TranscodeRequest) (TranscodeResult, error) {
+	var source SourceFile
+	if req.Source == nil {
+		var err error
+		source, err = req.LocalSource(ctx)
+		if err != nil {
+			return TranscodeResult{}, err
+		}
+		defer source.Done()
+	}
+
+	// Write any chapter markers to a metadata file so they're embedded
+	metadataFilePath, err := WriteChapterMetadata(req.Chapters, req.Job.Duration)
+	if err != nil {
+		return TranscodeResult{}, fmt.Errorf("error preparing chapter metadata: %w", err)
+	}
+	if metadataFilePath != "" {
+		defer os.Remove(metadataFilePath)
+	}
+
+	if req.Source != nil {
+		err := StreamVideoData(ctx, req.Source, metadataFilePath, req.Profile, func(r io.Reader) error {
+			return req.StoreStream(ctx, r)
+		})
+		if err != nil {
+			return TranscodeResult{}, err
+		}
+		return TranscodeResult{Stored: true}, nil
+	}
+
+	if t.Pipe && req.Profile.Format != FormatHLS {
+		err := StreamVideo(ctx, source.Input, metadataFilePath, req.Profile, func(r io.Reader) error {
+			return req.StoreStream(ctx, r)
+		})
+		if err != nil {
+			return TranscodeResult{}, err
+		}
+		return TranscodeResult{Stored: true}, nil
+	}
+
+	processedFilePath, err := processVideoWithFallback(ctx, source.Input, source.Path, metadataFilePath, req.Profile, req.HLSKey)
+	if err != nil {
+		return TranscodeResult{}, err
+	}
+
+	return TranscodeResult{ProcessedPath: processedFilePath}, nil
+}
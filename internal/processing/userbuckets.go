@@ -0,0 +1,157 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// roleARNPattern matches an IAM role ARN, capturing the account that owns it.
+var roleARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::([0-9]{12}):role/[\w+=,.@/-]{1,64}$`)
+
+// userBucketSessionDuration is how long credentials for a user's role last.
+// It's the shortest AWS allows, since roles in other accounts may cap it.
+const userBucketSessionDuration = 15 * time.Minute
+
+// RoleAccountID returns the account that owns an IAM role, reporting false
+// if arn isn't a role ARN.
+func RoleAccountID(arn string) (string, bool) {
+	match := roleARNPattern.FindStringSubmatch(arn)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// UserBucketURL returns where objects in a user's bucket are served from.
+func UserBucketURL(bucket database.UserBucket) string {
+	if bucket.BaseURL != "" {
+		return bucket.BaseURL
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket.Bucket, bucket.Region)
+}
+
+// VideoStorage returns the storage for a video: its owner's own bucket when
+// they registered one, otherwise their tenant's.
+func VideoStorage(db database.Client, video database.Video, defaults Storage) (Storage, error) {
+	storage, err := TenantStorage(db, video.TenantID, defaults)
+	if err != nil {
+		return Storage{}, err
+	}
+
+	bucket, err := db.GetUserBucket(video.UserID)
+	if err != nil {
+		return Storage{}, err
+	}
+	if bucket.UserID == uuid.Nil {
+		return storage, nil
+	}
+	storage.Bucket = bucket.Bucket
+	storage.BaseURL = UserBucketURL(bucket)
+	return storage, nil
+}
+
+// VideoLocation returns the storage a video's file is in and its key there:
+// its owner's storage, or their tenant's for videos uploaded before they
+// registered their own bucket.
+func VideoLocation(db database.Client, video database.Video, defaults Storage) (Storage, string, error) {
+	if video.VideoURL == nil {
+		return Storage{}, "", errors.New("video has not been uploaded")
+	}
+	storage, err := VideoStorage(db, video, defaults)
+	if err != nil {
+		return Storage{}, "", err
+	}
+	if key, err := storage.ObjectKey(*video.VideoURL); err == nil {
+		return storage, key, nil
+	}
+	storage, err = TenantStorage(db, video.TenantID, defaults)
+	if err != nil {
+		return Storage{}, "", err
+	}
+	key, err := storage.ObjectKey(*video.VideoURL)
+	if err != nil {
+		return Storage{}, "", err
+	}
+	return storage, key, nil
+}
+
+// BucketClients hands out the S3 client to use for a bucket. Buckets users
+// registered as their own are reached with the role they granted this
+// service, so presigned URLs for them are signed by that role too; every
+// other bucket uses Default.
+type BucketClients struct {
+	DB        database.Client
+	AWSConfig aws.Config
+	Endpoint  string
+	Default   *s3.Client
+
+	mu      sync.Mutex
+	clients map[string]userBucketClient
+}
+
+// userBucketClient is a client for a user's bucket, kept until the
+// registration it was made from changes.
+type userBucketClient struct {
+	updatedAt time.Time
+	client    *s3.Client
+}
+
+// Client returns the client for bucket.
+func (b *BucketClients) Client(bucket string) (*s3.Client, error) {
+	registration, err := b.DB.GetUserBucketByName(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if registration.UserID == uuid.Nil {
+		return b.Default, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cached, ok := b.clients[bucket]; ok && cached.updatedAt.Equal(registration.UpdatedAt) {
+		return cached.client, nil
+	}
+	if b.clients == nil {
+		b.clients = map[string]userBucketClient{}
+	}
+	client := b.NewClient(registration)
+	b.clients[bucket] = userBucketClient{updatedAt: registration.UpdatedAt, client: client}
+	return client, nil
+}
+
+// NewClient returns a client acting as a user's role. The user's ID is the
+// external ID, so their role's trust policy only lets this service assume
+// it on their behalf and never for another user who learns the ARN.
+func (b *BucketClients) NewClient(bucket database.UserBucket) *s3.Client {
+	awsCfg := b.AWSConfig.Copy()
+	awsCfg.Region = bucket.Region
+	awsCfg.Credentials = assumeRole(b.AWSConfig, AssumeRoleConfig{
+		RoleARN:     bucket.RoleARN,
+		ExternalID:  bucket.UserID.String(),
+		SessionName: "tubely-" + bucket.UserID.String(),
+		Duration:    userBucketSessionDuration,
+	})
+
+	// The bucket must belong to the account the role is in
+	owner, _ := RoleAccountID(bucket.RoleARN)
+	return NewS3Client(awsCfg, b.Endpoint, BucketAccess{ExpectedOwner: owner})
+}
+
+// CheckUserBucket assumes a user's role and confirms the bucket can be reached with it.
+func (b *BucketClients) CheckUserBucket(ctx context.Context, bucket database.UserBucket) error {
+	owner, _ := RoleAccountID(bucket.RoleARN)
+	_, err := b.NewClient(bucket).HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket:              aws.String(bucket.Bucket),
+		ExpectedBucketOwner: aws.String(owner),
+	})
+	return err
+}
@@ -0,0 +1,60 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DefaultVisibilityAttempts is how many times a new object is looked for
+// when the deployment doesn't say.
+const DefaultVisibilityAttempts = 5
+
+// Wait after the first HeadObject that doesn't find a new object, doubling
+// after each miss after that
+const visibilityBackoff = 250 * time.Millisecond
+
+// VisibilityCheck makes sure a stored object can be read before the video
+// is pointed at it, for S3-compatible stores that don't always read their
+// own writes straight away. AWS doesn't need it. The zero value doesn't
+// check.
+type VisibilityCheck struct {
+	// Attempts is how many times the object is looked for before giving up
+	Attempts int
+}
+
+// WaitForObject asks for an object's metadata until it's found, waiting a
+// little longer after each miss. It fails once Attempts have missed, and
+// straight away on errors other than the object not being found.
+func (v VisibilityCheck) WaitForObject(ctx context.Context, client *s3.Client, bucket, key string) error {
+	backoff := visibilityBackoff
+	for attempt := 1; attempt <= v.Attempts; attempt++ {
+		_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		var notFound *types.NotFound
+		if err == nil || !errors.As(err, &notFound) {
+			return err
+		}
+		if attempt == v.Attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	if v.Attempts > 0 {
+		return fmt.Errorf("object %s still isn't readable after %d attempts", key, v.Attempts)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// ScratchWatchdog periodically deletes scratch files left behind by crashes,
+// anything older than MaxAge that no unfinished job still needs.
+type ScratchWatchdog struct {
+	Scratch  *Scratch
+	DB       database.Client
+	MaxAge   time.Duration
+	Interval time.Duration
+
+	mu    sync.Mutex
+	stats WatchdogStats
+}
+
+// WatchdogStats counts what the watchdog has cleaned up since startup.
+type WatchdogStats struct {
+	MaxAge         string     `json:"max_age"`
+	LastSweepAt    *time.Time `json:"last_sweep_at"`
+	LastRemoved    int        `json:"last_removed"`
+	RemovedFiles   int        `json:"removed_files"`
+	RemovedBytes   int64      `json:"removed_bytes"`
+	FailedSweeps   int        `json:"failed_sweeps"`
+	LastSweepError *string    `json:"last_sweep_error"`
+}
+
+// Run sweeps once immediately and then every Interval until ctx is cancelled.
+func (w *ScratchWatchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.Sweep()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes stale scratch files and records what it removed.
+func (w *ScratchWatchdog) Sweep() {
+	removed, removedBytes, err := w.sweep()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now().UTC()
+	w.stats.LastSweepAt = &now
+	w.stats.LastRemoved = removed
+	w.stats.RemovedFiles += removed
+	w.stats.RemovedBytes += removedBytes
+	if err != nil {
+		msg := err.Error()
+		w.stats.FailedSweeps++
+		w.stats.LastSweepError = &msg
+		log.Printf("Scratch watchdog sweep failed: %v", err)
+	}
+
+	if removed > 0 {
+		log.Printf("Scratch watchdog removed %d stale file(s) totalling %d bytes, older than %s; a crash may have left them behind", removed, removedBytes, w.MaxAge)
+	}
+}
+
+// Stats returns the watchdog's running totals.
+func (w *ScratchWatchdog) Stats() WatchdogStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.MaxAge = w.MaxAge.String()
+	return stats
+}
+
+func (w *ScratchWatchdog) sweep() (int, int64, error) {
+
+	// Uploads waiting in the queue can be older than MaxAge, so never touch
+	// the files of a job that hasn't finished
+	jobs, err := w.DB.GetIncompleteJobs()
+	if err != nil {
+		return 0, 0, err
+	}
+	keep := make(map[string]bool)
+	for _, job := range jobs {
+		keep[filepath.Base(job.SourcePath)] = true
+		keep[downloadName(job)] = true
+		if job.ProcessedPath != nil {
+			keep[filepath.Base(*job.ProcessedPath)] = true
+		}
+	}
+
+	// Nor the chunks of a resumable upload that can still be finished
+	sessions, err := w.DB.GetUploadSessions(time.Now().UTC(), false)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, session := range sessions {
+		keep[filepath.Base(session.TempPath)] = true
+	}
+
+	cutoff := time.Now().Add(-w.MaxAge)
+	removed, removedBytes := 0, int64(0)
+	for _, dir := range w.Scratch.Dirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return removed, removedBytes, err
+		}
+
+		for _, entry := range entries {
+			if keep[entry.Name()] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+
+			// HLS output is a directory of files
+			entryPath := filepath.Join(dir, entry.Name())
+			files, size := 1, info.Size()
+			if entry.IsDir() {
+				usage, err := MeasureDir(entryPath)
+				if err != nil {
+					continue
+				}
+				files, size = usage.Files, usage.UsedBytes
+			}
+
+			if err := os.RemoveAll(entryPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Scratch watchdog couldn't remove %s: %v", entryPath, err)
+				continue
+			}
+			removed += files
+			removedBytes += size
+		}
+	}
+
+	return removed, removedBytes, nil
+}
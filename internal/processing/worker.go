@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Worker claims queued jobs from the shared database and runs them,
+// sending heartbeats while a job is in flight so that jobs held by a dead
+// worker can be reclaimed by the others.
+type Worker struct {
+	Processor         *Processor
+	ID                string
+	PollInterval      time.Duration
+	HeartbeatInterval time.Duration
+	StaleAfter        time.Duration
+	// Weights share the worker's time between job priorities; nil means
+	// DefaultPriorityWeights
+	Weights PriorityWeights
+}
+
+// Run processes jobs until ctx is cancelled. A job that is already running
+// when ctx is cancelled is allowed to finish.
+func (w *Worker) Run(ctx context.Context) {
+	log.Printf("Worker %s started", w.ID)
+
+	weights := w.Weights
+	if weights == nil {
+		weights = DefaultPriorityWeights
+	}
+	scheduler := newPriorityScheduler(weights)
+
+	for {
+		// Put jobs abandoned by dead workers back on the queue, or fail them
+		// once they've been tried too often
+		requeued, failed, err := w.Processor.RetryStaleJobs(ctx, w.StaleAfter)
+		if err != nil {
+			log.Printf("Couldn't retry stale jobs: %v", err)
+		} else if requeued > 0 || failed > 0 {
+			log.Printf("Requeued %d and failed %d stale job(s)", requeued, failed)
+		}
+
+		// Start with the priority whose turn it is, falling back to the
+		// others so the worker is never idle while there's work queued
+		var order []database.JobPriority
+		if priority := scheduler.next(); priority != "" {
+			order = append(order, priority)
+		}
+		job, ok, err := w.Processor.DB.ClaimJob(w.ID, order)
+		if err != nil {
+			log.Printf("Couldn't claim job: %v", err)
+		}
+		if ok {
+			w.runWithHeartbeat(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Worker %s stopped", w.ID)
+			return
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
+
+func (w *Worker) runWithHeartbeat(ctx context.Context, job database.Job) {
+	log.Printf("Worker %s claimed %s job %s for video %s", w.ID, job.Priority, job.ID, job.VideoID)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(w.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				state, err := w.Processor.DB.HeartbeatJob(job.ID, w.ID)
+				if err != nil {
+					log.Printf("Couldn't send heartbeat for job %s: %v", job.ID, err)
+					continue
+				}
+				// The owner cancelled the job, so kill its ffmpeg
+				if state == database.JobStateCancelled {
+					w.Processor.stop(job.ID)
+				}
+			}
+		}
+	}()
+
+	if _, err := w.Processor.Run(context.WithoutCancel(ctx), job); err != nil {
+		if errors.Is(err, ErrJobWaiting) {
+			log.Printf("Job %s is waiting for its transcoder to report back", job.ID)
+			return
+		}
+		if errors.Is(err, database.ErrJobCancelled) {
+			log.Printf("Job %s was cancelled", job.ID)
+			return
+		}
+		log.Printf("Job %s failed: %v", job.ID, err)
+		return
+	}
+	log.Printf("Job %s finished", job.ID)
+}
@@ -0,0 +1,200 @@
+// Package takeout reads the video metadata in a YouTube Takeout archive, so a
+// channel's library can be recreated before its files are transferred.
+package takeout
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Names of the CSV files Takeout writes under "video metadata", compared
+// without case
+const (
+	videosFile = "videos.csv"
+	tagsFile   = "video tags.csv"
+)
+
+// Longest CSV file read from an archive, well above the metadata of the
+// largest channels, so a zip bomb can't exhaust memory
+const maxFileSize = 64 << 20
+
+// ErrNoVideos is returned for archives without a videos.csv.
+var ErrNoVideos = errors.New("archive has no videos.csv, export YouTube video metadata from Takeout")
+
+// Video is one video of a channel, as Takeout describes it.
+type Video struct {
+	// ID is the YouTube video ID
+	ID          string
+	Title       string
+	Description string
+	Tags        []string
+	// Privacy is "public", "unlisted" or "private", lowercased
+	Privacy string
+}
+
+// Columns of each file, by the names Takeout has used for them. Older
+// exports leave "(Original)" off.
+var (
+	idColumns          = []string{"video id"}
+	titleColumns       = []string{"video title (original)", "video title", "title"}
+	descriptionColumns = []string{"video description (original)", "video description", "description"}
+	privacyColumns     = []string{"privacy"}
+	tagsColumns        = []string{"video tags", "tags"}
+	tagColumns         = []string{"video tag", "tag"}
+)
+
+// ReadVideos reads the videos in a Takeout zip archive, in the order
+// videos.csv lists them. Tags come from videos.csv if it has a tags column,
+// or from video tags.csv, which has a row per tag.
+func ReadVideos(r io.ReaderAt, size int64) ([]Video, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read zip archive: %w", err)
+	}
+
+	var videosEntry, tagsEntry *zip.File
+	for _, file := range archive.File {
+		switch strings.ToLower(path.Base(file.Name)) {
+		case videosFile:
+			videosEntry = file
+		case tagsFile:
+			tagsEntry = file
+		}
+	}
+	if videosEntry == nil {
+		return nil, ErrNoVideos
+	}
+
+	rows, columns, err := readCSV(videosEntry)
+	if err != nil {
+		return nil, err
+	}
+	idColumn, ok := findColumn(columns, idColumns)
+	if !ok {
+		return nil, fmt.Errorf("%s has no Video ID column", videosEntry.Name)
+	}
+	titleColumn, hasTitle := findColumn(columns, titleColumns)
+	descriptionColumn, hasDescription := findColumn(columns, descriptionColumns)
+	privacyColumn, hasPrivacy := findColumn(columns, privacyColumns)
+	tagsColumn, hasTags := findColumn(columns, tagsColumns)
+
+	videos := []Video{}
+	byID := map[string]int{}
+	for _, row := range rows {
+		video := Video{ID: strings.TrimSpace(row[idColumn])}
+		if video.ID == "" {
+			continue
+		}
+		if _, seen := byID[video.ID]; seen {
+			continue
+		}
+		if hasTitle {
+			video.Title = strings.TrimSpace(row[titleColumn])
+		}
+		if hasDescription {
+			video.Description = row[descriptionColumn]
+		}
+		if hasPrivacy {
+			video.Privacy = strings.ToLower(strings.TrimSpace(row[privacyColumn]))
+		}
+		if hasTags {
+			video.Tags = splitTags(row[tagsColumn])
+		}
+		byID[video.ID] = len(videos)
+		videos = append(videos, video)
+	}
+
+	if tagsEntry != nil && !hasTags {
+		if err := readTags(tagsEntry, videos, byID); err != nil {
+			return nil, err
+		}
+	}
+	return videos, nil
+}
+
+// readTags adds the tags in video tags.csv to the videos they're for.
+func readTags(file *zip.File, videos []Video, byID map[string]int) error {
+	rows, columns, err := readCSV(file)
+	if err != nil {
+		return err
+	}
+	idColumn, hasID := findColumn(columns, idColumns)
+	tagColumn, hasTag := findColumn(columns, tagColumns)
+	if !hasID || !hasTag {
+		return fmt.Errorf("%s needs Video ID and Video Tag columns", file.Name)
+	}
+	for _, row := range rows {
+		i, ok := byID[strings.TrimSpace(row[idColumn])]
+		if !ok {
+			continue
+		}
+		if tag := strings.TrimSpace(row[tagColumn]); tag != "" {
+			videos[i].Tags = append(videos[i].Tags, tag)
+		}
+	}
+	return nil
+}
+
+// readCSV reads a CSV file of an archive, returning its rows, each as long
+// as the header, and its lowercased column names.
+func readCSV(file *zip.File) ([][]string, []string, error) {
+	if file.UncompressedSize64 > maxFileSize {
+		return nil, nil, fmt.Errorf("%s is larger than %d bytes", file.Name, maxFileSize)
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't open %s: %w", file.Name, err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(io.LimitReader(f, maxFileSize))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read %s: %w", file.Name, err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("%s is empty", file.Name)
+	}
+
+	columns := make([]string, len(records[0]))
+	for i, name := range records[0] {
+		// Spreadsheet exports often start with a byte order mark
+		columns[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+	}
+	rows := make([][]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make([]string, len(columns))
+		copy(row, record)
+		rows = append(rows, row)
+	}
+	return rows, columns, nil
+}
+
+// findColumn finds the first of names among a file's columns.
+func findColumn(columns []string, names []string) (int, bool) {
+	for _, name := range names {
+		for i, column := range columns {
+			if column == name {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// splitTags splits a tags cell, which Takeout separates with commas.
+func splitTags(cell string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(cell, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,220 @@
+package testkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// How long WaitForJob waits for a job to finish
+const jobTimeout = 2 * time.Minute
+
+// Client calls the API of a test server as one user.
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+// APIError is the body of an error response.
+type APIError struct {
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Details []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"details"`
+}
+
+// NewUser signs up a new user with a random email and returns a client
+// logged in as them.
+func (s *Server) NewUser(t testing.TB) *Client {
+	t.Helper()
+
+	client := &Client{BaseURL: s.URL, HTTP: &http.Client{Timeout: jobTimeout}}
+	credentials := map[string]string{
+		"email":    fmt.Sprintf("%s@testkit.local", uuid.NewString()),
+		"password": "testkit-password",
+	}
+	client.JSON(t, http.MethodPost, "/api/v1/users", credentials, nil, http.StatusCreated)
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	client.JSON(t, http.MethodPost, "/api/v1/login", credentials, &login, http.StatusOK)
+	client.Token = login.Token
+	return client
+}
+
+// Request sends a request and returns the response status and body, for
+// tests that check failures as well as successes.
+func (c *Client) Request(t testing.TB, method, path, contentType string, body io.Reader) (int, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: couldn't read response: %v", method, path, err)
+	}
+	return resp.StatusCode, data
+}
+
+// JSON sends in as a JSON body, fails the test unless the response has
+// wantStatus, and decodes the response into out if it isn't nil.
+func (c *Client) JSON(t testing.TB, method, path string, in, out any, wantStatus int) {
+	t.Helper()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	status, data := c.Request(t, method, path, "application/json", body)
+	c.decode(t, method, path, status, data, out, wantStatus)
+}
+
+// CreateVideo creates a draft video owned by the client's user.
+func (c *Client) CreateVideo(t testing.TB, title string) database.Video {
+	t.Helper()
+
+	var video database.Video
+	c.JSON(t, http.MethodPost, "/api/v1/videos", map[string]string{
+		"title":       title,
+		"description": "Created by testkit",
+	}, &video, http.StatusCreated)
+	return video
+}
+
+// GetVideo fetches a video.
+func (c *Client) GetVideo(t testing.TB, videoID uuid.UUID) database.Video {
+	t.Helper()
+
+	var video database.Video
+	c.JSON(t, http.MethodGet, "/api/v1/videos/"+videoID.String(), nil, &video, http.StatusOK)
+	return video
+}
+
+// UploadThumbnail uploads an image file as a video's thumbnail.
+func (c *Client) UploadThumbnail(t testing.TB, videoID uuid.UUID, path, mediaType string) database.Video {
+	t.Helper()
+
+	var video database.Video
+	status, data := c.upload(t, "/api/v1/thumbnail_upload/"+videoID.String(), "thumbnail", path, mediaType)
+	c.decode(t, http.MethodPost, "/api/v1/thumbnail_upload", status, data, &video, http.StatusOK)
+	return video
+}
+
+// UploadVideo uploads an MP4 file as a video's content and returns the
+// video once it has been processed, waiting for the job if a worker
+// processes it.
+func (c *Client) UploadVideo(t testing.TB, videoID uuid.UUID, path string) database.Video {
+	t.Helper()
+
+	status, data := c.upload(t, "/api/v1/video_upload/"+videoID.String(), "video", path, "video/mp4")
+	if status == http.StatusAccepted {
+		var job database.Job
+		c.decode(t, http.MethodPost, "/api/v1/video_upload", status, data, &job, http.StatusAccepted)
+		if job = c.WaitForJob(t, job.ID); job.State != database.JobStateSucceeded {
+			t.Fatalf("job %s ended %s: %v", job.ID, job.State, job.Error)
+		}
+		return c.GetVideo(t, videoID)
+	}
+
+	var video database.Video
+	c.decode(t, http.MethodPost, "/api/v1/video_upload", status, data, &video, http.StatusOK)
+	return video
+}
+
+// WaitForJob polls a job until it succeeds or fails.
+func (c *Client) WaitForJob(t testing.TB, jobID uuid.UUID) database.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(jobTimeout)
+	for {
+		var job database.Job
+		c.JSON(t, http.MethodGet, "/api/v1/jobs/"+jobID.String(), nil, &job, http.StatusOK)
+		if job.State == database.JobStateSucceeded || job.State == database.JobStateFailed {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s after %s", jobID, job.State, jobTimeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// upload sends a file as a single-part multipart form.
+func (c *Client) upload(t testing.TB, path, field, filePath, mediaType string) (int, []byte) {
+	t.Helper()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filepath.Base(filePath)))
+	header.Set("Content-Type", mediaType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		t.Fatal(err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c.Request(t, http.MethodPost, path, form.FormDataContentType(), &body)
+}
+
+func (c *Client) decode(t testing.TB, method, path string, status int, data []byte, out any, wantStatus int) {
+	t.Helper()
+
+	if status != wantStatus {
+		var apiErr APIError
+		json.Unmarshal(data, &apiErr)
+		t.Fatalf("%s %s: got status %d, want %d: %s (%s)", method, path, status, wantStatus, apiErr.Error, apiErr.Code)
+	}
+	if out == nil {
+		return
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatalf("%s %s: couldn't decode response: %v", method, path, err)
+	}
+}
@@ -0,0 +1,21 @@
+// Package testkit runs the server end to end against a real S3-compatible
+// store, for integration tests of storage and processing features.
+//
+// A test starts MinIO, starts the server pointed at it, and drives the API
+// through a Client:
+//
+//	func TestUploadVideo(t *testing.T) {
+//		store := testkit.StartMinIO(t)
+//		server := testkit.StartServer(t, store)
+//		client := server.NewUser(t)
+//
+//		video := client.CreateVideo(t, "Boots")
+//		video = client.UploadVideo(t, video.ID, testkit.VideoFixture(t, 3*time.Second))
+//		store.RequireObject(t, video)
+//	}
+//
+// StartMinIO uses the store at TESTKIT_S3_ENDPOINT when it is set, and
+// otherwise runs a throwaway MinIO container with docker. Tests are skipped
+// when neither is available, as are tests needing fixtures from a missing
+// ffmpeg, so the suite still passes on machines without them.
+package testkit
@@ -0,0 +1,43 @@
+package testkit_test
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/testkit"
+)
+
+func TestUploadVideo(t *testing.T) {
+	testkit.RequireFFmpeg(t)
+	store := testkit.StartMinIO(t)
+	server := testkit.StartServer(t, store)
+	client := server.NewUser(t)
+
+	video := client.CreateVideo(t, "Boots")
+	video = client.UploadVideo(t, video.ID, testkit.VideoFixture(t, 3*time.Second))
+	key := store.RequireObject(t, video)
+
+	if !strings.Contains(key, "landscape") {
+		t.Errorf("key %s isn't filed as landscape", key)
+	}
+	if got := client.GetVideo(t, video.ID); got.VideoURL == nil || *got.VideoURL != *video.VideoURL {
+		t.Errorf("fetched video URL = %v, want %s", got.VideoURL, *video.VideoURL)
+	}
+}
+
+func TestUploadThumbnail(t *testing.T) {
+	store := testkit.StartMinIO(t)
+	server := testkit.StartServer(t, store)
+	client := server.NewUser(t)
+
+	video := client.CreateVideo(t, "Boots")
+	video = client.UploadThumbnail(t, video.ID, testkit.ThumbnailFixture(t, color.RGBA{R: 255, A: 255}), "image/png")
+	if video.ThumbnailURL == nil {
+		t.Fatal("video has no thumbnail URL")
+	}
+	if got := client.GetVideo(t, video.ID); got.ThumbnailURL == nil {
+		t.Error("fetched video has no thumbnail URL")
+	}
+}
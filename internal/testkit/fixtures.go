@@ -0,0 +1,71 @@
+package testkit
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// RequireFFmpeg skips the test unless ffmpeg and ffprobe are installed,
+// since the server needs both to process videos.
+func RequireFFmpeg(t testing.TB) {
+	t.Helper()
+
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+}
+
+// VideoFixture generates a small landscape MP4 with a test pattern and a
+// tone, lasting duration, and returns its path.
+func VideoFixture(t testing.TB, duration time.Duration) string {
+	t.Helper()
+	RequireFFmpeg(t)
+
+	outputPath := filepath.Join(t.TempDir(), "fixture.mp4")
+	seconds := fmt.Sprintf("%.3f", duration.Seconds())
+	cmd := exec.Command("ffmpeg",
+		"-y",
+		"-f", "lavfi", "-i", "testsrc=size=320x180:rate=24:duration="+seconds,
+		"-f", "lavfi", "-i", "sine=frequency=440:duration="+seconds,
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-shortest",
+		outputPath,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("couldn't generate video fixture: %v\n%s", err, out)
+	}
+	return outputPath
+}
+
+// ThumbnailFixture writes a small PNG in a single color and returns its path.
+func ThumbnailFixture(t testing.TB, fill color.Color) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 36))
+	for y := 0; y < 36; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "thumbnail.png")
+	file, err := os.Create(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatalf("couldn't write thumbnail fixture: %v", err)
+	}
+	return outputPath
+}
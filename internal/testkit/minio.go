@@ -0,0 +1,220 @@
+package testkit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Image and credentials of the MinIO container started for tests
+const (
+	minioImage     = "minio/minio:latest"
+	minioAccessKey = "testkit"
+	minioSecretKey = "testkit-secret"
+	minioRegion    = "us-east-1"
+)
+
+// How long to wait for a started MinIO container to accept requests
+const minioStartTimeout = 30 * time.Second
+
+// MinIO is an S3-compatible store with a fresh bucket for one test.
+type MinIO struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Bucket    string
+	Client    *s3.Client
+}
+
+// StartMinIO returns a store with an empty bucket, removed when the test ends.
+func StartMinIO(t testing.TB) *MinIO {
+	t.Helper()
+
+	store := &MinIO{
+		Endpoint:  os.Getenv("TESTKIT_S3_ENDPOINT"),
+		AccessKey: envOr("TESTKIT_S3_ACCESS_KEY", minioAccessKey),
+		SecretKey: envOr("TESTKIT_S3_SECRET_KEY", minioSecretKey),
+		Region:    envOr("TESTKIT_S3_REGION", minioRegion),
+		Bucket:    "testkit-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:16],
+	}
+	if store.Endpoint == "" {
+		store.Endpoint = runMinIOContainer(t, store.AccessKey, store.SecretKey)
+	}
+
+	awsCfg := aws.Config{
+		Region:      store.Region,
+		Credentials: credentials.NewStaticCredentialsProvider(store.AccessKey, store.SecretKey, ""),
+	}
+	store.Client = processing.NewS3Client(awsCfg, store.Endpoint, processing.BucketAccess{})
+
+	ctx := context.Background()
+	_, err := store.Client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(store.Bucket)})
+	if err != nil {
+		t.Fatalf("couldn't create bucket %s: %v", store.Bucket, err)
+	}
+	t.Cleanup(func() {
+		store.emptyBucket(ctx)
+		store.Client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(store.Bucket)})
+	})
+
+	return store
+}
+
+// BaseURL is where objects in the bucket can be fetched directly, standing in
+// for the CloudFront distribution.
+func (m *MinIO) BaseURL() string {
+	return strings.TrimSuffix(m.Endpoint, "/") + "/" + m.Bucket
+}
+
+// Object returns the contents of an object, failing the test if it doesn't exist.
+func (m *MinIO) Object(t testing.TB, key string) []byte {
+	t.Helper()
+
+	object, err := m.Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(m.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("couldn't get object %s: %v", key, err)
+	}
+	defer object.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(object.Body); err != nil {
+		t.Fatalf("couldn't read object %s: %v", key, err)
+	}
+	return buf.Bytes()
+}
+
+// RequireObject fails the test unless a video's stored file is in the bucket,
+// and returns its key.
+func (m *MinIO) RequireObject(t testing.TB, video database.Video) string {
+	t.Helper()
+
+	if video.VideoURL == nil {
+		t.Fatalf("video %s has no video URL", video.ID)
+	}
+	prefix := m.BaseURL() + "/"
+	if !strings.HasPrefix(*video.VideoURL, prefix) {
+		t.Fatalf("video URL %s is not served from %s", *video.VideoURL, m.BaseURL())
+	}
+	key := strings.TrimPrefix(*video.VideoURL, prefix)
+
+	_, err := m.Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(m.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("stored video %s is missing: %v", key, err)
+	}
+	return key
+}
+
+// Keys lists every object in the bucket under prefix.
+func (m *MinIO) Keys(t testing.TB, prefix string) []string {
+	t.Helper()
+
+	keys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(m.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(m.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("couldn't list objects: %v", err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys
+}
+
+func (m *MinIO) emptyBucket(ctx context.Context) {
+	paginator := s3.NewListObjectsV2Paginator(m.Client, &s3.ListObjectsV2Input{Bucket: aws.String(m.Bucket)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return
+		}
+		for _, object := range page.Contents {
+			m.Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(m.Bucket), Key: object.Key})
+		}
+	}
+}
+
+// runMinIOContainer starts MinIO with docker on a random local port and
+// returns its endpoint once it is healthy.
+func runMinIOContainer(t testing.TB, accessKey, secretKey string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not installed and TESTKIT_S3_ENDPOINT is not set")
+	}
+
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-p", "127.0.0.1::9000",
+		"-e", "MINIO_ROOT_USER="+accessKey,
+		"-e", "MINIO_ROOT_PASSWORD="+secretKey,
+		minioImage, "server", "/data",
+	).Output()
+	if err != nil {
+		t.Skipf("couldn't start MinIO container: %v", err)
+	}
+	containerID := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", containerID).Run()
+	})
+
+	out, err = exec.Command("docker", "port", containerID, "9000/tcp").Output()
+	if err != nil {
+		t.Fatalf("couldn't find MinIO port: %v", err)
+	}
+	endpoint := "http://" + strings.TrimSpace(strings.Split(string(out), "\n")[0])
+
+	if err := waitFor(endpoint+"/minio/health/live", minioStartTimeout); err != nil {
+		t.Fatalf("MinIO didn't start: %v", err)
+	}
+	return endpoint
+}
+
+// waitFor polls url until it answers with a 2xx status or timeout passes.
+func waitFor(url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not ready after %s: %w", url, timeout, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -6,22 +6,94 @@ import (
 	"net/http"
 )
 
+// envelope is the body of every successful JSON response from /api/v2
+type envelope struct {
+	Data      any    `json:"data"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// listEnvelope is the body of responses listing things. NextCursor is passed
+// back as ?cursor= for the next page, and is null on the last one.
+type listEnvelope struct {
+	Data       any     `json:"data"`
+	NextCursor *string `json:"next_cursor"`
+	Total      int     `json:"total"`
+	RequestID  string  `json:"request_id,omitempty"`
+}
+
+// listPage describes the page of a list being responded with
+type listPage struct {
+	// NextCursor is "" on the last page
+	NextCursor string
+	// Total counts the items on every page
+	Total int
+	// v1Field is the field /api/v1 sent the items in, next to next_cursor,
+	// "" when it sent the bare list
+	v1Field string
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	writeError(w, code, errorCodeForStatus(code), msg, err, nil)
+}
+
+// Function to respond with a specific error code, its status and any field-level details
+func respondWithErrorCode(w http.ResponseWriter, code errorCode, msg string, err error, details []fieldError) {
+	writeError(w, code.status(), code, msg, err, details)
+}
+
+func writeError(w http.ResponseWriter, status int, code errorCode, msg string, err error, details []fieldError) {
+	requestID := w.Header().Get(requestIDHeader)
 	if err != nil {
-		log.Println(err)
-	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		log.Printf("[%s] %s", requestID, err)
 	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	if status > 499 {
+		log.Printf("[%s] Responding with 5XX error: %s", requestID, msg)
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+	writeJSON(w, status, apiError{
+		Error:     msg,
+		Code:      code,
+		Details:   details,
+		RequestID: requestID,
 	})
 }
 
+// Function to respond with payload as the data of an envelope, or as it is to /api/v1
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	if _, ok := w.(v1ResponseWriter); ok {
+		writeJSON(w, code, payload)
+		return
+	}
+	writeJSON(w, code, envelope{
+		Data:      payload,
+		RequestID: w.Header().Get(requestIDHeader),
+	})
+}
+
+// Function to respond with one page of a list, a slice, as the data of a list envelope
+func respondWithList(w http.ResponseWriter, code int, items any, page listPage) {
+	var nextCursor *string
+	if page.NextCursor != "" {
+		nextCursor = &page.NextCursor
+	}
+	if _, ok := w.(v1ResponseWriter); ok {
+		if page.v1Field == "" {
+			writeJSON(w, code, items)
+			return
+		}
+		writeJSON(w, code, map[string]any{page.v1Field: items, "next_cursor": nextCursor})
+		return
+	}
+	writeJSON(w, code, listEnvelope{
+		Data:       items,
+		NextCursor: nextCursor,
+		Total:      page.Total,
+		RequestID:  w.Header().Get(requestIDHeader),
+	})
+}
+
+// Function to respond with payload as it is, for /api/v1 and for bodies whose
+// shape is set by a standard rather than by this API, such as oEmbed
+func writeJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads that are sampled unless REQUEST_LOG_SAMPLE_RATES says otherwise:
+// assets, and the job and upload statuses clients poll while they wait
+const defaultLogSampleRates = "/assets/=0.01,/api/jobs/=0.1,/api/uploads/=0.1"
+
+// logSampling is the share of reads logged under each path prefix. The
+// longest matching prefix wins, and paths under none are always logged.
+type logSampling map[string]float64
+
+// Function to parse sample rates such as "/assets/=0.01,/api/jobs/=0.1".
+// API paths are written without their version, and cover every version.
+func parseLogSampling(value string) (logSampling, error) {
+	sampling := logSampling{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, rate, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("%q isn't a path prefix and a rate", entry)
+		}
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("rate for %s must be between 0 and 1", prefix)
+		}
+		sampling[prefix] = r
+	}
+	return sampling, nil
+}
+
+// Function to get the share of reads of a path to log
+func (s logSampling) rate(path string) float64 {
+	for _, versioned := range []string{apiVersionedPrefix, apiV1Prefix} {
+		if strings.HasPrefix(path, versioned) {
+			path = apiPrefix + strings.TrimPrefix(path, versioned)
+			break
+		}
+	}
+	rate, longest := 1.0, -1
+	for prefix, r := range s {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			rate, longest = r, len(prefix)
+		}
+	}
+	return rate
+}
+
+// Function to log a line for each request with its status, size and time,
+// under its request ID. Reads of high-volume paths are sampled, but server
+// errors are always logged.
+func requestLogMiddleware(sampling logSampling, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &loggedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, r)
+
+		sampled := r.Method != http.MethodGet && r.Method != http.MethodHead
+		if !sampled {
+			rate := sampling.rate(r.URL.Path)
+			sampled = rate >= 1 || rand.Float64() < rate
+		}
+		if !sampled && lw.status < 500 {
+			return
+		}
+		log.Printf("[%s] %s %s %d %dB %s", w.Header().Get(requestIDHeader), r.Method, r.URL.RequestURI(),
+			lw.status, lw.bytes, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// loggedResponseWriter records what was sent, for the request log
+type loggedResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *loggedResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggedResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Function to let http.ResponseController reach the wrapped writer
+func (w *loggedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Credentials and personal data that mustn't reach the logs, and what's left in their place
+var logRedactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// Authorization header values, including admin API keys
+	{regexp.MustCompile(`(?i)\b(Bearer|ApiKey)\s+[A-Za-z0-9._~+/=-]+`), "$1 [REDACTED]"},
+	// JWTs, such as embed, stream and share tokens, wherever they turn up
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), "[REDACTED]"},
+	// Signatures and credentials of presigned S3 and CloudFront URLs, and tokens passed in queries
+	{regexp.MustCompile(`(?i)([?&](?:X-Amz-Signature|X-Amz-Credential|X-Amz-Security-Token|Signature|Policy|Key-Pair-Id|token|access_token|refresh_token)=)[^&\s"']+`), "$1[REDACTED]"},
+	// Links that are their own credential: share, clip, playback and HLS stream links
+	{regexp.MustCompile(`(/api/(?:v\d+/)?(?:share|clips|play|hls)/|/s/)[^/?\s"']+`), "$1[REDACTED]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[REDACTED EMAIL]"},
+}
+
+// Function to strip credentials and email addresses from a log message
+func redactLog(s string) string {
+	for _, r := range logRedactions {
+		s = r.pattern.ReplaceAllString(s, r.replacement)
+	}
+	return s
+}
+
+// redactingWriter scrubs everything logged before it's written out, so
+// errors that quote a URL or a request's data can't leak them either
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(w.w, redactLog(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
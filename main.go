@@ -5,29 +5,91 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/billing"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/geoip"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/inbound"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/notify"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	s3Client		 *s3.Client
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
+	db                 database.Client
+	jwtSecret          string
+	platform           string
+	s3Client           *s3.Client
+	buckets            *processing.BucketClients
+	notifier           *notify.Notifier
+	filepathRoot       string
+	assetsRoot         string
+	s3Bucket           string
+	s3Region           string
+	s3CfDistribution   string
+	port               string
+	origin             string
+	publicBaseURL      string
+	proxies            trustedProxies
+	minVideoDuration   time.Duration
+	maxVideoDuration   time.Duration
+	scratch            *processing.Scratch
+	memoryUploadLimit  int64
+	uploadLimit        int64
+	clamav             *ingest.ClamAV
+	watchdog           *processing.ScratchWatchdog
+	fixity             *processing.FixityChecker
+	reconciler         *processing.Reconciler
+	hotlink            hotlinkPolicy
+	feeds              feedConfig
+	urls               urlConfig
+	presignConcurrency int
+	embeds             embedConfig
+	hls                hlsProxyConfig
+	cdnMaxAge          time.Duration
+	cdnPurger          *cdn.Purger
+	geoIP              geoip.Provider
+	serviceMode        *serviceMode
+	processingMode     string
+	asyncTranscode     bool
+	callbackSecret     string
+	stagingPrefix      string
+	keyTemplate        processing.KeyTemplate
+	processor          *processing.Processor
+	ingest             *ingest.Pipeline
+	profiles           processing.Profiles
+	adminAPIKey        string
+	dryRunDefault      bool
+	uploadTimeout      time.Duration
+	uploadExpiry       time.Duration
+	uploadLocks        *uploadLocks
+	shareAttempts      *attemptLimiter
+	sftp               *sftpGateway
+	mailgun            *inbound.Mailgun
+	ses                *inbound.SES
+	stripe             *billing.Stripe
+	deletionWebhookURL string
+	errorReporter      *errreport.Sentry
 }
 
+// Processing modes: inline runs jobs inside the upload request, worker
+// leaves them queued for tubely-worker processes
+const (
+	processingModeInline = "inline"
+	processingModeWorker = "worker"
+)
+
 func main() {
 	godotenv.Load(".env")
+	log.SetOutput(redactingWriter{os.Stderr})
 
 	pathToDB := os.Getenv("DB_PATH")
 	if pathToDB == "" {
@@ -79,24 +141,569 @@ func main() {
 		log.Fatal("PORT environment variable is not set")
 	}
 
-	// Load default AWS SDK config
-	awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	// Optional limits on uploaded video length, 0 means no limit
+	minVideoDuration := envDuration("VIDEO_MIN_DURATION", 0)
+	maxVideoDuration := envDuration("VIDEO_MAX_DURATION", 0)
+	if maxVideoDuration > 0 && minVideoDuration > maxVideoDuration {
+		log.Fatal("VIDEO_MIN_DURATION must not be greater than VIDEO_MAX_DURATION")
+	}
+
+	// Scratch directories for uploads awaiting processing; they must survive
+	// restarts for interrupted jobs to be resumed. Several directories, one per
+	// disk, can be listed like PATH to spread the load.
+	scratch, err := processing.NewScratch(
+		processing.ParseScratchDirs(envString("PROCESSING_DIR", filepath.Join(os.TempDir(), "tubely-processing"))),
+		envString("PROCESSING_DIR_POLICY", processing.ScratchRoundRobin),
+	)
+	if err != nil {
+		log.Fatalf("Couldn't set up processing directories: %v", err)
+	}
+
+	// Uploads up to this many bytes are processed in memory, piped through
+	// ffprobe and ffmpeg without touching the scratch disk; 0 disables it
+	memoryUploadLimit := envInt("MEMORY_UPLOAD_LIMIT", 0)
+	if memoryUploadLimit < 0 {
+		log.Fatal("MEMORY_UPLOAD_LIMIT can't be negative")
+	}
+
+	// The largest video users can upload unless their plan sets its own
+	uploadLimit := envInt("UPLOAD_LIMIT", videoUploadLimit)
+	if uploadLimit <= 0 || uploadLimit > processing.MaxUploadStreamSize {
+		log.Fatalf("UPLOAD_LIMIT must be between 1 and %d bytes", processing.MaxUploadStreamSize)
+	}
+
+	// Uploads are streamed to clamd as they arrive when it's configured
+	var clamav *ingest.ClamAV
+	if address := os.Getenv("CLAMAV_ADDRESS"); address != "" {
+		clamav = &ingest.ClamAV{Address: address, Timeout: envDuration("CLAMAV_TIMEOUT", 30*time.Second)}
+	}
+	// Uploads can be encrypted on disk under a key only this process holds,
+	// so other tenants of the host can't read them; a restart loses the
+	// uploads in progress
+	if envBool("SCRATCH_ENCRYPTION", false) {
+		if err := scratch.EnableEncryption(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Files older than this in the processing directories are crash
+	// leftovers and are swept away, 0 disables the sweep
+	scratchMaxAge := envDuration("SCRATCH_MAX_AGE", 24*time.Hour)
+	scratchSweepInterval := envDuration("SCRATCH_SWEEP_INTERVAL", time.Hour)
+	if scratchMaxAge > 0 && scratchSweepInterval <= 0 {
+		log.Fatal("SCRATCH_SWEEP_INTERVAL must be positive")
+	}
+
+	// Multipart uploads not finished or resumed within this long are
+	// aborted so their parts stop being billed, 0 disables the sweep
+	multipartUploadTTL := envDuration("MULTIPART_UPLOAD_TTL", 24*time.Hour)
+	multipartSweepInterval := envDuration("MULTIPART_SWEEP_INTERVAL", time.Hour)
+	if multipartUploadTTL > 0 && multipartSweepInterval <= 0 {
+		log.Fatal("MULTIPART_SWEEP_INTERVAL must be positive")
+	}
+
+	// Stored objects are re-verified against their checksums a sample at a
+	// time, 0 disables the checks
+	fixityInterval := envDuration("FIXITY_INTERVAL", 24*time.Hour)
+	fixitySampleSize := envInt("FIXITY_SAMPLE_SIZE", 20)
+	if fixityInterval > 0 && fixitySampleSize <= 0 {
+		log.Fatal("FIXITY_SAMPLE_SIZE must be positive")
+	}
+
+	// Buckets are reconciled against the database on demand, and every
+	// RECONCILE_INTERVAL when it's set, reading the default bucket's S3
+	// Inventory instead of listing it when there is one
+	reconcileInterval := envDuration("RECONCILE_INTERVAL", 0)
+	reconcileRepairs, err := processing.ParseRepairs(os.Getenv("RECONCILE_REPAIR"))
+	if err != nil {
+		log.Fatalf("Invalid RECONCILE_REPAIR: %v", err)
+	}
+	var inventory *processing.InventoryLocation
+	if inventoryBucket := os.Getenv("S3_INVENTORY_BUCKET"); inventoryBucket != "" {
+		inventory = &processing.InventoryLocation{
+			Bucket: inventoryBucket,
+			Prefix: os.Getenv("S3_INVENTORY_PREFIX"),
+		}
+		if inventory.Prefix == "" {
+			log.Fatal("S3_INVENTORY_PREFIX must be set with S3_INVENTORY_BUCKET")
+		}
+	}
+
+	// How long replaced thumbnails are kept for reverting, 0 keeps them forever
+	thumbnailRetention := envDuration("THUMBNAIL_HISTORY_RETENTION", 30*24*time.Hour)
+
+	processingMode := envString("PROCESSING_MODE", processingModeInline)
+	if processingMode != processingModeInline && processingMode != processingModeWorker {
+		log.Fatalf("PROCESSING_MODE must be %q or %q", processingModeInline, processingModeWorker)
+	}
+
+	// Key prefix uploads are staged under for workers to pick up
+	stagingPrefix := envString("S3_STAGING_PREFIX", "staging")
+
+	// How processed videos' keys are laid out; tubely-migrate-keys moves
+	// videos stored under an earlier template
+	keyTemplate, err := processing.ParseKeyTemplate(os.Getenv("S3_KEY_TEMPLATE"))
+	if err != nil {
+		log.Fatalf("Invalid S3_KEY_TEMPLATE: %v", err)
+	}
+
+	// Jobs whose worker stops sending heartbeats are retried with a growing
+	// backoff, then failed; workers apply the same settings
+	retryPolicy := processing.RetryPolicy{
+		MaxAttempts: envInt("JOB_MAX_ATTEMPTS", processing.MaxJobAttempts),
+		Backoff:     envDuration("JOB_RETRY_BACKOFF", 30*time.Second),
+		MaxBackoff:  envDuration("JOB_RETRY_MAX_BACKOFF", 30*time.Minute),
+	}
+	if retryPolicy.MaxAttempts < 1 {
+		log.Fatal("JOB_MAX_ATTEMPTS must be positive")
+	}
+	workerStaleAfter := envDuration("WORKER_STALE_AFTER", time.Minute)
+
+	// Requests get readTimeout to send their headers and body; uploads that
+	// pass validation are given uploadTimeout instead
+	readTimeout := envDuration("SERVER_READ_TIMEOUT", time.Minute)
+	uploadTimeout := envDuration("UPLOAD_TIMEOUT", 30*time.Minute)
+
+	// Resumable uploads must be finished within uploadExpiry of being started
+	uploadExpiry := envDuration("UPLOAD_SESSION_EXPIRY", 24*time.Hour)
+
+	// With a certificate, from files or from Let's Encrypt, the server speaks
+	// HTTPS and HTTP/2, and optionally HTTP/3 (experimental) on the same port
+	// number over UDP
+	serverTLS := serverTLS{
+		certFile:         os.Getenv("TLS_CERT_FILE"),
+		keyFile:          os.Getenv("TLS_KEY_FILE"),
+		autocertHTTPAddr: envString("TLS_AUTOCERT_HTTP_ADDR", ":80"),
+		http3:            envBool("HTTP3_ENABLED", false),
+	}
+	autocertDomains, err := parseAutocertDomains(os.Getenv("TLS_AUTOCERT_DOMAINS"))
+	if err != nil {
+		log.Fatalf("Invalid TLS_AUTOCERT_DOMAINS: %v", err)
+	}
+	if len(autocertDomains) > 0 {
+		err := serverTLS.useAutocert(autocertDomains, envString("TLS_AUTOCERT_CACHE_DIR", "autocert"), os.Getenv("TLS_AUTOCERT_EMAIL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := serverTLS.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Generated URLs point at PUBLIC_BASE_URL when the server is reached
+	// somewhere other than where it listens, e.g. behind a reverse proxy
+	publicBaseURL, err := parsePublicBaseURL(os.Getenv("PUBLIC_BASE_URL"))
+	if err != nil {
+		log.Fatalf("Invalid PUBLIC_BASE_URL: %v", err)
+	}
+	origin := serverTLS.origin(port)
+	if publicBaseURL != "" {
+		origin = publicBaseURL
+	}
+
+	// Forwarded client addresses, schemes and hosts are only believed from
+	// these proxies, by default ones on the same host; set it empty to trust none
+	trustedProxyList, ok := os.LookupEnv("TRUSTED_PROXIES")
+	if !ok {
+		trustedProxyList = "127.0.0.0/8,::1"
+	}
+	proxies, err := parseTrustedProxies(trustedProxyList)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// When the unversioned /api paths stop working, announced in their Sunset
+	// header; unset keeps them working
+	var unversionedAPISunset time.Time
+	if sunset := os.Getenv("API_UNVERSIONED_SUNSET"); sunset != "" {
+		unversionedAPISunset, err = time.Parse(time.DateOnly, sunset)
+		if err != nil {
+			log.Fatalf("API_UNVERSIONED_SUNSET must be a date like 2027-04-01: %v", err)
+		}
+	}
+
+	// Key for the admin API, which is disabled when unset
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+
+	// Destructive requests only report what they'd remove unless ?dryRun=false
+	dryRunDefault := envBool("DRY_RUN_DEFAULT", false)
+
+	// Email notifications need a mail server; Slack and Discord ones are always available
+	var smtpServer *notify.SMTP
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		smtpServer = &notify.SMTP{
+			Host:     host,
+			Port:     envString("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     envString("SMTP_FROM", "tubely@localhost"),
+		}
+	}
+
+	// Optional protection against other sites embedding assets and shared links
+	allowedOrigins, err := parseAllowedOrigins(os.Getenv("HOTLINK_ALLOWED_ORIGINS"))
+	if err != nil {
+		log.Fatalf("Invalid HOTLINK_ALLOWED_ORIGINS: %v", err)
+	}
+	if len(allowedOrigins) > 0 {
+		if o, ok := originOf(origin); ok {
+			allowedOrigins[o] = true
+		}
+	}
+	hotlink := hotlinkPolicy{
+		AllowedOrigins: allowedOrigins,
+		RequireReferer: envBool("HOTLINK_REQUIRE_REFERER", false),
+		SingleUseURLs:  envBool("HOTLINK_SINGLE_USE_URLS", false),
+		URLExpiry:      envDuration("HOTLINK_URL_EXPIRY", 5*time.Minute),
+	}
+	if hotlink.URLExpiry <= 0 {
+		log.Fatal("HOTLINK_URL_EXPIRY must be positive")
+	}
+
+	feeds := feedConfig{
+		PresignedURLs: envBool("FEED_PRESIGNED_URLS", false),
+		URLExpiry:     envDuration("FEED_URL_EXPIRY", 24*time.Hour),
+		MaxItems:      envInt("FEED_MAX_ITEMS", 50),
+	}
+	// S3 doesn't accept presigned URLs valid for more than a week
+	if feeds.URLExpiry <= 0 || feeds.URLExpiry > 7*24*time.Hour {
+		log.Fatal("FEED_URL_EXPIRY must be between 0 and 168h")
+	}
+	if feeds.MaxItems <= 0 {
+		log.Fatal("FEED_MAX_ITEMS must be positive")
+	}
+
+	// Thumbnails and avatars are served by the server itself unless ASSET_URLS says
+	// otherwise; videos by their CDN unless VIDEO_URLS=presign
+	assetURLs, err := newAssetURLProvider(envString("ASSET_URLS", urlStrategyLocal), origin, os.Getenv("ASSET_URL_SECRET"), os.Getenv("ASSET_CDN_URL"))
+	if err != nil {
+		log.Fatalf("Invalid ASSET_URLS: %v", err)
+	}
+	urls := urlConfig{
+		Assets:      assetURLs,
+		AssetExpiry: envDuration("ASSET_URL_EXPIRY", 24*time.Hour),
+		VideoExpiry: envDuration("VIDEO_URL_EXPIRY", time.Hour),
+	}
+	if urls.AssetExpiry <= 0 {
+		log.Fatal("ASSET_URL_EXPIRY must be positive")
+	}
+	if urls.VideoExpiry <= 0 || urls.VideoExpiry > 7*24*time.Hour {
+		log.Fatal("VIDEO_URL_EXPIRY must be between 0 and 168h")
+	}
+	presignConcurrency := envInt("PRESIGN_CONCURRENCY", defaultPresignConcurrency)
+	if presignConcurrency <= 0 {
+		log.Fatal("PRESIGN_CONCURRENCY must be positive")
+	}
+
+	// A CDN may keep public reads of videos this long, and is told to drop them when videos change
+	cdnMaxAge := envDuration("CDN_CACHE_MAX_AGE", time.Minute)
+	if cdnMaxAge < 0 {
+		log.Fatal("CDN_CACHE_MAX_AGE can't be negative")
+	}
+	var cdnPurger *cdn.Purger
+	if purgeURL := os.Getenv("CDN_PURGE_URL"); purgeURL != "" {
+		cdnPurger = &cdn.Purger{
+			URL:        purgeURL,
+			Token:      os.Getenv("CDN_PURGE_TOKEN"),
+			HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	embeds := embedConfig{
+		TokenExpiry: envDuration("EMBED_TOKEN_EXPIRY", 10*time.Minute),
+		URLExpiry:   envDuration("EMBED_URL_EXPIRY", time.Hour),
+	}
+	if embeds.TokenExpiry <= 0 || embeds.URLExpiry <= 0 || embeds.URLExpiry > 7*24*time.Hour {
+		log.Fatal("EMBED_TOKEN_EXPIRY must be positive and EMBED_URL_EXPIRY between 0 and 168h")
+	}
+
+	hls := hlsProxyConfig{
+		Enabled:          envBool("HLS_PROXY", false),
+		ProxySegments:    envBool("HLS_PROXY_SEGMENTS", false),
+		SegmentURLExpiry: envDuration("HLS_SEGMENT_URL_EXPIRY", 15*time.Minute),
+	}
+	if hls.SegmentURLExpiry <= 0 || hls.SegmentURLExpiry > maxPresignExpiry {
+		log.Fatal("HLS_SEGMENT_URL_EXPIRY must be between 0 and 168h")
+	}
+	// Encrypted videos can only be played through the proxy, which hands out their keys
+	encryptHLS := envBool("HLS_ENCRYPTION", false)
+	if encryptHLS && !hls.Enabled {
+		log.Fatal("HLS_ENCRYPTION needs HLS_PROXY=true")
+	}
+
+	// Country lookups for videos restricted to some countries
+	var geoIP geoip.Provider
+	switch provider := os.Getenv("GEOIP_PROVIDER"); provider {
+	case "":
+	case "file":
+		geoIP, err = geoip.LoadRangeFile(os.Getenv("GEOIP_DATABASE"))
+		if err != nil {
+			log.Fatalf("Couldn't load GEOIP_DATABASE: %v", err)
+		}
+	case "header":
+		geoIP = geoip.Header{Name: envString("GEOIP_COUNTRY_HEADER", "CloudFront-Viewer-Country")}
+	default:
+		log.Fatalf("Unknown GEOIP_PROVIDER %q, expected file or header", provider)
+	}
+
+	// Read-only and maintenance modes can also be switched at runtime
+	serviceMode, err := newServiceMode(envString("SERVICE_MODE", serviceModeNormal), envDuration("SERVICE_MODE_RETRY_AFTER", 5*time.Minute))
+	if err != nil {
+		log.Fatalf("Invalid SERVICE_MODE: %v", err)
+	}
+	serviceMode.watchSignals()
+
+	// Load AWS SDK config, assuming an IAM role if one is configured
+	awsCfg, err := processing.LoadAWSConfig(context.Background(), s3Region, processing.AssumeRoleConfig{
+		RoleARN:     os.Getenv("AWS_ASSUME_ROLE_ARN"),
+		ExternalID:  os.Getenv("AWS_ASSUME_ROLE_EXTERNAL_ID"),
+		SessionName: envString("AWS_ASSUME_ROLE_SESSION_NAME", "tubely"),
+		Duration:    envDuration("AWS_ASSUME_ROLE_DURATION", time.Hour),
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
-	client := s3.NewFromConfig(awsCfg)
+	// Optionally inject faults into AWS and transcoder calls to rehearse outages
+	chaos := processing.Chaos{
+		ErrorRate:   envFloat("CHAOS_ERROR_RATE", 0),
+		LatencyRate: envFloat("CHAOS_LATENCY_RATE", 0),
+		MaxLatency:  envDuration("CHAOS_MAX_LATENCY", 0),
+	}
+	if err := chaos.Validate(); err != nil {
+		log.Fatalf("Invalid chaos settings: %v", err)
+	}
+	if chaos.Enabled() {
+		log.Printf("Chaos mode enabled: %.0f%% of calls fail, %.0f%% are delayed up to %s",
+			chaos.ErrorRate*100, chaos.LatencyRate*100, chaos.MaxLatency)
+	}
+	awsCfg.HTTPClient = chaos.WrapHTTPClient(awsCfg.HTTPClient)
+
+	// Buckets owned by another account are checked for their owner, and may bill requests to this one
+	bucketAccess := processing.BucketAccess{
+		ExpectedOwner: os.Getenv("S3_EXPECTED_BUCKET_OWNER"),
+		RequesterPays: envBool("S3_REQUESTER_PAYS", false),
+		ACL:           types.ObjectCannedACL(os.Getenv("S3_OBJECT_ACL")),
+	}
+	if err := bucketAccess.Validate(); err != nil {
+		log.Fatalf("Invalid bucket access settings: %v", err)
+	}
+
+	client := processing.NewS3Client(awsCfg, os.Getenv("S3_ENDPOINT"), bucketAccess)
+
+	// Only touch the bucket's own configuration when explicitly allowed to
+	if envBool("S3_MANAGE_BUCKET", false) {
+		setup := processing.BucketSetup{
+			S3Client:                client,
+			Bucket:                  s3Bucket,
+			AbortMultipartAfterDays: int32(envInt("S3_ABORT_INCOMPLETE_MULTIPART_DAYS", 7)),
+			CORSOrigins:             processing.ParseOrigins(os.Getenv("S3_CORS_ORIGINS")),
+			Versioning:              envBool("S3_VERSIONING", false),
+			DryRun:                  dryRunDefault,
+		}
+		if err := setup.Validate(); err != nil {
+			log.Fatalf("Invalid bucket settings: %v", err)
+		}
+		changes, err := setup.Apply(context.Background())
+		for _, change := range changes {
+			if setup.DryRun {
+				log.Printf("Bucket %s: would %s", s3Bucket, change)
+			} else {
+				log.Printf("Bucket %s: %s", s3Bucket, change)
+			}
+		}
+		if err != nil {
+			log.Fatalf("Couldn't configure bucket %s: %v", s3Bucket, err)
+		}
+	}
+
+	// Check the bucket lets the configured features work before anyone uploads
+	switch bucketCheckMode := envString("S3_BUCKET_CHECK", processing.BucketCheckWarn); bucketCheckMode {
+	case processing.BucketCheckOff:
+	case processing.BucketCheckWarn, processing.BucketCheckStrict:
+		check := processing.BucketCheck{
+			S3Client:            client,
+			Bucket:              s3Bucket,
+			DirectUploadOrigins: processing.ParseOrigins(os.Getenv("S3_CORS_ORIGINS")),
+			// Feeds link to unsigned URLs unless presigned, and HLS videos unless proxied
+			PublicReads: !feeds.PresignedURLs || !hls.Enabled,
+			ACL:         bucketAccess.ACL,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		problems := check.Check(ctx)
+		cancel()
+		for _, problem := range problems {
+			log.Printf("Bucket %s: %s", s3Bucket, problem)
+		}
+		if len(problems) > 0 && bucketCheckMode == processing.BucketCheckStrict {
+			log.Fatalf("Bucket %s isn't set up for the configured features; fix the problems above or set S3_BUCKET_CHECK=warn", s3Bucket)
+		}
+	default:
+		log.Fatalf("Invalid S3_BUCKET_CHECK %q, expected off, warn or strict", bucketCheckMode)
+	}
+
+	// S3-compatible stores may not read their own writes straight away, so stored
+	// videos are looked for before URLs are signed for them. AWS doesn't need it.
+	visibility := processing.VisibilityCheck{}
+	if envBool("S3_VERIFY_WRITES", os.Getenv("S3_ENDPOINT") != "") {
+		visibility.Attempts = envInt("S3_VERIFY_WRITE_ATTEMPTS", processing.DefaultVisibilityAttempts)
+		if visibility.Attempts < 1 {
+			log.Fatal("S3_VERIFY_WRITE_ATTEMPTS must be at least 1")
+		}
+	}
+
+	// Users' own buckets are reached by assuming the role each one registered
+	buckets := &processing.BucketClients{
+		DB:        db,
+		AWSConfig: awsCfg,
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		Default:   client,
+	}
+
+	// Select the transcoder backend for this deployment; with a webhook secret,
+	// MediaConvert reports back through /api/transcoder/callback
+	transcoderKind := envString("TRANSCODER", processing.TranscoderFFmpeg)
+	transcoderWebhookSecret := os.Getenv("TRANSCODER_WEBHOOK_SECRET")
+	transcoderCallbacks := transcoderWebhookSecret != "" && transcoderKind == processing.TranscoderMediaConvert
+	transcoder, err := processing.NewTranscoder(transcoderKind, awsCfg, processing.FFmpegConfig{
+		Output: envString("FFMPEG_OUTPUT", processing.FFmpegOutputFile),
+	}, processing.MediaConvertConfig{
+		Endpoint:     os.Getenv("MEDIACONVERT_ENDPOINT"),
+		RoleARN:      os.Getenv("MEDIACONVERT_ROLE_ARN"),
+		Queue:        os.Getenv("MEDIACONVERT_QUEUE"),
+		Bucket:       s3Bucket,
+		PollInterval: envDuration("MEDIACONVERT_POLL_INTERVAL", 10*time.Second),
+		Callbacks:    transcoderCallbacks,
+	})
+	if err != nil {
+		log.Fatalf("Couldn't set up transcoder: %v", err)
+	}
+	transcoder = chaos.WrapTranscoder(transcoder)
+
+	// ffmpeg and ffprobe invocations, optionally customized by a JSON file
+	commands, err := processing.LoadCommands(os.Getenv("FFMPEG_COMMANDS_FILE"))
+	if err != nil {
+		log.Fatalf("Couldn't load ffmpeg commands: %v", err)
+	}
+	processing.UseCommands(commands)
+
+	// Encode on the GPU when one is usable, falling back to software otherwise
+	accel, err := processing.DetectAccelerator(envString("HWACCEL", processing.HWAccelNone), os.Getenv("VAAPI_DEVICE"))
+	if err != nil {
+		log.Printf("Hardware acceleration unavailable, encoding in software: %v", err)
+	} else if accel.Name != "" {
+		log.Printf("Encoding with %s", accel.Encoder)
+	}
+	processing.UseAccelerator(accel)
+
+	// Large uploads interrupted by a crash carry on from their last part
+	processing.UseMultipartState(db)
+
+	// Processing profiles uploads can choose from, extended by an optional JSON file
+	profiles, err := processing.LoadProfiles(os.Getenv("PROCESSING_PROFILES_FILE"), os.Getenv("PROCESSING_DEFAULT_PROFILE"))
+	if err != nil {
+		log.Fatalf("Couldn't load processing profiles: %v", err)
+	}
+
+	notifier := &notify.Notifier{
+		DB:   db,
+		SMTP: smtpServer,
+	}
 
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		s3Client:		  client,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
+		db:                db,
+		jwtSecret:         jwtSecret,
+		platform:          platform,
+		s3Client:          client,
+		buckets:           buckets,
+		notifier:          notifier,
+		filepathRoot:      filepathRoot,
+		assetsRoot:        assetsRoot,
+		s3Bucket:          s3Bucket,
+		s3Region:          s3Region,
+		s3CfDistribution:  s3CfDistribution,
+		port:              port,
+		origin:            origin,
+		publicBaseURL:     publicBaseURL,
+		proxies:           proxies,
+		minVideoDuration:  minVideoDuration,
+		maxVideoDuration:  maxVideoDuration,
+		scratch:           scratch,
+		memoryUploadLimit: int64(memoryUploadLimit),
+		uploadLimit:       int64(uploadLimit),
+		clamav:            clamav,
+		processingMode:    processingMode,
+		stagingPrefix:     stagingPrefix,
+		keyTemplate:       keyTemplate,
+		processor: &processing.Processor{
+			DB:             db,
+			S3Client:       client,
+			S3Bucket:       s3Bucket,
+			CfDistribution: s3CfDistribution,
+			Scratch:        scratch,
+			StagingPrefix:  stagingPrefix,
+			Transcoder:     transcoder,
+			Profiles:       profiles,
+			Buckets:        buckets,
+			Notifier:       notifier,
+			Purger:         cdnPurger,
+			Retry:          retryPolicy,
+			EncryptHLS:     encryptHLS,
+			Visibility:     visibility,
+		},
+		profiles:           profiles,
+		adminAPIKey:        adminAPIKey,
+		dryRunDefault:      dryRunDefault,
+		hotlink:            hotlink,
+		feeds:              feeds,
+		urls:               urls,
+		presignConcurrency: presignConcurrency,
+		embeds:             embeds,
+		hls:                hls,
+		cdnMaxAge:          cdnMaxAge,
+		cdnPurger:          cdnPurger,
+		geoIP:              geoIP,
+		serviceMode:        serviceMode,
+		asyncTranscode:     transcoderCallbacks,
+		callbackSecret:     transcoderWebhookSecret,
+		deletionWebhookURL: os.Getenv("ACCOUNT_DELETION_WEBHOOK_URL"),
+		uploadTimeout:      uploadTimeout,
+		uploadExpiry:       uploadExpiry,
+		uploadLocks:        &uploadLocks{},
+		shareAttempts:      newAttemptLimiter(sharePasswordAttempts, sharePasswordWindow),
+	}
+
+	cfg.urls.Videos, err = newVideoURLProvider(envString("VIDEO_URLS", urlStrategyCDN), &cfg)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_URLS: %v", err)
+	}
+
+	notifier.VideoURL = cfg.getVideoPageURL
+
+	// Emails forwarded by Mailgun or SES become uploads for the users who sent them
+	if key := os.Getenv("INBOUND_EMAIL_MAILGUN_SIGNING_KEY"); key != "" {
+		cfg.mailgun = &inbound.Mailgun{SigningKey: key, MaxAge: mailgunSignatureMaxAge}
+	}
+	if topicArn := os.Getenv("INBOUND_EMAIL_SES_TOPIC_ARN"); topicArn != "" {
+		cfg.ses = &inbound.SES{
+			TopicArn:   topicArn,
+			Fetch:      cfg.fetchInboundEmail,
+			HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	// Stripe subscription events move users between plans
+	if secret := os.Getenv("STRIPE_WEBHOOK_SECRET"); secret != "" {
+		cfg.stripe = &billing.Stripe{WebhookSecret: secret, Tolerance: stripeSignatureTolerance}
+	}
+
+	// Stages uploads go through on their way to a processing job, optionally from a JSON file
+	ingestStages, err := ingest.LoadStages(os.Getenv("INGEST_STAGES_FILE"))
+	if err != nil {
+		log.Fatalf("Couldn't load ingest stages: %v", err)
+	}
+	cfg.ingest, err = cfg.newIngestPipeline(ingestStages)
+	if err != nil {
+		log.Fatalf("Invalid ingest stages: %v", err)
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -104,33 +711,294 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	// Exports are rebuilt on request rather than resumed
+	if err := cfg.db.FailInterruptedExports(); err != nil {
+		log.Fatalf("Couldn't clean up interrupted exports: %v", err)
+	}
+	// Bulk operations aren't resumed either, since their videos may have
+	// changed since; their reports show which videos to send again
+	if err := cfg.db.FailInterruptedBulkOperations(); err != nil {
+		log.Fatalf("Couldn't clean up interrupted bulk operations: %v", err)
+	}
+
+	if scratchMaxAge > 0 {
+		cfg.watchdog = &processing.ScratchWatchdog{
+			Scratch:  cfg.scratch,
+			DB:       cfg.db,
+			MaxAge:   scratchMaxAge,
+			Interval: scratchSweepInterval,
+		}
+		go cfg.watchdog.Run(context.Background())
+	}
+
+	if multipartUploadTTL > 0 {
+		sweeper := &processing.MultipartSweeper{
+			DB:       cfg.db,
+			S3Client: cfg.s3Client,
+			Buckets:  cfg.buckets,
+			Bucket:   cfg.s3Bucket,
+			MaxAge:   multipartUploadTTL,
+			Interval: multipartSweepInterval,
+		}
+		go sweeper.Run(context.Background())
+	}
+
+	if fixityInterval > 0 {
+		cfg.fixity = &processing.FixityChecker{
+			DB:         cfg.db,
+			S3Client:   cfg.s3Client,
+			SampleSize: fixitySampleSize,
+			Interval:   fixityInterval,
+			Buckets:    cfg.buckets,
+		}
+		go cfg.fixity.Run(context.Background())
+	}
+
+	cfg.reconciler = &processing.Reconciler{
+		DB:       cfg.db,
+		S3Client: cfg.s3Client,
+		Buckets:  cfg.buckets,
+		Defaults: processing.Storage{
+			Bucket:  cfg.s3Bucket,
+			BaseURL: cfg.s3CfDistribution,
+		},
+		Inventory: inventory,
+		// Staged uploads, candidate frames and exports are cleaned up where they're made
+		Exclude:     []string{stagingPrefix + "/", framesPrefix + "/", exportsPrefix + "/"},
+		MinAge:      envDuration("RECONCILE_MIN_AGE", 24*time.Hour),
+		MaxFindings: maxReconcileFindings,
+		Interval:    reconcileInterval,
+		Repairs:     reconcileRepairs,
+	}
+	if reconcileInterval > 0 {
+		go cfg.reconciler.Run(context.Background())
+	}
+
+	if thumbnailRetention > 0 {
+		go cfg.pruneThumbnailHistory(context.Background(), thumbnailRetention, time.Hour)
+	}
+
+	go cfg.pruneUploadSessions(context.Background(), time.Hour)
+
+	// Restores from cold storage take minutes to hours, so they're checked on in the background
+	if restorePollInterval := envDuration("ARCHIVE_RESTORE_POLL_INTERVAL", 15*time.Minute); restorePollInterval > 0 {
+		go cfg.pollVideoRestores(context.Background(), restorePollInterval)
+	}
+
+	// The SFTP gateway is off unless it's given an address to listen on
+	if sftpAddr := os.Getenv("SFTP_ADDR"); sftpAddr != "" {
+		cfg.sftp, err = cfg.newSFTPGateway(
+			envString("SFTP_HOST_KEY_FILE", "sftp_host_key"),
+			envString("SFTP_ROOT", filepath.Join(os.TempDir(), "tubely-sftp")),
+		)
+		if err != nil {
+			log.Fatalf("Couldn't set up SFTP gateway: %v", err)
+		}
+		go cfg.sftp.pruneFiles(context.Background(), uploadExpiry, time.Hour)
+		go func() { log.Fatal(cfg.sftp.ListenAndServe(sftpAddr)) }()
+	}
+
+	// Files dropped in WATCH_DIR become videos of WATCH_USER
+	if watchDir := os.Getenv("WATCH_DIR"); watchDir != "" {
+		watchFolder, err := cfg.newWatchFolder(watchDir, os.Getenv("WATCH_USER"), envDuration("WATCH_SETTLE", 10*time.Second))
+		if err != nil {
+			log.Fatalf("Couldn't watch %s: %v", watchDir, err)
+		}
+		go watchFolder.Run(context.Background())
+	}
+
+	// Finish deleting any accounts whose purge was interrupted
+	go cfg.resumeUserPurges(context.Background())
+
+	// Pick up any processing jobs interrupted by the last shutdown; in worker
+	// mode the workers reclaim them instead
+	if cfg.processingMode == processingModeInline {
+		go cfg.processor.ResumeIncomplete(context.Background())
+	} else {
+		go cfg.retryStaleJobs(context.Background(), workerStaleAfter)
+	}
+
 	mux := http.NewServeMux()
+	api := &apiRouter{mux: mux, sunset: unversionedAPISunset}
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	// Signed asset URLs are checked before the file is served
+	if local, ok := cfg.urls.Assets.(localURLs); ok && local.secret != nil {
+		assetsHandler = local.middleware(assetsHandler)
+	}
+	mux.Handle("/assets/", noCacheMiddleware(cfg.hotlink.middleware(assetsHandler)))
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.Handle("GET /upload/", uploaderHandler())
+	mux.Handle("GET /docs/", apiDocsHandler())
+	api.HandleFunc("GET /api/openapi.json", cfg.handlerOpenAPI(api))
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	api.HandleFunc("POST /api/login", cfg.handlerLogin)
+	api.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	api.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	api.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	api.HandleFunc("DELETE /api/users/me", cfg.handlerUserDelete)
+	api.HandleFunc("PUT /api/users/me/avatar", validateUpload(thumbnailUploadLimit, nil, cfg.handlerUserAvatarUpload))
+	api.HandleFunc("DELETE /api/users/me/avatar", cfg.handlerUserAvatarDelete)
+	api.HandleFunc("POST /api/users/me/export", cfg.handlerUserExportCreate)
+	api.HandleFunc("GET /api/users/me/usage", cfg.handlerUserUsage)
+	api.HandleFunc("GET /api/users/me/plan", cfg.handlerUserPlan)
+	api.HandleFunc("GET /api/users/me/bucket", cfg.handlerUserBucketGet)
+	api.HandleFunc("PUT /api/users/me/bucket", cfg.handlerUserBucketSet)
+	api.HandleFunc("DELETE /api/users/me/bucket", cfg.handlerUserBucketDelete)
+	api.HandleFunc("GET /api/users/me/notifications", cfg.handlerNotificationSettingsGet)
+	api.HandleFunc("PUT /api/users/me/notifications", cfg.handlerNotificationSettingsSet)
+	api.HandleFunc("GET /api/users/me/defaults", cfg.handlerUploadDefaultsGet)
+	api.HandleFunc("PUT /api/users/me/defaults", cfg.handlerUploadDefaultsSet)
+	api.HandleFunc("POST /api/users/me/notifications/test", cfg.handlerNotificationTest)
+	api.HandleFunc("GET /api/users/me/api-keys", cfg.handlerAPIKeysList)
+	api.HandleFunc("POST /api/users/me/api-keys", cfg.handlerAPIKeyCreate)
+	api.HandleFunc("DELETE /api/users/me/api-keys/{keyID}", cfg.handlerAPIKeyDelete)
+	api.HandleFunc("GET /api/exports/{exportID}", cfg.handlerExportGet)
+	api.HandleFunc("POST /api/imports/youtube-takeout", validateUpload(maxTakeoutArchiveSize, nil, cfg.handlerImportYouTubeTakeout))
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	api.HandleFunc("GET /api/profiles", cfg.handlerProfilesList)
+	api.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
+	api.HandleFunc("POST /api/thumbnail_upload/{videoID}", validateUpload(thumbnailUploadLimit, []string{"videoID"}, cfg.handlerUploadThumbnail))
+	api.HandleFunc("POST /api/video_upload/{videoID}", validateUpload(processing.MaxUploadStreamSize, []string{"videoID"}, cfg.handlerUploadVideo))
+	api.HandleFunc("POST /api/videos/{videoID}/media", validateUpload(processing.MaxUploadStreamSize+thumbnailUploadLimit, []string{"videoID"}, cfg.handlerUploadMedia))
+	api.HandleFunc("POST /api/videos/{videoID}/uploads", cfg.handlerUploadSessionCreate)
+	api.HandleFunc("GET /api/uploads/{uploadID}", cfg.handlerUploadSessionGet)
+	api.HandleFunc("PATCH /api/uploads/{uploadID}", cfg.handlerUploadSessionAppend)
+	api.HandleFunc("POST /api/uploads/{uploadID}/complete", cfg.handlerUploadSessionComplete)
+	api.HandleFunc("DELETE /api/uploads/{uploadID}", cfg.handlerUploadSessionDelete)
+	api.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	api.HandleFunc("GET /api/videos/duplicates", cfg.handlerVideoDuplicates)
+	api.HandleFunc("GET /api/videos/export", cfg.handlerVideosExport)
+	api.HandleFunc("GET /api/videos/by-external-id", cfg.handlerVideoByExternalID)
+	api.HandleFunc("POST /api/videos/bulk", cfg.handlerVideosBulk)
+	api.HandleFunc("GET /api/bulk/{operationID}", cfg.handlerBulkOperationGet)
+	api.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	api.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	api.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	api.HandleFunc("POST /api/videos/{videoID}/chapters", cfg.handlerChaptersCreate)
+	api.HandleFunc("GET /api/videos/{videoID}/chapters", cfg.handlerChaptersList)
+	api.HandleFunc("DELETE /api/videos/{videoID}/chapters/{chapterID}", cfg.handlerChapterDelete)
+	api.HandleFunc("POST /api/videos/{videoID}/comments", cfg.handlerCommentsCreate)
+	api.HandleFunc("GET /api/videos/{videoID}/comments", cfg.handlerCommentsList)
+	api.HandleFunc("PATCH /api/comments/{commentID}", cfg.handlerCommentUpdate)
+	api.HandleFunc("DELETE /api/comments/{commentID}", cfg.handlerCommentDelete)
+	api.HandleFunc("PUT /api/videos/{videoID}/like", cfg.handlerVideoLike)
+	api.HandleFunc("DELETE /api/videos/{videoID}/like", cfg.handlerVideoUnlike)
+	api.HandleFunc("GET /api/users/me/likes", cfg.handlerLikedVideos)
+	api.HandleFunc("GET /api/users/{userID}/feed.rss", cfg.handlerUserFeedRSS)
+	api.HandleFunc("GET /api/users/{userID}/feed.atom", cfg.handlerUserFeedAtom)
+	api.HandleFunc("GET /api/users/{userID}/podcast.rss", cfg.handlerUserPodcast)
+	mux.HandleFunc("GET /v/{videoID}", cfg.handlerVideoPage)
+	api.HandleUnversionedFunc("GET /oembed", cfg.handlerOEmbed)
+	mux.HandleFunc("GET /embed/{videoID}", cfg.handlerEmbedPlayer)
+	api.HandleFunc("GET /api/embed/{videoID}/playback", cfg.handlerEmbedPlayback)
+	api.HandleFunc("POST /api/embed/{videoID}/token", cfg.handlerEmbedTokenRefresh)
+	api.HandleFunc("GET /api/hls/{token}/master.m3u8", cfg.handlerHLSMasterPlaylist)
+	api.HandleFunc("GET /api/hls/{token}/key", cfg.handlerHLSKey)
+	api.HandleFunc("GET /api/hls/{token}/{rendition}/{file}", cfg.handlerHLSFile)
+	api.HandleFunc("GET /api/videos/{videoID}/embed", cfg.handlerVideoEmbedGet)
+	api.HandleFunc("PUT /api/videos/{videoID}/embed", cfg.handlerVideoEmbedUpdate)
+	api.HandleFunc("GET /api/videos/{videoID}/access", cfg.handlerVideoAccessGet)
+	api.HandleFunc("PUT /api/videos/{videoID}/access", cfg.handlerVideoAccessUpdate)
+	api.HandleFunc("POST /api/videos/{videoID}/archive", cfg.handlerVideoArchive)
+	api.HandleFunc("GET /api/videos/{videoID}/archive", cfg.handlerVideoArchiveGet)
+	api.HandleFunc("POST /api/videos/{videoID}/restore", cfg.handlerVideoRestore)
+	api.HandleFunc("POST /api/videos/{videoID}/share", cfg.handlerShareLinkCreate)
+	api.HandleFunc("GET /api/videos/{videoID}/share", cfg.handlerShareLinksList)
+	api.HandleFunc("DELETE /api/videos/{videoID}/share/{shareID}", cfg.handlerShareLinkDelete)
+	api.HandleFunc("GET /api/share/{token}", cfg.handlerShareLinkResolve)
+	mux.HandleFunc("GET /s/{token}", cfg.handlerSharePage)
+	api.HandleFunc("GET /api/users/me/watch-later", cfg.handlerWatchLaterList)
+	api.HandleFunc("PUT /api/users/me/watch-later/{videoID}", cfg.handlerWatchLaterAdd)
+	api.HandleFunc("DELETE /api/users/me/watch-later/{videoID}", cfg.handlerWatchLaterRemove)
+	api.HandleFunc("GET /api/videos/{videoID}/download", cfg.handlerVideoDownload)
+	api.HandleFunc("GET /api/videos/{videoID}/metadata", cfg.handlerVideoMetadataGet)
+	api.HandleFunc("PUT /api/videos/{videoID}/metadata", cfg.handlerVideoMetadataReplace)
+	api.HandleFunc("PUT /api/videos/{videoID}/metadata/{key}", cfg.handlerVideoMetadataSet)
+	api.HandleFunc("DELETE /api/videos/{videoID}/metadata/{key}", cfg.handlerVideoMetadataDelete)
+	api.HandleFunc("GET /api/videos/{videoID}/probe", cfg.handlerVideoProbe)
+	api.HandleFunc("GET /api/videos/{videoID}/frames", cfg.handlerVideoFrames)
+	api.HandleFunc("POST /api/videos/{videoID}/thumbnail/from-frame", cfg.handlerThumbnailFromFrame)
+	api.HandleFunc("GET /api/videos/{videoID}/thumbnails", cfg.handlerThumbnailsList)
+	api.HandleFunc("POST /api/videos/{videoID}/thumbnails/{thumbnailID}/revert", cfg.handlerThumbnailRevert)
+	api.HandleFunc("POST /api/videos/{videoID}/clips", cfg.handlerClipCreate)
+	api.HandleFunc("GET /api/videos/{videoID}/clips", cfg.handlerClipsList)
+	api.HandleFunc("GET /api/clips/{token}", cfg.handlerClipResolve)
+	api.HandleFunc("GET /api/play/{nonce}", cfg.handlerPlaybackLink)
+	api.HandleFunc("DELETE /api/clips/{clipID}", cfg.handlerClipDelete)
+
+	api.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
+	api.HandleFunc("DELETE /api/jobs/{jobID}", cfg.handlerJobCancel)
+	api.HandleFunc("POST /api/transcoder/callback", cfg.handlerTranscoderCallback)
+	api.HandleFunc("POST /api/inbound-email/mailgun", cfg.handlerInboundEmailMailgun)
+	api.HandleFunc("POST /api/inbound-email/ses", cfg.handlerInboundEmailSES)
+	api.HandleFunc("POST /api/billing/stripe", cfg.handlerStripeWebhook)
+
+	api.HandleUnversionedFunc("POST /admin/reset", cfg.handlerReset)
+	api.HandleUnversionedFunc("GET /admin/disk", cfg.handlerAdminDisk)
+	api.HandleUnversionedFunc("GET /admin/compliance", cfg.handlerAdminCompliance)
+	api.HandleUnversionedFunc("GET /admin/fixity", cfg.handlerAdminFixity)
+	api.HandleUnversionedFunc("POST /admin/fixity/run", cfg.handlerAdminFixityRun)
+	api.HandleUnversionedFunc("GET /admin/reconcile", cfg.handlerAdminReconcile)
+	api.HandleUnversionedFunc("POST /admin/reconcile/run", cfg.handlerAdminReconcileRun)
+	api.HandleUnversionedFunc("GET /admin/mode", cfg.handlerAdminModeGet)
+	api.HandleUnversionedFunc("GET /admin/pipeline", cfg.handlerAdminPipeline)
+	api.HandleUnversionedFunc("GET /admin/queue", cfg.handlerAdminQueue)
+	api.HandleUnversionedFunc("GET /admin/dead-letters", cfg.handlerAdminDeadLettersList)
+	api.HandleUnversionedFunc("GET /admin/dead-letters/{deadLetterID}", cfg.handlerAdminDeadLetterGet)
+	api.HandleUnversionedFunc("POST /admin/dead-letters/{deadLetterID}/retry", cfg.handlerAdminDeadLetterRetry)
+	api.HandleUnversionedFunc("DELETE /admin/dead-letters/{deadLetterID}", cfg.handlerAdminDeadLetterDelete)
+	api.HandleUnversionedFunc("PUT /admin/mode", cfg.handlerAdminModeSet)
+	api.HandleUnversionedFunc("GET /admin/probes/{sha256}", cfg.handlerAdminProbeGet)
+	api.HandleUnversionedFunc("GET /admin/videos/{videoID}/probe", cfg.handlerAdminVideoProbeGet)
+	api.HandleUnversionedFunc("GET /admin/videos/{videoID}/compliance", cfg.handlerAdminVideoCompliance)
+	api.HandleUnversionedFunc("POST /admin/tenants", cfg.handlerAdminTenantCreate)
+	api.HandleUnversionedFunc("GET /admin/tenants", cfg.handlerAdminTenantsList)
+	api.HandleUnversionedFunc("GET /admin/usage.csv", cfg.handlerAdminUsageExport)
+	api.HandleUnversionedFunc("PUT /admin/users/{userID}/tenant", cfg.handlerAdminUserTenantSet)
+	api.HandleUnversionedFunc("GET /admin/plans", cfg.handlerAdminPlansList)
+	api.HandleUnversionedFunc("PUT /admin/plans/{plan}", cfg.handlerAdminPlanSave)
+	api.HandleUnversionedFunc("DELETE /admin/plans/{plan}", cfg.handlerAdminPlanDelete)
+	api.HandleUnversionedFunc("PUT /admin/users/{userID}/plan", cfg.handlerAdminUserPlanSet)
+	api.HandleUnversionedFunc("GET /admin/defaults", cfg.handlerAdminUploadDefaultsGet)
+	api.HandleUnversionedFunc("PUT /admin/defaults", cfg.handlerAdminUploadDefaultsSet)
+
+	handler := cfg.serviceMode.middleware(cfg.rejectDeletedUsers(mux))
+	// Off when a proxy in front of the server compresses responses already
+	if envBool("RESPONSE_COMPRESSION", true) {
+		handler = compressionMiddleware(handler)
+	}
+
+	// Panics are answered with a 500, and reported to Sentry when it's set up
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		cfg.errorReporter, err = errreport.NewSentry(dsn)
+		if err != nil {
+			log.Fatalf("Invalid SENTRY_DSN: %v", err)
+		}
+		cfg.errorReporter.Environment = os.Getenv("SENTRY_ENVIRONMENT")
+	}
+	handler = cfg.recoverMiddleware(handler)
+
+	// Busy paths are only logged in part
+	logSampling, err := parseLogSampling(envString("REQUEST_LOG_SAMPLE_RATES", defaultLogSampleRates))
+	if err != nil {
+		log.Fatalf("Invalid REQUEST_LOG_SAMPLE_RATES: %v", err)
+	}
+	handler = requestLogMiddleware(logSampling, handler)
 
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:              ":" + port,
+		Handler:           requestIDMiddleware(handler),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       readTimeout,
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	log.Printf("Serving on: %s/app/\n", cfg.origin)
+	if serverTLS.http3 {
+		log.Printf("Serving HTTP/3 on UDP port %s\n", port)
+	}
+	log.Fatal(serve(srv, serverTLS))
 }
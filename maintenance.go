@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service modes: read-only rejects anything that would change stored videos
+// while still serving metadata and presigned URLs, maintenance rejects
+// everything but the admin API
+const (
+	serviceModeNormal      = "normal"
+	serviceModeReadOnly    = "read-only"
+	serviceModeMaintenance = "maintenance"
+)
+
+// serviceMode is the server's current mode, switched at runtime through the
+// admin API or a signal
+type serviceMode struct {
+	mu         sync.RWMutex
+	mode       string
+	reason     string
+	since      time.Time
+	retryAfter time.Duration
+}
+
+// serviceModeStatus is the JSON form of the current mode
+type serviceModeStatus struct {
+	Mode       string    `json:"mode"`
+	Reason     string    `json:"reason,omitempty"`
+	Since      time.Time `json:"since"`
+	RetryAfter string    `json:"retry_after"`
+}
+
+// Function to check that a mode name is one the server knows
+func validServiceMode(mode string) error {
+	switch mode {
+	case serviceModeNormal, serviceModeReadOnly, serviceModeMaintenance:
+		return nil
+	default:
+		return fmt.Errorf("must be %q, %q or %q", serviceModeNormal, serviceModeReadOnly, serviceModeMaintenance)
+	}
+}
+
+// Function to create a serviceMode starting in the given mode
+func newServiceMode(mode string, retryAfter time.Duration) (*serviceMode, error) {
+	if err := validServiceMode(mode); err != nil {
+		return nil, err
+	}
+	return &serviceMode{mode: mode, since: time.Now().UTC(), retryAfter: retryAfter}, nil
+}
+
+// Function to switch modes, logging the change
+func (m *serviceMode) set(mode, reason string) error {
+	if err := validServiceMode(mode); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mode != mode {
+		m.since = time.Now().UTC()
+	}
+	m.mode = mode
+	m.reason = reason
+	if reason != "" {
+		log.Printf("Service mode is now %s: %s", mode, reason)
+	} else {
+		log.Printf("Service mode is now %s", mode)
+	}
+	return nil
+}
+
+// Function to switch to the given mode, or back to normal if it's already on
+func (m *serviceMode) toggle(mode, reason string) {
+	next := mode
+	if m.status().Mode == mode {
+		next = serviceModeNormal
+		reason = ""
+	}
+	if err := m.set(next, reason); err != nil {
+		log.Printf("Couldn't switch service mode: %v", err)
+	}
+}
+
+// Function to return the current mode
+func (m *serviceMode) status() serviceModeStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return serviceModeStatus{
+		Mode:       m.mode,
+		Reason:     m.reason,
+		Since:      m.since,
+		RetryAfter: m.retryAfter.String(),
+	}
+}
+
+// Function to report whether storage should be left alone, pausing background cleanup
+func (m *serviceMode) frozen() bool {
+	return m.status().Mode != serviceModeNormal
+}
+
+// Function to report whether the current mode rejects a request
+func (m *serviceMode) rejects(r *http.Request) (bool, string) {
+	status := m.status()
+
+	// The admin API stays up so the mode can be switched back
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		return false, ""
+	}
+
+	switch status.Mode {
+	case serviceModeMaintenance:
+		return true, status.Mode
+	case serviceModeReadOnly:
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			return false, ""
+		}
+		// Sessions don't touch storage, so logging in keeps working
+		switch unversionedAPIPath(r.URL.Path) {
+		case "/api/login", "/api/refresh", "/api/revoke":
+			return false, ""
+		}
+		return true, status.Mode
+	default:
+		return false, ""
+	}
+}
+
+// Function to reject requests the current mode doesn't allow with a 503 and a Retry-After
+func (m *serviceMode) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejected, mode := m.rejects(r)
+		if !rejected {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		m.mu.RLock()
+		retryAfter := m.retryAfter
+		m.mu.RUnlock()
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second).Seconds())))
+		}
+		if mode == serviceModeMaintenance {
+			respondWithErrorCode(w, errCodeMaintenance, "Tubely is down for maintenance", nil, nil)
+			return
+		}
+		respondWithErrorCode(w, errCodeReadOnly, "Tubely is read-only for now, uploads and edits are paused", nil, nil)
+	})
+}
+
+func (cfg *apiConfig) handlerAdminModeGet(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.serviceMode.status())
+}
+
+func (cfg *apiConfig) handlerAdminModeSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Mode   string `json:"mode"`
+		Reason string `json:"reason"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := cfg.serviceMode.set(params.Mode, params.Reason); err != nil {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid mode", err, []fieldError{
+			{Field: "mode", Message: err.Error()},
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.serviceMode.status())
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// watchSignals does nothing on this platform, which has no user signals; use
+// the admin API to switch modes instead.
+func (m *serviceMode) watchSignals() {}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSignals toggles read-only mode on SIGUSR1 and maintenance mode on
+// SIGUSR2, for switching modes from scripts without the admin API.
+func (m *serviceMode) watchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				m.toggle(serviceModeReadOnly, "toggled by SIGUSR1")
+			} else {
+				m.toggle(serviceModeMaintenance, "toggled by SIGUSR2")
+			}
+		}
+	}()
+}
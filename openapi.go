@@ -0,0 +1,409 @@
+package main
+
+import (
+	"embed"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Swagger UI is compiled into the binary like the uploader; it loads the
+// viewer itself from a CDN and points it at the OpenAPI document
+//
+//go:embed apidocs
+var apiDocsFiles embed.FS
+
+// How callers authenticate with an operation
+const (
+	authNone    = ""
+	authBearer  = "bearer"
+	authRefresh = "refresh"
+	authAdmin   = "admin"
+)
+
+// apiOperation documents one route in the OpenAPI document. Request and
+// Response are example values, described from their types.
+type apiOperation struct {
+	Summary string
+	Tag     string
+	Auth    string
+	Query   []apiParam
+	Headers []apiParam
+	// Request is the JSON body, nil when there is none
+	Request any
+	// RequestType is set for request bodies of raw bytes instead of JSON
+	RequestType string
+	// Upload describes a multipart/form-data body instead of a JSON one
+	Upload *apiUpload
+	// Status is the success status, 200 unless set
+	Status int
+	// Response is the data of the JSON envelope on success, nil when the body
+	// is empty. GET responses of slices are documented as lists.
+	Response any
+	// Raw is set for JSON responses sent as they are, without an envelope
+	Raw bool
+	// ContentType is set for successful responses that aren't JSON
+	ContentType string
+}
+
+// apiParam documents a query parameter or header
+type apiParam struct {
+	Name        string
+	Description string
+	// Type is a JSON schema type, string unless set
+	Type string
+	// Optional is set for headers that can be left out; query parameters always can
+	Optional bool
+}
+
+// apiUpload documents a multipart upload
+type apiUpload struct {
+	// Limit is the most bytes the whole body may have
+	Limit int64
+	// PerPlan is set when the uploader's plan can raise Limit
+	PerPlan bool
+	Fields  []apiUploadField
+}
+
+// apiUploadField documents one part of a multipart upload
+type apiUploadField struct {
+	Name        string
+	Description string
+	File        bool
+	Required    bool
+}
+
+// Path wildcards such as {videoID}
+var pathParamPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// Function to serve the OpenAPI document for the routes registered on api
+func (cfg *apiConfig) handlerOpenAPI(api *apiRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, api.openAPI(cfg.getRequestOrigin(r)))
+	}
+}
+
+// Function to serve Swagger UI
+func apiDocsHandler() http.Handler {
+	files, err := fs.Sub(apiDocsFiles, "apidocs")
+	if err != nil {
+		// The embedded directory is fixed at build time, so this can't fail
+		panic(err)
+	}
+	return http.StripPrefix("/docs", http.FileServerFS(files))
+}
+
+// Function to build an OpenAPI 3 document describing every documented route
+func (a *apiRouter) openAPI(serverOrigin string) map[string]any {
+	paths := map[string]map[string]any{}
+	for _, route := range a.routes {
+		method, path, _ := strings.Cut(route.pattern, " ")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = route.doc.operation(method, path)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Tubely API",
+			"version": "2",
+			"description": "Uploads, processing and playback of videos. JSON responses are wrapped in an envelope: " +
+				"{data, request_id}, and lists add next_cursor and total. /api/v1 has the same routes with the bodies " +
+				"unwrapped, and the unversioned /api paths are deprecated aliases of it; neither is listed. " +
+				"Errors share one body whose code is stable across releases.",
+		},
+		"servers": []map[string]any{{"url": serverOrigin}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Error": errorSchema(),
+			},
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": errorCodesDescription(),
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+					},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{
+					"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+					"description": "Access token from POST /api/v2/login",
+				},
+				"refresh": map[string]any{
+					"type": "http", "scheme": "bearer",
+					"description": "Refresh token from POST /api/v2/login",
+				},
+				"admin": map[string]any{
+					"type": "apiKey", "in": "header", "name": "Authorization",
+					"description": "ApiKey followed by the server's ADMIN_API_KEY",
+				},
+			},
+		},
+	}
+}
+
+// Function to describe the operation as an OpenAPI operation object
+func (op apiOperation) operation(method, path string) map[string]any {
+	operation := map[string]any{
+		"summary": op.Summary,
+		"tags":    []string{op.Tag},
+	}
+
+	params := []map[string]any{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		schema := map[string]any{"type": "string"}
+		if strings.HasSuffix(match[1], "ID") {
+			schema["format"] = "uuid"
+		}
+		params = append(params, map[string]any{"name": match[1], "in": "path", "required": true, "schema": schema})
+	}
+	for _, param := range op.Query {
+		params = append(params, param.parameter("query"))
+	}
+	for _, param := range op.Headers {
+		params = append(params, param.parameter("header"))
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+
+	if op.Auth != authNone {
+		operation["security"] = []map[string][]string{{op.Auth: {}}}
+	}
+
+	switch {
+	case op.Upload != nil:
+		operation["requestBody"] = op.Upload.requestBody()
+	case op.RequestType != "":
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				op.RequestType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			},
+		}
+	case op.Request != nil:
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case op.ContentType != "":
+		success["content"] = map[string]any{op.ContentType: map[string]any{}}
+	case op.Response != nil:
+		success["content"] = map[string]any{
+			"application/json": map[string]any{"schema": op.responseSchema(method)},
+		}
+	}
+	operation["responses"] = map[string]any{
+		fmt.Sprint(status): success,
+		"default":          map[string]any{"$ref": "#/components/responses/Error"},
+	}
+	return operation
+}
+
+// Function to describe the successful response body, wrapping the response in
+// the envelope it's sent in
+func (op apiOperation) responseSchema(method string) map[string]any {
+	t := reflect.TypeOf(op.Response)
+	if op.Raw {
+		return schemaFor(t)
+	}
+	envelopeType := reflect.TypeOf(envelope{})
+	if method == http.MethodGet && t.Kind() == reflect.Slice {
+		envelopeType = reflect.TypeOf(listEnvelope{})
+	}
+	schema := schemaFor(envelopeType)
+	schema["properties"].(map[string]any)["data"] = schemaFor(t)
+	return schema
+}
+
+// Function to describe the parameter as an OpenAPI parameter object
+func (p apiParam) parameter(in string) map[string]any {
+	paramType := p.Type
+	if paramType == "" {
+		paramType = "string"
+	}
+	parameter := map[string]any{
+		"name": p.Name, "in": in, "description": p.Description,
+		"schema": map[string]any{"type": paramType},
+	}
+	if in == "header" {
+		parameter["required"] = !p.Optional
+	}
+	return parameter
+}
+
+// Function to describe a multipart upload as an OpenAPI request body
+func (u apiUpload) requestBody() map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for _, field := range u.Fields {
+		schema := map[string]any{"type": "string", "description": field.Description}
+		if field.File {
+			schema["format"] = "binary"
+		}
+		properties[field.Name] = schema
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	limit := fmt.Sprintf("At most %d bytes in all.", u.Limit)
+	if u.PerPlan {
+		limit = fmt.Sprintf("At most %d bytes in all, or more if the uploader's plan allows it.", u.Limit)
+	}
+	return map[string]any{
+		"required": true,
+		"description": limit + " Content-Length is required, and the body is " +
+			"rejected before it's read if it's over the limit.",
+		"content": map[string]any{"multipart/form-data": map[string]any{"schema": schema}},
+	}
+}
+
+// Function to describe the error body, listing every error code
+func errorSchema() map[string]any {
+	schema := schemaFor(reflect.TypeOf(apiError{}))
+	codes := []string{}
+	for code := range errorCodeStatus {
+		codes = append(codes, string(code))
+	}
+	slices.Sort(codes)
+	schema["properties"].(map[string]any)["code"] = map[string]any{"type": "string", "enum": codes}
+	return schema
+}
+
+// Function to list the error codes with the status each is sent with
+func errorCodesDescription() string {
+	codes := []string{}
+	for code, status := range errorCodeStatus {
+		codes = append(codes, fmt.Sprintf("- `%s`: %d", code, status))
+	}
+	slices.Sort(codes)
+	return "Error, with one of these codes:\n\n" + strings.Join(codes, "\n")
+}
+
+// Types described by their JSON encoding rather than their fields
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	uuidType     = reflect.TypeOf(uuid.UUID{})
+	nullUUIDType = reflect.TypeOf(uuid.NullUUID{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Function to describe a Go type as the JSON schema of its encoding
+func schemaFor(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]any{"type": "string", "format": "uuid"}
+	case nullUUIDType:
+		return map[string]any{"type": "string", "format": "uuid", "nullable": true}
+	case rawJSONType:
+		return map[string]any{}
+	}
+	// Other types that encode themselves can't be described from their fields
+	if t.Implements(jsonMarshalerType) {
+		return map[string]any{}
+	}
+	if t.Implements(textMarshalerType) {
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaFor(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		addStructFields(t, properties)
+		return map[string]any{"type": "object", "properties": properties}
+	default:
+		return map[string]any{}
+	}
+}
+
+// Function to add the JSON fields of a struct to properties, including those
+// of embedded structs the way encoding/json promotes them
+func addStructFields(t reflect.Type, properties map[string]any) {
+	// Fields of the struct itself win over promoted ones of the same name
+	promoted := map[string]any{}
+	defer func() {
+		for name, schema := range promoted {
+			if _, ok := properties[name]; !ok {
+				properties[name] = schema
+			}
+		}
+	}()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addStructFields(embedded, promoted)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Host names, IPv4 addresses and bracketed IPv6 addresses, with an optional port
+var hostPattern = regexp.MustCompile(`^(\[[0-9a-fA-F:.]+\]|[A-Za-z0-9.-]+)(:[0-9]{1,5})?$`)
+
+// Function to parse PUBLIC_BASE_URL, the origin clients reach the server at
+// when that's not where it listens, e.g. behind a reverse proxy
+func parsePublicBaseURL(raw string) (string, error) {
+	raw = strings.TrimSuffix(raw, "/")
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return "", errors.New("must be an http or https URL, like https://videos.example.com")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("must not have a query or fragment")
+	}
+	return raw, nil
+}
+
+// Function to get the origin this server is reached at, for URLs that are
+// saved or sent outside of a request
+func (cfg *apiConfig) getServerOrigin() string {
+	return cfg.origin
+}
+
+// Function to get the origin a request reached the server at. PUBLIC_BASE_URL
+// wins when it's set; otherwise the scheme and host a trusted proxy recorded
+// are used, falling back to the request itself
+func (cfg *apiConfig) getRequestOrigin(r *http.Request) string {
+	if cfg.publicBaseURL != "" {
+		return cfg.publicBaseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	forwardedScheme, forwardedHost := cfg.proxies.forwardedOrigin(r)
+	if forwardedScheme == "http" || forwardedScheme == "https" {
+		scheme = forwardedScheme
+	}
+	if forwardedHost != "" {
+		host = forwardedHost
+	}
+	if !hostPattern.MatchString(host) {
+		return cfg.getServerOrigin()
+	}
+	return scheme + "://" + strings.ToLower(host)
+}
+
+// Function to get the last entry of a comma-separated header, which proxies
+// append to as a request passes through each of them
+func lastHeaderValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	value := values[len(values)-1]
+	return strings.TrimSpace(value[strings.LastIndex(value, ",")+1:])
+}
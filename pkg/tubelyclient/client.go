@@ -0,0 +1,270 @@
+package tubelyclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Path every API route is under
+const apiPath = "/api/v2"
+
+// Client calls the Tubely API as one user. It's safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	mu           sync.Mutex
+	token        string
+	refreshToken string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests with httpClient instead of
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.http = httpClient }
+}
+
+// WithTokens uses tokens from an earlier Login instead of logging in again.
+// refreshToken may be empty, in which case the client can't renew the
+// access token once it expires.
+func WithTokens(token, refreshToken string) Option {
+	return func(c *Client) { c.token, c.refreshToken = token, refreshToken }
+}
+
+// New returns a client for the server at baseURL, such as
+// "https://tubely.example.com".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is an error response from the API.
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+	// Code is a stable code to branch on, such as "FILE_TOO_LARGE"
+	Code    string       `json:"code"`
+	Details []FieldError `json:"details"`
+	// RequestID identifies the request in the server's logs
+	RequestID string `json:"request_id"`
+}
+
+// FieldError says what's wrong with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("tubely: %d %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("tubely: %s: %s", e.Code, e.Message)
+}
+
+// Login logs in and keeps the tokens for later requests.
+func (c *Client) Login(ctx context.Context, email, password string) error {
+	var login struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	credentials := map[string]string{"email": email, "password": password}
+	if _, err := c.send(ctx, http.MethodPost, "/login", "", credentials, &login); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.refreshToken = login.Token, login.RefreshToken
+	return nil
+}
+
+// Tokens returns the access and refresh tokens, to be saved and passed to
+// WithTokens next time.
+func (c *Client) Tokens() (token, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token, c.refreshToken
+}
+
+// Refresh gets a new access token with the refresh token.
+func (c *Client) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	refreshToken := c.refreshToken
+	c.mu.Unlock()
+	if refreshToken == "" {
+		return &Error{StatusCode: http.StatusUnauthorized, Code: "UNAUTHORIZED", Message: "no refresh token, log in again"}
+	}
+
+	var refreshed struct {
+		Token string `json:"token"`
+	}
+	if _, err := c.send(ctx, http.MethodPost, "/refresh", refreshToken, nil, &refreshed); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = refreshed.Token
+	return nil
+}
+
+// Logout revokes the refresh token and forgets both tokens.
+func (c *Client) Logout(ctx context.Context) error {
+	c.mu.Lock()
+	refreshToken := c.refreshToken
+	c.token, c.refreshToken = "", ""
+	c.mu.Unlock()
+	if refreshToken == "" {
+		return nil
+	}
+	_, err := c.send(ctx, http.MethodPost, "/revoke", refreshToken, nil, nil)
+	return err
+}
+
+// do sends a JSON request as the logged in user.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) (*http.Response, error) {
+	data, err := marshalBody(in)
+	if err != nil {
+		return nil, err
+	}
+	return c.doAuthorized(func(token string) (*http.Request, error) {
+		return c.newJSONRequest(ctx, method, path, token, data)
+	}, out)
+}
+
+// doAuthorized sends the request build makes with the access token, and if
+// the token has expired, refreshes it and sends a new request once more.
+func (c *Client) doAuthorized(build func(token string) (*http.Request, error), out any) (*http.Response, error) {
+	req, err := build(c.accessToken())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(req, out)
+	if !isUnauthorized(err) || !c.canRefresh() {
+		return resp, err
+	}
+	if err := c.Refresh(req.Context()); err != nil {
+		return nil, err
+	}
+
+	req, err = build(c.accessToken())
+	if err != nil {
+		return nil, err
+	}
+	return c.roundTrip(req, out)
+}
+
+// send sends a JSON request with token, which may be empty.
+func (c *Client) send(ctx context.Context, method, path, token string, in, out any) (*http.Response, error) {
+	data, err := marshalBody(in)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newJSONRequest(ctx, method, path, token, data)
+	if err != nil {
+		return nil, err
+	}
+	return c.roundTrip(req, out)
+}
+
+// newJSONRequest builds a request with data as its JSON body, no body when data is nil.
+func (c *Client) newJSONRequest(ctx context.Context, method, path, token string, data []byte) (*http.Request, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(ctx, method, path, token, body)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// newRequest builds a request for an API path such as "/videos".
+func (c *Client) newRequest(ctx context.Context, method, path, token string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPath+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// roundTrip sends req, returning an *Error for error responses and otherwise
+// decoding the data of the body's envelope into out if it isn't nil.
+func (c *Client) roundTrip(req *http.Request, out any) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return resp, apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return resp, nil
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return resp, fmt.Errorf("couldn't decode response to %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return resp, fmt.Errorf("couldn't decode response to %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) accessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *Client) canRefresh() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshToken != ""
+}
+
+func isUnauthorized(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
+
+func marshalBody(in any) ([]byte, error) {
+	if in == nil {
+		return nil, nil
+	}
+	return json.Marshal(in)
+}
@@ -0,0 +1,30 @@
+// Package tubelyclient is a Go client for the Tubely API, covering logging
+// in, managing videos and uploading their files.
+//
+// A Client logs in once and refreshes its access token as needed:
+//
+//	client := tubelyclient.New("https://tubely.example.com")
+//	if err := client.Login(ctx, "creator@example.com", "password"); err != nil {
+//		return err
+//	}
+//	video, err := client.CreateVideo(ctx, "Boots", "A video about boots")
+//
+// Uploads are resumable. Upload sends a file in chunks, retrying a chunk
+// whose connection drops and carrying on from wherever the server got to,
+// and reports progress as it goes:
+//
+//	file, err := os.Open("boots.mp4")
+//	...
+//	result, err := client.Upload(ctx, video.ID, file, tubelyclient.UploadOptions{
+//		MediaType: "video/mp4",
+//		Progress: func(sent, total int64) {
+//			fmt.Printf("\r%d of %d bytes", sent, total)
+//		},
+//	})
+//
+// An upload interrupted for longer, say by a crash, can be picked up with
+// ResumeUpload using the ID of its session. Small files can instead be sent
+// in one request with UploadVideo.
+//
+// Failed requests return an *Error carrying the API's error code.
+package tubelyclient
@@ -0,0 +1,473 @@
+package tubelyclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Defaults for UploadOptions
+const (
+	DefaultMediaType = "video/mp4"
+	DefaultChunkSize = 8 << 20
+	DefaultRetries   = 5
+)
+
+// Header a chunk's offset is sent and reported in
+const uploadOffsetHeader = "Upload-Offset"
+
+// UploadOptions configures an upload.
+type UploadOptions struct {
+	// MediaType is the file's media type, DefaultMediaType unless set
+	MediaType string
+	// Profile is the processing profile to use, the user's default unless set
+	Profile string
+	// Notify is the notification channel the result is sent on: all, email,
+	// slack, discord or none. The user's default unless set.
+	Notify string
+	// Priority is how soon the server processes the upload: interactive,
+	// normal or bulk. Interactive unless set.
+	Priority string
+	// Filename is the name the server records the file under, found from
+	// the reader if it's a file and unset
+	Filename string
+	// Size is the file's length, or -1 if it isn't known, such as when
+	// streaming. Zero means it's found from the reader if it's a file or
+	// another io.Seeker, and is otherwise unknown.
+	Size int64
+	// ChunkSize is how many bytes resumable uploads send per request,
+	// DefaultChunkSize unless set
+	ChunkSize int
+	// Retries is how many times a chunk is retried after a dropped
+	// connection or server error, DefaultRetries unless set
+	Retries int
+	// Progress, if set, is called as the upload goes with how many bytes
+	// the server has and the total, which is -1 when the size isn't known.
+	// Resumable uploads report after each chunk.
+	Progress func(sent, total int64)
+	// Started, if set, is called with a resumable upload's session as soon
+	// as it's created, so its ID can be saved for ResumeUpload
+	Started func(UploadSession)
+}
+
+// UploadSession is a resumable upload in progress.
+type UploadSession struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	MediaType string    `json:"media_type"`
+	Profile   string    `json:"profile"`
+	Notify    string    `json:"notify"`
+	Priority  string    `json:"priority"`
+	// Size is nil when the length wasn't known when the upload started
+	Size *int64 `json:"size"`
+	// Offset is how many bytes the server has, where the next chunk starts
+	Offset int64 `json:"offset"`
+}
+
+// UploadResult is what the server made of an uploaded file: the processed
+// video, or when processing carries on in the background, its job.
+type UploadResult struct {
+	Video *Video
+	Job   *Job
+}
+
+// Wait returns the processed video, waiting for the job first if there is
+// one, polling every interval.
+func (r UploadResult) Wait(ctx context.Context, c *Client, interval time.Duration) (Video, error) {
+	if r.Job == nil {
+		return *r.Video, nil
+	}
+	if _, err := c.WaitForJob(ctx, r.Job.ID, interval); err != nil {
+		return Video{}, err
+	}
+	return c.GetVideo(ctx, r.Job.VideoID)
+}
+
+// Upload sends a video's file from r as a resumable upload, in chunks that
+// are retried when their connection drops.
+func (c *Client) Upload(ctx context.Context, videoID uuid.UUID, r io.Reader, opts UploadOptions) (UploadResult, error) {
+	opts = opts.withDefaults(r)
+	session, err := c.StartUpload(ctx, videoID, opts)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if opts.Started != nil {
+		opts.Started(session)
+	}
+	return c.sendUpload(ctx, session, r, opts)
+}
+
+// ResumeUpload carries on with an upload from where the server got to. r
+// must read the same file from its start; if it's an io.Seeker it's moved
+// straight to the offset, otherwise what the server already has is read
+// and skipped.
+func (c *Client) ResumeUpload(ctx context.Context, uploadID uuid.UUID, r io.Reader, opts UploadOptions) (UploadResult, error) {
+	opts = opts.withDefaults(r)
+	session, err := c.GetUpload(ctx, uploadID)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(session.Offset, io.SeekStart); err != nil {
+			return UploadResult{}, err
+		}
+	} else if _, err := io.CopyN(io.Discard, r, session.Offset); err != nil {
+		return UploadResult{}, fmt.Errorf("couldn't skip the %d bytes already uploaded: %w", session.Offset, err)
+	}
+	return c.sendUpload(ctx, session, r, opts)
+}
+
+// StartUpload starts a resumable upload of a video's file. Most callers
+// want Upload, which also sends the file.
+func (c *Client) StartUpload(ctx context.Context, videoID uuid.UUID, opts UploadOptions) (UploadSession, error) {
+	params := struct {
+		Size      *int64 `json:"size,omitempty"`
+		MediaType string `json:"media_type"`
+		Profile   string `json:"profile,omitempty"`
+		Notify    string `json:"notify,omitempty"`
+		Priority  string `json:"priority,omitempty"`
+		Filename  string `json:"filename,omitempty"`
+	}{MediaType: opts.MediaType, Profile: opts.Profile, Notify: opts.Notify, Priority: opts.Priority, Filename: opts.Filename}
+	if params.MediaType == "" {
+		params.MediaType = DefaultMediaType
+	}
+	if opts.Size > 0 {
+		params.Size = &opts.Size
+	}
+
+	var session UploadSession
+	_, err := c.do(ctx, http.MethodPost, "/videos/"+videoID.String()+"/uploads", params, &session)
+	return session, err
+}
+
+// GetUpload fetches a resumable upload, to find its offset.
+func (c *Client) GetUpload(ctx context.Context, uploadID uuid.UUID) (UploadSession, error) {
+	var session UploadSession
+	_, err := c.do(ctx, http.MethodGet, "/uploads/"+uploadID.String(), nil, &session)
+	return session, err
+}
+
+// SendChunk appends chunk to a resumable upload at offset, which must be
+// the upload's current offset.
+func (c *Client) SendChunk(ctx context.Context, uploadID uuid.UUID, offset int64, chunk []byte) (UploadSession, error) {
+	var session UploadSession
+	_, err := c.doAuthorized(func(token string) (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPatch, "/uploads/"+uploadID.String(), token, bytes.NewReader(chunk))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+		return req, nil
+	}, &session)
+	return session, err
+}
+
+// CompleteUpload processes a resumable upload once all of it has been sent.
+func (c *Client) CompleteUpload(ctx context.Context, uploadID uuid.UUID) (UploadResult, error) {
+	return c.decodeUploadResult(func(out any) (*http.Response, error) {
+		return c.do(ctx, http.MethodPost, "/uploads/"+uploadID.String()+"/complete", nil, out)
+	})
+}
+
+// AbortUpload abandons a resumable upload, deleting what was sent.
+func (c *Client) AbortUpload(ctx context.Context, uploadID uuid.UUID) error {
+	_, err := c.do(ctx, http.MethodDelete, "/uploads/"+uploadID.String(), nil, nil)
+	return err
+}
+
+// UploadVideo sends a video's file in a single request, which has to start
+// again from the beginning if it fails. size must be the exact length of r.
+func (c *Client) UploadVideo(ctx context.Context, videoID uuid.UUID, r io.Reader, size int64, opts UploadOptions) (UploadResult, error) {
+	fields := map[string]string{}
+	if opts.Profile != "" {
+		fields["profile"] = opts.Profile
+	}
+	if opts.Notify != "" {
+		fields["notify"] = opts.Notify
+	}
+	if opts.Priority != "" {
+		fields["priority"] = opts.Priority
+	}
+	mediaType := opts.MediaType
+	if mediaType == "" {
+		mediaType = DefaultMediaType
+	}
+	name := opts.Filename
+	if name == "" {
+		name = fileName(r, "video.mp4")
+	}
+
+	return c.decodeUploadResult(func(out any) (*http.Response, error) {
+		return c.sendForm(ctx, "/video_upload/"+videoID.String(), fields, "video", name,
+			mediaType, r, size, opts.Progress, out)
+	})
+}
+
+// UploadThumbnail sends an image as a video's thumbnail. size must be the
+// exact length of r.
+func (c *Client) UploadThumbnail(ctx context.Context, videoID uuid.UUID, r io.Reader, size int64, mediaType string) (Video, error) {
+	var video Video
+	_, err := c.sendForm(ctx, "/thumbnail_upload/"+videoID.String(), nil, "thumbnail", fileName(r, "thumbnail"),
+		mediaType, r, size, nil, &video)
+	return video, err
+}
+
+// sendUpload sends the rest of an upload in chunks and completes it.
+func (c *Client) sendUpload(ctx context.Context, session UploadSession, r io.Reader, opts UploadOptions) (UploadResult, error) {
+	total := opts.Size
+	if session.Size != nil {
+		total = *session.Size
+	}
+	report := func(sent int64) {
+		if opts.Progress != nil {
+			opts.Progress(sent, total)
+		}
+	}
+
+	offset := session.Offset
+	report(offset)
+	chunk := make([]byte, opts.ChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, chunk)
+		if n > 0 {
+			var err error
+			offset, err = c.sendChunkRetrying(ctx, session.ID, offset, chunk[:n], opts.Retries, report)
+			if err != nil {
+				return UploadResult{}, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return UploadResult{}, readErr
+		}
+	}
+
+	return c.CompleteUpload(ctx, session.ID)
+}
+
+// sendChunkRetrying sends a chunk that starts at offset, retrying with
+// backoff after errors that may pass. Before each retry it asks the server
+// how much arrived, so only the rest of the chunk is sent again. It returns
+// the offset after the chunk.
+func (c *Client) sendChunkRetrying(ctx context.Context, uploadID uuid.UUID, offset int64, chunk []byte, retries int, report func(int64)) (int64, error) {
+	end := offset + int64(len(chunk))
+	from := offset
+	backoff := 500 * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		session, err := c.SendChunk(ctx, uploadID, from, chunk[from-offset:])
+		if err == nil {
+			report(session.Offset)
+			return session.Offset, nil
+		}
+		if attempt >= retries || !retryable(err) {
+			return from, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return from, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+
+		// Carry on from wherever the server got to
+		session, statusErr := c.GetUpload(ctx, uploadID)
+		if statusErr != nil {
+			continue
+		}
+		if session.Offset < offset || session.Offset > end {
+			return from, fmt.Errorf("upload is at offset %d, outside the chunk being sent (%d to %d)", session.Offset, offset, end)
+		}
+		from = session.Offset
+		report(from)
+		if from == end {
+			return end, nil
+		}
+	}
+}
+
+// retryable reports whether a failed chunk might succeed if sent again:
+// dropped connections, server errors, and conflicts from a chunk that
+// arrived after all.
+func retryable(err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch apiErr.StatusCode {
+	case http.StatusConflict, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return apiErr.StatusCode >= 500
+}
+
+// sendForm posts a multipart form with a file part read from r and the
+// fields before it, reporting progress as the file is sent.
+func (c *Client) sendForm(ctx context.Context, path string, fields map[string]string, fileField, name, mediaType string,
+	r io.Reader, size int64, progress func(sent, total int64), out any) (*http.Response, error) {
+	var head bytes.Buffer
+	form := multipart.NewWriter(&head)
+	for field, value := range fields {
+		if err := form.WriteField(field, value); err != nil {
+			return nil, err
+		}
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, fileField, name))
+	header.Set("Content-Type", mediaType)
+	if _, err := form.CreatePart(header); err != nil {
+		return nil, err
+	}
+
+	// The file goes between the part's headers and the closing boundary, so
+	// the whole length is known without buffering it
+	var tail bytes.Buffer
+	headBytes := bytes.Clone(head.Bytes())
+	head.Reset()
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+	tail.Write(head.Bytes())
+
+	// The body can be sent again after refreshing the token only if it can be rewound
+	start, seekErr := int64(0), errors.ErrUnsupported
+	seeker, canSeek := r.(io.Seeker)
+	if canSeek {
+		start, seekErr = seeker.Seek(0, io.SeekCurrent)
+	}
+
+	build := func(token string) (*http.Request, error) {
+		if seekErr == nil {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		var file io.Reader = io.LimitReader(r, size)
+		if progress != nil {
+			file = &progressReader{r: file, total: size, report: progress}
+		}
+		body := io.MultiReader(bytes.NewReader(headBytes), file, bytes.NewReader(tail.Bytes()))
+		req, err := c.newRequest(ctx, http.MethodPost, path, token, body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(headBytes)) + size + int64(tail.Len())
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return req, nil
+	}
+	if seekErr != nil {
+		req, err := build(c.accessToken())
+		if err != nil {
+			return nil, err
+		}
+		return c.roundTrip(req, out)
+	}
+	return c.doAuthorized(build, out)
+}
+
+// decodeUploadResult makes a request that answers with a video, or with a
+// job when processing carries on in the background.
+func (c *Client) decodeUploadResult(send func(out any) (*http.Response, error)) (UploadResult, error) {
+	var body json.RawMessage
+	resp, err := send(&body)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		var job Job
+		return UploadResult{Job: &job}, json.Unmarshal(body, &job)
+	}
+	var video Video
+	return UploadResult{Video: &video}, json.Unmarshal(body, &video)
+}
+
+func (o UploadOptions) withDefaults(r io.Reader) UploadOptions {
+	if o.MediaType == "" {
+		o.MediaType = DefaultMediaType
+	}
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = DefaultChunkSize
+	}
+	if o.Retries == 0 {
+		o.Retries = DefaultRetries
+	}
+	if o.Size == 0 {
+		o.Size = readerSize(r)
+	}
+	// Pipes such as stdin are files too, but their names aren't the upload's
+	if o.Filename == "" && readerSize(r) >= 0 {
+		o.Filename = fileName(r, "")
+	}
+	return o
+}
+
+// readerSize returns how many bytes are left in r, -1 if that can't be told.
+func readerSize(r io.Reader) int64 {
+	if file, ok := r.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+	}
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return -1
+	}
+	return end - current
+}
+
+// fileName returns the name of the file r reads, or fallback.
+func fileName(r io.Reader, fallback string) string {
+	if file, ok := r.(*os.File); ok {
+		return filepath.Base(file.Name())
+	}
+	return fallback
+}
+
+// progressReader reports how much has been read through it.
+type progressReader struct {
+	r      io.Reader
+	sent   int64
+	total  int64
+	report func(sent, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.report(p.sent, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,167 @@
+package tubelyclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Video is a video's metadata.
+type Video struct {
+	ID                     uuid.UUID `json:"id"`
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at"`
+	UserID                 uuid.UUID `json:"user_id"`
+	Title                  string    `json:"title"`
+	Description            string    `json:"description"`
+	ThumbnailURL           *string   `json:"thumbnail_url"`
+	ThumbnailBlurHash      *string   `json:"thumbnail_blurhash"`
+	ThumbnailDominantColor *string   `json:"thumbnail_dominant_color"`
+	// ThumbnailVariants are URLs of the thumbnail's crops by aspect ratio, like "16:9"
+	ThumbnailVariants map[string]string `json:"thumbnail_variants"`
+	// VideoURL is nil until the video's file has been uploaded and processed
+	VideoURL          *string    `json:"video_url"`
+	State             VideoState `json:"state"`
+	Duration          *float64   `json:"duration"`
+	ProcessingProfile *string    `json:"processing_profile"`
+	LikeCount         int        `json:"like_count"`
+	WatchLaterCount   int        `json:"watch_later_count"`
+}
+
+// VideoState is where a video is in its life.
+type VideoState string
+
+const (
+	VideoDraft       VideoState = "draft"
+	VideoUploading   VideoState = "uploading"
+	VideoProcessing  VideoState = "processing"
+	VideoReady       VideoState = "ready"
+	VideoFailed      VideoState = "failed"
+	VideoArchived    VideoState = "archived"
+	VideoQuarantined VideoState = "quarantined"
+)
+
+// JobState is how far a processing job has got.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobWaiting   JobState = "waiting"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Job processes an uploaded file, when the server processes uploads after
+// answering rather than during the request.
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	State     JobState  `json:"state"`
+	Attempts  int       `json:"attempts"`
+	Error     *string   `json:"error"`
+	Profile   string    `json:"profile"`
+	Notify    string    `json:"notify"`
+	Priority  string    `json:"priority"`
+}
+
+// Done reports whether the job has finished, successfully or not.
+func (j Job) Done() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
+
+// Profile is a processing profile an upload can ask for.
+type Profile struct {
+	Name string `json:"name"`
+	// Format is the output format, such as "mp4" or "hls"
+	Format  string `json:"format"`
+	Default bool   `json:"default"`
+}
+
+// CreateVideo creates a video, ready for its file to be uploaded.
+func (c *Client) CreateVideo(ctx context.Context, title, description string) (Video, error) {
+	var video Video
+	params := map[string]string{"title": title, "description": description}
+	_, err := c.do(ctx, http.MethodPost, "/videos", params, &video)
+	return video, err
+}
+
+// GetVideo fetches one of the user's videos.
+func (c *Client) GetVideo(ctx context.Context, videoID uuid.UUID) (Video, error) {
+	var video Video
+	_, err := c.do(ctx, http.MethodGet, "/videos/"+videoID.String(), nil, &video)
+	return video, err
+}
+
+// ListVideos fetches all of the user's videos.
+func (c *Client) ListVideos(ctx context.Context) ([]Video, error) {
+	videos := []Video{}
+	_, err := c.do(ctx, http.MethodGet, "/videos", nil, &videos)
+	return videos, err
+}
+
+// DeleteVideo deletes a video and its files.
+func (c *Client) DeleteVideo(ctx context.Context, videoID uuid.UUID) error {
+	_, err := c.do(ctx, http.MethodDelete, "/videos/"+videoID.String(), nil, nil)
+	return err
+}
+
+// Profiles lists the processing profiles uploads can ask for.
+func (c *Client) Profiles(ctx context.Context) ([]Profile, error) {
+	profiles := []Profile{}
+	_, err := c.do(ctx, http.MethodGet, "/profiles", nil, &profiles)
+	return profiles, err
+}
+
+// GetJob fetches a processing job.
+func (c *Client) GetJob(ctx context.Context, jobID uuid.UUID) (Job, error) {
+	var job Job
+	_, err := c.do(ctx, http.MethodGet, "/jobs/"+jobID.String(), nil, &job)
+	return job, err
+}
+
+// CancelJob cancels a processing job that hasn't finished.
+func (c *Client) CancelJob(ctx context.Context, jobID uuid.UUID) (Job, error) {
+	var job Job
+	_, err := c.do(ctx, http.MethodDelete, "/jobs/"+jobID.String(), nil, &job)
+	return job, err
+}
+
+// WaitForJob polls a job every interval until it finishes or ctx is done,
+// returning an error if it failed or was cancelled.
+func (c *Client) WaitForJob(ctx context.Context, jobID uuid.UUID, interval time.Duration) (Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := c.GetJob(ctx, jobID)
+		if err != nil {
+			return job, err
+		}
+		if job.State == JobFailed {
+			message := "unknown error"
+			if job.Error != nil {
+				message = *job.Error
+			}
+			return job, fmt.Errorf("processing job %s failed: %s", job.ID, message)
+		}
+		if job.State == JobCancelled {
+			return job, fmt.Errorf("processing job %s was cancelled", job.ID)
+		}
+		if job.Done() {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Function to get the plan a user is on. Users on the default plan, or on
+// one that's since been deleted, get a plan without limits of its own.
+func (cfg *apiConfig) userPlan(userID uuid.UUID) (database.Plan, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return database.Plan{}, err
+	}
+	if user == nil || user.Plan == "" {
+		return database.Plan{}, nil
+	}
+	plan, err := cfg.db.GetPlan(user.Plan)
+	if err != nil {
+		return database.Plan{}, err
+	}
+	if plan == nil {
+		return database.Plan{}, nil
+	}
+	return *plan, nil
+}
+
+// Function to get the largest video a plan allows, UPLOAD_LIMIT unless the
+// plan sets its own
+func (cfg *apiConfig) planUploadLimit(plan database.Plan) int64 {
+	if plan.UploadLimit != nil {
+		return *plan.UploadLimit
+	}
+	return cfg.uploadLimit
+}
+
+// Function to look up the largest video a user can upload on their plan
+func (cfg *apiConfig) userUploadLimit(userID uuid.UUID) (int64, error) {
+	plan, err := cfg.userPlan(userID)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.planUploadLimit(plan), nil
+}
+
+// Function to get the longest video a plan allows, 0 for no limit. Plans
+// can only lower VIDEO_MAX_DURATION.
+func (cfg *apiConfig) planMaxVideoDuration(plan database.Plan) time.Duration {
+	if plan.MaxVideoDuration == nil {
+		return cfg.maxVideoDuration
+	}
+	limit := time.Duration(*plan.MaxVideoDuration) * time.Second
+	if cfg.maxVideoDuration > 0 {
+		return min(limit, cfg.maxVideoDuration)
+	}
+	return limit
+}
+
+// Function to get the longest a plan's share links and clips can last, 0 if
+// it doesn't limit them
+func planMaxLinkExpiry(plan database.Plan) time.Duration {
+	if plan.MaxLinkExpiry == nil {
+		return 0
+	}
+	return time.Duration(*plan.MaxLinkExpiry) * time.Second
+}
+
+// Function to report a link lasting longer than a plan allows
+func linkExpiryNotInPlan(limit time.Duration) error {
+	return newRequestError(errCodeNotInPlan, fmt.Sprintf("Your plan's links can last at most %d seconds", int(limit.Seconds())), nil)
+}
+
+// Function to check that storing size more bytes keeps a user within their
+// plan's storage quota. Uploads are checked by their own size, since what
+// processing makes of them isn't known yet.
+func (cfg *apiConfig) checkStorageQuota(userID uuid.UUID, plan database.Plan, size int64) error {
+	if plan.StorageQuota == nil {
+		return nil
+	}
+	stored, err := cfg.db.GetUserStoredBytes(userID)
+	if err != nil {
+		return err
+	}
+	if stored+size > *plan.StorageQuota {
+		return newRequestError(errCodeQuotaExceeded, fmt.Sprintf("Upload would go over your plan's %d byte storage quota", *plan.StorageQuota), nil)
+	}
+	return nil
+}
+
+// Function to check that a plan lets its users upload a file of size bytes
+// and store it
+func (cfg *apiConfig) checkPlanUpload(userID uuid.UUID, plan database.Plan, size int64) error {
+	if limit := cfg.planUploadLimit(plan); size > limit {
+		return newRequestError(errCodeFileTooLarge, fmt.Sprintf("Upload exceeds your plan's %d byte limit", limit), nil)
+	}
+	return cfg.checkStorageQuota(userID, plan, size)
+}
+
+// Function to resolve a processing profile a user asked for, which their plan
+// has to allow. Not asking for one always gets the default.
+func (cfg *apiConfig) resolvePlanProfile(plan database.Plan, name string) (processing.Profile, error) {
+	profile, err := cfg.resolveProfile(name)
+	if err != nil {
+		return profile, err
+	}
+	if name != "" && !plan.AllowsProfile(profile.Name) {
+		return processing.Profile{}, newRequestError(errCodeNotInPlan, "Your plan doesn't include the "+profile.Name+" profile", nil)
+	}
+	return profile, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How many URLs a list presigns at once when PRESIGN_CONCURRENCY isn't set.
+// Each URL takes a database lookup as well as signing, so more workers
+// contend for the database; BenchmarkGeneratePresignedURLs compares the
+// levels on a given host.
+const defaultPresignConcurrency = 8
+
+// presignObject is one object of a batch to presign
+type presignObject struct {
+	Bucket string
+	Key    string
+	// Filename makes the URL download the object as a file with this name, if set
+	Filename string
+}
+
+// Function to presign a batch of objects, at most presignConcurrency at a time. Each URL
+// or error is at the same index as its object, so one that can't be signed doesn't fail
+// the rest.
+func (cfg *apiConfig) generatePresignedURLs(ctx context.Context, objects []presignObject, expireTime time.Duration) ([]string, []error) {
+	urls := make([]string, len(objects))
+	errs := make([]error, len(objects))
+	cfg.forEachConcurrently(len(objects), func(i int) {
+		urls[i], errs[i] = cfg.generateDownloadURL(ctx, objects[i].Bucket, objects[i].Key, objects[i].Filename, expireTime)
+	})
+	return urls, errs
+}
+
+// Function to call fn for each index below n on a pool of presignConcurrency goroutines,
+// returning once every call has
+func (cfg *apiConfig) forEachConcurrently(n int, fn func(i int)) {
+	workers := min(max(cfg.presignConcurrency, 1), n)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// BenchmarkGeneratePresignedURLs presigns a page of 200 URLs, each with its
+// archive lookup, at each concurrency level, which is what
+// defaultPresignConcurrency was chosen by.
+func BenchmarkGeneratePresignedURLs(b *testing.B) {
+	db, err := database.NewClient(filepath.Join(b.TempDir(), "tubely.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	awsCfg := aws.Config{
+		Region:      "us-east-2",
+		Credentials: credentials.NewStaticCredentialsProvider("benchmark", "benchmark", ""),
+	}
+	objects := make([]presignObject, 200)
+	for i := range objects {
+		objects[i] = presignObject{Bucket: "tubely-benchmark", Key: fmt.Sprintf("landscape/%03d.mp4", i)}
+	}
+
+	for _, concurrency := range []int{1, 2, 4, 8, 16, 32} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			cfg := apiConfig{
+				db:                 db,
+				s3Client:           processing.NewS3Client(awsCfg, "", processing.BucketAccess{}),
+				presignConcurrency: concurrency,
+			}
+			for range b.N {
+				_, errs := cfg.generatePresignedURLs(context.Background(), objects, time.Hour)
+				for _, err := range errs {
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
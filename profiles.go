@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// Maximum length of the profile form field
+const maxProfileNameLength = 100
+
+type profileResponse struct {
+	Name    string `json:"name"`
+	Format  string `json:"format"`
+	Default bool   `json:"default"`
+}
+
+func (cfg *apiConfig) handlerProfilesList(w http.ResponseWriter, r *http.Request) {
+	// Only the ffmpeg transcoder runs profiles, others always use the default
+	if !cfg.transcoderRunsProfiles() {
+		respondWithList(w, http.StatusOK, []profileResponse{}, listPage{})
+		return
+	}
+
+	defaultProfile, _ := cfg.profiles.Get("")
+	profiles := []profileResponse{}
+	for _, name := range cfg.profiles.Names() {
+		profile, _ := cfg.profiles.Get(name)
+		profiles = append(profiles, profileResponse{
+			Name:    profile.Name,
+			Format:  profile.Format,
+			Default: profile.Name == defaultProfile.Name,
+		})
+	}
+
+	respondWithList(w, http.StatusOK, profiles, listPage{Total: len(profiles)})
+}
+
+// Function to look up the processing profile an upload asked for, or the default if it didn't ask
+func (cfg *apiConfig) resolveProfile(name string) (processing.Profile, error) {
+	if !cfg.transcoderRunsProfiles() {
+		if name != "" {
+			return processing.Profile{}, newRequestError(errCodeValidationFailed, "Processing profiles aren't supported by this deployment's transcoder", nil)
+		}
+		profile, _ := processing.DefaultProfiles().Get(processing.ProfilePassthrough)
+		return profile, nil
+	}
+
+	profile, ok := cfg.profiles.Get(name)
+	if !ok {
+		return processing.Profile{}, newRequestError(errCodeValidationFailed, "Unknown processing profile: "+name, nil)
+	}
+	return profile, nil
+}
+
+// Function to report whether uploads are processed by a transcoder that honors profiles
+func (cfg *apiConfig) transcoderRunsProfiles() bool {
+	return cfg.processor.Transcoder == nil || cfg.processor.Transcoder.Name() == processing.TranscoderFFmpeg
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the reverse proxies allowed to say who the client is.
+// The X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host and Forwarded
+// headers are only believed on requests arriving from one of them, since
+// anyone else can send whatever they like.
+type trustedProxies []netip.Prefix
+
+// Function to parse a comma-separated list of proxy addresses and networks
+func parseTrustedProxies(raw string) (trustedProxies, error) {
+	proxies := trustedProxies{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := parseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't an address or network", entry)
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies, nil
+}
+
+// Function to report whether addr is a trusted proxy
+func (p trustedProxies) trusts(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to report whether the request arrived from a trusted proxy
+func (p trustedProxies) fromProxy(r *http.Request) bool {
+	peer, ok := peerAddr(r)
+	return ok && p.trusts(peer)
+}
+
+// Function to get the address of the client that sent the request. Behind
+// trusted proxies the forwarded chain is walked back from the nearest hop,
+// and the first address that isn't a trusted proxy is the client.
+func (p trustedProxies) clientAddr(r *http.Request) (netip.Addr, bool) {
+	peer, ok := peerAddr(r)
+	if !ok || !p.trusts(peer) {
+		return peer, ok
+	}
+
+	hops := forwardedFor(r.Header)
+	i, ok := p.clientHop(hops)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if i < 0 {
+		return peer, true
+	}
+	addr, _ := parseHopAddr(hops[i])
+	return addr, true
+}
+
+// Function to find the hop of a forwarded chain that's the client, walking
+// back from the nearest: the first that isn't a trusted proxy, or the
+// earliest if they all are. It's -1 for an empty chain, and false when an
+// obfuscated or garbled hop hides everything before it.
+func (p trustedProxies) clientHop(hops []string) (int, bool) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHopAddr(hops[i])
+		if !ok {
+			return 0, false
+		}
+		if !p.trusts(addr) {
+			return i, true
+		}
+	}
+	// Every hop was a proxy, so the earliest is as close to the client as we get
+	return min(0, len(hops)-1), true
+}
+
+// Function to get the scheme and host the client asked for, as recorded by
+// a trusted proxy, empty when the request didn't come through one. Clients
+// can send these headers themselves, so the values the proxies appended
+// are the only ones believed.
+func (p trustedProxies) forwardedOrigin(r *http.Request) (scheme, host string) {
+	if !p.fromProxy(r) {
+		return "", ""
+	}
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		// Each proxy adds one element for the hop it received the request
+		// from, so the client's hop is in the element added by the proxy
+		// the client connected to
+		i, ok := p.clientHop(forwardedFor(r.Header))
+		if !ok || i < 0 {
+			return "", ""
+		}
+		element := forwardedElements(forwarded)[i]
+		return strings.ToLower(element["proto"]), element["host"]
+	}
+	// X-Forwarded-Proto and X-Forwarded-Host don't say which proxy added
+	// each value, so only the one added by the proxy that sent the request is
+	return strings.ToLower(lastHeaderValue(r.Header.Values("X-Forwarded-Proto"))),
+		lastHeaderValue(r.Header.Values("X-Forwarded-Host"))
+}
+
+// Function to get the address of the peer that sent the request, which is
+// the client itself unless a proxy is in between
+func peerAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// Function to get the addresses a request was forwarded for, client first,
+// preferring the standard Forwarded header over X-Forwarded-For
+func forwardedFor(header http.Header) []string {
+	if forwarded := header.Values("Forwarded"); len(forwarded) > 0 {
+		hops := []string{}
+		for _, element := range forwardedElements(forwarded) {
+			hops = append(hops, element["for"])
+		}
+		return hops
+	}
+
+	hops := []string{}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// Function to split Forwarded headers (RFC 7239) into their elements, one
+// per proxy, each a map of lowercased parameter names to unquoted values
+func forwardedElements(values []string) []map[string]string {
+	elements := []map[string]string{}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			params := map[string]string{}
+			for _, pair := range strings.Split(element, ";") {
+				name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				params[strings.ToLower(name)] = strings.Trim(val, `"`)
+			}
+			elements = append(elements, params)
+		}
+	}
+	return elements
+}
+
+// Function to parse a forwarded hop, which may carry a port and, for IPv6,
+// brackets; "unknown" and obfuscated identifiers aren't addresses
+func parseHopAddr(hop string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(hop, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedOrigin(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		wantScheme string
+		wantHost   string
+	}{
+		{
+			name:       "forwarded by the proxy",
+			remoteAddr: "10.0.0.2:443",
+			header:     http.Header{"Forwarded": {`for=203.0.113.7;proto=https;host=tubely.example.com`}},
+			wantScheme: "https",
+			wantHost:   "tubely.example.com",
+		},
+		{
+			name:       "spoofed Forwarded element in front",
+			remoteAddr: "10.0.0.2:443",
+			header:     http.Header{"Forwarded": {`for=198.51.100.1;proto=http;host=evil.example, for=203.0.113.7;proto=https;host=tubely.example.com`}},
+			wantScheme: "https",
+			wantHost:   "tubely.example.com",
+		},
+		{
+			name:       "spoofed Forwarded header before the proxy's",
+			remoteAddr: "10.0.0.2:443",
+			header:     http.Header{"Forwarded": {`for=198.51.100.1;host=evil.example`, `for=203.0.113.7;proto=https;host=tubely.example.com`}},
+			wantScheme: "https",
+			wantHost:   "tubely.example.com",
+		},
+		{
+			name:       "Forwarded through two proxies",
+			remoteAddr: "10.0.0.2:443",
+			header:     http.Header{"Forwarded": {`for=203.0.113.7;proto=https;host=tubely.example.com, for=10.0.0.9;proto=http;host=internal`}},
+			wantScheme: "https",
+			wantHost:   "tubely.example.com",
+		},
+		{
+			name:       "spoofed X-Forwarded values in front",
+			remoteAddr: "10.0.0.2:443",
+			header: http.Header{
+				"X-Forwarded-For":   {"198.51.100.1, 203.0.113.7"},
+				"X-Forwarded-Proto": {"http, https"},
+				"X-Forwarded-Host":  {"evil.example, tubely.example.com"},
+			},
+			wantScheme: "https",
+			wantHost:   "tubely.example.com",
+		},
+		{
+			name:       "spoofed X-Forwarded-Host header before the proxy's",
+			remoteAddr: "10.0.0.2:443",
+			header:     http.Header{"X-Forwarded-Host": {"evil.example", "tubely.example.com"}},
+			wantHost:   "tubely.example.com",
+		},
+		{
+			name:       "not from a proxy",
+			remoteAddr: "203.0.113.7:443",
+			header:     http.Header{"Forwarded": {`for=203.0.113.7;proto=https;host=evil.example`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header = tt.header
+			scheme, host := proxies.forwardedOrigin(r)
+			if scheme != tt.wantScheme || host != tt.wantHost {
+				t.Errorf("forwardedOrigin() = %q, %q, want %q, %q", scheme, host, tt.wantScheme, tt.wantHost)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+)
+
+// Function to turn a panic in a handler into a 500 carrying the request ID,
+// logging the stack and sending it to the error reporter, so one bad request
+// can't take the server down or go unnoticed
+func (cfg *apiConfig) recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryResponseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// Handlers abort responses on purpose with this, and the server expects it back
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			// Skip runtime.Callers, this function and runtime.gopanic
+			pcs := make([]uintptr, 64)
+			frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+			var stack []runtime.Frame
+			trace := ""
+			for {
+				frame, more := frames.Next()
+				stack = append(stack, frame)
+				trace += fmt.Sprintf("\n\t%s\n\t\t%s:%d", frame.Function, frame.File, frame.Line)
+				if !more {
+					break
+				}
+			}
+
+			requestID := w.Header().Get(requestIDHeader)
+			log.Printf("[%s] panic: %v%s", requestID, v, trace)
+			go cfg.errorReporter.Report(context.Background(), errreport.Event{
+				Message:   fmt.Sprint(v),
+				Stack:     stack,
+				Time:      time.Now(),
+				RequestID: requestID,
+				Method:    r.Method,
+				URL:       redactLog(cfg.getRequestOrigin(r) + r.URL.RequestURI()),
+				UserAgent: r.UserAgent(),
+			})
+
+			// Once the response has started there's no taking it back
+			if rw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			// Drop whatever the handler set for the response it didn't finish
+			header := w.Header()
+			clear(header)
+			header.Set(requestIDHeader, requestID)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error", nil)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// recoveryResponseWriter records whether a response has started
+type recoveryResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoveryResponseWriter) WriteHeader(status int) {
+	if status >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoveryResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Function to let http.ResponseController reach the wrapped writer
+func (w *recoveryResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+	"github.com/google/uuid"
+)
+
+// Function to download a stored video, remux it with its current chapters and overwrite the stored object
+func (cfg *apiConfig) reprocessStoredVideo(ctx context.Context, video database.Video) error {
+
+	if video.VideoURL == nil || video.Duration == nil {
+		return errors.New("video has not been uploaded")
+	}
+	bucket, key, err := cfg.getVideoLocation(video)
+	if err != nil {
+		return err
+	}
+
+	client, err := cfg.getS3Client(bucket)
+	if err != nil {
+		return err
+	}
+
+	// Download the stored object to a temporary file
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't download video: %w", err)
+	}
+	defer object.Body.Close()
+
+	tempFile, err := cfg.scratch.CreateTemp("tubely-reprocess-*.mp4")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, object.Body); err != nil {
+		return fmt.Errorf("couldn't write video to disk: %w", err)
+	}
+
+	// Remux with the current chapter markers
+	metadataFilePath, err := cfg.writeVideoChapterMetadata(video.ID, *video.Duration)
+	if err != nil {
+		return err
+	}
+	if metadataFilePath != "" {
+		defer os.Remove(metadataFilePath)
+	}
+
+	processedFilePath, err := processing.ProcessVideoForFastStart(tempFile.Name(), metadataFilePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(processedFilePath)
+
+	processedFile, err := os.Open(processedFilePath)
+	if err != nil {
+		return err
+	}
+	defer processedFile.Close()
+	processedInfo, err := processedFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Overwrite the stored object in place so existing URLs keep working
+	err = processing.UploadObject(ctx, client, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        processedFile,
+		ContentType: aws.String("video/mp4"),
+	}, processedInfo.Size())
+	if err != nil {
+		return fmt.Errorf("couldn't upload reprocessed video: %w", err)
+	}
+
+	// Embedding chapters changes the stored size
+	cfg.recordStoredObject(database.RecordStoredObjectParams{
+		UserID:  video.UserID,
+		VideoID: uuid.NullUUID{UUID: video.ID, Valid: true},
+		Kind:    database.StorageKindVideo,
+		Bucket:  bucket,
+		Key:     key,
+		Bytes:   processedInfo.Size(),
+	})
+
+	return nil
+}
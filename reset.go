@@ -9,6 +9,23 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dryRun, ok := cfg.parseDryRun(w, r)
+	if !ok {
+		return
+	}
+	if dryRun {
+		records, err := cfg.db.CountResetRecords()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count records", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, removalPlan{
+			DryRun:  true,
+			Removes: []removal{{Kind: removalData, Records: records}},
+		})
+		return
+	}
+
 	err := cfg.db.Reset()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't reset database", err)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serverTLS is how the server terminates TLS. Without a certificate it
+// serves plain HTTP/1.1, as when running behind a proxy that handles TLS.
+type serverTLS struct {
+	certFile string
+	keyFile  string
+	// autocert gets certificates from Let's Encrypt for domains instead of
+	// reading them from files, nil when unused
+	autocert *autocert.Manager
+	domains  []string
+	// autocertHTTPAddr answers HTTP-01 challenges and redirects everything
+	// else to HTTPS, "" to rely on TLS-ALPN-01 challenges alone
+	autocertHTTPAddr string
+	// http3 also serves over QUIC on the same port number, which helps large
+	// uploads over lossy networks; clients learn of it through Alt-Svc
+	http3 bool
+}
+
+// Function to parse a comma-separated list of domains to get certificates for
+func parseAutocertDomains(raw string) ([]string, error) {
+	domains := []string{}
+	for _, domain := range strings.Split(raw, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if strings.ContainsAny(domain, ":/") {
+			return nil, fmt.Errorf("%q must be a bare host name, like videos.example.com", domain)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// Function to set up certificates from Let's Encrypt for domains, which are
+// the only names it will request them for. They're cached in cacheDir so
+// restarts don't request new ones.
+func (t *serverTLS) useAutocert(domains []string, cacheDir, email string) error {
+	if cacheDir == "" {
+		return errors.New("TLS_AUTOCERT_CACHE_DIR must be set")
+	}
+	t.domains = domains
+	t.autocert = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	return nil
+}
+
+// Function to check the TLS settings make sense together
+func (t serverTLS) validate() error {
+	if (t.certFile == "") != (t.keyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if t.certFile != "" && t.autocert != nil {
+		return errors.New("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS can't both be set")
+	}
+	if t.http3 && !t.enabled() {
+		return errors.New("HTTP3_ENABLED needs TLS_CERT_FILE and TLS_KEY_FILE, or TLS_AUTOCERT_DOMAINS")
+	}
+	return nil
+}
+
+// Function to report whether the server terminates TLS itself
+func (t serverTLS) enabled() bool {
+	return t.certFile != "" || t.autocert != nil
+}
+
+// Function to report the scheme clients reach the server on
+func (t serverTLS) scheme() string {
+	if t.enabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// Function to get the origin the server is reached at: the first autocert
+// domain when there is one, otherwise localhost
+func (t serverTLS) origin(port string) string {
+	host := "localhost"
+	if t.autocert != nil {
+		host = t.domains[0]
+	}
+	if t.scheme() == "https" && port == "443" || t.scheme() == "http" && port == "80" {
+		return t.scheme() + "://" + host
+	}
+	return t.scheme() + "://" + host + ":" + port
+}
+
+// Function to build the TLS config for TCP connections, offering HTTP/2
+// through ALPN and falling back to HTTP/1.1
+func (t serverTLS) config() (*tls.Config, error) {
+	if t.autocert != nil {
+		// Also offers acme-tls/1 to answer TLS-ALPN-01 challenges
+		config := t.autocert.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return config, nil
+	}
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// Function to serve srv until it fails, over HTTPS when a certificate is
+// configured and over HTTP/3 as well when that's enabled
+func serve(srv *http.Server, t serverTLS) error {
+	if !t.enabled() {
+		return srv.ListenAndServe()
+	}
+	tlsConfig, err := t.config()
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+
+	errs := make(chan error, 3)
+	if t.autocert != nil && t.autocertHTTPAddr != "" {
+		challenges := &http.Server{
+			Addr:              t.autocertHTTPAddr,
+			Handler:           t.autocert.HTTPHandler(nil),
+			ReadHeaderTimeout: srv.ReadHeaderTimeout,
+			ReadTimeout:       srv.ReadTimeout,
+		}
+		go func() { errs <- challenges.ListenAndServe() }()
+	}
+	if !t.http3 {
+		go func() { errs <- srv.ListenAndServeTLS("", "") }()
+		return <-errs
+	}
+
+	// QUIC negotiates h3 itself, so only the certificates are shared
+	h3 := &http3.Server{
+		Addr:    srv.Addr,
+		Handler: withReadDeadline(srv.Handler, srv.ReadTimeout),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates:   tlsConfig.Certificates,
+			GetCertificate: tlsConfig.GetCertificate,
+		}),
+		IdleTimeout: srv.IdleTimeout,
+	}
+	tcpHandler := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h3.SetQUICHeaders(w.Header()); err != nil && !errors.Is(err, http3.ErrNoAltSvcPort) {
+			log.Printf("Couldn't advertise HTTP/3: %v", err)
+		}
+		tcpHandler.ServeHTTP(w, r)
+	})
+
+	go func() { errs <- h3.ListenAndServe() }()
+	go func() { errs <- srv.ListenAndServeTLS("", "") }()
+	return <-errs
+}
+
+// Function to give each request timeout to send its body, as http.Server's
+// ReadTimeout does over TCP; validated uploads extend it as usual
+func withReadDeadline(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			log.Printf("Couldn't set read deadline: %v", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
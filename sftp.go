@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Prefix of the names files are moved to while they're processed
+const sftpProcessingPrefix = ".processing-"
+
+// sftpGateway accepts video files dropped over SFTP and feeds them through
+// the same ingest pipeline as HTTP uploads. Users log in with their email
+// and one of their API keys, and each sees only their own directory under
+// root.
+type sftpGateway struct {
+	cfg    *apiConfig
+	root   string
+	config *ssh.ServerConfig
+}
+
+// Function to set up the SFTP gateway, creating a host key at hostKeyPath if there isn't one
+func (cfg *apiConfig) newSFTPGateway(hostKeyPath, root string) (*sftpGateway, error) {
+	hostKey, err := loadSFTPHostKey(hostKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load host key: %w", err)
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+
+	g := &sftpGateway{cfg: cfg, root: root}
+	g.config = &ssh.ServerConfig{PasswordCallback: g.checkPassword}
+	g.config.AddHostKey(hostKey)
+	return g, nil
+}
+
+// Function to read the host key, generating one the first time so clients
+// see the same key across restarts
+func loadSFTPHostKey(keyPath string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "tubely sftp host key")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated SFTP host key %s", keyPath)
+	return ssh.NewSignerFromKey(key)
+}
+
+func (g *sftpGateway) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	user, err := g.cfg.authenticateAPIKey(string(password))
+	if err != nil {
+		log.Printf("Couldn't check SFTP login for %s: %v", conn.User(), err)
+		return nil, errors.New("couldn't check credentials")
+	}
+	if user == nil || !strings.EqualFold(user.Email, conn.User()) {
+		return nil, errors.New("invalid credentials")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"user-id": user.ID.String()}}, nil
+}
+
+// ListenAndServe accepts SFTP connections on addr until the listener fails.
+func (g *sftpGateway) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving SFTP on %s\n", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go g.serveConn(conn)
+	}
+}
+
+func (g *sftpGateway) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	// Don't let clients hold connections open without logging in
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, g.config)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(requests)
+
+	userID, err := uuid.Parse(serverConn.Permissions.Extensions["user-id"])
+	if err != nil {
+		return
+	}
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go g.serveSession(channel, channelRequests, userID)
+	}
+}
+
+// Function to serve the SFTP subsystem on a session, refusing shells and commands
+func (g *sftpGateway) serveSession(channel ssh.Channel, requests <-chan *ssh.Request, userID uuid.UUID) {
+	defer channel.Close()
+
+	for req := range requests {
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		dir := g.userDir(userID)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Printf("Couldn't create SFTP directory for user %s: %v", userID, err)
+			return
+		}
+		uploadLimit, err := g.cfg.userUploadLimit(userID)
+		if err != nil {
+			log.Printf("Couldn't get upload limit of user %s: %v", userID, err)
+			return
+		}
+		fs := &sftpUserFS{gateway: g, userID: userID, dir: dir, uploadLimit: uploadLimit}
+		server := sftp.NewRequestServer(channel, sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs})
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			log.Printf("SFTP session for user %s ended: %v", userID, err)
+		}
+		server.Close()
+		return
+	}
+}
+
+func (g *sftpGateway) userDir(userID uuid.UUID) string {
+	return filepath.Join(g.root, userID.String())
+}
+
+// Function to process a file a user has finished uploading. Failures are
+// left next to where the file was, as NAME.error, since there's no request
+// to report them on.
+func (g *sftpGateway) ingest(userID uuid.UUID, name string) {
+	dir := g.userDir(userID)
+
+	// Move the file aside so its name can be used again straight away
+	processingPath := filepath.Join(dir, sftpProcessingPrefix+uuid.NewString())
+	if err := os.Rename(filepath.Join(dir, name), processingPath); err != nil {
+		log.Printf("Couldn't pick up SFTP upload %s from user %s: %v", name, userID, err)
+		return
+	}
+	defer os.Remove(processingPath)
+
+	video, err := g.cfg.ingestFile(userID, name, processingPath)
+	if err != nil {
+		log.Printf("SFTP upload %s from user %s failed: %v", name, userID, err)
+		g.writeError(userID, name, ingestFailureMessage(err))
+		return
+	}
+	log.Printf("SFTP upload %s from user %s is video %s", name, userID, video.ID)
+}
+
+// Function to leave the reason a file couldn't be taken in its place, as NAME.error
+func (g *sftpGateway) writeError(userID uuid.UUID, name, reason string) {
+	errorPath := filepath.Join(g.userDir(userID), name+".error")
+	if err := os.WriteFile(errorPath, []byte(reason+"\n"), 0600); err != nil {
+		log.Printf("Couldn't write %s for user %s: %v", name+".error", userID, err)
+	}
+}
+
+// Function to delete files in users' directories that haven't changed for
+// maxAge, such as uploads never renamed to a video name and old errors
+func (g *sftpGateway) pruneFiles(ctx context.Context, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cutoff := time.Now().Add(-maxAge)
+		paths, err := filepath.Glob(filepath.Join(g.root, "*", "*"))
+		if err != nil {
+			log.Printf("Couldn't list SFTP files: %v", err)
+		}
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil || info.IsDir() || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := os.Remove(p); err != nil {
+				log.Printf("Couldn't delete old SFTP file %s: %v", p, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sftpUserFS is one user's view of the gateway: a single flat directory of
+// the files they're uploading.
+type sftpUserFS struct {
+	gateway *sftpGateway
+	userID  uuid.UUID
+	dir     string
+	// uploadLimit is the largest file the user's plan allows
+	uploadLimit int64
+}
+
+// Function to map a path the client sent to a file in the user's
+// directory, "" for the directory itself
+func (fs *sftpUserFS) localPath(p string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "", nil
+	}
+	if strings.Contains(name, "/") {
+		return "", sftp.ErrSSHFxNoSuchFile
+	}
+	if strings.HasPrefix(name, ".") {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	return name, nil
+}
+
+func (fs *sftpUserFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	name, err := fs.localPath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	return os.Open(filepath.Join(fs.dir, name))
+}
+
+func (fs *sftpUserFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	name, err := fs.localPath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	if fs.gateway.cfg.serviceMode.frozen() {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if r.Pflags().Trunc {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(filepath.Join(fs.dir, name), flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpUpload{File: file, fs: fs, name: name}, nil
+}
+
+func (fs *sftpUserFS) Filecmd(r *sftp.Request) error {
+	name, err := fs.localPath(r.Filepath)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case "Setstat":
+		// Clients set times and modes after uploading; they don't matter here
+		return nil
+	case "Remove":
+		if name == "" {
+			return sftp.ErrSSHFxFailure
+		}
+		return os.Remove(filepath.Join(fs.dir, name))
+	case "Rename":
+		target, err := fs.localPath(r.Target)
+		if err != nil {
+			return err
+		}
+		if name == "" || target == "" {
+			return sftp.ErrSSHFxFailure
+		}
+		if err := os.Rename(filepath.Join(fs.dir, name), filepath.Join(fs.dir, target)); err != nil {
+			return err
+		}
+		if isVideoFileName(target) {
+			go fs.gateway.ingest(fs.userID, target)
+		}
+		return nil
+	default:
+		// Directories and links aren't supported, uploads all go in one place
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+func (fs *sftpUserFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name, err := fs.localPath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case "List":
+		if name != "" {
+			return nil, sftp.ErrSSHFxFailure
+		}
+		entries, err := os.ReadDir(fs.dir)
+		if err != nil {
+			return nil, err
+		}
+		listing := sftpListing{}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			listing = append(listing, info)
+		}
+		return listing, nil
+	case "Stat":
+		info, err := os.Stat(filepath.Join(fs.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		return sftpListing{info}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// sftpUpload is a file being written by a client, which is processed once
+// the client closes it if it has a video's name.
+type sftpUpload struct {
+	*os.File
+	fs   *sftpUserFS
+	name string
+
+	mu sync.Mutex
+	// failure is why the file didn't arrive whole, if it didn't
+	failure string
+}
+
+func (u *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > u.fs.uploadLimit {
+		u.fail(fmt.Sprintf("File exceeds the %d byte upload limit", u.fs.uploadLimit))
+		return 0, fmt.Errorf("file exceeds the %d byte upload limit", u.fs.uploadLimit)
+	}
+	n, err := u.File.WriteAt(p, off)
+	if err != nil {
+		log.Printf("Couldn't write SFTP upload %s from user %s: %v", u.name, u.fs.userID, err)
+		u.fail("Couldn't save the file")
+	}
+	return n, err
+}
+
+// TransferError is called when the connection ends with the file still
+// open, so it's known to be cut off when it's closed.
+func (u *sftpUpload) TransferError(err error) {
+	u.fail("Upload was interrupted before it finished")
+}
+
+// Function to record the first reason the file didn't arrive whole
+func (u *sftpUpload) fail(reason string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.failure == "" {
+		u.failure = reason
+	}
+}
+
+// Files that didn't arrive whole are deleted instead of processed, so
+// neither closing nor renaming them can turn them into a video
+func (u *sftpUpload) Close() error {
+	closeErr := u.File.Close()
+	u.mu.Lock()
+	failure := u.failure
+	u.mu.Unlock()
+	if failure != "" {
+		log.Printf("SFTP upload %s from user %s failed: %s", u.name, u.fs.userID, failure)
+		os.Remove(u.Name())
+		u.fs.gateway.writeError(u.fs.userID, u.name, failure)
+		return closeErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if isVideoFileName(u.name) {
+		go u.fs.gateway.ingest(u.fs.userID, u.name)
+	}
+	return nil
+}
+
+// sftpListing is a directory listing or a file's details.
+type sftpListing []os.FileInfo
+
+func (l sftpListing) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+	return n, nil
+}
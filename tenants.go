@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Function to get the tenant a user's new videos belong to. It's read from the
+// database on every request rather than trusted from the access token, so
+// moving a user to another tenant takes effect straight away.
+func (cfg *apiConfig) getUserTenant(userID uuid.UUID) (uuid.NullUUID, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	if user == nil || user.DeletedAt != nil {
+		return uuid.NullUUID{}, newRequestError(errCodeUnauthorized, "User no longer exists", nil)
+	}
+	return user.TenantID, nil
+}
+
+// Function to describe a tenant's quota being full, for a create that
+// failed with database.ErrTenantFull
+func (cfg *apiConfig) tenantFullError(tenantID uuid.UUID) error {
+	tenant, err := cfg.db.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	limit := 0
+	if tenant.MaxVideos != nil {
+		limit = *tenant.MaxVideos
+	}
+	return newRequestError(errCodeQuotaExceeded, fmt.Sprintf("Tenant has reached its limit of %d videos", limit), nil)
+}
+
+// Function to check a tenant has room for n more videos, returning a quota
+// error if it doesn't
+func (cfg *apiConfig) checkTenantRoom(tenantID uuid.UUID, n int) error {
+	tenant, err := cfg.db.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	if tenant.MaxVideos == nil {
+		return nil
+	}
+	count, err := cfg.db.CountTenantVideos(tenantID)
+	if err != nil {
+		return err
+	}
+	if count+n > *tenant.MaxVideos {
+		return newRequestError(errCodeQuotaExceeded, fmt.Sprintf("Tenant has room for %d more videos of its limit of %d", max(*tenant.MaxVideos-count, 0), *tenant.MaxVideos), nil)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Function to parse the priority an upload asked to be processed at.
+// Uploads over the API are interactive unless they say otherwise, so
+// scripts sending a backlog should ask for bulk to stay out of the way.
+func parseUploadPriority(value string) (database.JobPriority, error) {
+	if value == "" {
+		return database.JobPriorityInteractive, nil
+	}
+	priority := database.JobPriority(value)
+	if !priority.Valid() {
+		return "", newRequestError(errCodeValidationFailed, fmt.Sprintf("Invalid priority %q, must be interactive, normal or bulk", value), nil)
+	}
+	return priority, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The drag-and-drop uploader is compiled into the binary so it's available
+// without deploying the frontend
+//
+//go:embed uploader
+var uploaderFiles embed.FS
+
+// Function to serve the embedded uploader page and its assets
+func uploaderHandler() http.Handler {
+	files, err := fs.Sub(uploaderFiles, "uploader")
+	if err != nil {
+		// The embedded directory is fixed at build time, so this can't fail
+		panic(err)
+	}
+	return http.StripPrefix("/upload", http.FileServerFS(files))
+}
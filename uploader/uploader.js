@@ -0,0 +1,162 @@
+const loginForm = document.getElementById('login-form');
+const uploadSection = document.getElementById('upload-section');
+const dropZone = document.getElementById('drop-zone');
+const fileInput = document.getElementById('file-input');
+const uploadList = document.getElementById('upload-list');
+
+// Poll interval for jobs processed by workers
+const jobPollInterval = 2000;
+
+function showSection() {
+  const loggedIn = Boolean(localStorage.getItem('token'));
+  loginForm.hidden = loggedIn;
+  uploadSection.hidden = !loggedIn;
+}
+
+function authHeaders() {
+  return { Authorization: `Bearer ${localStorage.getItem('token')}` };
+}
+
+async function errorMessage(res) {
+  try {
+    const data = await res.json();
+    return data.code ? `${data.error} (${data.code})` : data.error;
+  } catch {
+    return `HTTP ${res.status}`;
+  }
+}
+
+loginForm.addEventListener('submit', async (event) => {
+  event.preventDefault();
+  const res = await fetch('/api/v2/login', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({
+      email: document.getElementById('email').value,
+      password: document.getElementById('password').value,
+    }),
+  });
+  if (!res.ok) {
+    alert(`Login failed: ${await errorMessage(res)}`);
+    return;
+  }
+  const { data } = await res.json();
+  localStorage.setItem('token', data.token);
+  showSection();
+});
+
+// Drag and drop, with the file picker as a fallback
+dropZone.addEventListener('dragover', (event) => {
+  event.preventDefault();
+  dropZone.classList.add('dragging');
+});
+dropZone.addEventListener('dragleave', () => dropZone.classList.remove('dragging'));
+dropZone.addEventListener('drop', (event) => {
+  event.preventDefault();
+  dropZone.classList.remove('dragging');
+  uploadFiles(event.dataTransfer.files);
+});
+fileInput.addEventListener('change', () => {
+  uploadFiles(fileInput.files);
+  fileInput.value = '';
+});
+
+function uploadFiles(files) {
+  for (const file of files) {
+    uploadFile(file);
+  }
+}
+
+async function uploadFile(file) {
+  const item = document.createElement('li');
+  const name = document.createElement('div');
+  const progress = document.createElement('progress');
+  const status = document.createElement('div');
+  name.textContent = file.name;
+  progress.max = 100;
+  progress.value = 0;
+  status.className = 'status';
+  item.append(name, progress, status);
+  uploadList.prepend(item);
+
+  const setStatus = (text, isError = false) => {
+    status.textContent = text;
+    status.classList.toggle('error', isError);
+  };
+
+  try {
+    // Each file gets its own video, titled after the file
+    setStatus('Creating video…');
+    const res = await fetch('/api/v2/videos', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', ...authHeaders() },
+      body: JSON.stringify({ title: file.name.replace(/\.[^.]+$/, ''), description: '' }),
+    });
+    if (!res.ok) {
+      throw new Error(await errorMessage(res));
+    }
+    const { data: video } = await res.json();
+
+    setStatus('Uploading…');
+    const result = await sendWithProgress(`/api/v2/video_upload/${video.id}`, file, (percent) => {
+      progress.value = percent;
+      setStatus(percent < 100 ? `Uploading… ${percent}%` : 'Processing…');
+    });
+
+    // Worker mode hands back a job to poll instead of the finished video
+    if (result.status === 202) {
+      await waitForJob(result.body.data.id, setStatus);
+    }
+    setStatus('Done');
+  } catch (error) {
+    setStatus(`Failed: ${error.message}`, true);
+  }
+}
+
+// fetch can't report upload progress, so use XHR
+function sendWithProgress(url, file, onProgress) {
+  return new Promise((resolve, reject) => {
+    const form = new FormData();
+    form.append('video', file);
+
+    const xhr = new XMLHttpRequest();
+    xhr.open('POST', url);
+    xhr.setRequestHeader('Authorization', authHeaders().Authorization);
+    xhr.responseType = 'json';
+    xhr.upload.addEventListener('progress', (event) => {
+      if (event.lengthComputable) {
+        onProgress(Math.round((event.loaded / event.total) * 100));
+      }
+    });
+    xhr.addEventListener('load', () => {
+      const body = xhr.response || {};
+      if (xhr.status >= 200 && xhr.status < 300) {
+        resolve({ status: xhr.status, body });
+      } else {
+        reject(new Error(body.code ? `${body.error} (${body.code})` : body.error || `HTTP ${xhr.status}`));
+      }
+    });
+    xhr.addEventListener('error', () => reject(new Error('network error')));
+    xhr.send(form);
+  });
+}
+
+async function waitForJob(jobID, setStatus) {
+  for (;;) {
+    const res = await fetch(`/api/v2/jobs/${jobID}`, { headers: authHeaders() });
+    if (!res.ok) {
+      throw new Error(await errorMessage(res));
+    }
+    const { data: job } = await res.json();
+    if (job.state === 'succeeded') {
+      return;
+    }
+    if (job.state === 'failed') {
+      throw new Error(job.error || 'processing failed');
+    }
+    setStatus(`Processing… (${job.state}, ${job.checkpoint})`);
+    await new Promise((resolve) => setTimeout(resolve, jobPollInterval));
+  }
+}
+
+showSection();
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/processing"
+)
+
+// Ways of giving out URLs, chosen by ASSET_URLS for thumbnails and avatars
+// and VIDEO_URLS for videos
+const (
+	// urlStrategyLocal serves files from the server's /assets/
+	urlStrategyLocal = "local"
+	// urlStrategySigned serves files from the server's /assets/ with URLs
+	// that expire
+	urlStrategySigned = "signed"
+	// urlStrategyPresign presigns objects in S3
+	urlStrategyPresign = "presign"
+	// urlStrategyCDN serves files from a CDN's domain
+	urlStrategyCDN = "cdn"
+)
+
+// assetsPrefix is where the assets directory is served, and what thumbnails'
+// and avatars' stored locations start with.
+const assetsPrefix = "/assets/"
+
+// Query parameters of a signed asset URL
+const (
+	assetExpiresParam   = "expires"
+	assetSignatureParam = "signature"
+)
+
+var (
+	errNotAnAsset  = errors.New("file isn't in the assets directory")
+	errNotAnObject = errors.New("file isn't stored in a bucket")
+)
+
+// storedFile is a thumbnail, avatar or video as it's stored.
+type storedFile struct {
+	// AssetPath is set for files in the assets directory
+	AssetPath string
+	// URL is the URL the file was stored under, a video's on its storage's base URL
+	URL string
+	// Locate returns the bucket and key of a video's object. It's only called by
+	// providers that need them, since it can take database lookups.
+	Locate func() (bucket, key string, err error)
+}
+
+// urlProvider gives out the URLs clients fetch stored files from.
+type urlProvider interface {
+	// fileURL returns a URL the file can be fetched from for at least expiry
+	fileURL(ctx context.Context, file storedFile, expiry time.Duration) (string, error)
+}
+
+// urlConfig decides the URLs clients are given for stored files.
+type urlConfig struct {
+	// Assets gives out URLs for thumbnails and avatars
+	Assets urlProvider
+	// AssetExpiry is how long asset URLs stay valid, for providers whose URLs expire
+	AssetExpiry time.Duration
+	// Videos gives out URLs for videos
+	Videos urlProvider
+	// VideoExpiry is how long video URLs stay valid, for providers whose URLs expire
+	VideoExpiry time.Duration
+}
+
+// localURLs serves assets from the server itself, signing their URLs when
+// it has a secret.
+type localURLs struct {
+	origin string
+	secret []byte
+}
+
+func (p localURLs) fileURL(ctx context.Context, file storedFile, expiry time.Duration) (string, error) {
+	if file.AssetPath == "" {
+		return "", errNotAnAsset
+	}
+	assetURL := p.origin + assetsPrefix + file.AssetPath
+	if p.secret == nil {
+		return assetURL, nil
+	}
+
+	// URLs are kept the same for a whole expiry period, so responses and
+	// browsers' caches of the assets don't change on every request
+	expires := time.Now().Truncate(expiry).Add(2 * expiry).Unix()
+	return assetURL + "?" + url.Values{
+		assetExpiresParam:   {strconv.FormatInt(expires, 10)},
+		assetSignatureParam: {p.sign(file.AssetPath, expires)},
+	}.Encode(), nil
+}
+
+// Function to sign an asset's path to stay valid until expires
+func (p localURLs) sign(assetPath string, expires int64) string {
+	mac := hmac.New(sha256.New, p.secret)
+	fmt.Fprintf(mac, "%d.%s", expires, assetPath)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Function to reject requests for assets that don't carry a valid signature
+func (p localURLs) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		expires, err := strconv.ParseInt(query.Get(assetExpiresParam), 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "Asset URL isn't signed", nil)
+			return
+		}
+		assetPath := strings.TrimPrefix(r.URL.Path, assetsPrefix)
+		if !hmac.Equal([]byte(query.Get(assetSignatureParam)), []byte(p.sign(assetPath, expires))) {
+			respondWithError(w, http.StatusForbidden, "Invalid asset URL signature", nil)
+			return
+		}
+		if time.Now().Unix() > expires {
+			respondWithErrorCode(w, errCodeExpired, "Asset URL has expired", nil, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// presignedURLs presigns videos' objects in S3.
+type presignedURLs struct {
+	cfg *apiConfig
+}
+
+func (p presignedURLs) fileURL(ctx context.Context, file storedFile, expiry time.Duration) (string, error) {
+	if file.Locate == nil {
+		return "", errNotAnObject
+	}
+	bucket, key, err := file.Locate()
+	if err != nil {
+		return "", err
+	}
+	return p.cfg.generatePresignedURL(ctx, bucket, key, expiry)
+}
+
+// cdnURLs serves files from a CDN's domain. Without a base URL of its own,
+// videos are served from the URL they were stored under, their storage's
+// S3_CF_DISTRO or tenant's base URL.
+type cdnURLs struct {
+	baseURL string
+}
+
+func (p cdnURLs) fileURL(ctx context.Context, file storedFile, expiry time.Duration) (string, error) {
+	if p.baseURL == "" {
+		return file.URL, nil
+	}
+	if file.AssetPath == "" {
+		return "", errNotAnAsset
+	}
+	return p.baseURL + "/" + file.AssetPath, nil
+}
+
+// Function to pick the provider of thumbnails' and avatars' URLs from ASSET_URLS
+func newAssetURLProvider(strategy, origin, secret, cdnURL string) (urlProvider, error) {
+	switch strategy {
+	case urlStrategyLocal:
+		return localURLs{origin: origin}, nil
+	case urlStrategySigned:
+		if secret == "" {
+			return nil, errors.New("ASSET_URL_SECRET must be set to sign asset URLs")
+		}
+		return localURLs{origin: origin, secret: []byte(secret)}, nil
+	case urlStrategyCDN:
+		if _, ok := originOf(cdnURL); !ok {
+			return nil, fmt.Errorf("ASSET_CDN_URL %q must be a URL like https://assets.example.com", cdnURL)
+		}
+		return cdnURLs{baseURL: strings.TrimSuffix(cdnURL, "/")}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q, expected local, signed or cdn", strategy)
+	}
+}
+
+// Function to pick the provider of videos' URLs from VIDEO_URLS
+func newVideoURLProvider(strategy string, cfg *apiConfig) (urlProvider, error) {
+	switch strategy {
+	case urlStrategyCDN:
+		return cdnURLs{}, nil
+	case urlStrategyPresign:
+		return presignedURLs{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q, expected cdn or presign", strategy)
+	}
+}
+
+// Function to get where a file in the assets directory is stored, which is
+// saved in place of a URL so the URL can be worked out when it's given out
+func assetLocation(assetPath string) string {
+	return assetsPrefix + assetPath
+}
+
+// Function to get the URL clients fetch a file in the assets directory from
+func (cfg *apiConfig) getAssetURL(ctx context.Context, assetPath string) string {
+	assetURL, err := cfg.urls.Assets.fileURL(ctx, storedFile{AssetPath: assetPath}, cfg.urls.AssetExpiry)
+	if err != nil {
+		log.Printf("Couldn't get URL of asset %s: %v", assetPath, err)
+		return ""
+	}
+	return assetURL
+}
+
+// Function to get the URL clients fetch a stored thumbnail or avatar from,
+// leaving anything that isn't in the assets directory as it is
+func (cfg *apiConfig) resolveAssetURL(ctx context.Context, stored *string) *string {
+	if stored == nil {
+		return nil
+	}
+	assetPath, ok := cfg.getAssetPathFromURL(*stored)
+	if !ok {
+		return stored
+	}
+	assetURL := cfg.getAssetURL(ctx, assetPath)
+	return &assetURL
+}
+
+// Function to replace a video's stored thumbnail and video locations with the
+// URLs clients fetch them from. A video whose URL can't be worked out, such as
+// an archived one being presigned, is logged and given none.
+func (cfg *apiConfig) withURLs(ctx context.Context, video database.Video) database.Video {
+	video.ThumbnailURL = cfg.resolveAssetURL(ctx, video.ThumbnailURL)
+	if video.ThumbnailVariants != nil {
+		variants := make(map[string]string, len(video.ThumbnailVariants))
+		for name, stored := range video.ThumbnailVariants {
+			variants[name] = *cfg.resolveAssetURL(ctx, &stored)
+		}
+		video.ThumbnailVariants = variants
+	}
+
+	// HLS playlists point at their segments by relative path, which a presigned URL
+	// can't cover, so they keep the URL they're stored under
+	if video.VideoURL != nil && processing.FormatForKey(*video.VideoURL) != processing.FormatHLS {
+		videoURL, err := cfg.urls.Videos.fileURL(ctx, storedFile{
+			URL: *video.VideoURL,
+			Locate: func() (string, string, error) {
+				return cfg.getVideoLocation(video)
+			},
+		}, cfg.urls.VideoExpiry)
+		if err != nil {
+			log.Printf("Couldn't get URL of video %s: %v", video.ID, err)
+			video.VideoURL = nil
+		} else {
+			video.VideoURL = &videoURL
+		}
+	}
+	return video
+}
+
+// Function to get how long a CDN may keep a response carrying a video's URL.
+// Presigned URLs start to expire as soon as they're made, so everyone sharing
+// a cached copy must get a new one well before the URL in it stops working.
+func (cfg *apiConfig) videoURLsMaxAge() time.Duration {
+	if _, presigned := cfg.urls.Videos.(presignedURLs); !presigned {
+		return cfg.cdnMaxAge
+	}
+	return min(cfg.cdnMaxAge, cfg.urls.VideoExpiry/10)
+}
+
+// Function to replace videos' stored locations with their URLs, presignConcurrency at a time
+func (cfg *apiConfig) withURLsAll(ctx context.Context, videos []database.Video) []database.Video {
+	resolved := make([]database.Video, len(videos))
+	cfg.forEachConcurrently(len(videos), func(i int) {
+		resolved[i] = cfg.withURLs(ctx, videos[i])
+	})
+	return resolved
+}
+
+// Function to replace the stored locations of listed videos with their URLs,
+// unless ?fields= leaves out every field that has one, when they'd only be dropped
+func (cfg *apiConfig) withSelectedURLs(ctx context.Context, videos []database.Video, fields fieldSelection) []database.Video {
+	if !fields.hasURLs() {
+		return videos
+	}
+	return cfg.withURLsAll(ctx, videos)
+}
+
+// Function to replace a user's stored avatar location with its URL
+func (cfg *apiConfig) withAvatarURL(ctx context.Context, user database.User) database.User {
+	user.AvatarURL = cfg.resolveAssetURL(ctx, user.AvatarURL)
+	return user
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Layout of the month query parameters and report rows
+const usageMonthLayout = "2006-01"
+
+// Number of months reported when no range is given
+const defaultUsageMonths = 12
+
+// monthlyUsage summarizes one user's stored bytes over one calendar month.
+// AverageBytes weights each object by how long it was stored during the
+// month, which is what storage is billed on.
+type monthlyUsage struct {
+	UserID       uuid.UUID        `json:"user_id"`
+	Month        string           `json:"month"`
+	StoredBytes  int64            `json:"stored_bytes"`
+	AverageBytes int64            `json:"average_bytes"`
+	AddedBytes   int64            `json:"added_bytes"`
+	ByKind       map[string]int64 `json:"stored_bytes_by_kind"`
+}
+
+type videoUsage struct {
+	VideoID uuid.UUID        `json:"video_id"`
+	Bytes   int64            `json:"bytes"`
+	ByKind  map[string]int64 `json:"bytes_by_kind"`
+}
+
+func (cfg *apiConfig) handlerUserUsage(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		TotalBytes int64          `json:"total_bytes"`
+		Videos     []videoUsage   `json:"videos"`
+		Months     []monthlyUsage `json:"months"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	from, to, details := parseUsageRange(r)
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid usage range", nil, details)
+		return
+	}
+
+	objects, err := cfg.db.GetStoredObjects(userID, from)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+
+	// Break down what's stored right now by video
+	resp := response{Videos: []videoUsage{}}
+	byVideo := map[uuid.UUID]*videoUsage{}
+	for _, object := range objects {
+		if object.DeletedAt != nil {
+			continue
+		}
+		resp.TotalBytes += object.Bytes
+		if !object.VideoID.Valid {
+			continue
+		}
+		usage, ok := byVideo[object.VideoID.UUID]
+		if !ok {
+			usage = &videoUsage{VideoID: object.VideoID.UUID, ByKind: map[string]int64{}}
+			byVideo[object.VideoID.UUID] = usage
+		}
+		usage.Bytes += object.Bytes
+		usage.ByKind[object.Kind] += object.Bytes
+	}
+	for _, usage := range byVideo {
+		resp.Videos = append(resp.Videos, *usage)
+	}
+	sort.Slice(resp.Videos, func(i, j int) bool {
+		return resp.Videos[i].Bytes > resp.Videos[j].Bytes
+	})
+
+	resp.Months = summarizeUsage(objects, from, to, time.Now())
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerAdminUsageExport(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusForbidden, "Admin access required", err)
+		return
+	}
+
+	from, to, details := parseUsageRange(r)
+	if len(details) > 0 {
+		respondWithErrorCode(w, errCodeValidationFailed, "Invalid usage range", nil, details)
+		return
+	}
+
+	objects, err := cfg.db.GetStoredObjects(uuid.Nil, from)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage usage", err)
+		return
+	}
+
+	// Deleted accounts still appear in the report, just without an email
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+	emails := make(map[uuid.UUID]string, len(users))
+	for _, user := range users {
+		emails[user.ID] = user.Email
+	}
+
+	filename := fmt.Sprintf("usage-%s-%s.csv", from.Format(usageMonthLayout), to.Format(usageMonthLayout))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{
+		"month",
+		"user_id",
+		"email",
+		"stored_bytes",
+		"average_bytes",
+		"added_bytes",
+		"video_bytes",
+		"thumbnail_bytes",
+		"clip_bytes",
+	})
+	for _, usage := range summarizeUsage(objects, from, to, time.Now()) {
+		out.Write([]string{
+			usage.Month,
+			usage.UserID.String(),
+			emails[usage.UserID],
+			strconv.FormatInt(usage.StoredBytes, 10),
+			strconv.FormatInt(usage.AverageBytes, 10),
+			strconv.FormatInt(usage.AddedBytes, 10),
+			strconv.FormatInt(usage.ByKind[database.StorageKindVideo], 10),
+			strconv.FormatInt(usage.ByKind[database.StorageKindThumbnail], 10),
+			strconv.FormatInt(usage.ByKind[database.StorageKindClip], 10),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Couldn't write usage export: %v", err)
+	}
+}
+
+// Function to add an object to the storage ledger; a failure is logged rather than failing the upload
+func (cfg *apiConfig) recordStoredObject(params database.RecordStoredObjectParams) {
+	if err := cfg.db.RecordStoredObject(params); err != nil {
+		log.Printf("Couldn't record stored object %s: %v", params.Key, err)
+	}
+}
+
+// Function to mark an object removed from the storage ledger
+func (cfg *apiConfig) forgetStoredObject(bucket, key string) {
+	if err := cfg.db.MarkStoredObjectDeleted(bucket, key); err != nil {
+		log.Printf("Couldn't record deletion of %s: %v", key, err)
+	}
+}
+
+// Function to read the from and to months of a usage report, returning the start of each
+// and any problems with them. Without them the report covers the last twelve months.
+func parseUsageRange(r *http.Request) (time.Time, time.Time, []fieldError) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -(defaultUsageMonths - 1), 0)
+
+	details := []fieldError{}
+	if value := r.URL.Query().Get("from"); value != "" {
+		month, err := time.Parse(usageMonthLayout, value)
+		if err != nil {
+			details = append(details, fieldError{Field: "from", Message: "must be a month in YYYY-MM format"})
+		}
+		from = month
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		month, err := time.Parse(usageMonthLayout, value)
+		if err != nil {
+			details = append(details, fieldError{Field: "to", Message: "must be a month in YYYY-MM format"})
+		}
+		to = month
+	}
+	if len(details) == 0 && to.Before(from) {
+		details = append(details, fieldError{Field: "to", Message: "must not be before from"})
+	}
+	return from, to, details
+}
+
+// Function to total each user's stored bytes for every month from the start of from
+// to the end of to. Months still in progress are measured up to now.
+func summarizeUsage(objects []database.StoredObject, from, to, now time.Time) []monthlyUsage {
+	usage := []monthlyUsage{}
+
+	for month := from; !month.After(to); month = month.AddDate(0, 1, 0) {
+		start := month
+		end := month.AddDate(0, 1, 0)
+		if end.After(now) {
+			end = now
+		}
+		if !end.After(start) {
+			break
+		}
+		period := end.Sub(start)
+
+		byUser := map[uuid.UUID]*monthlyUsage{}
+		for _, object := range objects {
+			removed := end
+			if object.DeletedAt != nil && object.DeletedAt.Before(end) {
+				removed = *object.DeletedAt
+			}
+			added := object.CreatedAt
+			if added.Before(start) {
+				added = start
+			}
+			if !removed.After(added) {
+				continue
+			}
+
+			userUsage, ok := byUser[object.UserID]
+			if !ok {
+				userUsage = &monthlyUsage{
+					UserID: object.UserID,
+					Month:  month.Format(usageMonthLayout),
+					ByKind: map[string]int64{},
+				}
+				byUser[object.UserID] = userUsage
+			}
+
+			stored := removed.Sub(added)
+			userUsage.AverageBytes += int64(float64(object.Bytes) * (float64(stored) / float64(period)))
+			if !object.CreatedAt.Before(start) {
+				userUsage.AddedBytes += object.Bytes
+			}
+			if removed.Equal(end) {
+				userUsage.StoredBytes += object.Bytes
+				userUsage.ByKind[object.Kind] += object.Bytes
+			}
+		}
+
+		monthUsage := make([]monthlyUsage, 0, len(byUser))
+		for _, userUsage := range byUser {
+			monthUsage = append(monthUsage, *userUsage)
+		}
+		sort.Slice(monthUsage, func(i, j int) bool {
+			return monthUsage[i].UserID.String() < monthUsage[j].UserID.String()
+		})
+		usage = append(usage, monthUsage...)
+	}
+
+	return usage
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload size limits, enforced before any of the body is read. Videos are
+// held to the uploader's plan, or UPLOAD_LIMIT; this is its default.
+const (
+	videoUploadLimit     = 1 << 30
+	thumbnailUploadLimit = 10 << 20
+)
+
+// Upload handlers must reject a request before reading any of its body
+// wherever possible. The server only answers "Expect: 100-continue" when the
+// body is first read, so a client that sent it won't transfer an upload that
+// was going to be rejected anyway.
+
+// Function to wrap an upload handler with checks that can be made from the
+// request line and headers alone, so oversized or malformed uploads are
+// rejected before any of the body is streamed
+func validateUpload(limit int64, uuidParams []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check path parameters before looking at the body
+		details := []fieldError{}
+		for _, param := range uuidParams {
+			if _, err := uuid.Parse(r.PathValue(param)); err != nil {
+				details = append(details, fieldError{Field: param, Message: "must be a valid UUID"})
+			}
+		}
+		if len(details) > 0 {
+			respondWithErrorCode(w, errCodeValidationFailed, "Invalid path parameters", nil, details)
+			return
+		}
+
+		// Uploads must be multipart forms
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+			respondWithErrorCode(w, errCodeInvalidMediaType, "Request must be multipart/form-data", err, nil)
+			return
+		}
+
+		// Require the length up front so it can be checked against the limit,
+		// -1 means it's unknown (e.g. a chunked body)
+		if r.ContentLength < 0 {
+			respondWithErrorCode(w, errCodeLengthRequired, "Content-Length is required", nil, nil)
+			return
+		}
+		if r.ContentLength > limit {
+			respondWithErrorCode(w, errCodeFileTooLarge, fmt.Sprintf("Upload exceeds the %d byte limit", limit), nil, nil)
+			return
+		}
+
+		// The declared length could still be a lie, so cap what's actually read
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+		next(w, r)
+	}
+}
+
+// Function to hold an upload to the limit of the uploader's plan once they're
+// known, since before that it could only be checked against what any plan
+// could allow
+func limitUploadBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if r.ContentLength > limit {
+		respondWithErrorCode(w, errCodeFileTooLarge, fmt.Sprintf("Upload exceeds your plan's %d byte limit", limit), nil, nil)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// Function to extend the read deadline once an upload has been validated, so
+// the server's short read timeout only applies to requests still unverified
+func (cfg *apiConfig) beginUploadBody(w http.ResponseWriter, r *http.Request) {
+	if cfg.uploadTimeout <= 0 {
+		return
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(cfg.uploadTimeout)); err != nil {
+		log.Printf("Couldn't extend read deadline for upload to %s: %v", r.URL.Path, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Limits on a video's tags
+const (
+	maxVideoTags     = 30
+	maxTagCharacters = 50
+)
+
+// Function to normalize a tag, trimming it and lowercasing it so "Cats" and
+// " cats" are the same tag, and check it's one a video can have
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	switch {
+	case tag == "":
+		return "", fmt.Errorf("must not be empty")
+	case utf8.RuneCountInString(tag) > maxTagCharacters:
+		return "", fmt.Errorf("must be at most %d characters", maxTagCharacters)
+	case strings.IndexFunc(tag, unicode.IsControl) >= 0:
+		return "", fmt.Errorf("must not contain control characters")
+	}
+	return tag, nil
+}
+
+// Function to normalize the tags a client sent for a video, dropping
+// duplicates, returning what's wrong with them if they can't be used
+func normalizeTags(tags []string) ([]string, []fieldError) {
+	normalized := make([]string, 0, len(tags))
+	details := []fieldError{}
+	for i, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			details = append(details, fieldError{Field: fmt.Sprintf("tags[%d]", i), Message: err.Error()})
+			continue
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(details) == 0 && len(normalized) > maxVideoTags {
+		details = append(details, fieldError{Field: "tags", Message: fmt.Sprintf("must be at most %d tags", maxVideoTags)})
+	}
+	if len(details) > 0 {
+		return nil, details
+	}
+	return normalized, nil
+}
+
+// Function to parse the filters of a list of the user's videos: ?metadata=,
+// ?tag= for tags the videos must all have, ?visibility= and ?state=
+func parseVideoFilter(r *http.Request) (database.VideoFilter, error) {
+	metadata, err := parseMetadataFilters(r)
+	if err != nil {
+		return database.VideoFilter{}, err
+	}
+	filter := database.VideoFilter{Metadata: metadata}
+	for _, param := range r.URL.Query()["tag"] {
+		tag, err := normalizeTag(param)
+		if err != nil {
+			return database.VideoFilter{}, newRequestError(errCodeValidationFailed, fmt.Sprintf("Invalid tag filter %q, %v", param, err), nil)
+		}
+		filter.Tags = append(filter.Tags, tag)
+	}
+	if visibility := r.URL.Query().Get("visibility"); visibility != "" {
+		filter.Visibility = database.Visibility(visibility)
+		if !filter.Visibility.Valid() {
+			return database.VideoFilter{}, newRequestError(errCodeValidationFailed, fmt.Sprintf("Invalid visibility filter %q, must be public, unlisted or private", visibility), nil)
+		}
+	}
+	if state := r.URL.Query().Get("state"); state != "" {
+		filter.State = database.VideoState(state)
+		if !filter.State.Valid() {
+			return database.VideoFilter{}, newRequestError(errCodeValidationFailed, fmt.Sprintf("Invalid state filter %q, must be one of %v", state, database.VideoStates), nil)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Function to check a visibility a client sent, returning what's wrong with
+// it or nil
+func validateVisibility(visibility database.Visibility) []fieldError {
+	if visibility.Valid() {
+		return nil
+	}
+	return []fieldError{{Field: "visibility", Message: "must be public, unlisted or private"}}
+}
+
+// Function to report whether a user can see a video through the API. Only
+// owners see their private videos; uuid.Nil is an anonymous viewer.
+func videoVisibleTo(video database.Video, userID uuid.UUID) bool {
+	return video.Visibility != database.VisibilityPrivate || (userID != uuid.Nil && video.UserID == userID)
+}
+
+// Function to get the user a request is signed in as, if it has a valid JWT,
+// for endpoints that anyone can call but that show owners more
+func (cfg *apiConfig) optionalUserID(r *http.Request) uuid.UUID {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return uuid.Nil
+	}
+	return userID
+}
+
+// Function to get a video userID can see, responding with 404 if it doesn't
+// exist or is someone else's private video
+func (cfg *apiConfig) getViewableVideo(w http.ResponseWriter, videoID, userID uuid.UUID) (database.Video, bool) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || !videoVisibleTo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// Function to leave out the videos in a user's list that are now someone
+// else's private videos
+func visibleVideos(videos []database.Video, userID uuid.UUID) []database.Video {
+	visible := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		if videoVisibleTo(video, userID) {
+			visible = append(visible, video)
+		}
+	}
+	return visible
+}
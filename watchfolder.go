@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/uuid"
+)
+
+// Subdirectories of the watch folder files are moved to once they've been
+// ingested, or once ingesting them has failed
+const (
+	watchIngestedDir = "ingested"
+	watchFailedDir   = "failed"
+)
+
+// watchFolder ingests video files that appear in a local directory as
+// videos of one user, for screen recorders, cameras and other tools on the
+// same host that write files rather than calling the API. A file is picked
+// up once nothing has written to it for settle, so recordings still in
+// progress aren't ingested half finished.
+type watchFolder struct {
+	cfg     *apiConfig
+	dir     string
+	userID  uuid.UUID
+	settle  time.Duration
+	watcher *fsnotify.Watcher
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+	ready   chan string
+}
+
+// Function to start watching dir for files to ingest as the user with the given email
+func (cfg *apiConfig) newWatchFolder(dir, ownerEmail string, settle time.Duration) (*watchFolder, error) {
+	if ownerEmail == "" {
+		return nil, errors.New("WATCH_USER must be set to the email of the user the files belong to")
+	}
+	owner, err := cfg.db.GetUserByEmail(ownerEmail)
+	if err != nil {
+		return nil, err
+	}
+	if owner.ID == uuid.Nil {
+		return nil, fmt.Errorf("no user with email %s", ownerEmail)
+	}
+	for _, sub := range []string{watchIngestedDir, watchFailedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return &watchFolder{
+		cfg:     cfg,
+		dir:     dir,
+		userID:  owner.ID,
+		settle:  settle,
+		watcher: watcher,
+		pending: map[string]*time.Timer{},
+		ready:   make(chan string),
+	}, nil
+}
+
+// Run ingests files as they settle until ctx is done, starting with any
+// left in the folder while the server wasn't running.
+func (w *watchFolder) Run(ctx context.Context) {
+	defer w.watcher.Close()
+	log.Printf("Watching %s for videos\n", w.dir)
+
+	go w.ingestReady(ctx)
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		log.Printf("Couldn't list watch folder: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			w.schedule(ctx, entry.Name())
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Base(event.Name)
+			switch {
+			case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+				w.schedule(ctx, name)
+			case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+				w.cancel(name)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watch folder error: %v", err)
+		}
+	}
+}
+
+// Function to (re)start the countdown to ingesting a file, each write
+// pushing it back
+func (w *watchFolder) schedule(ctx context.Context, name string) {
+	if strings.HasPrefix(name, ".") || !isVideoFileName(name) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, ok := w.pending[name]; ok {
+		timer.Reset(w.settle)
+		return
+	}
+	w.pending[name] = time.AfterFunc(w.settle, func() {
+		w.mu.Lock()
+		delete(w.pending, name)
+		w.mu.Unlock()
+
+		select {
+		case w.ready <- name:
+		case <-ctx.Done():
+		}
+	})
+}
+
+func (w *watchFolder) cancel(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, ok := w.pending[name]; ok {
+		timer.Stop()
+		delete(w.pending, name)
+	}
+}
+
+// Function to ingest settled files one at a time, so a folder of recordings
+// doesn't start them all processing at once
+func (w *watchFolder) ingestReady(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case name := <-w.ready:
+			w.ingest(ctx, name)
+		}
+	}
+}
+
+func (w *watchFolder) ingest(ctx context.Context, name string) {
+	filePath := filepath.Join(w.dir, name)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		// It was moved or deleted while it settled
+		return
+	}
+	// Writes over network filesystems don't always raise events, so check
+	// the file really has stopped changing
+	if since := time.Since(info.ModTime()); since < w.settle {
+		w.schedule(ctx, name)
+		return
+	}
+
+	video, err := w.cfg.ingestFile(w.userID, name, filePath)
+	if err != nil {
+		log.Printf("Couldn't ingest %s from the watch folder: %v", name, err)
+		w.moveTo(watchFailedDir, name)
+		os.WriteFile(filepath.Join(w.dir, watchFailedDir, name+".error"), []byte(ingestFailureMessage(err)+"\n"), 0644)
+		return
+	}
+	log.Printf("Ingested %s from the watch folder as video %s", name, video.ID)
+	w.moveTo(watchIngestedDir, name)
+}
+
+// Function to move a file out of the folder so it isn't ingested again
+func (w *watchFolder) moveTo(sub, name string) {
+	if err := os.Rename(filepath.Join(w.dir, name), filepath.Join(w.dir, sub, name)); err != nil {
+		log.Printf("Couldn't move %s to %s: %v", name, sub, err)
+	}
+}